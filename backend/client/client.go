@@ -0,0 +1,299 @@
+// Package client is a Go client for the chat server's REST and WebSocket
+// API, meant for bots and integration tests. It handles sign-in, session
+// refresh and CSRF, and keeps a room WebSocket connected. Message payloads
+// are passed through untouched: encrypting and signing them is up to the
+// caller.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	authCookieName    = "e2ee-chat.auth"
+	refreshCookieName = "e2ee-chat.refresh"
+	csrfCookieName    = "e2ee-chat.csrf"
+
+	defaultRequestTimeout = 15 * time.Second
+)
+
+// AuthMode selects how requests carry the access token.
+type AuthMode int
+
+const (
+	// AuthCookies sends the session cookies and the CSRF header, the way
+	// the browser client does.
+	AuthCookies AuthMode = iota
+	// AuthBearer sends the access token as an Authorization header, which
+	// needs no CSRF token. The refresh cookie is still used to renew it.
+	AuthBearer
+)
+
+// Client talks to one server. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	jar        http.CookieJar
+	mode       AuthMode
+	deviceName string
+
+	refreshMu sync.Mutex
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient replaces the default HTTP client. Its cookie jar is
+// replaced so the session cookies stay with this Client.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		copied := *httpClient
+		c.httpClient = &copied
+	}
+}
+
+// WithAuthMode selects cookie or bearer authentication.
+func WithAuthMode(mode AuthMode) Option {
+	return func(c *Client) { c.mode = mode }
+}
+
+// WithDeviceName sets the device name the server records at sign-in.
+func WithDeviceName(name string) Option {
+	return func(c *Client) { c.deviceName = strings.TrimSpace(name) }
+}
+
+// New returns a client for the server at baseURL, e.g. "https://chat.example".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimRight(strings.TrimSpace(baseURL), "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parse base url: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("base url must be http or https, got %q", baseURL)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		baseURL:    parsed,
+		httpClient: &http.Client{Timeout: defaultRequestTimeout},
+		jar:        jar,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.httpClient.Jar = jar
+	return c, nil
+}
+
+// APIError is an error response from the server. Code is stable and safe to
+// branch on; Message is for people.
+type APIError struct {
+	Status    int            `json:"-"`
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"requestId,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s (%d %s, request %s)", e.Message, e.Status, e.Code, e.RequestID)
+	}
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.Status, e.Code)
+}
+
+// IsCode reports whether err is an APIError with the given code.
+func IsCode(err error, code string) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// User is the signed-in account.
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// Device is the device the session is bound to.
+type Device struct {
+	DeviceID       string `json:"deviceId"`
+	DeviceName     string `json:"deviceName"`
+	SessionVersion int    `json:"sessionVersion"`
+	LastSeenAt     string `json:"lastSeenAt"`
+}
+
+// Session is what sign-in returns.
+type Session struct {
+	User   User   `json:"user"`
+	Device Device `json:"device"`
+}
+
+// Login signs in and stores the session cookies on the client.
+func (c *Client) Login(ctx context.Context, username, password string) (*Session, error) {
+	var session Session
+	err := c.doOnce(ctx, http.MethodPost, "/api/login", map[string]string{
+		"username": username,
+		"password": password,
+	}, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Refresh rotates the refresh token and issues a new access token.
+func (c *Client) Refresh(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	return c.doOnce(ctx, http.MethodPost, "/api/refresh", nil, nil)
+}
+
+// Logout ends the session on the server and drops the local cookies.
+func (c *Client) Logout(ctx context.Context) error {
+	err := c.doOnce(ctx, http.MethodPost, "/api/logout", nil, nil)
+	if jar, ok := c.jar.(*cookiejar.Jar); ok {
+		expired := make([]*http.Cookie, 0, 3)
+		for _, name := range []string{authCookieName, refreshCookieName, csrfCookieName} {
+			expired = append(expired, &http.Cookie{Name: name, Path: "/", MaxAge: -1})
+		}
+		jar.SetCookies(c.baseURL, expired)
+	}
+	return err
+}
+
+// CodePolicyAcceptanceRequired is returned, with status 428, by every
+// authenticated endpoint until the user accepts the current policy version.
+const CodePolicyAcceptanceRequired = "policy_acceptance_required"
+
+// AcceptPolicy records that the user accepted the given policy version. The
+// version must be the one the server currently requires; it is in the
+// details of a CodePolicyAcceptanceRequired error.
+func (c *Client) AcceptPolicy(ctx context.Context, version int) error {
+	return c.Do(ctx, http.MethodPost, "/api/account/accept-policy", map[string]int{"version": version}, nil)
+}
+
+// Features returns the server's feature flags. It needs no session.
+func (c *Client) Features(ctx context.Context) (map[string]bool, error) {
+	var resp struct {
+		Features map[string]bool `json:"features"`
+	}
+	if err := c.doOnce(ctx, http.MethodGet, "/api/features", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Features, nil
+}
+
+// Do sends an authenticated request to path and decodes the JSON response
+// into out, which may be nil. An expired access token is refreshed once and
+// the request retried. It covers endpoints without a dedicated method.
+func (c *Client) Do(ctx context.Context, method, path string, body, out any) error {
+	err := c.doOnce(ctx, method, path, body, out)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized || c.cookie(refreshCookieName) == "" {
+		return err
+	}
+	if refreshErr := c.Refresh(ctx); refreshErr != nil {
+		return err
+	}
+	return c.doOnce(ctx, method, path, body, out)
+}
+
+func (c *Client) doOnce(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.resolve(path), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.deviceName != "" {
+		req.Header.Set("X-Device-Name", c.deviceName)
+	}
+	c.authorize(req.Header, method)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return decodeAPIError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// authorize adds the bearer token, or the CSRF header for cookie sessions.
+// The refresh endpoint always checks CSRF, so the header goes on every
+// mutating request regardless of mode.
+func (c *Client) authorize(header http.Header, method string) {
+	if c.mode == AuthBearer {
+		if token := c.cookie(authCookieName); token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	if csrf := c.cookie(csrfCookieName); csrf != "" {
+		header.Set("X-CSRF-Token", csrf)
+	}
+}
+
+func (c *Client) cookie(name string) string {
+	for _, cookie := range c.jar.Cookies(c.baseURL) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func (c *Client) resolve(path string) string {
+	return c.baseURL.String() + path
+}
+
+func decodeAPIError(resp *http.Response) error {
+	apiErr := &APIError{Status: resp.StatusCode}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(raw, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Code = "http_" + fmt.Sprint(resp.StatusCode)
+		apiErr.Message = strings.TrimSpace(string(raw))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	return apiErr
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeServer mimics the server's session cookies, CSRF check and access
+// token expiry closely enough to exercise the client.
+type fakeServer struct {
+	*httptest.Server
+	accessToken atomic.Value
+	refreshes   atomic.Int32
+}
+
+func newFakeServer(t *testing.T, extra func(mux *http.ServeMux, s *fakeServer)) *fakeServer {
+	t.Helper()
+	s := &fakeServer{}
+	s.accessToken.Store("access-1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/login", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Password != "password123" {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"code": "unauthorized", "message": "invalid credentials", "requestId": "req-1"})
+			return
+		}
+		s.setSession(w)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"user":   map[string]any{"id": 7, "username": req.Username, "role": "user"},
+			"device": map[string]any{"deviceId": "dev-1", "sessionVersion": 1},
+		})
+	})
+	mux.HandleFunc("/api/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if !s.validCSRF(r) {
+			writeJSON(w, http.StatusForbidden, map[string]any{"code": "forbidden", "message": "csrf token validation failed"})
+			return
+		}
+		s.refreshes.Add(1)
+		s.accessToken.Store("access-2")
+		s.setSession(w)
+		writeJSON(w, http.StatusOK, map[string]any{})
+	})
+	mux.HandleFunc("/api/rooms", func(w http.ResponseWriter, r *http.Request) {
+		source, ok := s.authenticate(r)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"code": "unauthorized", "message": "invalid token"})
+			return
+		}
+		if r.Method == http.MethodPost {
+			if source == "cookie" && !s.validCSRF(r) {
+				writeJSON(w, http.StatusForbidden, map[string]any{"code": "forbidden", "message": "csrf token validation failed"})
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]any{"room": map[string]any{"id": 3, "name": "ops"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"rooms": []map[string]any{{"id": 1, "name": "general"}}})
+	})
+	if extra != nil {
+		extra(mux, s)
+	}
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) setSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: authCookieName, Value: s.accessToken.Load().(string), Path: "/"})
+	http.SetCookie(w, &http.Cookie{Name: refreshCookieName, Value: "refresh", Path: "/"})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookieName, Value: "csrf", Path: "/"})
+}
+
+func (s *fakeServer) authenticate(r *http.Request) (string, bool) {
+	want := "Bearer " + s.accessToken.Load().(string)
+	if header := r.Header.Get("Authorization"); header != "" {
+		return "bearer", header == want
+	}
+	cookie, err := r.Cookie(authCookieName)
+	return "cookie", err == nil && cookie.Value == s.accessToken.Load().(string)
+}
+
+func (s *fakeServer) validCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookieName)
+	return err == nil && cookie.Value != "" && r.Header.Get("X-CSRF-Token") == cookie.Value
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func TestLoginAndRoomsInBothAuthModes(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeServer(t, nil)
+	for _, mode := range []AuthMode{AuthCookies, AuthBearer} {
+		c, err := New(server.URL, WithAuthMode(mode))
+		if err != nil {
+			t.Fatalf("new client: %v", err)
+		}
+		ctx := context.Background()
+		session, err := c.Login(ctx, "alice", "password123")
+		if err != nil || session.User.ID != 7 || session.Device.DeviceID != "dev-1" {
+			t.Fatalf("mode %d: login = %+v, %v", mode, session, err)
+		}
+		rooms, err := c.Rooms(ctx)
+		if err != nil || len(rooms) != 1 || rooms[0].Name != "general" {
+			t.Fatalf("mode %d: rooms = %+v, %v", mode, rooms, err)
+		}
+		room, err := c.CreateRoom(ctx, "ops")
+		if err != nil || room.ID != 3 {
+			t.Fatalf("mode %d: create room = %+v, %v", mode, room, err)
+		}
+	}
+}
+
+func TestLoginFailureDecodesErrorEnvelope(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeServer(t, nil)
+	c, err := New(server.URL)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	_, err = c.Login(context.Background(), "alice", "wrong-password")
+	var apiErr *APIError
+	if !IsCode(err, "unauthorized") || !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized || apiErr.RequestID != "req-1" {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+}
+
+func TestExpiredAccessTokenIsRefreshedOnce(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeServer(t, nil)
+	c, err := New(server.URL, WithAuthMode(AuthBearer))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := c.Login(ctx, "alice", "password123"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	// The server rotates its key; the token the client holds is now stale.
+	server.accessToken.Store("access-rotated")
+	server.refreshes.Store(0)
+	if _, err := c.Rooms(ctx); err != nil {
+		t.Fatalf("expected rooms after refresh, got %v", err)
+	}
+	if got := server.refreshes.Load(); got != 1 {
+		t.Fatalf("expected one refresh, got %d", got)
+	}
+}
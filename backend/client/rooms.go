@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Room is a room the signed-in user belongs to.
+type Room struct {
+	ID               int64  `json:"id"`
+	Name             string `json:"name"`
+	CreatedAt        string `json:"createdAt"`
+	AnnouncementOnly bool   `json:"announcementOnly,omitempty"`
+	// KeyEpoch counts membership changes; ciphertext tagged with an older
+	// epoch is refused once the server's grace period has passed.
+	KeyEpoch          int64  `json:"keyEpoch,omitempty"`
+	KeyEpochChangedAt string `json:"keyEpochChangedAt,omitempty"`
+}
+
+// Member is one member of a room.
+type Member struct {
+	ID                int64   `json:"id"`
+	Username          string  `json:"username"`
+	DisplayName       string  `json:"displayName,omitempty"`
+	Role              string  `json:"role"`
+	CreatedAt         string  `json:"createdAt"`
+	LastReadMessageID int64   `json:"lastReadMessageId"`
+	LastSeenAt        *string `json:"lastSeenAt,omitempty"`
+}
+
+// Message is one stored message. Payload is the encrypted payload as the
+// sender uploaded it.
+type Message struct {
+	Kind              string          `json:"kind"`
+	ID                int64           `json:"id"`
+	RoomID            int64           `json:"roomId"`
+	Seq               int64           `json:"seq"`
+	SenderID          int64           `json:"senderId"`
+	SenderUsername    string          `json:"senderUsername"`
+	SenderDisplayName string          `json:"senderDisplayName,omitempty"`
+	CreatedAt         string          `json:"createdAt"`
+	Revision          int             `json:"revision"`
+	EditedAt          *string         `json:"editedAt,omitempty"`
+	RevokedAt         *string         `json:"revokedAt,omitempty"`
+	Mentions          []int64         `json:"mentions,omitempty"`
+	Payload           json.RawMessage `json:"payload"`
+}
+
+// MessagePage is one page of room history, oldest first.
+type MessagePage struct {
+	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"hasMore"`
+}
+
+// HistoryOptions pages through history. Zero values use server defaults.
+type HistoryOptions struct {
+	Limit    int
+	BeforeID int64
+	AfterID  int64
+}
+
+func (c *Client) Rooms(ctx context.Context) ([]Room, error) {
+	var resp struct {
+		Rooms []Room `json:"rooms"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/api/rooms", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Rooms, nil
+}
+
+func (c *Client) CreateRoom(ctx context.Context, name string) (*Room, error) {
+	var resp struct {
+		Room Room `json:"room"`
+	}
+	if err := c.Do(ctx, http.MethodPost, "/api/rooms", map[string]string{"name": name}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Room, nil
+}
+
+// JoinRoom joins a public room directly.
+func (c *Client) JoinRoom(ctx context.Context, roomID int64) error {
+	return c.Do(ctx, http.MethodPost, roomPath(roomID, "join"), nil, nil)
+}
+
+// JoinInvite redeems an invite token and returns the room it opens.
+func (c *Client) JoinInvite(ctx context.Context, inviteToken string) (*Room, error) {
+	var resp struct {
+		Room Room `json:"room"`
+	}
+	if err := c.Do(ctx, http.MethodPost, "/api/invites/join", map[string]string{"inviteToken": inviteToken}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Room, nil
+}
+
+func (c *Client) Members(ctx context.Context, roomID int64) ([]Member, error) {
+	var resp struct {
+		Members []Member `json:"members"`
+	}
+	if err := c.Do(ctx, http.MethodGet, roomPath(roomID, "members"), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Members, nil
+}
+
+func (c *Client) Messages(ctx context.Context, roomID int64, opts HistoryOptions) (*MessagePage, error) {
+	query := url.Values{}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.BeforeID > 0 {
+		query.Set("beforeId", strconv.FormatInt(opts.BeforeID, 10))
+	}
+	if opts.AfterID > 0 {
+		query.Set("afterId", strconv.FormatInt(opts.AfterID, 10))
+	}
+	path := roomPath(roomID, "messages")
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var page MessagePage
+	if err := c.Do(ctx, http.MethodGet, path, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func roomPath(roomID int64, action string) string {
+	return fmt.Sprintf("/api/rooms/%d/%s", roomID, action)
+}
@@ -0,0 +1,375 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsSubprotocolJSON = "e2ee-chat.v1.json"
+
+	// Close codes the server uses when the session itself is over, so
+	// reconnecting would only fail again.
+	closeAccountRemoved = 4003
+	closeSessionRevoked = 4004
+
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+	wsWriteTimeout    = 10 * time.Second
+)
+
+// ErrSessionEnded is returned by RoomConn.Run when the server closed the
+// connection because the account or device session was revoked.
+var ErrSessionEnded = errors.New("client: session ended by server")
+
+// Event is any frame the server sent. Raw holds the whole frame.
+type Event struct {
+	Type   string          `json:"type"`
+	RoomID int64           `json:"roomId"`
+	Raw    json.RawMessage `json:"-"`
+}
+
+// CiphertextEvent is a new message in the room.
+type CiphertextEvent struct {
+	ID                int64           `json:"id"`
+	RoomID            int64           `json:"roomId"`
+	Seq               int64           `json:"seq"`
+	SenderID          int64           `json:"senderId"`
+	SenderUsername    string          `json:"senderUsername"`
+	SenderDisplayName string          `json:"senderDisplayName,omitempty"`
+	CreatedAt         string          `json:"createdAt"`
+	Mentions          []int64         `json:"mentions"`
+	Payload           json.RawMessage `json:"payload"`
+}
+
+type PresenceEvent struct {
+	RoomID   int64  `json:"roomId"`
+	UserID   int64  `json:"userId"`
+	Username string `json:"username"`
+	Status   string `json:"status"`
+}
+
+type TypingEvent struct {
+	RoomID       int64  `json:"roomId"`
+	FromUserID   int64  `json:"fromUserId"`
+	FromUsername string `json:"fromUsername"`
+	IsTyping     bool   `json:"isTyping"`
+}
+
+// Peer is one connected device in the room, as listed in room_peers.
+type Peer struct {
+	UserID              int64           `json:"userId"`
+	Username            string          `json:"username"`
+	DisplayName         string          `json:"displayName,omitempty"`
+	Presence            string          `json:"presence,omitempty"`
+	DeviceID            string          `json:"deviceId"`
+	DeviceName          string          `json:"deviceName,omitempty"`
+	PublicKeyJWK        json.RawMessage `json:"publicKeyJwk"`
+	SigningPublicKeyJWK json.RawMessage `json:"signingPublicKeyJwk,omitempty"`
+}
+
+type PeersEvent struct {
+	RoomID int64  `json:"roomId"`
+	Peers  []Peer `json:"peers"`
+}
+
+type ProtocolErrorEvent struct {
+	RoomID  int64  `json:"roomId"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type MaintenanceEvent struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+	Since   string `json:"since,omitempty"`
+}
+
+// AnnouncementEvent is a system announcement an administrator broadcast.
+// Announcements missed while offline are returned by the sync endpoint.
+type AnnouncementEvent struct {
+	ID        int64  `json:"id"`
+	Message   string `json:"message"`
+	Severity  string `json:"severity"`
+	Audience  string `json:"audience"`
+	RoomID    int64  `json:"roomId,omitempty"`
+	Role      string `json:"role,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// Handlers are the callbacks of a RoomConn. Every field is optional. They
+// run on the connection's read goroutine, one at a time, so a slow handler
+// delays the frames after it.
+type Handlers struct {
+	// OnEvent sees every frame, including the typed ones below.
+	OnEvent         func(Event)
+	OnCiphertext    func(CiphertextEvent)
+	OnPresence      func(PresenceEvent)
+	OnTyping        func(TypingEvent)
+	OnPeers         func(PeersEvent)
+	OnProtocolError func(ProtocolErrorEvent)
+	OnMaintenance   func(MaintenanceEvent)
+	OnAnnouncement  func(AnnouncementEvent)
+	// OnConnect runs after every successful dial, OnDisconnect after every
+	// lost connection with the error that ended it.
+	OnConnect    func()
+	OnDisconnect func(error)
+}
+
+// RoomConn is a WebSocket connection to one room that reconnects until its
+// context ends or the server ends the session.
+type RoomConn struct {
+	client   *Client
+	roomID   int64
+	handlers Handlers
+	dialer   websocket.Dialer
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+	// resumeAfter is the delay a server_restarting frame asked for.
+	resumeAfter time.Duration
+}
+
+// Room returns a connection to roomID. Nothing is dialled until Run.
+func (c *Client) Room(roomID int64, handlers Handlers) *RoomConn {
+	return &RoomConn{
+		client:   c,
+		roomID:   roomID,
+		handlers: handlers,
+		dialer: websocket.Dialer{
+			HandshakeTimeout: defaultRequestTimeout,
+			Subprotocols:     []string{wsSubprotocolJSON},
+			Jar:              c.jar,
+		},
+	}
+}
+
+// Run keeps the connection up until ctx is done, returning ctx's error, or
+// until the session ends, returning ErrSessionEnded.
+func (rc *RoomConn) Run(ctx context.Context) error {
+	attempt := 0
+	for {
+		conn, err := rc.dial(ctx)
+		if err == nil {
+			attempt = 0
+			err = rc.serve(ctx, conn)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrSessionEnded) {
+			rc.disconnected(err)
+			return err
+		}
+		rc.disconnected(err)
+
+		delay := rc.takeResumeDelay()
+		if delay == 0 {
+			delay = reconnectDelay(attempt)
+			attempt++
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Send writes one frame, marshalled as JSON. It fails while disconnected.
+func (rc *RoomConn) Send(frame any) error {
+	encoded, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.conn == nil {
+		return errors.New("client: room connection is not open")
+	}
+	_ = rc.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return rc.conn.WriteMessage(websocket.TextMessage, encoded)
+}
+
+func (rc *RoomConn) SetTyping(isTyping bool) error {
+	return rc.Send(map[string]any{"type": "typing_status", "isTyping": isTyping})
+}
+
+// SetPresence sets the status other members see: online, away or dnd.
+func (rc *RoomConn) SetPresence(status string) error {
+	return rc.Send(map[string]any{"type": "presence_update", "status": status})
+}
+
+// MarkRead sends a read receipt covering every message up to messageID.
+func (rc *RoomConn) MarkRead(messageID int64) error {
+	return rc.Send(map[string]any{"type": "read_receipt", "upToMessageId": messageID})
+}
+
+func (rc *RoomConn) dial(ctx context.Context) (*websocket.Conn, error) {
+	conn, resp, err := rc.dialOnce(ctx)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusUnauthorized && rc.client.cookie(refreshCookieName) != "" {
+		if refreshErr := rc.client.Refresh(ctx); refreshErr != nil {
+			if isSessionError(refreshErr) {
+				return nil, fmt.Errorf("%w: %v", ErrSessionEnded, refreshErr)
+			}
+			return nil, refreshErr
+		}
+		conn, resp, err = rc.dialOnce(ctx)
+	}
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("%w: %v", ErrSessionEnded, err)
+		}
+		return nil, err
+	}
+	rc.mu.Lock()
+	rc.conn = conn
+	rc.mu.Unlock()
+	if rc.handlers.OnConnect != nil {
+		rc.handlers.OnConnect()
+	}
+	return conn, nil
+}
+
+func (rc *RoomConn) dialOnce(ctx context.Context) (*websocket.Conn, *http.Response, error) {
+	wsURL := *rc.client.baseURL
+	wsURL.Scheme = "ws"
+	if rc.client.baseURL.Scheme == "https" {
+		wsURL.Scheme = "wss"
+	}
+	wsURL.Path += "/ws"
+	wsURL.RawQuery = "room_id=" + strconv.FormatInt(rc.roomID, 10)
+
+	header := http.Header{}
+	if rc.client.mode == AuthBearer {
+		if token := rc.client.cookie(authCookieName); token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	conn, resp, err := rc.dialer.DialContext(ctx, wsURL.String(), header)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode >= http.StatusBadRequest {
+				return nil, resp, decodeAPIError(resp)
+			}
+		}
+		return nil, resp, err
+	}
+	return conn, resp, nil
+}
+
+// serve reads frames until the connection drops.
+func (rc *RoomConn) serve(ctx context.Context, conn *websocket.Conn) error {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	defer func() {
+		rc.mu.Lock()
+		rc.conn = nil
+		rc.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && (closeErr.Code == closeAccountRemoved || closeErr.Code == closeSessionRevoked) {
+				return fmt.Errorf("%w: %s", ErrSessionEnded, closeErr.Text)
+			}
+			return err
+		}
+		rc.dispatch(raw)
+	}
+}
+
+func (rc *RoomConn) dispatch(raw []byte) {
+	var event Event
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return
+	}
+	event.Raw = raw
+	if rc.handlers.OnEvent != nil {
+		rc.handlers.OnEvent(event)
+	}
+	switch event.Type {
+	case "ciphertext":
+		deliver(raw, rc.handlers.OnCiphertext)
+	case "presence":
+		deliver(raw, rc.handlers.OnPresence)
+	case "typing_status":
+		deliver(raw, rc.handlers.OnTyping)
+	case "room_peers":
+		deliver(raw, rc.handlers.OnPeers)
+	case "protocol_error":
+		deliver(raw, rc.handlers.OnProtocolError)
+	case "maintenance":
+		deliver(raw, rc.handlers.OnMaintenance)
+	case "system_announcement":
+		deliver(raw, rc.handlers.OnAnnouncement)
+	case "server_restarting":
+		var frame struct {
+			ReconnectAfterMs int64 `json:"reconnectAfterMs"`
+		}
+		if json.Unmarshal(raw, &frame) == nil {
+			rc.mu.Lock()
+			rc.resumeAfter = time.Duration(frame.ReconnectAfterMs)*time.Millisecond + minReconnectDelay
+			rc.mu.Unlock()
+		}
+	}
+}
+
+func deliver[T any](raw []byte, handler func(T)) {
+	if handler == nil {
+		return
+	}
+	var event T
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return
+	}
+	handler(event)
+}
+
+func (rc *RoomConn) disconnected(err error) {
+	if rc.handlers.OnDisconnect != nil {
+		rc.handlers.OnDisconnect(err)
+	}
+}
+
+func (rc *RoomConn) takeResumeDelay() time.Duration {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delay := rc.resumeAfter
+	rc.resumeAfter = 0
+	return delay
+}
+
+// reconnectDelay doubles from minReconnectDelay up to maxReconnectDelay, with
+// jitter so a server restart does not bring every bot back at once.
+func reconnectDelay(attempt int) time.Duration {
+	delay := minReconnectDelay
+	for i := 0; i < attempt && delay < maxReconnectDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxReconnectDelay {
+		delay = maxReconnectDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func isSessionError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusForbidden)
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRoomConnDispatchesTypedEventsAndReconnects(t *testing.T) {
+	t.Parallel()
+
+	var dials atomic.Int32
+	upgrader := websocket.Upgrader{Subprotocols: []string{wsSubprotocolJSON}}
+	server := newFakeServer(t, func(mux *http.ServeMux, s *fakeServer) {
+		mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := s.authenticate(r); !ok || r.URL.Query().Get("room_id") != "5" {
+				writeJSON(w, http.StatusUnauthorized, map[string]any{"code": "unauthorized", "message": "invalid token"})
+				return
+			}
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if dials.Add(1) == 1 {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"room_peers","roomId":5,"peers":[{"userId":7,"deviceId":"dev-1"}]}`))
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"server_restarting","reconnectAfterMs":0}`))
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "restart"))
+				return
+			}
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ciphertext","id":11,"roomId":5,"seq":2,"senderId":8,"payload":{"version":3}}`))
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeSessionRevoked, "device revoked"))
+		})
+	})
+
+	c, err := New(server.URL)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := c.Login(ctx, "alice", "password123"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	var peers []Peer
+	var message CiphertextEvent
+	var events []string
+	err = c.Room(5, Handlers{
+		OnEvent:      func(e Event) { events = append(events, e.Type) },
+		OnPeers:      func(e PeersEvent) { peers = e.Peers },
+		OnCiphertext: func(e CiphertextEvent) { message = e },
+	}).Run(ctx)
+
+	if !errors.Is(err, ErrSessionEnded) {
+		t.Fatalf("expected session end after revoke close, got %v", err)
+	}
+	if dials.Load() != 2 {
+		t.Fatalf("expected a reconnect after the restart notice, got %d dials", dials.Load())
+	}
+	if len(peers) != 1 || peers[0].DeviceID != "dev-1" {
+		t.Fatalf("unexpected peers: %+v", peers)
+	}
+	if message.ID != 11 || message.Seq != 2 || !strings.Contains(string(message.Payload), `"version":3`) {
+		t.Fatalf("unexpected message: %+v", message)
+	}
+	if strings.Join(events, ",") != "room_peers,server_restarting,ciphertext" {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
+
+func TestReconnectDelayStaysWithinBounds(t *testing.T) {
+	t.Parallel()
+
+	for attempt := 0; attempt < 12; attempt++ {
+		delay := reconnectDelay(attempt)
+		if delay < minReconnectDelay/2 || delay > maxReconnectDelay {
+			t.Fatalf("attempt %d: delay %v out of bounds", attempt, delay)
+		}
+	}
+}
@@ -1,7 +1,14 @@
 package main
 
-import "e2ee-chat/backend/internal/server"
+import (
+	"os"
+
+	"e2ee-chat/backend/internal/server"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "message-backup" {
+		os.Exit(server.RunMessageBackup(os.Args[2:]))
+	}
 	server.Run()
 }
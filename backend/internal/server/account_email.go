@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// onboardingTokenTTL bounds how long the first-login link of an account
+// created by an admin stays valid.
+const onboardingTokenTTL = 72 * time.Hour
+
+// generateLinkToken returns a single-use token for an emailed link and the
+// hash it is stored under.
+func generateLinkToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashLinkToken(token), nil
+}
+
+func hashLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// unusablePasswordHash locks an account until its owner picks a password
+// through the onboarding link.
+func unusablePasswordHash() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(base64.RawURLEncoding.EncodeToString(raw)), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// tokenLink points the web client's page at path, carrying token.
+func (a *App) tokenLink(path, token string) string {
+	return strings.TrimRight(a.appBaseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// queueOnboardingEmail issues a first-login token for a freshly created
+// account and queues the mail carrying it, inside the creating transaction.
+func (a *App) queueOnboardingEmail(ctx context.Context, tx *sql.Tx, userID, adminID int64, username string) error {
+	token, tokenHash, err := generateLinkToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(onboardingTokenTTL)
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO account_onboarding_tokens(user_id, token_hash, created_by, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id) DO UPDATE
+SET token_hash = EXCLUDED.token_hash, created_by = EXCLUDED.created_by, expires_at = EXCLUDED.expires_at
+`, userID, tokenHash, adminID, expiresAt); err != nil {
+		return err
+	}
+	return enqueueUserEmail(ctx, tx, userID, emailTemplateOnboarding, map[string]any{
+		"Username":  username,
+		"Link":      a.tokenLink("/onboarding", token),
+		"ExpiresAt": expiresAt.UTC().Format(time.RFC1123),
+	})
+}
+
+// handleAccountEmail reads (GET) or sets (PUT) the address security
+// notifications go to. An empty email removes it.
+func (a *App) handleAccountEmail(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		var email sql.NullString
+		if err := a.db.QueryRowContext(ctx, `SELECT email FROM users WHERE id = $1`, auth.UserID).Scan(&email); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to load email")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"email": email.String, "notificationsEnabled": a.mail != nil})
+	case http.MethodPut:
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		var email any
+		if strings.TrimSpace(req.Email) != "" {
+			normalized, ok := normalizeEmail(req.Email)
+			if !ok {
+				respondError(w, http.StatusBadRequest, "invalid email address")
+				return
+			}
+			email = normalized
+		}
+		if _, err := a.db.ExecContext(ctx, `UPDATE users SET email = $2 WHERE id = $1`, auth.UserID, email); err != nil {
+			if isUniqueViolation(err) {
+				respondErrorCode(w, http.StatusConflict, "email_taken", "email is already in use")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to update email")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"email": strings.TrimSpace(req.Email)})
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAccountPassword changes the caller's password after checking the
+// current one.
+func (a *App) handleAccountPassword(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if len(req.NewPassword) < 8 || len(req.NewPassword) > 128 {
+		respondError(w, http.StatusBadRequest, "password length must be between 8 and 128")
+		return
+	}
+	if auth.Username == a.adminUsername {
+		// ADMIN_PASSWORD_HASH is written back on every start.
+		respondError(w, http.StatusBadRequest, "change ADMIN_PASSWORD_HASH to change this password")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var hash string
+	if err := a.db.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE id = $1`, auth.UserID).Scan(&hash); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load account")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.CurrentPassword)); err != nil {
+		respondError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to hash password")
+		return
+	}
+	if _, err := a.db.ExecContext(ctx, `UPDATE users SET password_hash = $2 WHERE id = $1`, auth.UserID, string(newHash)); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to change password")
+		return
+	}
+	requestLogger(r.Context()).Info("password_changed", "user_id", auth.UserID)
+	a.notifyUserByEmail(ctx, auth.UserID, auth.Username, emailTemplatePasswordChanged, nil)
+	respondJSON(w, http.StatusOK, map[string]any{"changed": true})
+}
+
+// handleAccountOnboarding redeems a first-login link: the token sets the
+// account's initial password and is consumed.
+func (a *App) handleAccountOnboarding(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	clientKey := clientKeyFromRequest(r, a.trustProxyHeaders)
+	if allowed, retryAfter := a.loginIPLimiter.Check(clientKey); !allowed {
+		respondRateLimitedAfter(w, "too many attempts", retryAfter)
+		return
+	}
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if len(req.Password) < 8 || len(req.Password) > 128 {
+		respondError(w, http.StatusBadRequest, "password length must be between 8 and 128")
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to hash password")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to set password")
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var username string
+	err = tx.QueryRowContext(ctx, `
+DELETE FROM account_onboarding_tokens
+WHERE token_hash = $1 AND expires_at > NOW()
+RETURNING user_id
+`, hashLinkToken(strings.TrimSpace(req.Token))).Scan(&userID)
+	if err == nil {
+		err = tx.QueryRowContext(ctx,
+			`UPDATE users SET password_hash = $2 WHERE id = $1 AND deleted_at IS NULL RETURNING username`,
+			userID, string(hash),
+		).Scan(&username)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondErrorCode(w, http.StatusBadRequest, "invalid_onboarding_token", "invalid or expired link")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to set password")
+		return
+	}
+	requestLogger(r.Context()).Info("account_onboarded", "user_id", userID)
+	respondJSON(w, http.StatusOK, map[string]any{"username": username})
+}
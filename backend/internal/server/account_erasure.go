@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultAccountErasureGraceDays = 14
+	accountErasureInterval         = 15 * time.Minute
+	accountErasureTimeout          = time.Minute
+	accountErasureBatch            = 50
+)
+
+func (a *App) accountErasureGrace() time.Duration {
+	if a.accountErasureGracePeriod <= 0 {
+		return defaultAccountErasureGraceDays * 24 * time.Hour
+	}
+	return a.accountErasureGracePeriod
+}
+
+// handleAccountDelete serves /api/account/delete. POST schedules erasure of
+// the caller's account after the grace period, re-checking the password;
+// DELETE cancels a scheduled erasure and GET reports it. The account keeps
+// working until the erasure job runs, so a user who changes their mind
+// during the grace period signs in and cancels.
+func (a *App) handleAccountDelete(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		var requestedAt, scheduledAt sql.NullTime
+		if err := a.db.QueryRowContext(ctx,
+			`SELECT erasure_requested_at, erasure_scheduled_at FROM users WHERE id = $1`,
+			auth.UserID,
+		).Scan(&requestedAt, &scheduledAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to load account")
+			return
+		}
+		respondJSON(w, http.StatusOK, accountErasureStatus(requestedAt, scheduledAt))
+
+	case http.MethodPost:
+		var req struct {
+			Password string `json:"password"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		if auth.Role == "admin" || a.isConfiguredAdmin(auth.Username) {
+			respondError(w, http.StatusForbidden, "admin accounts cannot be erased")
+			return
+		}
+
+		var hash string
+		if err := a.db.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE id = $1`, auth.UserID).Scan(&hash); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to load account")
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)); err != nil {
+			respondError(w, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+
+		var requestedAt, scheduledAt sql.NullTime
+		err := a.db.QueryRowContext(ctx, `
+UPDATE users
+SET erasure_requested_at = NOW(), erasure_scheduled_at = NOW() + $2 * INTERVAL '1 second'
+WHERE id = $1 AND erasure_scheduled_at IS NULL
+RETURNING erasure_requested_at, erasure_scheduled_at
+`, auth.UserID, int64(a.accountErasureGrace()/time.Second)).Scan(&requestedAt, &scheduledAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondErrorCode(w, http.StatusConflict, "erasure_already_scheduled", "account erasure is already scheduled")
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to schedule erasure")
+			return
+		}
+		requestLogger(r.Context()).Warn("account_erasure_scheduled",
+			"user_id", auth.UserID,
+			"scheduled_at", scheduledAt.Time.UTC().Format(time.RFC3339Nano),
+		)
+		a.notifyUserByEmail(ctx, auth.UserID, auth.Username, emailTemplateErasureScheduled, map[string]any{
+			"ScheduledAt": scheduledAt.Time.UTC().Format(time.RFC1123),
+		})
+		respondJSON(w, http.StatusAccepted, accountErasureStatus(requestedAt, scheduledAt))
+
+	case http.MethodDelete:
+		result, err := a.db.ExecContext(ctx, `
+UPDATE users
+SET erasure_requested_at = NULL, erasure_scheduled_at = NULL
+WHERE id = $1 AND erasure_scheduled_at IS NOT NULL
+`, auth.UserID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to cancel erasure")
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			respondError(w, http.StatusNotFound, "no erasure is scheduled")
+			return
+		}
+		requestLogger(r.Context()).Info("account_erasure_cancelled", "user_id", auth.UserID)
+		respondJSON(w, http.StatusOK, map[string]any{"scheduled": false})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func accountErasureStatus(requestedAt, scheduledAt sql.NullTime) map[string]any {
+	status := map[string]any{"scheduled": scheduledAt.Valid}
+	if requestedAt.Valid {
+		status["requestedAt"] = requestedAt.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if scheduledAt.Valid {
+		status["scheduledAt"] = scheduledAt.Time.UTC().Format(time.RFC3339Nano)
+	}
+	return status
+}
+
+// erasableMessagesQuery selects the messages erasure strips: everything the
+// user sent outside rooms under legal hold.
+const erasableMessagesQuery = `SELECT m.id FROM messages m JOIN rooms r ON r.id = m.room_id
+WHERE m.sender_id = $1 AND r.legal_hold_at IS NULL`
+
+// eraseAccountTx tombstones the account, then removes what tombstoning
+// keeps: contact details, devices with all their key material, the key
+// backup, blocks and verifications. Messages the user sent stay as revoked
+// rows with their ciphertext, edit history and event payloads dropped, so
+// threads and read cursors of other members still line up; messages in rooms
+// under legal hold are left untouched. It returns the rooms the user left.
+func eraseAccountTx(ctx context.Context, tx *sql.Tx, userID int64) ([]int64, error) {
+	roomIDs, err := tombstoneUserTx(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := releaseMessageQuotaTx(ctx, tx, `m.id IN (`+erasableMessagesQuery+`)`, userID); err != nil {
+		return nil, err
+	}
+	statements := []string{
+		`UPDATE users
+SET email = NULL, erased_at = NOW(), erasure_scheduled_at = NULL,
+    accepted_policy_version = 0, policy_accepted_at = NULL
+WHERE id = $1`,
+		`DELETE FROM message_revisions WHERE message_id IN (` + erasableMessagesQuery + `)`,
+		`UPDATE events SET payload = NULL
+WHERE payload IS NOT NULL AND message_id IN (` + erasableMessagesQuery + `)`,
+		`INSERT INTO events(room_id, message_id, actor_id, event_type)
+SELECT room_id, id, $1, '` + eventMessageRevoked + `' FROM messages
+WHERE id IN (` + erasableMessagesQuery + `)`,
+		`UPDATE messages
+SET payload = '{}'::jsonb, edited_at = NULL, revision = revision + 1,
+    revoked_at = COALESCE(revoked_at, NOW()), revoked_by = COALESCE(revoked_by, $1)
+WHERE id IN (` + erasableMessagesQuery + `)`,
+		// Device identity keys and prekeys cascade from their device.
+		`DELETE FROM user_devices WHERE user_id = $1`,
+		`DELETE FROM account_key_backups WHERE user_id = $1`,
+		`DELETE FROM user_blocks WHERE blocker_id = $1 OR blocked_id = $1`,
+		`DELETE FROM identity_verifications WHERE verifier_id = $1 OR target_user_id = $1`,
+		`DELETE FROM email_outbox WHERE user_id = $1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, userID); err != nil {
+			return nil, err
+		}
+	}
+	return roomIDs, nil
+}
+
+// accountErasureJob erases accounts whose grace period has ended. Accounts
+// under legal hold are skipped until the hold is released.
+type accountErasureJob struct {
+	app *App
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newAccountErasureJob(app *App) *accountErasureJob {
+	return &accountErasureJob{
+		app:  app,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (j *accountErasureJob) Start() {
+	go j.run()
+}
+
+func (j *accountErasureJob) Stop() {
+	if j == nil {
+		return
+	}
+	j.stopOnce.Do(func() {
+		close(j.stop)
+	})
+	<-j.done
+}
+
+func (j *accountErasureJob) run() {
+	defer close(j.done)
+	ticker := time.NewTicker(accountErasureInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), accountErasureTimeout)
+		erased, err := j.Run(ctx)
+		cancel()
+		if err != nil {
+			logger.Warn("account_erasure_failed", "error", err)
+		} else if erased > 0 {
+			logger.Info("account_erasure_completed", "accounts_erased", erased)
+		}
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run erases one batch of due accounts, each in its own transaction, and
+// returns how many it erased. An account that fails is logged and retried on
+// the next run without holding up the rest of the batch.
+func (j *accountErasureJob) Run(ctx context.Context) (int, error) {
+	a := j.app
+	rows, err := a.db.QueryContext(ctx, `
+SELECT id FROM users
+WHERE erasure_scheduled_at <= NOW() AND deleted_at IS NULL AND legal_hold_at IS NULL
+ORDER BY erasure_scheduled_at
+LIMIT $1
+`, accountErasureBatch)
+	if err != nil {
+		return 0, err
+	}
+	userIDs := make([]int64, 0, accountErasureBatch)
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	erased := 0
+	for _, userID := range userIDs {
+		roomIDs, err := a.eraseAccount(ctx, userID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return erased, err
+			}
+			logger.Warn("account_erasure_user_failed", "user_id", userID, "error", err)
+			continue
+		}
+		a.membership.InvalidateUser(userID)
+		a.hub.KickUser(userID, 4003, "account deleted")
+		for _, roomID := range roomIDs {
+			a.announceRoomKeyEpoch(ctx, roomID)
+		}
+		logger.Warn("account_erased", "user_id", userID, "rooms_left", len(roomIDs))
+		erased++
+	}
+	return erased, nil
+}
+
+func (a *App) eraseAccount(ctx context.Context, userID int64) ([]int64, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	roomIDs, err := eraseAccountTx(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return roomIDs, tx.Commit()
+}
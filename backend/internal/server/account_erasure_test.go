@@ -0,0 +1,60 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccountErasureStatus(t *testing.T) {
+	t.Parallel()
+
+	if status := accountErasureStatus(sql.NullTime{}, sql.NullTime{}); status["scheduled"] != false || len(status) != 1 {
+		t.Fatalf("unexpected idle status %v", status)
+	}
+	requested := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	status := accountErasureStatus(sql.NullTime{Time: requested, Valid: true}, sql.NullTime{Time: requested.Add(14 * 24 * time.Hour), Valid: true})
+	if status["scheduled"] != true || status["scheduledAt"] != "2026-10-15T12:00:00Z" || status["requestedAt"] != "2026-10-01T12:00:00Z" {
+		t.Fatalf("unexpected scheduled status %v", status)
+	}
+}
+
+func TestAccountDeleteRefusesAdmins(t *testing.T) {
+	t.Parallel()
+
+	app := &App{adminUsername: "root"}
+	for _, auth := range []AuthContext{
+		{UserID: 1, Username: "root", Role: "user"},
+		{UserID: 2, Username: "ops", Role: "admin"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/account/delete", strings.NewReader(`{"password":"hunter22"}`))
+		rec := httptest.NewRecorder()
+		app.handleAccountDelete(rec, req, auth)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403, got %d: %s", auth.Username, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestAccountExportSections(t *testing.T) {
+	t.Parallel()
+
+	seen := make(map[string]bool, len(accountExportSections))
+	for _, section := range accountExportSections {
+		if seen[section.name] || section.name == "exportedAt" {
+			t.Fatalf("duplicate export field %q", section.name)
+		}
+		seen[section.name] = true
+		if !strings.Contains(section.query, "$1") {
+			t.Fatalf("section %q is not scoped to the user", section.name)
+		}
+	}
+	for _, name := range []string{"account", "devices", "memberships", "messages", "identityKeys", "keyBackup"} {
+		if !seen[name] {
+			t.Fatalf("expected export section %q", name)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	accountExportTimeout    = 2 * time.Minute
+	accountExportFlushEvery = 200
+)
+
+// accountExportSection is one top-level field of the export. Each query takes
+// the user ID as $1 and yields one JSON value per row, built by Postgres so
+// rows go straight to the response. Object sections write their single row,
+// or null when there is none.
+type accountExportSection struct {
+	name   string
+	object bool
+	query  string
+}
+
+var accountExportSections = []accountExportSection{
+	{name: "account", object: true, query: `
+SELECT json_build_object(
+    'id', id, 'username', username, 'displayName', display_name, 'bio', bio, 'email', email,
+    'role', role, 'customRole', custom_role, 'createdAt', created_at, 'lastSeenAt', last_seen_at,
+    'hideLastSeen', hide_last_seen, 'acceptedPolicyVersion', accepted_policy_version,
+    'policyAcceptedAt', policy_accepted_at, 'erasureScheduledAt', erasure_scheduled_at)
+FROM users WHERE id = $1`},
+	{name: "devices", query: `
+SELECT json_build_object(
+    'deviceId', device_id, 'deviceName', device_name, 'createdAt', created_at,
+    'lastSeenAt', last_seen_at, 'revokedAt', revoked_at)
+FROM user_devices WHERE user_id = $1 ORDER BY created_at, device_id`},
+	{name: "memberships", query: `
+SELECT json_build_object(
+    'roomId', rm.room_id, 'roomName', r.name, 'joinedAt', rm.joined_at,
+    'lastReadMessageId', rm.last_read_message_id, 'notificationMode', rm.notification_mode,
+    'mutedUntil', rm.muted_until)
+FROM room_members rm JOIN rooms r ON r.id = rm.room_id
+WHERE rm.user_id = $1 ORDER BY rm.room_id`},
+	{name: "blocks", query: `
+SELECT json_build_object('userId', blocked_id, 'createdAt', created_at)
+FROM user_blocks WHERE blocker_id = $1 ORDER BY created_at`},
+	{name: "verifications", query: `
+SELECT json_build_object('userId', target_user_id, 'fingerprint', fingerprint, 'verifiedAt', verified_at)
+FROM identity_verifications WHERE verifier_id = $1 ORDER BY verified_at`},
+	{name: "identityKeys", query: `
+SELECT json_build_object(
+    'deviceId', device_id, 'identityKey', identity_key_jwk,
+    'identitySigningPublicKey', identity_signing_public_key_jwk, 'updatedAt', updated_at)
+FROM signal_device_identity_keys WHERE user_id = $1 ORDER BY device_id`},
+	{name: "signedPreKeys", query: `
+SELECT json_build_object(
+    'deviceId', device_id, 'keyId', key_id, 'publicKey', public_key_jwk,
+    'signature', signature, 'updatedAt', updated_at)
+FROM signal_device_signed_prekeys WHERE user_id = $1 ORDER BY device_id`},
+	{name: "oneTimePreKeys", query: `
+SELECT json_build_object(
+    'deviceId', device_id, 'keyId', key_id, 'publicKey', public_key_jwk,
+    'createdAt', created_at, 'consumedAt', consumed_at)
+FROM signal_device_one_time_prekeys WHERE user_id = $1 ORDER BY device_id, key_id`},
+	{name: "keyBackup", object: true, query: `
+SELECT json_build_object('version', version, 'ciphertext', ciphertext, 'updatedAt', updated_at)
+FROM account_key_backups WHERE user_id = $1`},
+	{name: "messages", query: `
+SELECT json_build_object(
+    'id', id, 'roomId', room_id, 'seq', room_seq, 'revision', revision, 'keyEpoch', key_epoch,
+    'payload', payload, 'createdAt', created_at, 'editedAt', edited_at, 'revokedAt', revoked_at)
+FROM messages WHERE sender_id = $1 ORDER BY id`},
+}
+
+// handleAccountExport serves GET /api/account/export: everything the server
+// holds about the caller as one JSON document. Sections are read in a single
+// repeatable-read transaction so the document is consistent, and streamed,
+// so a long history is never held in memory. Once streaming starts the
+// status is committed; a failure after that truncates the document.
+func (a *App) handleAccountExport(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), accountExportTimeout)
+	defer cancel()
+
+	tx, err := a.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to start export")
+		return
+	}
+	defer tx.Rollback()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%d-export.json"`, auth.UserID))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	export := &accountExportWriter{w: w, controller: http.NewResponseController(w)}
+	err = export.begin(time.Now().UTC())
+	for _, section := range accountExportSections {
+		if err != nil {
+			break
+		}
+		err = export.section(ctx, tx, section, auth.UserID)
+	}
+	if err == nil {
+		err = export.end()
+	}
+	if err != nil {
+		requestLogger(r.Context()).Error("account_export_failed", "user_id", auth.UserID, "error", err)
+		return
+	}
+	requestLogger(r.Context()).Info("account_exported", "user_id", auth.UserID, "messages", export.rows["messages"])
+}
+
+// accountExportWriter writes the export object one field at a time.
+type accountExportWriter struct {
+	w          io.Writer
+	controller *http.ResponseController
+	rows       map[string]int
+}
+
+func (e *accountExportWriter) begin(exportedAt time.Time) error {
+	e.rows = make(map[string]int, len(accountExportSections))
+	_, err := fmt.Fprintf(e.w, `{"exportedAt":%q`, exportedAt.Format(time.RFC3339Nano))
+	return err
+}
+
+func (e *accountExportWriter) section(ctx context.Context, tx *sql.Tx, section accountExportSection, userID int64) error {
+	name, _ := json.Marshal(section.name)
+	if _, err := fmt.Fprintf(e.w, ",%s:", name); err != nil {
+		return err
+	}
+	if section.object {
+		var value []byte
+		err := tx.QueryRowContext(ctx, section.query, userID).Scan(&value)
+		if errors.Is(err, sql.ErrNoRows) {
+			value, err = []byte("null"), nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", section.name, err)
+		}
+		_, err = e.w.Write(value)
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, section.query, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", section.name, err)
+	}
+	defer rows.Close()
+	if _, err := io.WriteString(e.w, "["); err != nil {
+		return err
+	}
+	count := 0
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return fmt.Errorf("%s: %w", section.name, err)
+		}
+		if count > 0 {
+			if _, err := io.WriteString(e.w, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := e.w.Write(value); err != nil {
+			return err
+		}
+		count++
+		if count%accountExportFlushEvery == 0 {
+			_ = e.controller.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%s: %w", section.name, err)
+	}
+	e.rows[section.name] = count
+	_, err = io.WriteString(e.w, "]")
+	return err
+}
+
+func (e *accountExportWriter) end() error {
+	if _, err := io.WriteString(e.w, "}\n"); err != nil {
+		return err
+	}
+	_ = e.controller.Flush()
+	return nil
+}
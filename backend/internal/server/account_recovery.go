@@ -0,0 +1,368 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	recoveryStatusPending  = "pending"
+	recoveryStatusApproved = "approved"
+	recoveryStatusRejected = "rejected"
+	recoveryStatusUsed     = "used"
+	recoveryStatusExpired  = "expired"
+
+	// passwordResetTokenTTL bounds how long an approved reset can be redeemed.
+	passwordResetTokenTTL = 24 * time.Hour
+)
+
+// handleRecoveryRequest lets a signed-out user ask for a password reset. The
+// answer is the same whether or not the account exists, so the endpoint
+// cannot be used to probe usernames.
+func (a *App) handleRecoveryRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	clientKey := clientKeyFromRequest(r, a.trustProxyHeaders)
+	if allowed, retryAfter := a.loginIPLimiter.Check(clientKey); !allowed {
+		respondRateLimitedAfter(w, "too many recovery requests", retryAfter)
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if len(req.Username) < 3 || len(req.Username) > 32 {
+		respondError(w, http.StatusBadRequest, "username length must be between 3 and 32")
+		return
+	}
+	if allowed, retryAfter := a.loginUserLimiter.Check(strings.ToLower(req.Username)); !allowed {
+		respondRateLimitedAfter(w, "too many recovery requests for this account", retryAfter)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// An approved token that lapsed would otherwise hold the user's open slot.
+	if _, err := a.db.ExecContext(ctx, `
+UPDATE password_reset_requests
+SET status = $2
+WHERE user_id IN (SELECT id FROM users WHERE username = $1) AND status = 'approved' AND expires_at <= NOW()
+`, req.Username, recoveryStatusExpired); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to record request")
+		return
+	}
+	// Bots and the bootstrap admin have no password to recover here.
+	if _, err := a.db.ExecContext(ctx, `
+INSERT INTO password_reset_requests(user_id, requested_ip)
+SELECT id, $2
+FROM users
+WHERE username = $1 AND username <> $3 AND role IN ('admin', 'user') AND deleted_at IS NULL
+ON CONFLICT DO NOTHING
+`, req.Username, clientKey, a.adminUsername); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to record request")
+		return
+	}
+	requestLogger(r.Context()).Info("recovery_requested", "username", req.Username)
+	respondJSON(w, http.StatusAccepted, map[string]any{"requested": true})
+}
+
+type recoveryRequestResp struct {
+	ID          int64  `json:"id"`
+	UserID      int64  `json:"userId"`
+	Username    string `json:"username"`
+	HasEmail    bool   `json:"hasEmail"`
+	RequestedAt string `json:"requestedAt"`
+	RequestedIP string `json:"requestedIp"`
+}
+
+// handleAdminRecoveryRequests lists pending reset requests, oldest first.
+func (a *App) handleAdminRecoveryRequests(w http.ResponseWriter, r *http.Request, _ AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT pr.id, pr.user_id, u.username, u.email IS NOT NULL, pr.requested_at, pr.requested_ip
+FROM password_reset_requests pr
+JOIN users u ON u.id = pr.user_id
+WHERE pr.status = 'pending'
+ORDER BY pr.requested_at ASC, pr.id ASC
+LIMIT 200
+`)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list recovery requests")
+		return
+	}
+	defer rows.Close()
+
+	requests := make([]recoveryRequestResp, 0, 16)
+	for rows.Next() {
+		var item recoveryRequestResp
+		var requestedAt time.Time
+		if err := rows.Scan(&item.ID, &item.UserID, &item.Username, &item.HasEmail, &requestedAt, &item.RequestedIP); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode recovery requests")
+			return
+		}
+		item.RequestedAt = requestedAt.UTC().Format(time.RFC3339Nano)
+		requests = append(requests, item)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list recovery requests")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"requests": requests})
+}
+
+// handleAdminRecoveryRequestSubroutes serves
+// POST /api/admin/recovery-requests/{id}/approve and .../reject. Approving
+// issues the reset token; it is emailed when possible and returned once to
+// admins so they can hand it over another way. Custom roles holding
+// manage_users never see the token, and deciding on an admin's request
+// needs manage_roles, so neither can be used to take over an admin account.
+func (a *App) handleAdminRecoveryRequestSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 5 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "recovery-requests" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requestID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || requestID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid request id")
+		return
+	}
+	var approve bool
+	switch parts[4] {
+	case "approve":
+		approve = true
+	case "reject":
+	default:
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to update recovery request")
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var username, role string
+	var hasEmail bool
+	err = tx.QueryRowContext(ctx, `
+SELECT pr.user_id, u.username, u.role, u.email IS NOT NULL
+FROM password_reset_requests pr
+JOIN users u ON u.id = pr.user_id
+WHERE pr.id = $1 AND pr.status = 'pending'
+FOR UPDATE OF pr
+`, requestID).Scan(&userID, &username, &role, &hasEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "pending recovery request not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to update recovery request")
+		return
+	}
+	if role == "admin" && !auth.Can(permManageRoles) {
+		respondErrorDetails(w, http.StatusForbidden, "permission_denied", "permission required", map[string]any{"permission": permManageRoles})
+		return
+	}
+	revealToken := auth.Role == "admin"
+	if approve && !revealToken && (a.mail == nil || !hasEmail) {
+		respondErrorCode(w, http.StatusConflict, "reset_email_unavailable", "the reset token can only be emailed to this user and no email can be sent")
+		return
+	}
+
+	if !approve {
+		if _, err := tx.ExecContext(ctx, `
+UPDATE password_reset_requests
+SET status = $2, decided_by = $3, decided_at = NOW()
+WHERE id = $1
+`, requestID, recoveryStatusRejected, auth.UserID); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update recovery request")
+			return
+		}
+		if err := recordAdminAudit(ctx, tx, auth.UserID, auditRecoveryRejected, userID, map[string]any{"requestId": requestID}); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update recovery request")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update recovery request")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"id": requestID, "status": recoveryStatusRejected})
+		return
+	}
+
+	token, tokenHash, err := generateLinkToken()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to issue reset token")
+		return
+	}
+	expiresAt := time.Now().Add(passwordResetTokenTTL)
+	if _, err := tx.ExecContext(ctx, `
+UPDATE password_reset_requests
+SET status = $2, decided_by = $3, decided_at = NOW(), token_hash = $4, expires_at = $5
+WHERE id = $1
+`, requestID, recoveryStatusApproved, auth.UserID, tokenHash, expiresAt); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to issue reset token")
+		return
+	}
+	if err := recordAdminAudit(ctx, tx, auth.UserID, auditRecoveryApproved, userID, map[string]any{"requestId": requestID}); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to issue reset token")
+		return
+	}
+	emailed := a.mail != nil && hasEmail
+	if emailed {
+		if err := enqueueUserEmail(ctx, tx, userID, emailTemplatePasswordReset, map[string]any{
+			"Username":  username,
+			"Link":      a.tokenLink("/recovery", token),
+			"ExpiresAt": expiresAt.UTC().Format(time.RFC1123),
+		}); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to queue reset email")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to issue reset token")
+		return
+	}
+	if emailed {
+		a.mail.Notify()
+	}
+	requestLogger(r.Context()).Warn("recovery_approved", "request_id", requestID, "user_id", userID, "admin_user_id", auth.UserID)
+	resp := map[string]any{
+		"id":        requestID,
+		"status":    recoveryStatusApproved,
+		"expiresAt": expiresAt.UTC().Format(time.RFC3339Nano),
+		// emailed is true when mail is configured and the user has an
+		// address on file.
+		"emailed": emailed,
+	}
+	if revealToken {
+		resp["token"] = token
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// handleRecoveryReset redeems an approved reset token. The token works once;
+// every refresh token is revoked and every device's session version bumped,
+// so all sessions from before the reset end.
+func (a *App) handleRecoveryReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	clientKey := clientKeyFromRequest(r, a.trustProxyHeaders)
+	if allowed, retryAfter := a.loginIPLimiter.Check(clientKey); !allowed {
+		respondRateLimitedAfter(w, "too many attempts", retryAfter)
+		return
+	}
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if len(req.Password) < 8 || len(req.Password) > 128 {
+		respondError(w, http.StatusBadRequest, "password length must be between 8 and 128")
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to hash password")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var username string
+	err = tx.QueryRowContext(ctx, `
+UPDATE password_reset_requests
+SET status = $2, used_at = NOW()
+WHERE token_hash = $1
+  AND status = 'approved'
+  AND expires_at > NOW()
+  AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+RETURNING user_id, (SELECT username FROM users WHERE users.id = password_reset_requests.user_id)
+`, hashLinkToken(strings.TrimSpace(req.Token)), recoveryStatusUsed).Scan(&userID, &username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondErrorCode(w, http.StatusBadRequest, "invalid_reset_token", "invalid or expired reset token")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password_hash = $2 WHERE id = $1`, userID, string(hash)); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+	if err := revokeUserSessionsTx(ctx, tx, userID); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to revoke sessions")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+
+	a.hub.KickUser(userID, 4004, "password reset")
+	requestLogger(r.Context()).Warn("password_reset", "user_id", userID)
+	a.notifyUserByEmail(ctx, userID, username, emailTemplatePasswordChanged, nil)
+	respondJSON(w, http.StatusOK, map[string]any{"username": username, "sessionsRevoked": true})
+}
+
+// revokeUserSessionsTx ends every session of the user: refresh tokens stop
+// rotating and access tokens fail the device session version check. Devices
+// stay registered so a fresh login keeps their keys.
+func revokeUserSessionsTx(ctx context.Context, tx *sql.Tx, userID int64) error {
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE auth_refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		`UPDATE user_devices SET session_version = session_version + 1 WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	)
+	return err
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestAdminRecoveryRequestSubroutesRejectMalformedPaths(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	cases := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPost, "/api/admin/recovery-requests/7", http.StatusNotFound},
+		{http.MethodGet, "/api/admin/recovery-requests/7/approve", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/admin/recovery-requests/abc/approve", http.StatusBadRequest},
+		{http.MethodPost, "/api/admin/recovery-requests/7/escalate", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		app.handleAdminRecoveryRequestSubroutes(rec, httptest.NewRequest(tc.method, tc.path, nil), AuthContext{UserID: 1})
+		if rec.Code != tc.status {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.path, tc.status, rec.Code)
+		}
+	}
+}
+
+func TestApproveRecoveryWithoutEmailReportsNotEmailed(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	adminID, roomID := seedSQLiteTestRoom(t, db)
+	userID := insertSQLiteTestMember(t, db, roomID, "alice", "user")
+	ctx := context.Background()
+	var requestID int64
+	if err := db.QueryRowContext(ctx,
+		`INSERT INTO password_reset_requests(user_id) VALUES ($1) RETURNING id`, userID,
+	).Scan(&requestID); err != nil {
+		t.Fatalf("insert request: %v", err)
+	}
+
+	app := &App{db: db, mail: newEmailDispatcher(db, nil)}
+	rec := httptest.NewRecorder()
+	path := "/api/admin/recovery-requests/" + strconv.FormatInt(requestID, 10) + "/approve"
+	app.handleAdminRecoveryRequestSubroutes(rec, httptest.NewRequest(http.MethodPost, path, nil), AuthContext{UserID: adminID, Role: "admin"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Emailed bool   `json:"emailed"`
+		Token   string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.Emailed || body.Token == "" {
+		t.Fatalf("expected the token to be handed to the admin instead of emailed, got %+v", body)
+	}
+	var queued int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM email_outbox`).Scan(&queued); err != nil {
+		t.Fatalf("count outbox: %v", err)
+	}
+	if queued != 0 {
+		t.Fatalf("expected no email to be queued, got %d", queued)
+	}
+}
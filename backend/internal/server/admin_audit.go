@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Admin audit actions.
+const (
+	auditSupportTokenIssued = "support_token_issued"
+	auditSupportAccess      = "support_access"
+	auditAdminInvited       = "admin_invited"
+	auditAdminInviteRevoked = "admin_invite_revoked"
+	auditAdminPromoted      = "admin_promoted"
+	auditRoleCreated        = "role_created"
+	auditRoleUpdated        = "role_updated"
+	auditRoleDeleted        = "role_deleted"
+	auditRoleAssigned       = "role_assigned"
+	auditRecoveryApproved   = "recovery_approved"
+	auditRecoveryRejected   = "recovery_rejected"
+	auditMaintenanceChanged = "maintenance_changed"
+	auditFeatureFlagChanged = "feature_flag_changed"
+	auditBroadcastSent      = "broadcast_sent"
+	auditPolicyPublished    = "policy_published"
+	auditLegalHoldPlaced    = "legal_hold_placed"
+	auditLegalHoldReleased  = "legal_hold_released"
+)
+
+type adminAuditEntry struct {
+	ID           int64           `json:"id"`
+	ActorID      *int64          `json:"actorId,omitempty"`
+	Action       string          `json:"action"`
+	TargetUserID *int64          `json:"targetUserId,omitempty"`
+	Details      json.RawMessage `json:"details"`
+	CreatedAt    string          `json:"createdAt"`
+}
+
+// recordAdminAudit appends to admin_audit_log. Callers performing audited
+// actions must fail the action when this fails.
+func recordAdminAudit(ctx context.Context, exec sqlExecer, actorID int64, action string, targetUserID int64, details map[string]any) error {
+	if details == nil {
+		details = map[string]any{}
+	}
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	var target any
+	if targetUserID > 0 {
+		target = targetUserID
+	}
+	_, err = exec.ExecContext(ctx,
+		`INSERT INTO admin_audit_log(actor_id, action, target_user_id, details) VALUES ($1, $2, $3, $4)`,
+		actorID, action, target, encoded,
+	)
+	return err
+}
+
+// handleAdminAuditLog lists audit entries newest first, optionally for one
+// target user. beforeId pages further back.
+func (a *App) handleAdminAuditLog(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	limit := int64(100)
+	if value := strings.TrimSpace(query.Get("limit")); value != "" {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed > 0 && parsed <= 500 {
+			limit = parsed
+		}
+	}
+	var beforeID, targetUserID int64
+	if value := strings.TrimSpace(query.Get("beforeId")); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid beforeId")
+			return
+		}
+		beforeID = parsed
+	}
+	if value := strings.TrimSpace(query.Get("userId")); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid userId")
+			return
+		}
+		targetUserID = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT id, actor_id, action, target_user_id, details, created_at
+FROM admin_audit_log
+WHERE ($1::BIGINT <= 0 OR id < $1)
+  AND ($2::BIGINT <= 0 OR target_user_id = $2)
+ORDER BY id DESC
+LIMIT $3
+`, beforeID, targetUserID, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load audit log")
+		return
+	}
+	defer rows.Close()
+
+	entries := make([]adminAuditEntry, 0, limit)
+	for rows.Next() {
+		var entry adminAuditEntry
+		var actorID, target sql.NullInt64
+		var details []byte
+		var createdAt time.Time
+		if err := rows.Scan(&entry.ID, &actorID, &entry.Action, &target, &details, &createdAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode audit log")
+			return
+		}
+		if actorID.Valid {
+			entry.ActorID = &actorID.Int64
+		}
+		if target.Valid {
+			entry.TargetUserID = &target.Int64
+		}
+		entry.Details = details
+		entry.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to decode audit log")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	broadcastAudienceAll  = "all"
+	broadcastAudienceRoom = "room"
+	broadcastAudienceRole = "role"
+
+	defaultBroadcastTTL    = 7 * 24 * time.Hour
+	maxBroadcastTTL        = 30 * 24 * time.Hour
+	maxBroadcastMessageLen = 1000
+	maxSyncAnnouncements   = 50
+)
+
+var errBroadcastSignature = errors.New("announcement signature mismatch")
+
+// adminBroadcast is a system announcement. Signature is the server's MAC
+// over its content; rows are checked again when read back, so an
+// announcement edited or planted directly in the database is never shown.
+type adminBroadcast struct {
+	ID        int64  `json:"id"`
+	Message   string `json:"message"`
+	Severity  string `json:"severity"`
+	Audience  string `json:"audience"`
+	RoomID    int64  `json:"roomId,omitempty"`
+	Role      string `json:"role,omitempty"`
+	CreatedBy int64  `json:"createdBy,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
+	Signature string `json:"signature"`
+}
+
+func validBroadcastSeverity(severity string) bool {
+	switch severity {
+	case "info", "warning", "critical":
+		return true
+	}
+	return false
+}
+
+// signingInput leaves out the ID, which the insert assigns, and the author,
+// who may be deleted later.
+func (b adminBroadcast) signingInput() []byte {
+	unsigned := b
+	unsigned.ID = 0
+	unsigned.CreatedBy = 0
+	unsigned.Signature = ""
+	encoded, _ := json.Marshal(unsigned)
+	return encoded
+}
+
+func (a *App) signBroadcast(b adminBroadcast) string {
+	key := hmac.New(sha256.New, a.jwtSecret)
+	key.Write([]byte("admin-broadcast"))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write(b.signingInput())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *App) verifyBroadcast(b adminBroadcast) error {
+	if !hmac.Equal([]byte(a.signBroadcast(b)), []byte(b.Signature)) {
+		return errBroadcastSignature
+	}
+	return nil
+}
+
+func (b adminBroadcast) frame() ([]byte, error) {
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		adminBroadcast
+	}{Type: "system_announcement", adminBroadcast: b})
+}
+
+// NotifyUsers sends payload to every non-guest connection whose user
+// matches. A nil match reaches everyone.
+func (h *Hub) NotifyUsers(payload []byte, match func(userID int64) bool) int {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.rooms))
+	for _, roomClients := range h.rooms {
+		for client := range roomClients {
+			if client.guest || (match != nil && !match(client.userID)) {
+				continue
+			}
+			clients = append(clients, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		select {
+		case client.send <- payload:
+		default:
+			client.log().Warn("websocket_announcement_drop", "user_id", client.userID, "room_id", client.roomID, "reason", "send queue full")
+		}
+	}
+	return len(clients)
+}
+
+// broadcastAudienceUsers resolves a room or role audience to its users.
+// The "all" audience returns nil.
+func (a *App) broadcastAudienceUsers(ctx context.Context, b adminBroadcast) (map[int64]bool, error) {
+	var rows *sql.Rows
+	var err error
+	switch b.Audience {
+	case broadcastAudienceRoom:
+		rows, err = a.db.QueryContext(ctx, `SELECT user_id FROM room_members WHERE room_id = $1`, b.RoomID)
+	case broadcastAudienceRole:
+		rows, err = a.db.QueryContext(ctx, `
+SELECT id FROM users
+WHERE deleted_at IS NULL AND (role = $1 OR custom_role = $1)
+`, b.Role)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make(map[int64]bool)
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		users[userID] = true
+	}
+	return users, rows.Err()
+}
+
+// handleAdminBroadcast serves POST /api/admin/broadcast. The announcement is
+// stored first, so users who are offline or miss the live frame pick it up
+// from /api/sync until it expires.
+func (a *App) handleAdminBroadcast(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Message          string `json:"message"`
+		Severity         string `json:"severity"`
+		Audience         string `json:"audience"`
+		RoomID           int64  `json:"roomId"`
+		Role             string `json:"role"`
+		ExpiresInMinutes int    `json:"expiresInMinutes"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		respondError(w, http.StatusBadRequest, "message is required")
+		return
+	}
+	if utf8.RuneCountInString(req.Message) > maxBroadcastMessageLen {
+		respondError(w, http.StatusBadRequest, "message is too long")
+		return
+	}
+	if req.Severity == "" {
+		req.Severity = "info"
+	}
+	if !validBroadcastSeverity(req.Severity) {
+		respondError(w, http.StatusBadRequest, "severity must be info, warning or critical")
+		return
+	}
+	ttl := defaultBroadcastTTL
+	if req.ExpiresInMinutes != 0 {
+		ttl = time.Duration(req.ExpiresInMinutes) * time.Minute
+		if ttl <= 0 || ttl > maxBroadcastTTL {
+			respondError(w, http.StatusBadRequest, "expiresInMinutes must be between 1 and 43200")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if req.Audience == "" {
+		req.Audience = broadcastAudienceAll
+	}
+	switch req.Audience {
+	case broadcastAudienceAll:
+		req.RoomID, req.Role = 0, ""
+	case broadcastAudienceRoom:
+		req.Role = ""
+		if req.RoomID <= 0 {
+			respondError(w, http.StatusBadRequest, "roomId is required for room audience")
+			return
+		}
+		if err := a.ensureRoomExists(ctx, req.RoomID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "room not found")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to verify room")
+			return
+		}
+	case broadcastAudienceRole:
+		req.RoomID = 0
+		req.Role = strings.TrimSpace(req.Role)
+		switch req.Role {
+		case "admin", "user", "bot":
+		case "":
+			respondError(w, http.StatusBadRequest, "role is required for role audience")
+			return
+		default:
+			var exists bool
+			if err := a.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)`, req.Role).Scan(&exists); err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to verify role")
+				return
+			}
+			if !exists {
+				respondError(w, http.StatusNotFound, "role not found")
+				return
+			}
+		}
+	default:
+		respondError(w, http.StatusBadRequest, "audience must be all, room or role")
+		return
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+	expiresAt := createdAt.Add(ttl)
+	broadcast := adminBroadcast{
+		Message:   req.Message,
+		Severity:  req.Severity,
+		Audience:  req.Audience,
+		RoomID:    req.RoomID,
+		Role:      req.Role,
+		CreatedBy: auth.UserID,
+		CreatedAt: createdAt.Format(time.RFC3339Nano),
+		ExpiresAt: expiresAt.Format(time.RFC3339Nano),
+	}
+	broadcast.Signature = a.signBroadcast(broadcast)
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to store announcement")
+		return
+	}
+	defer tx.Rollback()
+	if err := tx.QueryRowContext(ctx, `
+INSERT INTO admin_broadcasts(message, severity, audience, room_id, role, created_by, signature, created_at, expires_at)
+VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''), $6, $7, $8, $9)
+RETURNING id
+`, broadcast.Message, broadcast.Severity, broadcast.Audience, broadcast.RoomID, broadcast.Role, auth.UserID, broadcast.Signature, createdAt, expiresAt).Scan(&broadcast.ID); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to store announcement")
+		return
+	}
+	if err := recordAdminAudit(ctx, tx, auth.UserID, auditBroadcastSent, 0, map[string]any{
+		"broadcastId": broadcast.ID,
+		"audience":    broadcast.Audience,
+		"roomId":      broadcast.RoomID,
+		"role":        broadcast.Role,
+		"severity":    broadcast.Severity,
+	}); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to record audit entry")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to store announcement")
+		return
+	}
+
+	notified := 0
+	if users, err := a.broadcastAudienceUsers(ctx, broadcast); err != nil {
+		requestLogger(r.Context()).Warn("broadcast_audience_failed", "broadcast_id", broadcast.ID, "error", err)
+	} else if payload, err := broadcast.frame(); err == nil {
+		var match func(int64) bool
+		if users != nil {
+			match = func(userID int64) bool { return users[userID] }
+		}
+		notified = a.hub.NotifyUsers(payload, match)
+	}
+	requestLogger(r.Context()).Info(
+		"admin_broadcast_sent",
+		"broadcast_id", broadcast.ID,
+		"audience", broadcast.Audience,
+		"connections_notified", notified,
+		"admin_user_id", auth.UserID,
+	)
+	respondJSON(w, http.StatusCreated, map[string]any{
+		"announcement":        broadcast,
+		"connectionsNotified": notified,
+	})
+}
+
+// listUserAnnouncementsSince returns unexpired announcements addressed to
+// userID with IDs above cursor, oldest first.
+func (a *App) listUserAnnouncementsSince(ctx context.Context, userID, cursor int64, limit int) ([]adminBroadcast, error) {
+	rows, err := a.db.QueryContext(ctx, `
+SELECT b.id, b.message, b.severity, b.audience, COALESCE(b.room_id, 0), COALESCE(b.role, ''), COALESCE(b.created_by, 0),
+       b.signature, b.created_at, b.expires_at
+FROM admin_broadcasts b
+WHERE b.id > $2
+  AND b.expires_at > NOW()
+  AND (
+    b.audience = 'all'
+    OR (b.audience = 'room' AND EXISTS (
+      SELECT 1 FROM room_members rm WHERE rm.room_id = b.room_id AND rm.user_id = $1
+    ))
+    OR (b.audience = 'role' AND EXISTS (
+      SELECT 1 FROM users u WHERE u.id = $1 AND (u.role = b.role OR u.custom_role = b.role)
+    ))
+  )
+ORDER BY b.id ASC
+LIMIT $3
+`, userID, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	announcements := make([]adminBroadcast, 0, limit)
+	for rows.Next() {
+		var item adminBroadcast
+		var createdAt, expiresAt time.Time
+		if err := rows.Scan(
+			&item.ID,
+			&item.Message,
+			&item.Severity,
+			&item.Audience,
+			&item.RoomID,
+			&item.Role,
+			&item.CreatedBy,
+			&item.Signature,
+			&createdAt,
+			&expiresAt,
+		); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		item.ExpiresAt = expiresAt.UTC().Format(time.RFC3339Nano)
+		announcements = append(announcements, item)
+	}
+	return announcements, rows.Err()
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBroadcastSignatureCoversContent(t *testing.T) {
+	t.Parallel()
+
+	app := &App{jwtSecret: []byte("test-secret-test-secret-test-secret")}
+	broadcast := adminBroadcast{
+		ID:        4,
+		Message:   "maintenance at 22:00",
+		Severity:  "warning",
+		Audience:  broadcastAudienceRole,
+		Role:      "user",
+		CreatedBy: 1,
+		CreatedAt: "2026-10-15T12:00:00Z",
+		ExpiresAt: "2026-10-22T12:00:00Z",
+	}
+	broadcast.Signature = app.signBroadcast(broadcast)
+	if err := app.verifyBroadcast(broadcast); err != nil {
+		t.Fatalf("expected signature to verify: %v", err)
+	}
+
+	// The author may be deleted after sending; that must not invalidate it.
+	authorGone := broadcast
+	authorGone.CreatedBy = 0
+	if err := app.verifyBroadcast(authorGone); err != nil {
+		t.Fatalf("expected signature to survive author removal: %v", err)
+	}
+
+	tampered := broadcast
+	tampered.Message = "maintenance at 23:00"
+	if err := app.verifyBroadcast(tampered); !errors.Is(err, errBroadcastSignature) {
+		t.Fatalf("expected tampered message to fail, got %v", err)
+	}
+	widened := broadcast
+	widened.Audience, widened.Role = broadcastAudienceAll, ""
+	if err := app.verifyBroadcast(widened); !errors.Is(err, errBroadcastSignature) {
+		t.Fatalf("expected widened audience to fail, got %v", err)
+	}
+}
+
+func TestHubNotifyUsersSkipsGuestsAndUnmatched(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	member := &Client{send: make(chan []byte, 1), userID: 1, deviceID: "a", roomID: 1}
+	other := &Client{send: make(chan []byte, 1), userID: 2, deviceID: "b", roomID: 1}
+	guest := &Client{send: make(chan []byte, 1), guest: true, deviceID: "g", roomID: 1}
+	for _, client := range []*Client{member, other, guest} {
+		hub.AddClient(client)
+		drainSend(client)
+	}
+
+	if notified := hub.NotifyUsers([]byte(`{}`), func(userID int64) bool { return userID == 1 }); notified != 1 {
+		t.Fatalf("expected one connection notified, got %d", notified)
+	}
+	if len(member.send) != 1 || len(other.send) != 0 || len(guest.send) != 0 {
+		t.Fatalf("unexpected deliveries member=%d other=%d guest=%d", len(member.send), len(other.send), len(guest.send))
+	}
+	drainSend(member)
+	if notified := hub.NotifyUsers([]byte(`{}`), nil); notified != 2 {
+		t.Fatalf("expected everyone but the guest, got %d", notified)
+	}
+}
+
+func drainSend(client *Client) {
+	for {
+		select {
+		case <-client.send:
+		default:
+			return
+		}
+	}
+}
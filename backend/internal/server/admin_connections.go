@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type adminConnection struct {
+	ID          int64             `json:"id"`
+	UserID      int64             `json:"userId,omitempty"`
+	Username    string            `json:"username"`
+	DeviceID    string            `json:"deviceId,omitempty"`
+	DeviceName  string            `json:"deviceName,omitempty"`
+	Guest       bool              `json:"guest"`
+	RoomID      int64             `json:"roomId"`
+	RemoteIP    string            `json:"remoteIp"`
+	ConnectedAt string            `json:"connectedAt"`
+	QueueDepth  int               `json:"queueDepth"`
+	QueueSize   int               `json:"queueSize"`
+	Latency     connectionLatency `json:"latency"`
+}
+
+// Connections snapshots every live connection, oldest first.
+func (h *Hub) Connections() []adminConnection {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	connections := make([]adminConnection, 0, len(h.rooms))
+	for _, roomClients := range h.rooms {
+		for client := range roomClients {
+			connection := adminConnection{
+				ID:         client.id,
+				UserID:     client.userID,
+				Username:   client.username,
+				DeviceID:   client.deviceID,
+				DeviceName: client.deviceName,
+				Guest:      client.guest,
+				RoomID:     client.roomID,
+				RemoteIP:   client.remoteIP,
+				QueueDepth: len(client.send),
+				QueueSize:  cap(client.send),
+				Latency:    client.latency(),
+			}
+			if !client.connectedAt.IsZero() {
+				connection.ConnectedAt = client.connectedAt.UTC().Format(time.RFC3339Nano)
+			}
+			connections = append(connections, connection)
+		}
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].ID < connections[j].ID })
+	return connections
+}
+
+// Disconnect closes the connection with the given id and reports whether it
+// was still connected.
+func (h *Hub) Disconnect(connID int64, code int, reason string) (adminConnection, bool) {
+	var found *Client
+	h.mu.RLock()
+	for _, roomClients := range h.rooms {
+		for client := range roomClients {
+			if client.id == connID {
+				found = client
+			}
+		}
+	}
+	h.mu.RUnlock()
+	if found == nil {
+		return adminConnection{}, false
+	}
+
+	h.kick(func(client *Client) bool { return client == found }, code, reason)
+	return adminConnection{
+		ID:       found.id,
+		UserID:   found.userID,
+		Username: found.username,
+		DeviceID: found.deviceID,
+		Guest:    found.guest,
+		RoomID:   found.roomID,
+	}, true
+}
+
+// handleAdminConnections lists live WebSocket connections on this instance.
+func (a *App) handleAdminConnections(w http.ResponseWriter, r *http.Request, _ AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	connections := a.hub.Connections()
+	respondJSON(w, http.StatusOK, map[string]any{
+		"connections": connections,
+		"count":       len(connections),
+	})
+}
+
+// handleAdminConnectionSubroutes serves DELETE /api/admin/connections/{id},
+// which force-closes one connection. The client is free to reconnect; suspend
+// the account or revoke the device to keep it out.
+func (a *App) handleAdminConnectionSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "connections" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	connID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || connID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid connection id")
+		return
+	}
+
+	connection, ok := a.hub.Disconnect(connID, websocket.ClosePolicyViolation, "disconnected by admin")
+	if !ok {
+		respondError(w, http.StatusNotFound, "connection not found")
+		return
+	}
+	requestLogger(r.Context()).Warn("websocket_connection_disconnected",
+		"connection_id", connID,
+		"user_id", connection.UserID,
+		"device_id", connection.DeviceID,
+		"room_id", connection.RoomID,
+		"admin_user_id", auth.UserID,
+	)
+	respondJSON(w, http.StatusOK, map[string]any{"disconnected": true, "connection": connection})
+}
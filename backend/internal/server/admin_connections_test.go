@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHubConnectionsSnapshot(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	member := &Client{userID: 1, username: "alice", deviceID: "a", roomID: 7, remoteIP: "203.0.113.9", connectedAt: time.Now(), send: make(chan []byte, 4)}
+	guest := &Client{username: "guest", roomID: 7, guest: true, send: make(chan []byte, 4)}
+	hub.AddClient(member)
+	hub.AddClient(guest)
+	member.send <- []byte(`{}`)
+	member.rtt.add(15 * time.Millisecond)
+
+	connections := hub.Connections()
+	if len(connections) != 2 {
+		t.Fatalf("expected 2 connections, got %d", len(connections))
+	}
+	first := connections[0]
+	if first.ID != member.id || first.UserID != 1 || first.RemoteIP != "203.0.113.9" || first.ConnectedAt == "" {
+		t.Fatalf("unexpected member snapshot: %+v", first)
+	}
+	if first.QueueDepth != 1 || first.QueueSize != 4 || first.Latency.AverageRTTMillis != 15 {
+		t.Fatalf("expected queue depth and latency, got %+v", first)
+	}
+	if !connections[1].Guest || connections[1].ID <= first.ID {
+		t.Fatalf("expected guest listed second, got %+v", connections[1])
+	}
+}
+
+func TestHubDisconnectUnknownConnection(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	hub.AddClient(&Client{userID: 1, roomID: 7, send: make(chan []byte, 1)})
+	if _, ok := hub.Disconnect(99, 4000, "test"); ok {
+		t.Fatal("expected unknown connection id to report not found")
+	}
+}
+
+func TestAdminConnectionSubroutesValidation(t *testing.T) {
+	t.Parallel()
+
+	app := &App{hub: NewHub()}
+	cases := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodDelete, "/api/admin/connections/abc", http.StatusBadRequest},
+		{http.MethodGet, "/api/admin/connections/1", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/admin/connections/1", http.StatusNotFound},
+		{http.MethodDelete, "/api/admin/connections/1/extra", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		app.handleAdminConnectionSubroutes(recorder, httptest.NewRequest(tc.method, tc.path, nil), AuthContext{UserID: 1, Role: "admin"})
+		if recorder.Code != tc.status {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.path, tc.status, recorder.Code)
+		}
+	}
+}
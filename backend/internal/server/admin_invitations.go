@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// adminInvitationTTL bounds how long an invitation to become an admin can be
+// accepted.
+const adminInvitationTTL = 72 * time.Hour
+
+// parseAdminUsernames reads ADMIN_USERNAMES, a comma-separated list of
+// accounts that are kept as admins alongside ADMIN_USERNAME.
+func parseAdminUsernames(value string) ([]string, error) {
+	usernames := []string{}
+	seen := make(map[string]struct{})
+	for _, entry := range strings.Split(value, ",") {
+		username := strings.TrimSpace(entry)
+		if username == "" {
+			continue
+		}
+		if len(username) < 3 || len(username) > 32 {
+			return nil, fmt.Errorf("username %q must be between 3 and 32 characters", username)
+		}
+		if _, ok := seen[username]; ok {
+			continue
+		}
+		seen[username] = struct{}{}
+		usernames = append(usernames, username)
+	}
+	return usernames, nil
+}
+
+// isConfiguredAdmin reports whether username is one of the admins managed by
+// configuration. Those names are reserved and their accounts cannot be
+// deleted.
+func (a *App) isConfiguredAdmin(username string) bool {
+	if username == a.adminUsername {
+		return true
+	}
+	for _, name := range a.adminUsernames {
+		if name == username {
+			return true
+		}
+	}
+	return false
+}
+
+type adminInvitation struct {
+	UserID    int64  `json:"userId"`
+	InvitedBy *int64 `json:"invitedBy,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+func (a *App) loadAdminInvitation(ctx context.Context, userID int64) (adminInvitation, error) {
+	var invitation adminInvitation
+	var invitedBy sql.NullInt64
+	var createdAt, expiresAt time.Time
+	err := a.db.QueryRowContext(ctx, `
+SELECT user_id, invited_by, created_at, expires_at
+FROM admin_invitations
+WHERE user_id = $1 AND expires_at > NOW()
+`, userID).Scan(&invitation.UserID, &invitedBy, &createdAt, &expiresAt)
+	if err != nil {
+		return adminInvitation{}, err
+	}
+	if invitedBy.Valid {
+		value := invitedBy.Int64
+		invitation.InvitedBy = &value
+	}
+	invitation.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+	invitation.ExpiresAt = expiresAt.UTC().Format(time.RFC3339Nano)
+	return invitation, nil
+}
+
+// handleAdminInvitation serves /api/admin/users/{id}/admin-invitation. POST
+// invites the user to become an admin and DELETE withdraws the invitation.
+// The role only changes once the invitee confirms with their password.
+func (a *App) handleAdminInvitation(w http.ResponseWriter, r *http.Request, auth AuthContext, userID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodPost:
+		if userID == auth.UserID {
+			respondError(w, http.StatusBadRequest, "cannot invite yourself")
+			return
+		}
+		var role string
+		var inactive bool
+		err := a.db.QueryRowContext(ctx,
+			`SELECT role, suspended_at IS NOT NULL OR deleted_at IS NOT NULL FROM users WHERE id = $1`,
+			userID,
+		).Scan(&role, &inactive)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "user not found")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to load user")
+			return
+		}
+		if role == "admin" {
+			respondErrorCode(w, http.StatusConflict, "already_admin", "user is already an admin")
+			return
+		}
+		if role != "user" || inactive {
+			respondError(w, http.StatusBadRequest, "only active user accounts can be invited")
+			return
+		}
+
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create invitation")
+			return
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO admin_invitations(user_id, invited_by, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET invited_by = EXCLUDED.invited_by,
+    created_at = NOW(),
+    expires_at = EXCLUDED.expires_at
+`, userID, auth.UserID, time.Now().Add(adminInvitationTTL)); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create invitation")
+			return
+		}
+		if err := recordAdminAudit(ctx, tx, auth.UserID, auditAdminInvited, userID, nil); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create invitation")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create invitation")
+			return
+		}
+		invitation, err := a.loadAdminInvitation(ctx, userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to load invitation")
+			return
+		}
+		requestLogger(r.Context()).Info("admin_invited", "admin_id", auth.UserID, "user_id", userID)
+		respondJSON(w, http.StatusCreated, map[string]any{"invitation": invitation})
+	case http.MethodDelete:
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to revoke invitation")
+			return
+		}
+		defer tx.Rollback()
+		result, err := tx.ExecContext(ctx, `DELETE FROM admin_invitations WHERE user_id = $1`, userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to revoke invitation")
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			respondError(w, http.StatusNotFound, "invitation not found")
+			return
+		}
+		if err := recordAdminAudit(ctx, tx, auth.UserID, auditAdminInviteRevoked, userID, nil); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to revoke invitation")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to revoke invitation")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAccountAdminInvitation lets the invitee see a pending admin
+// invitation (GET), accept it by confirming their password (POST) or decline
+// it (DELETE). After accepting, existing tokens no longer match the account's
+// role and the user signs in again as an admin.
+func (a *App) handleAccountAdminInvitation(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		invitation, err := a.loadAdminInvitation(ctx, auth.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "invitation not found")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to load invitation")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"invitation": invitation})
+	case http.MethodPost:
+		var req struct {
+			Password string `json:"password"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		a.acceptAdminInvitation(ctx, w, r, auth, req.Password)
+	case http.MethodDelete:
+		if _, err := a.db.ExecContext(ctx, `DELETE FROM admin_invitations WHERE user_id = $1`, auth.UserID); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decline invitation")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) acceptAdminInvitation(ctx context.Context, w http.ResponseWriter, r *http.Request, auth AuthContext, password string) {
+	if auth.Role != "user" {
+		respondErrorCode(w, http.StatusConflict, "already_admin", "only user accounts can accept")
+		return
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to accept invitation")
+		return
+	}
+	defer tx.Rollback()
+
+	var hash string
+	var invitedBy sql.NullInt64
+	err = tx.QueryRowContext(ctx, `
+SELECT u.password_hash, ai.invited_by
+FROM admin_invitations ai
+JOIN users u ON u.id = ai.user_id
+WHERE ai.user_id = $1 AND ai.expires_at > NOW()
+FOR UPDATE OF ai
+`, auth.UserID).Scan(&hash, &invitedBy)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "invitation not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to accept invitation")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		respondError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE users
+SET role = 'admin', admin_granted_at = NOW(), admin_granted_by = $2
+WHERE id = $1 AND role = 'user'
+`, auth.UserID, invitedBy); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to accept invitation")
+		return
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM admin_invitations WHERE user_id = $1`, auth.UserID); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to accept invitation")
+		return
+	}
+	details := map[string]any{}
+	if invitedBy.Valid {
+		details["invitedBy"] = invitedBy.Int64
+	}
+	if err := recordAdminAudit(ctx, tx, auth.UserID, auditAdminPromoted, auth.UserID, details); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to accept invitation")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to accept invitation")
+		return
+	}
+	requestLogger(r.Context()).Warn("admin_promoted", "user_id", auth.UserID, "invited_by", invitedBy.Int64)
+	respondJSON(w, http.StatusOK, map[string]any{"role": "admin", "reauthenticate": true})
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAdminUsersPageSize = 100
+	maxAdminUsersPageSize     = 500
+	maxAdminUsersQueryLength  = 64
+)
+
+var errInvalidAdminUsersCursor = errors.New("invalid cursor")
+
+// adminUsersSort maps a public sort name to the column it orders by. The
+// user id is always the tie-breaker, so every sort is a total order and the
+// cursor can resume exactly where the last page stopped.
+var adminUsersSort = map[string]string{
+	"id":        "id",
+	"username":  "COALESCE(former_username, username)",
+	"createdAt": "created_at",
+}
+
+type adminUsersQuery struct {
+	Limit      int
+	Search     string
+	Role       string
+	Sort       string
+	Descending bool
+	Cursor     *adminUsersCursor
+}
+
+// adminUsersCursor is the sort key and id of the last user on a page. It is
+// only meaningful with the same sort and order it was issued for.
+type adminUsersCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d,omitempty"`
+	Key  string `json:"k"`
+	ID   int64  `json:"id"`
+}
+
+func (c adminUsersCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeAdminUsersCursor(value string) (*adminUsersCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidAdminUsersCursor
+	}
+	var cursor adminUsersCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.ID <= 0 {
+		return nil, errInvalidAdminUsersCursor
+	}
+	if _, ok := adminUsersSort[cursor.Sort]; !ok {
+		return nil, errInvalidAdminUsersCursor
+	}
+	if cursor.Sort == "createdAt" {
+		if _, err := time.Parse(time.RFC3339Nano, cursor.Key); err != nil {
+			return nil, errInvalidAdminUsersCursor
+		}
+	}
+	return &cursor, nil
+}
+
+func parseAdminUsersQuery(r *http.Request) (adminUsersQuery, error) {
+	values := r.URL.Query()
+	query := adminUsersQuery{Limit: defaultAdminUsersPageSize, Sort: "id"}
+	if value := strings.TrimSpace(values.Get("limit")); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxAdminUsersPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxAdminUsersPageSize)
+		}
+		query.Limit = parsed
+	}
+	query.Search = strings.TrimSpace(values.Get("q"))
+	if len(query.Search) > maxAdminUsersQueryLength {
+		return query, fmt.Errorf("q must be at most %d characters", maxAdminUsersQueryLength)
+	}
+	query.Role = strings.ToLower(strings.TrimSpace(values.Get("role")))
+	switch query.Role {
+	case "", "admin", "user", "bot":
+	default:
+		return query, errors.New("role must be admin, user or bot")
+	}
+	if value := strings.TrimSpace(values.Get("sort")); value != "" {
+		if _, ok := adminUsersSort[value]; !ok {
+			return query, errors.New("sort must be id, username or createdAt")
+		}
+		query.Sort = value
+	}
+	switch strings.ToLower(strings.TrimSpace(values.Get("order"))) {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, errors.New("order must be asc or desc")
+	}
+	if value := strings.TrimSpace(values.Get("cursor")); value != "" {
+		cursor, err := decodeAdminUsersCursor(value)
+		if err != nil {
+			return query, err
+		}
+		if cursor.Sort != query.Sort || cursor.Desc != query.Descending {
+			return query, errors.New("cursor does not match sort and order")
+		}
+		query.Cursor = cursor
+	}
+	return query, nil
+}
+
+// escapeLikePattern makes user input match literally inside ILIKE.
+func escapeLikePattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// buildAdminUsersSQL assembles the page query. Only whitelisted column names
+// are interpolated; every user-supplied value is a bind parameter.
+func buildAdminUsersSQL(query adminUsersQuery) (string, []any) {
+	column := adminUsersSort[query.Sort]
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	conditions := make([]string, 0, 3)
+	args := make([]any, 0, 5)
+	if query.Search != "" {
+		args = append(args, "%"+escapeLikePattern(query.Search)+"%")
+		conditions = append(conditions, fmt.Sprintf(`COALESCE(former_username, username) ILIKE $%d ESCAPE '\'`, len(args)))
+	}
+	if query.Role != "" {
+		args = append(args, query.Role)
+		conditions = append(conditions, fmt.Sprintf("role = $%d", len(args)))
+	}
+	if query.Cursor != nil {
+		if query.Sort == "id" {
+			args = append(args, query.Cursor.ID)
+			conditions = append(conditions, fmt.Sprintf("id %s $%d", comparison, len(args)))
+		} else {
+			var key any = query.Cursor.Key
+			if query.Sort == "createdAt" {
+				key, _ = time.Parse(time.RFC3339Nano, query.Cursor.Key)
+			}
+			args = append(args, key, query.Cursor.ID)
+			conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, comparison, len(args)-1, len(args)))
+		}
+	}
+
+	var builder strings.Builder
+	builder.WriteString(`
+SELECT id, COALESCE(former_username, username), role, created_at, suspended_at, deleted_at
+FROM users`)
+	if len(conditions) > 0 {
+		builder.WriteString("\nWHERE ")
+		builder.WriteString(strings.Join(conditions, "\n  AND "))
+	}
+	if query.Sort == "id" {
+		fmt.Fprintf(&builder, "\nORDER BY id %s", direction)
+	} else {
+		fmt.Fprintf(&builder, "\nORDER BY %s %s, id %s", column, direction, direction)
+	}
+	args = append(args, query.Limit+1)
+	fmt.Fprintf(&builder, "\nLIMIT $%d\n", len(args))
+	return builder.String(), args
+}
+
+type adminUserSnapshot struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	Role        string `json:"role"`
+	CreatedAt   string `json:"createdAt"`
+	SuspendedAt string `json:"suspendedAt,omitempty"`
+	DeletedAt   string `json:"deletedAt,omitempty"`
+}
+
+// listAdminUsers serves one page of GET /api/admin/users. nextCursor is set
+// when more users match.
+func (a *App) listAdminUsers(w http.ResponseWriter, r *http.Request) {
+	query, err := parseAdminUsersQuery(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	statement, args := buildAdminUsersSQL(query)
+	rows, err := a.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list users")
+		return
+	}
+	defer rows.Close()
+
+	users := make([]adminUserSnapshot, 0, query.Limit+1)
+	for rows.Next() {
+		var user adminUserSnapshot
+		var createdAt time.Time
+		var suspendedAt sql.NullTime
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &createdAt, &suspendedAt, &deletedAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode user list")
+			return
+		}
+		user.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		if suspendedAt.Valid {
+			user.SuspendedAt = suspendedAt.Time.UTC().Format(time.RFC3339Nano)
+		}
+		if deletedAt.Valid {
+			user.DeletedAt = deletedAt.Time.UTC().Format(time.RFC3339Nano)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list users")
+		return
+	}
+
+	response := map[string]any{"users": users}
+	if len(users) > query.Limit {
+		users = users[:query.Limit]
+		last := users[len(users)-1]
+		cursor := adminUsersCursor{Sort: query.Sort, Desc: query.Descending, ID: last.ID}
+		switch query.Sort {
+		case "username":
+			cursor.Key = last.Username
+		case "createdAt":
+			cursor.Key = last.CreatedAt
+		}
+		response["users"] = users
+		response["nextCursor"] = cursor.encode()
+	}
+	respondJSON(w, http.StatusOK, response)
+}
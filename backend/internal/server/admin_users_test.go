@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseAdminUsersQuery(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/users?limit=20&q=al_&role=User&sort=username&order=desc", nil)
+	query, err := parseAdminUsersQuery(req)
+	if err != nil {
+		t.Fatalf("parse query: %v", err)
+	}
+	if query.Limit != 20 || query.Search != "al_" || query.Role != "user" || query.Sort != "username" || !query.Descending {
+		t.Fatalf("unexpected query: %+v", query)
+	}
+
+	for _, raw := range []string{"limit=0", "limit=501", "role=owner", "sort=password", "order=up", "cursor=!!"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/users?"+raw, nil)
+		if _, err := parseAdminUsersQuery(req); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestAdminUsersCursorMustMatchSort(t *testing.T) {
+	t.Parallel()
+
+	cursor := adminUsersCursor{Sort: "username", Key: "alice", ID: 4}.encode()
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/users?sort=username&cursor="+cursor, nil)
+	query, err := parseAdminUsersQuery(req)
+	if err != nil {
+		t.Fatalf("parse query: %v", err)
+	}
+	if query.Cursor == nil || query.Cursor.Key != "alice" || query.Cursor.ID != 4 {
+		t.Fatalf("unexpected cursor: %+v", query.Cursor)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/users?sort=createdAt&cursor="+cursor, nil)
+	if _, err := parseAdminUsersQuery(req); err == nil {
+		t.Fatal("expected cursor from another sort to be rejected")
+	}
+}
+
+func TestBuildAdminUsersSQL(t *testing.T) {
+	t.Parallel()
+
+	statement, args := buildAdminUsersSQL(adminUsersQuery{
+		Limit:      10,
+		Search:     "50%",
+		Role:       "admin",
+		Sort:       "username",
+		Descending: true,
+		Cursor:     &adminUsersCursor{Sort: "username", Desc: true, Key: "bob", ID: 7},
+	})
+	for _, fragment := range []string{
+		"ILIKE $1 ESCAPE",
+		"role = $2",
+		"(COALESCE(former_username, username), id) < ($3, $4)",
+		"ORDER BY COALESCE(former_username, username) DESC, id DESC",
+		"LIMIT $5",
+	} {
+		if !strings.Contains(statement, fragment) {
+			t.Fatalf("expected %q in:\n%s", fragment, statement)
+		}
+	}
+	if len(args) != 5 || args[0] != `%50\%%` || args[4] != 11 {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+
+	statement, args = buildAdminUsersSQL(adminUsersQuery{Limit: 100, Sort: "id"})
+	if strings.Contains(statement, "WHERE") || !strings.Contains(statement, "ORDER BY id ASC") || len(args) != 1 {
+		t.Fatalf("unexpected default query:\n%s %#v", statement, args)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import "net/http"
+
+// Generic error codes, one per status, for errors that have no more specific
+// code. Codes are part of the API contract: clients branch on them, while
+// messages are for people and may be reworded at any time. Handlers add
+// specific codes (challenge_required, room_full, quota codes and so on) next
+// to the call that produces them.
+const (
+	errCodeBadRequest       = "bad_request"
+	errCodeUnauthorized     = "unauthorized"
+	errCodeForbidden        = "forbidden"
+	errCodeNotFound         = "not_found"
+	errCodeMethodNotAllowed = "method_not_allowed"
+	errCodeConflict         = "conflict"
+	errCodeGone             = "gone"
+	errCodePayloadTooLarge  = "payload_too_large"
+	errCodeUnprocessable    = "unprocessable"
+	errCodeRateLimited      = "rate_limited"
+	errCodeInternal         = "internal_error"
+	errCodeBadGateway       = "bad_gateway"
+	errCodeUnavailable      = "unavailable"
+)
+
+// apiError is the body of every error response.
+type apiError struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+	// RequestID echoes the X-Request-ID header so a pasted error body is
+	// enough to find the request in the logs.
+	RequestID string `json:"requestId,omitempty"`
+}
+
+func errorCodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return errCodeBadRequest
+	case http.StatusUnauthorized:
+		return errCodeUnauthorized
+	case http.StatusForbidden:
+		return errCodeForbidden
+	case http.StatusNotFound:
+		return errCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return errCodeMethodNotAllowed
+	case http.StatusConflict:
+		return errCodeConflict
+	case http.StatusGone:
+		return errCodeGone
+	case http.StatusRequestEntityTooLarge:
+		return errCodePayloadTooLarge
+	case http.StatusUnprocessableEntity:
+		return errCodeUnprocessable
+	case http.StatusTooManyRequests:
+		return errCodeRateLimited
+	case http.StatusBadGateway:
+		return errCodeBadGateway
+	case http.StatusServiceUnavailable:
+		return errCodeUnavailable
+	}
+	if status >= 500 {
+		return errCodeInternal
+	}
+	return errCodeBadRequest
+}
+
+// respondError writes an error with the generic code for status.
+func respondError(w http.ResponseWriter, status int, message string) {
+	respondErrorDetails(w, status, errorCodeForStatus(status), message, nil)
+}
+
+func respondErrorCode(w http.ResponseWriter, status int, code, message string) {
+	respondErrorDetails(w, status, code, message, nil)
+}
+
+// respondErrorDetails writes the error envelope. The request ID is read back
+// from the response header withRequestID set, so helpers without the request
+// at hand still fill it in.
+func respondErrorDetails(w http.ResponseWriter, status int, code, message string, details map[string]any) {
+	if code == "" {
+		code = errorCodeForStatus(status)
+	}
+	respondJSON(w, status, apiError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: w.Header().Get(requestIDHeader),
+	})
+}
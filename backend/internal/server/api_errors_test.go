@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRespondErrorWritesEnvelope(t *testing.T) {
+	t.Parallel()
+
+	var recorder *httptest.ResponseRecorder
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		recorder = w.(*httptest.ResponseRecorder)
+		respondErrorDetails(w, http.StatusConflict, "", "room is full", map[string]any{"roomId": 7})
+	}))
+	request := httptest.NewRequest(http.MethodGet, "/api/rooms", nil)
+	request.Header.Set(requestIDHeader, "req-123")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	payload := decodeBodyMap(t, recorder)
+	details, _ := payload["details"].(map[string]any)
+	if payload["code"] != errCodeConflict || payload["message"] != "room is full" || payload["requestId"] != "req-123" || details["roomId"] != float64(7) {
+		t.Fatalf("unexpected payload: %#v", payload)
+	}
+}
+
+func TestErrorCodeForStatus(t *testing.T) {
+	t.Parallel()
+
+	for status, want := range map[int]string{
+		http.StatusBadRequest:          errCodeBadRequest,
+		http.StatusNotFound:            errCodeNotFound,
+		http.StatusTooManyRequests:     errCodeRateLimited,
+		http.StatusInternalServerError: errCodeInternal,
+		http.StatusGatewayTimeout:      errCodeInternal,
+	} {
+		if got := errorCodeForStatus(status); got != want {
+			t.Fatalf("status %d: expected %q, got %q", status, want, got)
+		}
+	}
+}
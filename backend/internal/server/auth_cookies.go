@@ -100,6 +100,24 @@ func authTokenFromRequest(r *http.Request) (token string, source string) {
 	return cookieToken, "cookie"
 }
 
+// wsAuthTokenFromRequest resolves the token for a WebSocket upgrade. Native
+// clients send it in the Authorization header or as a subprotocol; browsers
+// fall back to the session cookie.
+func wsAuthTokenFromRequest(r *http.Request) (string, error) {
+	token, source := authTokenFromRequest(r)
+	if source == "bearer" {
+		return token, nil
+	}
+	subprotocolToken, err := wsSubprotocolToken(r)
+	if err != nil {
+		return "", err
+	}
+	if subprotocolToken != "" {
+		return subprotocolToken, nil
+	}
+	return token, nil
+}
+
 func refreshTokenFromRequest(r *http.Request) string {
 	cookie, err := r.Cookie(refreshCookieName)
 	if err != nil {
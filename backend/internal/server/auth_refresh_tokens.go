@@ -147,18 +147,23 @@ func (a *App) rotateRefreshToken(ctx context.Context, presentedToken string) (Au
 
 	var username string
 	var role string
+	var grant roleGrant
+	var suspended bool
 	err = tx.QueryRowContext(
 		ctx,
-		`SELECT username, role FROM users WHERE id = $1`,
+		`SELECT u.username, u.role, COALESCE(u.custom_role, ''), COALESCE(r.version, 0), u.suspended_at IS NOT NULL OR u.deleted_at IS NOT NULL
+		   FROM users u
+		   LEFT JOIN roles r ON r.name = u.custom_role
+		  WHERE u.id = $1`,
 		userID,
-	).Scan(&username, &role)
+	).Scan(&username, &role, &grant.Name, &grant.Version, &suspended)
 	if errors.Is(err, sql.ErrNoRows) {
 		return AuthContext{}, "", errRefreshTokenInvalid
 	}
 	if err != nil {
 		return AuthContext{}, "", err
 	}
-	if role != "admin" && role != "user" {
+	if (role != "admin" && role != "user") || suspended {
 		return AuthContext{}, "", errRefreshTokenInvalid
 	}
 
@@ -225,6 +230,8 @@ func (a *App) rotateRefreshToken(ctx context.Context, presentedToken string) (Au
 		UserID:               userID,
 		Username:             username,
 		Role:                 role,
+		CustomRole:           grant.Name,
+		RoleVersion:          grant.Version,
 		DeviceID:             deviceID,
 		DeviceName:           deviceName,
 		DeviceSessionVersion: currentDeviceSessionVersion,
@@ -14,6 +14,9 @@ import (
 
 const (
 	defaultInviteTTL = 72 * time.Hour
+
+	inviteTypeRoomJoin  = "room_join"
+	inviteTypeRoomGuest = "room_guest"
 )
 
 type InviteClaims struct {
@@ -23,13 +26,15 @@ type InviteClaims struct {
 	jwt.RegisteredClaims
 }
 
-func (a *App) issueToken(userID int64, username, role, deviceID string, deviceSessionVersion int) (string, error) {
+func (a *App) issueToken(userID int64, username, role string, grant roleGrant, deviceID string, deviceSessionVersion int) (string, error) {
 	now := time.Now().UTC()
 	ttl := a.effectiveAccessTokenTTL()
 	claims := Claims{
 		UserID:               userID,
 		Username:             username,
 		Role:                 role,
+		CustomRole:           grant.Name,
+		RoleVersion:          grant.Version,
 		DeviceID:             deviceID,
 		DeviceSessionVersion: deviceSessionVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -75,12 +80,18 @@ func (a *App) parseToken(tokenString string) (*Claims, error) {
 }
 
 func (a *App) issueInviteToken(roomID, createdBy int64) (string, time.Time, error) {
+	return a.issueRoomToken(roomID, createdBy, inviteTypeRoomJoin, defaultInviteTTL)
+}
+
+// issueRoomToken signs a room-scoped token. inviteType keeps a guest link
+// from being redeemed as a join invite and the other way around.
+func (a *App) issueRoomToken(roomID, createdBy int64, inviteType string, ttl time.Duration) (string, time.Time, error) {
 	now := time.Now().UTC()
-	expiresAt := now.Add(defaultInviteTTL)
+	expiresAt := now.Add(ttl)
 	claims := InviteClaims{
 		RoomID:     roomID,
 		CreatedBy:  createdBy,
-		InviteType: "room_join",
+		InviteType: inviteType,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    "e2ee-chat-backend",
 			Subject:   strconv.FormatInt(roomID, 10),
@@ -97,6 +108,10 @@ func (a *App) issueInviteToken(roomID, createdBy int64) (string, time.Time, erro
 }
 
 func (a *App) parseInviteToken(tokenString string) (*InviteClaims, error) {
+	return a.parseRoomToken(tokenString, inviteTypeRoomJoin)
+}
+
+func (a *App) parseRoomToken(tokenString string, inviteType string) (*InviteClaims, error) {
 	claims := &InviteClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -107,7 +122,7 @@ func (a *App) parseInviteToken(tokenString string) (*InviteClaims, error) {
 	if err != nil || !token.Valid {
 		return nil, errors.New("invalid invite token")
 	}
-	if claims.RoomID <= 0 || claims.InviteType != "room_join" {
+	if claims.RoomID <= 0 || claims.InviteType != inviteType {
 		return nil, errors.New("invalid invite token claims")
 	}
 	if claims.ExpiresAt == nil || claims.ExpiresAt.Time.Before(time.Now().UTC()) {
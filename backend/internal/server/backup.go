@@ -0,0 +1,382 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	backupFormat          = "e2ee-chat-message-backup"
+	backupFormatVersion   = 1
+	backupKeyLayout       = "20060102T150405Z"
+	backupMaxObjectBytes  = 2 << 30
+	backupTimeout         = 10 * time.Minute
+	backupAdvisoryLockKey = 0x6d736762 // "msgb"
+)
+
+// backupTables lists what a snapshot carries, in an order that satisfies
+// foreign keys on restore: accounts and rooms, their content and keys, and
+// the audit log and integration settings admins configured.
+var backupTables = []string{
+	"roles",
+	"users",
+	"rooms",
+	"room_members",
+	"messages",
+	"message_revisions",
+	"message_mentions",
+	"user_devices",
+	"signal_device_identity_keys",
+	"signal_device_identity_key_history",
+	"signal_device_signed_prekeys",
+	"signal_device_one_time_prekeys",
+	"bot_tokens",
+	"ip_denylist",
+	"message_reports",
+	"user_blocks",
+	"poll_votes",
+	"identity_verifications",
+	"account_key_backups",
+	"room_sender_keys",
+	"room_sender_key_recipients",
+	"room_device_keys",
+	"signal_prekey_consumptions",
+	"pending_dr_handshakes",
+	"user_quotas",
+	"user_quota_usage",
+	"room_join_requests",
+	"admin_audit_log",
+	"admin_invitations",
+	"admin_broadcasts",
+	"feature_flags",
+	"policy_versions",
+	"room_webhooks",
+	"federation_peers",
+	"federation_room_links",
+	"federation_inbound",
+}
+
+// backupDerivedTables are emptied on restore and refilled by triggers as
+// messages are reinserted.
+var backupDerivedTables = []string{
+	"room_message_stats",
+	"room_sender_message_stats",
+}
+
+// backupClearedTables hold sessions, one-time tokens, delivery queues and the
+// sync log. They are not carried in a snapshot and are emptied on restore,
+// since their rows point at state the snapshot replaces.
+var backupClearedTables = []string{
+	"auth_refresh_tokens",
+	"account_onboarding_tokens",
+	"password_reset_requests",
+	"events",
+	"undelivered_events",
+	"email_outbox",
+	"webhook_deliveries",
+	"federation_outbox",
+	"decrypt_recovery_requests",
+	"history_backfills",
+	"history_backfill_keys",
+}
+
+var (
+	errBackupInProgress    = errors.New("another backup is already running")
+	errBackupInvalidFormat = errors.New("object is not a message backup")
+	errBackupSchemaChanged = errors.New("backup schema version does not match the database")
+)
+
+type backupConfig struct {
+	S3       s3Config
+	Prefix   string
+	Interval time.Duration
+}
+
+type backupSnapshot struct {
+	Format        string                     `json:"format"`
+	FormatVersion int                        `json:"formatVersion"`
+	SchemaVersion int64                      `json:"schemaVersion"`
+	CreatedAt     string                     `json:"createdAt"`
+	Tables        map[string]json.RawMessage `json:"tables"`
+}
+
+type backupResult struct {
+	Key       string           `json:"key"`
+	SizeBytes int              `json:"sizeBytes"`
+	Rows      map[string]int64 `json:"rows"`
+	CreatedAt string           `json:"createdAt"`
+}
+
+// backupService snapshots the message store to S3-compatible storage. A
+// Postgres advisory lock keeps replicas from running backups concurrently.
+type backupService struct {
+	db     *sql.DB
+	store  *s3Client
+	prefix string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newBackupService(db *sql.DB, cfg backupConfig) *backupService {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backupService{
+		db:     db,
+		store:  newS3Client(cfg.S3),
+		prefix: normalizeBackupPrefix(cfg.Prefix),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+func normalizeBackupPrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+func (s *backupService) objectKey(now time.Time) string {
+	return s.prefix + "message-backup-" + now.UTC().Format(backupKeyLayout) + ".json.gz"
+}
+
+// StartSchedule runs Create every interval until Stop. Stop cancels a backup
+// that is still uploading.
+func (s *backupService) StartSchedule(interval time.Duration) {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(s.ctx, backupTimeout)
+			result, err := s.Create(ctx)
+			cancel()
+			switch {
+			case errors.Is(err, errBackupInProgress):
+				logger.Info("message_backup_skipped", "reason", err.Error())
+			case err != nil:
+				logger.Error("message_backup_failed", "error", err)
+			default:
+				logger.Info("message_backup_completed", "key", result.Key, "size_bytes", result.SizeBytes)
+			}
+		}
+	}()
+}
+
+// Stop must only be called after StartSchedule.
+func (s *backupService) Stop() {
+	s.cancel()
+	<-s.done
+}
+
+func (s *backupService) Create(ctx context.Context) (backupResult, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return backupResult{}, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, backupAdvisoryLockKey).Scan(&locked); err != nil {
+		return backupResult{}, err
+	}
+	if !locked {
+		return backupResult{}, errBackupInProgress
+	}
+
+	schemaVersion, err := currentSchemaVersion(ctx, tx)
+	if err != nil {
+		return backupResult{}, err
+	}
+	now := time.Now().UTC()
+	snapshot := backupSnapshot{
+		Format:        backupFormat,
+		FormatVersion: backupFormatVersion,
+		SchemaVersion: schemaVersion,
+		CreatedAt:     now.Format(time.RFC3339Nano),
+		Tables:        make(map[string]json.RawMessage, len(backupTables)),
+	}
+	rows := make(map[string]int64, len(backupTables))
+	for _, table := range backupTables {
+		var data []byte
+		var count int64
+		// Table names come from the fixed list above, never from input.
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf(
+			`SELECT COALESCE(json_agg(t), '[]'::json), COUNT(*) FROM %s t`, table,
+		)).Scan(&data, &count); err != nil {
+			return backupResult{}, fmt.Errorf("export %s: %w", table, err)
+		}
+		snapshot.Tables[table] = data
+		rows[table] = count
+	}
+	if err := tx.Commit(); err != nil {
+		return backupResult{}, err
+	}
+
+	encoded, err := encodeBackupSnapshot(snapshot)
+	if err != nil {
+		return backupResult{}, err
+	}
+	key := s.objectKey(now)
+	if err := s.store.PutObject(ctx, key, encoded, "application/gzip"); err != nil {
+		return backupResult{}, err
+	}
+	return backupResult{
+		Key:       key,
+		SizeBytes: len(encoded),
+		Rows:      rows,
+		CreatedAt: snapshot.CreatedAt,
+	}, nil
+}
+
+func (s *backupService) List(ctx context.Context) ([]s3Object, error) {
+	return s.store.ListObjects(ctx, s.prefix+"message-backup-")
+}
+
+// Restore replaces the backed-up tables with the snapshot stored at key. The
+// snapshot must come from the schema version the database is on now.
+func (s *backupService) Restore(ctx context.Context, key string) (map[string]int64, error) {
+	if !strings.HasPrefix(key, s.prefix+"message-backup-") {
+		return nil, errBackupInvalidFormat
+	}
+	encoded, err := s.store.GetObject(ctx, key, backupMaxObjectBytes)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := decodeBackupSnapshot(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, backupAdvisoryLockKey).Scan(&locked); err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, errBackupInProgress
+	}
+	schemaVersion, err := currentSchemaVersion(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.SchemaVersion != schemaVersion {
+		return nil, fmt.Errorf("%w: backup %d, database %d", errBackupSchemaChanged, snapshot.SchemaVersion, schemaVersion)
+	}
+
+	// No CASCADE: a table that references these but is missing from the
+	// lists makes the restore fail instead of being emptied unnoticed.
+	truncated := append(append(append([]string(nil), backupTables...), backupDerivedTables...), backupClearedTables...)
+	if _, err := tx.ExecContext(ctx, `TRUNCATE `+strings.Join(truncated, ", ")); err != nil {
+		return nil, fmt.Errorf("truncate tables: %w", err)
+	}
+	rows := make(map[string]int64, len(backupTables))
+	for _, table := range backupTables {
+		result, err := tx.ExecContext(ctx, fmt.Sprintf(
+			`INSERT INTO %[1]s SELECT * FROM json_populate_recordset(NULL::%[1]s, $1::json)`, table,
+		), []byte(snapshot.Tables[table]))
+		if err != nil {
+			return nil, fmt.Errorf("restore %s: %w", table, err)
+		}
+		rows[table], _ = result.RowsAffected()
+		if err := resetSerialSequence(ctx, tx, table); err != nil {
+			return nil, fmt.Errorf("reset %s sequence: %w", table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// resetSerialSequence moves a table's id sequence past the restored rows so
+// new inserts do not collide with them.
+func resetSerialSequence(ctx context.Context, tx *sql.Tx, table string) error {
+	var sequence sql.NullString
+	err := tx.QueryRowContext(ctx, `
+SELECT pg_get_serial_sequence(c.table_name, c.column_name)
+FROM information_schema.columns c
+WHERE c.table_schema = current_schema() AND c.table_name = $1 AND c.column_name = 'id'
+`, table).Scan(&sequence)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !sequence.Valid) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(
+		`SELECT setval($1, COALESCE((SELECT MAX(id) FROM %s), 0) + 1, false)`, table,
+	), sequence.String)
+	return err
+}
+
+func currentSchemaVersion(ctx context.Context, exec sqlQueryer) (int64, error) {
+	var version int64
+	var dirty bool
+	if err := exec.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty); err != nil {
+		return 0, fmt.Errorf("load schema version: %w", err)
+	}
+	if dirty {
+		return 0, errors.New("schema migrations are dirty")
+	}
+	return version, nil
+}
+
+type sqlQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func encodeBackupSnapshot(snapshot backupSnapshot) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if err := json.NewEncoder(writer).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeBackupSnapshot(encoded []byte) (backupSnapshot, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(encoded))
+	if err != nil {
+		return backupSnapshot{}, fmt.Errorf("%w: %v", errBackupInvalidFormat, err)
+	}
+	defer reader.Close()
+
+	var snapshot backupSnapshot
+	if err := json.NewDecoder(io.LimitReader(reader, backupMaxObjectBytes*4)).Decode(&snapshot); err != nil {
+		return backupSnapshot{}, fmt.Errorf("%w: %v", errBackupInvalidFormat, err)
+	}
+	if snapshot.Format != backupFormat || snapshot.FormatVersion != backupFormatVersion {
+		return backupSnapshot{}, errBackupInvalidFormat
+	}
+	for _, table := range backupTables {
+		if len(snapshot.Tables[table]) == 0 {
+			return backupSnapshot{}, fmt.Errorf("%w: missing table %s", errBackupInvalidFormat, table)
+		}
+	}
+	return snapshot, nil
+}
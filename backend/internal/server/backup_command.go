@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// RunMessageBackup implements the `message-backup` subcommand. With no flags
+// it writes one snapshot; -list prints stored snapshots and -restore KEY
+// loads one into the configured database.
+func RunMessageBackup(args []string) int {
+	flags := flag.NewFlagSet("message-backup", flag.ContinueOnError)
+	list := flags.Bool("list", false, "list stored backups")
+	restoreKey := flags.String("restore", "", "restore the backup stored under this key")
+	confirm := flags.Bool("yes", false, "confirm a destructive restore")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := loadRuntimeConfig()
+	if err != nil {
+		logger.Error("load runtime config failed", "error", err)
+		return 1
+	}
+	configureLogging(cfg.Logging)
+	if !cfg.Backup.S3.configured() {
+		logger.Error("BACKUP_S3_BUCKET is not configured")
+		return 1
+	}
+	db, err := openDatabase(cfg.DBURL)
+	if err != nil {
+		logger.Error("open database failed", "error", err)
+		return 1
+	}
+	defer db.Close()
+	cfg.DBPool.apply(db)
+	if err := waitForDB(db, 30*time.Second); err != nil {
+		logger.Error("database not ready", "error", err)
+		return 1
+	}
+
+	service := newBackupService(db, cfg.Backup)
+	ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+	defer cancel()
+
+	var result any
+	switch {
+	case *list:
+		result, err = service.List(ctx)
+	case *restoreKey != "":
+		if !*confirm {
+			logger.Error("restore replaces all messages and keys; pass -yes to confirm")
+			return 2
+		}
+		result, err = service.Restore(ctx, *restoreKey)
+	default:
+		result, err = service.Create(ctx)
+	}
+	if err != nil {
+		logger.Error("message_backup_command_failed", "error", err)
+		return 1
+	}
+	return printJSON(os.Stdout, result)
+}
+
+func printJSON(out io.Writer, value any) int {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
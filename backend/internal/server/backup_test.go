@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupSnapshotRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	snapshot := backupSnapshot{
+		Format:        backupFormat,
+		FormatVersion: backupFormatVersion,
+		SchemaVersion: 14,
+		CreatedAt:     "2024-01-02T03:04:05Z",
+		Tables:        make(map[string]json.RawMessage),
+	}
+	for _, table := range backupTables {
+		snapshot.Tables[table] = json.RawMessage(`[]`)
+	}
+	snapshot.Tables["users"] = json.RawMessage(`[{"id":1,"username":"alice"}]`)
+
+	encoded, err := encodeBackupSnapshot(snapshot)
+	if err != nil {
+		t.Fatalf("encode snapshot: %v", err)
+	}
+	decoded, err := decodeBackupSnapshot(encoded)
+	if err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	if decoded.SchemaVersion != 14 || string(decoded.Tables["users"]) != `[{"id":1,"username":"alice"}]` {
+		t.Fatalf("unexpected decoded snapshot: %#v", decoded)
+	}
+
+	delete(snapshot.Tables, "messages")
+	encoded, err = encodeBackupSnapshot(snapshot)
+	if err != nil {
+		t.Fatalf("encode snapshot: %v", err)
+	}
+	if _, err := decodeBackupSnapshot(encoded); !errors.Is(err, errBackupInvalidFormat) {
+		t.Fatalf("expected missing table to be rejected, got %v", err)
+	}
+	if _, err := decodeBackupSnapshot([]byte("not gzip")); !errors.Is(err, errBackupInvalidFormat) {
+		t.Fatalf("expected garbage to be rejected, got %v", err)
+	}
+}
+
+func TestBackupObjectKey(t *testing.T) {
+	t.Parallel()
+
+	service := &backupService{prefix: normalizeBackupPrefix(" /nightly/ ")}
+	key := service.objectKey(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if key != "nightly/message-backup-20240102T030405Z.json.gz" {
+		t.Fatalf("unexpected key: %s", key)
+	}
+	if normalizeBackupPrefix("") != "" {
+		t.Fatalf("expected empty prefix to stay empty")
+	}
+}
+
+func TestBackupHandlersWithoutDB(t *testing.T) {
+	t.Parallel()
+
+	admin := AuthContext{UserID: 1, Username: "admin", Role: "admin"}
+
+	t.Run("disabled", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/admin/backups", nil)
+		response := httptest.NewRecorder()
+
+		(&App{}).handleAdminBackups(response, request, admin)
+
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("expected %d, got %d", http.StatusNotFound, response.Code)
+		}
+	})
+
+	app := &App{backups: &backupService{}}
+
+	t.Run("restore wrong method", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/admin/backups/restore", nil)
+		response := httptest.NewRecorder()
+
+		app.handleAdminBackupRestore(response, request, admin)
+
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, response.Code)
+		}
+	})
+
+	t.Run("restore requires confirmation", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/admin/backups/restore", strings.NewReader(`{"key":"message-backup-1.json.gz"}`))
+		response := httptest.NewRecorder()
+
+		app.handleAdminBackupRestore(response, request, admin)
+
+		if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "confirmation_required") {
+			t.Fatalf("unexpected response %d %s", response.Code, response.Body.String())
+		}
+	})
+}
+
+// TestBackupTablesCoverSchema fails when a migration adds a table that is
+// neither backed up nor explicitly derived or cleared on restore, and when
+// the backup order would break a foreign key.
+func TestBackupTablesCoverSchema(t *testing.T) {
+	t.Parallel()
+
+	files, err := fs.Glob(migrationsFS, "migrations/*.up.sql")
+	if err != nil {
+		t.Fatalf("list migrations: %v", err)
+	}
+	statement := regexp.MustCompile(`(?i)\b(CREATE|DROP)\s+TABLE\s+(?:IF\s+(?:NOT\s+)?EXISTS\s+)?(\w+)`)
+	tables := map[string]bool{}
+	for _, file := range files {
+		data, err := fs.ReadFile(migrationsFS, file)
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		for _, match := range statement.FindAllStringSubmatch(string(data), -1) {
+			tables[strings.ToLower(match[2])] = strings.EqualFold(match[1], "CREATE")
+		}
+	}
+
+	listed := map[string]string{}
+	for name, list := range map[string][]string{
+		"backupTables":        backupTables,
+		"backupDerivedTables": backupDerivedTables,
+		"backupClearedTables": backupClearedTables,
+	} {
+		for _, table := range list {
+			if other, ok := listed[table]; ok {
+				t.Errorf("%s is in both %s and %s", table, other, name)
+			}
+			listed[table] = name
+			if !tables[table] {
+				t.Errorf("%s lists %s, which no migration creates", name, table)
+			}
+		}
+	}
+	for table, exists := range tables {
+		if _, ok := listed[table]; exists && !ok {
+			t.Errorf("table %s is neither backed up nor derived or cleared on restore", table)
+		}
+	}
+
+	db := openSQLiteTestDB(t)
+	for i, table := range backupTables {
+		rows, err := db.QueryContext(context.Background(), `SELECT "table" FROM pragma_foreign_key_list($1)`, table)
+		if err != nil {
+			t.Fatalf("load %s foreign keys: %v", table, err)
+		}
+		for rows.Next() {
+			var parent string
+			if err := rows.Scan(&parent); err != nil {
+				t.Fatalf("scan %s foreign key: %v", table, err)
+			}
+			if index := slices.Index(backupTables, parent); index < 0 || index > i {
+				t.Errorf("%s references %s, which is not restored before it", table, parent)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatalf("close %s foreign keys: %v", table, err)
+		}
+	}
+}
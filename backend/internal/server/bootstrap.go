@@ -22,6 +22,7 @@ func Run() {
 	if err != nil {
 		fatalLog("load runtime config failed", "error", err)
 	}
+	configureLogging(cfg.Logging)
 
 	if cfg.AdminPasswordHash == "" {
 		fatalLog("admin password hash must not be empty")
@@ -30,15 +31,13 @@ func Run() {
 		fatalLog("invalid admin password hash", "error", err)
 	}
 
-	db, err := sql.Open("pgx", cfg.DBURL)
+	db, err := openDatabase(cfg.DBURL)
 	if err != nil {
 		fatalLog("open database failed", "error", err)
 	}
 	defer db.Close()
 
-	db.SetConnMaxLifetime(30 * time.Minute)
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(10)
+	cfg.DBPool.apply(db)
 
 	if err := waitForDB(db, 30*time.Second); err != nil {
 		fatalLog("database not ready", "error", err)
@@ -47,61 +46,90 @@ func Run() {
 	if err := runMigrations(db); err != nil {
 		fatalLog("run migrations failed", "error", err)
 	}
-	if err := bootstrapAdminSecurity(db, cfg.AdminUsername, cfg.AdminPasswordHash, cfg.AdminRoomName); err != nil {
+	if err := bootstrapAdminSecurity(db, cfg.AdminUsername, cfg.AdminUsernames, cfg.AdminPasswordHash, cfg.AdminRoomName); err != nil {
 		fatalLog("bootstrap admin security failed", "error", err)
 	}
 
-	app := &App{
-		db:                db,
-		hub:               NewHub(),
-		jwtSecret:         []byte(cfg.JWTSecret),
-		accessTokenTTL:    cfg.AccessTokenTTL,
-		refreshTokenTTL:   cfg.RefreshTokenTTL,
-		corsOrigin:        cfg.CORSOrigin,
-		adminUsername:     cfg.AdminUsername,
-		trustProxyHeaders: cfg.TrustProxyHeaders,
-		loginIPLimiter:    newKeyedRateLimiter(perMinuteLimit(cfg.LoginIPRatePerMinute), cfg.LoginIPRateBurst, defaultRateLimitEntryTTL),
-		loginUserLimiter:  newKeyedRateLimiter(perMinuteLimit(cfg.LoginUserRatePerMinute), cfg.LoginUserRateBurst, defaultRateLimitEntryTTL),
-		wsConnectLimiter:  newKeyedRateLimiter(perMinuteLimit(cfg.WSConnectRatePerMinute), cfg.WSConnectRateBurst, defaultRateLimitEntryTTL),
-		upgrader: websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				if cfg.CORSOrigin == "*" {
-					return true
-				}
-				origin := r.Header.Get("Origin")
-				return origin == "" || origin == cfg.CORSOrigin
-			},
-		},
+	app := newApp(db, cfg)
+	if err := app.ipDenylist.Start(context.Background()); err != nil {
+		fatalLog("load ip denylist failed", "error", err)
+	}
+	defer app.ipDenylist.Stop()
+	if err := app.features.Start(context.Background()); err != nil {
+		fatalLog("load feature flags failed", "error", err)
+	}
+	defer app.features.Stop()
+	if err := app.hub.blocks.Load(context.Background(), db); err != nil {
+		fatalLog("load user blocks failed", "error", err)
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", app.handleHealth)
-	mux.HandleFunc("/api/register", app.handleRegister)
-	mux.HandleFunc("/api/login", app.handleLogin)
-	mux.HandleFunc("/api/logout", app.handleLogout)
-	mux.HandleFunc("/api/refresh", app.handleRefresh)
-	mux.HandleFunc("/api/session", app.withAuth(app.handleSession))
-	mux.HandleFunc("/api/admin/users", app.withAuth(app.withAdmin(app.handleAdminUsers)))
-	mux.HandleFunc("/api/admin/users/", app.withAuth(app.withAdmin(app.handleAdminUserSubroutes)))
-	mux.HandleFunc("/api/rooms", app.withAuth(app.handleRooms))
-	mux.HandleFunc("/api/rooms/", app.withAuth(app.handleRoomSubroutes))
-	mux.HandleFunc("/api/devices", app.withAuth(app.handleDevices))
-	mux.HandleFunc("/api/devices/", app.withAuth(app.handleDeviceSubroutes))
-	mux.HandleFunc("/api/signal/prekey-bundle", app.withAuth(app.handleSignalPreKeyBundle))
-	mux.HandleFunc("/api/signal/prekey-bundle/", app.withAuth(app.handleSignalPreKeyBundleSubroutes))
-	mux.HandleFunc("/api/signal/safety-number/", app.withAuth(app.handleSignalSafetyNumberSubroutes))
-	mux.HandleFunc("/api/invites/join", app.withAuth(app.handleInviteJoin))
-	mux.HandleFunc("/ws", app.handleWS)
-
-	handler := loggingMiddleware(app.withSecurityHeaders(app.withCORS(mux)))
+	if cfg.DBReplicaURL != "" {
+		replicaDB, err := sql.Open("pgx", cfg.DBReplicaURL)
+		if err != nil {
+			fatalLog("open replica database failed", "error", err)
+		}
+		defer replicaDB.Close()
+		cfg.DBPool.apply(replicaDB)
+		app.replica = newReplicaRouter(replicaDB)
+		app.replica.Start(cfg.ReplicaHealthInterval)
+		defer app.replica.Stop()
+	}
+	if cfg.Tracing.enabled() {
+		app.tracer = newTracer(cfg.Tracing)
+		app.tracer.Start()
+		defer app.tracer.Stop()
+	}
+	app.preKeyHygiene = newPreKeyHygieneJob(db, cfg.PreKeyHygiene)
+	app.preKeyHygiene.Start()
+	defer app.preKeyHygiene.Stop()
+	erasure := newAccountErasureJob(app)
+	erasure.Start()
+	defer erasure.Stop()
+	app.webhooks = newWebhookDispatcher(db)
+	app.webhooks.Start()
+	defer app.webhooks.Stop()
+	if cfg.SMTP.configured() {
+		app.appBaseURL = cfg.SMTP.BaseURL
+		app.mail = newEmailDispatcher(db, smtpSender{cfg: cfg.SMTP})
+		app.mail.Start()
+		defer app.mail.Stop()
+	}
+	if cfg.MQTT.enabled() {
+		bridge := newMQTTBridge(db, cfg.MQTT)
+		bridge.Start()
+		defer bridge.Stop()
+	}
+	if cfg.FederationServerID != "" {
+		app.federation = newFederationRelay(db, cfg.FederationServerID)
+		app.federation.Start()
+		defer app.federation.Stop()
+	}
+	if cfg.Backup.S3.configured() {
+		app.backups = newBackupService(db, cfg.Backup)
+		if cfg.Backup.Interval > 0 {
+			app.backups.StartSchedule(cfg.Backup.Interval)
+			defer app.backups.Stop()
+		}
+	}
+	app.messages = newMessagePipeline(app.flushMessageBatch, cfg.MessageBatchSize, cfg.MessageBatchMaxLatency)
+	defer app.messages.Close()
+
 	server := &http.Server{
 		Addr:              cfg.Addr,
-		Handler:           handler,
+		Handler:           app.routes(),
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
+	var gateway *tcpGateway
+	if cfg.TCPGateway.enabled() {
+		gateway, err = newTCPGateway(app, cfg.TCPGateway)
+		if err != nil {
+			fatalLog("start tcp gateway failed", "error", err)
+		}
+		gateway.Start()
+		logger.Info("tcp_gateway_started", "addr", gateway.Addr().String())
+	}
+
 	serverErr := make(chan error, 1)
 	go func() {
 		serverErr <- server.ListenAndServe()
@@ -111,6 +139,15 @@ func Run() {
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
 	defer signal.Stop(signalCh)
 
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
+	defer signal.Stop(reloadCh)
+	go func() {
+		for range reloadCh {
+			logConfigReload(app.reloadConfig())
+		}
+	}()
+
 	logger.Info(
 		"backend_started",
 		"addr",
@@ -123,6 +160,19 @@ func Run() {
 		int64(app.effectiveRefreshTokenTTL().Seconds()),
 	)
 
+	defer func() {
+		stats := app.membership.Stats()
+		logger.Info(
+			"membership_cache_stats",
+			"hits",
+			stats.Hits,
+			"misses",
+			stats.Misses,
+			"hit_rate",
+			stats.HitRate,
+		)
+	}()
+
 	select {
 	case err := <-serverErr:
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -130,6 +180,14 @@ func Run() {
 		}
 	case sig := <-signalCh:
 		logger.Info("shutdown_signal_received", "signal", sig.String())
+		// SIGTERM is what orchestrators send on deploys; give clients time to
+		// move before sockets close. SIGINT stays immediate for local use.
+		if gateway != nil {
+			gateway.Stop()
+		}
+		if sig == syscall.SIGTERM {
+			app.drainConnections(app.effectiveWSDrainWindow(), cfg.GracefulShutdownTimeout)
+		}
 		if err := gracefulShutdown(server, app.hub, cfg.GracefulShutdownTimeout); err != nil {
 			logger.Error("graceful_shutdown_failed", "error", err)
 		}
@@ -139,6 +197,138 @@ func Run() {
 	}
 }
 
+// newApp builds the App from its configuration. Background workers are
+// started by the caller.
+func newApp(db *sql.DB, cfg runtimeConfig) *App {
+	app := &App{
+		db:                db,
+		hub:               NewHub(),
+		membership:        newMembershipCache(defaultMembershipCacheTTL),
+		jwtSecret:         []byte(cfg.JWTSecret),
+		accessTokenTTL:    cfg.AccessTokenTTL,
+		refreshTokenTTL:   cfg.RefreshTokenTTL,
+		corsOrigin:        cfg.CORSOrigin,
+		adminUsername:     cfg.AdminUsername,
+		adminUsernames:    cfg.AdminUsernames,
+		trustProxyHeaders: cfg.TrustProxyHeaders,
+		loginIPLimiter:    newKeyedRateLimiter(perMinuteLimit(cfg.LoginIPRatePerMinute), cfg.LoginIPRateBurst, defaultRateLimitEntryTTL),
+		loginUserLimiter:  newKeyedRateLimiter(perMinuteLimit(cfg.LoginUserRatePerMinute), cfg.LoginUserRateBurst, defaultRateLimitEntryTTL),
+		wsConnectLimiter:  newKeyedRateLimiter(perMinuteLimit(cfg.WSConnectRatePerMinute), cfg.WSConnectRateBurst, defaultRateLimitEntryTTL),
+		routeLimiters:     newRouteRateLimiters(cfg.RouteRateLimits),
+		adminAllowlist:    cfg.AdminIPAllowlist,
+		ipDenylist:        newIPDenylist(db),
+		features:          newFeatureFlags(db),
+		wsCompression:     cfg.WSCompression,
+		wsLimits:          cfg.WSLimits,
+		roomLimits:        cfg.RoomLimits,
+		signatureReplay:   newSignatureReplayGuard(cfg.SignatureMaxSkew),
+		keyEpochGrace:     cfg.KeyEpochGrace,
+		wsDrainWindow:     cfg.WSDrainWindow,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			EnableCompression: cfg.WSCompression.Enabled,
+			Subprotocols:      []string{wsSubprotocolJSON, wsSubprotocolCBOR},
+		},
+		preKeyFetchesPerTargetHour: cfg.PreKeyFetchesPerHour,
+		drHandshakeTTL:             cfg.DRHandshakeTTL,
+		dailyMessageLimit:          cfg.DailyMessageLimit,
+		storageQuotaBytes:          int64(cfg.StorageQuotaMB) << 20,
+		usernameHoldPeriod:         cfg.UsernameHold,
+		policyVersion:              cfg.PolicyVersion,
+		accountErasureGracePeriod:  cfg.AccountErasureGrace,
+		loginChallenge:             newLoginChallenge(cfg.LoginChallenge, []byte(cfg.JWTSecret)),
+	}
+	app.upgrader.CheckOrigin = app.checkWSOrigin
+	if cfg.MaintenanceMode {
+		app.maintenance.Set(true, cfg.MaintenanceReason)
+		logger.Warn("maintenance_mode_enabled", "reason", cfg.MaintenanceReason)
+	}
+	return app
+}
+
+// routes registers every endpoint and wraps the mux in the middleware chain.
+func (a *App) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", a.handleHealth)
+	mux.HandleFunc("/livez", a.handleLivez)
+	mux.HandleFunc("/readyz", a.handleReadyz)
+	mux.HandleFunc("/api/register", a.handleRegister)
+	mux.HandleFunc("/api/login", a.handleLogin)
+	mux.HandleFunc("/api/logout", a.handleLogout)
+	mux.HandleFunc("/api/refresh", a.handleRefresh)
+	mux.HandleFunc("/api/session", a.withAuth(a.handleSession))
+	mux.HandleFunc("/api/features", a.handleFeatures)
+	mux.HandleFunc("/api/admin/users", a.withAuth(a.withPermission(permManageUsers, a.handleAdminUsers)))
+	mux.HandleFunc("/api/admin/users/", a.withAuth(a.withPermission(permManageUsers, a.handleAdminUserSubroutes)))
+	mux.HandleFunc("/api/admin/stats", a.withAuth(a.withPermission(permViewStats, a.handleAdminStats)))
+	mux.HandleFunc("/api/admin/connections", a.withAuth(a.withPermission(permManageUsers, a.handleAdminConnections)))
+	mux.HandleFunc("/api/admin/connections/", a.withAuth(a.withPermission(permManageUsers, a.handleAdminConnectionSubroutes)))
+	mux.HandleFunc("/api/admin/roles", a.withAuth(a.withPermission(permManageRoles, a.handleAdminRoles)))
+	mux.HandleFunc("/api/admin/roles/", a.withAuth(a.withPermission(permManageRoles, a.handleAdminRoleSubroutes)))
+	mux.HandleFunc("/api/admin/recovery-requests", a.withAuth(a.withPermission(permManageUsers, a.handleAdminRecoveryRequests)))
+	mux.HandleFunc("/api/admin/recovery-requests/", a.withAuth(a.withPermission(permManageUsers, a.handleAdminRecoveryRequestSubroutes)))
+	mux.HandleFunc("/api/admin/audit-log", a.withAuth(a.withPermission(permViewAudit, a.handleAdminAuditLog)))
+	mux.HandleFunc("/api/admin/config/reload", a.withAuth(a.withPermission(permManageServer, a.handleAdminConfigReload)))
+	mux.HandleFunc("/api/admin/ip-denylist", a.withAuth(a.withPermission(permManageServer, a.handleAdminIPDenylist)))
+	mux.HandleFunc("/api/admin/ip-denylist/", a.withAuth(a.withPermission(permManageServer, a.handleAdminIPDenylistSubroutes)))
+	mux.HandleFunc("/api/admin/reports", a.withAuth(a.withPermission(permModerateReports, a.handleAdminReports)))
+	mux.HandleFunc("/api/admin/reports/", a.withAuth(a.withPermission(permModerateReports, a.handleAdminReportSubroutes)))
+	mux.HandleFunc("/api/admin/features", a.withAuth(a.withPermission(permManageServer, a.handleAdminFeatures)))
+	mux.HandleFunc("/api/admin/features/", a.withAuth(a.withPermission(permManageServer, a.handleAdminFeatureSubroutes)))
+	mux.HandleFunc("/api/admin/maintenance", a.withAuth(a.withPermission(permManageServer, a.handleAdminMaintenance)))
+	mux.HandleFunc("/api/admin/legal-holds", a.withAuth(a.withPermission(permManageServer, a.handleAdminLegalHolds)))
+	mux.HandleFunc("/api/admin/legal-holds/", a.withAuth(a.withPermission(permManageServer, a.handleAdminLegalHoldSubroutes)))
+	mux.HandleFunc("/api/admin/policy", a.withAuth(a.withPermission(permManageServer, a.handleAdminPolicy)))
+	mux.HandleFunc("/api/admin/broadcast", a.withAuth(a.withPermission(permManageServer, a.handleAdminBroadcast)))
+	mux.HandleFunc("/api/admin/drain", a.withAuth(a.withPermission(permManageServer, a.handleAdminDrain)))
+	mux.HandleFunc("/api/admin/log-level", a.withAuth(a.withPermission(permManageServer, a.handleAdminLogLevel)))
+	mux.HandleFunc("/api/admin/backups", a.withAuth(a.withPermission(permManageServer, a.handleAdminBackups)))
+	mux.HandleFunc("/api/admin/backups/restore", a.withAuth(a.withPermission(permManageServer, a.handleAdminBackupRestore)))
+	mux.HandleFunc("/api/admin/bots", a.withAuth(a.withPermission(permManageBots, a.handleAdminBots)))
+	mux.HandleFunc("/api/admin/bots/", a.withAuth(a.withPermission(permManageBots, a.handleAdminBotSubroutes)))
+	mux.HandleFunc("/api/admin/signal/hygiene", a.withAuth(a.withPermission(permManageServer, a.handleAdminSignalHygiene)))
+	mux.HandleFunc("/api/admin/federation/peers", a.withAuth(a.withPermission(permManageServer, a.handleAdminFederationPeers)))
+	mux.HandleFunc("/api/admin/federation/peers/", a.withAuth(a.withPermission(permManageServer, a.handleAdminFederationPeerSubroutes)))
+	mux.HandleFunc("/api/bot/rooms/", a.withBotAuth(a.handleBotRoomSubroutes))
+	mux.HandleFunc("/api/federation/relay", a.handleFederationRelay)
+	mux.HandleFunc("/api/rooms", a.withAuth(a.withRouteRateLimit(rateLimitRoomCreate, a.handleRooms)))
+	mux.HandleFunc("/api/rooms/", a.withAuth(a.handleRoomSubroutes))
+	mux.HandleFunc("/api/directory/rooms", a.withAuth(a.handleDirectoryRooms))
+	mux.HandleFunc("/api/account/key-backup", a.withAuth(a.handleAccountKeyBackup))
+	mux.HandleFunc("/api/account/profile", a.withAuth(a.handleAccountProfile))
+	mux.HandleFunc("/api/account/admin-invitation", a.withAuth(a.handleAccountAdminInvitation))
+	mux.HandleFunc("/api/account/email", a.withAuth(a.handleAccountEmail))
+	mux.HandleFunc("/api/account/password", a.withAuth(a.handleAccountPassword))
+	mux.HandleFunc("/api/account/accept-policy", a.withAuth(a.handleAccountAcceptPolicy))
+	mux.HandleFunc("/api/account/export", a.withAuth(a.withRouteRateLimit(rateLimitAccountExport, a.handleAccountExport)))
+	mux.HandleFunc("/api/account/delete", a.withAuth(a.handleAccountDelete))
+	mux.HandleFunc("/api/account/onboarding", a.handleAccountOnboarding)
+	mux.HandleFunc("/api/account/recovery/request", a.handleRecoveryRequest)
+	mux.HandleFunc("/api/account/recovery/reset", a.handleRecoveryReset)
+	mux.HandleFunc("/api/devices", a.withAuth(a.handleDevices))
+	mux.HandleFunc("/api/devices/", a.withAuth(a.handleDeviceSubroutes))
+	mux.HandleFunc("/api/signal/prekey-bundle", a.withAuth(a.handleSignalPreKeyBundle))
+	mux.HandleFunc("/api/signal/prekey-bundle/", a.withAuth(a.withRouteRateLimit(rateLimitPreKeyFetch, a.handleSignalPreKeyBundleSubroutes)))
+	mux.HandleFunc("/api/signal/prekey-consumption", a.withAuth(a.handleSignalPreKeyConsumption))
+	mux.HandleFunc("/api/signal/prekey-bundles:batch", a.withAuth(a.withRouteRateLimit(rateLimitPreKeyFetch, a.handleSignalPreKeyBundleBatch)))
+	mux.HandleFunc("/api/signal/sessions/", a.withAuth(a.withRouteRateLimit(rateLimitSessionReset, a.handleSignalSessionSubroutes)))
+	mux.HandleFunc("/api/signal/safety-number/", a.withAuth(a.handleSignalSafetyNumberSubroutes))
+	mux.HandleFunc("/api/signal/verifications/", a.withAuth(a.handleSignalVerificationSubroutes))
+	mux.HandleFunc("/api/invites/join", a.withAuth(a.withRouteRateLimit(rateLimitInviteJoin, a.handleInviteJoin)))
+	mux.HandleFunc("/api/blocks", a.withAuth(a.handleBlocks))
+	mux.HandleFunc("/api/blocks/", a.withAuth(a.handleBlockSubroutes))
+	mux.HandleFunc("/api/read-receipts", a.withAuth(a.handleBulkReadReceipts))
+	mux.HandleFunc("/api/sync", a.withAuth(a.handleSync))
+	mux.HandleFunc("/api/guest/room", a.handleGuestRoom)
+	mux.HandleFunc("/api/admin/support/rooms", a.withSupportAuth(a.handleSupportRooms))
+	mux.HandleFunc("/api/admin/support/devices", a.withSupportAuth(a.handleSupportDevices))
+	mux.HandleFunc("/ws", a.handleWS)
+	mux.HandleFunc("/ws/guest", a.handleGuestWS)
+
+	return withRequestID(loggingMiddleware(a.withTracing(a.withSecurityHeaders(a.withCORS(a.withAdminIPFilter(a.withMaintenance(mux)))))))
+}
+
 func gracefulShutdown(server *http.Server, hub *Hub, timeout time.Duration) error {
 	if timeout <= 0 {
 		timeout = time.Duration(defaultShutdownSecs) * time.Second
@@ -177,11 +367,12 @@ func loggingMiddleware(next http.Handler) http.Handler {
 			"remote_addr", r.RemoteAddr,
 			"user_agent", r.UserAgent(),
 		}
+		log := requestLogger(r.Context())
 		if recorder.statusCode >= http.StatusInternalServerError {
-			logger.Error("http_request", attrs...)
+			log.Error("http_request", attrs...)
 			return
 		}
-		logger.Info("http_request", attrs...)
+		log.Info("http_request", attrs...)
 	})
 }
 
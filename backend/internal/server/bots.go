@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	roleBot                = "bot"
+	botTokenPrefix         = "bot_"
+	botTokenRawBytes       = 32
+	maxBotTokenRooms       = 50
+	botDisabledPassword    = "!"
+	botAuthorizationScheme = "bot "
+)
+
+var errBotTokenInvalid = errors.New("invalid bot token")
+
+// BotAuthContext identifies a bot request. Tokens are scoped to an explicit
+// room list; membership is still checked separately so removing the bot from
+// a room revokes access without rotating tokens.
+type BotAuthContext struct {
+	BotUserID int64
+	Username  string
+	TokenID   int64
+	RoomIDs   []int64
+}
+
+func (auth BotAuthContext) allowsRoom(roomID int64) bool {
+	for _, candidate := range auth.RoomIDs {
+		if candidate == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+func generateBotToken() (string, error) {
+	raw := make([]byte, botTokenRawBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return botTokenPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashBotToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func botTokenFromRequest(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if !strings.HasPrefix(strings.ToLower(header), botAuthorizationScheme) {
+		return ""
+	}
+	token := strings.TrimSpace(header[len(botAuthorizationScheme):])
+	if !strings.HasPrefix(token, botTokenPrefix) {
+		return ""
+	}
+	return token
+}
+
+func normalizeBotRoomIDs(roomIDs []int64) ([]int64, bool) {
+	if len(roomIDs) == 0 || len(roomIDs) > maxBotTokenRooms {
+		return nil, false
+	}
+	seen := make(map[int64]struct{}, len(roomIDs))
+	normalized := make([]int64, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		if roomID <= 0 {
+			return nil, false
+		}
+		if _, found := seen[roomID]; found {
+			continue
+		}
+		seen[roomID] = struct{}{}
+		normalized = append(normalized, roomID)
+	}
+	return normalized, true
+}
+
+func (a *App) lookupBotToken(ctx context.Context, token string) (BotAuthContext, error) {
+	var auth BotAuthContext
+	var roomIDsRaw string
+	err := a.db.QueryRowContext(ctx, `
+UPDATE bot_tokens
+SET last_used_at = NOW()
+WHERE token_hash = $1
+  AND revoked_at IS NULL
+  AND bot_user_id IN (SELECT id FROM users WHERE role = 'bot')
+RETURNING id, bot_user_id, (SELECT username FROM users WHERE users.id = bot_tokens.bot_user_id),
+          array_to_json(room_ids)::TEXT
+`, hashBotToken(token)).Scan(&auth.TokenID, &auth.BotUserID, &auth.Username, &roomIDsRaw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return BotAuthContext{}, errBotTokenInvalid
+		}
+		return BotAuthContext{}, err
+	}
+	if err := json.Unmarshal([]byte(roomIDsRaw), &auth.RoomIDs); err != nil {
+		return BotAuthContext{}, err
+	}
+	return auth, nil
+}
+
+func (a *App) withBotAuth(next func(http.ResponseWriter, *http.Request, BotAuthContext)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := botTokenFromRequest(r)
+		if token == "" {
+			respondError(w, http.StatusUnauthorized, "bot authorization required")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+		auth, err := a.lookupBotToken(ctx, token)
+		if err != nil {
+			if errors.Is(err, errBotTokenInvalid) {
+				respondError(w, http.StatusUnauthorized, "invalid bot token")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to validate bot token")
+			return
+		}
+		next(w, r, auth)
+	}
+}
+
+// isBotAccount lets key-exchange endpoints skip bots: they hold no device
+// identity, so prekey bundles and safety numbers do not apply to them.
+func (a *App) isBotAccount(ctx context.Context, userID int64) (bool, error) {
+	var role string
+	if err := a.db.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1`, userID).Scan(&role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return role == roleBot, nil
+}
+
+func (a *App) handleBotRoomSubroutes(w http.ResponseWriter, r *http.Request, auth BotAuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 5 || parts[0] != "api" || parts[1] != "bot" || parts[2] != "rooms" || parts[4] != "messages" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	roomID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || roomID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid room id")
+		return
+	}
+	a.handleBotRoomMessages(w, r, auth, roomID)
+}
+
+func (a *App) handleBotRoomMessages(w http.ResponseWriter, r *http.Request, auth BotAuthContext, roomID int64) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !auth.allowsRoom(roomID) {
+		respondErrorCode(w, http.StatusForbidden, "bot_scope_denied", "bot token is not scoped to this room")
+		return
+	}
+
+	var req struct {
+		Payload  CipherPayload `json:"payload"`
+		Mentions []int64       `json:"mentions,omitempty"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	payload := req.Payload
+	if !a.validateExternalCipherPayload(w, auth.BotUserID, payload) {
+		return
+	}
+	mentions, err := normalizeMentions(req.Mentions, auth.BotUserID)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if err := a.ensureMembership(ctx, auth.BotUserID, roomID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "bot is not a room member")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+		return
+	}
+	decision, err := a.loadRoomSendDecision(ctx, auth.BotUserID, roleBot, roomID, payload)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load room")
+		return
+	}
+	if !decision.Allowed {
+		respondErrorCode(w, http.StatusForbidden, decision.Code, decision.Error)
+		return
+	}
+	mentions, err = a.filterRoomMembers(ctx, roomID, mentions)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to validate mentions")
+		return
+	}
+	stamp, err := a.storeMessage(ctx, roomID, auth.BotUserID, payload, mentions)
+	if err != nil {
+		if respondMessageQuotaError(w, err) {
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to store message")
+		return
+	}
+	a.deliverStoredCiphertext(roomID, auth.BotUserID, auth.Username, "", stamp, payload, mentions, nil)
+
+	respondJSON(w, http.StatusCreated, map[string]any{
+		"id":        stamp.ID,
+		"roomId":    roomID,
+		"seq":       stamp.Seq,
+		"createdAt": stamp.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// validateExternalCipherPayload applies the checks the WS ciphertext path runs
+// to payloads submitted over HTTP, writing the error response on failure.
+// senderID is the local user the message is stored under; with the sender
+// device it keys the nonce replay check.
+func (a *App) validateExternalCipherPayload(w http.ResponseWriter, senderID int64, payload CipherPayload) bool {
+	if payload.Ciphertext == "" || payload.MessageIV == "" || payload.Signature == "" {
+		respondError(w, http.StatusBadRequest, "ciphertext, iv and signature are required")
+		return false
+	}
+	if normalizeDeviceID(payload.SenderDeviceID) == "" {
+		respondError(w, http.StatusBadRequest, "invalid sender device id")
+		return false
+	}
+	if len(payload.SenderPublicJWK) == 0 || !json.Valid(payload.SenderPublicJWK) ||
+		len(payload.SenderSigningPubJWK) == 0 || !json.Valid(payload.SenderSigningPubJWK) {
+		respondError(w, http.StatusBadRequest, "sender keys are required")
+		return false
+	}
+	if err := validateV3CipherPayload(payload); err != nil {
+		code, _ := protocolErrorFromValidation(err)
+		respondErrorCode(w, http.StatusBadRequest, code, err.Error())
+		return false
+	}
+	if err := a.roomLimits.validateCipherPayload(payload); err != nil {
+		code, _ := protocolErrorFromValidation(err)
+		respondErrorCode(w, http.StatusRequestEntityTooLarge, code, err.Error())
+		return false
+	}
+	if err := verifyCipherSignature(payload); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid payload signature")
+		return false
+	}
+	if err := a.signatureReplay.Check(senderID, normalizeDeviceID(payload.SenderDeviceID), payload.Nonce, payload.SignedAt); err != nil {
+		code, _ := protocolErrorFromValidation(err)
+		respondErrorCode(w, http.StatusBadRequest, code, err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateBotToken(t *testing.T) {
+	t.Parallel()
+
+	first, err := generateBotToken()
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	second, err := generateBotToken()
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	if !strings.HasPrefix(first, botTokenPrefix) || first == second {
+		t.Fatalf("unexpected tokens %q / %q", first, second)
+	}
+	if hashBotToken(first) == hashBotToken(second) || len(hashBotToken(first)) != 64 {
+		t.Fatalf("unexpected token hash")
+	}
+}
+
+func TestBotTokenFromRequest(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"":                    "",
+		"Bearer bot_abc":      "",
+		"Bot abc":             "",
+		"Bot bot_abc":         "bot_abc",
+		"bot   bot_abc  ":     "bot_abc",
+		"BOT bot_abc.def-ghi": "bot_abc.def-ghi",
+	}
+	for header, want := range cases {
+		request := httptest.NewRequest(http.MethodPost, "/api/bot/rooms/1/messages", nil)
+		if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+		if got := botTokenFromRequest(request); got != want {
+			t.Fatalf("header %q: expected %q, got %q", header, want, got)
+		}
+	}
+}
+
+func TestNormalizeBotRoomIDs(t *testing.T) {
+	t.Parallel()
+
+	roomIDs, ok := normalizeBotRoomIDs([]int64{3, 1, 3})
+	if !ok || len(roomIDs) != 2 || roomIDs[0] != 3 || roomIDs[1] != 1 {
+		t.Fatalf("unexpected normalization: %v %v", roomIDs, ok)
+	}
+	if _, ok := normalizeBotRoomIDs(nil); ok {
+		t.Fatalf("expected empty room list to be rejected")
+	}
+	if _, ok := normalizeBotRoomIDs([]int64{1, 0}); ok {
+		t.Fatalf("expected invalid room id to be rejected")
+	}
+	if !(BotAuthContext{RoomIDs: []int64{4, 7}}).allowsRoom(7) {
+		t.Fatalf("expected scoped room to be allowed")
+	}
+	if (BotAuthContext{RoomIDs: []int64{4, 7}}).allowsRoom(5) {
+		t.Fatalf("expected unscoped room to be denied")
+	}
+}
+
+func TestBotHandlersWithoutDB(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	bot := BotAuthContext{BotUserID: 9, Username: "deploy-bot", TokenID: 1, RoomIDs: []int64{1}}
+
+	t.Run("missing authorization", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/bot/rooms/1/messages", nil)
+		response := httptest.NewRecorder()
+
+		app.withBotAuth(app.handleBotRoomSubroutes)(response, request)
+
+		if response.Code != http.StatusUnauthorized {
+			t.Fatalf("expected %d, got %d", http.StatusUnauthorized, response.Code)
+		}
+	})
+
+	t.Run("invalid room id", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/bot/rooms/abc/messages", nil)
+		response := httptest.NewRecorder()
+
+		app.handleBotRoomSubroutes(response, request, bot)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("wrong method", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/bot/rooms/1/messages", nil)
+		response := httptest.NewRecorder()
+
+		app.handleBotRoomSubroutes(response, request, bot)
+
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, response.Code)
+		}
+	})
+
+	t.Run("room outside token scope", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/bot/rooms/2/messages", strings.NewReader(`{}`))
+		response := httptest.NewRecorder()
+
+		app.handleBotRoomSubroutes(response, request, bot)
+
+		if response.Code != http.StatusForbidden {
+			t.Fatalf("expected %d, got %d", http.StatusForbidden, response.Code)
+		}
+	})
+
+	t.Run("missing ciphertext", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/bot/rooms/1/messages", strings.NewReader(`{"payload":{}}`))
+		response := httptest.NewRecorder()
+
+		app.handleBotRoomSubroutes(response, request, bot)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("admin create bot invalid rooms", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/admin/bots", strings.NewReader(`{"username":"deploy-bot","roomIds":[]}`))
+		response := httptest.NewRecorder()
+
+		app.handleAdminBots(response, request, AuthContext{UserID: 1, Role: "admin"})
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("admin bot subroute invalid token id", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodDelete, "/api/admin/bots/2/tokens/abc", nil)
+		response := httptest.NewRecorder()
+
+		app.handleAdminBotSubroutes(response, request, AuthContext{UserID: 1, Role: "admin"})
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+}
+
+// signedTestCipherPayload returns a V3 payload that passes
+// validateExternalCipherPayload once.
+func signedTestCipherPayload(t *testing.T) CipherPayload {
+	t.Helper()
+	privateKey, signingJWK := makeECDSAP256JWK(t)
+	payload := CipherPayload{
+		Version:          3,
+		Ciphertext:       "ciphertext-value",
+		MessageIV:        "iv-value",
+		EncryptionScheme: "DOUBLE_RATCHET_V1",
+		WrappedKeys: map[string]WrappedKey{
+			"12:device_1234": {IV: "wrap-iv", WrappedKey: "wrap-key"},
+		},
+		SenderPublicJWK:     mustJSONRaw(t, map[string]any{"kty": "EC", "crv": "P-256", "x": "sender-x", "y": "sender-y"}),
+		SenderSigningPubJWK: signingJWK,
+		SenderDeviceID:      "device_5678",
+		Nonce:               "nonce-0123456789abcdef",
+		SignedAt:            time.Now().UnixMilli(),
+	}
+	canonical, err := canonicalSignaturePayload(payload)
+	if err != nil {
+		t.Fatalf("canonical signature payload: %v", err)
+	}
+	payload.Signature = signWithECDSA(t, privateKey, canonical)
+	return payload
+}
+
+func TestValidateExternalCipherPayloadRejectsReplay(t *testing.T) {
+	t.Parallel()
+
+	payload := signedTestCipherPayload(t)
+
+	app := &App{signatureReplay: newSignatureReplayGuard(time.Minute)}
+	rec := httptest.NewRecorder()
+	if !app.validateExternalCipherPayload(rec, 7, payload) {
+		t.Fatalf("expected first submission to pass, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	if app.validateExternalCipherPayload(rec, 7, payload) {
+		t.Fatal("expected the replayed payload to be rejected")
+	}
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), protocolErrorReplayedPayload) {
+		t.Fatalf("expected replayed_payload, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBotCannotPostIntoAnnouncementRoom(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	_, roomID := seedSQLiteTestRoom(t, db)
+	botID := insertSQLiteTestMember(t, db, roomID, "notifier", roleBot)
+	if _, err := db.Exec(`UPDATE rooms SET announcement_only = TRUE WHERE id = $1`, roomID); err != nil {
+		t.Fatalf("mark announcement room: %v", err)
+	}
+
+	body, err := json.Marshal(map[string]any{"payload": signedTestCipherPayload(t)})
+	if err != nil {
+		t.Fatalf("encode body: %v", err)
+	}
+	app := &App{db: db, signatureReplay: newSignatureReplayGuard(time.Minute)}
+	rec := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/bot/rooms/1/messages", bytes.NewReader(body))
+	app.handleBotRoomMessages(rec, request, BotAuthContext{BotUserID: botID, Username: "notifier", RoomIDs: []int64{roomID}}, roomID)
+
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "announcement_only") {
+		t.Fatalf("expected announcement_only, got %d %s", rec.Code, rec.Body.String())
+	}
+}
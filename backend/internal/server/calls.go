@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	maxCallIDLength        = 64
+	maxCallSDPBytes        = 64 << 10
+	maxCallCandidateBytes  = 4 << 10
+	callRingTimeout        = 2 * time.Minute
+	callMaxDuration        = 12 * time.Hour
+	callEndReasonHangup    = "hangup"
+	callEndReasonOffline   = "disconnected"
+	callEndReasonMaxLength = 32
+
+	// Offers ring every device of the callee, so a user gets a handful a
+	// minute across all connections. Candidates trickle in bursts while a
+	// call connects and then stop.
+	callOffersPerMinute  = 10
+	callOfferBurst       = 5
+	callCandidatesPerSec = 10
+	callCandidateBurst   = 100
+	callSignalLimiterTTL = 10 * time.Minute
+)
+
+var (
+	errCallInvalid     = errors.New("invalid call signaling frame")
+	errCallNotFound    = errors.New("call not found")
+	errCallIDConflict  = errors.New("call id already in use")
+	errCallNotAPartner = errors.New("not a participant of this call")
+)
+
+// callSession is the server's view of a call: who is talking to whom. SDP and
+// ICE payloads are relayed untouched and never stored.
+type callSession struct {
+	ID           string
+	RoomID       int64
+	CallerID     int64
+	CallerDevice string
+	CalleeID     int64
+	CalleeDevice string
+	Answered     bool
+	StartedAt    time.Time
+}
+
+// peerOf returns the other party of the call, or false if userID is not in it.
+func (s callSession) peerOf(userID int64) (int64, string, bool) {
+	switch userID {
+	case s.CallerID:
+		return s.CalleeID, s.CalleeDevice, true
+	case s.CalleeID:
+		return s.CallerID, s.CallerDevice, true
+	}
+	return 0, "", false
+}
+
+// callRegistry tracks live calls by ID so answers, candidates and hangups are
+// only relayed between the two parties an offer paired up.
+type callRegistry struct {
+	mu    sync.Mutex
+	calls map[string]*callSession
+	now   func() time.Time
+
+	offers     *keyedRateLimiter
+	candidates *keyedRateLimiter
+}
+
+func newCallRegistry() *callRegistry {
+	return &callRegistry{
+		calls:      make(map[string]*callSession),
+		now:        time.Now,
+		offers:     newKeyedRateLimiter(perMinuteLimit(callOffersPerMinute), callOfferBurst, callSignalLimiterTTL),
+		candidates: newKeyedRateLimiter(rate.Limit(callCandidatesPerSec), callCandidateBurst, callSignalLimiterTTL),
+	}
+}
+
+// AllowSignal applies the per-user limits on call_offer and ice_candidate;
+// other frame types only act on a call that already exists.
+func (r *callRegistry) AllowSignal(frameType string, userID int64) bool {
+	key := strconv.FormatInt(userID, 10)
+	switch frameType {
+	case "call_offer":
+		return r.offers.Allow(key)
+	case "ice_candidate":
+		return r.candidates.Allow(key)
+	}
+	return true
+}
+
+func validCallID(callID string) bool {
+	if len(callID) < 8 || len(callID) > maxCallIDLength {
+		return false
+	}
+	for _, ch := range callID {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '-', ch == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validCallBlob accepts the JSON objects browsers produce for
+// RTCSessionDescriptionInit and RTCIceCandidateInit.
+func validCallBlob(raw json.RawMessage, maxBytes int) bool {
+	if len(raw) == 0 || len(raw) > maxBytes || !json.Valid(raw) {
+		return false
+	}
+	var object map[string]json.RawMessage
+	return json.Unmarshal(raw, &object) == nil
+}
+
+// Offer registers a new call, or returns the existing one when a participant
+// renegotiates with a fresh offer.
+func (r *callRegistry) Offer(callID string, roomID, callerID int64, callerDevice string, calleeID int64) (callSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweepLocked()
+
+	if existing, ok := r.calls[callID]; ok {
+		if existing.RoomID != roomID {
+			return callSession{}, errCallIDConflict
+		}
+		if _, _, ok := existing.peerOf(callerID); !ok {
+			return callSession{}, errCallIDConflict
+		}
+		return *existing, nil
+	}
+	session := &callSession{
+		ID:           callID,
+		RoomID:       roomID,
+		CallerID:     callerID,
+		CallerDevice: callerDevice,
+		CalleeID:     calleeID,
+		StartedAt:    r.now(),
+	}
+	r.calls[callID] = session
+	return *session, nil
+}
+
+// Answer pins the callee device that picked up; later frames for the call are
+// routed to that device only.
+func (r *callRegistry) Answer(callID string, roomID, calleeID int64, calleeDevice string) (callSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.calls[callID]
+	if !ok || session.RoomID != roomID {
+		return callSession{}, errCallNotFound
+	}
+	if session.CalleeID != calleeID {
+		return callSession{}, errCallNotAPartner
+	}
+	if session.Answered && session.CalleeDevice != calleeDevice {
+		return callSession{}, errCallNotAPartner
+	}
+	session.Answered = true
+	session.CalleeDevice = calleeDevice
+	return *session, nil
+}
+
+func (r *callRegistry) Lookup(callID string, roomID, userID int64) (callSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.calls[callID]
+	if !ok || session.RoomID != roomID {
+		return callSession{}, errCallNotFound
+	}
+	if _, _, ok := session.peerOf(userID); !ok {
+		return callSession{}, errCallNotAPartner
+	}
+	return *session, nil
+}
+
+func (r *callRegistry) End(callID string, roomID, userID int64) (callSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.calls[callID]
+	if !ok || session.RoomID != roomID {
+		return callSession{}, errCallNotFound
+	}
+	if _, _, ok := session.peerOf(userID); !ok {
+		return callSession{}, errCallNotAPartner
+	}
+	delete(r.calls, callID)
+	r.sweepLocked()
+	return *session, nil
+}
+
+// DropDevice ends every call the device takes part in, for when its
+// connection goes away mid-call.
+func (r *callRegistry) DropDevice(roomID, userID int64, deviceID string) []callSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var dropped []callSession
+	for callID, session := range r.calls {
+		if session.RoomID != roomID {
+			continue
+		}
+		isCaller := session.CallerID == userID && session.CallerDevice == deviceID
+		isCallee := session.CalleeID == userID && session.CalleeDevice == deviceID
+		if !isCaller && !isCallee {
+			continue
+		}
+		dropped = append(dropped, *session)
+		delete(r.calls, callID)
+	}
+	r.sweepLocked()
+	return dropped
+}
+
+// sweepLocked forgets calls that rang out or ran past callMaxDuration. It
+// runs on every offer, hangup and disconnect, so a call whose parties never
+// send call_end does not outlive the next one.
+func (r *callRegistry) sweepLocked() {
+	now := r.now()
+	for callID, session := range r.calls {
+		age := now.Sub(session.StartedAt)
+		if (!session.Answered && age > callRingTimeout) || age > callMaxDuration {
+			delete(r.calls, callID)
+		}
+	}
+}
+
+// relayCallSignal handles call_offer, call_answer, ice_candidate and
+// call_end frames. The backend never terminates media; it only forwards the
+// WebRTC negotiation between two members of the same room.
+func (c *Client) relayCallSignal(incoming WSIncoming) error {
+	if !validCallID(incoming.CallID) {
+		return errCallInvalid
+	}
+	calls := c.app.hub.calls
+
+	var session callSession
+	var err error
+	frame := map[string]any{
+		"type":         incoming.Type,
+		"roomId":       c.roomID,
+		"callId":       incoming.CallID,
+		"fromUserId":   c.userID,
+		"fromUsername": c.username,
+		"fromDeviceId": c.deviceID,
+	}
+
+	switch incoming.Type {
+	case "call_offer":
+		if !validCallBlob(incoming.SDP, maxCallSDPBytes) {
+			return errCallInvalid
+		}
+		if incoming.Media != "audio" && incoming.Media != "video" {
+			return errCallInvalid
+		}
+		if incoming.ToUserID <= 0 || incoming.ToUserID == c.userID {
+			return errCallInvalid
+		}
+		if c.app.hub.blocks.Between(c.userID, incoming.ToUserID) {
+			return errCallNotAPartner
+		}
+		ctx, cancel := context.WithTimeout(c.context(), 5*time.Second)
+		memberErr := c.app.ensureMembership(ctx, c.userID, c.roomID)
+		if memberErr == nil {
+			memberErr = c.app.ensureMembership(ctx, incoming.ToUserID, c.roomID)
+		}
+		cancel()
+		if memberErr != nil {
+			return errCallNotAPartner
+		}
+		session, err = calls.Offer(incoming.CallID, c.roomID, c.userID, c.deviceID, incoming.ToUserID)
+		frame["sdp"] = incoming.SDP
+		frame["media"] = incoming.Media
+	case "call_answer":
+		if !validCallBlob(incoming.SDP, maxCallSDPBytes) {
+			return errCallInvalid
+		}
+		session, err = calls.Answer(incoming.CallID, c.roomID, c.userID, c.deviceID)
+		frame["sdp"] = incoming.SDP
+	case "ice_candidate":
+		if !validCallBlob(incoming.Candidate, maxCallCandidateBytes) {
+			return errCallInvalid
+		}
+		session, err = calls.Lookup(incoming.CallID, c.roomID, c.userID)
+		frame["candidate"] = incoming.Candidate
+	case "call_end":
+		reason := incoming.Reason
+		if reason == "" {
+			reason = callEndReasonHangup
+		}
+		if len(reason) > callEndReasonMaxLength {
+			return errCallInvalid
+		}
+		session, err = calls.End(incoming.CallID, c.roomID, c.userID)
+		frame["reason"] = reason
+	default:
+		return errCallInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	peerID, peerDevice, _ := session.peerOf(c.userID)
+	frame["toUserId"] = peerID
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	// Until the callee answers, every one of its devices rings.
+	c.app.hub.UnicastToDevice(c.roomID, peerID, peerDevice, payload)
+	return nil
+}
+
+// endCallsForDisconnect tells the remaining party of each call the client was
+// in that the call is over.
+func (c *Client) endCallsForDisconnect() {
+	for _, session := range c.app.hub.calls.DropDevice(c.roomID, c.userID, c.deviceID) {
+		peerID, peerDevice, _ := session.peerOf(c.userID)
+		payload, err := json.Marshal(map[string]any{
+			"type":         "call_end",
+			"roomId":       c.roomID,
+			"callId":       session.ID,
+			"fromUserId":   c.userID,
+			"fromUsername": c.username,
+			"fromDeviceId": c.deviceID,
+			"toUserId":     peerID,
+			"reason":       callEndReasonOffline,
+		})
+		if err != nil {
+			continue
+		}
+		c.app.hub.UnicastToDevice(c.roomID, peerID, peerDevice, payload)
+	}
+}
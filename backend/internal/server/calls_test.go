@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCallRegistryPairsParticipants(t *testing.T) {
+	t.Parallel()
+
+	registry := newCallRegistry()
+	session, err := registry.Offer("call-0001", 7, 1, "alice-phone", 2)
+	if err != nil {
+		t.Fatalf("offer: %v", err)
+	}
+	if peer, device, ok := session.peerOf(1); !ok || peer != 2 || device != "" {
+		t.Fatalf("expected unanswered call to ring every callee device, got %d %q", peer, device)
+	}
+	if _, err := registry.Offer("call-0001", 7, 3, "carol-laptop", 2); !errors.Is(err, errCallIDConflict) {
+		t.Fatalf("expected call id conflict for an outsider, got %v", err)
+	}
+	if _, err := registry.Answer("call-0001", 7, 3, "carol-laptop"); !errors.Is(err, errCallNotAPartner) {
+		t.Fatalf("expected outsider answer to fail, got %v", err)
+	}
+
+	session, err = registry.Answer("call-0001", 7, 2, "bob-desktop")
+	if err != nil {
+		t.Fatalf("answer: %v", err)
+	}
+	if peer, device, _ := session.peerOf(1); peer != 2 || device != "bob-desktop" {
+		t.Fatalf("expected answered call to pin the callee device, got %d %q", peer, device)
+	}
+	if _, err := registry.Answer("call-0001", 7, 2, "bob-phone"); !errors.Is(err, errCallNotAPartner) {
+		t.Fatalf("expected a second callee device to be refused, got %v", err)
+	}
+	if _, err := registry.Lookup("call-0001", 8, 1); !errors.Is(err, errCallNotFound) {
+		t.Fatalf("expected lookup from another room to fail, got %v", err)
+	}
+
+	dropped := registry.DropDevice(7, 2, "bob-desktop")
+	if len(dropped) != 1 || dropped[0].ID != "call-0001" {
+		t.Fatalf("expected the call to end with the callee connection, got %+v", dropped)
+	}
+	if _, err := registry.End("call-0001", 7, 1); !errors.Is(err, errCallNotFound) {
+		t.Fatalf("expected call to be gone, got %v", err)
+	}
+}
+
+func TestCallRegistryExpiresUnansweredOffers(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	registry := newCallRegistry()
+	registry.now = func() time.Time { return now }
+	if _, err := registry.Offer("call-ring", 7, 1, "a", 2); err != nil {
+		t.Fatalf("offer: %v", err)
+	}
+	now = now.Add(callRingTimeout + time.Second)
+	if _, err := registry.Offer("call-next", 7, 1, "a", 3); err != nil {
+		t.Fatalf("offer: %v", err)
+	}
+	if _, err := registry.Lookup("call-ring", 7, 1); !errors.Is(err, errCallNotFound) {
+		t.Fatalf("expected stale offer to be swept, got %v", err)
+	}
+}
+
+func TestCallRegistrySweepsOnHangupAndDisconnect(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	registry := newCallRegistry()
+	registry.now = func() time.Time { return now }
+	for _, callID := range []string{"call-ring", "call-hangup", "call-drop"} {
+		if _, err := registry.Offer(callID, 7, 1, "a", 2); err != nil {
+			t.Fatalf("offer %s: %v", callID, err)
+		}
+	}
+	now = now.Add(callRingTimeout + time.Second)
+	if _, err := registry.End("call-hangup", 7, 1); err != nil {
+		t.Fatalf("end: %v", err)
+	}
+	if _, err := registry.Lookup("call-ring", 7, 1); !errors.Is(err, errCallNotFound) {
+		t.Fatalf("expected the hangup to sweep the stale offer, got %v", err)
+	}
+	if len(registry.calls) != 0 {
+		t.Fatalf("expected every stale call to be gone, got %d", len(registry.calls))
+	}
+
+	if _, err := registry.Offer("call-stale", 7, 3, "c", 4); err != nil {
+		t.Fatalf("offer: %v", err)
+	}
+	now = now.Add(callRingTimeout + time.Second)
+	registry.DropDevice(7, 5, "e")
+	if len(registry.calls) != 0 {
+		t.Fatalf("expected a disconnect to sweep stale calls, got %d", len(registry.calls))
+	}
+}
+
+func TestCallRegistryLimitsSignalsPerUser(t *testing.T) {
+	t.Parallel()
+
+	registry := newCallRegistry()
+	for i := 0; i < callOfferBurst; i++ {
+		if !registry.AllowSignal("call_offer", 1) {
+			t.Fatalf("offer %d refused within the burst", i)
+		}
+	}
+	if registry.AllowSignal("call_offer", 1) {
+		t.Fatal("expected offers past the burst to be refused")
+	}
+	if !registry.AllowSignal("call_offer", 2) || !registry.AllowSignal("call_end", 1) {
+		t.Fatal("expected other users and hangups to be unaffected")
+	}
+	for i := 0; i < callCandidateBurst; i++ {
+		if !registry.AllowSignal("ice_candidate", 1) {
+			t.Fatalf("candidate %d refused within the burst", i)
+		}
+	}
+	if registry.AllowSignal("ice_candidate", 1) {
+		t.Fatal("expected candidates past the burst to be refused")
+	}
+}
+
+func TestCallSignalValidation(t *testing.T) {
+	t.Parallel()
+
+	if validCallID("short") || validCallID("bad id with spaces") || !validCallID("b3f0c2a1-9d4e") {
+		t.Fatalf("unexpected call id validation")
+	}
+	if !validCallBlob(json.RawMessage(`{"type":"offer","sdp":"v=0"}`), maxCallSDPBytes) {
+		t.Fatalf("expected session description to be accepted")
+	}
+	if validCallBlob(json.RawMessage(`"v=0"`), maxCallSDPBytes) || validCallBlob(json.RawMessage(`{"a":1}`), 4) {
+		t.Fatalf("expected non-object or oversized blobs to be rejected")
+	}
+}
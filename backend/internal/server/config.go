@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"net/netip"
 	"net/url"
 	"os"
 	"strconv"
@@ -18,6 +19,7 @@ type runtimeConfig struct {
 	RefreshTokenTTL         time.Duration
 	CORSOrigin              string
 	AdminUsername           string
+	AdminUsernames          []string
 	AdminPasswordHash       string
 	AdminRoomName           string
 	TrustProxyHeaders       bool
@@ -28,6 +30,37 @@ type runtimeConfig struct {
 	WSConnectRatePerMinute  int
 	WSConnectRateBurst      int
 	GracefulShutdownTimeout time.Duration
+	WSDrainWindow           time.Duration
+	MessageBatchSize        int
+	MessageBatchMaxLatency  time.Duration
+	WSCompression           wsCompressionConfig
+	WSLimits                wsLimitsConfig
+	RoomLimits              roomLimitsConfig
+	PreKeyFetchesPerHour    int
+	DRHandshakeTTL          time.Duration
+	SignatureMaxSkew        time.Duration
+	KeyEpochGrace           time.Duration
+	PreKeyHygiene           preKeyHygieneConfig
+	DailyMessageLimit       int
+	StorageQuotaMB          int
+	UsernameHold            time.Duration
+	PolicyVersion           int
+	AccountErasureGrace     time.Duration
+	LoginChallenge          loginChallengeConfig
+	FederationServerID      string
+	Backup                  backupConfig
+	DBPool                  dbPoolConfig
+	DBReplicaURL            string
+	ReplicaHealthInterval   time.Duration
+	Tracing                 tracingConfig
+	Logging                 loggingConfig
+	RouteRateLimits         map[string]routeRateLimit
+	AdminIPAllowlist        []netip.Prefix
+	SMTP                    smtpConfig
+	TCPGateway              tcpGatewayConfig
+	MQTT                    mqttConfig
+	MaintenanceMode         bool
+	MaintenanceReason       string
 }
 
 func loadRuntimeConfig() (runtimeConfig, error) {
@@ -63,6 +96,134 @@ func loadRuntimeConfig() (runtimeConfig, error) {
 	if err != nil {
 		return runtimeConfig{}, err
 	}
+	wsDrainSecs, err := readPositiveIntEnv("WS_DRAIN_SECONDS", defaultWSDrainSecs)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	messageBatchSize, err := readPositiveIntEnv("MESSAGE_BATCH_SIZE", defaultMessageBatchSize)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	messageBatchLatencyMS, err := readPositiveIntEnv("MESSAGE_BATCH_MAX_LATENCY_MS", defaultMessageBatchLatencyMS)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	wsCompressionEnabled, err := readBoolEnv("WS_COMPRESSION_ENABLED", defaultWSCompressionEnabled)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	wsCompressionLevel, err := readPositiveIntEnv("WS_COMPRESSION_LEVEL", defaultWSCompressionLevel)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	wsCompressionThreshold, err := readPositiveIntEnv("WS_COMPRESSION_THRESHOLD_BYTES", defaultWSCompressionThreshold)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	wsReadLimitBytes, err := readPositiveIntEnv("WS_READ_LIMIT_BYTES", defaultWSReadLimitBytes)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	wsPongTimeoutSecs, err := readPositiveIntEnv("WS_PONG_TIMEOUT_SECONDS", defaultWSPongTimeoutSecs)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	wsPingIntervalSecs, err := readPositiveIntEnv("WS_PING_INTERVAL_SECONDS", defaultWSPingIntervalSec)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	wsSendBufferSize, err := readPositiveIntEnv("WS_SEND_BUFFER_SIZE", defaultWSSendBufferSize)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	maxRoomMembers, err := readPositiveIntEnv("MAX_ROOM_MEMBERS", defaultMaxRoomMembers)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	maxWrappedKeys, err := readPositiveIntEnv("MAX_WRAPPED_KEYS_PER_MESSAGE", defaultMaxWrappedKeys)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	maxCipherPayloadBytes, err := readPositiveIntEnv("MAX_CIPHER_PAYLOAD_BYTES", defaultMaxCipherPayloadBytes)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	preKeyFetchesPerHour, err := readPositiveIntEnv("PREKEY_FETCHES_PER_TARGET_PER_HOUR", defaultPreKeyFetchesPerTargetHour)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	drHandshakeTTLHours, err := readPositiveIntEnv("DR_HANDSHAKE_TTL_HOURS", int(defaultDRHandshakeTTL/time.Hour))
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	signatureMaxSkewSecs, err := readPositiveIntEnv("SIGNATURE_MAX_SKEW_SECONDS", int(defaultSignatureMaxSkew/time.Second))
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	keyEpochGraceSecs, err := readPositiveIntEnv("ROOM_KEY_EPOCH_GRACE_SECONDS", int(defaultRoomKeyEpochGrace/time.Second))
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	consumedPreKeyRetentionDays, err := readPositiveIntEnv("CONSUMED_PREKEY_RETENTION_DAYS", defaultConsumedPreKeyRetentionDays)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	signedPreKeyMaxAgeDays, err := readPositiveIntEnv("SIGNED_PREKEY_MAX_AGE_DAYS", defaultSignedPreKeyMaxAgeDays)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	dailyMessageLimit, err := readPositiveIntEnv("USER_DAILY_MESSAGE_LIMIT", defaultDailyMessageLimit)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	storageQuotaMB, err := readPositiveIntEnv("USER_STORAGE_QUOTA_MB", defaultStorageQuotaMB)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	usernameHoldDays, err := readPositiveIntEnv("DELETED_USERNAME_HOLD_DAYS", defaultUsernameHoldDays)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	policyVersion, err := readPositiveIntEnv("POLICY_VERSION", 0)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	accountErasureGraceDays, err := readPositiveIntEnv("ACCOUNT_ERASURE_GRACE_DAYS", defaultAccountErasureGraceDays)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	loginChallengeAfterFailures, err := readPositiveIntEnv("LOGIN_CHALLENGE_AFTER_FAILURES", defaultLoginChallengeAfterFailures)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	loginPoWDifficulty, err := readPositiveIntEnv("LOGIN_POW_DIFFICULTY", defaultLoginPoWDifficulty)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	backupIntervalMinutes, err := readPositiveIntEnv("BACKUP_INTERVAL_MINUTES", 0)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	dbMaxOpenConns, err := readPositiveIntEnv("DB_MAX_OPEN_CONNS", defaultDBMaxOpenConns)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	dbMaxIdleConns, err := readPositiveIntEnv("DB_MAX_IDLE_CONNS", defaultDBMaxIdleConns)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	dbConnMaxLifetimeMinutes, err := readPositiveIntEnv("DB_CONN_MAX_LIFETIME_MINUTES", defaultDBConnMaxLifetimeMinutes)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	dbConnMaxIdleTimeMinutes, err := readPositiveIntEnv("DB_CONN_MAX_IDLE_TIME_MINUTES", defaultDBConnMaxIdleTimeMinutes)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	replicaHealthIntervalSecs, err := readPositiveIntEnv("DATABASE_REPLICA_HEALTH_INTERVAL_SECONDS", defaultReplicaHealthIntervalSecs)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
 	accessTokenTTLMinutes, err := readPositiveIntEnv("ACCESS_TOKEN_TTL_MINUTES", defaultAccessTokenMins)
 	if err != nil {
 		return runtimeConfig{}, err
@@ -72,15 +233,51 @@ func loadRuntimeConfig() (runtimeConfig, error) {
 		return runtimeConfig{}, err
 	}
 
+	routeRateLimits, err := loadRouteRateLimits()
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	maintenanceMode, err := readBoolEnv("MAINTENANCE_MODE", false)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	smtpPort, err := readPositiveIntEnv("SMTP_PORT", defaultSMTPPort)
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	adminUsernames, err := parseAdminUsernames(os.Getenv("ADMIN_USERNAMES"))
+	if err != nil {
+		return runtimeConfig{}, fmt.Errorf("ADMIN_USERNAMES: %w", err)
+	}
+	adminIPAllowlist, err := parseCIDRList(os.Getenv("ADMIN_IP_ALLOWLIST"))
+	if err != nil {
+		return runtimeConfig{}, fmt.Errorf("ADMIN_IP_ALLOWLIST: %w", err)
+	}
+	logLevel, err := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		return runtimeConfig{}, fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	logFormat, err := parseLogFormat(os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		return runtimeConfig{}, fmt.Errorf("LOG_FORMAT: %w", err)
+	}
+	otlpHeaders, err := parseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return runtimeConfig{}, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+
 	cfg := runtimeConfig{
 		Addr:                    readEnvOrFallback("APP_ADDR", defaultAddr),
 		AppEnv:                  normalizeAppEnv(readEnvOrFallback("APP_ENV", defaultAppEnv)),
 		DBURL:                   strings.TrimSpace(os.Getenv("DATABASE_URL")),
+		DBReplicaURL:            strings.TrimSpace(os.Getenv("DATABASE_REPLICA_URL")),
+		ReplicaHealthInterval:   time.Duration(replicaHealthIntervalSecs) * time.Second,
 		JWTSecret:               strings.TrimSpace(os.Getenv("JWT_SECRET")),
 		AccessTokenTTL:          time.Duration(accessTokenTTLMinutes) * time.Minute,
 		RefreshTokenTTL:         time.Duration(refreshTokenTTLHours) * time.Hour,
 		CORSOrigin:              strings.TrimSpace(os.Getenv("CORS_ORIGIN")),
 		AdminUsername:           strings.TrimSpace(readEnvOrFallback("ADMIN_USERNAME", defaultAdminUsername)),
+		AdminUsernames:          adminUsernames,
 		AdminPasswordHash:       strings.TrimSpace(os.Getenv("ADMIN_PASSWORD_HASH")),
 		AdminRoomName:           strings.TrimSpace(readEnvOrFallback("ADMIN_ROOM_NAME", defaultAdminRoomName)),
 		TrustProxyHeaders:       trustProxyHeaders,
@@ -90,7 +287,99 @@ func loadRuntimeConfig() (runtimeConfig, error) {
 		LoginUserRateBurst:      loginUserRateBurst,
 		WSConnectRatePerMinute:  wsConnectRatePerMinute,
 		WSConnectRateBurst:      wsConnectRateBurst,
+		RouteRateLimits:         routeRateLimits,
+		AdminIPAllowlist:        adminIPAllowlist,
+		MaintenanceMode:         maintenanceMode,
+		MaintenanceReason:       strings.TrimSpace(os.Getenv("MAINTENANCE_REASON")),
 		GracefulShutdownTimeout: time.Duration(shutdownTimeoutSecs) * time.Second,
+		WSDrainWindow:           time.Duration(wsDrainSecs) * time.Second,
+		MessageBatchSize:        messageBatchSize,
+		MessageBatchMaxLatency:  time.Duration(messageBatchLatencyMS) * time.Millisecond,
+		WSCompression: wsCompressionConfig{
+			Enabled:   wsCompressionEnabled,
+			Level:     wsCompressionLevel,
+			Threshold: wsCompressionThreshold,
+		},
+		WSLimits: wsLimitsConfig{
+			ReadLimitBytes: int64(wsReadLimitBytes),
+			PongTimeout:    time.Duration(wsPongTimeoutSecs) * time.Second,
+			PingInterval:   time.Duration(wsPingIntervalSecs) * time.Second,
+			SendBufferSize: wsSendBufferSize,
+		},
+		RoomLimits: roomLimitsConfig{
+			MaxRoomMembers:  maxRoomMembers,
+			MaxWrappedKeys:  maxWrappedKeys,
+			MaxPayloadBytes: maxCipherPayloadBytes,
+		},
+		PreKeyFetchesPerHour: preKeyFetchesPerHour,
+		DRHandshakeTTL:       time.Duration(drHandshakeTTLHours) * time.Hour,
+		SignatureMaxSkew:     time.Duration(signatureMaxSkewSecs) * time.Second,
+		KeyEpochGrace:        time.Duration(keyEpochGraceSecs) * time.Second,
+		FederationServerID:   strings.ToLower(strings.TrimSpace(os.Getenv("FEDERATION_SERVER_ID"))),
+		PreKeyHygiene: preKeyHygieneConfig{
+			ConsumedRetention:  time.Duration(consumedPreKeyRetentionDays) * 24 * time.Hour,
+			SignedPreKeyMaxAge: time.Duration(signedPreKeyMaxAgeDays) * 24 * time.Hour,
+		},
+		DailyMessageLimit:   dailyMessageLimit,
+		StorageQuotaMB:      storageQuotaMB,
+		UsernameHold:        time.Duration(usernameHoldDays) * 24 * time.Hour,
+		PolicyVersion:       policyVersion,
+		AccountErasureGrace: time.Duration(accountErasureGraceDays) * 24 * time.Hour,
+		LoginChallenge: loginChallengeConfig{
+			Provider:      strings.ToLower(strings.TrimSpace(os.Getenv("LOGIN_CHALLENGE_PROVIDER"))),
+			Secret:        strings.TrimSpace(os.Getenv("LOGIN_CHALLENGE_SECRET")),
+			AfterFailures: loginChallengeAfterFailures,
+			PoWDifficulty: loginPoWDifficulty,
+		},
+		SMTP: smtpConfig{
+			Host:     strings.TrimSpace(os.Getenv("SMTP_HOST")),
+			Port:     smtpPort,
+			Username: strings.TrimSpace(os.Getenv("SMTP_USERNAME")),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     strings.TrimSpace(os.Getenv("SMTP_FROM")),
+			BaseURL:  strings.TrimSpace(os.Getenv("APP_BASE_URL")),
+		},
+		TCPGateway: tcpGatewayConfig{
+			Addr:     strings.TrimSpace(os.Getenv("TCP_GATEWAY_ADDR")),
+			CertFile: strings.TrimSpace(os.Getenv("TCP_GATEWAY_TLS_CERT_FILE")),
+			KeyFile:  strings.TrimSpace(os.Getenv("TCP_GATEWAY_TLS_KEY_FILE")),
+		},
+		MQTT: mqttConfig{
+			BrokerURL:   strings.TrimSpace(os.Getenv("MQTT_BROKER_URL")),
+			ClientID:    strings.TrimSpace(os.Getenv("MQTT_CLIENT_ID")),
+			Username:    strings.TrimSpace(os.Getenv("MQTT_USERNAME")),
+			Password:    os.Getenv("MQTT_PASSWORD"),
+			TopicPrefix: strings.Trim(readEnvOrFallback("MQTT_TOPIC_PREFIX", defaultMQTTTopicPrefix), "/"),
+		},
+		Backup: backupConfig{
+			S3: s3Config{
+				Endpoint:        strings.TrimSpace(os.Getenv("BACKUP_S3_ENDPOINT")),
+				Region:          strings.TrimSpace(os.Getenv("BACKUP_S3_REGION")),
+				Bucket:          strings.TrimSpace(os.Getenv("BACKUP_S3_BUCKET")),
+				AccessKeyID:     strings.TrimSpace(os.Getenv("BACKUP_S3_ACCESS_KEY_ID")),
+				SecretAccessKey: strings.TrimSpace(os.Getenv("BACKUP_S3_SECRET_ACCESS_KEY")),
+			},
+			Prefix:   strings.TrimSpace(os.Getenv("BACKUP_S3_PREFIX")),
+			Interval: time.Duration(backupIntervalMinutes) * time.Minute,
+		},
+		DBPool: dbPoolConfig{
+			MaxOpenConns:    dbMaxOpenConns,
+			MaxIdleConns:    dbMaxIdleConns,
+			ConnMaxLifetime: time.Duration(dbConnMaxLifetimeMinutes) * time.Minute,
+			ConnMaxIdleTime: time.Duration(dbConnMaxIdleTimeMinutes) * time.Minute,
+		},
+		Tracing: tracingConfig{
+			Endpoint: tracingEndpoint(
+				strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")),
+				strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")),
+			),
+			ServiceName: strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME")),
+			Headers:     otlpHeaders,
+		},
+		Logging: loggingConfig{
+			Level:  logLevel,
+			Format: logFormat,
+		},
 	}
 
 	if cfg.DBURL == "" {
@@ -99,6 +388,17 @@ func loadRuntimeConfig() (runtimeConfig, error) {
 	if err := validateDatabaseURL(cfg.DBURL, isProductionEnv(cfg.AppEnv)); err != nil {
 		return runtimeConfig{}, err
 	}
+	if cfg.DBReplicaURL != "" && (isSQLiteURL(cfg.DBURL) || isSQLiteURL(cfg.DBReplicaURL)) {
+		return runtimeConfig{}, fmt.Errorf("DATABASE_REPLICA_URL requires PostgreSQL")
+	}
+	if cfg.DBReplicaURL != "" {
+		if err := validateDatabaseURL(cfg.DBReplicaURL, isProductionEnv(cfg.AppEnv)); err != nil {
+			return runtimeConfig{}, fmt.Errorf("DATABASE_REPLICA_URL: %w", err)
+		}
+	}
+	if err := validateDBPool(cfg.DBPool); err != nil {
+		return runtimeConfig{}, err
+	}
 
 	if err := validateJWTSecret(cfg.JWTSecret); err != nil {
 		return runtimeConfig{}, err
@@ -111,6 +411,55 @@ func loadRuntimeConfig() (runtimeConfig, error) {
 		return runtimeConfig{}, err
 	}
 
+	if cfg.MessageBatchSize > 1000 {
+		return runtimeConfig{}, fmt.Errorf("MESSAGE_BATCH_SIZE must be <= 1000")
+	}
+	if cfg.MessageBatchMaxLatency > time.Second {
+		return runtimeConfig{}, fmt.Errorf("MESSAGE_BATCH_MAX_LATENCY_MS must be <= 1000")
+	}
+
+	if err := validateWSLimits(cfg.WSLimits); err != nil {
+		return runtimeConfig{}, err
+	}
+	if int64(cfg.RoomLimits.maxFrameBytes()) > cfg.WSLimits.ReadLimitBytes {
+		return runtimeConfig{}, fmt.Errorf("MAX_CIPHER_PAYLOAD_BYTES plus %d bytes of frame envelope must fit in WS_READ_LIMIT_BYTES", wsFrameEnvelopeBytes)
+	}
+	if cfg.WSCompression.Level > 9 {
+		return runtimeConfig{}, fmt.Errorf("WS_COMPRESSION_LEVEL must be between 1 and 9")
+	}
+
+	if cfg.FederationServerID != "" {
+		if err := validateFederationServerID(cfg.FederationServerID); err != nil {
+			return runtimeConfig{}, fmt.Errorf("FEDERATION_SERVER_ID: %w", err)
+		}
+	}
+
+	if err := validateTracingConfig(cfg.Tracing); err != nil {
+		return runtimeConfig{}, err
+	}
+
+	if err := validateS3Config(cfg.Backup.S3); err != nil {
+		return runtimeConfig{}, err
+	}
+	if cfg.Backup.Interval > 0 && !cfg.Backup.S3.configured() {
+		return runtimeConfig{}, fmt.Errorf("BACKUP_INTERVAL_MINUTES requires BACKUP_S3_BUCKET")
+	}
+	// Snapshots are taken with PostgreSQL's JSON record functions; a SQLite
+	// deployment is backed up by copying its database file.
+	if cfg.Backup.S3.configured() && isSQLiteURL(cfg.DBURL) {
+		return runtimeConfig{}, fmt.Errorf("BACKUP_S3_BUCKET requires a PostgreSQL DATABASE_URL")
+	}
+
+	if err := validateSMTPConfig(cfg.SMTP); err != nil {
+		return runtimeConfig{}, err
+	}
+	if err := validateTCPGatewayConfig(cfg.TCPGateway); err != nil {
+		return runtimeConfig{}, err
+	}
+	if err := validateMQTTConfig(cfg.MQTT); err != nil {
+		return runtimeConfig{}, err
+	}
+
 	if cfg.AdminUsername == "" {
 		return runtimeConfig{}, fmt.Errorf("ADMIN_USERNAME must not be empty")
 	}
@@ -120,6 +469,9 @@ func loadRuntimeConfig() (runtimeConfig, error) {
 	if cfg.AdminRoomName == "" {
 		return runtimeConfig{}, fmt.Errorf("ADMIN_ROOM_NAME must not be empty")
 	}
+	if err := cfg.LoginChallenge.validate(); err != nil {
+		return runtimeConfig{}, err
+	}
 
 	return cfg, nil
 }
@@ -217,8 +569,15 @@ func validateDatabaseURL(dbURL string, requireTLS bool) error {
 	}
 
 	scheme := strings.ToLower(strings.TrimSpace(parsed.Scheme))
+	if scheme == "sqlite" {
+		// A local file needs neither credentials nor TLS.
+		if _, err := sqliteDSN(dbURL); err != nil {
+			return err
+		}
+		return nil
+	}
 	if scheme != "postgres" && scheme != "postgresql" {
-		return fmt.Errorf("DATABASE_URL must use postgres or postgresql scheme")
+		return fmt.Errorf("DATABASE_URL must use postgres, postgresql or sqlite scheme")
 	}
 
 	if parsed.User == nil {
@@ -248,11 +607,28 @@ func validateDatabaseURL(dbURL string, requireTLS bool) error {
 	return nil
 }
 
-func validateCORSOrigin(origin string, allowWildcard bool) error {
-	trimmed := strings.TrimSpace(origin)
-	if trimmed == "" {
+// validateCORSOrigin accepts "*" or a comma-separated list of origins.
+func validateCORSOrigin(origins string, allowWildcard bool) error {
+	if strings.TrimSpace(origins) == "" {
 		return fmt.Errorf("CORS_ORIGIN must not be empty")
 	}
+	entries := strings.Split(origins, ",")
+	for _, entry := range entries {
+		trimmed := strings.TrimSpace(entry)
+		if trimmed == "*" && len(entries) > 1 {
+			return fmt.Errorf("CORS_ORIGIN cannot combine '*' with other origins")
+		}
+		if err := validateSingleCORSOrigin(trimmed, allowWildcard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateSingleCORSOrigin(trimmed string, allowWildcard bool) error {
+	if trimmed == "" {
+		return fmt.Errorf("CORS_ORIGIN must not contain empty entries")
+	}
 	if trimmed == "*" {
 		if allowWildcard {
 			return nil
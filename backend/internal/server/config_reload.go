@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// reloadableSettings lists what a reload applies in place. Everything else in
+// runtimeConfig still needs a restart.
+var reloadableSettings = []string{
+	"LOGIN_RATE_LIMIT_IP_PER_MINUTE",
+	"LOGIN_RATE_LIMIT_IP_BURST",
+	"LOGIN_RATE_LIMIT_USER_PER_MINUTE",
+	"LOGIN_RATE_LIMIT_USER_BURST",
+	"WS_RATE_LIMIT_IP_PER_MINUTE",
+	"WS_RATE_LIMIT_IP_BURST",
+	"RATE_LIMIT_*_PER_MINUTE",
+	"RATE_LIMIT_*_BURST",
+	"CORS_ORIGIN",
+	"LOG_LEVEL",
+}
+
+// reloadConfig re-reads the environment and applies the reloadable subset. An
+// invalid configuration is rejected as a whole and nothing changes.
+func (a *App) reloadConfig() error {
+	cfg, err := loadRuntimeConfig()
+	if err != nil {
+		return err
+	}
+	a.applyReloadableConfig(cfg)
+	return nil
+}
+
+func (a *App) applyReloadableConfig(cfg runtimeConfig) {
+	a.loginIPLimiter.SetLimit(perMinuteLimit(cfg.LoginIPRatePerMinute), cfg.LoginIPRateBurst)
+	a.loginUserLimiter.SetLimit(perMinuteLimit(cfg.LoginUserRatePerMinute), cfg.LoginUserRateBurst)
+	a.wsConnectLimiter.SetLimit(perMinuteLimit(cfg.WSConnectRatePerMinute), cfg.WSConnectRateBurst)
+	for name, limit := range cfg.RouteRateLimits {
+		a.routeLimiters[name].SetLimit(perMinuteLimit(limit.PerMinute), limit.Burst)
+	}
+
+	a.settingsMu.Lock()
+	a.corsOrigin = cfg.CORSOrigin
+	a.settingsMu.Unlock()
+
+	logLevels.Configure(cfg.Logging.Level)
+}
+
+func (a *App) handleAdminConfigReload(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := a.reloadConfig(); err != nil {
+		respondErrorCode(w, http.StatusUnprocessableEntity, "invalid_config", err.Error())
+		return
+	}
+	requestLogger(r.Context()).Warn("config_reloaded", "source", "admin", "admin_user_id", auth.UserID)
+	respondJSON(w, http.StatusOK, map[string]any{"reloaded": reloadableSettings})
+}
+
+func logConfigReload(err error) {
+	if err != nil {
+		logger.Error("config_reload_failed", "source", "sighup", "error", err)
+		return
+	}
+	logger.Info("config_reloaded", "source", "sighup", "settings", strings.Join(reloadableSettings, ","))
+}
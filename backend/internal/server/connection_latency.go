@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// rttSampleWindow is how many recent heartbeats the rolling average covers;
+// at the default 30s ping interval that is the last four minutes.
+const rttSampleWindow = 8
+
+// rttWindow keeps the most recent ping/pong round trips of one connection.
+type rttWindow struct {
+	samples [rttSampleWindow]time.Duration
+	count   int
+	next    int
+	last    time.Duration
+}
+
+func (w *rttWindow) add(rtt time.Duration) {
+	w.samples[w.next] = rtt
+	w.next = (w.next + 1) % rttSampleWindow
+	if w.count < rttSampleWindow {
+		w.count++
+	}
+	w.last = rtt
+}
+
+func (w *rttWindow) average() time.Duration {
+	if w.count == 0 {
+		return 0
+	}
+	var total time.Duration
+	for i := 0; i < w.count; i++ {
+		total += w.samples[i]
+	}
+	return total / time.Duration(w.count)
+}
+
+type connectionLatency struct {
+	LastRTTMillis    float64 `json:"rttMs"`
+	AverageRTTMillis float64 `json:"avgRttMs"`
+	Samples          int     `json:"samples"`
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// pingPayload stamps a heartbeat with its send time; the pong echoes it back,
+// so the round trip is measured without per-connection bookkeeping.
+func pingPayload(now time.Time) []byte {
+	return strconv.AppendInt(nil, now.UnixNano(), 10)
+}
+
+// recordPong measures the round trip of the ping appData came from. Pongs
+// that do not carry one of our stamps, such as unsolicited ones, are ignored.
+func (c *Client) recordPong(appData string, now time.Time) (time.Duration, bool) {
+	sentAt, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	rtt := now.Sub(time.Unix(0, sentAt))
+	if rtt < 0 || rtt > time.Minute {
+		return 0, false
+	}
+	c.mu.Lock()
+	c.rtt.add(rtt)
+	c.mu.Unlock()
+	return rtt, true
+}
+
+func (c *Client) latency() connectionLatency {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return connectionLatency{
+		LastRTTMillis:    durationMillis(c.rtt.last),
+		AverageRTTMillis: durationMillis(c.rtt.average()),
+		Samples:          c.rtt.count,
+	}
+}
+
+// handlePong extends the read deadline and, for members, reports the updated
+// latency in a connection_stats frame so clients can show a lag indicator.
+func (c *Client) handlePong(appData string, pongTimeout time.Duration) error {
+	if _, ok := c.recordPong(appData, time.Now()); ok && !c.guest {
+		stats := c.latency()
+		if payload, err := json.Marshal(map[string]any{
+			"type":     "connection_stats",
+			"roomId":   c.roomID,
+			"rttMs":    stats.LastRTTMillis,
+			"avgRttMs": stats.AverageRTTMillis,
+			"samples":  stats.Samples,
+		}); err == nil {
+			select {
+			case c.send <- payload:
+			default:
+			}
+		}
+	}
+	return c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+}
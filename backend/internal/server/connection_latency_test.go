@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTWindowRollingAverage(t *testing.T) {
+	t.Parallel()
+
+	var window rttWindow
+	if window.average() != 0 {
+		t.Fatal("expected empty window to average zero")
+	}
+	for i := 1; i <= rttSampleWindow+2; i++ {
+		window.add(time.Duration(i) * time.Millisecond)
+	}
+	// Samples 1ms and 2ms fell out; 3..10ms remain.
+	if got := window.average(); got != 6500*time.Microsecond {
+		t.Fatalf("expected 6.5ms average, got %v", got)
+	}
+	if window.count != rttSampleWindow || window.last != 10*time.Millisecond {
+		t.Fatalf("unexpected window state: count=%d last=%v", window.count, window.last)
+	}
+}
+
+func TestRecordPongMeasuresStampedPings(t *testing.T) {
+	t.Parallel()
+
+	client := &Client{userID: 1, roomID: 3, send: make(chan []byte, 1)}
+	sentAt := time.Unix(1_700_000_000, 0)
+	rtt, ok := client.recordPong(string(pingPayload(sentAt)), sentAt.Add(42*time.Millisecond))
+	if !ok || rtt != 42*time.Millisecond {
+		t.Fatalf("expected 42ms round trip, got %v ok=%v", rtt, ok)
+	}
+	if _, ok := client.recordPong("", sentAt); ok {
+		t.Fatal("expected unsolicited pong to be ignored")
+	}
+	if _, ok := client.recordPong(string(pingPayload(sentAt)), sentAt.Add(-time.Second)); ok {
+		t.Fatal("expected pong from the future to be ignored")
+	}
+	latency := client.latency()
+	if latency.Samples != 1 || latency.LastRTTMillis != 42 || latency.AverageRTTMillis != 42 {
+		t.Fatalf("unexpected latency: %+v", latency)
+	}
+}
+
+func TestConnectionStatsAveragesMeasuredClients(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	fast := &Client{userID: 1, deviceID: "a", roomID: 7, send: make(chan []byte, 1)}
+	slow := &Client{userID: 2, deviceID: "b", roomID: 7, send: make(chan []byte, 1)}
+	fresh := &Client{userID: 3, deviceID: "c", roomID: 8, send: make(chan []byte, 1)}
+	for _, client := range []*Client{fast, slow, fresh} {
+		hub.AddClient(client)
+	}
+	fast.rtt.add(20 * time.Millisecond)
+	slow.rtt.add(100 * time.Millisecond)
+
+	stats := hub.ConnectionStats()
+	if stats.Connections != 3 || stats.AverageRTTMillis != 60 {
+		t.Fatalf("expected 60ms average over measured clients, got %+v", stats)
+	}
+}
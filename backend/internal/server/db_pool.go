@@ -0,0 +1,95 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultDBMaxOpenConns           = 25
+	defaultDBMaxIdleConns           = 10
+	defaultDBConnMaxLifetimeMinutes = 30
+	defaultDBConnMaxIdleTimeMinutes = 5
+
+	maxDBMaxOpenConns = 1000
+)
+
+// dbPoolConfig sizes the database/sql pool. Small deployments want a handful
+// of connections; large ones behind PgBouncer may want hundreds.
+type dbPoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+func (cfg dbPoolConfig) withDefaults() dbPoolConfig {
+	if cfg.MaxOpenConns <= 0 {
+		cfg.MaxOpenConns = defaultDBMaxOpenConns
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = defaultDBMaxIdleConns
+	}
+	if cfg.ConnMaxLifetime <= 0 {
+		cfg.ConnMaxLifetime = time.Duration(defaultDBConnMaxLifetimeMinutes) * time.Minute
+	}
+	if cfg.ConnMaxIdleTime <= 0 {
+		cfg.ConnMaxIdleTime = time.Duration(defaultDBConnMaxIdleTimeMinutes) * time.Minute
+	}
+	return cfg
+}
+
+func validateDBPool(cfg dbPoolConfig) error {
+	if cfg.MaxOpenConns > maxDBMaxOpenConns {
+		return fmt.Errorf("DB_MAX_OPEN_CONNS must be <= %d", maxDBMaxOpenConns)
+	}
+	if cfg.MaxIdleConns > cfg.MaxOpenConns {
+		return fmt.Errorf("DB_MAX_IDLE_CONNS must be <= DB_MAX_OPEN_CONNS")
+	}
+	if cfg.ConnMaxIdleTime > cfg.ConnMaxLifetime {
+		return fmt.Errorf("DB_CONN_MAX_IDLE_TIME_MINUTES must be <= DB_CONN_MAX_LIFETIME_MINUTES")
+	}
+	return nil
+}
+
+func (cfg dbPoolConfig) apply(db *sql.DB) {
+	cfg = cfg.withDefaults()
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+}
+
+type dbPoolStats struct {
+	MaxOpen           int     `json:"maxOpen"`
+	Open              int     `json:"open"`
+	InUse             int     `json:"inUse"`
+	Idle              int     `json:"idle"`
+	Utilization       float64 `json:"utilization"`
+	WaitCount         int64   `json:"waitCount"`
+	WaitDurationMS    int64   `json:"waitDurationMs"`
+	MaxIdleClosed     int64   `json:"maxIdleClosed"`
+	MaxIdleTimeClosed int64   `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed int64   `json:"maxLifetimeClosed"`
+}
+
+// newDBPoolStats reports utilization as in-use connections over the open
+// limit; a value near 1 with a growing wait count means the pool is too small.
+func newDBPoolStats(stats sql.DBStats) dbPoolStats {
+	result := dbPoolStats{
+		MaxOpen:           stats.MaxOpenConnections,
+		Open:              stats.OpenConnections,
+		InUse:             stats.InUse,
+		Idle:              stats.Idle,
+		WaitCount:         stats.WaitCount,
+		WaitDurationMS:    stats.WaitDuration.Milliseconds(),
+		MaxIdleClosed:     stats.MaxIdleClosed,
+		MaxIdleTimeClosed: stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed: stats.MaxLifetimeClosed,
+	}
+	if stats.MaxOpenConnections > 0 {
+		result.Utilization = float64(stats.InUse) / float64(stats.MaxOpenConnections)
+	}
+	return result
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReplicaHealthIntervalSecs = 5
+	replicaPingTimeout               = 2 * time.Second
+)
+
+// replicaRouter tracks whether the read replica is reachable. Reads go to the
+// replica while it answers pings and fall back to the primary otherwise, so a
+// replica outage degrades capacity but never availability.
+type replicaRouter struct {
+	db      *sql.DB
+	healthy atomic.Bool
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newReplicaRouter(db *sql.DB) *replicaRouter {
+	return &replicaRouter{
+		db:   db,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (r *replicaRouter) Start(interval time.Duration) {
+	r.check()
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.check()
+			}
+		}
+	}()
+}
+
+func (r *replicaRouter) Stop() {
+	if r == nil {
+		return
+	}
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+}
+
+func (r *replicaRouter) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+	defer cancel()
+	err := r.db.PingContext(ctx)
+	wasHealthy := r.healthy.Swap(err == nil)
+	switch {
+	case err != nil && wasHealthy:
+		logger.Warn("db_replica_unavailable", "error", err)
+	case err == nil && !wasHealthy:
+		logger.Info("db_replica_available")
+	}
+}
+
+func (r *replicaRouter) available() bool {
+	return r != nil && r.healthy.Load()
+}
+
+func (r *replicaRouter) markDown(err error) {
+	if r.healthy.Swap(false) {
+		logger.Warn("db_replica_unavailable", "error", err)
+	}
+}
+
+type replicaStats struct {
+	Healthy bool        `json:"healthy"`
+	Pool    dbPoolStats `json:"pool"`
+}
+
+func (r *replicaRouter) Stats() *replicaStats {
+	if r == nil {
+		return nil
+	}
+	return &replicaStats{Healthy: r.healthy.Load(), Pool: newDBPoolStats(r.db.Stats())}
+}
+
+// readQuery runs a read-only query on the replica when one is healthy. A
+// replica error marks it down and retries on the primary; callers must only
+// use it for reads that tolerate replication lag.
+func (a *App) readQuery(ctx context.Context, query string, args ...any) (rows *sql.Rows, err error) {
+	ctx, s := a.tracer.StartSpan(ctx, "db.read", spanKindClient, attrString("db.system", "postgresql"))
+	defer func() { s.End(err) }()
+
+	if a.replica.available() {
+		rows, err := a.replica.db.QueryContext(ctx, query, args...)
+		if err == nil {
+			s.SetAttributes(attrBool("db.replica", true))
+			return rows, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		a.replica.markDown(err)
+	}
+	s.SetAttributes(attrBool("db.replica", false))
+	return a.db.QueryContext(ctx, query, args...)
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReplicaRouterAvailability(t *testing.T) {
+	t.Parallel()
+
+	var missing *replicaRouter
+	if missing.available() || missing.Stats() != nil {
+		t.Fatalf("expected nil router to be unavailable")
+	}
+	missing.Stop()
+
+	router := newReplicaRouter(nil)
+	if router.available() {
+		t.Fatalf("expected router to start unavailable until the first ping")
+	}
+	router.healthy.Store(true)
+	if !router.available() {
+		t.Fatalf("expected healthy router to be available")
+	}
+	router.markDown(errors.New("connection refused"))
+	if router.available() {
+		t.Fatalf("expected router to be unavailable after a failed read")
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	undeliveredQueueFull = "queue_full"
+	undeliveredOffline   = "offline"
+
+	// undeliveredRetention bounds how long a missed event waits for its user
+	// to sync; after that the regular event cursor is the only way back.
+	undeliveredRetention = 30 * 24 * time.Hour
+)
+
+// deliveryReport sorts the users a tracked broadcast was meant for. A user
+// with any connection whose queue was full counts as dropped even if another
+// device received the frame.
+type deliveryReport struct {
+	delivered map[int64]struct{}
+	dropped   map[int64]struct{}
+	skipped   map[int64]struct{}
+}
+
+func addReportUser(set *map[int64]struct{}, userID int64) {
+	// Guests have no account and never sync, so they are not tracked.
+	if userID <= 0 {
+		return
+	}
+	if *set == nil {
+		*set = make(map[int64]struct{})
+	}
+	(*set)[userID] = struct{}{}
+}
+
+func (r *deliveryReport) addDelivered(userID int64) { addReportUser(&r.delivered, userID) }
+func (r *deliveryReport) addDropped(userID int64)   { addReportUser(&r.dropped, userID) }
+func (r *deliveryReport) addSkipped(userID int64)   { addReportUser(&r.skipped, userID) }
+
+// settled lists users that need no dead letter: every connection got the
+// frame, or the frame was withheld from them on purpose.
+func (r deliveryReport) settled() []int64 {
+	users := make([]int64, 0, len(r.delivered)+len(r.skipped))
+	for userID := range r.delivered {
+		if _, dropped := r.dropped[userID]; !dropped {
+			users = append(users, userID)
+		}
+	}
+	for userID := range r.skipped {
+		users = append(users, userID)
+	}
+	return users
+}
+
+func (r deliveryReport) droppedUsers() []int64 {
+	users := make([]int64, 0, len(r.dropped))
+	for userID := range r.dropped {
+		users = append(users, userID)
+	}
+	return users
+}
+
+// broadcastEvent delivers the frame for a logged event and dead-letters it for
+// every room member who did not get it live: members without a connection to
+// the room and members whose send queue was full. The actor is never
+// dead-lettered for their own event. fromUserID applies the block filter the
+// same way BroadcastFrom does; pass 0 to reach every member.
+func (a *App) broadcastEvent(roomID, eventID, actorID, fromUserID int64, payload []byte) {
+	a.hub.BroadcastTracked(roomID, fromUserID, payload, func(report deliveryReport) {
+		if eventID <= 0 || a.db == nil {
+			return
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := recordUndelivered(ctx, a.db, roomID, eventID, actorID, fromUserID, report); err != nil {
+				logger.Warn("undelivered_event_record_failed", "room_id", roomID, "event_id", eventID, "error", err)
+			}
+		}()
+	})
+}
+
+// recordUndelivered applies the block filter to offline members too, so a
+// user fromUserID blocked does not get the event back through sync.
+func recordUndelivered(ctx context.Context, exec sqlExecer, roomID, eventID, actorID, fromUserID int64, report deliveryReport) error {
+	_, err := exec.ExecContext(ctx, `
+INSERT INTO undelivered_events(user_id, event_id, reason)
+SELECT rm.user_id,
+       $2,
+       CASE WHEN rm.user_id = ANY($3::BIGINT[]) THEN 'queue_full' ELSE 'offline' END
+FROM room_members rm
+WHERE rm.room_id = $1
+  AND rm.user_id <> $5
+  AND NOT (rm.user_id = ANY($4::BIGINT[]))
+  AND NOT EXISTS (
+      SELECT 1 FROM user_blocks b
+      WHERE b.blocker_id = $6 AND b.blocked_id = rm.user_id
+  )
+ON CONFLICT DO NOTHING
+`, roomID, eventID, report.droppedUsers(), report.settled(), actorID, fromUserID)
+	return err
+}
+
+// listUndeliveredEvents returns the user's dead letters oldest first, still
+// limited to rooms the user belongs to.
+func (a *App) listUndeliveredEvents(ctx context.Context, userID int64, limit int) ([]SyncEvent, error) {
+	rows, err := a.db.QueryContext(ctx, `
+SELECT e.id, e.event_type, e.room_id, e.message_id, e.actor_id, COALESCE(u.former_username, u.username, ''), e.payload, e.created_at, ue.reason
+FROM undelivered_events ue
+JOIN events e ON e.id = ue.event_id
+JOIN room_members rm ON rm.room_id = e.room_id AND rm.user_id = ue.user_id
+LEFT JOIN users u ON u.id = e.actor_id
+WHERE ue.user_id = $1
+ORDER BY ue.event_id ASC
+LIMIT $2
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]SyncEvent, 0, 16)
+	for rows.Next() {
+		var item SyncEvent
+		var messageID sql.NullInt64
+		var actorID sql.NullInt64
+		var payload []byte
+		var createdAt time.Time
+		if err := rows.Scan(
+			&item.Cursor,
+			&item.Type,
+			&item.RoomID,
+			&messageID,
+			&actorID,
+			&item.ActorUsername,
+			&payload,
+			&createdAt,
+			&item.UndeliveredReason,
+		); err != nil {
+			return nil, err
+		}
+		if messageID.Valid {
+			value := messageID.Int64
+			item.MessageID = &value
+		}
+		if actorID.Valid {
+			value := actorID.Int64
+			item.ActorID = &value
+		}
+		if len(payload) > 0 {
+			item.Payload = json.RawMessage(payload)
+		}
+		item.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		events = append(events, item)
+	}
+	return events, rows.Err()
+}
+
+// acknowledgeUndelivered drops the user's dead letters up to and including
+// eventID, along with any that outlived the retention window.
+func (a *App) acknowledgeUndelivered(ctx context.Context, userID, eventID int64) error {
+	_, err := a.db.ExecContext(ctx, `
+DELETE FROM undelivered_events
+WHERE user_id = $1 AND (event_id <= $2 OR created_at < $3)
+`, userID, eventID, time.Now().Add(-undeliveredRetention))
+	return err
+}
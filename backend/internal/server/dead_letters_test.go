@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestBroadcastTrackedReportsDropsAndSkips(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	sender := &Client{userID: 1, deviceID: "a", roomID: 7, send: make(chan []byte, 1)}
+	reader := &Client{userID: 2, deviceID: "b", roomID: 7, send: make(chan []byte, 1)}
+	fullPhone := &Client{userID: 3, deviceID: "c", roomID: 7, send: make(chan []byte)}
+	laptop := &Client{userID: 3, deviceID: "d", roomID: 7, send: make(chan []byte, 1)}
+	blocked := &Client{userID: 4, deviceID: "e", roomID: 7, send: make(chan []byte, 1)}
+	guest := &Client{roomID: 7, guest: true, send: make(chan []byte)}
+	for _, client := range []*Client{sender, reader, fullPhone, laptop, blocked, guest} {
+		hub.AddClient(client)
+	}
+	hub.blocks.Add(1, 4)
+
+	var report deliveryReport
+	hub.BroadcastTracked(7, 1, []byte(`{"type":"read_receipt"}`), func(r deliveryReport) { report = r })
+
+	settled := report.settled()
+	sort.Slice(settled, func(i, j int) bool { return settled[i] < settled[j] })
+	if len(settled) != 3 || settled[0] != 1 || settled[1] != 2 || settled[2] != 4 {
+		t.Fatalf("expected users 1, 2 and blocked 4 to be settled, got %v", settled)
+	}
+	dropped := report.droppedUsers()
+	if len(dropped) != 1 || dropped[0] != 3 {
+		t.Fatalf("expected user 3 to count as dropped despite a second device, got %v", dropped)
+	}
+	if len(blocked.send) != 0 {
+		t.Fatal("expected blocked recipient to be skipped")
+	}
+}
+
+func TestBroadcastTrackedEmptyRoom(t *testing.T) {
+	t.Parallel()
+
+	called := false
+	var report deliveryReport
+	NewHub().BroadcastTracked(9, 0, []byte(`{}`), func(r deliveryReport) {
+		called = true
+		report = r
+	})
+	if !called {
+		t.Fatal("expected done to run for an empty room")
+	}
+	if len(report.settled()) != 0 || len(report.droppedUsers()) != 0 {
+		t.Fatalf("expected empty report, got %+v", report)
+	}
+}
+
+func TestRecordUndeliveredSkipsBlockedOfflineMembers(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	_, roomID := seedSQLiteTestRoom(t, db)
+	readerID := insertSQLiteTestMember(t, db, roomID, "reader", "user")
+	offlineID := insertSQLiteTestMember(t, db, roomID, "offline", "user")
+	blockedID := insertSQLiteTestMember(t, db, roomID, "blocked", "user")
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `INSERT INTO user_blocks(blocker_id, blocked_id) VALUES ($1, $2)`, readerID, blockedID); err != nil {
+		t.Fatalf("insert block: %v", err)
+	}
+	eventID, err := recordEvent(ctx, db, roomID, 0, readerID, eventReadReceipt, nil)
+	if err != nil {
+		t.Fatalf("record event: %v", err)
+	}
+
+	if err := recordUndelivered(ctx, db, roomID, eventID, readerID, readerID, deliveryReport{}); err != nil {
+		t.Fatalf("record undelivered: %v", err)
+	}
+	rows, err := db.QueryContext(ctx, `SELECT user_id FROM undelivered_events WHERE event_id = $1`, eventID)
+	if err != nil {
+		t.Fatalf("load dead letters: %v", err)
+	}
+	defer rows.Close()
+	var users []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			t.Fatalf("scan dead letter: %v", err)
+		}
+		users = append(users, userID)
+	}
+	if slices.Contains(users, blockedID) || !slices.Contains(users, offlineID) {
+		t.Fatalf("expected a dead letter for %d only among members, got %v", offlineID, users)
+	}
+}
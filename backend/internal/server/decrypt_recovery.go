@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	decryptRecoveryTTL              = 7 * 24 * time.Hour
+	decryptRecoveryRetryAfter       = time.Minute
+	maxDecryptRecoveryAttempts      = 5
+	maxDecryptRecoveriesPerDelivery = 64
+	maxDecryptRecoveriesListed      = 100
+
+	decryptRecoveryPending   = "pending"
+	decryptRecoveryDelivered = "delivered"
+	decryptRecoveryFulfilled = "fulfilled"
+	decryptRecoveryExpired   = "expired"
+)
+
+// decryptRecoveryRequest is one queued decrypt_recovery_request. An empty
+// SenderDeviceID addresses whichever of the sender's devices connects first.
+type decryptRecoveryRequest struct {
+	ID                int64
+	RoomID            int64
+	MessageID         int64
+	RequesterID       int64
+	RequesterUsername string
+	RequesterDeviceID string
+	SenderID          int64
+	SenderDeviceID    string
+	Action            string
+	Status            string
+	Attempts          int
+	CreatedAt         time.Time
+	LastAttemptAt     sql.NullTime
+	FulfilledAt       sql.NullTime
+	ExpiresAt         time.Time
+}
+
+func (req decryptRecoveryRequest) frame() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":         "decrypt_recovery_request",
+		"recoveryId":   req.ID,
+		"roomId":       req.RoomID,
+		"messageId":    req.MessageID,
+		"fromUserId":   req.RequesterID,
+		"fromUsername": req.RequesterUsername,
+		"fromDeviceId": req.RequesterDeviceID,
+		"toUserId":     req.SenderID,
+		"toDeviceId":   req.SenderDeviceID,
+		"action":       req.Action,
+	})
+}
+
+// effectiveDecryptRecoveryStatus reports an unfulfilled request whose TTL ran
+// out as expired; its row lingers until the next request prunes it.
+func effectiveDecryptRecoveryStatus(status string, expiresAt, now time.Time) string {
+	if status != decryptRecoveryFulfilled && !now.Before(expiresAt) {
+		return decryptRecoveryExpired
+	}
+	return status
+}
+
+// queueDecryptRecovery records a recovery request so it survives the sender
+// being offline. Asking again for the same message from the same device
+// resets the request, including one that was already fulfilled, since the
+// device evidently still cannot decrypt it.
+func (a *App) queueDecryptRecovery(ctx context.Context, req decryptRecoveryRequest) (int64, error) {
+	if _, err := a.db.ExecContext(ctx, `DELETE FROM decrypt_recovery_requests WHERE expires_at <= NOW()`); err != nil {
+		return 0, err
+	}
+	var id int64
+	err := a.db.QueryRowContext(ctx, `
+INSERT INTO decrypt_recovery_requests(room_id, message_id, requester_id, requester_device_id, sender_id, sender_device_id, action, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (message_id, requester_id, requester_device_id) DO UPDATE
+SET sender_device_id = EXCLUDED.sender_device_id,
+    action = EXCLUDED.action,
+    status = 'pending',
+    attempts = 0,
+    created_at = NOW(),
+    last_attempt_at = NULL,
+    fulfilled_at = NULL,
+    expires_at = EXCLUDED.expires_at
+RETURNING id
+`, req.RoomID, req.MessageID, req.RequesterID, req.RequesterDeviceID, req.SenderID, req.SenderDeviceID, req.Action,
+		time.Now().Add(decryptRecoveryTTL)).Scan(&id)
+	return id, err
+}
+
+// markDecryptRecoveryDelivered counts a delivery attempt for a request that
+// was handed to a connected sender device.
+func (a *App) markDecryptRecoveryDelivered(ctx context.Context, id int64) error {
+	_, err := a.db.ExecContext(ctx, `
+UPDATE decrypt_recovery_requests
+SET status = 'delivered', attempts = attempts + 1, last_attempt_at = NOW()
+WHERE id = $1 AND status <> 'fulfilled'
+`, id)
+	return err
+}
+
+// claimDecryptRecoveries returns up to limit requests the connecting sender
+// device should answer, oldest first, and counts the attempt. Requests that
+// were delivered but never answered are retried once
+// decryptRecoveryRetryAfter has passed, until maxDecryptRecoveryAttempts is
+// reached.
+func (a *App) claimDecryptRecoveries(ctx context.Context, roomID, senderID int64, deviceID string, limit int) ([]decryptRecoveryRequest, error) {
+	rows, err := a.db.QueryContext(ctx, `
+UPDATE decrypt_recovery_requests
+SET status = 'delivered', attempts = attempts + 1, last_attempt_at = NOW()
+WHERE id IN (
+  SELECT id
+  FROM decrypt_recovery_requests
+  WHERE room_id = $1
+    AND sender_id = $2
+    AND (sender_device_id = $3 OR sender_device_id = '')
+    AND expires_at > NOW()
+    AND attempts < $4
+    AND (status = 'pending' OR (status = 'delivered' AND last_attempt_at <= NOW() - $5 * INTERVAL '1 second'))
+  ORDER BY id ASC
+  LIMIT $6
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING id, room_id, message_id, requester_id,
+          (SELECT username FROM users WHERE users.id = decrypt_recovery_requests.requester_id),
+          requester_device_id, sender_id, sender_device_id, action, attempts
+`, roomID, senderID, deviceID, maxDecryptRecoveryAttempts, int64(decryptRecoveryRetryAfter/time.Second), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claimed := make([]decryptRecoveryRequest, 0, 8)
+	for rows.Next() {
+		var req decryptRecoveryRequest
+		if err := rows.Scan(&req.ID, &req.RoomID, &req.MessageID, &req.RequesterID, &req.RequesterUsername, &req.RequesterDeviceID,
+			&req.SenderID, &req.SenderDeviceID, &req.Action, &req.Attempts); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(claimed, func(i, j int) bool { return claimed[i].ID < claimed[j].ID })
+	return claimed, nil
+}
+
+// fulfillDecryptRecovery marks the requests answered by a recovery payload
+// and returns their IDs. An empty requesterDeviceID answers every device of
+// the requester that asked for the message.
+func (a *App) fulfillDecryptRecovery(ctx context.Context, roomID, messageID, senderID, requesterID int64, requesterDeviceID string) ([]int64, error) {
+	rows, err := a.db.QueryContext(ctx, `
+UPDATE decrypt_recovery_requests
+SET status = 'fulfilled', fulfilled_at = NOW()
+WHERE room_id = $1
+  AND message_id = $2
+  AND sender_id = $3
+  AND requester_id = $4
+  AND ($5 = '' OR requester_device_id = $5)
+  AND status <> 'fulfilled'
+RETURNING id
+`, roomID, messageID, senderID, requesterID, requesterDeviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, 1)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// handleRoomDecryptRecovery lists the caller's recovery requests in the room,
+// newest first, so a client can tell whether a request is still waiting on
+// the sender. messageId narrows the list to one message.
+func (a *App) handleRoomDecryptRecovery(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var messageID int64
+	if value := strings.TrimSpace(r.URL.Query().Get("messageId")); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid messageId")
+			return
+		}
+		messageID = parsed
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "not a room member")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+		return
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT id, message_id, requester_device_id, sender_id, sender_device_id, action, status, attempts,
+       created_at, last_attempt_at, fulfilled_at, expires_at
+FROM decrypt_recovery_requests
+WHERE room_id = $1 AND requester_id = $2 AND ($3 = 0 OR message_id = $3)
+ORDER BY id DESC
+LIMIT $4
+`, roomID, auth.UserID, messageID, maxDecryptRecoveriesListed)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load decrypt recovery requests")
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	requests := make([]map[string]any, 0, 8)
+	for rows.Next() {
+		var req decryptRecoveryRequest
+		if err := rows.Scan(&req.ID, &req.MessageID, &req.RequesterDeviceID, &req.SenderID, &req.SenderDeviceID, &req.Action,
+			&req.Status, &req.Attempts, &req.CreatedAt, &req.LastAttemptAt, &req.FulfilledAt, &req.ExpiresAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode decrypt recovery requests")
+			return
+		}
+		item := map[string]any{
+			"id":                req.ID,
+			"messageId":         req.MessageID,
+			"requesterDeviceId": req.RequesterDeviceID,
+			"senderId":          req.SenderID,
+			"senderDeviceId":    req.SenderDeviceID,
+			"action":            req.Action,
+			"status":            effectiveDecryptRecoveryStatus(req.Status, req.ExpiresAt, now),
+			"attempts":          req.Attempts,
+			"maxAttempts":       maxDecryptRecoveryAttempts,
+			"createdAt":         req.CreatedAt.UTC().Format(time.RFC3339Nano),
+			"expiresAt":         req.ExpiresAt.UTC().Format(time.RFC3339Nano),
+		}
+		if req.LastAttemptAt.Valid {
+			item["lastAttemptAt"] = req.LastAttemptAt.Time.UTC().Format(time.RFC3339Nano)
+		}
+		if req.FulfilledAt.Valid {
+			item["fulfilledAt"] = req.FulfilledAt.Time.UTC().Format(time.RFC3339Nano)
+		}
+		requests = append(requests, item)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to iterate decrypt recovery requests")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"roomId":   roomID,
+		"requests": requests,
+	})
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEffectiveDecryptRecoveryStatus(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	cases := []struct {
+		status    string
+		expiresAt time.Time
+		want      string
+	}{
+		{decryptRecoveryPending, now.Add(time.Hour), decryptRecoveryPending},
+		{decryptRecoveryDelivered, now.Add(time.Hour), decryptRecoveryDelivered},
+		{decryptRecoveryPending, now, decryptRecoveryExpired},
+		{decryptRecoveryDelivered, now.Add(-time.Hour), decryptRecoveryExpired},
+		// A fulfilled request stays fulfilled after its TTL.
+		{decryptRecoveryFulfilled, now.Add(-time.Hour), decryptRecoveryFulfilled},
+	}
+	for _, tc := range cases {
+		if got := effectiveDecryptRecoveryStatus(tc.status, tc.expiresAt, now); got != tc.want {
+			t.Fatalf("%s expiring at %v: got %s, want %s", tc.status, tc.expiresAt, got, tc.want)
+		}
+	}
+}
+
+func TestDecryptRecoveryRequestFrame(t *testing.T) {
+	t.Parallel()
+
+	raw, err := decryptRecoveryRequest{
+		ID:                9,
+		RoomID:            3,
+		MessageID:         42,
+		RequesterID:       1,
+		RequesterUsername: "alice",
+		RequesterDeviceID: "alice-phone",
+		SenderID:          2,
+		Action:            "resync",
+	}.frame()
+	if err != nil {
+		t.Fatalf("frame: %v", err)
+	}
+	var frame map[string]any
+	if err := json.Unmarshal(raw, &frame); err != nil {
+		t.Fatalf("decode frame: %v", err)
+	}
+	if frame["type"] != "decrypt_recovery_request" || frame["recoveryId"] != float64(9) || frame["fromDeviceId"] != "alice-phone" || frame["toUserId"] != float64(2) {
+		t.Fatalf("unexpected frame: %s", raw)
+	}
+}
@@ -105,6 +105,13 @@ type deviceRecord struct {
 	RevokedAt      sql.NullTime
 }
 
+// isNew reports whether upsertLoginDevice just created the device: an insert
+// stamps created_at and last_seen_at with the same transaction time, while a
+// returning device gets a later last_seen_at.
+func (d deviceRecord) isNew() bool {
+	return d.CreatedAt.Equal(d.LastSeenAt)
+}
+
 func (a *App) listUserDevices(ctx context.Context, userID int64) ([]deviceRecord, error) {
 	rows, err := a.db.QueryContext(ctx, `
 SELECT user_id, device_id, device_name, session_version, created_at, last_seen_at, revoked_at
@@ -159,6 +166,7 @@ WHERE user_id = $1
 	return device, nil
 }
 
+// touchDevice marks the device, and with it the user, as seen now.
 func (a *App) touchDevice(ctx context.Context, userID int64, deviceID string) (deviceRecord, error) {
 	var device deviceRecord
 	err := a.db.QueryRowContext(ctx, `
@@ -180,6 +188,9 @@ RETURNING user_id, device_id, device_name, session_version, created_at, last_see
 	if err != nil {
 		return deviceRecord{}, err
 	}
+	if _, err := a.db.ExecContext(ctx, `UPDATE users SET last_seen_at = NOW() WHERE id = $1`, userID); err != nil {
+		return deviceRecord{}, err
+	}
 	return device, nil
 }
 
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultWSDrainSecs = 10
+	drainPollInterval  = 100 * time.Millisecond
+)
+
+// NotifyRestart sends every connection a server_restarting frame. Each client
+// gets its own reconnect delay spread across window so a deploy does not turn
+// into a reconnect stampede on the remaining instances.
+func (h *Hub) NotifyRestart(window time.Duration) int {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.rooms))
+	for _, roomClients := range h.rooms {
+		for client := range roomClients {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		var delay time.Duration
+		if window > 0 {
+			delay = rand.N(window)
+		}
+		payload, err := json.Marshal(map[string]any{
+			"type":             "server_restarting",
+			"reconnectAfterMs": delay.Milliseconds(),
+		})
+		if err != nil {
+			continue
+		}
+		select {
+		case client.send <- payload:
+		default:
+			client.log().Warn(
+				"websocket_restart_notice_drop",
+				"user_id",
+				client.userID,
+				"room_id",
+				client.roomID,
+				"reason",
+				"send queue full",
+			)
+		}
+	}
+	return len(clients)
+}
+
+// startDrain stops new WebSocket upgrades and tells connected clients to move.
+// It reports false when a drain is already under way.
+func (a *App) startDrain(window time.Duration) bool {
+	if !a.draining.CompareAndSwap(false, true) {
+		return false
+	}
+	notified := a.hub.NotifyRestart(window)
+	logger.Info("websocket_drain_started", "connections", notified, "window_ms", window.Milliseconds())
+	return true
+}
+
+// drainConnections gives clients up to window to disconnect on their own,
+// closes whatever is left and then waits for frames already read to finish
+// persisting.
+func (a *App) drainConnections(window, persistTimeout time.Duration) {
+	a.startDrain(window)
+
+	deadline := time.Now().Add(window)
+	for time.Now().Before(deadline) && a.hub.ConnectionStats().Connections > 0 {
+		time.Sleep(drainPollInterval)
+	}
+	remaining := a.hub.ConnectionStats().Connections
+	a.hub.Shutdown()
+
+	if !a.waitForInflightMessages(persistTimeout) {
+		logger.Warn("websocket_drain_persist_timeout", "timeout_ms", persistTimeout.Milliseconds())
+	}
+	logger.Info("websocket_drain_completed", "closed_connections", remaining)
+}
+
+func (a *App) waitForInflightMessages(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		a.inflightMessages.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (a *App) effectiveWSDrainWindow() time.Duration {
+	if a.wsDrainWindow <= 0 {
+		return time.Duration(defaultWSDrainSecs) * time.Second
+	}
+	return a.wsDrainWindow
+}
+
+func respondDraining(w http.ResponseWriter, window time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(window.Seconds()), 10))
+	respondErrorCode(w, http.StatusServiceUnavailable, "server_draining", "server is restarting")
+}
+
+func (a *App) handleAdminDrain(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	window := a.effectiveWSDrainWindow()
+	if a.draining.Load() {
+		respondErrorCode(w, http.StatusConflict, "already_draining", "drain already in progress")
+		return
+	}
+	requestLogger(r.Context()).Warn("websocket_drain_requested", "admin_user_id", auth.UserID)
+	go a.drainConnections(window, window)
+	respondJSON(w, http.StatusAccepted, map[string]any{"draining": true, "windowMs": window.Milliseconds()})
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHubNotifyRestartSpreadsReconnects(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	alice := &Client{roomID: 1, userID: 1, send: make(chan []byte, 1)}
+	bob := &Client{roomID: 2, userID: 2, send: make(chan []byte, 1)}
+	hub.AddClient(alice)
+	hub.AddClient(bob)
+
+	window := 5 * time.Second
+	if notified := hub.NotifyRestart(window); notified != 2 {
+		t.Fatalf("expected 2 clients notified, got %d", notified)
+	}
+	for _, client := range []*Client{alice, bob} {
+		var frame struct {
+			Type             string `json:"type"`
+			ReconnectAfterMs int64  `json:"reconnectAfterMs"`
+		}
+		if err := json.Unmarshal(<-client.send, &frame); err != nil {
+			t.Fatalf("decode frame: %v", err)
+		}
+		if frame.Type != "server_restarting" || frame.ReconnectAfterMs < 0 || frame.ReconnectAfterMs >= window.Milliseconds() {
+			t.Fatalf("unexpected restart frame: %+v", frame)
+		}
+	}
+}
+
+func TestDrainRejectsUpgradesAndFailsReadiness(t *testing.T) {
+	t.Parallel()
+
+	app := &App{hub: NewHub(), wsDrainWindow: 3 * time.Second}
+	if !app.startDrain(time.Second) || app.startDrain(time.Second) {
+		t.Fatalf("expected only the first drain to start")
+	}
+
+	recorder := httptest.NewRecorder()
+	app.handleWS(recorder, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "3" {
+		t.Fatalf("expected 503 with Retry-After, got %d %q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+
+	recorder = httptest.NewRecorder()
+	app.handleAdminDrain(recorder, httptest.NewRequest(http.MethodPost, "/api/admin/drain", nil), AuthContext{UserID: 1})
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a second drain, got %d", recorder.Code)
+	}
+}
+
+func TestWaitForInflightMessages(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	app.inflightMessages.Add(1)
+	if app.waitForInflightMessages(10 * time.Millisecond) {
+		t.Fatalf("expected wait to time out with a message in flight")
+	}
+	app.inflightMessages.Done()
+	if !app.waitForInflightMessages(time.Second) {
+		t.Fatalf("expected wait to finish once the message persisted")
+	}
+}
@@ -0,0 +1,468 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	emailTemplateNewDeviceLogin   = "new_device_login"
+	emailTemplateDeviceRevoked    = "device_revoked"
+	emailTemplatePasswordChanged  = "password_changed"
+	emailTemplateOnboarding       = "account_onboarding"
+	emailTemplatePasswordReset    = "password_reset"
+	emailTemplateErasureScheduled = "account_erasure_scheduled"
+
+	emailMaxAttempts    = 6
+	emailBaseBackoff    = 30 * time.Second
+	emailMaxBackoff     = time.Hour
+	emailPollInterval   = 10 * time.Second
+	emailBatchSize      = 20
+	emailSendTimeout    = 15 * time.Second
+	emailMaxErrorLength = 500
+	maxEmailLength      = 254
+	defaultSMTPPort     = 587
+)
+
+// smtpConfig is optional; without SMTP_HOST no mail is queued at all.
+type smtpConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// BaseURL is the public address of the web client, used for links.
+	BaseURL string
+}
+
+func (cfg smtpConfig) configured() bool {
+	return cfg.Host != ""
+}
+
+func validateSMTPConfig(cfg smtpConfig) error {
+	if !cfg.configured() {
+		if cfg.Username != "" || cfg.Password != "" || cfg.From != "" {
+			return errors.New("SMTP_HOST must be set when other SMTP_* settings are")
+		}
+		return nil
+	}
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return errors.New("SMTP_PORT must be between 1 and 65535")
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return errors.New("SMTP_FROM must be a valid address")
+	}
+	if (cfg.Username == "") != (cfg.Password == "") {
+		return errors.New("SMTP_USERNAME and SMTP_PASSWORD must be set together")
+	}
+	parsed, err := url.Parse(cfg.BaseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("APP_BASE_URL must be an http(s) URL when SMTP is enabled")
+	}
+	return nil
+}
+
+// normalizeEmail accepts a bare address such as "a@example.com" and rejects
+// display names or anything net/mail would have to rewrite.
+func normalizeEmail(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" || len(value) > maxEmailLength {
+		return "", false
+	}
+	parsed, err := mail.ParseAddress(value)
+	if err != nil || parsed.Name != "" || parsed.Address != value {
+		return "", false
+	}
+	return value, true
+}
+
+type emailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newEmailTemplate(subject, body string) emailTemplate {
+	return emailTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		body:    template.Must(template.New("body").Parse(body)),
+	}
+}
+
+var emailTemplates = map[string]emailTemplate{
+	emailTemplateNewDeviceLogin: newEmailTemplate(
+		"New sign-in to your account",
+		`Hi {{.Username}},
+
+Your account was just signed in on a new device: {{.DeviceName}}.
+Time: {{.Time}}
+Address: {{.RemoteIP}}
+
+If this was not you, revoke the device from your device list and change your password.
+`),
+	emailTemplateDeviceRevoked: newEmailTemplate(
+		"A device was removed from your account",
+		`Hi {{.Username}},
+
+The device "{{.DeviceName}}" was signed out of your account at {{.Time}}.
+
+If you did not do this, change your password.
+`),
+	emailTemplatePasswordChanged: newEmailTemplate(
+		"Your password was changed",
+		`Hi {{.Username}},
+
+The password of your account was changed at {{.Time}}.
+
+If you did not do this, contact your administrator right away.
+`),
+	emailTemplateOnboarding: newEmailTemplate(
+		"Your account is ready",
+		`Hi {{.Username}},
+
+An administrator created an account for you. Choose a password to sign in for the first time:
+
+{{.Link}}
+
+The link expires at {{.ExpiresAt}}.
+`),
+	emailTemplatePasswordReset: newEmailTemplate(
+		"Reset your password",
+		`Hi {{.Username}},
+
+An administrator approved your request to reset your password. Choose a new one here:
+
+{{.Link}}
+
+The link works once and expires at {{.ExpiresAt}}. Resetting signs out all of your devices.
+`),
+	emailTemplateErasureScheduled: newEmailTemplate(
+		"Your account is scheduled for deletion",
+		`Hi {{.Username}},
+
+You asked for your account and its data to be erased. This happens at {{.ScheduledAt}}.
+
+To keep your account, sign in and cancel the request before then.
+`),
+}
+
+type renderedEmail struct {
+	Subject string
+	Body    string
+}
+
+func renderEmail(name string, data map[string]any) (renderedEmail, error) {
+	tmpl, ok := emailTemplates[name]
+	if !ok {
+		return renderedEmail{}, fmt.Errorf("unknown email template %q", name)
+	}
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return renderedEmail{}, err
+	}
+	if err := tmpl.body.Execute(&body, data); err != nil {
+		return renderedEmail{}, err
+	}
+	return renderedEmail{Subject: strings.TrimSpace(subject.String()), Body: body.String()}, nil
+}
+
+// enqueueUserEmail renders a template and queues it for the user's address.
+// Users without an address are skipped silently. Callers may pass a
+// transaction so the mail only goes out for committed state.
+func enqueueUserEmail(ctx context.Context, exec sqlExecer, userID int64, name string, data map[string]any) error {
+	rendered, err := renderEmail(name, data)
+	if err != nil {
+		return err
+	}
+	_, err = exec.ExecContext(ctx, `
+INSERT INTO email_outbox(user_id, template, recipient, subject, body)
+SELECT id, $2, email, $3, $4
+FROM users
+WHERE id = $1 AND email IS NOT NULL AND deleted_at IS NULL
+`, userID, name, rendered.Subject, rendered.Body)
+	return err
+}
+
+// notifyUserByEmail queues a security notification outside any transaction.
+// Failing to queue never fails the action that triggered it.
+func (a *App) notifyUserByEmail(ctx context.Context, userID int64, username, name string, data map[string]any) {
+	if a.mail == nil {
+		return
+	}
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["Username"] = username
+	if _, ok := data["Time"]; !ok {
+		data["Time"] = time.Now().UTC().Format(time.RFC1123)
+	}
+	if err := enqueueUserEmail(ctx, a.db, userID, name, data); err != nil {
+		requestLogger(ctx).Warn("email_enqueue_failed", "user_id", userID, "template", name, "error", err)
+		return
+	}
+	a.mail.Notify()
+}
+
+type outgoingEmail struct {
+	id        int64
+	recipient string
+	subject   string
+	body      string
+	attempts  int
+}
+
+// mailSender delivers one message. The SMTP implementation is swapped out in
+// tests.
+type mailSender interface {
+	Send(ctx context.Context, msg outgoingEmail) error
+}
+
+type smtpSender struct {
+	cfg smtpConfig
+}
+
+func (s smtpSender) Send(ctx context.Context, msg outgoingEmail) error {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	dialer := &net.Dialer{Timeout: emailSendTimeout}
+	var conn net.Conn
+	var err error
+	// Port 465 speaks TLS from the first byte; every other port upgrades
+	// with STARTTLS when the server offers it.
+	if s.cfg.Port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: s.cfg.Host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && s.cfg.Port != 465 {
+		if err := client.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	from, err := mail.ParseAddress(s.cfg.From)
+	if err != nil {
+		return err
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.recipient); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(buildEmailMessage(s.cfg.From, msg)); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func buildEmailMessage(from string, msg outgoingEmail) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.recipient)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+func emailBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := emailBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= emailMaxBackoff {
+			return emailMaxBackoff
+		}
+	}
+	return delay
+}
+
+// emailDispatcher drains email_outbox in the background the same way the
+// webhook dispatcher drains its deliveries.
+type emailDispatcher struct {
+	db     *sql.DB
+	sender mailSender
+	now    func() time.Time
+	wake   chan struct{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newEmailDispatcher(db *sql.DB, sender mailSender) *emailDispatcher {
+	return &emailDispatcher{
+		db:     db,
+		sender: sender,
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (d *emailDispatcher) Start() {
+	go d.run()
+}
+
+func (d *emailDispatcher) Notify() {
+	if d == nil {
+		return
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *emailDispatcher) Stop() {
+	if d == nil {
+		return
+	}
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+	<-d.done
+}
+
+func (d *emailDispatcher) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(emailPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			processed, err := d.processBatch()
+			if err != nil {
+				logger.Warn("email_dispatch_failed", "error", err)
+				break
+			}
+			if processed < emailBatchSize {
+				break
+			}
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *emailDispatcher) processBatch() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout*emailBatchSize)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT id, recipient, subject, body, attempts
+FROM email_outbox
+WHERE status = 'pending' AND next_attempt_at <= NOW()
+ORDER BY next_attempt_at ASC, id ASC
+LIMIT $1
+FOR UPDATE SKIP LOCKED
+`, emailBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	emails := make([]outgoingEmail, 0, emailBatchSize)
+	for rows.Next() {
+		var item outgoingEmail
+		if err := rows.Scan(&item.id, &item.recipient, &item.subject, &item.body, &item.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		emails = append(emails, item)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	for _, item := range emails {
+		sendCtx, sendCancel := context.WithTimeout(ctx, emailSendTimeout)
+		sendErr := d.sender.Send(sendCtx, item)
+		sendCancel()
+		attempts := item.attempts + 1
+		if sendErr == nil {
+			if _, err := tx.ExecContext(ctx, `
+UPDATE email_outbox
+SET status = 'sent', attempts = $2, last_error = NULL, sent_at = NOW()
+WHERE id = $1
+`, item.id, attempts); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		status := "pending"
+		if attempts >= emailMaxAttempts {
+			status = "failed"
+			logger.Warn("email_delivery_failed", "email_id", item.id, "attempts", attempts, "error", sendErr)
+		}
+		message := sendErr.Error()
+		if len(message) > emailMaxErrorLength {
+			message = message[:emailMaxErrorLength]
+		}
+		if _, err := tx.ExecContext(ctx, `
+UPDATE email_outbox
+SET status = $2, attempts = $3, last_error = $4, next_attempt_at = $5
+WHERE id = $1
+`, item.id, status, attempts, message, d.now().Add(emailBackoff(attempts))); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(emails), nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderEmailTemplates(t *testing.T) {
+	t.Parallel()
+
+	data := map[string]any{
+		"Username":    "alice",
+		"DeviceName":  "Laptop",
+		"RemoteIP":    "203.0.113.7",
+		"Time":        "Mon, 02 Jan 2006 15:04:05 UTC",
+		"Link":        "https://chat.example/onboarding?token=abc",
+		"ExpiresAt":   "Thu, 05 Jan 2006 15:04:05 UTC",
+		"ScheduledAt": "Fri, 06 Jan 2006 15:04:05 UTC",
+	}
+	for name := range emailTemplates {
+		rendered, err := renderEmail(name, data)
+		if err != nil {
+			t.Fatalf("render %s: %v", name, err)
+		}
+		if rendered.Subject == "" || !strings.Contains(rendered.Body, "alice") {
+			t.Fatalf("render %s: unexpected output %+v", name, rendered)
+		}
+	}
+	rendered, _ := renderEmail(emailTemplateOnboarding, data)
+	if !strings.Contains(rendered.Body, "https://chat.example/onboarding?token=abc") {
+		t.Fatalf("expected onboarding link in body, got %q", rendered.Body)
+	}
+	if _, err := renderEmail("unknown", data); err == nil {
+		t.Fatal("expected unknown template to fail")
+	}
+}
+
+func TestValidateSMTPConfig(t *testing.T) {
+	t.Parallel()
+
+	valid := smtpConfig{Host: "smtp.example", Port: 587, From: "Chat <chat@example.com>", BaseURL: "https://chat.example"}
+	if err := validateSMTPConfig(valid); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+	if err := validateSMTPConfig(smtpConfig{}); err != nil {
+		t.Fatalf("expected disabled config to pass, got %v", err)
+	}
+	for name, cfg := range map[string]smtpConfig{
+		"stray settings": {From: "chat@example.com"},
+		"bad from":       {Host: "smtp.example", Port: 587, From: "nope", BaseURL: "https://chat.example"},
+		"half auth":      {Host: "smtp.example", Port: 587, From: "chat@example.com", Username: "u", BaseURL: "https://chat.example"},
+		"no base url":    {Host: "smtp.example", Port: 587, From: "chat@example.com"},
+	} {
+		if err := validateSMTPConfig(cfg); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	t.Parallel()
+
+	for value, want := range map[string]bool{
+		" alice@example.com ":              true,
+		"Alice <alice@example.com>":        false,
+		"not-an-address":                   false,
+		"":                                 false,
+		strings.Repeat("a", 250) + "@x.io": false,
+	} {
+		if _, ok := normalizeEmail(value); ok != want {
+			t.Fatalf("normalizeEmail(%q) = %v, want %v", value, ok, want)
+		}
+	}
+}
+
+func TestBuildEmailMessage(t *testing.T) {
+	t.Parallel()
+
+	raw := string(buildEmailMessage("chat@example.com", outgoingEmail{
+		recipient: "alice@example.com",
+		subject:   "Neue Anmeldung",
+		body:      "line one\nline two\n",
+	}))
+	if !strings.Contains(raw, "To: alice@example.com\r\n") || !strings.Contains(raw, "\r\n\r\nline one\r\nline two\r\n") {
+		t.Fatalf("unexpected message: %q", raw)
+	}
+}
+
+func TestEmailBackoff(t *testing.T) {
+	t.Parallel()
+
+	if emailBackoff(1) != emailBaseBackoff || emailBackoff(2) != 2*emailBaseBackoff {
+		t.Fatalf("unexpected backoff progression: %v %v", emailBackoff(1), emailBackoff(2))
+	}
+	if emailBackoff(50) != emailMaxBackoff {
+		t.Fatalf("expected backoff to cap at %v", emailMaxBackoff)
+	}
+}
+
+func TestDeviceRecordIsNew(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	if !(deviceRecord{CreatedAt: now, LastSeenAt: now}).isNew() {
+		t.Fatal("expected a device seen at creation time to be new")
+	}
+	if (deviceRecord{CreatedAt: now, LastSeenAt: now.Add(time.Second)}).isNew() {
+		t.Fatal("expected a returning device not to be new")
+	}
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+const (
+	eventMessageCreated = "message_created"
+	eventMessageEdited  = "message_edited"
+	eventMessageRevoked = "message_revoked"
+	// eventSafetyNumberChanged is a room-level system event: message_id is
+	// NULL and actor_id is the user whose identity key changed.
+	eventSafetyNumberChanged = "safety_number_changed"
+	// eventRoomOwnershipChanged is a room-level system event; actor_id is
+	// whoever performed the transfer.
+	eventRoomOwnershipChanged = "room_ownership_changed"
+	// Membership and rename events are room-level too; actor_id is the user
+	// who joined or left, or whoever renamed the room.
+	eventMemberJoined = "member_joined"
+	eventMemberLeft   = "member_left"
+	eventRoomRenamed  = "room_renamed"
+	// eventReadReceipt references the message read up to; actor_id is the
+	// reader.
+	eventReadReceipt = "read_receipt"
+)
+
+// History entries carry a kind so clients can tell ciphertext messages from
+// plaintext system events in one list.
+const (
+	historyKindMessage = "message"
+	historyKindSystem  = "system"
+
+	maxSystemEventsPerPage = 200
+
+	// eventSyncSettleWindow bounds how long a transaction that took an event
+	// ID may still be running; it has to exceed the longest write timeout.
+	eventSyncSettleWindow = time.Minute
+)
+
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// recordEvent appends to the global event log and returns the new event's
+// ID; callers pass the transaction that performed the mutation so the cursor
+// never points at rolled-back state.
+func recordEvent(
+	ctx context.Context,
+	q sqlQueryer,
+	roomID int64,
+	messageID int64,
+	actorID int64,
+	eventType string,
+	payload json.RawMessage,
+) (int64, error) {
+	var messageRef sql.NullInt64
+	if messageID > 0 {
+		messageRef = sql.NullInt64{Int64: messageID, Valid: true}
+	}
+	var actorRef sql.NullInt64
+	if actorID > 0 {
+		actorRef = sql.NullInt64{Int64: actorID, Valid: true}
+	}
+	var payloadRef any
+	if len(payload) > 0 {
+		payloadRef = []byte(payload)
+	}
+	var eventID int64
+	err := q.QueryRowContext(ctx, `
+INSERT INTO events(room_id, message_id, actor_id, event_type, payload)
+VALUES ($1, $2, $3, $4, $5::jsonb)
+RETURNING id
+`, roomID, messageRef, actorRef, eventType, payloadRef).Scan(&eventID)
+	return eventID, err
+}
+
+// eventSyncHorizon returns the lowest event ID above cursor that is missing
+// while a later event exists, or 0 when there is no such gap. Event IDs are
+// taken from a sequence before commit, so a missing ID usually belongs to a
+// transaction that has not committed yet; syncing past it would skip that
+// event for good. Gaps left behind for longer than eventSyncSettleWindow are
+// rollbacks and no longer hold the cursor back.
+func eventSyncHorizon(ctx context.Context, q sqlQueryer, cursor int64) (int64, error) {
+	var horizon sql.NullInt64
+	err := q.QueryRowContext(ctx, `
+SELECT MIN(e.id) - 1
+FROM events e
+WHERE e.created_at > $2
+  AND e.id > $1 + 1
+  AND NOT EXISTS (SELECT 1 FROM events p WHERE p.id = e.id - 1)
+`, cursor, time.Now().Add(-eventSyncSettleWindow)).Scan(&horizon)
+	return horizon.Int64, err
+}
+
+// listUserEventsSince returns the user's events after cursor, stopping at
+// the sync horizon, and leaves out read receipts from readers who blocked
+// the user, matching the live fan-out in broadcastReadReceipt.
+func (a *App) listUserEventsSince(ctx context.Context, userID, cursor int64, limit int) ([]SyncEvent, error) {
+	horizon, err := eventSyncHorizon(ctx, a.db, cursor)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.db.QueryContext(ctx, `
+SELECT e.id, e.event_type, e.room_id, e.message_id, e.actor_id, COALESCE(u.former_username, u.username, ''), e.payload, e.created_at
+FROM events e
+JOIN room_members rm ON rm.room_id = e.room_id AND rm.user_id = $1
+LEFT JOIN users u ON u.id = e.actor_id
+WHERE e.id > $2
+  AND ($5 = 0 OR e.id < $5)
+  AND NOT (
+      e.event_type = $4
+      AND EXISTS (
+          SELECT 1 FROM user_blocks b
+          WHERE b.blocker_id = e.actor_id AND b.blocked_id = $1
+      )
+  )
+ORDER BY e.id ASC
+LIMIT $3
+`, userID, cursor, limit, eventReadReceipt, horizon)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]SyncEvent, 0, limit)
+	for rows.Next() {
+		var item SyncEvent
+		var messageID sql.NullInt64
+		var actorID sql.NullInt64
+		var payload []byte
+		var createdAt time.Time
+		if err := rows.Scan(
+			&item.Cursor,
+			&item.Type,
+			&item.RoomID,
+			&messageID,
+			&actorID,
+			&item.ActorUsername,
+			&payload,
+			&createdAt,
+		); err != nil {
+			return nil, err
+		}
+		if messageID.Valid {
+			value := messageID.Int64
+			item.MessageID = &value
+		}
+		if actorID.Valid {
+			value := actorID.Int64
+			item.ActorID = &value
+		}
+		if len(payload) > 0 {
+			item.Payload = json.RawMessage(payload)
+		}
+		item.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		events = append(events, item)
+	}
+	return events, rows.Err()
+}
+
+// listRoomSystemEvents returns room-level events (those without a message)
+// created in [from, to); a zero bound is open. When more than the page cap
+// match, the newest are kept.
+func (a *App) listRoomSystemEvents(ctx context.Context, roomID int64, from, to time.Time) ([]SystemEvent, error) {
+	var fromRef, toRef sql.NullTime
+	if !from.IsZero() {
+		fromRef = sql.NullTime{Time: from, Valid: true}
+	}
+	if !to.IsZero() {
+		toRef = sql.NullTime{Time: to, Valid: true}
+	}
+	rows, err := a.readQuery(ctx, `
+SELECT e.id, e.event_type, e.actor_id, COALESCE(u.former_username, u.username, ''), e.payload, e.created_at
+FROM events e
+LEFT JOIN users u ON u.id = e.actor_id
+WHERE e.room_id = $1
+  AND e.message_id IS NULL
+  AND ($2::TIMESTAMPTZ IS NULL OR e.created_at >= $2)
+  AND ($3::TIMESTAMPTZ IS NULL OR e.created_at < $3)
+ORDER BY e.created_at DESC, e.id DESC
+LIMIT $4
+`, roomID, fromRef, toRef, maxSystemEventsPerPage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]SystemEvent, 0, 8)
+	for rows.Next() {
+		item := SystemEvent{Kind: historyKindSystem, RoomID: roomID}
+		var actorID sql.NullInt64
+		var payload []byte
+		var createdAt time.Time
+		if err := rows.Scan(&item.ID, &item.Type, &actorID, &item.ActorUsername, &payload, &createdAt); err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			value := actorID.Int64
+			item.ActorID = &value
+		}
+		if len(payload) > 0 {
+			item.Payload = json.RawMessage(payload)
+		}
+		item.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		events = append(events, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for left, right := 0, len(events)-1; left < right; left, right = left+1, right-1 {
+		events[left], events[right] = events[right], events[left]
+	}
+	return events, nil
+}
+
+// withSystemEvents interleaves system events into a page of messages sorted
+// oldest first. Each page claims the events from its oldest message up to the
+// next newer message, so consecutive pages never show an event twice or drop
+// one; the first and last pages of a room are open on their outer side.
+func (a *App) withSystemEvents(ctx context.Context, roomID int64, messages []StoredMessage) ([]any, error) {
+	var from, to time.Time
+	if len(messages) > 0 {
+		var hasOlder bool
+		var nextCreatedAt sql.NullTime
+		if err := a.db.QueryRowContext(ctx, `
+SELECT
+	EXISTS(SELECT 1 FROM messages WHERE room_id = $1 AND id < $2),
+	(SELECT created_at FROM messages WHERE room_id = $1 AND id > $3 ORDER BY id ASC LIMIT 1)
+`, roomID, messages[0].ID, messages[len(messages)-1].ID).Scan(&hasOlder, &nextCreatedAt); err != nil {
+			return nil, err
+		}
+		if hasOlder {
+			from, _ = time.Parse(time.RFC3339Nano, messages[0].CreatedAt)
+		}
+		if nextCreatedAt.Valid {
+			to = nextCreatedAt.Time
+		}
+	}
+	events, err := a.listRoomSystemEvents(ctx, roomID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return interleaveHistory(messages, events), nil
+}
+
+func interleaveHistory(messages []StoredMessage, events []SystemEvent) []any {
+	type entry struct {
+		at   time.Time
+		item any
+	}
+	entries := make([]entry, 0, len(messages)+len(events))
+	for _, message := range messages {
+		at, _ := time.Parse(time.RFC3339Nano, message.CreatedAt)
+		entries = append(entries, entry{at: at, item: message})
+	}
+	for _, event := range events {
+		at, _ := time.Parse(time.RFC3339Nano, event.CreatedAt)
+		entries = append(entries, entry{at: at, item: event})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].at.Before(entries[j].at)
+	})
+	history := make([]any, 0, len(entries))
+	for _, item := range entries {
+		history = append(history, item.item)
+	}
+	return history
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestInterleaveHistoryOrdersByCreatedAt(t *testing.T) {
+	t.Parallel()
+
+	messages := []StoredMessage{
+		{Kind: historyKindMessage, ID: 1, CreatedAt: "2026-01-01T10:00:00Z"},
+		{Kind: historyKindMessage, ID: 2, CreatedAt: "2026-01-01T10:00:02.5Z"},
+	}
+	events := []SystemEvent{
+		{Kind: historyKindSystem, ID: 9, Type: eventMemberJoined, CreatedAt: "2026-01-01T10:00:01Z"},
+		{Kind: historyKindSystem, ID: 10, Type: eventRoomRenamed, CreatedAt: "2026-01-01T10:00:03Z"},
+	}
+
+	history := interleaveHistory(messages, events)
+	encoded, err := json.Marshal(history)
+	if err != nil {
+		t.Fatalf("marshal history: %v", err)
+	}
+	var decoded []struct {
+		Kind string `json:"kind"`
+		ID   int64  `json:"id"`
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("unmarshal history: %v", err)
+	}
+	want := []struct {
+		kind string
+		id   int64
+	}{
+		{historyKindMessage, 1},
+		{historyKindSystem, 9},
+		{historyKindMessage, 2},
+		{historyKindSystem, 10},
+	}
+	if len(decoded) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(decoded))
+	}
+	for i, entry := range want {
+		if decoded[i].Kind != entry.kind || decoded[i].ID != entry.id {
+			t.Fatalf("entry %d: expected %s %d, got %+v", i, entry.kind, entry.id, decoded[i])
+		}
+	}
+}
+
+func TestListUserEventsSinceHidesReceiptsFromBlockers(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	_, roomID := seedSQLiteTestRoom(t, db)
+	readerID := insertSQLiteTestMember(t, db, roomID, "reader", "user")
+	blockedID := insertSQLiteTestMember(t, db, roomID, "blocked", "user")
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `INSERT INTO user_blocks(blocker_id, blocked_id) VALUES ($1, $2)`, readerID, blockedID); err != nil {
+		t.Fatalf("insert block: %v", err)
+	}
+	receiptID, err := recordEvent(ctx, db, roomID, 0, readerID, eventReadReceipt, nil)
+	if err != nil {
+		t.Fatalf("record receipt: %v", err)
+	}
+	renameID, err := recordEvent(ctx, db, roomID, 0, readerID, eventRoomRenamed, nil)
+	if err != nil {
+		t.Fatalf("record rename: %v", err)
+	}
+
+	app := &App{db: db}
+	events, err := app.listUserEventsSince(ctx, blockedID, 0, 10)
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if len(events) != 1 || events[0].Cursor != renameID {
+		t.Fatalf("expected only the rename for the blocked user, got %+v", events)
+	}
+	if events, err = app.listUserEventsSince(ctx, readerID, 0, 10); err != nil || len(events) != 2 || events[0].Cursor != receiptID {
+		t.Fatalf("expected the reader to see both events, got %+v %v", events, err)
+	}
+}
+
+func TestListUserEventsSinceStopsAtUncommittedGap(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	adminID, roomID := seedSQLiteTestRoom(t, db)
+	ctx := context.Background()
+	// ID 3 stands for an event whose transaction has not committed yet.
+	for _, id := range []int64{1, 2, 4} {
+		if _, err := db.ExecContext(ctx,
+			`INSERT INTO events(id, room_id, actor_id, event_type) VALUES ($1, $2, $3, $4)`, id, roomID, adminID, eventRoomRenamed,
+		); err != nil {
+			t.Fatalf("insert event %d: %v", id, err)
+		}
+	}
+
+	app := &App{db: db}
+	events, err := app.listUserEventsSince(ctx, adminID, 0, 10)
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if len(events) != 2 || events[1].Cursor != 2 {
+		t.Fatalf("expected sync to stop below the gap, got %+v", events)
+	}
+	if events, err = app.listUserEventsSince(ctx, adminID, 3, 10); err != nil || len(events) != 1 || events[0].Cursor != 4 {
+		t.Fatalf("expected the gap to be ignored once passed, got %+v %v", events, err)
+	}
+
+	if _, err := db.ExecContext(ctx,
+		`UPDATE events SET created_at = $1 WHERE id = 4`, time.Now().Add(-2*eventSyncSettleWindow),
+	); err != nil {
+		t.Fatalf("age event: %v", err)
+	}
+	if events, err = app.listUserEventsSince(ctx, adminID, 2, 10); err != nil || len(events) != 1 || events[0].Cursor != 4 {
+		t.Fatalf("expected a settled gap to be skipped, got %+v %v", events, err)
+	}
+}
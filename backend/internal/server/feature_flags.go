@@ -0,0 +1,326 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	featureSenderKeys   = "sender_keys"
+	featureReactions    = "reactions"
+	featureSSETransport = "sse_transport"
+
+	featureFlagRefreshInterval = 30 * time.Second
+	maxFeatureFlagDescription  = 256
+)
+
+// featureFlagDefaults is what a deployment gets with no feature_flags rows.
+// Flags the server itself checks default to the behaviour that shipped
+// before they existed; the rest are read only by clients.
+var featureFlagDefaults = map[string]bool{
+	featureSenderKeys:   true,
+	featureReactions:    false,
+	featureSSETransport: false,
+}
+
+var featureFlagNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,63}$`)
+
+// featureFlags caches the feature_flags table the same way ipDenylist caches
+// its table: a timer refresh plus an immediate one after admin changes.
+type featureFlags struct {
+	db        *sql.DB
+	overrides atomic.Pointer[map[string]bool]
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newFeatureFlags(db *sql.DB) *featureFlags {
+	return &featureFlags{
+		db:   db,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Enabled reports the stored value for name, falling back to its default.
+// Unknown flags are off.
+func (f *featureFlags) Enabled(name string) bool {
+	if f != nil {
+		if overrides := f.overrides.Load(); overrides != nil {
+			if enabled, ok := (*overrides)[name]; ok {
+				return enabled
+			}
+		}
+	}
+	return featureFlagDefaults[name]
+}
+
+// Snapshot returns every known flag with its effective value.
+func (f *featureFlags) Snapshot() map[string]bool {
+	flags := make(map[string]bool, len(featureFlagDefaults))
+	for name, enabled := range featureFlagDefaults {
+		flags[name] = enabled
+	}
+	if f != nil {
+		if overrides := f.overrides.Load(); overrides != nil {
+			for name, enabled := range *overrides {
+				flags[name] = enabled
+			}
+		}
+	}
+	return flags
+}
+
+func (f *featureFlags) Refresh(ctx context.Context) error {
+	if f == nil {
+		return nil
+	}
+	rows, err := f.db.QueryContext(ctx, `SELECT name, enabled FROM feature_flags`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	overrides := make(map[string]bool, 8)
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return err
+		}
+		overrides[name] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	f.overrides.Store(&overrides)
+	return nil
+}
+
+// Start loads the flags once synchronously so the first requests see them,
+// then keeps them fresh in the background.
+func (f *featureFlags) Start(ctx context.Context) error {
+	if err := f.Refresh(ctx); err != nil {
+		return err
+	}
+	go f.run()
+	return nil
+}
+
+func (f *featureFlags) Stop() {
+	if f == nil {
+		return
+	}
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+	<-f.done
+}
+
+func (f *featureFlags) run() {
+	defer close(f.done)
+	ticker := time.NewTicker(featureFlagRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := f.Refresh(ctx); err != nil {
+			logger.Warn("feature_flags_refresh_failed", "error", err)
+		}
+		cancel()
+	}
+}
+
+func respondFeatureDisabled(w http.ResponseWriter, name string) {
+	respondErrorDetails(w, http.StatusNotFound, "feature_disabled", "feature is disabled", map[string]any{"feature": name})
+}
+
+// handleFeatures serves GET /api/features. It needs no session so the
+// frontend can adapt before sign-in.
+func (a *App) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"features":                   a.features.Snapshot(),
+		"signatureCanonicalizations": supportedSignatureCanonicalizations,
+	})
+}
+
+type featureFlagResp struct {
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	Default     *bool  `json:"default,omitempty"`
+	Description string `json:"description,omitempty"`
+	UpdatedBy   *int64 `json:"updatedBy,omitempty"`
+	UpdatedAt   string `json:"updatedAt,omitempty"`
+}
+
+// handleAdminFeatures lists every flag: the built-in ones, whether or not
+// they are overridden, and any extra ones admins added for clients.
+func (a *App) handleAdminFeatures(w http.ResponseWriter, r *http.Request, _ AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `SELECT name, enabled, description, updated_by, updated_at FROM feature_flags`)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list feature flags")
+		return
+	}
+	defer rows.Close()
+
+	byName := make(map[string]featureFlagResp, len(featureFlagDefaults))
+	for name, enabled := range featureFlagDefaults {
+		byName[name] = featureFlagResp{Name: name, Enabled: enabled}
+	}
+	for rows.Next() {
+		var flag featureFlagResp
+		var updatedBy sql.NullInt64
+		var updatedAt time.Time
+		if err := rows.Scan(&flag.Name, &flag.Enabled, &flag.Description, &updatedBy, &updatedAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode feature flags")
+			return
+		}
+		if updatedBy.Valid {
+			value := updatedBy.Int64
+			flag.UpdatedBy = &value
+		}
+		flag.UpdatedAt = updatedAt.UTC().Format(time.RFC3339Nano)
+		byName[flag.Name] = flag
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list feature flags")
+		return
+	}
+
+	flags := make([]featureFlagResp, 0, len(byName))
+	for name, flag := range byName {
+		if enabled, ok := featureFlagDefaults[name]; ok {
+			flag.Default = &enabled
+		}
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	respondJSON(w, http.StatusOK, map[string]any{"features": flags})
+}
+
+// handleAdminFeatureSubroutes serves PUT and DELETE
+// /api/admin/features/{name}. Deleting a built-in flag returns it to its
+// default.
+func (a *App) handleAdminFeatureSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "features" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	name := parts[3]
+	if !featureFlagNamePattern.MatchString(name) {
+		respondErrorCode(w, http.StatusBadRequest, "invalid_feature_name", "invalid feature name")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Enabled     bool   `json:"enabled"`
+			Description string `json:"description"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		req.Description = strings.TrimSpace(req.Description)
+		if len(req.Description) > maxFeatureFlagDescription {
+			respondError(w, http.StatusBadRequest, "description must be at most 256 characters")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update feature flag")
+			return
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO feature_flags(name, enabled, description, updated_by, updated_at)
+VALUES ($1, $2, $3, $4, NOW())
+ON CONFLICT (name) DO UPDATE
+SET enabled = EXCLUDED.enabled, description = EXCLUDED.description,
+    updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at
+`, name, req.Enabled, req.Description, auth.UserID); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update feature flag")
+			return
+		}
+		if err := recordAdminAudit(ctx, tx, auth.UserID, auditFeatureFlagChanged, 0, map[string]any{"feature": name, "enabled": req.Enabled}); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update feature flag")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update feature flag")
+			return
+		}
+		a.refreshFeatureFlags(ctx, r)
+		requestLogger(r.Context()).Warn("feature_flag_changed", "feature", name, "enabled", req.Enabled, "admin_user_id", auth.UserID)
+		respondJSON(w, http.StatusOK, featureFlagResp{Name: name, Enabled: req.Enabled, Description: req.Description})
+
+	case http.MethodDelete:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to remove feature flag")
+			return
+		}
+		defer tx.Rollback()
+		var removed string
+		err = tx.QueryRowContext(ctx, `DELETE FROM feature_flags WHERE name = $1 RETURNING name`, name).Scan(&removed)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "feature flag not set")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to remove feature flag")
+			return
+		}
+		if err := recordAdminAudit(ctx, tx, auth.UserID, auditFeatureFlagChanged, 0, map[string]any{"feature": name, "removed": true}); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to remove feature flag")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to remove feature flag")
+			return
+		}
+		a.refreshFeatureFlags(ctx, r)
+		requestLogger(r.Context()).Warn("feature_flag_removed", "feature", name, "admin_user_id", auth.UserID)
+		respondJSON(w, http.StatusOK, map[string]any{"removed": true, "name": name, "enabled": a.features.Enabled(name)})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) refreshFeatureFlags(ctx context.Context, r *http.Request) {
+	if err := a.features.Refresh(ctx); err != nil {
+		requestLogger(r.Context()).Warn("feature_flags_refresh_failed", "error", err)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFeatureFlagsFallBackToDefaults(t *testing.T) {
+	t.Parallel()
+
+	var unloaded *featureFlags
+	if !unloaded.Enabled(featureSenderKeys) || unloaded.Enabled(featureReactions) || unloaded.Enabled("unknown_flag") {
+		t.Fatal("expected defaults from an unloaded flag store")
+	}
+
+	flags := newFeatureFlags(nil)
+	flags.overrides.Store(&map[string]bool{featureSenderKeys: false, "dark_launch": true})
+	if flags.Enabled(featureSenderKeys) || !flags.Enabled("dark_launch") || flags.Enabled(featureSSETransport) {
+		t.Fatalf("expected stored values to override defaults, got %v", flags.Snapshot())
+	}
+	snapshot := flags.Snapshot()
+	if len(snapshot) != len(featureFlagDefaults)+1 || snapshot[featureSenderKeys] || !snapshot["dark_launch"] {
+		t.Fatalf("unexpected snapshot: %v", snapshot)
+	}
+}
+
+func TestHandleFeaturesListsEffectiveFlags(t *testing.T) {
+	t.Parallel()
+
+	app := &App{features: newFeatureFlags(nil)}
+	app.features.overrides.Store(&map[string]bool{featureReactions: true})
+
+	recorder := httptest.NewRecorder()
+	app.handleFeatures(recorder, httptest.NewRequest(http.MethodGet, "/api/features", nil))
+	var body struct {
+		Features map[string]bool `json:"features"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if !body.Features[featureReactions] || !body.Features[featureSenderKeys] {
+		t.Fatalf("unexpected features: %v", body.Features)
+	}
+}
+
+func TestAdminFeatureSubroutesValidateName(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	for path, want := range map[string]int{
+		"/api/admin/features/Bad-Name": http.StatusBadRequest,
+		"/api/admin/features/x":        http.StatusBadRequest,
+		"/api/admin/features/a/b":      http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		app.handleAdminFeatureSubroutes(recorder, httptest.NewRequest(http.MethodPut, path, nil), AuthContext{UserID: 1})
+		if recorder.Code != want {
+			t.Fatalf("%s: expected %d, got %d", path, want, recorder.Code)
+		}
+	}
+}
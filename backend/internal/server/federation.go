@@ -0,0 +1,385 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	federationMaxHops           = 8
+	federationMaxClockSkew      = 5 * time.Minute
+	federationMaxAttempts       = 12
+	federationPollInterval      = 5 * time.Second
+	federationDeliveryBatchSize = 20
+	federationRequestTimeout    = 10 * time.Second
+	federationRelayUserPrefix   = "fed:"
+	minFederationSecretLength   = 32
+
+	// federationClaimLease hides claimed envelopes from other relays for a
+	// full batch of timed-out requests, like webhookClaimLease.
+	federationClaimLease = federationRequestTimeout * (federationDeliveryBatchSize + 1)
+)
+
+var (
+	federationServerIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,62}[a-z0-9]$`)
+
+	errFederationLoop        = errors.New("message already passed through this server")
+	errFederationTooManyHops = errors.New("relay path exceeds hop limit")
+	errFederationBadPath     = errors.New("relay path must end with the sending peer")
+)
+
+func validateFederationServerID(serverID string) error {
+	if !federationServerIDPattern.MatchString(serverID) {
+		return errors.New("server id must be 3-64 characters of a-z, 0-9, '.' or '-'")
+	}
+	return nil
+}
+
+// federationEnvelope is the server-to-server wire format. Path lists every
+// server that has written the message, starting with the origin; a server
+// never accepts an envelope that already names it and never forwards one to
+// a peer listed in it.
+type federationEnvelope struct {
+	OriginServer    string          `json:"originServer"`
+	OriginMessageID int64           `json:"originMessageId"`
+	Path            []string        `json:"path"`
+	RoomID          int64           `json:"roomId"`
+	SenderUsername  string          `json:"senderUsername"`
+	CreatedAt       string          `json:"createdAt"`
+	Payload         json.RawMessage `json:"payload"`
+}
+
+func localFederationEnvelope(messageID int64, createdAt time.Time, payloadJSON []byte) federationEnvelope {
+	return federationEnvelope{
+		OriginMessageID: messageID,
+		CreatedAt:       createdAt.UTC().Format(time.RFC3339Nano),
+		Payload:         payloadJSON,
+	}
+}
+
+// checkFederationPath validates an inbound envelope's route before anything is
+// stored.
+func checkFederationPath(envelope federationEnvelope, localServerID, peerServerID string) error {
+	if len(envelope.Path) == 0 || envelope.Path[0] != envelope.OriginServer {
+		return errFederationBadPath
+	}
+	if envelope.Path[len(envelope.Path)-1] != peerServerID {
+		return errFederationBadPath
+	}
+	if len(envelope.Path) > federationMaxHops {
+		return errFederationTooManyHops
+	}
+	for _, serverID := range envelope.Path {
+		if serverID == localServerID {
+			return errFederationLoop
+		}
+	}
+	return nil
+}
+
+func federationSenderLabel(username, originServer string) string {
+	return username + "@" + originServer
+}
+
+type federationOutboxItem struct {
+	id       int64
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+// federationRelay pushes messages in linked rooms to peer deployments. It
+// mirrors the webhook dispatcher: rows are enqueued inside the message
+// transaction and claimed in the background under a SKIP LOCKED lease.
+type federationRelay struct {
+	serverID string
+	db       *sql.DB
+	client   *http.Client
+	now      func() time.Time
+	wake     chan struct{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newFederationRelay(db *sql.DB, serverID string) *federationRelay {
+	return &federationRelay{
+		serverID: serverID,
+		db:       db,
+		// Peer URLs are entered by admins, but DNS can still rebind them to
+		// internal addresses, so every dial is vetted like a webhook's.
+		client: newWebhookHTTPClient(webhookDialControl),
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// enqueueMessage queues an envelope for every peer linked to the room that is
+// not already on its path. Local messages leave OriginServer empty and are
+// stamped with this server's id.
+func (f *federationRelay) enqueueMessage(ctx context.Context, exec sqlExecer, roomID, senderID int64, envelope federationEnvelope) error {
+	if f == nil {
+		return nil
+	}
+	if envelope.OriginServer == "" {
+		envelope.OriginServer = f.serverID
+		envelope.Path = nil
+	}
+	envelope.Path = append(append([]string(nil), envelope.Path...), f.serverID)
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	_, err = exec.ExecContext(ctx, `
+INSERT INTO federation_outbox(peer_id, payload)
+SELECT p.id, $2::jsonb || jsonb_build_object(
+	'roomId', l.remote_room_id,
+	'senderUsername', COALESCE(NULLIF($3, ''), (SELECT username FROM users WHERE id = $4))
+)
+FROM federation_room_links l
+JOIN federation_peers p ON p.id = l.peer_id
+WHERE l.room_id = $1 AND p.disabled_at IS NULL AND NOT (p.server_id = ANY($5::TEXT[]))
+`, roomID, body, envelope.SenderUsername, senderID, envelope.Path)
+	return err
+}
+
+func (f *federationRelay) Start() {
+	go f.run()
+}
+
+func (f *federationRelay) Notify() {
+	if f == nil {
+		return
+	}
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *federationRelay) Stop() {
+	if f == nil {
+		return
+	}
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+	<-f.done
+}
+
+func (f *federationRelay) run() {
+	defer close(f.done)
+	ticker := time.NewTicker(federationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			processed, err := f.processBatch()
+			if err != nil {
+				logger.Warn("federation_relay_failed", "error", err)
+				break
+			}
+			if processed < federationDeliveryBatchSize {
+				break
+			}
+		}
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		case <-f.wake:
+		}
+	}
+}
+
+// processBatch claims due envelopes under a lease the way the webhook
+// dispatcher does, then delivers each with no transaction or row lock held
+// and records its outcome on its own.
+func (f *federationRelay) processBatch() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), federationClaimLease)
+	defer cancel()
+
+	rows, err := f.db.QueryContext(ctx, `
+UPDATE federation_outbox
+SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+WHERE id IN (
+    SELECT o.id
+    FROM federation_outbox o
+    JOIN federation_peers p ON p.id = o.peer_id
+    WHERE o.status = 'pending' AND o.next_attempt_at <= NOW() AND p.disabled_at IS NULL
+    ORDER BY o.next_attempt_at ASC, o.id ASC
+    LIMIT $1
+    FOR UPDATE OF o SKIP LOCKED
+)
+RETURNING id, payload, attempts,
+          (SELECT endpoint_url FROM federation_peers WHERE federation_peers.id = federation_outbox.peer_id),
+          (SELECT shared_secret FROM federation_peers WHERE federation_peers.id = federation_outbox.peer_id)
+`, federationDeliveryBatchSize, federationClaimLease.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	items := make([]federationOutboxItem, 0, federationDeliveryBatchSize)
+	for rows.Next() {
+		var item federationOutboxItem
+		if err := rows.Scan(&item.id, &item.payload, &item.attempts, &item.url, &item.secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	for _, item := range items {
+		statusCode, deliverErr := f.deliver(ctx, item)
+		if err := f.recordOutcome(ctx, item, statusCode, deliverErr); err != nil {
+			return 0, err
+		}
+	}
+	return len(items), nil
+}
+
+// recordOutcome only updates the row if no other relay reclaimed it after
+// the lease ran out.
+func (f *federationRelay) recordOutcome(ctx context.Context, item federationOutboxItem, statusCode int, deliverErr error) error {
+	attempts := item.attempts + 1
+	if deliverErr == nil {
+		_, err := f.db.ExecContext(ctx, `
+UPDATE federation_outbox
+SET status = 'delivered', attempts = $2, last_status_code = $3, last_error = NULL, delivered_at = NOW()
+WHERE id = $1 AND attempts = $4
+`, item.id, attempts, statusCode, item.attempts)
+		return err
+	}
+
+	status := "pending"
+	if attempts >= federationMaxAttempts {
+		status = "failed"
+	}
+	var statusRef sql.NullInt64
+	if statusCode > 0 {
+		statusRef = sql.NullInt64{Int64: int64(statusCode), Valid: true}
+	}
+	message := deliverErr.Error()
+	if len(message) > webhookMaxErrorLength {
+		message = message[:webhookMaxErrorLength]
+	}
+	_, err := f.db.ExecContext(ctx, `
+UPDATE federation_outbox
+SET status = $2, attempts = $3, last_status_code = $4, last_error = $5, next_attempt_at = $6
+WHERE id = $1 AND attempts = $7
+`, item.id, status, attempts, statusRef, message, f.now().Add(webhookBackoff(attempts)), item.attempts)
+	return err
+}
+
+func (f *federationRelay) deliver(ctx context.Context, item federationOutboxItem) (int, error) {
+	timestamp := f.now().Unix()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, item.url, bytes.NewReader(item.payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "e2ee-chat-federation/1")
+	request.Header.Set("X-Federation-Server", f.serverID)
+	request.Header.Set("X-Federation-Timestamp", strconv.FormatInt(timestamp, 10))
+	request.Header.Set("X-Federation-Signature", signWebhookPayload(item.secret, timestamp, item.payload))
+
+	response, err := f.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// verifyFederationSignature checks a peer request's MAC and rejects
+// timestamps outside the allowed clock skew.
+func verifyFederationSignature(secret, timestampRaw, signature string, body []byte, now time.Time) error {
+	timestamp, err := strconv.ParseInt(timestampRaw, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	skew := now.Sub(time.Unix(timestamp, 0))
+	if skew > federationMaxClockSkew || skew < -federationMaxClockSkew {
+		return errors.New("timestamp outside allowed window")
+	}
+	expected := signWebhookPayload(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+type federationPeer struct {
+	id          int64
+	serverID    string
+	secret      string
+	relayUserID int64
+}
+
+// storeRelayedMessage writes an inbound envelope as the peer's relay user and
+// queues it for any further peers. It reports duplicate=true without writing
+// when the origin message was already received.
+func (a *App) storeRelayedMessage(ctx context.Context, peer federationPeer, envelope federationEnvelope) (messageStamp, bool, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return messageStamp{}, false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+INSERT INTO federation_inbound(origin_server, origin_message_id, peer_id)
+VALUES ($1, $2, $3)
+ON CONFLICT DO NOTHING
+`, envelope.OriginServer, envelope.OriginMessageID, peer.id)
+	if err != nil {
+		return messageStamp{}, false, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return messageStamp{}, false, err
+	} else if inserted == 0 {
+		return messageStamp{}, true, nil
+	}
+
+	stamp, err := insertMessageTx(ctx, tx, envelope.RoomID, peer.relayUserID, envelope.Payload, nil)
+	if err != nil {
+		return messageStamp{}, false, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE federation_inbound SET message_id = $3
+WHERE origin_server = $1 AND origin_message_id = $2
+`, envelope.OriginServer, envelope.OriginMessageID, stamp.ID); err != nil {
+		return messageStamp{}, false, err
+	}
+	if err := a.federation.enqueueMessage(ctx, tx, envelope.RoomID, peer.relayUserID, envelope); err != nil {
+		return messageStamp{}, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return messageStamp{}, false, err
+	}
+	a.webhooks.Notify()
+	a.federation.Notify()
+	return stamp, false, nil
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidateFederationServerID(t *testing.T) {
+	t.Parallel()
+
+	for _, valid := range []string{"chat.example.com", "node-1", "abc"} {
+		if err := validateFederationServerID(valid); err != nil {
+			t.Fatalf("expected %q to be valid: %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"", "ab", "-leading", "trailing.", "Upper.example", "has space", strings.Repeat("a", 65)} {
+		if err := validateFederationServerID(invalid); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestCheckFederationPath(t *testing.T) {
+	t.Parallel()
+
+	envelope := federationEnvelope{OriginServer: "a.example", Path: []string{"a.example", "b.example"}}
+	if err := checkFederationPath(envelope, "c.example", "b.example"); err != nil {
+		t.Fatalf("expected valid path, got %v", err)
+	}
+	if err := checkFederationPath(envelope, "a.example", "b.example"); !errors.Is(err, errFederationLoop) {
+		t.Fatalf("expected loop detection, got %v", err)
+	}
+	if err := checkFederationPath(envelope, "c.example", "d.example"); !errors.Is(err, errFederationBadPath) {
+		t.Fatalf("expected sender mismatch to be rejected, got %v", err)
+	}
+	if err := checkFederationPath(federationEnvelope{OriginServer: "a.example"}, "c.example", "a.example"); !errors.Is(err, errFederationBadPath) {
+		t.Fatalf("expected empty path to be rejected, got %v", err)
+	}
+
+	long := federationEnvelope{OriginServer: "s0"}
+	for i := 0; i <= federationMaxHops; i++ {
+		long.Path = append(long.Path, "s"+strconv.Itoa(i))
+	}
+	last := long.Path[len(long.Path)-1]
+	if err := checkFederationPath(long, "local", last); !errors.Is(err, errFederationTooManyHops) {
+		t.Fatalf("expected hop limit, got %v", err)
+	}
+}
+
+func TestVerifyFederationSignature(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"roomId":1}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := signWebhookPayload("shared-secret", now.Unix(), body)
+
+	if err := verifyFederationSignature("shared-secret", timestamp, signature, body, now); err != nil {
+		t.Fatalf("expected valid signature: %v", err)
+	}
+	if err := verifyFederationSignature("other-secret", timestamp, signature, body, now); err == nil {
+		t.Fatalf("expected wrong secret to fail")
+	}
+	if err := verifyFederationSignature("shared-secret", timestamp, signature, []byte(`{"roomId":2}`), now); err == nil {
+		t.Fatalf("expected tampered body to fail")
+	}
+	if err := verifyFederationSignature("shared-secret", timestamp, signature, body, now.Add(federationMaxClockSkew+time.Second)); err == nil {
+		t.Fatalf("expected stale timestamp to fail")
+	}
+	if err := verifyFederationSignature("shared-secret", "not-a-number", signature, body, now); err == nil {
+		t.Fatalf("expected invalid timestamp to fail")
+	}
+}
+
+func TestFederationHandlersWithoutDB(t *testing.T) {
+	t.Parallel()
+
+	admin := AuthContext{UserID: 1, Username: "admin", Role: "admin"}
+
+	t.Run("relay disabled", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/federation/relay", strings.NewReader(`{}`))
+		response := httptest.NewRecorder()
+
+		(&App{}).handleFederationRelay(response, request)
+
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("expected %d, got %d", http.StatusNotFound, response.Code)
+		}
+	})
+
+	t.Run("admin peers disabled", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/admin/federation/peers", nil)
+		response := httptest.NewRecorder()
+
+		(&App{}).handleAdminFederationPeers(response, request, admin)
+
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("expected %d, got %d", http.StatusNotFound, response.Code)
+		}
+	})
+
+	app := &App{federation: newFederationRelay(nil, "local.example")}
+
+	t.Run("relay wrong method", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/federation/relay", nil)
+		response := httptest.NewRecorder()
+
+		app.handleFederationRelay(response, request)
+
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, response.Code)
+		}
+	})
+
+	t.Run("relay missing signature", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/federation/relay", strings.NewReader(`{}`))
+		request.Header.Set("X-Federation-Server", "peer.example")
+		response := httptest.NewRecorder()
+
+		app.handleFederationRelay(response, request)
+
+		if response.Code != http.StatusUnauthorized {
+			t.Fatalf("expected %d, got %d", http.StatusUnauthorized, response.Code)
+		}
+	})
+
+	t.Run("create peer with own server id", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/admin/federation/peers", strings.NewReader(`{"serverId":"local.example","endpointUrl":"https://peer.example/api/federation/relay"}`))
+		response := httptest.NewRecorder()
+
+		app.handleAdminFederationPeers(response, request, admin)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("create peer with short secret", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/admin/federation/peers", strings.NewReader(`{"serverId":"peer.example","endpointUrl":"https://peer.example/api/federation/relay","sharedSecret":"short"}`))
+		response := httptest.NewRecorder()
+
+		app.handleAdminFederationPeers(response, request, admin)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("link invalid ids", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/admin/federation/peers/1/links", strings.NewReader(`{"roomId":0,"remoteRoomId":3}`))
+		response := httptest.NewRecorder()
+
+		app.handleAdminFederationPeerSubroutes(response, request, admin)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("unknown peer subroute", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/admin/federation/peers/1/unknown", nil)
+		response := httptest.NewRecorder()
+
+		app.handleAdminFederationPeerSubroutes(response, request, admin)
+
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("expected %d, got %d", http.StatusNotFound, response.Code)
+		}
+	})
+}
+
+// insertSQLiteTestPeer registers peer.example with secret "shared-secret"
+// and links it to roomID.
+func insertSQLiteTestPeer(t *testing.T, db *sql.DB, adminID, roomID int64, endpointURL string) int64 {
+	t.Helper()
+	ctx := context.Background()
+	relayUserID := insertSQLiteTestMember(t, db, roomID, federationRelayUserPrefix+"peer.example", roleBot)
+	var peerID int64
+	if err := db.QueryRowContext(ctx, `
+INSERT INTO federation_peers(server_id, endpoint_url, shared_secret, relay_user_id, created_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id
+`, "peer.example", endpointURL, "shared-secret", relayUserID, adminID).Scan(&peerID); err != nil {
+		t.Fatalf("insert peer: %v", err)
+	}
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO federation_room_links(peer_id, room_id, remote_room_id) VALUES ($1, $2, $3)`, peerID, roomID, 9,
+	); err != nil {
+		t.Fatalf("link room: %v", err)
+	}
+	return peerID
+}
+
+func TestFederationRelayDeliversOutsideTransaction(t *testing.T) {
+	t.Parallel()
+
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	db := openSQLiteTestDB(t)
+	adminID, roomID := seedSQLiteTestRoom(t, db)
+	peerID := insertSQLiteTestPeer(t, db, adminID, roomID, server.URL)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `INSERT INTO federation_outbox(peer_id, payload) VALUES ($1, $2)`, peerID, `{"roomId":9}`); err != nil {
+		t.Fatalf("enqueue envelope: %v", err)
+	}
+	load := func() (status string, attempts int, lastError sql.NullString) {
+		t.Helper()
+		if err := db.QueryRowContext(ctx,
+			`SELECT status, attempts, last_error FROM federation_outbox WHERE peer_id = $1`, peerID,
+		).Scan(&status, &attempts, &lastError); err != nil {
+			t.Fatalf("load outbox: %v", err)
+		}
+		return status, attempts, lastError
+	}
+
+	relay := newFederationRelay(db, "local.example")
+	if processed, err := relay.processBatch(); err != nil || processed != 1 {
+		t.Fatalf("process batch: %d %v", processed, err)
+	}
+	status, attempts, lastError := load()
+	if status != "pending" || attempts != 1 || !strings.Contains(lastError.String, "public address") || received.Load() != 0 {
+		t.Fatalf("expected the loopback peer to be refused at dial time, got %s %d %q", status, attempts, lastError.String)
+	}
+	if processed, err := relay.processBatch(); err != nil || processed != 0 {
+		t.Fatalf("expected the retry to wait for its backoff, got %d %v", processed, err)
+	}
+
+	if _, err := db.ExecContext(ctx, `UPDATE federation_outbox SET next_attempt_at = $2 WHERE peer_id = $1`, peerID, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("reset backoff: %v", err)
+	}
+	relay.client = newWebhookHTTPClient(nil)
+	if processed, err := relay.processBatch(); err != nil || processed != 1 {
+		t.Fatalf("process batch: %d %v", processed, err)
+	}
+	if status, attempts, _ := load(); status != "delivered" || attempts != 2 || received.Load() != 1 {
+		t.Fatalf("expected delivery on the second attempt, got %s %d", status, attempts)
+	}
+}
+
+func TestFederationRelayRespectsAnnouncementRooms(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	adminID, roomID := seedSQLiteTestRoom(t, db)
+	insertSQLiteTestPeer(t, db, adminID, roomID, "https://peer.example/api/federation/relay")
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `UPDATE rooms SET announcement_only = TRUE WHERE id = $1`, roomID); err != nil {
+		t.Fatalf("mark announcement room: %v", err)
+	}
+
+	body, err := json.Marshal(federationEnvelope{
+		OriginServer:    "peer.example",
+		OriginMessageID: 1,
+		Path:            []string{"peer.example"},
+		RoomID:          roomID,
+		SenderUsername:  "carol",
+		Payload:         mustJSONRaw(t, signedTestCipherPayload(t)),
+	})
+	if err != nil {
+		t.Fatalf("encode envelope: %v", err)
+	}
+	now := time.Now()
+	request := httptest.NewRequest(http.MethodPost, "/api/federation/relay", bytes.NewReader(body))
+	request.Header.Set("X-Federation-Server", "peer.example")
+	request.Header.Set("X-Federation-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	request.Header.Set("X-Federation-Signature", signWebhookPayload("shared-secret", now.Unix(), body))
+	rec := httptest.NewRecorder()
+	app := &App{db: db, federation: newFederationRelay(db, "local.example"), signatureReplay: newSignatureReplayGuard(time.Minute)}
+	app.handleFederationRelay(rec, request)
+
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "announcement_only") {
+		t.Fatalf("expected announcement_only, got %d %s", rec.Code, rec.Body.String())
+	}
+}
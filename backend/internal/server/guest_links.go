@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultGuestLinkTTL = 24 * time.Hour
+	maxGuestLinkTTL     = 30 * 24 * time.Hour
+	guestHistoryLimit   = 50
+)
+
+var errGuestLinkInvalid = errors.New("guest link is invalid, expired or revoked")
+
+// guestFrameTypes are the live frames a guest connection receives. Anything
+// else sent to the room, such as key announcements, peer lists, typing and
+// presence, stays with members.
+var guestFrameTypes = map[string]bool{
+	"guest_session":         true,
+	"ciphertext":            true,
+	"message_update":        true,
+	"room_renamed":          true,
+	"room_settings_updated": true,
+	"maintenance":           true,
+	"server_restarting":     true,
+}
+
+// guestFrame reduces a room frame to what a guest may see, mirroring
+// guestMessage: message metadata without ciphertext or wrapped keys. It
+// reports false for frame types guests do not receive at all.
+func guestFrame(payload []byte) ([]byte, bool) {
+	var frame map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &frame); err != nil {
+		return nil, false
+	}
+	var frameType string
+	if err := json.Unmarshal(frame["type"], &frameType); err != nil || !guestFrameTypes[frameType] {
+		return nil, false
+	}
+	_, hasPayload := frame["payload"]
+	_, hasWrappedKeys := frame["wrappedKeys"]
+	if !hasPayload && !hasWrappedKeys {
+		return payload, true
+	}
+	delete(frame, "payload")
+	delete(frame, "wrappedKeys")
+	filtered, err := json.Marshal(frame)
+	if err != nil {
+		return nil, false
+	}
+	return filtered, true
+}
+
+// guestMessage is the history a guest may see: who posted when, never the
+// ciphertext, since guests hold no key to unwrap it.
+type guestMessage struct {
+	ID                int64   `json:"id"`
+	SenderID          int64   `json:"senderId"`
+	SenderUsername    string  `json:"senderUsername"`
+	SenderDisplayName string  `json:"senderDisplayName,omitempty"`
+	CreatedAt         string  `json:"createdAt"`
+	EditedAt          *string `json:"editedAt,omitempty"`
+	RevokedAt         *string `json:"revokedAt,omitempty"`
+}
+
+type guestRoom struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// handleRoomGuestLinks issues read-only guest links for an announcement-only
+// room (POST) or revokes every link issued so far (DELETE). Only the room
+// creator or an admin may do either.
+func (a *App) handleRoomGuestLinks(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ttl := defaultGuestLinkTTL
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		var req struct {
+			TTLMinutes int `json:"ttlMinutes"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		if req.TTLMinutes < 0 || time.Duration(req.TTLMinutes)*time.Minute > maxGuestLinkTTL {
+			respondError(w, http.StatusBadRequest, "ttlMinutes must be between 1 and 43200")
+			return
+		}
+		if req.TTLMinutes > 0 {
+			ttl = time.Duration(req.TTLMinutes) * time.Minute
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	decision, err := a.loadMessageModerationDecision(ctx, auth.UserID, auth.Role, roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load room")
+		return
+	}
+	if !decision.Allowed {
+		respondErrorCode(w, http.StatusForbidden, decision.Code, "only room creator or admin can manage guest links")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if _, err := a.db.ExecContext(ctx, `UPDATE rooms SET guest_links_revoked_at = NOW() WHERE id = $1`, roomID); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to revoke guest links")
+			return
+		}
+		a.hub.KickRoomGuests(roomID, websocket.ClosePolicyViolation, "guest link revoked")
+		requestLogger(r.Context()).Info("guest_links_revoked", "room_id", roomID, "actor_id", auth.UserID)
+		respondJSON(w, http.StatusOK, map[string]any{"roomId": roomID, "revoked": true})
+		return
+	}
+
+	var announcementOnly bool
+	if err := a.db.QueryRowContext(ctx, `SELECT announcement_only FROM rooms WHERE id = $1`, roomID).Scan(&announcementOnly); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load room")
+		return
+	}
+	if !announcementOnly {
+		respondErrorCode(w, http.StatusConflict, "announcement_only_required", "guest links require an announcement-only room")
+		return
+	}
+	guestToken, expiresAt, err := a.issueRoomToken(roomID, auth.UserID, inviteTypeRoomGuest, ttl)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to issue guest link")
+		return
+	}
+	requestLogger(r.Context()).Info("guest_link_issued",
+		"room_id", roomID,
+		"actor_id", auth.UserID,
+		"expires_at", expiresAt,
+	)
+	respondJSON(w, http.StatusOK, map[string]any{
+		"roomId":     roomID,
+		"guestToken": guestToken,
+		"expiresAt":  expiresAt.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// guestTokenFromRequest reads the guest link from X-Guest-Token or, for
+// browser WebSockets that cannot set headers, the token query parameter.
+func guestTokenFromRequest(r *http.Request) string {
+	if token := strings.TrimSpace(r.Header.Get("X-Guest-Token")); token != "" {
+		return token
+	}
+	return strings.TrimSpace(r.URL.Query().Get("token"))
+}
+
+// resolveGuestLink validates a guest token against the room's current state:
+// the room must still be announcement-only and the link must postdate the
+// last revocation.
+func (a *App) resolveGuestLink(ctx context.Context, tokenString string) (*InviteClaims, guestRoom, error) {
+	if tokenString == "" {
+		return nil, guestRoom{}, errGuestLinkInvalid
+	}
+	claims, err := a.parseRoomToken(tokenString, inviteTypeRoomGuest)
+	if err != nil || claims.IssuedAt == nil {
+		return nil, guestRoom{}, errGuestLinkInvalid
+	}
+	room := guestRoom{ID: claims.RoomID, ExpiresAt: claims.ExpiresAt.Time.UTC().Format(time.RFC3339Nano)}
+	var announcementOnly bool
+	var revokedAt sql.NullTime
+	err = a.db.QueryRowContext(ctx,
+		`SELECT name, announcement_only, guest_links_revoked_at FROM rooms WHERE id = $1`,
+		claims.RoomID,
+	).Scan(&room.Name, &announcementOnly, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, guestRoom{}, errGuestLinkInvalid
+	}
+	if err != nil {
+		return nil, guestRoom{}, err
+	}
+	// IssuedAt has second precision, so a link issued in the same second as
+	// a revocation is treated as revoked.
+	if !announcementOnly || (revokedAt.Valid && !claims.IssuedAt.Time.After(revokedAt.Time)) {
+		return nil, guestRoom{}, errGuestLinkInvalid
+	}
+	return claims, room, nil
+}
+
+func (a *App) respondGuestLinkError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errGuestLinkInvalid) {
+		respondErrorCode(w, http.StatusUnauthorized, "guest_link_invalid", err.Error())
+		return
+	}
+	respondError(w, http.StatusInternalServerError, "failed to validate guest link")
+}
+
+// handleGuestRoom returns the room and the metadata of its latest messages,
+// newest last. beforeId pages further back.
+func (a *App) handleGuestRoom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if allowed, retryAfter := a.wsConnectLimiter.Check(clientKeyFromRequest(r, a.trustProxyHeaders)); !allowed {
+		respondRateLimitedAfter(w, "too many guest requests", retryAfter)
+		return
+	}
+	beforeID := int64(0)
+	if value := strings.TrimSpace(r.URL.Query().Get("beforeId")); value != "" {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed > 0 {
+			beforeID = parsed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	claims, room, err := a.resolveGuestLink(ctx, guestTokenFromRequest(r))
+	if err != nil {
+		a.respondGuestLinkError(w, err)
+		return
+	}
+	stored, err := a.listRoomMessages(ctx,
+		`AND ($2::BIGINT <= 0 OR m.id < $2) ORDER BY m.id DESC LIMIT $3`,
+		claims.RoomID, beforeID, guestHistoryLimit+1)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to fetch messages")
+		return
+	}
+	hasMore := len(stored) > guestHistoryLimit
+	if hasMore {
+		stored = stored[:guestHistoryLimit]
+	}
+	reverseStoredMessages(stored)
+	messages := make([]guestMessage, 0, len(stored))
+	for _, message := range stored {
+		messages = append(messages, guestMessage{
+			ID:                message.ID,
+			SenderID:          message.SenderID,
+			SenderUsername:    message.SenderUsername,
+			SenderDisplayName: message.SenderDisplayName,
+			CreatedAt:         message.CreatedAt,
+			EditedAt:          message.EditedAt,
+			RevokedAt:         message.RevokedAt,
+		})
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"room":     room,
+		"messages": messages,
+		"hasMore":  hasMore,
+	})
+}
+
+// handleGuestWS attaches a read-only guest connection to the room. Guests
+// receive the room broadcasts guestFrame lets through but never appear in
+// room_peers, so members never wrap keys for them, and every frame they send
+// is ignored.
+func (a *App) handleGuestWS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if a.draining.Load() {
+		respondDraining(w, a.effectiveWSDrainWindow())
+		return
+	}
+	if a.rejectMaintenanceUpgrade(w, r) {
+		return
+	}
+	if allowed, retryAfter := a.wsConnectLimiter.Check(clientKeyFromRequest(r, a.trustProxyHeaders)); !allowed {
+		respondRateLimitedAfter(w, "too many websocket connection attempts", retryAfter)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	claims, room, err := a.resolveGuestLink(ctx, guestTokenFromRequest(r))
+	if err != nil {
+		a.respondGuestLinkError(w, err)
+		return
+	}
+
+	conn, err := a.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		requestLogger(r.Context()).Error("websocket_upgrade_failed", "error", err)
+		return
+	}
+	a.wsCompression.configureConn(conn)
+
+	client := &Client{
+		app:         a,
+		conn:        conn,
+		send:        make(chan []byte, a.wsLimits.withDefaults().SendBufferSize),
+		username:    "guest",
+		roomID:      claims.RoomID,
+		guest:       true,
+		codec:       wsCodecForSubprotocol(conn.Subprotocol()),
+		requestID:   requestIDFromContext(r.Context()),
+		remoteIP:    clientKeyFromRequest(r, a.trustProxyHeaders),
+		connectedAt: time.Now(),
+	}
+	a.hub.AddClient(client)
+	if payload, err := json.Marshal(map[string]any{
+		"type":      "guest_session",
+		"roomId":    room.ID,
+		"roomName":  room.Name,
+		"expiresAt": room.ExpiresAt,
+	}); err == nil {
+		client.send <- payload
+	}
+
+	go client.writePump()
+	client.guestReadPump(claims.ExpiresAt.Time)
+}
+
+// guestReadPump keeps the connection's deadlines and discards what the guest
+// sends. The connection is closed when the guest link expires.
+func (c *Client) guestReadPump(expiresAt time.Time) {
+	expiry := time.AfterFunc(time.Until(expiresAt), func() {
+		_ = c.conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "guest link expired"),
+			time.Now().Add(time.Second),
+		)
+		_ = c.conn.Close()
+	})
+	defer func() {
+		expiry.Stop()
+		c.app.hub.RemoveClient(c)
+		_ = c.conn.Close()
+	}()
+
+	limits := c.app.wsLimits.withDefaults()
+	c.conn.SetReadLimit(limits.ReadLimitBytes)
+	_ = c.conn.SetReadDeadline(time.Now().Add(limits.PongTimeout))
+	c.conn.SetPongHandler(func(appData string) error {
+		return c.handlePong(appData, limits.PongTimeout)
+	})
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			c.log().Info("guest_websocket_closed", "room_id", c.roomID, "error", err)
+			return
+		}
+	}
+}
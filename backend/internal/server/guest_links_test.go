@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGuestAndInviteTokensAreNotInterchangeable(t *testing.T) {
+	t.Parallel()
+
+	app := &App{jwtSecret: []byte("0123456789abcdef0123456789abcdef")}
+	guestToken, _, err := app.issueRoomToken(7, 1, inviteTypeRoomGuest, time.Hour)
+	if err != nil {
+		t.Fatalf("issue guest token: %v", err)
+	}
+	if _, err := app.parseInviteToken(guestToken); err == nil {
+		t.Fatal("expected guest token to be rejected as a join invite")
+	}
+	claims, err := app.parseRoomToken(guestToken, inviteTypeRoomGuest)
+	if err != nil || claims.RoomID != 7 {
+		t.Fatalf("expected guest token to parse, got %+v %v", claims, err)
+	}
+
+	inviteToken, _, err := app.issueInviteToken(7, 1)
+	if err != nil {
+		t.Fatalf("issue invite token: %v", err)
+	}
+	if _, _, err := app.resolveGuestLink(context.Background(), inviteToken); !errors.Is(err, errGuestLinkInvalid) {
+		t.Fatalf("expected join invite to be rejected as a guest link, got %v", err)
+	}
+	if _, _, err := app.resolveGuestLink(context.Background(), ""); !errors.Is(err, errGuestLinkInvalid) {
+		t.Fatalf("expected missing guest token to be rejected, got %v", err)
+	}
+}
+
+func TestGuestTokenFromRequest(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/guest?token=from-query", nil)
+	if got := guestTokenFromRequest(req); got != "from-query" {
+		t.Fatalf("expected query token, got %q", got)
+	}
+	req.Header.Set("X-Guest-Token", "from-header")
+	if got := guestTokenFromRequest(req); got != "from-header" {
+		t.Fatalf("expected header token to win, got %q", got)
+	}
+}
+
+func TestGuestsStayOutOfPeersAndUserCounts(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	guest := &Client{roomID: 7, guest: true, send: make(chan []byte, 1)}
+	hub.AddClient(guest)
+	member := &Client{userID: 2, deviceID: "b", roomID: 7, send: make(chan []byte, 1)}
+	if peers := hub.AddClient(member); len(peers) != 0 {
+		t.Fatalf("expected guest to be left out of room_peers, got %+v", peers)
+	}
+	stats := hub.ConnectionStats()
+	if stats.Connections != 2 || stats.Users != 1 || stats.Guests != 1 {
+		t.Fatalf("unexpected connection stats: %+v", stats)
+	}
+
+	hub.Broadcast(7, []byte("announcement"))
+	if got := <-guest.send; string(got) != "announcement" {
+		t.Fatalf("expected guest to receive room broadcasts, got %q", got)
+	}
+}
+
+func TestGuestFrameFiltersAndStripsKeys(t *testing.T) {
+	t.Parallel()
+
+	filtered, ok := guestFrame([]byte(`{"type":"ciphertext","id":5,"roomId":1,"payload":{"ciphertext":"YQ==","wrappedKeys":{"u1":{}}},"wrappedKeys":{"u1":{}}}`))
+	if !ok {
+		t.Fatal("expected ciphertext metadata to reach guests")
+	}
+	if strings.Contains(string(filtered), "payload") || strings.Contains(string(filtered), "wrappedKeys") || !strings.Contains(string(filtered), `"id":5`) {
+		t.Fatalf("unexpected guest frame: %s", filtered)
+	}
+	for _, frame := range []string{
+		`{"type":"key_announce","userId":2}`,
+		`{"type":"room_peers","peers":[]}`,
+		`{"type":"typing_status","userId":2}`,
+		`not json`,
+	} {
+		if _, ok := guestFrame([]byte(frame)); ok {
+			t.Fatalf("expected %s to be withheld from guests", frame)
+		}
+	}
+}
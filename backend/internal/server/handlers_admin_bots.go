@@ -0,0 +1,324 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type botTokenResp struct {
+	ID         int64   `json:"id"`
+	Name       string  `json:"name"`
+	RoomIDs    []int64 `json:"roomIds"`
+	CreatedAt  string  `json:"createdAt"`
+	LastUsedAt string  `json:"lastUsedAt,omitempty"`
+	RevokedAt  string  `json:"revokedAt,omitempty"`
+}
+
+type botTokenRequest struct {
+	Name    string  `json:"name"`
+	RoomIDs []int64 `json:"roomIds"`
+}
+
+func (req *botTokenRequest) normalize() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		req.Name = "default"
+	}
+	if len(req.Name) > 64 {
+		return errors.New("token name must be at most 64 characters")
+	}
+	roomIDs, ok := normalizeBotRoomIDs(req.RoomIDs)
+	if !ok {
+		return errors.New("roomIds must list between 1 and 50 valid room ids")
+	}
+	req.RoomIDs = roomIDs
+	return nil
+}
+
+func (a *App) handleAdminBots(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	switch r.Method {
+	case http.MethodGet:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		rows, err := a.db.QueryContext(ctx, `
+SELECT u.id, u.username, u.created_at,
+	(SELECT COUNT(*) FROM bot_tokens t WHERE t.bot_user_id = u.id AND t.revoked_at IS NULL)
+FROM users u
+WHERE u.role = 'bot'
+ORDER BY u.id ASC
+`)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to list bots")
+			return
+		}
+		defer rows.Close()
+
+		type botResp struct {
+			ID           int64  `json:"id"`
+			Username     string `json:"username"`
+			CreatedAt    string `json:"createdAt"`
+			ActiveTokens int    `json:"activeTokens"`
+		}
+		bots := make([]botResp, 0, 8)
+		for rows.Next() {
+			var bot botResp
+			var createdAt time.Time
+			if err := rows.Scan(&bot.ID, &bot.Username, &createdAt, &bot.ActiveTokens); err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to decode bot list")
+				return
+			}
+			bot.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+			bots = append(bots, bot)
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"bots": bots})
+
+	case http.MethodPost:
+		var req struct {
+			Username string `json:"username"`
+			botTokenRequest
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		req.Username = strings.TrimSpace(req.Username)
+		if len(req.Username) < 3 || len(req.Username) > 32 {
+			respondError(w, http.StatusBadRequest, "username length must be between 3 and 32")
+			return
+		}
+		if a.isConfiguredAdmin(req.Username) {
+			respondError(w, http.StatusBadRequest, "reserved username")
+			return
+		}
+		if err := req.botTokenRequest.normalize(); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		if _, err := releaseHeldUsernames(ctx, a.db, a.usernameHold()); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create bot")
+			return
+		}
+
+		// Bots never log in with a password; the placeholder hash cannot match
+		// any bcrypt comparison and login rejects the role regardless.
+		var botID int64
+		var createdAt time.Time
+		err := a.db.QueryRowContext(ctx, `
+INSERT INTO users(username, password_hash, role)
+VALUES ($1, $2, 'bot')
+RETURNING id, created_at
+`, req.Username, botDisabledPassword).Scan(&botID, &createdAt)
+		if err != nil {
+			if isUniqueViolation(err) {
+				respondError(w, http.StatusConflict, "username already exists")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to create bot")
+			return
+		}
+
+		token, issued, ok := a.issueBotToken(ctx, w, auth, botID, req.botTokenRequest)
+		if !ok {
+			return
+		}
+		respondJSON(w, http.StatusCreated, map[string]any{
+			"bot": map[string]any{
+				"id":        botID,
+				"username":  req.Username,
+				"createdAt": createdAt.UTC().Format(time.RFC3339Nano),
+			},
+			"token":     token,
+			"tokenInfo": issued,
+		})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) handleAdminBotSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 5 || len(parts) > 6 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "bots" || parts[4] != "tokens" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	botID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || botID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid bot id")
+		return
+	}
+	if len(parts) == 6 {
+		tokenID, err := strconv.ParseInt(parts[5], 10, 64)
+		if err != nil || tokenID <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid token id")
+			return
+		}
+		a.handleAdminRevokeBotToken(w, r, botID, tokenID)
+		return
+	}
+	a.handleAdminBotTokens(w, r, auth, botID)
+}
+
+func (a *App) handleAdminBotTokens(w http.ResponseWriter, r *http.Request, auth AuthContext, botID int64) {
+	switch r.Method {
+	case http.MethodGet:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if !a.requireBotAccount(ctx, w, botID) {
+			return
+		}
+		tokens, err := a.listBotTokens(ctx, botID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to list bot tokens")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"tokens": tokens})
+
+	case http.MethodPost:
+		var req botTokenRequest
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		if err := req.normalize(); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		if !a.requireBotAccount(ctx, w, botID) {
+			return
+		}
+		token, issued, ok := a.issueBotToken(ctx, w, auth, botID, req)
+		if !ok {
+			return
+		}
+		respondJSON(w, http.StatusCreated, map[string]any{"token": token, "tokenInfo": issued})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) handleAdminRevokeBotToken(w http.ResponseWriter, r *http.Request, botID, tokenID int64) {
+	if r.Method != http.MethodDelete {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var revokedID int64
+	err := a.db.QueryRowContext(ctx, `
+UPDATE bot_tokens
+SET revoked_at = COALESCE(revoked_at, NOW())
+WHERE id = $1 AND bot_user_id = $2
+RETURNING id
+`, tokenID, botID).Scan(&revokedID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "token not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to revoke token")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"revoked": true, "tokenId": revokedID})
+}
+
+func (a *App) requireBotAccount(ctx context.Context, w http.ResponseWriter, botID int64) bool {
+	isBot, err := a.isBotAccount(ctx, botID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load bot")
+		return false
+	}
+	if !isBot {
+		respondError(w, http.StatusNotFound, "bot not found")
+		return false
+	}
+	return true
+}
+
+// issueBotToken adds the bot to every scoped room before storing the token,
+// so a token never grants a room the bot cannot actually post to.
+func (a *App) issueBotToken(ctx context.Context, w http.ResponseWriter, auth AuthContext, botID int64, req botTokenRequest) (string, botTokenResp, bool) {
+	for _, roomID := range req.RoomIDs {
+		if err := a.addRoomMember(ctx, roomID, botID); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				respondErrorDetails(w, http.StatusNotFound, errCodeNotFound, "room not found", map[string]any{"roomId": roomID})
+			case errors.Is(err, errRoomFull):
+				respondErrorDetails(w, http.StatusConflict, "room_full", errRoomFull.Error(), map[string]any{"roomId": roomID})
+			default:
+				respondError(w, http.StatusInternalServerError, "failed to add bot to room")
+			}
+			return "", botTokenResp{}, false
+		}
+	}
+
+	token, err := generateBotToken()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to generate token")
+		return "", botTokenResp{}, false
+	}
+	issued := botTokenResp{Name: req.Name, RoomIDs: req.RoomIDs}
+	var createdAt time.Time
+	err = a.db.QueryRowContext(ctx, `
+INSERT INTO bot_tokens(bot_user_id, name, token_hash, room_ids, created_by)
+VALUES ($1, $2, $3, $4::BIGINT[], $5)
+RETURNING id, created_at
+`, botID, req.Name, hashBotToken(token), req.RoomIDs, auth.UserID).Scan(&issued.ID, &createdAt)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to store token")
+		return "", botTokenResp{}, false
+	}
+	issued.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+	return token, issued, true
+}
+
+func (a *App) listBotTokens(ctx context.Context, botID int64) ([]botTokenResp, error) {
+	rows, err := a.db.QueryContext(ctx, `
+SELECT id, name, array_to_json(room_ids)::TEXT, created_at, last_used_at, revoked_at
+FROM bot_tokens
+WHERE bot_user_id = $1
+ORDER BY id ASC
+`, botID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]botTokenResp, 0, 4)
+	for rows.Next() {
+		var token botTokenResp
+		var roomIDsRaw string
+		var createdAt time.Time
+		var lastUsedAt sql.NullTime
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&token.ID, &token.Name, &roomIDsRaw, &createdAt, &lastUsedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(roomIDsRaw), &token.RoomIDs); err != nil {
+			return nil, err
+		}
+		token.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		if lastUsedAt.Valid {
+			token.LastUsedAt = lastUsedAt.Time.UTC().Format(time.RFC3339Nano)
+		}
+		if revokedAt.Valid {
+			token.RevokedAt = revokedAt.Time.UTC().Format(time.RFC3339Nano)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ipDenylistEntryResp struct {
+	ID        int64  `json:"id"`
+	CIDR      string `json:"cidr"`
+	Reason    string `json:"reason"`
+	CreatedBy *int64 `json:"createdBy,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+const maxIPDenylistReasonLength = 256
+
+func (a *App) handleAdminIPDenylist(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	switch r.Method {
+	case http.MethodGet:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		rows, err := a.db.QueryContext(ctx, `
+SELECT id, cidr::text, reason, created_by, created_at, expires_at
+FROM ip_denylist
+ORDER BY id ASC
+`)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to list denylist")
+			return
+		}
+		defer rows.Close()
+
+		entries := make([]ipDenylistEntryResp, 0, 16)
+		for rows.Next() {
+			var entry ipDenylistEntryResp
+			var createdBy sql.NullInt64
+			var createdAt time.Time
+			var expiresAt sql.NullTime
+			if err := rows.Scan(&entry.ID, &entry.CIDR, &entry.Reason, &createdBy, &createdAt, &expiresAt); err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to decode denylist")
+				return
+			}
+			if createdBy.Valid {
+				value := createdBy.Int64
+				entry.CreatedBy = &value
+			}
+			entry.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+			if expiresAt.Valid {
+				entry.ExpiresAt = expiresAt.Time.UTC().Format(time.RFC3339Nano)
+			}
+			entries = append(entries, entry)
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"entries": entries})
+
+	case http.MethodPost:
+		var req struct {
+			CIDR             string `json:"cidr"`
+			Reason           string `json:"reason"`
+			ExpiresInMinutes int    `json:"expiresInMinutes"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		prefix, err := parseCIDR(strings.TrimSpace(req.CIDR))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if len(req.Reason) > maxIPDenylistReasonLength {
+			respondError(w, http.StatusBadRequest, "reason must be at most 256 characters")
+			return
+		}
+		if req.ExpiresInMinutes < 0 {
+			respondError(w, http.StatusBadRequest, "expiresInMinutes must not be negative")
+			return
+		}
+		if addr, ok := a.clientAddr(r); ok && prefix.Contains(addr) {
+			respondErrorCode(w, http.StatusConflict, "self_lockout", "entry would block the address making this request")
+			return
+		}
+		var expiresAt sql.NullTime
+		if req.ExpiresInMinutes > 0 {
+			expiresAt = sql.NullTime{Time: time.Now().Add(time.Duration(req.ExpiresInMinutes) * time.Minute), Valid: true}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		var entryID int64
+		err = a.db.QueryRowContext(ctx, `
+INSERT INTO ip_denylist(cidr, reason, created_by, expires_at)
+VALUES ($1::cidr, $2, $3, $4)
+RETURNING id
+`, prefix.String(), req.Reason, auth.UserID, expiresAt).Scan(&entryID)
+		if err != nil {
+			if isUniqueViolation(err) {
+				respondError(w, http.StatusConflict, "cidr is already denied")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to add denylist entry")
+			return
+		}
+		a.refreshIPDenylist(ctx, r)
+		requestLogger(r.Context()).Warn("ip_denylist_added", "cidr", prefix.String(), "admin_user_id", auth.UserID)
+		respondJSON(w, http.StatusCreated, map[string]any{"id": entryID, "cidr": prefix.String()})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) handleAdminIPDenylistSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "ip-denylist" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	entryID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || entryID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid entry id")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	var cidr string
+	err = a.db.QueryRowContext(ctx, `DELETE FROM ip_denylist WHERE id = $1 RETURNING cidr::text`, entryID).Scan(&cidr)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "denylist entry not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to remove denylist entry")
+		return
+	}
+	a.refreshIPDenylist(ctx, r)
+	requestLogger(r.Context()).Warn("ip_denylist_removed", "cidr", cidr, "admin_user_id", auth.UserID)
+	respondJSON(w, http.StatusOK, map[string]any{"removed": true, "id": entryID})
+}
+
+func (a *App) refreshIPDenylist(ctx context.Context, r *http.Request) {
+	if err := a.ipDenylist.Refresh(ctx); err != nil {
+		requestLogger(r.Context()).Warn("ip_denylist_refresh_failed", "error", err)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+func (a *App) handleAdminLogLevel(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, http.StatusOK, logLevels.State())
+
+	case http.MethodPut:
+		var req struct {
+			Level           string `json:"level"`
+			DurationMinutes int    `json:"durationMinutes"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		if req.Level == "" {
+			respondError(w, http.StatusBadRequest, "level is required")
+			return
+		}
+		level, err := parseLogLevel(req.Level)
+		if err != nil {
+			respondErrorCode(w, http.StatusBadRequest, "invalid_log_level", err.Error())
+			return
+		}
+		duration := time.Duration(req.DurationMinutes) * time.Minute
+		if duration < 0 || duration > maxLogLevelOverride {
+			respondError(w, http.StatusBadRequest, "durationMinutes must be between 0 and 1440")
+			return
+		}
+		state := logLevels.Override(level, duration)
+		requestLogger(r.Context()).Warn(
+			"log_level_changed",
+			"level", state.Level,
+			"duration_minutes", req.DurationMinutes,
+			"admin_user_id", auth.UserID,
+		)
+		respondJSON(w, http.StatusOK, state)
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type adminServerStats struct {
+	Users          int64 `json:"users"`
+	Rooms          int64 `json:"rooms"`
+	Messages       int64 `json:"messages"`
+	MessagesLast24 int64 `json:"messagesLast24h"`
+	PayloadBytes   int64 `json:"payloadBytes"`
+	Prekeys        struct {
+		ActiveDevices          int64   `json:"activeDevices"`
+		DevicesWithSignedKey   int64   `json:"devicesWithSignedPreKey"`
+		DevicesWithOneTimeKeys int64   `json:"devicesWithOneTimePreKeys"`
+		Coverage               float64 `json:"coverage"`
+	} `json:"prekeys"`
+	RefreshTokens struct {
+		Active  int64 `json:"active"`
+		Expired int64 `json:"expired"`
+		Revoked int64 `json:"revoked"`
+	} `json:"refreshTokens"`
+	WebSocket       hubConnectionStats   `json:"websocket"`
+	MembershipCache membershipCacheStats `json:"membershipCache"`
+	DatabasePool    dbPoolStats          `json:"databasePool"`
+	DatabaseReplica *replicaStats        `json:"databaseReplica,omitempty"`
+	GeneratedAt     string               `json:"generatedAt"`
+}
+
+// loadAdminServerStats aggregates in one round trip. Message totals come from
+// the trigger-maintained room_message_stats table instead of scanning messages.
+func (a *App) loadAdminServerStats(ctx context.Context) (adminServerStats, error) {
+	var stats adminServerStats
+	err := a.db.QueryRowContext(ctx, `
+SELECT
+	(SELECT COUNT(*) FROM users),
+	(SELECT COUNT(*) FROM rooms),
+	(SELECT COALESCE(SUM(message_count), 0) FROM room_message_stats),
+	(SELECT COALESCE(SUM(payload_bytes), 0) FROM room_message_stats),
+	(SELECT COUNT(*) FROM messages WHERE created_at > NOW() - INTERVAL '24 hours'),
+	(SELECT COUNT(*) FROM user_devices WHERE revoked_at IS NULL),
+	(SELECT COUNT(*)
+	   FROM user_devices d
+	   JOIN signal_device_signed_prekeys sp ON sp.user_id = d.user_id AND sp.device_id = d.device_id
+	  WHERE d.revoked_at IS NULL),
+	(SELECT COUNT(*)
+	   FROM user_devices d
+	  WHERE d.revoked_at IS NULL
+	    AND EXISTS (
+	        SELECT 1 FROM signal_device_one_time_prekeys otp
+	         WHERE otp.user_id = d.user_id AND otp.device_id = d.device_id AND otp.consumed_at IS NULL
+	    )),
+	(SELECT COUNT(*) FROM auth_refresh_tokens WHERE revoked_at IS NULL AND expires_at > NOW()),
+	(SELECT COUNT(*) FROM auth_refresh_tokens WHERE revoked_at IS NULL AND expires_at <= NOW()),
+	(SELECT COUNT(*) FROM auth_refresh_tokens WHERE revoked_at IS NOT NULL)
+`).Scan(
+		&stats.Users,
+		&stats.Rooms,
+		&stats.Messages,
+		&stats.PayloadBytes,
+		&stats.MessagesLast24,
+		&stats.Prekeys.ActiveDevices,
+		&stats.Prekeys.DevicesWithSignedKey,
+		&stats.Prekeys.DevicesWithOneTimeKeys,
+		&stats.RefreshTokens.Active,
+		&stats.RefreshTokens.Expired,
+		&stats.RefreshTokens.Revoked,
+	)
+	if err != nil {
+		return adminServerStats{}, err
+	}
+	if stats.Prekeys.ActiveDevices > 0 {
+		stats.Prekeys.Coverage = float64(stats.Prekeys.DevicesWithSignedKey) / float64(stats.Prekeys.ActiveDevices)
+	}
+	stats.WebSocket = a.hub.ConnectionStats()
+	stats.MembershipCache = a.membership.Stats()
+	stats.DatabasePool = newDBPoolStats(a.db.Stats())
+	stats.DatabaseReplica = a.replica.Stats()
+	stats.GeneratedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	return stats, nil
+}
+
+func (a *App) handleAdminStats(w http.ResponseWriter, r *http.Request, _ AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	stats, err := a.loadAdminServerStats(ctx)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load server stats")
+		return
+	}
+	respondJSON(w, http.StatusOK, stats)
+}
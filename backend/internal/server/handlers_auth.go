@@ -14,7 +14,7 @@ import (
 
 func (a *App) handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
@@ -27,62 +27,86 @@ func (a *App) handleHealth(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) handleRegister(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusForbidden, map[string]any{"error": "registration is disabled on this deployment"})
+	respondError(w, http.StatusForbidden, "registration is disabled on this deployment")
 }
 
 func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	if a.loginIPLimiter != nil && !a.loginIPLimiter.Allow(clientKeyFromRequest(r, a.trustProxyHeaders)) {
-		respondRateLimited(w, "too many login attempts")
+	clientKey := clientKeyFromRequest(r, a.trustProxyHeaders)
+	if allowed, retryAfter := a.loginIPLimiter.Check(clientKey); !allowed {
+		respondRateLimitedAfter(w, "too many login attempts", retryAfter)
 		return
 	}
 
 	var req struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		// ChallengeToken and ChallengeNonce answer a challenge_required
+		// response; see loginChallenge.
+		ChallengeToken string `json:"challengeToken"`
+		ChallengeNonce string `json:"challengeNonce"`
 	}
 	if err := decodeJSON(r, &req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid json body"})
+		respondError(w, http.StatusBadRequest, "invalid json body")
 		return
 	}
 
 	req.Username = strings.TrimSpace(req.Username)
-	if a.loginUserLimiter != nil && req.Username != "" && !a.loginUserLimiter.Allow(strings.ToLower(req.Username)) {
-		respondRateLimited(w, "too many login attempts for this account")
-		return
+	if req.Username != "" {
+		if allowed, retryAfter := a.loginUserLimiter.Check(strings.ToLower(req.Username)); !allowed {
+			respondRateLimitedAfter(w, "too many login attempts for this account", retryAfter)
+			return
+		}
 	}
 	if len(req.Username) < 3 || len(req.Username) > 32 {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "username length must be between 3 and 32"})
+		respondError(w, http.StatusBadRequest, "username length must be between 3 and 32")
 		return
 	}
 	if len(req.Password) < 8 || len(req.Password) > 128 {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "password length must be between 8 and 128"})
+		respondError(w, http.StatusBadRequest, "password length must be between 8 and 128")
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
+	if a.loginChallenge.Required(clientKey) && !a.loginChallenge.Verify(ctx, req.ChallengeToken, req.ChallengeNonce, clientKey) {
+		a.loginChallenge.respondChallengeRequired(w)
+		return
+	}
+
 	var userID int64
 	var hash string
 	var role string
-	err := a.db.QueryRowContext(ctx,
-		`SELECT id, password_hash, role FROM users WHERE username = $1`,
-		req.Username,
-	).Scan(&userID, &hash, &role)
+	var grant roleGrant
+	var suspended bool
+	err := a.db.QueryRowContext(ctx, `
+SELECT u.id, u.password_hash, u.role, COALESCE(u.custom_role, ''), COALESCE(r.version, 0), u.suspended_at IS NOT NULL
+FROM users u
+LEFT JOIN roles r ON r.name = u.custom_role
+WHERE u.username = $1 AND u.deleted_at IS NULL
+`, req.Username).Scan(&userID, &hash, &role, &grant.Name, &grant.Version, &suspended)
 	if err != nil {
-		respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid credentials"})
+		a.loginChallenge.RecordFailure(clientKey)
+		respondError(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
 	if role != "admin" && role != "user" {
-		respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid credentials"})
+		a.loginChallenge.RecordFailure(clientKey)
+		respondError(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)); err != nil {
-		respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid credentials"})
+		a.loginChallenge.RecordFailure(clientKey)
+		respondError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	a.loginChallenge.Reset(clientKey)
+	if suspended {
+		respondErrorCode(w, http.StatusForbidden, "account_suspended", "account is suspended")
 		return
 	}
 
@@ -93,23 +117,30 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 		normalizeDeviceName(r.Header.Get("X-Device-Name"), buildDefaultDeviceName(r)),
 	)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to initialize device session"})
+		respondError(w, http.StatusInternalServerError, "failed to initialize device session")
 		return
 	}
 
-	tokenString, err := a.issueToken(userID, req.Username, role, loginDevice.DeviceID, loginDevice.SessionVersion)
+	if loginDevice.isNew() {
+		a.notifyUserByEmail(ctx, userID, req.Username, emailTemplateNewDeviceLogin, map[string]any{
+			"DeviceName": loginDevice.DeviceName,
+			"RemoteIP":   clientKey,
+		})
+	}
+
+	tokenString, err := a.issueToken(userID, req.Username, role, grant, loginDevice.DeviceID, loginDevice.SessionVersion)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to issue token"})
+		respondError(w, http.StatusInternalServerError, "failed to issue token")
 		return
 	}
 	refreshToken, err := a.issueRefreshToken(ctx, userID, loginDevice.DeviceID, loginDevice.SessionVersion)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to initialize refresh session"})
+		respondError(w, http.StatusInternalServerError, "failed to initialize refresh session")
 		return
 	}
 	csrfToken, err := generateCSRFToken()
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to initialize session"})
+		respondError(w, http.StatusInternalServerError, "failed to initialize session")
 		return
 	}
 	setSessionCookies(
@@ -140,7 +171,7 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 func (a *App) handleSession(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	if r.Method != http.MethodGet {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	respondJSON(w, http.StatusOK, map[string]any{
@@ -155,22 +186,26 @@ func (a *App) handleSession(w http.ResponseWriter, r *http.Request, auth AuthCon
 			"sessionVersion": auth.DeviceSessionVersion,
 			"lastSeenAt":     auth.DeviceLastSeenAt.UTC().Format(time.RFC3339Nano),
 		},
+		"policy": map[string]any{
+			"requiredVersion": auth.PolicyVersion,
+			"acceptedVersion": auth.AcceptedPolicyVersion,
+		},
 	})
 }
 
 func (a *App) handleRefresh(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
 	refreshToken := refreshTokenFromRequest(r)
 	if refreshToken == "" {
-		respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "refresh session required"})
+		respondError(w, http.StatusUnauthorized, "refresh session required")
 		return
 	}
 	if !validateCSRFToken(r) {
-		respondJSON(w, http.StatusForbidden, map[string]any{"error": "csrf token validation failed"})
+		respondError(w, http.StatusForbidden, "csrf token validation failed")
 		return
 	}
 
@@ -180,10 +215,10 @@ func (a *App) handleRefresh(w http.ResponseWriter, r *http.Request) {
 	auth, rotatedRefreshToken, err := a.rotateRefreshToken(ctx, refreshToken)
 	if err != nil {
 		if errors.Is(err, errRefreshTokenInvalid) || errors.Is(err, errRefreshTokenExpired) {
-			respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "refresh session expired"})
+			respondError(w, http.StatusUnauthorized, "refresh session expired")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to refresh session"})
+		respondError(w, http.StatusInternalServerError, "failed to refresh session")
 		return
 	}
 
@@ -191,16 +226,17 @@ func (a *App) handleRefresh(w http.ResponseWriter, r *http.Request) {
 		auth.UserID,
 		auth.Username,
 		auth.Role,
+		roleGrant{Name: auth.CustomRole, Version: auth.RoleVersion},
 		auth.DeviceID,
 		auth.DeviceSessionVersion,
 	)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to issue refreshed token"})
+		respondError(w, http.StatusInternalServerError, "failed to issue refreshed token")
 		return
 	}
 	csrfToken, err := generateCSRFToken()
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to rotate csrf token"})
+		respondError(w, http.StatusInternalServerError, "failed to rotate csrf token")
 		return
 	}
 
@@ -232,14 +268,14 @@ func (a *App) handleRefresh(w http.ResponseWriter, r *http.Request) {
 
 func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	accessToken, _ := authTokenFromRequest(r)
 	refreshToken := refreshTokenFromRequest(r)
 	if accessToken != "" || refreshToken != "" {
 		if !validateCSRFToken(r) {
-			respondJSON(w, http.StatusForbidden, map[string]any{"error": "csrf token validation failed"})
+			respondError(w, http.StatusForbidden, "csrf token validation failed")
 			return
 		}
 	}
@@ -247,7 +283,7 @@ func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 		if err := a.revokeRefreshToken(ctx, refreshToken); err != nil {
 			cancel()
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to revoke refresh session"})
+			respondError(w, http.StatusInternalServerError, "failed to revoke refresh session")
 			return
 		}
 		cancel()
@@ -256,50 +292,22 @@ func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]any{"loggedOut": true})
 }
 
-func (a *App) handleAdminUsers(w http.ResponseWriter, r *http.Request, _ AuthContext) {
+func (a *App) handleAdminUsers(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	switch r.Method {
 	case http.MethodGet:
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-		defer cancel()
-
-		rows, err := a.db.QueryContext(ctx, `
-SELECT id, username, role, created_at
-FROM users
-ORDER BY id ASC
-`)
-		if err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to list users"})
-			return
-		}
-		defer rows.Close()
-
-		type userResp struct {
-			ID        int64  `json:"id"`
-			Username  string `json:"username"`
-			Role      string `json:"role"`
-			CreatedAt string `json:"createdAt"`
-		}
-		users := make([]userResp, 0, 16)
-		for rows.Next() {
-			var user userResp
-			var createdAt time.Time
-			if err := rows.Scan(&user.ID, &user.Username, &user.Role, &createdAt); err != nil {
-				respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to decode user list"})
-				return
-			}
-			user.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
-			users = append(users, user)
-		}
-		respondJSON(w, http.StatusOK, map[string]any{"users": users})
+		a.listAdminUsers(w, r)
 
 	case http.MethodPost:
 		var req struct {
 			Username string `json:"username"`
 			Password string `json:"password"`
 			Role     string `json:"role,omitempty"`
+			// Email, when SMTP is configured, sends the user a first-login
+			// link; the password may then be left empty.
+			Email string `json:"email,omitempty"`
 		}
 		if err := decodeJSON(r, &req); err != nil {
-			respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid json body"})
+			respondError(w, http.StatusBadRequest, "invalid json body")
 			return
 		}
 
@@ -309,45 +317,93 @@ ORDER BY id ASC
 			req.Role = "user"
 		}
 		if req.Role != "user" {
-			respondJSON(w, http.StatusBadRequest, map[string]any{"error": "only role=user is allowed for managed creation"})
+			respondError(w, http.StatusBadRequest, "only role=user is allowed for managed creation")
 			return
 		}
 		if len(req.Username) < 3 || len(req.Username) > 32 {
-			respondJSON(w, http.StatusBadRequest, map[string]any{"error": "username length must be between 3 and 32"})
+			respondError(w, http.StatusBadRequest, "username length must be between 3 and 32")
 			return
 		}
-		if len(req.Password) < 8 || len(req.Password) > 128 {
-			respondJSON(w, http.StatusBadRequest, map[string]any{"error": "password length must be between 8 and 128"})
+		var email any
+		if strings.TrimSpace(req.Email) != "" {
+			normalized, ok := normalizeEmail(req.Email)
+			if !ok {
+				respondError(w, http.StatusBadRequest, "invalid email address")
+				return
+			}
+			email = normalized
+		}
+		onboarding := req.Password == "" && email != nil
+		if onboarding && a.mail == nil {
+			respondError(w, http.StatusBadRequest, "a password is required unless email delivery is configured")
 			return
 		}
-		if req.Username == a.adminUsername {
-			respondJSON(w, http.StatusBadRequest, map[string]any{"error": "reserved username"})
+		if !onboarding && (len(req.Password) < 8 || len(req.Password) > 128) {
+			respondError(w, http.StatusBadRequest, "password length must be between 8 and 128")
 			return
 		}
-
-		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-		if err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to hash password"})
+		if a.isConfiguredAdmin(req.Username) {
+			respondError(w, http.StatusBadRequest, "reserved username")
 			return
 		}
 
+		var hash string
+		if onboarding {
+			unusable, err := unusablePasswordHash()
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to hash password")
+				return
+			}
+			hash = unusable
+		} else {
+			generated, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to hash password")
+				return
+			}
+			hash = string(generated)
+		}
+
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
+		if _, err := releaseHeldUsernames(ctx, a.db, a.usernameHold()); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create user")
+			return
+		}
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create user")
+			return
+		}
+		defer tx.Rollback()
 		var userID int64
 		var createdAt time.Time
-		err = a.db.QueryRowContext(ctx, `
-INSERT INTO users(username, password_hash, role)
-VALUES ($1, $2, $3)
+		err = tx.QueryRowContext(ctx, `
+INSERT INTO users(username, password_hash, role, email)
+VALUES ($1, $2, $3, $4)
 RETURNING id, created_at
-`, req.Username, string(hash), req.Role).Scan(&userID, &createdAt)
+`, req.Username, hash, req.Role, email).Scan(&userID, &createdAt)
 		if err != nil {
-			if strings.Contains(strings.ToLower(err.Error()), "duplicate") {
-				respondJSON(w, http.StatusConflict, map[string]any{"error": "username already exists"})
+			if isUniqueViolation(err) {
+				respondError(w, http.StatusConflict, "username or email already exists")
 				return
 			}
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to create user"})
+			respondError(w, http.StatusInternalServerError, "failed to create user")
 			return
 		}
+		if onboarding {
+			if err := a.queueOnboardingEmail(ctx, tx, userID, auth.UserID, req.Username); err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to queue onboarding email")
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create user")
+			return
+		}
+		if onboarding {
+			a.mail.Notify()
+		}
 
 		respondJSON(w, http.StatusCreated, map[string]any{
 			"user": map[string]any{
@@ -356,23 +412,47 @@ RETURNING id, created_at
 				"role":      req.Role,
 				"createdAt": createdAt.UTC().Format(time.RFC3339Nano),
 			},
+			"onboardingEmailQueued": onboarding,
 		})
 
 	default:
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (a *App) handleAdminUserSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	if len(parts) != 4 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "users" {
-		respondJSON(w, http.StatusNotFound, map[string]any{"error": "not found"})
+	if len(parts) < 4 || len(parts) > 5 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "users" {
+		respondError(w, http.StatusNotFound, "not found")
 		return
 	}
 
 	userID, err := strconv.ParseInt(parts[3], 10, 64)
 	if err != nil || userID <= 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid user id"})
+		respondError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if len(parts) == 5 {
+		switch parts[4] {
+		case "quota":
+			a.handleAdminUserQuota(w, r, auth, userID)
+		case "support-token":
+			a.handleAdminSupportToken(w, r, auth, userID)
+		case "admin-invitation", "role":
+			// Both change what the account may do, so custom roles holding
+			// manage_users cannot use them to escalate.
+			if !auth.Can(permManageRoles) {
+				respondErrorDetails(w, http.StatusForbidden, "permission_denied", "permission required", map[string]any{"permission": permManageRoles})
+				return
+			}
+			if parts[4] == "role" {
+				a.handleAdminUserRole(w, r, auth, userID)
+				return
+			}
+			a.handleAdminInvitation(w, r, auth, userID)
+		default:
+			respondError(w, http.StatusNotFound, "not found")
+		}
 		return
 	}
 
@@ -380,13 +460,13 @@ func (a *App) handleAdminUserSubroutes(w http.ResponseWriter, r *http.Request, a
 	case http.MethodDelete:
 		a.handleAdminDeleteUser(w, r, auth, userID)
 	default:
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (a *App) handleAdminDeleteUser(w http.ResponseWriter, r *http.Request, auth AuthContext, userID int64) {
 	if auth.UserID == userID {
-		respondJSON(w, http.StatusForbidden, map[string]any{"error": "cannot delete the current admin session user"})
+		respondError(w, http.StatusForbidden, "cannot delete the current admin session user")
 		return
 	}
 
@@ -395,39 +475,60 @@ func (a *App) handleAdminDeleteUser(w http.ResponseWriter, r *http.Request, auth
 
 	var username string
 	var role string
+	var deletedAt sql.NullTime
 	err := a.db.QueryRowContext(ctx,
-		`SELECT username, role FROM users WHERE id = $1`,
+		`SELECT username, role, deleted_at FROM users WHERE id = $1`,
 		userID,
-	).Scan(&username, &role)
+	).Scan(&username, &role, &deletedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "user not found"})
+			respondError(w, http.StatusNotFound, "user not found")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load user"})
+		respondError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+	if deletedAt.Valid {
+		respondErrorCode(w, http.StatusConflict, "already_deleted", "user is already deleted")
 		return
 	}
-	if role == "admin" || username == a.adminUsername {
-		respondJSON(w, http.StatusForbidden, map[string]any{"error": "admin user cannot be deleted"})
+	if role == "admin" || a.isConfiguredAdmin(username) {
+		respondError(w, http.StatusForbidden, "admin user cannot be deleted")
 		return
 	}
 
-	var deletedID int64
-	err = a.db.QueryRowContext(ctx,
-		`DELETE FROM users WHERE id = $1 RETURNING id`,
-		userID,
-	).Scan(&deletedID)
+	// Accounts are tombstoned rather than removed: a hard delete would
+	// cascade through messages and break sender joins in history.
+	tx, err := a.db.BeginTx(ctx, nil)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "user not found"})
-			return
-		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to delete user"})
+		respondError(w, http.StatusInternalServerError, "failed to delete user")
 		return
 	}
+	defer tx.Rollback()
+	roomIDs, err := tombstoneUserTx(ctx, tx, userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to delete user")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to delete user")
+		return
+	}
+
+	a.membership.InvalidateUser(userID)
+	a.hub.KickUser(userID, 4003, "account deleted")
+	for _, roomID := range roomIDs {
+		a.announceRoomKeyEpoch(ctx, roomID)
+	}
+	requestLogger(r.Context()).Warn("user_deleted",
+		"user_id", userID,
+		"admin_user_id", auth.UserID,
+		"rooms_left", len(roomIDs),
+	)
 
 	respondJSON(w, http.StatusOK, map[string]any{
-		"deleted": true,
-		"userId":  deletedID,
+		"deleted":            true,
+		"userId":             userID,
+		"usernameReleasedAt": time.Now().Add(a.usernameHold()).UTC().Format(time.RFC3339Nano),
 	})
 }
@@ -31,7 +31,7 @@ func TestHandleLogout(t *testing.T) {
 			t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, response.Code)
 		}
 		payload := decodeBodyMap(t, response)
-		if payload["error"] != "method not allowed" {
+		if payload["code"] != errCodeMethodNotAllowed || payload["message"] != "method not allowed" {
 			t.Fatalf("unexpected payload: %#v", payload)
 		}
 	})
@@ -62,7 +62,7 @@ func TestHandleLogout(t *testing.T) {
 			t.Fatalf("expected %d, got %d", http.StatusForbidden, response.Code)
 		}
 		payload := decodeBodyMap(t, response)
-		if payload["error"] != "csrf token validation failed" {
+		if payload["message"] != "csrf token validation failed" {
 			t.Fatalf("unexpected payload: %#v", payload)
 		}
 	})
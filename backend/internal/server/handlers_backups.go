@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+func (a *App) handleAdminBackups(w http.ResponseWriter, r *http.Request, _ AuthContext) {
+	if a.backups == nil {
+		respondErrorCode(w, http.StatusNotFound, "backups_disabled", "backups are not configured")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		ctx, cancel := context.WithTimeout(r.Context(), s3RequestTimeout)
+		defer cancel()
+		objects, err := a.backups.List(ctx)
+		if err != nil {
+			requestLogger(r.Context()).Error("message_backup_list_failed", "error", err)
+			respondError(w, http.StatusBadGateway, "failed to list backups")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"backups": objects})
+
+	case http.MethodPost:
+		ctx, cancel := context.WithTimeout(r.Context(), backupTimeout)
+		defer cancel()
+		result, err := a.backups.Create(ctx)
+		if err != nil {
+			if errors.Is(err, errBackupInProgress) {
+				respondErrorCode(w, http.StatusConflict, "backup_in_progress", err.Error())
+				return
+			}
+			requestLogger(r.Context()).Error("message_backup_failed", "error", err)
+			respondError(w, http.StatusBadGateway, "failed to create backup")
+			return
+		}
+		respondJSON(w, http.StatusCreated, map[string]any{"backup": result})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) handleAdminBackupRestore(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if a.backups == nil {
+		respondErrorCode(w, http.StatusNotFound, "backups_disabled", "backups are not configured")
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Key     string `json:"key"`
+		Confirm bool   `json:"confirm"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	req.Key = strings.TrimSpace(req.Key)
+	if req.Key == "" {
+		respondError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+	if !req.Confirm {
+		respondErrorCode(w, http.StatusBadRequest, "confirmation_required", "restore replaces all messages and keys; set confirm=true")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), backupTimeout)
+	defer cancel()
+	rows, err := a.backups.Restore(ctx, req.Key)
+	if err != nil {
+		switch {
+		case errors.Is(err, errS3ObjectNotFound):
+			respondError(w, http.StatusNotFound, "backup not found")
+		case errors.Is(err, errBackupInvalidFormat):
+			respondErrorCode(w, http.StatusUnprocessableEntity, "invalid_backup", err.Error())
+		case errors.Is(err, errBackupSchemaChanged):
+			respondErrorCode(w, http.StatusConflict, "schema_mismatch", err.Error())
+		case errors.Is(err, errBackupInProgress):
+			respondErrorCode(w, http.StatusConflict, "backup_in_progress", err.Error())
+		default:
+			requestLogger(r.Context()).Error("message_backup_restore_failed", "key", req.Key, "error", err)
+			respondError(w, http.StatusInternalServerError, "failed to restore backup")
+		}
+		return
+	}
+	a.membership.Reset()
+	if err := a.hub.blocks.Load(r.Context(), a.db); err != nil {
+		requestLogger(r.Context()).Warn("user_blocks_reload_failed", "error", err)
+	}
+	requestLogger(r.Context()).Warn("message_backup_restored", "key", req.Key, "admin_user_id", auth.UserID)
+	respondJSON(w, http.StatusOK, map[string]any{"restored": true, "key": req.Key, "rows": rows})
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type blockedUserResp struct {
+	UserID    int64  `json:"userId"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"createdAt"`
+}
+
+func (a *App) handleBlocks(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT b.blocked_id, u.username, b.created_at
+FROM user_blocks b
+JOIN users u ON u.id = b.blocked_id
+WHERE b.blocker_id = $1
+ORDER BY b.created_at ASC
+`, auth.UserID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list blocks")
+		return
+	}
+	defer rows.Close()
+
+	blocks := make([]blockedUserResp, 0, 8)
+	for rows.Next() {
+		var block blockedUserResp
+		var createdAt time.Time
+		if err := rows.Scan(&block.UserID, &block.Username, &createdAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode blocks")
+			return
+		}
+		block.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		blocks = append(blocks, block)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"blocks": blocks})
+}
+
+// handleBlockSubroutes serves POST and DELETE /api/blocks/{userId}. Blocking
+// is idempotent in both directions so clients can retry freely.
+func (a *App) handleBlockSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "api" || parts[1] != "blocks" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	targetUserID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || targetUserID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if targetUserID == auth.UserID {
+		respondError(w, http.StatusBadRequest, "cannot block yourself")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		var found int64
+		if err := a.db.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1`, targetUserID).Scan(&found); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "user not found")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to load user")
+			return
+		}
+		if _, err := a.db.ExecContext(ctx,
+			`INSERT INTO user_blocks(blocker_id, blocked_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			auth.UserID, targetUserID,
+		); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to block user")
+			return
+		}
+		a.hub.blocks.Add(auth.UserID, targetUserID)
+		respondJSON(w, http.StatusOK, map[string]any{"blocked": true, "userId": targetUserID})
+
+	case http.MethodDelete:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if _, err := a.db.ExecContext(ctx,
+			`DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`,
+			auth.UserID, targetUserID,
+		); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to unblock user")
+			return
+		}
+		a.hub.blocks.Remove(auth.UserID, targetUserID)
+		respondJSON(w, http.StatusOK, map[string]any{"blocked": false, "userId": targetUserID})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -11,7 +11,7 @@ import (
 
 func (a *App) handleDevices(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	if r.Method != http.MethodGet {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
@@ -19,7 +19,7 @@ func (a *App) handleDevices(w http.ResponseWriter, r *http.Request, auth AuthCon
 
 	devices, err := a.listUserDevices(ctx, auth.UserID)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load devices"})
+		respondError(w, http.StatusInternalServerError, "failed to load devices")
 		return
 	}
 	response := make([]DeviceSnapshot, 0, len(devices))
@@ -32,12 +32,12 @@ func (a *App) handleDevices(w http.ResponseWriter, r *http.Request, auth AuthCon
 func (a *App) handleDeviceSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 	if len(parts) != 3 || parts[0] != "api" || parts[1] != "devices" {
-		respondJSON(w, http.StatusNotFound, map[string]any{"error": "not found"})
+		respondError(w, http.StatusNotFound, "not found")
 		return
 	}
 	deviceID := normalizeDeviceID(parts[2])
 	if deviceID == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid device id"})
+		respondError(w, http.StatusBadRequest, "invalid device id")
 		return
 	}
 	switch r.Method {
@@ -46,7 +46,7 @@ func (a *App) handleDeviceSubroutes(w http.ResponseWriter, r *http.Request, auth
 	case http.MethodDelete:
 		a.handleRevokeDevice(w, r, auth, deviceID)
 	default:
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
@@ -56,7 +56,7 @@ func (a *App) handleRenameDevice(w http.ResponseWriter, r *http.Request, auth Au
 		Name       string `json:"name"`
 	}
 	if err := decodeJSON(r, &req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid json body"})
+		respondError(w, http.StatusBadRequest, "invalid json body")
 		return
 	}
 	name := req.DeviceName
@@ -70,10 +70,10 @@ func (a *App) handleRenameDevice(w http.ResponseWriter, r *http.Request, auth Au
 	device, err := a.renameUserDevice(ctx, auth.UserID, deviceID, nextName)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidIdentity) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "device not found"})
+			respondError(w, http.StatusNotFound, "device not found")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to rename device"})
+		respondError(w, http.StatusInternalServerError, "failed to rename device")
 		return
 	}
 	respondJSON(w, http.StatusOK, map[string]any{"device": toDeviceSnapshot(device, auth.DeviceID)})
@@ -86,19 +86,20 @@ func (a *App) handleRevokeDevice(w http.ResponseWriter, r *http.Request, auth Au
 	device, err := a.revokeUserDevice(ctx, auth.UserID, deviceID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidIdentity) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "device not found"})
+			respondError(w, http.StatusNotFound, "device not found")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to revoke device"})
+		respondError(w, http.StatusInternalServerError, "failed to revoke device")
 		return
 	}
 	if err := a.revokeRefreshTokensForDevice(ctx, auth.UserID, deviceID); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to revoke device sessions"})
+		respondError(w, http.StatusInternalServerError, "failed to revoke device sessions")
 		return
 	}
 
 	wasCurrent := device.DeviceID == auth.DeviceID
 	a.hub.KickUserDevice(auth.UserID, deviceID, 4004, "device revoked")
+	a.notifyUserByEmail(ctx, auth.UserID, auth.Username, emailTemplateDeviceRevoked, map[string]any{"DeviceName": device.DeviceName})
 	if wasCurrent {
 		clearSessionCookies(w, isSecureRequest(r))
 	}
@@ -0,0 +1,490 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func respondFederationDisabled(w http.ResponseWriter) {
+	respondErrorCode(w, http.StatusNotFound, "federation_disabled", "federation is disabled")
+}
+
+// handleFederationRelay accepts envelopes pushed by a peer deployment. Peers
+// authenticate with the shared secret configured for their server id.
+func (a *App) handleFederationRelay(w http.ResponseWriter, r *http.Request) {
+	if a.federation == nil {
+		respondFederationDisabled(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	peerServerID := strings.TrimSpace(r.Header.Get("X-Federation-Server"))
+	timestampRaw := strings.TrimSpace(r.Header.Get("X-Federation-Timestamp"))
+	signature := strings.TrimSpace(r.Header.Get("X-Federation-Signature"))
+	if peerServerID == "" || timestampRaw == "" || signature == "" {
+		respondError(w, http.StatusUnauthorized, "federation signature headers are required")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(a.roomLimits.withDefaults().MaxPayloadBytes)+64<<10))
+	if err != nil {
+		respondError(w, http.StatusRequestEntityTooLarge, "relay body too large")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+	defer cancel()
+
+	var peer federationPeer
+	err = a.db.QueryRowContext(ctx, `
+SELECT id, server_id, shared_secret, relay_user_id
+FROM federation_peers
+WHERE server_id = $1 AND disabled_at IS NULL
+`, peerServerID).Scan(&peer.id, &peer.serverID, &peer.secret, &peer.relayUserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusUnauthorized, "unknown federation peer")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load federation peer")
+		return
+	}
+	if err := verifyFederationSignature(peer.secret, timestampRaw, signature, body, time.Now()); err != nil {
+		respondError(w, http.StatusUnauthorized, "invalid federation signature")
+		return
+	}
+
+	var envelope federationEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if envelope.RoomID <= 0 || envelope.OriginMessageID <= 0 || validateFederationServerID(envelope.OriginServer) != nil {
+		respondError(w, http.StatusBadRequest, "invalid relay envelope")
+		return
+	}
+	if err := checkFederationPath(envelope, a.federation.serverID, peer.serverID); err != nil {
+		code := "invalid_path"
+		if errors.Is(err, errFederationLoop) {
+			code = "loop_detected"
+		}
+		respondErrorCode(w, http.StatusConflict, code, err.Error())
+		return
+	}
+	envelope.SenderUsername = strings.TrimSpace(envelope.SenderUsername)
+	if envelope.SenderUsername == "" || len(envelope.SenderUsername) > 64 {
+		respondError(w, http.StatusBadRequest, "invalid sender username")
+		return
+	}
+	var payload CipherPayload
+	if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid relay payload")
+		return
+	}
+	// A peer retrying a relay that was already stored gets the duplicate
+	// answer before its nonce reaches the replay check.
+	var relayed bool
+	if err := a.db.QueryRowContext(ctx, `
+SELECT EXISTS(SELECT 1 FROM federation_inbound WHERE origin_server = $1 AND origin_message_id = $2)
+`, envelope.OriginServer, envelope.OriginMessageID).Scan(&relayed); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load relayed message")
+		return
+	}
+	if relayed {
+		respondJSON(w, http.StatusOK, map[string]any{"duplicate": true})
+		return
+	}
+	if !a.validateExternalCipherPayload(w, peer.relayUserID, payload) {
+		return
+	}
+
+	var linked bool
+	if err := a.db.QueryRowContext(ctx, `
+SELECT EXISTS(SELECT 1 FROM federation_room_links WHERE peer_id = $1 AND room_id = $2)
+`, peer.id, envelope.RoomID).Scan(&linked); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load room link")
+		return
+	}
+	if !linked {
+		respondErrorCode(w, http.StatusNotFound, "room_not_linked", "room is not bridged with this peer")
+		return
+	}
+	// Relayed messages obey the local room's announcement gate and payload
+	// policy like any other, posting as the peer's bot relay user.
+	decision, err := a.loadRoomSendDecision(ctx, peer.relayUserID, roleBot, envelope.RoomID, payload)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load room")
+		return
+	}
+	if !decision.Allowed {
+		respondErrorCode(w, http.StatusForbidden, decision.Code, decision.Error)
+		return
+	}
+
+	stamp, duplicate, err := a.storeRelayedMessage(ctx, peer, envelope)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to store relayed message")
+		return
+	}
+	if duplicate {
+		respondJSON(w, http.StatusOK, map[string]any{"duplicate": true})
+		return
+	}
+	a.deliverStoredCiphertext(
+		envelope.RoomID,
+		peer.relayUserID,
+		federationSenderLabel(envelope.SenderUsername, envelope.OriginServer),
+		"",
+		stamp,
+		payload,
+		nil,
+		nil,
+	)
+	respondJSON(w, http.StatusAccepted, map[string]any{"id": stamp.ID, "roomId": envelope.RoomID})
+}
+
+func (a *App) handleAdminFederationPeers(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if a.federation == nil {
+		respondFederationDisabled(w)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		rows, err := a.db.QueryContext(ctx, `
+SELECT p.id, p.server_id, p.endpoint_url, p.relay_user_id, p.created_at, p.disabled_at,
+	(SELECT COUNT(*) FROM federation_room_links l WHERE l.peer_id = p.id),
+	(SELECT COUNT(*) FROM federation_outbox o WHERE o.peer_id = p.id AND o.status = 'pending'),
+	(SELECT COUNT(*) FROM federation_outbox o WHERE o.peer_id = p.id AND o.status = 'failed')
+FROM federation_peers p
+ORDER BY p.id ASC
+`)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to list federation peers")
+			return
+		}
+		defer rows.Close()
+
+		type peerResp struct {
+			ID          int64  `json:"id"`
+			ServerID    string `json:"serverId"`
+			EndpointURL string `json:"endpointUrl"`
+			RelayUserID int64  `json:"relayUserId"`
+			CreatedAt   string `json:"createdAt"`
+			DisabledAt  string `json:"disabledAt,omitempty"`
+			Links       int    `json:"links"`
+			Pending     int    `json:"pending"`
+			Failed      int    `json:"failed"`
+		}
+		peers := make([]peerResp, 0, 4)
+		for rows.Next() {
+			var peer peerResp
+			var createdAt time.Time
+			var disabledAt sql.NullTime
+			if err := rows.Scan(&peer.ID, &peer.ServerID, &peer.EndpointURL, &peer.RelayUserID, &createdAt, &disabledAt, &peer.Links, &peer.Pending, &peer.Failed); err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to decode federation peers")
+				return
+			}
+			peer.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+			if disabledAt.Valid {
+				peer.DisabledAt = disabledAt.Time.UTC().Format(time.RFC3339Nano)
+			}
+			peers = append(peers, peer)
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"serverId": a.federation.serverID, "peers": peers})
+
+	case http.MethodPost:
+		var req struct {
+			ServerID     string `json:"serverId"`
+			EndpointURL  string `json:"endpointUrl"`
+			SharedSecret string `json:"sharedSecret,omitempty"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		req.ServerID = strings.ToLower(strings.TrimSpace(req.ServerID))
+		req.EndpointURL = strings.TrimSpace(req.EndpointURL)
+		if err := validateFederationServerID(req.ServerID); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.ServerID == a.federation.serverID {
+			respondError(w, http.StatusBadRequest, "peer server id must differ from this server")
+			return
+		}
+		if err := validateWebhookURL(req.EndpointURL); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// Both sides must hold the same secret, so the second deployment to
+		// register the link supplies the one the first generated.
+		secret := strings.TrimSpace(req.SharedSecret)
+		if secret == "" {
+			generated, err := generateWebhookSecret()
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to generate secret")
+				return
+			}
+			secret = generated
+		} else if len(secret) < minFederationSecretLength {
+			respondError(w, http.StatusBadRequest, "shared secret must be at least 32 characters")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to begin transaction")
+			return
+		}
+		defer tx.Rollback()
+
+		var relayUserID int64
+		if err := tx.QueryRowContext(ctx, `
+INSERT INTO users(username, password_hash, role)
+VALUES ($1, $2, 'bot')
+RETURNING id
+`, federationRelayUserPrefix+req.ServerID, botDisabledPassword).Scan(&relayUserID); err != nil {
+			if isUniqueViolation(err) {
+				respondError(w, http.StatusConflict, "federation peer already exists")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to create relay user")
+			return
+		}
+		var peerID int64
+		var createdAt time.Time
+		if err := tx.QueryRowContext(ctx, `
+INSERT INTO federation_peers(server_id, endpoint_url, shared_secret, relay_user_id, created_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at
+`, req.ServerID, req.EndpointURL, secret, relayUserID, auth.UserID).Scan(&peerID, &createdAt); err != nil {
+			if isUniqueViolation(err) {
+				respondError(w, http.StatusConflict, "federation peer already exists")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to create federation peer")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create federation peer")
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, map[string]any{
+			"peer": map[string]any{
+				"id":          peerID,
+				"serverId":    req.ServerID,
+				"endpointUrl": req.EndpointURL,
+				"relayUserId": relayUserID,
+				"createdAt":   createdAt.UTC().Format(time.RFC3339Nano),
+			},
+			"sharedSecret": secret,
+		})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) handleAdminFederationPeerSubroutes(w http.ResponseWriter, r *http.Request, _ AuthContext) {
+	if a.federation == nil {
+		respondFederationDisabled(w)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 5 || len(parts) > 7 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "federation" || parts[3] != "peers" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	peerID, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil || peerID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid peer id")
+		return
+	}
+
+	switch {
+	case len(parts) == 5:
+		a.handleAdminDisableFederationPeer(w, r, peerID)
+	case parts[5] != "links":
+		respondError(w, http.StatusNotFound, "not found")
+	case len(parts) == 6:
+		a.handleAdminFederationLinks(w, r, peerID)
+	default:
+		linkID, err := strconv.ParseInt(parts[6], 10, 64)
+		if err != nil || linkID <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid link id")
+			return
+		}
+		a.handleAdminDeleteFederationLink(w, r, peerID, linkID)
+	}
+}
+
+func (a *App) handleAdminDisableFederationPeer(w http.ResponseWriter, r *http.Request, peerID int64) {
+	if r.Method != http.MethodDelete {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var disabledID int64
+	err := a.db.QueryRowContext(ctx, `
+UPDATE federation_peers
+SET disabled_at = COALESCE(disabled_at, NOW())
+WHERE id = $1
+RETURNING id
+`, peerID).Scan(&disabledID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "federation peer not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to disable federation peer")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"disabled": true, "peerId": disabledID})
+}
+
+func (a *App) handleAdminFederationLinks(w http.ResponseWriter, r *http.Request, peerID int64) {
+	switch r.Method {
+	case http.MethodGet:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		rows, err := a.db.QueryContext(ctx, `
+SELECT id, room_id, remote_room_id, created_at
+FROM federation_room_links
+WHERE peer_id = $1
+ORDER BY id ASC
+`, peerID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to list room links")
+			return
+		}
+		defer rows.Close()
+
+		type linkResp struct {
+			ID           int64  `json:"id"`
+			RoomID       int64  `json:"roomId"`
+			RemoteRoomID int64  `json:"remoteRoomId"`
+			CreatedAt    string `json:"createdAt"`
+		}
+		links := make([]linkResp, 0, 4)
+		for rows.Next() {
+			var link linkResp
+			var createdAt time.Time
+			if err := rows.Scan(&link.ID, &link.RoomID, &link.RemoteRoomID, &createdAt); err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to decode room links")
+				return
+			}
+			link.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+			links = append(links, link)
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"links": links})
+
+	case http.MethodPost:
+		var req struct {
+			RoomID       int64 `json:"roomId"`
+			RemoteRoomID int64 `json:"remoteRoomId"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		if req.RoomID <= 0 || req.RemoteRoomID <= 0 {
+			respondError(w, http.StatusBadRequest, "roomId and remoteRoomId are required")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		var relayUserID int64
+		if err := a.db.QueryRowContext(ctx,
+			`SELECT relay_user_id FROM federation_peers WHERE id = $1 AND disabled_at IS NULL`,
+			peerID,
+		).Scan(&relayUserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "federation peer not found")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to load federation peer")
+			return
+		}
+		// The relay user joins the room so bridged messages have a member
+		// sender and count against the member cap like anyone else.
+		if err := a.addRoomMember(ctx, req.RoomID, relayUserID); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				respondError(w, http.StatusNotFound, "room not found")
+			case errors.Is(err, errRoomFull):
+				respondErrorCode(w, http.StatusConflict, "room_full", errRoomFull.Error())
+			default:
+				respondError(w, http.StatusInternalServerError, "failed to add relay user to room")
+			}
+			return
+		}
+
+		var linkID int64
+		if err := a.db.QueryRowContext(ctx, `
+INSERT INTO federation_room_links(peer_id, room_id, remote_room_id)
+VALUES ($1, $2, $3)
+RETURNING id
+`, peerID, req.RoomID, req.RemoteRoomID).Scan(&linkID); err != nil {
+			if isUniqueViolation(err) {
+				respondError(w, http.StatusConflict, "room is already bridged with this peer")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to create room link")
+			return
+		}
+		respondJSON(w, http.StatusCreated, map[string]any{
+			"link": map[string]any{
+				"id":           linkID,
+				"peerId":       peerID,
+				"roomId":       req.RoomID,
+				"remoteRoomId": req.RemoteRoomID,
+			},
+		})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) handleAdminDeleteFederationLink(w http.ResponseWriter, r *http.Request, peerID, linkID int64) {
+	if r.Method != http.MethodDelete {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var deletedID int64
+	err := a.db.QueryRowContext(ctx,
+		`DELETE FROM federation_room_links WHERE id = $1 AND peer_id = $2 RETURNING id`,
+		linkID, peerID,
+	).Scan(&deletedID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "room link not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to delete room link")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"deleted": true, "linkId": deletedID})
+}
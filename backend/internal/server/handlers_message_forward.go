@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// handleMessageForward copies a message into another room. The client
+// re-wraps the content key for the target room's members and submits a fresh
+// payload; the server only vouches for the provenance, checking that the
+// caller can read the source message and post in the target room.
+func (a *App) handleMessageForward(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID, messageID int64) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		TargetRoomID int64         `json:"targetRoomId"`
+		Payload      CipherPayload `json:"payload"`
+		Mentions     []int64       `json:"mentions,omitempty"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if req.TargetRoomID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid target room id")
+		return
+	}
+	if req.TargetRoomID == roomID {
+		respondError(w, http.StatusBadRequest, "cannot forward a message into its own room")
+		return
+	}
+	payload := req.Payload
+	if !a.validateExternalCipherPayload(w, auth.UserID, payload) {
+		return
+	}
+	if normalizeDeviceID(payload.SenderDeviceID) != auth.DeviceID {
+		respondError(w, http.StatusBadRequest, "sender device does not match the session")
+		return
+	}
+	mentions, err := normalizeMentions(req.Mentions, auth.UserID)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	for _, id := range []int64{roomID, req.TargetRoomID} {
+		if err := a.ensureMembership(ctx, auth.UserID, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondErrorDetails(w, http.StatusForbidden, "not_room_member", "not a room member", map[string]any{"roomId": id})
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+			return
+		}
+	}
+
+	var revokedAt sql.NullTime
+	err = a.db.QueryRowContext(ctx,
+		`SELECT revoked_at FROM messages WHERE id = $1 AND room_id = $2`,
+		messageID, roomID,
+	).Scan(&revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "message not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load message")
+		return
+	}
+	if revokedAt.Valid {
+		respondError(w, http.StatusGone, "message has been revoked")
+		return
+	}
+
+	decision, err := a.loadRoomSendDecision(ctx, auth.UserID, auth.Role, req.TargetRoomID, payload)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load target room")
+		return
+	}
+	if !decision.Allowed {
+		respondErrorCode(w, http.StatusForbidden, decision.Code, decision.Error)
+		return
+	}
+	mentions, err = a.filterRoomMembers(ctx, req.TargetRoomID, mentions)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to validate mentions")
+		return
+	}
+
+	forward := &messageForward{RoomID: roomID, MessageID: messageID}
+	stamp, err := a.storeMessageFrom(ctx, req.TargetRoomID, auth.UserID, payload, mentions, forward)
+	if err != nil {
+		if respondMessageQuotaError(w, err) {
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to store message")
+		return
+	}
+	a.deliverStoredCiphertext(req.TargetRoomID, auth.UserID, auth.Username, auth.DisplayName, stamp, payload, mentions, forward)
+
+	respondJSON(w, http.StatusCreated, map[string]any{
+		"id":                     stamp.ID,
+		"roomId":                 req.TargetRoomID,
+		"seq":                    stamp.Seq,
+		"createdAt":              stamp.CreatedAt.UTC().Format(time.RFC3339Nano),
+		"forwardedFromRoomId":    roomID,
+		"forwardedFromMessageId": messageID,
+	})
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMessageForwardValidatesTarget(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	auth := AuthContext{UserID: 1, Username: "alice", Role: "user", DeviceID: "device-1"}
+	cases := []struct {
+		method   string
+		body     string
+		expected int
+	}{
+		{method: http.MethodGet, expected: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, body: `{"payload":{}}`, expected: http.StatusBadRequest},
+		{method: http.MethodPost, body: `{"targetRoomId":4,"payload":{}}`, expected: http.StatusBadRequest},
+		{method: http.MethodPost, body: `{"targetRoomId":5,"payload":{}}`, expected: http.StatusBadRequest},
+	}
+	for _, item := range cases {
+		request := httptest.NewRequest(item.method, "/api/rooms/4/messages/9/forward", strings.NewReader(item.body))
+		response := httptest.NewRecorder()
+		app.handleMessageForward(response, request, auth, 4, 9)
+		if response.Code != item.expected {
+			t.Fatalf("%s %s: expected %d, got %d", item.method, item.body, item.expected, response.Code)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const maxReadReceiptsPerBatch = 100
+
+type readReceiptUpdate struct {
+	RoomID        int64 `json:"roomId"`
+	UpToMessageID int64 `json:"upToMessageId"`
+}
+
+type readReceiptResult struct {
+	RoomID        int64  `json:"roomId"`
+	UpToMessageID int64  `json:"upToMessageId"`
+	Applied       bool   `json:"applied"`
+	Error         string `json:"error,omitempty"`
+}
+
+// broadcastReadReceipt fans out a receipt; eventID is 0 when the cursor did
+// not move, in which case nothing is dead-lettered.
+func (a *App) broadcastReadReceipt(roomID, userID int64, username string, upToMessageID, eventID int64) {
+	payload, err := json.Marshal(map[string]any{
+		"type":          "read_receipt",
+		"roomId":        roomID,
+		"fromUserId":    userID,
+		"fromUsername":  username,
+		"upToMessageId": upToMessageID,
+	})
+	if err != nil {
+		return
+	}
+	a.broadcastEvent(roomID, eventID, userID, userID, payload)
+}
+
+// collapseReadReceipts keeps the highest cursor per room, preserving the
+// order in which rooms first appear in the batch.
+func collapseReadReceipts(updates []readReceiptUpdate) []readReceiptUpdate {
+	indexByRoom := make(map[int64]int, len(updates))
+	collapsed := make([]readReceiptUpdate, 0, len(updates))
+	for _, update := range updates {
+		if index, found := indexByRoom[update.RoomID]; found {
+			if update.UpToMessageID > collapsed[index].UpToMessageID {
+				collapsed[index].UpToMessageID = update.UpToMessageID
+			}
+			continue
+		}
+		indexByRoom[update.RoomID] = len(collapsed)
+		collapsed = append(collapsed, update)
+	}
+	return collapsed
+}
+
+func (a *App) handleBulkReadReceipts(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Receipts []readReceiptUpdate `json:"receipts"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if len(req.Receipts) == 0 {
+		respondError(w, http.StatusBadRequest, "receipts are required")
+		return
+	}
+	if len(req.Receipts) > maxReadReceiptsPerBatch {
+		respondError(w, http.StatusBadRequest, "too many read receipts in one batch")
+		return
+	}
+	for _, update := range req.Receipts {
+		if update.RoomID <= 0 || update.UpToMessageID <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid read receipt")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	updates := collapseReadReceipts(req.Receipts)
+	results := make([]readReceiptResult, 0, len(updates))
+	for _, update := range updates {
+		result := readReceiptResult{RoomID: update.RoomID, UpToMessageID: update.UpToMessageID}
+		if err := a.ensureMembership(ctx, auth.UserID, update.RoomID); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+				return
+			}
+			result.Error = "not a room member"
+			results = append(results, result)
+			continue
+		}
+		eventID, err := a.applyReadReceipt(ctx, auth.UserID, update.RoomID, update.UpToMessageID)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusInternalServerError, "failed to update read receipt")
+				return
+			}
+			result.Error = "message not found"
+			results = append(results, result)
+			continue
+		}
+		result.Applied = true
+		results = append(results, result)
+		a.broadcastReadReceipt(update.RoomID, auth.UserID, auth.Username, update.UpToMessageID, eventID)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{"results": results})
+}
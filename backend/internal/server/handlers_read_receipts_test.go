@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCollapseReadReceipts(t *testing.T) {
+	t.Parallel()
+
+	collapsed := collapseReadReceipts([]readReceiptUpdate{
+		{RoomID: 2, UpToMessageID: 10},
+		{RoomID: 1, UpToMessageID: 5},
+		{RoomID: 2, UpToMessageID: 8},
+		{RoomID: 2, UpToMessageID: 14},
+	})
+	if len(collapsed) != 2 {
+		t.Fatalf("expected 2 collapsed receipts, got %d", len(collapsed))
+	}
+	if collapsed[0].RoomID != 2 || collapsed[0].UpToMessageID != 14 {
+		t.Fatalf("unexpected first receipt: %+v", collapsed[0])
+	}
+	if collapsed[1].RoomID != 1 || collapsed[1].UpToMessageID != 5 {
+		t.Fatalf("unexpected second receipt: %+v", collapsed[1])
+	}
+}
+
+func TestHandleBulkReadReceiptsValidation(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	auth := AuthContext{UserID: 1, Username: "alice", Role: "user"}
+
+	cases := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{name: "wrong method", method: http.MethodGet, body: "", status: http.StatusMethodNotAllowed},
+		{name: "empty batch", method: http.MethodPost, body: `{"receipts":[]}`, status: http.StatusBadRequest},
+		{name: "invalid room", method: http.MethodPost, body: `{"receipts":[{"roomId":0,"upToMessageId":3}]}`, status: http.StatusBadRequest},
+		{name: "invalid cursor", method: http.MethodPost, body: `{"receipts":[{"roomId":1,"upToMessageId":0}]}`, status: http.StatusBadRequest},
+	}
+	for _, item := range cases {
+		item := item
+		t.Run(item.name, func(t *testing.T) {
+			request := httptest.NewRequest(item.method, "/api/read-receipts", strings.NewReader(item.body))
+			response := httptest.NewRecorder()
+
+			app.handleBulkReadReceipts(response, request, auth)
+
+			if response.Code != item.status {
+				t.Fatalf("expected %d, got %d", item.status, response.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxReportNoteLength = 1000
+
+var messageReportReasons = map[string]struct{}{
+	"spam":            {},
+	"harassment":      {},
+	"illegal_content": {},
+	"impersonation":   {},
+	"other":           {},
+}
+
+const (
+	reportActionDismiss       = "dismiss"
+	reportActionRevokeMessage = "revoke_message"
+	reportActionSuspendSender = "suspend_sender"
+)
+
+type messageReportResp struct {
+	ID             int64  `json:"id"`
+	MessageID      int64  `json:"messageId"`
+	RoomID         int64  `json:"roomId"`
+	ReporterID     int64  `json:"reporterId"`
+	SenderID       int64  `json:"senderId"`
+	SenderUsername string `json:"senderUsername"`
+	Reason         string `json:"reason"`
+	Note           string `json:"note,omitempty"`
+	Status         string `json:"status"`
+	Action         string `json:"action,omitempty"`
+	ReviewedBy     *int64 `json:"reviewedBy,omitempty"`
+	ReviewedAt     string `json:"reviewedAt,omitempty"`
+	CreatedAt      string `json:"createdAt"`
+}
+
+func validReportAction(action string) bool {
+	switch action {
+	case reportActionDismiss, reportActionRevokeMessage, reportActionSuspendSender:
+		return true
+	}
+	return false
+}
+
+// handleMessageReport lets a room member flag a message for admin review. The
+// server never sees plaintext, so the reporter's reason code and note are all
+// a reviewer has to go on.
+func (a *App) handleMessageReport(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID, messageID int64) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if _, ok := messageReportReasons[req.Reason]; !ok {
+		respondError(w, http.StatusBadRequest, "reason must be one of spam, harassment, illegal_content, impersonation, other")
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > maxReportNoteLength {
+		respondError(w, http.StatusBadRequest, "note must be at most 1000 characters")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "not a room member")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+		return
+	}
+
+	var senderID int64
+	err := a.db.QueryRowContext(ctx,
+		`SELECT sender_id FROM messages WHERE id = $1 AND room_id = $2`,
+		messageID, roomID,
+	).Scan(&senderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "message not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load message")
+		return
+	}
+	if senderID == auth.UserID {
+		respondError(w, http.StatusBadRequest, "cannot report your own message")
+		return
+	}
+
+	var reportID int64
+	err = a.db.QueryRowContext(ctx, `
+INSERT INTO message_reports(message_id, room_id, reporter_id, reason, note)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id
+`, messageID, roomID, auth.UserID, req.Reason, req.Note).Scan(&reportID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			respondErrorCode(w, http.StatusConflict, "already_reported", "message already reported")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to store report")
+		return
+	}
+	requestLogger(r.Context()).Info("message_reported",
+		"report_id", reportID,
+		"room_id", roomID,
+		"message_id", messageID,
+		"reporter_id", auth.UserID,
+		"reason", req.Reason,
+	)
+	respondJSON(w, http.StatusCreated, map[string]any{"id": reportID, "status": "pending"})
+}
+
+func (a *App) handleAdminReports(w http.ResponseWriter, r *http.Request, _ AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	if status == "" {
+		status = "pending"
+	}
+	if status != "pending" && status != "dismissed" && status != "actioned" && status != "all" {
+		respondError(w, http.StatusBadRequest, "status must be pending, dismissed, actioned or all")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT mr.id, mr.message_id, mr.room_id, mr.reporter_id, m.sender_id, COALESCE(u.former_username, u.username),
+       mr.reason, mr.note, mr.status, mr.action, mr.reviewed_by, mr.reviewed_at, mr.created_at
+FROM message_reports mr
+JOIN messages m ON m.id = mr.message_id
+JOIN users u ON u.id = m.sender_id
+WHERE $1 = 'all' OR mr.status = $1
+ORDER BY mr.created_at ASC, mr.id ASC
+LIMIT 200
+`, status)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list reports")
+		return
+	}
+	defer rows.Close()
+
+	reports := make([]messageReportResp, 0, 16)
+	for rows.Next() {
+		var report messageReportResp
+		var action sql.NullString
+		var reviewedBy sql.NullInt64
+		var reviewedAt sql.NullTime
+		var createdAt time.Time
+		if err := rows.Scan(
+			&report.ID, &report.MessageID, &report.RoomID, &report.ReporterID, &report.SenderID, &report.SenderUsername,
+			&report.Reason, &report.Note, &report.Status, &action, &reviewedBy, &reviewedAt, &createdAt,
+		); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode reports")
+			return
+		}
+		report.Action = action.String
+		if reviewedBy.Valid {
+			value := reviewedBy.Int64
+			report.ReviewedBy = &value
+		}
+		if reviewedAt.Valid {
+			report.ReviewedAt = reviewedAt.Time.UTC().Format(time.RFC3339Nano)
+		}
+		report.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		reports = append(reports, report)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"reports": reports})
+}
+
+func (a *App) handleAdminReportSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 5 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "reports" || parts[4] != "resolve" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	reportID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || reportID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid report id")
+		return
+	}
+	var req struct {
+		Action string `json:"action"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	req.Action = strings.TrimSpace(req.Action)
+	if !validReportAction(req.Action) {
+		respondError(w, http.StatusBadRequest, "action must be dismiss, revoke_message or suspend_sender")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var messageID, roomID, senderID int64
+	var status, senderRole string
+	err = a.db.QueryRowContext(ctx, `
+SELECT mr.message_id, mr.room_id, mr.status, m.sender_id, u.role
+FROM message_reports mr
+JOIN messages m ON m.id = mr.message_id
+JOIN users u ON u.id = m.sender_id
+WHERE mr.id = $1
+`, reportID).Scan(&messageID, &roomID, &status, &senderID, &senderRole)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "report not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load report")
+		return
+	}
+	if status != "pending" {
+		respondErrorCode(w, http.StatusConflict, "already_resolved", "report has already been resolved")
+		return
+	}
+
+	switch req.Action {
+	case reportActionRevokeMessage:
+		revokedSenderID, revokedAt, eventID, err := a.moderatorRevokeMessage(ctx, roomID, messageID, auth.UserID)
+		switch {
+		case err == nil:
+			a.broadcastModeratedRevoke(roomID, messageID, revokedSenderID, eventID, revokedAt, auth)
+		case errors.Is(err, sql.ErrNoRows):
+			// Already revoked by the sender or another moderator; resolving the
+			// report is all that is left to do.
+		default:
+			respondError(w, http.StatusInternalServerError, "failed to revoke message")
+			return
+		}
+	case reportActionSuspendSender:
+		if senderRole == "admin" {
+			respondError(w, http.StatusForbidden, "admin user cannot be suspended")
+			return
+		}
+		if _, err := a.db.ExecContext(ctx,
+			`UPDATE users SET suspended_at = NOW() WHERE id = $1 AND suspended_at IS NULL`,
+			senderID,
+		); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to suspend sender")
+			return
+		}
+		a.hub.KickUser(senderID, 4003, "account suspended")
+	}
+
+	// A revoke or suspension settles every pending report on the message, not
+	// only the one being reviewed.
+	resolvedStatus := "actioned"
+	if req.Action == reportActionDismiss {
+		resolvedStatus = "dismissed"
+	}
+	result, err := a.db.ExecContext(ctx, `
+UPDATE message_reports
+SET status = $2, action = $3, reviewed_by = $4, reviewed_at = NOW()
+WHERE status = 'pending' AND (id = $1 OR ($5 AND message_id = $6))
+`, reportID, resolvedStatus, req.Action, auth.UserID, req.Action != reportActionDismiss, messageID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to resolve report")
+		return
+	}
+	resolved, _ := result.RowsAffected()
+
+	requestLogger(r.Context()).Warn("message_report_resolved",
+		"report_id", reportID,
+		"action", req.Action,
+		"room_id", roomID,
+		"message_id", messageID,
+		"sender_id", senderID,
+		"admin_user_id", auth.UserID,
+	)
+	respondJSON(w, http.StatusOK, map[string]any{
+		"id":       reportID,
+		"status":   resolvedStatus,
+		"action":   req.Action,
+		"resolved": resolved,
+	})
+}
+
+// broadcastModeratedRevoke mirrors the frame a WS moderator revoke sends so
+// clients render an admin-resolved revoke the same way.
+func (a *App) broadcastModeratedRevoke(roomID, messageID, senderID, eventID int64, revokedAt time.Time, auth AuthContext) {
+	payload, err := json.Marshal(map[string]any{
+		"type":         "message_update",
+		"roomId":       roomID,
+		"messageId":    messageID,
+		"mode":         "revoke",
+		"fromUserId":   auth.UserID,
+		"fromUsername": auth.Username,
+		"senderId":     senderID,
+		"revokedAt":    revokedAt.UTC().Format(time.RFC3339Nano),
+		"revokedBy":    auth.UserID,
+		"moderated":    true,
+	})
+	if err != nil {
+		return
+	}
+	a.broadcastEvent(roomID, eventID, auth.UserID, 0, payload)
+}
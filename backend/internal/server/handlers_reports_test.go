@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMessageReportValidatesReason(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	cases := []struct {
+		body     string
+		expected int
+	}{
+		{body: `{"reason":"boring"}`, expected: http.StatusBadRequest},
+		{body: `{"reason":""}`, expected: http.StatusBadRequest},
+		{body: `{"reason":"spam","note":"` + strings.Repeat("x", maxReportNoteLength+1) + `"}`, expected: http.StatusBadRequest},
+		{body: `not json`, expected: http.StatusBadRequest},
+	}
+	for _, item := range cases {
+		request := httptest.NewRequest(http.MethodPost, "/api/rooms/1/messages/2/report", strings.NewReader(item.body))
+		response := httptest.NewRecorder()
+		app.handleMessageReport(response, request, AuthContext{UserID: 3}, 1, 2)
+		if response.Code != item.expected {
+			t.Fatalf("body %.40q: expected %d, got %d", item.body, item.expected, response.Code)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/messages/2/report", nil)
+	response := httptest.NewRecorder()
+	app.handleMessageReport(response, request, AuthContext{UserID: 3}, 1, 2)
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", response.Code)
+	}
+}
+
+func TestAdminReportResolveValidatesRequest(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	cases := []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		{method: http.MethodPost, path: "/api/admin/reports/7/resolve", body: `{"action":"ban_forever"}`, expected: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/api/admin/reports/abc/resolve", body: `{"action":"dismiss"}`, expected: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/api/admin/reports/7", body: `{"action":"dismiss"}`, expected: http.StatusNotFound},
+		{method: http.MethodGet, path: "/api/admin/reports/7/resolve", expected: http.StatusMethodNotAllowed},
+	}
+	for _, item := range cases {
+		request := httptest.NewRequest(item.method, item.path, strings.NewReader(item.body))
+		response := httptest.NewRecorder()
+		app.handleAdminReportSubroutes(response, request, AuthContext{UserID: 1, Role: "admin"})
+		if response.Code != item.expected {
+			t.Fatalf("%s %s: expected %d, got %d", item.method, item.path, item.expected, response.Code)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/admin/reports?status=closed", nil)
+	response := httptest.NewRecorder()
+	app.handleAdminReports(response, request, AuthContext{UserID: 1, Role: "admin"})
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown status, got %d", response.Code)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// handleRoomTransferOwnership reassigns created_by to another member. Rooms
+// whose creator was deleted have no owner left, so admins use this to hand
+// them to someone who can moderate.
+func (a *App) handleRoomTransferOwnership(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		UserID int64 `json:"userId"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if req.UserID <= 0 {
+		respondError(w, http.StatusBadRequest, "userId is required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to begin transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	var createdBy sql.NullInt64
+	var isSystem bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT created_by, COALESCE(is_system, FALSE) FROM rooms WHERE id = $1 FOR UPDATE`,
+		roomID,
+	).Scan(&createdBy, &isSystem)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load room")
+		return
+	}
+	if isSystem {
+		respondError(w, http.StatusForbidden, "system room ownership cannot be transferred")
+		return
+	}
+	allowed := auth.Can(permManageRooms) || (createdBy.Valid && createdBy.Int64 == auth.UserID)
+	if !allowed {
+		respondError(w, http.StatusForbidden, "only room creator or admin can transfer ownership")
+		return
+	}
+	if createdBy.Valid && createdBy.Int64 == req.UserID {
+		respondErrorCode(w, http.StatusConflict, "already_owner", "user already owns the room")
+		return
+	}
+
+	var username, role string
+	err = tx.QueryRowContext(ctx, `
+SELECT u.username, u.role
+FROM room_members rm
+JOIN users u ON u.id = rm.user_id
+WHERE rm.room_id = $1 AND rm.user_id = $2
+`, roomID, req.UserID).Scan(&username, &role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondErrorCode(w, http.StatusBadRequest, "not_a_member", "new owner must be a room member")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load new owner")
+		return
+	}
+	if role == roleBot {
+		respondErrorCode(w, http.StatusBadRequest, "bot_account", "bot accounts cannot own rooms")
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE rooms SET created_by = $2 WHERE id = $1`, roomID, req.UserID); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to transfer ownership")
+		return
+	}
+	var previousOwnerID any
+	if createdBy.Valid {
+		previousOwnerID = createdBy.Int64
+	}
+	eventPayload, err := json.Marshal(map[string]any{
+		"previousOwnerId": previousOwnerID,
+		"ownerId":         req.UserID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to transfer ownership")
+		return
+	}
+	if _, err := recordEvent(ctx, tx, roomID, 0, auth.UserID, eventRoomOwnershipChanged, eventPayload); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to transfer ownership")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to transfer ownership")
+		return
+	}
+
+	if payload, err := json.Marshal(map[string]any{
+		"type":            "room_ownership_changed",
+		"roomId":          roomID,
+		"previousOwnerId": previousOwnerID,
+		"ownerId":         req.UserID,
+		"ownerUsername":   username,
+		"fromUserId":      auth.UserID,
+		"fromUsername":    auth.Username,
+	}); err == nil {
+		a.hub.Broadcast(roomID, payload)
+	}
+	requestLogger(r.Context()).Info("room_ownership_transferred",
+		"room_id", roomID,
+		"previous_owner_id", previousOwnerID,
+		"owner_id", req.UserID,
+		"actor_id", auth.UserID,
+	)
+	respondJSON(w, http.StatusOK, map[string]any{
+		"roomId":          roomID,
+		"ownerId":         req.UserID,
+		"previousOwnerId": previousOwnerID,
+	})
+}
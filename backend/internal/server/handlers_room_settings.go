@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// handleRoomSettings reads and updates room-wide settings. Any member may read
+// them; only the room creator or an admin may change them.
+func (a *App) handleRoomSettings(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusForbidden, "not a room member")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+			return
+		}
+		settings, err := scanRoomSettings(a.db.QueryRowContext(ctx, `SELECT `+roomSettingsColumns+` FROM rooms WHERE id = $1`, roomID))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "room not found")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to load room settings")
+			return
+		}
+		allowedContentTypes, err := scanAllowedContentTypes(settings.contentTypesRaw)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to load room settings")
+			return
+		}
+		encryptionPolicy, err := scanRoomEncryptionPolicy(settings.minPayloadVersion, settings.schemesRaw)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to load room settings")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{
+			"roomId":              roomID,
+			"announcementOnly":    settings.announcementOnly,
+			"allowedContentTypes": allowedContentTypes,
+			"knockEnabled":        settings.knockEnabled,
+			"visibility":          settings.visibility,
+			"encryptionPolicy":    encryptionPolicy,
+		})
+
+	case http.MethodPatch:
+		var req struct {
+			AnnouncementOnly *bool `json:"announcementOnly"`
+			// AllowedContentTypes distinguishes an absent field (unchanged)
+			// from null (every content type allowed).
+			AllowedContentTypes json.RawMessage `json:"allowedContentTypes"`
+			KnockEnabled        *bool           `json:"knockEnabled"`
+			Visibility          *string         `json:"visibility"`
+			MinPayloadVersion   *int            `json:"minPayloadVersion"`
+			// AllowedEncryptionSchemes follows the same absent/null rule as
+			// AllowedContentTypes.
+			AllowedEncryptionSchemes json.RawMessage `json:"allowedEncryptionSchemes"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		updateContentTypes := len(req.AllowedContentTypes) > 0
+		var allowedContentTypes []string
+		if updateContentTypes && string(req.AllowedContentTypes) != "null" {
+			var values []string
+			if err := json.Unmarshal(req.AllowedContentTypes, &values); err != nil {
+				respondError(w, http.StatusBadRequest, "allowedContentTypes must be an array of strings or null")
+				return
+			}
+			normalized, err := normalizeAllowedContentTypes(values)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			allowedContentTypes = normalized
+		}
+		updateSchemes := len(req.AllowedEncryptionSchemes) > 0
+		var allowedSchemes []string
+		if updateSchemes && string(req.AllowedEncryptionSchemes) != "null" {
+			var values []string
+			if err := json.Unmarshal(req.AllowedEncryptionSchemes, &values); err != nil {
+				respondError(w, http.StatusBadRequest, "allowedEncryptionSchemes must be an array of strings or null")
+				return
+			}
+			normalized, err := normalizeEncryptionSchemes(values)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			allowedSchemes = normalized
+		}
+		if req.MinPayloadVersion != nil && !validPinnedPayloadVersion(*req.MinPayloadVersion) {
+			respondError(w, http.StatusBadRequest, "minPayloadVersion must be between 3 and 16")
+			return
+		}
+		if req.Visibility != nil && !validRoomVisibility(*req.Visibility) {
+			respondError(w, http.StatusBadRequest, "visibility must be private or directory")
+			return
+		}
+		if req.AnnouncementOnly == nil && !updateContentTypes && req.KnockEnabled == nil && req.Visibility == nil &&
+			req.MinPayloadVersion == nil && !updateSchemes {
+			respondError(w, http.StatusBadRequest, "at least one room setting is required")
+			return
+		}
+
+		decision, err := a.loadMessageModerationDecision(ctx, auth.UserID, auth.Role, roomID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "room not found")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to load room")
+			return
+		}
+		if !decision.Allowed {
+			respondErrorCode(w, http.StatusForbidden, decision.Code, "only room creator or admin can change room settings")
+			return
+		}
+		if req.Visibility != nil && *req.Visibility == roomVisibilityDirectory {
+			var isSystem bool
+			if err := a.db.QueryRowContext(ctx, `SELECT COALESCE(is_system, FALSE) FROM rooms WHERE id = $1`, roomID).Scan(&isSystem); err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to load room")
+				return
+			}
+			if isSystem {
+				respondError(w, http.StatusForbidden, "system room cannot be listed in the directory")
+				return
+			}
+		}
+
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update room settings")
+			return
+		}
+		defer tx.Rollback()
+
+		previous, err := scanRoomSettings(tx.QueryRowContext(ctx, `SELECT `+roomSettingsColumns+` FROM rooms WHERE id = $1 FOR UPDATE`, roomID))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "room not found")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to update room settings")
+			return
+		}
+		settings, err := scanRoomSettings(tx.QueryRowContext(ctx, `
+UPDATE rooms
+SET announcement_only = COALESCE($2, announcement_only),
+    allowed_content_types = CASE WHEN $3 THEN $4::TEXT[] ELSE allowed_content_types END,
+    knock_enabled = COALESCE($5, knock_enabled),
+    visibility = COALESCE($6, visibility),
+    min_payload_version = COALESCE($7, min_payload_version),
+    allowed_encryption_schemes = CASE WHEN $8 THEN $9::TEXT[] ELSE allowed_encryption_schemes END
+WHERE id = $1
+RETURNING `+roomSettingsColumns,
+			roomID, req.AnnouncementOnly, updateContentTypes, allowedContentTypes, req.KnockEnabled, req.Visibility,
+			req.MinPayloadVersion, updateSchemes, allowedSchemes,
+		))
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update room settings")
+			return
+		}
+		allowedContentTypes, err = scanAllowedContentTypes(settings.contentTypesRaw)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update room settings")
+			return
+		}
+		encryptionPolicy, err := scanRoomEncryptionPolicy(settings.minPayloadVersion, settings.schemesRaw)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update room settings")
+			return
+		}
+		if settings != previous {
+			if payload, err := json.Marshal(map[string]any{
+				"type":                "room_settings_updated",
+				"roomId":              roomID,
+				"announcementOnly":    settings.announcementOnly,
+				"allowedContentTypes": allowedContentTypes,
+				"knockEnabled":        settings.knockEnabled,
+				"visibility":          settings.visibility,
+				"encryptionPolicy":    encryptionPolicy,
+				"fromUserId":          auth.UserID,
+				"fromUsername":        auth.Username,
+			}); err == nil {
+				a.hub.Broadcast(roomID, payload)
+			}
+			if !settings.announcementOnly {
+				a.hub.KickRoomGuests(roomID, websocket.ClosePolicyViolation, "room is no longer announcement-only")
+			}
+		}
+		respondJSON(w, http.StatusOK, map[string]any{
+			"roomId":              roomID,
+			"announcementOnly":    settings.announcementOnly,
+			"allowedContentTypes": allowedContentTypes,
+			"knockEnabled":        settings.knockEnabled,
+			"visibility":          settings.visibility,
+			"encryptionPolicy":    encryptionPolicy,
+		})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// roomSettings is a room's settings row as read through roomSettingsColumns.
+// It is comparable, so an update can tell whether it changed anything.
+type roomSettings struct {
+	announcementOnly  bool
+	contentTypesRaw   sql.NullString
+	knockEnabled      bool
+	visibility        string
+	minPayloadVersion int
+	schemesRaw        sql.NullString
+}
+
+const roomSettingsColumns = `announcement_only, array_to_json(allowed_content_types)::TEXT, knock_enabled, visibility,
+       min_payload_version, array_to_json(allowed_encryption_schemes)::TEXT`
+
+func scanRoomSettings(row *sql.Row) (roomSettings, error) {
+	var settings roomSettings
+	err := row.Scan(&settings.announcementOnly, &settings.contentTypesRaw, &settings.knockEnabled,
+		&settings.visibility, &settings.minPayloadVersion, &settings.schemesRaw)
+	return settings, err
+}
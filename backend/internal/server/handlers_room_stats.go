@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type roomSenderStats struct {
+	UserID       int64  `json:"userId"`
+	Username     string `json:"username"`
+	MessageCount int64  `json:"messageCount"`
+	PayloadBytes int64  `json:"payloadBytes"`
+}
+
+type roomStats struct {
+	RoomID         int64             `json:"roomId"`
+	MessageCount   int64             `json:"messageCount"`
+	PayloadBytes   int64             `json:"payloadBytes"`
+	FirstMessageAt *string           `json:"firstMessageAt"`
+	LastMessageAt  *string           `json:"lastMessageAt"`
+	Senders        []roomSenderStats `json:"senders"`
+}
+
+// loadRoomStats reads the trigger-maintained counter tables; rooms without
+// any messages have no stats row and report zeros.
+func (a *App) loadRoomStats(ctx context.Context, roomID int64) (roomStats, error) {
+	stats := roomStats{RoomID: roomID, Senders: make([]roomSenderStats, 0, 8)}
+
+	var firstAt sql.NullTime
+	var lastAt sql.NullTime
+	err := a.db.QueryRowContext(ctx, `
+SELECT message_count, payload_bytes, first_message_at, last_message_at
+FROM room_message_stats
+WHERE room_id = $1
+`, roomID).Scan(&stats.MessageCount, &stats.PayloadBytes, &firstAt, &lastAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return roomStats{}, err
+	}
+	if firstAt.Valid {
+		value := firstAt.Time.UTC().Format(time.RFC3339Nano)
+		stats.FirstMessageAt = &value
+	}
+	if lastAt.Valid {
+		value := lastAt.Time.UTC().Format(time.RFC3339Nano)
+		stats.LastMessageAt = &value
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT s.sender_id, COALESCE(u.former_username, u.username), s.message_count, s.payload_bytes
+FROM room_sender_message_stats s
+JOIN users u ON u.id = s.sender_id
+WHERE s.room_id = $1 AND s.message_count > 0
+ORDER BY s.message_count DESC, s.sender_id ASC
+`, roomID)
+	if err != nil {
+		return roomStats{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item roomSenderStats
+		if err := rows.Scan(&item.UserID, &item.Username, &item.MessageCount, &item.PayloadBytes); err != nil {
+			return roomStats{}, err
+		}
+		stats.Senders = append(stats.Senders, item)
+	}
+	return stats, rows.Err()
+}
+
+func (a *App) handleRoomStats(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var createdBy sql.NullInt64
+	if err := a.db.QueryRowContext(ctx, `SELECT created_by FROM rooms WHERE id = $1`, roomID).Scan(&createdBy); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load room")
+		return
+	}
+	allowed := auth.Can(permManageRooms) || (createdBy.Valid && createdBy.Int64 == auth.UserID)
+	if !allowed {
+		respondError(w, http.StatusForbidden, "only room creator or admin can view room stats")
+		return
+	}
+
+	stats, err := a.loadRoomStats(ctx, roomID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load room stats")
+		return
+	}
+	respondJSON(w, http.StatusOK, stats)
+}
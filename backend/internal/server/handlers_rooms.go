@@ -17,7 +17,7 @@ type roomAccessDecision struct {
 	Error   string
 }
 
-func decideDirectJoin(role string, isSystem bool) roomAccessDecision {
+func decideDirectJoin(role string, isSystem bool, visibility string) roomAccessDecision {
 	if role == "admin" {
 		return roomAccessDecision{Allowed: true}
 	}
@@ -28,6 +28,9 @@ func decideDirectJoin(role string, isSystem bool) roomAccessDecision {
 			Error:   "system room can only be joined by admin",
 		}
 	}
+	if visibility == roomVisibilityDirectory {
+		return roomAccessDecision{Allowed: true}
+	}
 	return roomAccessDecision{
 		Allowed: false,
 		Code:    "invite_required",
@@ -46,12 +49,71 @@ func decideSystemRoomAccess(role string, isSystem bool) roomAccessDecision {
 	}
 }
 
+func validRoomName(name string) bool {
+	return len(name) >= 2 && len(name) <= 64
+}
+
+func decideMessageModeration(role string, isRoomCreator bool) roomAccessDecision {
+	if role == "admin" || isRoomCreator {
+		return roomAccessDecision{Allowed: true}
+	}
+	return roomAccessDecision{
+		Allowed: false,
+		Code:    "moderator_required",
+		Error:   "only room creator or admin can moderate messages",
+	}
+}
+
+func (a *App) loadMessageModerationDecision(ctx context.Context, userID int64, role string, roomID int64) (roomAccessDecision, error) {
+	var createdBy sql.NullInt64
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT created_by FROM rooms WHERE id = $1`,
+		roomID,
+	).Scan(&createdBy); err != nil {
+		return roomAccessDecision{}, err
+	}
+	return decideMessageModeration(role, createdBy.Valid && createdBy.Int64 == userID), nil
+}
+
+// decideRoomSend gates ciphertext sends; announcement rooms accept posts only
+// from the same moderators decideMessageModeration allows.
+func decideRoomSend(role string, isRoomCreator, announcementOnly bool) roomAccessDecision {
+	if !announcementOnly || role == "admin" || isRoomCreator {
+		return roomAccessDecision{Allowed: true}
+	}
+	return roomAccessDecision{
+		Allowed: false,
+		Code:    "announcement_only",
+		Error:   "only room creator or admin can post in an announcement room",
+	}
+}
+
+// loadRoomSendDecision applies the announcement gate, then the room's
+// payload policy to a new message.
+func (a *App) loadRoomSendDecision(ctx context.Context, userID int64, role string, roomID int64, payload CipherPayload) (roomAccessDecision, error) {
+	var createdBy sql.NullInt64
+	var announcementOnly bool
+	policy, err := scanRoomPayloadPolicy(a.db.QueryRowContext(ctx,
+		`SELECT created_by, announcement_only, `+roomPayloadPolicyColumns+` FROM rooms WHERE id = $1`,
+		roomID,
+	), &createdBy, &announcementOnly)
+	if err != nil {
+		return roomAccessDecision{}, err
+	}
+	decision := decideRoomSend(role, createdBy.Valid && createdBy.Int64 == userID, announcementOnly)
+	if !decision.Allowed {
+		return decision, nil
+	}
+	return a.decideRoomPayload(policy, payload), nil
+}
+
 func isUniqueViolation(err error) bool {
 	if err == nil {
 		return false
 	}
 	value := strings.ToLower(err.Error())
-	return strings.Contains(value, "duplicate key value") || strings.Contains(value, "sqlstate 23505")
+	return strings.Contains(value, "duplicate key value") || strings.Contains(value, "sqlstate 23505") ||
+		strings.Contains(value, "unique constraint failed")
 }
 
 func (a *App) handleRooms(w http.ResponseWriter, r *http.Request, auth AuthContext) {
@@ -60,33 +122,52 @@ func (a *App) handleRooms(w http.ResponseWriter, r *http.Request, auth AuthConte
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 
-		rows, err := a.db.QueryContext(ctx, `
-SELECT r.id, r.name, r.created_at
+		rows, err := a.readQuery(ctx, `
+SELECT r.id, r.name, r.created_at, r.announcement_only, rm.notification_mode, rm.muted_until,
+       r.min_payload_version, array_to_json(r.allowed_encryption_schemes)::TEXT,
+       r.key_epoch, r.key_epoch_changed_at
 FROM rooms r
 JOIN room_members rm ON rm.room_id = r.id
 WHERE rm.user_id = $1
 ORDER BY r.id ASC
 `, auth.UserID)
 		if err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to fetch rooms"})
+			respondError(w, http.StatusInternalServerError, "failed to fetch rooms")
 			return
 		}
 		defer rows.Close()
 
 		type roomResp struct {
-			ID        int64  `json:"id"`
-			Name      string `json:"name"`
-			CreatedAt string `json:"createdAt"`
+			ID                   int64                `json:"id"`
+			Name                 string               `json:"name"`
+			CreatedAt            string               `json:"createdAt"`
+			AnnouncementOnly     bool                 `json:"announcementOnly"`
+			NotificationSettings NotificationSettings `json:"notificationSettings"`
+			EncryptionPolicy     roomEncryptionPolicy `json:"encryptionPolicy"`
+			KeyEpoch             int64                `json:"keyEpoch"`
+			KeyEpochChangedAt    string               `json:"keyEpochChangedAt"`
 		}
 		rooms := []roomResp{}
 		for rows.Next() {
 			var room roomResp
-			var createdAt time.Time
-			if err := rows.Scan(&room.ID, &room.Name, &createdAt); err != nil {
-				respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to decode rooms"})
+			var createdAt, keyEpochChangedAt time.Time
+			var pref notificationPreference
+			var minPayloadVersion int
+			var schemesRaw sql.NullString
+			if err := rows.Scan(&room.ID, &room.Name, &createdAt, &room.AnnouncementOnly, &pref.Mode, &pref.MutedUntil, &minPayloadVersion, &schemesRaw,
+				&room.KeyEpoch, &keyEpochChangedAt); err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to decode rooms")
+				return
+			}
+			policy, err := scanRoomEncryptionPolicy(minPayloadVersion, schemesRaw)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to decode rooms")
 				return
 			}
+			room.EncryptionPolicy = policy
 			room.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+			room.KeyEpochChangedAt = keyEpochChangedAt.UTC().Format(time.RFC3339Nano)
+			room.NotificationSettings = toNotificationSettings(pref)
 			rooms = append(rooms, room)
 		}
 		respondJSON(w, http.StatusOK, map[string]any{"rooms": rooms})
@@ -96,13 +177,13 @@ ORDER BY r.id ASC
 			Name string `json:"name"`
 		}
 		if err := decodeJSON(r, &req); err != nil {
-			respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid json body"})
+			respondError(w, http.StatusBadRequest, "invalid json body")
 			return
 		}
 
 		req.Name = strings.TrimSpace(req.Name)
-		if len(req.Name) < 2 || len(req.Name) > 64 {
-			respondJSON(w, http.StatusBadRequest, map[string]any{"error": "room name length must be between 2 and 64"})
+		if !validRoomName(req.Name) {
+			respondError(w, http.StatusBadRequest, "room name length must be between 2 and 64")
 			return
 		}
 
@@ -113,7 +194,7 @@ ORDER BY r.id ASC
 		defer cancel()
 		tx, err := a.db.BeginTx(ctx, nil)
 		if err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to begin transaction"})
+			respondError(w, http.StatusInternalServerError, "failed to begin transaction")
 			return
 		}
 		defer tx.Rollback()
@@ -124,13 +205,10 @@ RETURNING id, name, created_at
 `, req.Name, auth.UserID).Scan(&roomID, &roomName, &createdAt)
 		if err != nil {
 			if isUniqueViolation(err) {
-				respondJSON(w, http.StatusConflict, map[string]any{
-					"error": "room name already exists",
-					"code":  "room_name_conflict",
-				})
+				respondErrorCode(w, http.StatusConflict, "room_name_conflict", "room name already exists")
 				return
 			}
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to create room"})
+			respondError(w, http.StatusInternalServerError, "failed to create room")
 			return
 		}
 
@@ -138,14 +216,15 @@ RETURNING id, name, created_at
 			`INSERT INTO room_members(room_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
 			roomID, auth.UserID,
 		); err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to add room membership"})
+			respondError(w, http.StatusInternalServerError, "failed to add room membership")
 			return
 		}
 
 		if err := tx.Commit(); err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to commit room transaction"})
+			respondError(w, http.StatusInternalServerError, "failed to commit room transaction")
 			return
 		}
+		a.membership.Invalidate(auth.UserID, roomID)
 
 		respondJSON(w, http.StatusCreated, map[string]any{
 			"room": map[string]any{
@@ -156,29 +235,48 @@ RETURNING id, name, created_at
 		})
 
 	default:
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (a *App) handleRoomSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	if len(parts) < 3 || len(parts) > 4 || parts[0] != "api" || parts[1] != "rooms" {
-		respondJSON(w, http.StatusNotFound, map[string]any{"error": "not found"})
+	if len(parts) < 3 || len(parts) > 6 || parts[0] != "api" || parts[1] != "rooms" {
+		respondError(w, http.StatusNotFound, "not found")
 		return
 	}
 
 	roomID, err := strconv.ParseInt(parts[2], 10, 64)
 	if err != nil || roomID <= 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid room id"})
+		respondError(w, http.StatusBadRequest, "invalid room id")
 		return
 	}
 
 	if len(parts) == 3 {
+		if r.Method == http.MethodPatch {
+			a.handleRenameRoom(w, r, auth, roomID)
+			return
+		}
 		a.handleDeleteRoom(w, r, auth, roomID)
 		return
 	}
 
 	action := parts[3]
+	if len(parts) > 4 {
+		switch action {
+		case "messages":
+			a.handleRoomMessageSubroutes(w, r, auth, roomID, parts[4:])
+		case "webhooks":
+			a.handleRoomWebhookSubroutes(w, r, auth, roomID, parts[4:])
+		case "polls":
+			a.handleRoomPoll(w, r, auth, roomID, parts[4:])
+		case "join-requests":
+			a.handleRoomJoinRequestDecision(w, r, auth, roomID, parts[4:])
+		default:
+			respondError(w, http.StatusNotFound, "not found")
+		}
+		return
+	}
 	switch action {
 	case "join":
 		a.handleJoinRoom(w, r, auth, roomID)
@@ -188,14 +286,36 @@ func (a *App) handleRoomSubroutes(w http.ResponseWriter, r *http.Request, auth A
 		a.handleRoomMembers(w, r, auth, roomID)
 	case "invite":
 		a.handleRoomInvite(w, r, auth, roomID)
+	case "notification-settings":
+		a.handleRoomNotificationSettings(w, r, auth, roomID)
+	case "settings":
+		a.handleRoomSettings(w, r, auth, roomID)
+	case "stats":
+		a.handleRoomStats(w, r, auth, roomID)
+	case "sender-keys":
+		a.handleRoomSenderKeys(w, r, auth, roomID)
+	case "decrypt-recovery":
+		a.handleRoomDecryptRecovery(w, r, auth, roomID)
+	case "history-backfill":
+		a.handleRoomHistoryBackfill(w, r, auth, roomID)
+	case "transfer-ownership":
+		a.handleRoomTransferOwnership(w, r, auth, roomID)
+	case "guest-links":
+		a.handleRoomGuestLinks(w, r, auth, roomID)
+	case "knock":
+		a.handleRoomKnock(w, r, auth, roomID)
+	case "join-requests":
+		a.handleRoomJoinRequests(w, r, auth, roomID)
+	case "webhooks":
+		a.handleRoomWebhooks(w, r, auth, roomID)
 	default:
-		respondJSON(w, http.StatusNotFound, map[string]any{"error": "not found"})
+		respondError(w, http.StatusNotFound, "not found")
 	}
 }
 
 func (a *App) handleDeleteRoom(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
 	if r.Method != http.MethodDelete {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
@@ -210,72 +330,208 @@ func (a *App) handleDeleteRoom(w http.ResponseWriter, r *http.Request, auth Auth
 	).Scan(&createdBy, &isSystem)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "room not found"})
+			respondError(w, http.StatusNotFound, "room not found")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load room"})
+		respondError(w, http.StatusInternalServerError, "failed to load room")
 		return
 	}
 	if isSystem {
-		respondJSON(w, http.StatusForbidden, map[string]any{"error": "system room cannot be deleted"})
+		respondError(w, http.StatusForbidden, "system room cannot be deleted")
 		return
 	}
 
-	allowed := auth.Role == "admin" || (createdBy.Valid && createdBy.Int64 == auth.UserID)
+	allowed := auth.Can(permManageRooms) || (createdBy.Valid && createdBy.Int64 == auth.UserID)
 	if !allowed {
-		respondJSON(w, http.StatusForbidden, map[string]any{"error": "only room creator or admin can delete room"})
+		respondError(w, http.StatusForbidden, "only room creator or admin can delete room")
+		return
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to delete room")
+		return
+	}
+	defer tx.Rollback()
+
+	held, err := roomDeletionHeld(ctx, tx, roomID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to delete room")
+		return
+	}
+	if held {
+		respondErrorCode(w, http.StatusConflict, errCodeLegalHold, "room is under legal hold")
 		return
 	}
 
+	if err := releaseMessageQuotaTx(ctx, tx, `m.room_id = $1`, roomID); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to delete room")
+		return
+	}
 	var deletedID int64
-	err = a.db.QueryRowContext(ctx,
+	err = tx.QueryRowContext(ctx,
 		`DELETE FROM rooms WHERE id = $1 RETURNING id`,
 		roomID,
 	).Scan(&deletedID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "room not found"})
+			respondError(w, http.StatusNotFound, "room not found")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to delete room"})
+		respondError(w, http.StatusInternalServerError, "failed to delete room")
+		return
+	}
+	if err := enqueueWebhookEvent(ctx, tx, deletedID, webhookEventRoomDeleted, map[string]any{
+		"deletedBy": auth.UserID,
+	}); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to delete room")
+		return
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE room_webhooks SET disabled_at = NOW() WHERE room_id = $1 AND disabled_at IS NULL`,
+		deletedID,
+	); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to delete room")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to delete room")
 		return
 	}
 
+	a.membership.InvalidateRoom(deletedID)
+	a.webhooks.Notify()
+
 	respondJSON(w, http.StatusOK, map[string]any{"deleted": true, "roomId": deletedID})
 }
 
+func (a *App) handleRenameRoom(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodPatch {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if !validRoomName(req.Name) {
+		respondError(w, http.StatusBadRequest, "room name length must be between 2 and 64")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var createdBy sql.NullInt64
+	var isSystem bool
+	var previousName string
+	err := a.db.QueryRowContext(ctx,
+		`SELECT created_by, COALESCE(is_system, FALSE), name FROM rooms WHERE id = $1`,
+		roomID,
+	).Scan(&createdBy, &isSystem, &previousName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load room")
+		return
+	}
+	if isSystem {
+		respondError(w, http.StatusForbidden, "system room cannot be renamed")
+		return
+	}
+
+	allowed := auth.Can(permManageRooms) || (createdBy.Valid && createdBy.Int64 == auth.UserID)
+	if !allowed {
+		respondError(w, http.StatusForbidden, "only room creator or admin can rename room")
+		return
+	}
+
+	var roomName string
+	var createdAt time.Time
+	err = a.db.QueryRowContext(ctx,
+		`UPDATE rooms SET name = $2 WHERE id = $1 RETURNING name, created_at`,
+		roomID, req.Name,
+	).Scan(&roomName, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		if isUniqueViolation(err) {
+			respondErrorCode(w, http.StatusConflict, "room_name_conflict", "room name already exists")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to rename room")
+		return
+	}
+
+	if roomName != previousName {
+		eventPayload, _ := json.Marshal(map[string]any{"name": roomName, "previousName": previousName})
+		if _, err := recordEvent(ctx, a.db, roomID, 0, auth.UserID, eventRoomRenamed, eventPayload); err != nil {
+			requestLogger(r.Context()).Warn("room_renamed_event_failed", "room_id", roomID, "error", err)
+		}
+		if payload, err := json.Marshal(map[string]any{
+			"type":         "room_renamed",
+			"roomId":       roomID,
+			"name":         roomName,
+			"previousName": previousName,
+			"fromUserId":   auth.UserID,
+			"fromUsername": auth.Username,
+		}); err == nil {
+			a.hub.Broadcast(roomID, payload)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"room": map[string]any{
+			"id":        roomID,
+			"name":      roomName,
+			"createdAt": createdAt.UTC().Format(time.RFC3339Nano),
+		},
+	})
+}
+
 func (a *App) handleJoinRoom(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
 	if r.Method != http.MethodPost {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 	var isSystem bool
-	if err := a.db.QueryRowContext(ctx, `SELECT COALESCE(is_system, FALSE) FROM rooms WHERE id = $1`, roomID).Scan(&isSystem); err != nil {
+	var visibility string
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT COALESCE(is_system, FALSE), visibility FROM rooms WHERE id = $1`,
+		roomID,
+	).Scan(&isSystem, &visibility); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "room not found"})
+			respondError(w, http.StatusNotFound, "room not found")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load room"})
+		respondError(w, http.StatusInternalServerError, "failed to load room")
 		return
 	}
 
-	decision := decideDirectJoin(auth.Role, isSystem)
+	decision := decideDirectJoin(auth.Role, isSystem, visibility)
 	if !decision.Allowed {
-		respondJSON(w, http.StatusForbidden, map[string]any{
-			"error": decision.Error,
-			"code":  decision.Code,
-		})
+		respondErrorCode(w, http.StatusForbidden, decision.Code, decision.Error)
 		return
 	}
 
-	if _, err := a.db.ExecContext(ctx,
-		`INSERT INTO room_members(room_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
-		roomID, auth.UserID,
-	); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to join room"})
+	if err := a.addRoomMember(ctx, roomID, auth.UserID); err != nil {
+		if errors.Is(err, errRoomFull) {
+			respondErrorCode(w, http.StatusConflict, "room_full", "room has reached its member limit")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to join room")
 		return
 	}
 
@@ -284,7 +540,7 @@ func (a *App) handleJoinRoom(w http.ResponseWriter, r *http.Request, auth AuthCo
 
 func (a *App) handleRoomInvite(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
 	if r.Method != http.MethodPost {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
@@ -293,33 +549,30 @@ func (a *App) handleRoomInvite(w http.ResponseWriter, r *http.Request, auth Auth
 
 	if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusForbidden, map[string]any{"error": "not a room member"})
+			respondError(w, http.StatusForbidden, "not a room member")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to validate room membership"})
+		respondError(w, http.StatusInternalServerError, "failed to validate room membership")
 		return
 	}
 	var isSystem bool
 	if err := a.db.QueryRowContext(ctx, `SELECT COALESCE(is_system, FALSE) FROM rooms WHERE id = $1`, roomID).Scan(&isSystem); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "room not found"})
+			respondError(w, http.StatusNotFound, "room not found")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load room"})
+		respondError(w, http.StatusInternalServerError, "failed to load room")
 		return
 	}
 	decision := decideSystemRoomAccess(auth.Role, isSystem)
 	if !decision.Allowed {
-		respondJSON(w, http.StatusForbidden, map[string]any{
-			"error": decision.Error,
-			"code":  decision.Code,
-		})
+		respondErrorCode(w, http.StatusForbidden, decision.Code, decision.Error)
 		return
 	}
 
 	inviteToken, expiresAt, err := a.issueInviteToken(roomID, auth.UserID)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to issue invite token"})
+		respondError(w, http.StatusInternalServerError, "failed to issue invite token")
 		return
 	}
 
@@ -332,7 +585,7 @@ func (a *App) handleRoomInvite(w http.ResponseWriter, r *http.Request, auth Auth
 
 func (a *App) handleInviteJoin(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	if r.Method != http.MethodPost {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
@@ -340,19 +593,19 @@ func (a *App) handleInviteJoin(w http.ResponseWriter, r *http.Request, auth Auth
 		InviteToken string `json:"inviteToken"`
 	}
 	if err := decodeJSON(r, &req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid json body"})
+		respondError(w, http.StatusBadRequest, "invalid json body")
 		return
 	}
 
 	req.InviteToken = strings.TrimSpace(req.InviteToken)
 	if req.InviteToken == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invite token is required"})
+		respondError(w, http.StatusBadRequest, "invite token is required")
 		return
 	}
 
 	claims, err := a.parseInviteToken(req.InviteToken)
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid or expired invite token"})
+		respondError(w, http.StatusBadRequest, "invalid or expired invite token")
 		return
 	}
 
@@ -369,26 +622,24 @@ func (a *App) handleInviteJoin(w http.ResponseWriter, r *http.Request, auth Auth
 	).Scan(&roomID, &roomName, &createdAt, &isSystem)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "room not found"})
+			respondError(w, http.StatusNotFound, "room not found")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load room"})
+		respondError(w, http.StatusInternalServerError, "failed to load room")
 		return
 	}
 	decision := decideSystemRoomAccess(auth.Role, isSystem)
 	if !decision.Allowed {
-		respondJSON(w, http.StatusForbidden, map[string]any{
-			"error": decision.Error,
-			"code":  decision.Code,
-		})
+		respondErrorCode(w, http.StatusForbidden, decision.Code, decision.Error)
 		return
 	}
 
-	if _, err := a.db.ExecContext(ctx,
-		`INSERT INTO room_members(room_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
-		roomID, auth.UserID,
-	); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to join room by invite"})
+	if err := a.addRoomMember(ctx, roomID, auth.UserID); err != nil {
+		if errors.Is(err, errRoomFull) {
+			respondErrorCode(w, http.StatusConflict, "room_full", "room has reached its member limit")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to join room by invite")
 		return
 	}
 
@@ -404,127 +655,289 @@ func (a *App) handleInviteJoin(w http.ResponseWriter, r *http.Request, auth Auth
 
 func (a *App) handleRoomMessages(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
 	if r.Method != http.MethodGet {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
-		return
-	}
-
-	ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
-	defer cancel()
-
-	if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusForbidden, map[string]any{"error": "not a room member"})
-			return
-		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to validate room membership"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
+	query := r.URL.Query()
 	limit := int64(50)
-	if value := strings.TrimSpace(r.URL.Query().Get("limit")); value != "" {
+	if value := strings.TrimSpace(query.Get("limit")); value != "" {
 		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed > 0 && parsed <= 200 {
 			limit = parsed
 		}
 	}
 	beforeID := int64(0)
-	if value := strings.TrimSpace(r.URL.Query().Get("beforeId")); value != "" {
+	if value := strings.TrimSpace(query.Get("beforeId")); value != "" {
 		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed > 0 {
 			beforeID = parsed
 		}
 	}
 	afterID := int64(0)
-	if value := strings.TrimSpace(r.URL.Query().Get("afterId")); value != "" {
+	if value := strings.TrimSpace(query.Get("afterId")); value != "" {
 		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed > 0 {
 			afterID = parsed
 		}
 	}
+	afterSeq := int64(0)
+	if value := strings.TrimSpace(query.Get("afterSeq")); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			respondError(w, http.StatusBadRequest, "invalid afterSeq")
+			return
+		}
+		afterSeq = parsed
+	}
+	aroundID := int64(0)
+	if value := strings.TrimSpace(query.Get("aroundId")); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid aroundId")
+			return
+		}
+		aroundID = parsed
+	}
+	var before, after time.Time
+	if value := strings.TrimSpace(query.Get("before")); value != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "before must be an RFC3339 timestamp")
+			return
+		}
+		before = parsed
+	}
+	if value := strings.TrimSpace(query.Get("after")); value != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "after must be an RFC3339 timestamp")
+			return
+		}
+		after = parsed
+	}
+	includeSystem := query.Get("includeSystem") == "true"
+	hasAfterSeq := query.Has("afterSeq")
+	if countPaginationModes(beforeID > 0, afterID > 0, aroundID > 0, !before.IsZero(), !after.IsZero(), hasAfterSeq) > 1 {
+		respondError(w, http.StatusBadRequest, "only one of beforeId, afterId, aroundId, before, after, afterSeq may be set")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+	defer cancel()
+
+	if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "not a room member")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+		return
+	}
 
-	var rows *sql.Rows
+	if aroundID > 0 {
+		a.respondMessagesAround(ctx, w, roomID, aroundID, limit, includeSystem)
+		return
+	}
+
+	var messages []StoredMessage
 	var err error
 	orderedAsc := false
-	if afterID > 0 {
+	switch {
+	case hasAfterSeq:
+		// Gap repair: a client that saw seq jump asks for what it missed.
+		orderedAsc = true
+		messages, err = a.listRoomMessages(ctx,
+			`AND m.room_seq > $2 ORDER BY m.room_seq ASC LIMIT $3`,
+			roomID, afterSeq, limit+1)
+	case afterID > 0:
 		orderedAsc = true
-		rows, err = a.db.QueryContext(ctx, `
-SELECT m.id, m.room_id, m.sender_id, u.username, m.payload, m.created_at, m.edited_at, m.revoked_at
-	FROM messages m
-	JOIN users u ON u.id = m.sender_id
-	WHERE m.room_id = $1
-	  AND m.id > $2
-	ORDER BY m.id ASC
-	LIMIT $3
-	`, roomID, afterID, limit+1)
-	} else {
-		rows, err = a.db.QueryContext(ctx, `
-SELECT m.id, m.room_id, m.sender_id, u.username, m.payload, m.created_at, m.edited_at, m.revoked_at
-	FROM messages m
-	JOIN users u ON u.id = m.sender_id
-	WHERE m.room_id = $1
-	  AND ($2::BIGINT <= 0 OR m.id < $2)
-	ORDER BY m.id DESC
-	LIMIT $3
-	`, roomID, beforeID, limit+1)
+		messages, err = a.listRoomMessages(ctx,
+			`AND m.id > $2 ORDER BY m.id ASC LIMIT $3`,
+			roomID, afterID, limit+1)
+	case !after.IsZero():
+		orderedAsc = true
+		messages, err = a.listRoomMessages(ctx,
+			`AND m.created_at > $2 ORDER BY m.created_at ASC, m.id ASC LIMIT $3`,
+			roomID, after, limit+1)
+	case !before.IsZero():
+		messages, err = a.listRoomMessages(ctx,
+			`AND m.created_at < $2 ORDER BY m.created_at DESC, m.id DESC LIMIT $3`,
+			roomID, before, limit+1)
+	default:
+		messages, err = a.listRoomMessages(ctx,
+			`AND ($2::BIGINT <= 0 OR m.id < $2) ORDER BY m.id DESC LIMIT $3`,
+			roomID, beforeID, limit+1)
 	}
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to fetch messages"})
+		respondError(w, http.StatusInternalServerError, "failed to fetch messages")
 		return
 	}
-	defer rows.Close()
 
-	messages := make([]StoredMessage, 0, limit+1)
-	for rows.Next() {
-		var message StoredMessage
-		var payloadRaw []byte
-		var createdAt time.Time
-		var editedAt sql.NullTime
-		var revokedAt sql.NullTime
-		if err := rows.Scan(
-			&message.ID,
-			&message.RoomID,
-			&message.SenderID,
-			&message.SenderUsername,
-			&payloadRaw,
-			&createdAt,
-			&editedAt,
-			&revokedAt,
-		); err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to decode message"})
+	hasMore := len(messages) > int(limit)
+	if hasMore {
+		messages = messages[:int(limit)]
+	}
+
+	if !orderedAsc {
+		reverseStoredMessages(messages)
+	}
+
+	if includeSystem {
+		history, err := a.withSystemEvents(ctx, roomID, messages)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to fetch system events")
 			return
 		}
-		if err := json.Unmarshal(payloadRaw, &message.Payload); err != nil {
-			continue
-		}
-		message.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
-		if editedAt.Valid {
-			value := editedAt.Time.UTC().Format(time.RFC3339Nano)
-			message.EditedAt = &value
+		respondJSON(w, http.StatusOK, map[string]any{
+			"messages": history,
+			"hasMore":  hasMore,
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"messages": messages,
+		"hasMore":  hasMore,
+	})
+}
+
+func countPaginationModes(modes ...bool) int {
+	count := 0
+	for _, set := range modes {
+		if set {
+			count++
 		}
-		if revokedAt.Valid {
-			value := revokedAt.Time.UTC().Format(time.RFC3339Nano)
-			message.RevokedAt = &value
+	}
+	return count
+}
+
+func reverseStoredMessages(messages []StoredMessage) {
+	for left, right := 0, len(messages)-1; left < right; left, right = left+1, right-1 {
+		messages[left], messages[right] = messages[right], messages[left]
+	}
+}
+
+// respondMessagesAround returns up to limit messages on each side of the
+// anchor, with the anchor itself included, for deep links into history.
+func (a *App) respondMessagesAround(ctx context.Context, w http.ResponseWriter, roomID, aroundID, limit int64, includeSystem bool) {
+	newer, err := a.listRoomMessages(ctx,
+		`AND m.id >= $2 ORDER BY m.id ASC LIMIT $3`,
+		roomID, aroundID, limit+2)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to fetch messages")
+		return
+	}
+	if len(newer) == 0 || newer[0].ID != aroundID {
+		respondError(w, http.StatusNotFound, "message not found")
+		return
+	}
+	older, err := a.listRoomMessages(ctx,
+		`AND m.id < $2 ORDER BY m.id DESC LIMIT $3`,
+		roomID, aroundID, limit+1)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to fetch messages")
+		return
+	}
+
+	hasMoreBefore := len(older) > int(limit)
+	if hasMoreBefore {
+		older = older[:int(limit)]
+	}
+	hasMoreAfter := len(newer) > int(limit)+1
+	if hasMoreAfter {
+		newer = newer[:int(limit)+1]
+	}
+	reverseStoredMessages(older)
+
+	var history any = append(older, newer...)
+	if includeSystem {
+		history, err = a.withSystemEvents(ctx, roomID, append(older, newer...))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to fetch system events")
+			return
 		}
-		messages = append(messages, message)
 	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"messages":      history,
+		"hasMore":       hasMoreBefore,
+		"hasMoreBefore": hasMoreBefore,
+		"hasMoreAfter":  hasMoreAfter,
+		"aroundId":      aroundID,
+	})
+}
 
-	hasMore := len(messages) > int(limit)
-	if hasMore {
-		messages = messages[:int(limit)]
+func (a *App) handleRoomMessageSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64, parts []string) {
+	messageID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || messageID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid message id")
+		return
+	}
+	if len(parts) != 2 {
+		respondError(w, http.StatusNotFound, "not found")
+		return
 	}
 
-	if !orderedAsc {
-		for left, right := 0, len(messages)-1; left < right; left, right = left+1, right-1 {
-			messages[left], messages[right] = messages[right], messages[left]
+	switch parts[1] {
+	case "revisions":
+		a.handleMessageRevisions(w, r, auth, roomID, messageID)
+	case "report":
+		a.handleMessageReport(w, r, auth, roomID, messageID)
+	case "forward":
+		a.handleMessageForward(w, r, auth, roomID, messageID)
+	default:
+		respondError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (a *App) handleMessageRevisions(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID, messageID int64) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "not a room member")
+			return
 		}
+		respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+		return
+	}
+
+	var revokedAt sql.NullTime
+	err := a.db.QueryRowContext(ctx,
+		`SELECT revoked_at FROM messages WHERE id = $1 AND room_id = $2`,
+		messageID, roomID,
+	).Scan(&revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "message not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load message")
+		return
+	}
+	if revokedAt.Valid {
+		respondError(w, http.StatusGone, "message has been revoked")
+		return
+	}
+
+	revisions, err := a.listMessageRevisions(ctx, roomID, messageID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load message revisions")
+		return
 	}
 
 	respondJSON(w, http.StatusOK, map[string]any{
-		"messages": messages,
-		"hasMore":  hasMore,
+		"roomId":    roomID,
+		"messageId": messageID,
+		"revisions": revisions,
 	})
 }
 
 func (a *App) handleRoomMembers(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
 	if r.Method != http.MethodGet {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
@@ -533,29 +946,33 @@ func (a *App) handleRoomMembers(w http.ResponseWriter, r *http.Request, auth Aut
 
 	if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusForbidden, map[string]any{"error": "not a room member"})
+			respondError(w, http.StatusForbidden, "not a room member")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to validate room membership"})
+		respondError(w, http.StatusInternalServerError, "failed to validate room membership")
 		return
 	}
 
 	type roomMember struct {
 		ID                int64  `json:"id"`
 		Username          string `json:"username"`
+		DisplayName       string `json:"displayName,omitempty"`
 		Role              string `json:"role"`
 		CreatedAt         string `json:"createdAt"`
 		LastReadMessageID int64  `json:"lastReadMessageId"`
+		// LastSeenAt is omitted for members who hide it, except to themselves.
+		LastSeenAt *string `json:"lastSeenAt,omitempty"`
 	}
-	rows, err := a.db.QueryContext(ctx, `
-SELECT u.id, u.username, u.role, u.created_at, rm.last_read_message_id
+	rows, err := a.readQuery(ctx, `
+SELECT u.id, u.username, COALESCE(u.display_name, ''), u.role, u.created_at, rm.last_read_message_id,
+       CASE WHEN u.hide_last_seen AND u.id <> $2 THEN NULL ELSE u.last_seen_at END
 FROM room_members rm
 JOIN users u ON u.id = rm.user_id
 WHERE rm.room_id = $1
 ORDER BY u.id ASC
-`, roomID)
+`, roomID, auth.UserID)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to list room members"})
+		respondError(w, http.StatusInternalServerError, "failed to list room members")
 		return
 	}
 	defer rows.Close()
@@ -564,11 +981,16 @@ ORDER BY u.id ASC
 	for rows.Next() {
 		var item roomMember
 		var createdAt time.Time
-		if err := rows.Scan(&item.ID, &item.Username, &item.Role, &createdAt, &item.LastReadMessageID); err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to decode room members"})
+		var lastSeenAt sql.NullTime
+		if err := rows.Scan(&item.ID, &item.Username, &item.DisplayName, &item.Role, &createdAt, &item.LastReadMessageID, &lastSeenAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode room members")
 			return
 		}
 		item.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		if lastSeenAt.Valid {
+			value := lastSeenAt.Time.UTC().Format(time.RFC3339Nano)
+			item.LastSeenAt = &value
+		}
 		members = append(members, item)
 	}
 
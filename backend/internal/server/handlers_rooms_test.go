@@ -3,28 +3,32 @@ package server
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
 func TestDecideDirectJoin(t *testing.T) {
 	t.Parallel()
 
-	if decision := decideDirectJoin("admin", true); !decision.Allowed {
+	if decision := decideDirectJoin("admin", true, roomVisibilityPrivate); !decision.Allowed {
 		t.Fatalf("expected admin to join system room")
 	}
-	if decision := decideDirectJoin("admin", false); !decision.Allowed {
+	if decision := decideDirectJoin("admin", false, roomVisibilityPrivate); !decision.Allowed {
 		t.Fatalf("expected admin to join normal room")
 	}
 
-	userSystem := decideDirectJoin("user", true)
+	userSystem := decideDirectJoin("user", true, roomVisibilityDirectory)
 	if userSystem.Allowed || userSystem.Code != "system_room_admin_only" {
 		t.Fatalf("unexpected decision for user/system room: %#v", userSystem)
 	}
 
-	userNormal := decideDirectJoin("user", false)
+	userNormal := decideDirectJoin("user", false, roomVisibilityPrivate)
 	if userNormal.Allowed || userNormal.Code != "invite_required" {
 		t.Fatalf("unexpected decision for user/normal room: %#v", userNormal)
 	}
+	if decision := decideDirectJoin("user", false, roomVisibilityDirectory); !decision.Allowed {
+		t.Fatalf("expected user to join directory room directly")
+	}
 }
 
 func TestDecideSystemRoomAccess(t *testing.T) {
@@ -42,6 +46,70 @@ func TestDecideSystemRoomAccess(t *testing.T) {
 	}
 }
 
+func TestDecideMessageModeration(t *testing.T) {
+	t.Parallel()
+
+	if decision := decideMessageModeration("admin", false); !decision.Allowed {
+		t.Fatalf("expected admin to moderate any room")
+	}
+	if decision := decideMessageModeration("user", true); !decision.Allowed {
+		t.Fatalf("expected room creator to moderate own room")
+	}
+	member := decideMessageModeration("user", false)
+	if member.Allowed || member.Code != "moderator_required" {
+		t.Fatalf("unexpected decision for plain member: %#v", member)
+	}
+}
+
+func TestDecideRoomSend(t *testing.T) {
+	t.Parallel()
+
+	if decision := decideRoomSend("user", false, false); !decision.Allowed {
+		t.Fatalf("expected members to post in a regular room")
+	}
+	if decision := decideRoomSend("admin", false, true); !decision.Allowed {
+		t.Fatalf("expected admin to post in an announcement room")
+	}
+	if decision := decideRoomSend("user", true, true); !decision.Allowed {
+		t.Fatalf("expected room creator to post in own announcement room")
+	}
+	member := decideRoomSend("user", false, true)
+	if member.Allowed || member.Code != "announcement_only" {
+		t.Fatalf("unexpected decision for plain member: %#v", member)
+	}
+}
+
+func TestDecideRoomContentType(t *testing.T) {
+	t.Parallel()
+
+	if decision := decideRoomContentType(nil, "application/octet-stream"); !decision.Allowed {
+		t.Fatalf("expected an unrestricted room to accept any content type")
+	}
+	allowed, err := normalizeAllowedContentTypes([]string{" Text/Plain ", "image/*", "text/plain"})
+	if err != nil {
+		t.Fatalf("normalize allowed content types: %v", err)
+	}
+	if len(allowed) != 2 || allowed[0] != "image/*" || allowed[1] != "text/plain" {
+		t.Fatalf("unexpected normalized list: %#v", allowed)
+	}
+	for _, contentType := range []string{"text/plain", "image/png", "IMAGE/JPEG"} {
+		if decision := decideRoomContentType(allowed, contentType); !decision.Allowed {
+			t.Fatalf("expected %q to be allowed", contentType)
+		}
+	}
+	for _, contentType := range []string{"", "file/pdf", "imagex/png", "text/html"} {
+		decision := decideRoomContentType(allowed, contentType)
+		if decision.Allowed || decision.Code != "content_type_not_allowed" {
+			t.Fatalf("expected %q to be rejected, got %#v", contentType, decision)
+		}
+	}
+	for _, invalid := range [][]string{{}, {"*/*"}, {"text/<script>"}, {"/*"}} {
+		if _, err := normalizeAllowedContentTypes(invalid); err == nil {
+			t.Fatalf("expected %#v to be rejected", invalid)
+		}
+	}
+}
+
 func TestHandleRoomSubroutesGuards(t *testing.T) {
 	t.Parallel()
 
@@ -70,6 +138,39 @@ func TestHandleRoomSubroutesGuards(t *testing.T) {
 		}
 	})
 
+	t.Run("invalid message id", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/messages/abc/revisions", nil)
+		response := httptest.NewRecorder()
+
+		app.handleRoomSubroutes(response, request, auth)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("unknown message action", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/messages/5/unknown", nil)
+		response := httptest.NewRecorder()
+
+		app.handleRoomSubroutes(response, request, auth)
+
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("expected %d, got %d", http.StatusNotFound, response.Code)
+		}
+	})
+
+	t.Run("nested path on non-message action", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/members/5/revisions", nil)
+		response := httptest.NewRecorder()
+
+		app.handleRoomSubroutes(response, request, auth)
+
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("expected %d, got %d", http.StatusNotFound, response.Code)
+		}
+	})
+
 	t.Run("unknown action", func(t *testing.T) {
 		request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/unknown", nil)
 		response := httptest.NewRecorder()
@@ -99,6 +200,50 @@ func TestHandleRoomMethodsWithoutDB(t *testing.T) {
 		}
 	})
 
+	t.Run("transfer ownership wrong method", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/transfer-ownership", nil)
+		response := httptest.NewRecorder()
+
+		app.handleRoomSubroutes(response, request, auth)
+
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, response.Code)
+		}
+	})
+
+	t.Run("transfer ownership missing user", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/rooms/1/transfer-ownership", strings.NewReader(`{}`))
+		response := httptest.NewRecorder()
+
+		app.handleRoomTransferOwnership(response, request, auth, 1)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("rename room wrong method", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/rooms/1", nil)
+		response := httptest.NewRecorder()
+
+		app.handleRenameRoom(response, request, auth, 1)
+
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, response.Code)
+		}
+	})
+
+	t.Run("rename room invalid name", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPatch, "/api/rooms/1", strings.NewReader(`{"name":"x"}`))
+		response := httptest.NewRecorder()
+
+		app.handleRenameRoom(response, request, auth, 1)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
 	t.Run("join room wrong method", func(t *testing.T) {
 		request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/join", nil)
 		response := httptest.NewRecorder()
@@ -121,6 +266,72 @@ func TestHandleRoomMethodsWithoutDB(t *testing.T) {
 		}
 	})
 
+	t.Run("messages invalid timestamp", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/messages?before=yesterday", nil)
+		response := httptest.NewRecorder()
+
+		app.handleRoomMessages(response, request, auth, 1)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("messages conflicting pagination modes", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/messages?aroundId=5&after=2024-01-01T00:00:00Z", nil)
+		response := httptest.NewRecorder()
+
+		app.handleRoomMessages(response, request, auth, 1)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("messages afterSeq with another mode", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/messages?afterSeq=0&beforeId=9", nil)
+		response := httptest.NewRecorder()
+
+		app.handleRoomMessages(response, request, auth, 1)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("messages invalid afterSeq", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/rooms/1/messages?afterSeq=-3", nil)
+		response := httptest.NewRecorder()
+
+		app.handleRoomMessages(response, request, auth, 1)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("stats wrong method", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/rooms/1/stats", nil)
+		response := httptest.NewRecorder()
+
+		app.handleRoomStats(response, request, auth, 1)
+
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, response.Code)
+		}
+	})
+
+	t.Run("revisions wrong method", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/rooms/1/messages/5/revisions", nil)
+		response := httptest.NewRecorder()
+
+		app.handleMessageRevisions(response, request, auth, 1, 5)
+
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, response.Code)
+		}
+	})
+
 	t.Run("members wrong method", func(t *testing.T) {
 		request := httptest.NewRequest(http.MethodPost, "/api/rooms/1/members", nil)
 		response := httptest.NewRecorder()
@@ -15,7 +15,12 @@ import (
 	"time"
 )
 
-const maxOneTimePreKeysPerUpload = 512
+const (
+	maxOneTimePreKeysPerUpload = 512
+	maxPreKeyBundleBatchUsers  = 100
+)
+
+var errPreKeyBundleNotPublished = errors.New("prekey bundle is not published")
 
 func canonicalRawJSON(raw json.RawMessage) (json.RawMessage, error) {
 	if len(raw) == 0 || !json.Valid(raw) {
@@ -115,6 +120,21 @@ LIMIT 1
 `, leftUserID, rightUserID).Scan(&found)
 }
 
+// rejectBotTarget reports whether key exchange with the target may proceed.
+// Bots post pre-encrypted payloads and never publish identity material.
+func (a *App) rejectBotTarget(ctx context.Context, w http.ResponseWriter, targetUserID int64) bool {
+	isBot, err := a.isBotAccount(ctx, targetUserID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load target user")
+		return false
+	}
+	if isBot {
+		respondErrorCode(w, http.StatusNotFound, "bot_account", "bot accounts do not take part in key exchange")
+		return false
+	}
+	return true
+}
+
 func (a *App) handleSignalPreKeyBundle(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	switch r.Method {
 	case http.MethodPut:
@@ -122,23 +142,23 @@ func (a *App) handleSignalPreKeyBundle(w http.ResponseWriter, r *http.Request, a
 	case http.MethodGet:
 		a.handleSignalPreKeyBundleSelf(w, r, auth)
 	default:
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (a *App) handleSignalPreKeyBundleSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 	if len(parts) != 4 || parts[0] != "api" || parts[1] != "signal" || parts[2] != "prekey-bundle" {
-		respondJSON(w, http.StatusNotFound, map[string]any{"error": "not found"})
+		respondError(w, http.StatusNotFound, "not found")
 		return
 	}
 	if r.Method != http.MethodGet {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	targetUserID, err := strconv.ParseInt(parts[3], 10, 64)
 	if err != nil || targetUserID <= 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid user id"})
+		respondError(w, http.StatusBadRequest, "invalid user id")
 		return
 	}
 	a.handleSignalPreKeyBundleFetch(w, r, auth, targetUserID)
@@ -147,16 +167,16 @@ func (a *App) handleSignalPreKeyBundleSubroutes(w http.ResponseWriter, r *http.R
 func (a *App) handleSignalSafetyNumberSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 	if len(parts) != 4 || parts[0] != "api" || parts[1] != "signal" || parts[2] != "safety-number" {
-		respondJSON(w, http.StatusNotFound, map[string]any{"error": "not found"})
+		respondError(w, http.StatusNotFound, "not found")
 		return
 	}
 	if r.Method != http.MethodGet {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	targetUserID, err := strconv.ParseInt(parts[3], 10, 64)
 	if err != nil || targetUserID <= 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid user id"})
+		respondError(w, http.StatusBadRequest, "invalid user id")
 		return
 	}
 	a.handleSignalSafetyNumber(w, r, auth, targetUserID)
@@ -165,42 +185,42 @@ func (a *App) handleSignalSafetyNumberSubroutes(w http.ResponseWriter, r *http.R
 func (a *App) handleSignalPreKeyBundleUpsert(w http.ResponseWriter, r *http.Request, auth AuthContext) {
 	var req SignalPreKeyBundleUpload
 	if err := decodeJSON(r, &req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid json body"})
+		respondError(w, http.StatusBadRequest, "invalid json body")
 		return
 	}
 	if len(req.IdentityKeyJWK) == 0 || !json.Valid(req.IdentityKeyJWK) {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "identity key is required"})
+		respondError(w, http.StatusBadRequest, "identity key is required")
 		return
 	}
 	if len(req.IdentitySigningPubJWK) == 0 || !json.Valid(req.IdentitySigningPubJWK) {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "identity signing key is required"})
+		respondError(w, http.StatusBadRequest, "identity signing key is required")
 		return
 	}
 	if req.SignedPreKey.KeyID <= 0 || len(req.SignedPreKey.PublicKeyJWK) == 0 || !json.Valid(req.SignedPreKey.PublicKeyJWK) {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "signed prekey is required"})
+		respondError(w, http.StatusBadRequest, "signed prekey is required")
 		return
 	}
 	if strings.TrimSpace(req.SignedPreKey.Signature) == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "signed prekey signature is required"})
+		respondError(w, http.StatusBadRequest, "signed prekey signature is required")
 		return
 	}
 	if len(req.OneTimePreKeys) > maxOneTimePreKeysPerUpload {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "too many one-time prekeys in one upload"})
+		respondError(w, http.StatusBadRequest, "too many one-time prekeys in one upload")
 		return
 	}
 	if err := verifySignedPreKeySignature(req.IdentitySigningPubJWK, req.SignedPreKey.PublicKeyJWK, req.SignedPreKey.Signature); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	for _, entry := range req.OneTimePreKeys {
 		if entry.KeyID <= 0 || len(entry.PublicKeyJWK) == 0 || !json.Valid(entry.PublicKeyJWK) {
-			respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid one-time prekey"})
+			respondError(w, http.StatusBadRequest, "invalid one-time prekey")
 			return
 		}
 	}
 	fingerprint, err := keyFingerprint(req.IdentityKeyJWK)
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid identity key format"})
+		respondError(w, http.StatusBadRequest, "invalid identity key format")
 		return
 	}
 
@@ -208,11 +228,17 @@ func (a *App) handleSignalPreKeyBundleUpsert(w http.ResponseWriter, r *http.Requ
 	defer cancel()
 	tx, err := a.db.BeginTx(ctx, nil)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to begin transaction"})
+		respondError(w, http.StatusInternalServerError, "failed to begin transaction")
 		return
 	}
 	defer tx.Rollback()
 
+	previousFingerprint, err := loadCurrentIdentityFingerprint(ctx, tx, auth.UserID, auth.DeviceID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load identity key")
+		return
+	}
+
 	if _, err := tx.ExecContext(ctx, `
 INSERT INTO signal_device_identity_keys(user_id, device_id, identity_key_jwk, identity_signing_public_key_jwk, updated_at)
 VALUES ($1, $2, $3::jsonb, $4::jsonb, NOW())
@@ -221,7 +247,7 @@ SET identity_key_jwk = EXCLUDED.identity_key_jwk,
     identity_signing_public_key_jwk = EXCLUDED.identity_signing_public_key_jwk,
     updated_at = NOW()
 `, auth.UserID, auth.DeviceID, req.IdentityKeyJWK, req.IdentitySigningPubJWK); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to upsert identity key"})
+		respondError(w, http.StatusInternalServerError, "failed to upsert identity key")
 		return
 	}
 
@@ -232,10 +258,25 @@ ON CONFLICT (user_id, device_id, fingerprint) DO UPDATE
 SET identity_key_jwk = EXCLUDED.identity_key_jwk,
     last_seen_at = NOW()
 `, auth.UserID, auth.DeviceID, fingerprint, req.IdentityKeyJWK); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to update identity history"})
+		respondError(w, http.StatusInternalServerError, "failed to update identity history")
 		return
 	}
 
+	var identityChange *identityKeyChange
+	if previousFingerprint != "" && previousFingerprint != fingerprint {
+		identityChange = &identityKeyChange{
+			UserID:              auth.UserID,
+			Username:            auth.Username,
+			DeviceID:            auth.DeviceID,
+			PreviousFingerprint: previousFingerprint,
+			Fingerprint:         fingerprint,
+		}
+		if err := recordIdentityKeyChangeTx(ctx, tx, identityChange); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to record identity key change")
+			return
+		}
+	}
+
 	if _, err := tx.ExecContext(ctx, `
 INSERT INTO signal_device_signed_prekeys(user_id, device_id, key_id, public_key_jwk, signature, updated_at)
 VALUES ($1, $2, $3, $4::jsonb, $5, NOW())
@@ -245,7 +286,7 @@ SET key_id = EXCLUDED.key_id,
     signature = EXCLUDED.signature,
     updated_at = NOW()
 `, auth.UserID, auth.DeviceID, req.SignedPreKey.KeyID, req.SignedPreKey.PublicKeyJWK, req.SignedPreKey.Signature); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to upsert signed prekey"})
+		respondError(w, http.StatusInternalServerError, "failed to upsert signed prekey")
 		return
 	}
 
@@ -254,7 +295,7 @@ SET key_id = EXCLUDED.key_id,
 		auth.UserID,
 		auth.DeviceID,
 	); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to reset one-time prekeys"})
+		respondError(w, http.StatusInternalServerError, "failed to reset one-time prekeys")
 		return
 	}
 
@@ -268,16 +309,20 @@ SET public_key_jwk = EXCLUDED.public_key_jwk,
     consumed_at = NULL,
     created_at = NOW()
 `, auth.UserID, auth.DeviceID, entry.KeyID, entry.PublicKeyJWK); err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to upsert one-time prekeys"})
+			respondError(w, http.StatusInternalServerError, "failed to upsert one-time prekeys")
 			return
 		}
 		insertedOneTimePreKeys += 1
 	}
 
 	if err := tx.Commit(); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to commit prekey upload"})
+		respondError(w, http.StatusInternalServerError, "failed to commit prekey upload")
 		return
 	}
+	if identityChange != nil {
+		a.broadcastIdentityKeyChange(*identityChange)
+		a.notifyVerificationsCleared(*identityChange)
+	}
 
 	respondJSON(w, http.StatusOK, map[string]any{
 		"ok":                  true,
@@ -307,28 +352,62 @@ func (a *App) handleSignalPreKeyBundleFetchInternal(
 
 	if err := a.ensureSharedRoom(ctx, auth.UserID, targetUserID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusForbidden, map[string]any{"error": "target user is not in any shared room"})
+			respondError(w, http.StatusForbidden, "target user is not in any shared room")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to validate room relationship"})
+		respondError(w, http.StatusInternalServerError, "failed to validate room relationship")
+		return
+	}
+	if !a.rejectBotTarget(ctx, w, targetUserID) {
 		return
 	}
 
 	tx, err := a.db.BeginTx(ctx, nil)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to begin transaction"})
+		respondError(w, http.StatusInternalServerError, "failed to begin transaction")
 		return
 	}
 	defer tx.Rollback()
 
+	var requester *AuthContext
+	if consumeOneTimePreKey {
+		requester = &auth
+		if err := a.checkPreKeyFetchBudgetTx(ctx, tx, auth.UserID, targetUserID); err != nil {
+			if errors.Is(err, errPreKeyFetchBudgetExceeded) {
+				respondErrorCode(w, http.StatusTooManyRequests, "prekey_fetch_limited", err.Error())
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to check prekey fetch budget")
+			return
+		}
+	}
+	response, err := loadPreKeyBundleTx(ctx, tx, targetUserID, requester)
+	if err != nil {
+		if errors.Is(err, errPreKeyBundleNotPublished) {
+			respondError(w, http.StatusNotFound, "target user prekey bundle is not published")
+			return
+		}
+		requestLogger(r.Context()).Error("prekey_bundle_load_failed", "target_user_id", targetUserID, "error", err)
+		respondError(w, http.StatusInternalServerError, "failed to load target prekey bundle")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to finalize prekey bundle fetch")
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// loadPreKeyBundleTx reads the target's published device bundles. With a
+// requester it also claims one unused one-time prekey per device and records
+// who was served which key.
+func loadPreKeyBundleTx(ctx context.Context, tx *sql.Tx, targetUserID int64, requester *AuthContext) (SignalPreKeyBundleResponse, error) {
 	var response SignalPreKeyBundleResponse
 	if err := tx.QueryRowContext(ctx, `SELECT username FROM users WHERE id = $1`, targetUserID).Scan(&response.Username); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "target user prekey bundle is not published"})
-			return
+			return SignalPreKeyBundleResponse{}, errPreKeyBundleNotPublished
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load target prekey bundle"})
-		return
+		return SignalPreKeyBundleResponse{}, err
 	}
 	response.UserID = targetUserID
 
@@ -352,8 +431,7 @@ WHERE d.user_id = $1
 ORDER BY d.last_seen_at DESC, d.device_id ASC
 `, targetUserID)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load target prekey bundle"})
-		return
+		return SignalPreKeyBundleResponse{}, err
 	}
 	defer rows.Close()
 
@@ -373,8 +451,7 @@ ORDER BY d.last_seen_at DESC, d.device_id ASC
 			&item.SignedPreKey.Signature,
 			&signedPreKeyUpdatedAt,
 		); err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to decode target prekey bundle"})
-			return
+			return SignalPreKeyBundleResponse{}, err
 		}
 		if signedPreKeyUpdatedAt.After(identityUpdatedAt) {
 			item.UpdatedAt = signedPreKeyUpdatedAt.UTC().Format(time.RFC3339Nano)
@@ -390,15 +467,13 @@ ORDER BY d.last_seen_at DESC, d.device_id ASC
 		devices = append(devices, item)
 	}
 	if err := rows.Err(); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to iterate target prekey bundles"})
-		return
+		return SignalPreKeyBundleResponse{}, err
 	}
 	if len(devices) == 0 {
-		respondJSON(w, http.StatusNotFound, map[string]any{"error": "target user prekey bundle is not published"})
-		return
+		return SignalPreKeyBundleResponse{}, errPreKeyBundleNotPublished
 	}
 
-	if consumeOneTimePreKey {
+	if requester != nil {
 		for index := range devices {
 			var oneTimePreKey SignalOneTimePreKey
 			var createdAt time.Time
@@ -410,41 +485,38 @@ ORDER BY key_id ASC
 LIMIT 1
 FOR UPDATE SKIP LOCKED
 `, targetUserID, devices[index].DeviceID).Scan(&oneTimePreKey.KeyID, &oneTimePreKey.PublicKeyJWK, &createdAt)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return SignalPreKeyBundleResponse{}, err
+			}
+			now := time.Now().UTC()
+			var consumedKeyID *int64
 			if err == nil {
-				now := time.Now().UTC()
 				if _, execErr := tx.ExecContext(ctx, `
 UPDATE signal_device_one_time_prekeys
 SET consumed_at = $4
 WHERE user_id = $1 AND device_id = $2 AND key_id = $3
 `, targetUserID, devices[index].DeviceID, oneTimePreKey.KeyID, now); execErr != nil {
-					respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to consume one-time prekey"})
-					return
+					return SignalPreKeyBundleResponse{}, execErr
 				}
 				oneTimePreKey.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
 				devices[index].OneTimePreKey = &oneTimePreKey
+				consumedKeyID = &oneTimePreKey.KeyID
 				if now.After(maxUpdatedAt) {
 					maxUpdatedAt = now
 				}
-				continue
 			}
-			if !errors.Is(err, sql.ErrNoRows) {
-				respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load one-time prekey"})
-				return
+			if err := recordPreKeyConsumptionTx(ctx, tx, targetUserID, devices[index].DeviceID, consumedKeyID, *requester, now); err != nil {
+				return SignalPreKeyBundleResponse{}, err
 			}
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to finalize prekey bundle fetch"})
-		return
-	}
-
 	response.Devices = devices
 	if maxUpdatedAt.IsZero() {
 		maxUpdatedAt = time.Now().UTC()
 	}
 	response.UpdatedAt = maxUpdatedAt.UTC().Format(time.RFC3339Nano)
-	respondJSON(w, http.StatusOK, response)
+	return response, nil
 }
 
 func (a *App) handleSignalSafetyNumber(w http.ResponseWriter, r *http.Request, auth AuthContext, targetUserID int64) {
@@ -453,10 +525,13 @@ func (a *App) handleSignalSafetyNumber(w http.ResponseWriter, r *http.Request, a
 
 	if err := a.ensureSharedRoom(ctx, auth.UserID, targetUserID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusForbidden, map[string]any{"error": "target user is not in any shared room"})
+			respondError(w, http.StatusForbidden, "target user is not in any shared room")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to validate room relationship"})
+		respondError(w, http.StatusInternalServerError, "failed to validate room relationship")
+		return
+	}
+	if !a.rejectBotTarget(ctx, w, targetUserID) {
 		return
 	}
 
@@ -468,46 +543,41 @@ FROM signal_device_identity_keys
 WHERE user_id = $1 AND device_id = $2
 `, auth.UserID, auth.DeviceID).Scan(&localIdentityKey, &localUpdatedAt); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "local identity key is not published"})
+			respondError(w, http.StatusNotFound, "local identity key is not published")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load local identity"})
+		respondError(w, http.StatusInternalServerError, "failed to load local identity")
 		return
 	}
 
-	var targetIdentityKey json.RawMessage
-	var targetUpdatedAt time.Time
-	if err := a.db.QueryRowContext(ctx, `
-SELECT ik.identity_key_jwk, ik.updated_at
-FROM user_devices d
-JOIN signal_device_identity_keys ik
-  ON ik.user_id = d.user_id AND ik.device_id = d.device_id
-WHERE d.user_id = $1
-  AND d.revoked_at IS NULL
-ORDER BY d.last_seen_at DESC, d.device_id ASC
-LIMIT 1
-`, targetUserID).Scan(&targetIdentityKey, &targetUpdatedAt); err != nil {
+	targetIdentityKey, targetUpdatedAt, err := a.loadSafetyNumberIdentity(ctx, targetUserID)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "target identity key is not published"})
+			respondError(w, http.StatusNotFound, "target identity key is not published")
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load target identity"})
+		respondError(w, http.StatusInternalServerError, "failed to load target identity")
 		return
 	}
 
 	safetyNumber, err := formatSafetyNumber(auth.UserID, localIdentityKey, targetUserID, targetIdentityKey)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to derive safety number"})
+		respondError(w, http.StatusInternalServerError, "failed to derive safety number")
 		return
 	}
 	localFingerprint, err := keyFingerprint(localIdentityKey)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to fingerprint local identity"})
+		respondError(w, http.StatusInternalServerError, "failed to fingerprint local identity")
 		return
 	}
 	targetFingerprint, err := keyFingerprint(targetIdentityKey)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to fingerprint target identity"})
+		respondError(w, http.StatusInternalServerError, "failed to fingerprint target identity")
+		return
+	}
+	verification, err := a.loadIdentityVerification(ctx, auth.UserID, targetUserID, targetFingerprint)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load verification")
 		return
 	}
 
@@ -525,7 +595,7 @@ ORDER BY first_seen_at DESC
 LIMIT 20
 `, targetUserID)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to load identity history"})
+		respondError(w, http.StatusInternalServerError, "failed to load identity history")
 		return
 	}
 	defer rows.Close()
@@ -534,7 +604,7 @@ LIMIT 20
 		var firstSeenAt time.Time
 		var lastSeenAt time.Time
 		if err := rows.Scan(&item.Fingerprint, &firstSeenAt, &lastSeenAt); err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to decode identity history"})
+			respondError(w, http.StatusInternalServerError, "failed to decode identity history")
 			return
 		}
 		item.FirstSeenAt = firstSeenAt.UTC().Format(time.RFC3339Nano)
@@ -553,5 +623,25 @@ LIMIT 20
 		"targetIdentityUpdatedAt":   targetUpdatedAt.UTC().Format(time.RFC3339Nano),
 		"safetyNumber":              safetyNumber,
 		"targetHistory":             history,
+		"verified":                  verification.Verified,
+		"verifiedAt":                verification.VerifiedAt,
 	})
 }
+
+// loadSafetyNumberIdentity picks the identity key safety numbers are derived
+// from: the most recently active device's.
+func (a *App) loadSafetyNumberIdentity(ctx context.Context, userID int64) (json.RawMessage, time.Time, error) {
+	var identityKey json.RawMessage
+	var updatedAt time.Time
+	err := a.db.QueryRowContext(ctx, `
+SELECT ik.identity_key_jwk, ik.updated_at
+FROM user_devices d
+JOIN signal_device_identity_keys ik
+  ON ik.user_id = d.user_id AND ik.device_id = d.device_id
+WHERE d.user_id = $1
+  AND d.revoked_at IS NULL
+ORDER BY d.last_seen_at DESC, d.device_id ASC
+LIMIT 1
+`, userID).Scan(&identityKey, &updatedAt)
+	return identityKey, updatedAt, err
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSyncLimit = 200
+	maxSyncLimit     = 1000
+)
+
+func (a *App) handleSync(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	cursor := int64(0)
+	if value := strings.TrimSpace(r.URL.Query().Get("cursor")); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			respondError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		cursor = parsed
+	}
+	ackUndelivered := int64(0)
+	if value := strings.TrimSpace(r.URL.Query().Get("ackUndelivered")); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			respondError(w, http.StatusBadRequest, "invalid ackUndelivered")
+			return
+		}
+		ackUndelivered = parsed
+	}
+	announcementCursor := int64(0)
+	if value := strings.TrimSpace(r.URL.Query().Get("announcementCursor")); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			respondError(w, http.StatusBadRequest, "invalid announcementCursor")
+			return
+		}
+		announcementCursor = parsed
+	}
+	limit := defaultSyncLimit
+	if value := strings.TrimSpace(r.URL.Query().Get("limit")); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 && parsed <= maxSyncLimit {
+			limit = parsed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	events, err := a.listUserEventsSince(ctx, auth.UserID, cursor, limit+1)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load events")
+		return
+	}
+
+	// Dead letters stay until the client acknowledges them, so a sync that
+	// fails half way delivers them again.
+	if err := a.acknowledgeUndelivered(ctx, auth.UserID, ackUndelivered); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to acknowledge undelivered events")
+		return
+	}
+	undelivered, err := a.listUndeliveredEvents(ctx, auth.UserID, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load undelivered events")
+		return
+	}
+
+	handshakes, err := a.claimPendingHandshakes(ctx, auth.UserID, auth.DeviceID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load pending handshakes")
+		return
+	}
+	a.acknowledgeHandshakes(handshakes, auth.DeviceID, true)
+
+	loaded, err := a.listUserAnnouncementsSince(ctx, auth.UserID, announcementCursor, maxSyncAnnouncements)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load announcements")
+		return
+	}
+	announcements := make([]adminBroadcast, 0, len(loaded))
+	for _, announcement := range loaded {
+		announcementCursor = announcement.ID
+		if err := a.verifyBroadcast(announcement); err != nil {
+			requestLogger(r.Context()).Warn("drop_invalid_announcement", "broadcast_id", announcement.ID, "error", err)
+			continue
+		}
+		announcements = append(announcements, announcement)
+	}
+
+	hasMore := len(events) > limit
+	if hasMore {
+		events = events[:limit]
+	}
+	nextCursor := cursor
+	if len(events) > 0 {
+		nextCursor = events[len(events)-1].Cursor
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"events":      events,
+		"cursor":      nextCursor,
+		"hasMore":     hasMore,
+		"undelivered": undelivered,
+		"handshakes":  handshakes,
+		// Announcements page separately from events; clients pass
+		// announcementCursor back to receive only new ones.
+		"announcements":      announcements,
+		"announcementCursor": announcementCursor,
+	})
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleSyncGuards(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	auth := AuthContext{UserID: 1, Username: "alice", Role: "user"}
+
+	t.Run("wrong method", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/api/sync", nil)
+		response := httptest.NewRecorder()
+
+		app.handleSync(response, request, auth)
+
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, response.Code)
+		}
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/sync?cursor=-4", nil)
+		response := httptest.NewRecorder()
+
+		app.handleSync(response, request, auth)
+
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	})
+}
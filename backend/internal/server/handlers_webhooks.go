@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type roomWebhook struct {
+	ID         int64    `json:"id"`
+	RoomID     int64    `json:"roomId"`
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	CreatedAt  string   `json:"createdAt"`
+	DisabledAt *string  `json:"disabledAt,omitempty"`
+	Secret     string   `json:"secret,omitempty"`
+}
+
+type webhookDeliveryLogEntry struct {
+	ID             int64   `json:"id"`
+	EventType      string  `json:"eventType"`
+	Status         string  `json:"status"`
+	Attempts       int     `json:"attempts"`
+	LastStatusCode *int64  `json:"lastStatusCode,omitempty"`
+	LastError      *string `json:"lastError,omitempty"`
+	NextAttemptAt  string  `json:"nextAttemptAt"`
+	CreatedAt      string  `json:"createdAt"`
+	DeliveredAt    *string `json:"deliveredAt,omitempty"`
+}
+
+// normalizeWebhookEvents dedupes the requested event list; an empty list
+// subscribes to every supported event.
+func normalizeWebhookEvents(events []string) ([]string, bool) {
+	if len(events) == 0 {
+		return append([]string(nil), webhookEventTypes...), true
+	}
+	seen := make(map[string]struct{}, len(events))
+	normalized := make([]string, 0, len(events))
+	for _, event := range events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if !validWebhookEventType(event) {
+			return nil, false
+		}
+		if _, found := seen[event]; found {
+			continue
+		}
+		seen[event] = struct{}{}
+		normalized = append(normalized, event)
+	}
+	return normalized, true
+}
+
+// authorizeRoomManager writes the error response itself and reports whether
+// the caller may manage the room (creator or admin).
+func (a *App) authorizeRoomManager(ctx context.Context, w http.ResponseWriter, auth AuthContext, roomID int64) bool {
+	var createdBy sql.NullInt64
+	if err := a.db.QueryRowContext(ctx, `SELECT created_by FROM rooms WHERE id = $1`, roomID).Scan(&createdBy); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "room not found")
+			return false
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load room")
+		return false
+	}
+	if !auth.Can(permManageRooms) && !(createdBy.Valid && createdBy.Int64 == auth.UserID) {
+		respondError(w, http.StatusForbidden, "only room creator or admin can manage webhooks")
+		return false
+	}
+	return true
+}
+
+func (a *App) handleRoomWebhooks(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	var events []string
+	if r.Method == http.MethodPost {
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		req.URL = strings.TrimSpace(req.URL)
+		if err := validateWebhookURL(req.URL); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		normalized, ok := normalizeWebhookEvents(req.Events)
+		if !ok {
+			respondError(w, http.StatusBadRequest, "unsupported webhook event type")
+			return
+		}
+		events = normalized
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if !a.authorizeRoomManager(ctx, w, auth, roomID) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		webhooks, err := a.listRoomWebhooks(ctx, roomID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to list webhooks")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"webhooks": webhooks})
+		return
+	}
+
+	var existing int
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM room_webhooks WHERE room_id = $1 AND disabled_at IS NULL`,
+		roomID,
+	).Scan(&existing); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to create webhook")
+		return
+	}
+	if existing >= maxWebhooksPerRoom {
+		respondErrorCode(w, http.StatusConflict, "webhook_limit_reached", "room webhook limit reached")
+		return
+	}
+	if err := checkWebhookDestination(ctx, req.URL); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to create webhook")
+		return
+	}
+	webhook := roomWebhook{RoomID: roomID, URL: req.URL, Events: events, Secret: secret}
+	var createdAt time.Time
+	if err := a.db.QueryRowContext(ctx, `
+INSERT INTO room_webhooks(room_id, url, secret, event_types, created_by)
+VALUES ($1, $2, $3, $4::TEXT[], $5)
+RETURNING id, created_at
+`, roomID, webhook.URL, secret, events, auth.UserID).Scan(&webhook.ID, &createdAt); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to create webhook")
+		return
+	}
+	webhook.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+
+	// The secret is only ever returned here; receivers need it to verify
+	// X-Webhook-Signature.
+	respondJSON(w, http.StatusCreated, map[string]any{"webhook": webhook})
+}
+
+func (a *App) handleRoomWebhookSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64, parts []string) {
+	webhookID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || webhookID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid webhook id")
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		a.handleDeleteRoomWebhook(w, r, auth, roomID, webhookID)
+	case len(parts) == 2 && parts[1] == "deliveries":
+		a.handleRoomWebhookDeliveries(w, r, auth, roomID, webhookID)
+	default:
+		respondError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (a *App) handleDeleteRoomWebhook(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID, webhookID int64) {
+	if r.Method != http.MethodDelete {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if !a.authorizeRoomManager(ctx, w, auth, roomID) {
+		return
+	}
+
+	var deletedID int64
+	if err := a.db.QueryRowContext(ctx,
+		`DELETE FROM room_webhooks WHERE id = $1 AND room_id = $2 RETURNING id`,
+		webhookID, roomID,
+	).Scan(&deletedID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "webhook not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to delete webhook")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"deleted": true, "webhookId": deletedID})
+}
+
+func (a *App) handleRoomWebhookDeliveries(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID, webhookID int64) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limit := 50
+	if value := strings.TrimSpace(r.URL.Query().Get("limit")); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 && parsed <= 200 {
+			limit = parsed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if !a.authorizeRoomManager(ctx, w, auth, roomID) {
+		return
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT d.id, d.event_type, d.status, d.attempts, d.last_status_code, d.last_error, d.next_attempt_at, d.created_at, d.delivered_at
+FROM webhook_deliveries d
+JOIN room_webhooks w ON w.id = d.webhook_id
+WHERE d.webhook_id = $1 AND w.room_id = $2
+ORDER BY d.id DESC
+LIMIT $3
+`, webhookID, roomID, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list webhook deliveries")
+		return
+	}
+	defer rows.Close()
+
+	deliveries := make([]webhookDeliveryLogEntry, 0, limit)
+	for rows.Next() {
+		var item webhookDeliveryLogEntry
+		var lastStatusCode sql.NullInt64
+		var lastError sql.NullString
+		var nextAttemptAt time.Time
+		var createdAt time.Time
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(
+			&item.ID,
+			&item.EventType,
+			&item.Status,
+			&item.Attempts,
+			&lastStatusCode,
+			&lastError,
+			&nextAttemptAt,
+			&createdAt,
+			&deliveredAt,
+		); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode webhook delivery")
+			return
+		}
+		if lastStatusCode.Valid {
+			value := lastStatusCode.Int64
+			item.LastStatusCode = &value
+		}
+		if lastError.Valid {
+			value := lastError.String
+			item.LastError = &value
+		}
+		item.NextAttemptAt = nextAttemptAt.UTC().Format(time.RFC3339Nano)
+		item.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		if deliveredAt.Valid {
+			value := deliveredAt.Time.UTC().Format(time.RFC3339Nano)
+			item.DeliveredAt = &value
+		}
+		deliveries = append(deliveries, item)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"deliveries": deliveries})
+}
+
+func (a *App) listRoomWebhooks(ctx context.Context, roomID int64) ([]roomWebhook, error) {
+	rows, err := a.db.QueryContext(ctx, `
+SELECT id, room_id, url, array_to_json(event_types)::TEXT, created_at, disabled_at
+FROM room_webhooks
+WHERE room_id = $1
+ORDER BY id ASC
+`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]roomWebhook, 0, 4)
+	for rows.Next() {
+		var item roomWebhook
+		var eventsRaw string
+		var createdAt time.Time
+		var disabledAt sql.NullTime
+		if err := rows.Scan(&item.ID, &item.RoomID, &item.URL, &eventsRaw, &createdAt, &disabledAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(eventsRaw), &item.Events); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		if disabledAt.Valid {
+			value := disabledAt.Time.UTC().Format(time.RFC3339Nano)
+			item.DisabledAt = &value
+		}
+		webhooks = append(webhooks, item)
+	}
+	return webhooks, rows.Err()
+}
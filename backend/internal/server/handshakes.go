@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	defaultDRHandshakeTTL       = 72 * time.Hour
+	maxHandshakesPerDelivery    = 64
+	drHandshakeStepInit         = "init"
+	drHandshakeStepResponse     = "response"
+	maxDRHandshakeKeyFieldBytes = 4 << 10
+)
+
+var errHandshakeInvalid = errors.New("invalid dr_handshake frame")
+
+func (a *App) handshakeTTL() time.Duration {
+	if a.drHandshakeTTL <= 0 {
+		return defaultDRHandshakeTTL
+	}
+	return a.drHandshakeTTL
+}
+
+func validHandshakeKey(raw json.RawMessage, required bool) bool {
+	if len(raw) == 0 {
+		return !required
+	}
+	return len(raw) <= maxDRHandshakeKeyFieldBytes && json.Valid(raw)
+}
+
+// validateHandshake checks a dr_handshake frame. The init carries the
+// initiator's identity keys so the recipient can run X3DH; the response only
+// needs the recipient's first ratchet key.
+func validateHandshake(incoming WSIncoming, fromUserID int64, fromDeviceID string) error {
+	if incoming.ToUserID <= 0 || incoming.SessionVersion <= 0 {
+		return errHandshakeInvalid
+	}
+	toDeviceID := normalizeDeviceID(incoming.ToDeviceID)
+	if incoming.ToUserID == fromUserID && (toDeviceID == "" || toDeviceID == fromDeviceID) {
+		return errHandshakeInvalid
+	}
+	if !validHandshakeKey(incoming.RatchetDHPublic, true) {
+		return errHandshakeInvalid
+	}
+	switch incoming.Step {
+	case drHandshakeStepInit:
+		if !validHandshakeKey(incoming.IdentityPublicJWK, true) || !validHandshakeKey(incoming.IdentitySigningPubJWK, true) {
+			return errHandshakeInvalid
+		}
+	case drHandshakeStepResponse:
+		if !validHandshakeKey(incoming.IdentityPublicJWK, false) || !validHandshakeKey(incoming.IdentitySigningPubJWK, false) {
+			return errHandshakeInvalid
+		}
+	default:
+		return errHandshakeInvalid
+	}
+	return nil
+}
+
+// relayHandshake delivers a dr_handshake to its recipient. If no matching
+// device is connected to the room the frame is queued until the recipient
+// next connects or syncs, so a session init is never lost to timing.
+func (c *Client) relayHandshake(incoming WSIncoming) error {
+	if err := validateHandshake(incoming, c.userID, c.deviceID); err != nil {
+		return err
+	}
+	if c.app.hub.blocks.Between(c.userID, incoming.ToUserID) {
+		return errHandshakeInvalid
+	}
+	toDeviceID := normalizeDeviceID(incoming.ToDeviceID)
+
+	ctx, cancel := context.WithTimeout(c.context(), 5*time.Second)
+	defer cancel()
+	if err := c.app.ensureMembership(ctx, c.userID, c.roomID); err != nil {
+		return err
+	}
+	if err := c.app.ensureMembership(ctx, incoming.ToUserID, c.roomID); err != nil {
+		return err
+	}
+
+	frame := map[string]any{
+		"type":                  "dr_handshake",
+		"roomId":                c.roomID,
+		"fromUserId":            c.userID,
+		"fromUsername":          c.username,
+		"fromDeviceId":          c.deviceID,
+		"toUserId":              incoming.ToUserID,
+		"toDeviceId":            toDeviceID,
+		"step":                  incoming.Step,
+		"sessionVersion":        incoming.SessionVersion,
+		"createdAt":             time.Now().UTC().Format(time.RFC3339Nano),
+		"ratchetDhPublicKeyJwk": incoming.RatchetDHPublic,
+	}
+	if len(incoming.IdentityPublicJWK) > 0 {
+		frame["identityPublicKeyJwk"] = incoming.IdentityPublicJWK
+	}
+	if len(incoming.IdentitySigningPubJWK) > 0 {
+		frame["identitySigningPublicKeyJwk"] = incoming.IdentitySigningPubJWK
+	}
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+
+	// Only the addressed device (or, without a device, the addressed user)
+	// ever sees the frame; the rest of the room learns nothing about who is
+	// setting up sessions with whom.
+	if c.app.hub.DeviceConnected(c.roomID, incoming.ToUserID, toDeviceID) {
+		c.app.hub.UnicastToDevice(c.roomID, incoming.ToUserID, toDeviceID, payload)
+		c.app.acknowledgeHandshakes([]json.RawMessage{payload}, toDeviceID, false)
+		return nil
+	}
+	return c.app.queueHandshake(ctx, c.roomID, c.userID, c.deviceID, incoming.ToUserID, toDeviceID, payload)
+}
+
+func (a *App) queueHandshake(ctx context.Context, roomID, fromUserID int64, fromDeviceID string, toUserID int64, toDeviceID string, frame []byte) error {
+	// Expired rows are dropped lazily; the expires_at index keeps this cheap.
+	if _, err := a.db.ExecContext(ctx, `DELETE FROM pending_dr_handshakes WHERE expires_at <= NOW()`); err != nil {
+		return err
+	}
+	_, err := a.db.ExecContext(ctx, `
+INSERT INTO pending_dr_handshakes(room_id, from_user_id, from_device_id, to_user_id, to_device_id, frame, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7)
+`, roomID, fromUserID, fromDeviceID, toUserID, toDeviceID, frame, time.Now().Add(a.handshakeTTL()))
+	return err
+}
+
+// claimPendingHandshakes removes and returns the unexpired handshakes queued
+// for the device, oldest first. Handshakes addressed to the user without a
+// device are claimed by whichever device comes online first.
+func (a *App) claimPendingHandshakes(ctx context.Context, userID int64, deviceID string) ([]json.RawMessage, error) {
+	rows, err := a.db.QueryContext(ctx, `
+DELETE FROM pending_dr_handshakes
+WHERE id IN (
+  SELECT id
+  FROM pending_dr_handshakes
+  WHERE to_user_id = $1
+    AND (to_device_id = $2 OR to_device_id = '')
+    AND expires_at > NOW()
+  ORDER BY id ASC
+  LIMIT $3
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING id, frame
+`, userID, deviceID, maxHandshakesPerDelivery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type claimed struct {
+		id    int64
+		frame json.RawMessage
+	}
+	items := make([]claimed, 0, 8)
+	for rows.Next() {
+		var item claimed
+		if err := rows.Scan(&item.id, &item.frame); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// DELETE ... RETURNING does not keep the subquery's order.
+	sort.Slice(items, func(i, j int) bool { return items[i].id < items[j].id })
+	frames := make([]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		frames = append(frames, item.frame)
+	}
+	return frames, nil
+}
+
+// acknowledgeHandshakes tells each initiator device that its handshake
+// reached the recipient. queued marks handshakes that waited for the
+// recipient to come online.
+func (a *App) acknowledgeHandshakes(frames []json.RawMessage, deliveredToDeviceID string, queued bool) {
+	deliveredAt := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, frame := range frames {
+		var handshake struct {
+			Type           string `json:"type"`
+			RoomID         int64  `json:"roomId"`
+			FromUserID     int64  `json:"fromUserId"`
+			FromDeviceID   string `json:"fromDeviceId"`
+			ToUserID       int64  `json:"toUserId"`
+			ToDeviceID     string `json:"toDeviceId"`
+			Step           string `json:"step"`
+			SessionVersion int    `json:"sessionVersion"`
+		}
+		if err := json.Unmarshal(frame, &handshake); err != nil || handshake.Type != "dr_handshake" {
+			continue
+		}
+		payload, err := json.Marshal(map[string]any{
+			"type":                "handshake_delivered",
+			"roomId":              handshake.RoomID,
+			"toUserId":            handshake.ToUserID,
+			"toDeviceId":          handshake.ToDeviceID,
+			"deliveredToDeviceId": deliveredToDeviceID,
+			"step":                handshake.Step,
+			"sessionVersion":      handshake.SessionVersion,
+			"queued":              queued,
+			"deliveredAt":         deliveredAt,
+		})
+		if err != nil {
+			continue
+		}
+		a.hub.UnicastToDevice(handshake.RoomID, handshake.FromUserID, handshake.FromDeviceID, payload)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestValidateHandshake(t *testing.T) {
+	t.Parallel()
+
+	key := json.RawMessage(`{"kty":"EC","crv":"P-256","x":"x","y":"y"}`)
+	init := WSIncoming{
+		Type:                  "dr_handshake",
+		ToUserID:              2,
+		ToDeviceID:            "device-b",
+		Step:                  drHandshakeStepInit,
+		SessionVersion:        1,
+		RatchetDHPublic:       key,
+		IdentityPublicJWK:     key,
+		IdentitySigningPubJWK: key,
+	}
+	if err := validateHandshake(init, 1, "device-a"); err != nil {
+		t.Fatalf("expected valid init, got %v", err)
+	}
+
+	response := WSIncoming{
+		Type:            "dr_handshake",
+		ToUserID:        1,
+		Step:            drHandshakeStepResponse,
+		SessionVersion:  1,
+		RatchetDHPublic: key,
+	}
+	if err := validateHandshake(response, 2, "device-b"); err != nil {
+		t.Fatalf("expected valid response, got %v", err)
+	}
+
+	missingIdentity := init
+	missingIdentity.IdentityPublicJWK = nil
+	selfDevice := init
+	selfDevice.ToUserID = 1
+	selfDevice.ToDeviceID = "device-a"
+	unknownStep := init
+	unknownStep.Step = "rekey"
+	noVersion := init
+	noVersion.SessionVersion = 0
+	for name, incoming := range map[string]WSIncoming{
+		"missing identity": missingIdentity,
+		"self device":      selfDevice,
+		"unknown step":     unknownStep,
+		"no version":       noVersion,
+	} {
+		if err := validateHandshake(incoming, 1, "device-a"); !errors.Is(err, errHandshakeInvalid) {
+			t.Fatalf("%s: expected errHandshakeInvalid, got %v", name, err)
+		}
+	}
+
+	// Another device of the same user is a legitimate target.
+	ownOtherDevice := init
+	ownOtherDevice.ToUserID = 1
+	ownOtherDevice.ToDeviceID = "device-c"
+	if err := validateHandshake(ownOtherDevice, 1, "device-a"); err != nil {
+		t.Fatalf("expected handshake to own other device to be valid, got %v", err)
+	}
+}
+
+func TestAcknowledgeHandshakesNotifiesInitiatorDeviceOnly(t *testing.T) {
+	t.Parallel()
+
+	app := &App{hub: NewHub()}
+	initiator := &Client{roomID: 7, userID: 1, deviceID: "device-a", send: make(chan []byte, 1)}
+	initiatorOther := &Client{roomID: 7, userID: 1, deviceID: "device-z", send: make(chan []byte, 1)}
+	bystander := &Client{roomID: 7, userID: 3, deviceID: "device-c", send: make(chan []byte, 1)}
+	app.hub.AddClient(initiator)
+	app.hub.AddClient(initiatorOther)
+	app.hub.AddClient(bystander)
+
+	frame, err := json.Marshal(map[string]any{
+		"type":           "dr_handshake",
+		"roomId":         7,
+		"fromUserId":     1,
+		"fromDeviceId":   "device-a",
+		"toUserId":       2,
+		"toDeviceId":     "device-b",
+		"step":           drHandshakeStepInit,
+		"sessionVersion": 4,
+	})
+	if err != nil {
+		t.Fatalf("marshal frame: %v", err)
+	}
+	app.acknowledgeHandshakes([]json.RawMessage{frame}, "device-b", true)
+
+	var ack map[string]any
+	if err := json.Unmarshal(<-initiator.send, &ack); err != nil {
+		t.Fatalf("decode ack: %v", err)
+	}
+	if ack["type"] != "handshake_delivered" || ack["toDeviceId"] != "device-b" || ack["sessionVersion"] != float64(4) || ack["queued"] != true {
+		t.Fatalf("unexpected ack: %#v", ack)
+	}
+	for _, client := range []*Client{initiatorOther, bystander} {
+		select {
+		case <-client.send:
+			t.Fatalf("device %s should not receive the ack", client.deviceID)
+		default:
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	healthStatusOK          = "ok"
+	healthStatusUnavailable = "unavailable"
+	healthStatusDegraded    = "degraded"
+
+	readinessTimeout = 2 * time.Second
+	livenessTimeout  = time.Second
+)
+
+var errHubUnresponsive = errors.New("hub lock not acquired in time")
+
+type healthComponent struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+	// Critical components fail the probe; others only report.
+	Critical bool `json:"critical"`
+}
+
+func newHealthComponent(critical bool, started time.Time, err error) healthComponent {
+	component := healthComponent{
+		Status:    healthStatusOK,
+		LatencyMS: float64(time.Since(started).Microseconds()) / 1000,
+		Critical:  critical,
+	}
+	if err != nil {
+		component.Status = healthStatusUnavailable
+		component.Error = err.Error()
+	}
+	return component
+}
+
+func respondHealth(w http.ResponseWriter, components map[string]healthComponent) {
+	status := healthStatusOK
+	code := http.StatusOK
+	for _, component := range components {
+		if component.Status == healthStatusOK {
+			continue
+		}
+		if component.Critical {
+			status = healthStatusUnavailable
+			code = http.StatusServiceUnavailable
+			break
+		}
+		status = healthStatusDegraded
+	}
+	respondJSON(w, code, map[string]any{"status": status, "components": components})
+}
+
+// Probe fails when the hub lock cannot be taken within timeout. A hub wedged
+// on its mutex stops routing frames, which is what liveness needs to catch.
+func (h *Hub) Probe(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		h.mu.RLock()
+		h.mu.RUnlock()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return errHubUnresponsive
+	}
+}
+
+// latestMigrationVersion is the highest version embedded in the binary, which
+// the database must have reached before the instance takes traffic.
+func latestMigrationVersion() (int64, error) {
+	entries, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for _, entry := range entries {
+		prefix, _, found := strings.Cut(entry.Name(), "_")
+		if !found {
+			continue
+		}
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		if version > latest {
+			latest = version
+		}
+	}
+	if latest == 0 {
+		return 0, errors.New("no embedded migrations")
+	}
+	return latest, nil
+}
+
+func (a *App) checkMigrations(ctx context.Context) error {
+	expected, err := latestMigrationVersion()
+	if err != nil {
+		return err
+	}
+	current, err := currentSchemaVersion(ctx, a.db)
+	if err != nil {
+		return err
+	}
+	if current != expected {
+		return errors.New("schema version " + strconv.FormatInt(current, 10) + ", expected " + strconv.FormatInt(expected, 10))
+	}
+	return nil
+}
+
+// handleLivez only checks in-process state so a database outage never gets
+// the pod restarted.
+func (a *App) handleLivez(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	components := make(map[string]healthComponent, 1)
+
+	started := time.Now()
+	var hubErr error
+	if a.hub == nil {
+		hubErr = errors.New("hub not initialized")
+	} else {
+		hubErr = a.hub.Probe(livenessTimeout)
+	}
+	components["hub"] = newHealthComponent(true, started, hubErr)
+
+	respondHealth(w, components)
+}
+
+func (a *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	components := make(map[string]healthComponent, 5)
+
+	// A draining instance must leave the load balancer before it closes sockets.
+	var drainErr error
+	if a.draining.Load() {
+		drainErr = errors.New("draining for restart")
+	}
+	components["drain"] = newHealthComponent(true, time.Now(), drainErr)
+
+	started := time.Now()
+	var hubErr error
+	if a.hub == nil {
+		hubErr = errors.New("hub not initialized")
+	}
+	components["hub"] = newHealthComponent(true, started, hubErr)
+
+	started = time.Now()
+	dbErr := a.db.PingContext(ctx)
+	components["database"] = newHealthComponent(true, started, dbErr)
+
+	started = time.Now()
+	migrationErr := dbErr
+	if migrationErr == nil {
+		migrationErr = a.checkMigrations(ctx)
+	}
+	components["migrations"] = newHealthComponent(true, started, migrationErr)
+
+	if a.replica != nil {
+		started = time.Now()
+		components["databaseReplica"] = newHealthComponent(false, started, a.replica.db.PingContext(ctx))
+	}
+
+	respondHealth(w, components)
+}
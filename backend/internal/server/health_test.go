@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLivezReportsHubState(t *testing.T) {
+	t.Parallel()
+
+	app := &App{hub: NewHub()}
+	recorder := httptest.NewRecorder()
+	app.handleLivez(recorder, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	var body struct {
+		Status     string                     `json:"status"`
+		Components map[string]healthComponent `json:"components"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.Status != healthStatusOK || body.Components["hub"].Status != healthStatusOK {
+		t.Fatalf("unexpected body: %+v", body)
+	}
+
+	recorder = httptest.NewRecorder()
+	(&App{}).handleLivez(recorder, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a hub, got %d", recorder.Code)
+	}
+}
+
+func TestHealthProbesRejectWrongMethod(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	for _, handler := range []http.HandlerFunc{app.handleLivez, app.handleReadyz} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/", nil))
+		if recorder.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected 405, got %d", recorder.Code)
+		}
+	}
+}
+
+func TestHubProbeTimesOutWhenLocked(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	hub.mu.Lock()
+	err := hub.Probe(10 * time.Millisecond)
+	hub.mu.Unlock()
+	if !errors.Is(err, errHubUnresponsive) {
+		t.Fatalf("expected errHubUnresponsive, got %v", err)
+	}
+	if err := hub.Probe(time.Second); err != nil {
+		t.Fatalf("expected probe to succeed, got %v", err)
+	}
+}
+
+func TestRespondHealthIgnoresNonCriticalFailures(t *testing.T) {
+	t.Parallel()
+
+	recorder := httptest.NewRecorder()
+	respondHealth(recorder, map[string]healthComponent{
+		"database":        {Status: healthStatusOK, Critical: true},
+		"databaseReplica": {Status: healthStatusUnavailable},
+	})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["status"] != healthStatusDegraded {
+		t.Fatalf("expected degraded status, got %v", body["status"])
+	}
+}
+
+func TestLatestMigrationVersionMatchesEmbeddedFiles(t *testing.T) {
+	t.Parallel()
+
+	version, err := latestMigrationVersion()
+	if err != nil {
+		t.Fatalf("latestMigrationVersion: %v", err)
+	}
+	if version < 14 {
+		t.Fatalf("expected at least version 14, got %d", version)
+	}
+}
@@ -0,0 +1,416 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	historyBackfillTTL             = time.Hour
+	maxHistoryBackfillMessages     = 500
+	maxHistoryKeysPerResponse      = 100
+	maxHistoryBackfillResponses    = 50
+	maxHistoryBackfillsPerDelivery = 16
+
+	historyBackfillOpen      = "open"
+	historyBackfillCompleted = "completed"
+)
+
+var (
+	errHistoryBackfillInvalid = errors.New("invalid history key frame")
+	errHistoryBackfillClosed  = errors.New("history backfill is closed or expired")
+	errHistoryBackfillRange   = errors.New("message is outside the history backfill range")
+	errHistoryBackfillLimit   = errors.New("history backfill response limit reached")
+	errHistoryBackfillOffline = errors.New("requesting device is not connected")
+)
+
+// historyBackfill is a joined device's request for the keys of messages sent
+// before it joined. Existing members re-wrap those keys for the device and the
+// server only routes the result and tracks which messages were covered.
+type historyBackfill struct {
+	ID                int64
+	RoomID            int64
+	RequesterID       int64
+	RequesterUsername string
+	RequesterDeviceID string
+	FromMessageID     int64
+	UpToMessageID     int64
+	MessageCount      int
+	KeysDelivered     int
+	Responses         int
+	Status            string
+	CreatedAt         time.Time
+	CompletedAt       sql.NullTime
+	ExpiresAt         time.Time
+}
+
+func (b historyBackfill) requestFrame() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":          "history_key_request",
+		"backfillId":    b.ID,
+		"roomId":        b.RoomID,
+		"fromUserId":    b.RequesterID,
+		"fromUsername":  b.RequesterUsername,
+		"fromDeviceId":  b.RequesterDeviceID,
+		"fromMessageId": b.FromMessageID,
+		"upToMessageId": b.UpToMessageID,
+		"messageCount":  b.MessageCount,
+	})
+}
+
+func (b historyBackfill) progress() map[string]any {
+	return map[string]any{
+		"backfillId":    b.ID,
+		"roomId":        b.RoomID,
+		"status":        b.Status,
+		"fromMessageId": b.FromMessageID,
+		"upToMessageId": b.UpToMessageID,
+		"messageCount":  b.MessageCount,
+		"keysDelivered": b.KeysDelivered,
+		"responses":     b.Responses,
+		"expiresAt":     b.ExpiresAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// normalizeHistoryKeyMessageIDs checks the message list of a response and
+// returns it without duplicates.
+func normalizeHistoryKeyMessageIDs(ids []int64) ([]int64, error) {
+	if len(ids) == 0 || len(ids) > maxHistoryKeysPerResponse {
+		return nil, errHistoryBackfillInvalid
+	}
+	seen := make(map[int64]struct{}, len(ids))
+	unique := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id <= 0 {
+			return nil, errHistoryBackfillInvalid
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		unique = append(unique, id)
+	}
+	return unique, nil
+}
+
+// openHistoryBackfill starts a backfill covering the newest limit messages
+// the device's user could not read because they were sent before the user
+// joined. An unexpired backfill for the device is returned as is, so asking
+// again re-announces an open one and does not restart a completed one.
+func (a *App) openHistoryBackfill(ctx context.Context, roomID, userID int64, deviceID string, limit int) (historyBackfill, error) {
+	if _, err := a.db.ExecContext(ctx, `DELETE FROM history_backfills WHERE expires_at <= NOW()`); err != nil {
+		return historyBackfill{}, err
+	}
+	backfill, err := a.loadHistoryBackfill(ctx, roomID, userID, deviceID)
+	if err == nil {
+		return backfill, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return historyBackfill{}, err
+	}
+
+	var fromID, upToID sql.NullInt64
+	var count int
+	if err := a.db.QueryRowContext(ctx, `
+SELECT MIN(m.id), MAX(m.id), COUNT(*)
+FROM (
+  SELECT m.id
+  FROM messages m
+  JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = $2
+  WHERE m.room_id = $1 AND m.created_at < rm.joined_at AND m.revoked_at IS NULL
+  ORDER BY m.id DESC
+  LIMIT $3
+) m
+`, roomID, userID, limit).Scan(&fromID, &upToID, &count); err != nil {
+		return historyBackfill{}, err
+	}
+
+	backfill = historyBackfill{
+		RoomID:            roomID,
+		RequesterID:       userID,
+		RequesterDeviceID: deviceID,
+		FromMessageID:     fromID.Int64,
+		UpToMessageID:     upToID.Int64,
+		MessageCount:      count,
+		Status:            historyBackfillOpen,
+	}
+	// Nothing predates the join, so there is nothing to wait for.
+	if count == 0 {
+		backfill.Status = historyBackfillCompleted
+	}
+	err = a.db.QueryRowContext(ctx, `
+INSERT INTO history_backfills(room_id, requester_id, requester_device_id, from_message_id, up_to_message_id, message_count, status, completed_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, CASE WHEN $7 = 'completed' THEN NOW() END, $8)
+ON CONFLICT (room_id, requester_id, requester_device_id) DO UPDATE
+SET from_message_id = EXCLUDED.from_message_id,
+    up_to_message_id = EXCLUDED.up_to_message_id,
+    message_count = EXCLUDED.message_count,
+    keys_delivered = 0,
+    responses = 0,
+    status = EXCLUDED.status,
+    created_at = NOW(),
+    completed_at = EXCLUDED.completed_at,
+    expires_at = EXCLUDED.expires_at
+RETURNING id, created_at, expires_at
+`, roomID, userID, deviceID, backfill.FromMessageID, backfill.UpToMessageID, count, backfill.Status,
+		time.Now().Add(historyBackfillTTL)).Scan(&backfill.ID, &backfill.CreatedAt, &backfill.ExpiresAt)
+	if err != nil {
+		return historyBackfill{}, err
+	}
+	// A backfill that raced with another request starts over; keys
+	// delivered before are not counted again.
+	if _, err := a.db.ExecContext(ctx, `DELETE FROM history_backfill_keys WHERE backfill_id = $1`, backfill.ID); err != nil {
+		return historyBackfill{}, err
+	}
+	return backfill, nil
+}
+
+const historyBackfillColumns = `
+b.id, b.room_id, b.requester_id, u.username, b.requester_device_id, b.from_message_id, b.up_to_message_id,
+b.message_count, b.keys_delivered, b.responses, b.status, b.created_at, b.completed_at, b.expires_at`
+
+func scanHistoryBackfill(row interface{ Scan(...any) error }) (historyBackfill, error) {
+	var b historyBackfill
+	err := row.Scan(&b.ID, &b.RoomID, &b.RequesterID, &b.RequesterUsername, &b.RequesterDeviceID, &b.FromMessageID, &b.UpToMessageID,
+		&b.MessageCount, &b.KeysDelivered, &b.Responses, &b.Status, &b.CreatedAt, &b.CompletedAt, &b.ExpiresAt)
+	return b, err
+}
+
+// loadHistoryBackfill returns the device's unexpired backfill in the room.
+func (a *App) loadHistoryBackfill(ctx context.Context, roomID, userID int64, deviceID string) (historyBackfill, error) {
+	return scanHistoryBackfill(a.db.QueryRowContext(ctx, `
+SELECT`+historyBackfillColumns+`
+FROM history_backfills b
+JOIN users u ON u.id = b.requester_id
+WHERE b.room_id = $1 AND b.requester_id = $2 AND b.requester_device_id = $3 AND b.expires_at > NOW()
+`, roomID, userID, deviceID))
+}
+
+// openHistoryBackfills returns the room's open backfills that memberID can
+// help with, oldest first, so a member that connects late still sees them.
+func (a *App) openHistoryBackfills(ctx context.Context, roomID, memberID int64, limit int) ([]historyBackfill, error) {
+	rows, err := a.db.QueryContext(ctx, `
+SELECT`+historyBackfillColumns+`
+FROM history_backfills b
+JOIN users u ON u.id = b.requester_id
+WHERE b.room_id = $1 AND b.requester_id <> $2 AND b.status = 'open' AND b.expires_at > NOW()
+ORDER BY b.id ASC
+LIMIT $3
+`, roomID, memberID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	backfills := make([]historyBackfill, 0, 4)
+	for rows.Next() {
+		backfill, err := scanHistoryBackfill(rows)
+		if err != nil {
+			return nil, err
+		}
+		backfills = append(backfills, backfill)
+	}
+	return backfills, rows.Err()
+}
+
+// recordHistoryKeys notes which messages a response covered and returns the
+// backfill with its updated progress. Every message must belong to the
+// backfill's range; a message covered twice only counts once.
+func (a *App) recordHistoryKeys(ctx context.Context, backfillID, roomID, responderID int64, messageIDs []int64) (historyBackfill, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return historyBackfill{}, err
+	}
+	defer tx.Rollback()
+
+	backfill, err := scanHistoryBackfill(tx.QueryRowContext(ctx, `
+SELECT`+historyBackfillColumns+`
+FROM history_backfills b
+JOIN users u ON u.id = b.requester_id
+WHERE b.id = $1 AND b.room_id = $2
+FOR UPDATE OF b
+`, backfillID, roomID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return historyBackfill{}, errHistoryBackfillClosed
+		}
+		return historyBackfill{}, err
+	}
+	if backfill.Status != historyBackfillOpen || !time.Now().Before(backfill.ExpiresAt) {
+		return historyBackfill{}, errHistoryBackfillClosed
+	}
+	if backfill.RequesterID == responderID {
+		return historyBackfill{}, errHistoryBackfillInvalid
+	}
+	if backfill.Responses >= maxHistoryBackfillResponses {
+		return historyBackfill{}, errHistoryBackfillLimit
+	}
+
+	var inRange int
+	if err := tx.QueryRowContext(ctx, `
+SELECT COUNT(*)
+FROM messages
+WHERE room_id = $1 AND id = ANY($2) AND id BETWEEN $3 AND $4
+`, roomID, messageIDs, backfill.FromMessageID, backfill.UpToMessageID).Scan(&inRange); err != nil {
+		return historyBackfill{}, err
+	}
+	if inRange != len(messageIDs) {
+		return historyBackfill{}, errHistoryBackfillRange
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO history_backfill_keys(backfill_id, message_id, responder_id)
+SELECT $1, id, $3 FROM UNNEST($2::BIGINT[]) AS id
+ON CONFLICT (backfill_id, message_id) DO NOTHING
+`, backfillID, messageIDs, responderID); err != nil {
+		return historyBackfill{}, err
+	}
+	if err := tx.QueryRowContext(ctx, `
+UPDATE history_backfills
+SET keys_delivered = (SELECT COUNT(*) FROM history_backfill_keys WHERE backfill_id = $1),
+    responses = responses + 1
+WHERE id = $1
+RETURNING keys_delivered, responses
+`, backfillID).Scan(&backfill.KeysDelivered, &backfill.Responses); err != nil {
+		return historyBackfill{}, err
+	}
+	if backfill.KeysDelivered >= backfill.MessageCount {
+		backfill.Status = historyBackfillCompleted
+		if err := tx.QueryRowContext(ctx, `
+UPDATE history_backfills
+SET status = 'completed', completed_at = NOW()
+WHERE id = $1
+RETURNING completed_at
+`, backfillID).Scan(&backfill.CompletedAt); err != nil {
+			return historyBackfill{}, err
+		}
+	}
+	return backfill, tx.Commit()
+}
+
+// requestHistoryKeys opens (or re-announces) the device's backfill, asks the
+// connected members to re-wrap their keys for it and reports the progress
+// back to the device.
+func (c *Client) requestHistoryKeys(ctx context.Context, incoming WSIncoming) error {
+	limit := incoming.Limit
+	if limit <= 0 || limit > maxHistoryBackfillMessages {
+		limit = maxHistoryBackfillMessages
+	}
+	backfill, err := c.app.openHistoryBackfill(ctx, c.roomID, c.userID, c.deviceID, limit)
+	if err != nil {
+		return err
+	}
+	backfill.RequesterUsername = c.username
+	if backfill.Status == historyBackfillOpen {
+		payload, err := backfill.requestFrame()
+		if err != nil {
+			return err
+		}
+		c.app.hub.BroadcastFrom(c.roomID, c.userID, payload)
+	}
+	c.sendHistoryBackfillProgress(backfill)
+	return nil
+}
+
+// relayHistoryKeys forwards one member's re-wrapped keys to the backfilling
+// device. The payload must be wrapped for that device alone; the server
+// checks the covered messages against the backfill but never sees the keys.
+func (c *Client) relayHistoryKeys(ctx context.Context, incoming WSIncoming, payload CipherPayload) error {
+	if incoming.BackfillID <= 0 || incoming.ToUserID <= 0 || incoming.ToUserID == c.userID {
+		return errHistoryBackfillInvalid
+	}
+	toDeviceID := normalizeDeviceID(incoming.ToDeviceID)
+	if toDeviceID == "" || len(payload.WrappedKeys) != 1 {
+		return errHistoryBackfillInvalid
+	}
+	if _, ok := payload.WrappedKeys[strconv.FormatInt(incoming.ToUserID, 10)+":"+toDeviceID]; !ok {
+		return errHistoryBackfillInvalid
+	}
+	messageIDs, err := normalizeHistoryKeyMessageIDs(incoming.MessageIDs)
+	if err != nil {
+		return err
+	}
+	if c.app.hub.blocks.Between(c.userID, incoming.ToUserID) {
+		return errHistoryBackfillInvalid
+	}
+	// Keys are not queued: a device that went away asks again on return.
+	if !c.app.hub.DeviceConnected(c.roomID, incoming.ToUserID, toDeviceID) {
+		return errHistoryBackfillOffline
+	}
+
+	backfill, err := c.app.loadHistoryBackfill(ctx, c.roomID, incoming.ToUserID, toDeviceID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errHistoryBackfillClosed
+		}
+		return err
+	}
+	if backfill.ID != incoming.BackfillID {
+		return errHistoryBackfillClosed
+	}
+	backfill, err = c.app.recordHistoryKeys(ctx, backfill.ID, c.roomID, c.userID, messageIDs)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(map[string]any{
+		"type":         "history_key_response",
+		"backfillId":   backfill.ID,
+		"roomId":       c.roomID,
+		"fromUserId":   c.userID,
+		"fromUsername": c.username,
+		"fromDeviceId": c.deviceID,
+		"toUserId":     incoming.ToUserID,
+		"toDeviceId":   toDeviceID,
+		"messageIds":   messageIDs,
+		"payload":      payload,
+		"progress":     backfill.progress(),
+	})
+	if err != nil {
+		return err
+	}
+	c.app.hub.UnicastToDevice(c.roomID, incoming.ToUserID, toDeviceID, out)
+	return nil
+}
+
+func (c *Client) sendHistoryBackfillProgress(backfill historyBackfill) {
+	frame := backfill.progress()
+	frame["type"] = "history_backfill_progress"
+	if payload, err := json.Marshal(frame); err == nil {
+		c.app.hub.UnicastToDevice(c.roomID, c.userID, c.deviceID, payload)
+	}
+}
+
+// handleRoomHistoryBackfill reports the calling device's backfill in the room,
+// or null when it has none.
+func (a *App) handleRoomHistoryBackfill(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "not a room member")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+		return
+	}
+	backfill, err := a.loadHistoryBackfill(ctx, roomID, auth.UserID, auth.DeviceID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondJSON(w, http.StatusOK, map[string]any{"roomId": roomID, "backfill": nil})
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load history backfill")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"roomId": roomID, "backfill": backfill.progress()})
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNormalizeHistoryKeyMessageIDs(t *testing.T) {
+	t.Parallel()
+
+	ids, err := normalizeHistoryKeyMessageIDs([]int64{5, 3, 5, 9})
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if len(ids) != 3 || ids[0] != 5 || ids[1] != 3 || ids[2] != 9 {
+		t.Fatalf("expected duplicates dropped in order, got %v", ids)
+	}
+
+	tooMany := make([]int64, maxHistoryKeysPerResponse+1)
+	for i := range tooMany {
+		tooMany[i] = int64(i + 1)
+	}
+	for _, invalid := range [][]int64{nil, {0}, {4, -1}, tooMany} {
+		if _, err := normalizeHistoryKeyMessageIDs(invalid); !errors.Is(err, errHistoryBackfillInvalid) {
+			t.Fatalf("expected %v to be rejected, got %v", invalid, err)
+		}
+	}
+}
+
+func TestRelayHistoryKeysRequiresSingleRecipient(t *testing.T) {
+	t.Parallel()
+
+	h := newWSHarness()
+	sender, _, _ := h.connect(t, 1, "device-a", 1)
+	incoming := WSIncoming{BackfillID: 4, ToUserID: 2, ToDeviceID: "device-b", MessageIDs: []int64{10}}
+	cases := map[string]CipherPayload{
+		"no keys": {},
+		"other device": {WrappedKeys: map[string]WrappedKey{
+			"2:device-c": {IV: "iv", WrappedKey: "key"},
+		}},
+		"extra device": {WrappedKeys: map[string]WrappedKey{
+			"2:device-b": {IV: "iv", WrappedKey: "key"},
+			"3:device-d": {IV: "iv", WrappedKey: "key"},
+		}},
+	}
+	for name, payload := range cases {
+		if err := sender.relayHistoryKeys(context.Background(), incoming, payload); !errors.Is(err, errHistoryBackfillInvalid) {
+			t.Fatalf("%s: expected invalid frame error, got %v", name, err)
+		}
+	}
+
+	// A well-formed response for a device that is not connected is refused
+	// before the database is consulted.
+	payload := CipherPayload{WrappedKeys: map[string]WrappedKey{"2:device-b": {IV: "iv", WrappedKey: "key"}}}
+	if err := sender.relayHistoryKeys(context.Background(), incoming, payload); !errors.Is(err, errHistoryBackfillOffline) {
+		t.Fatalf("expected offline error, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -8,7 +9,17 @@ import (
 )
 
 func NewHub() *Hub {
-	return &Hub{rooms: make(map[int64]map[*Client]struct{})}
+	hub := &Hub{
+		rooms:           make(map[int64]map[*Client]struct{}),
+		blocks:          newBlockList(),
+		calls:           newCallRegistry(),
+		presence:        newPresenceTracker(),
+		fanout:          newFanoutPool(defaultFanoutWorkers()),
+		fanoutThreshold: defaultFanoutThreshold,
+	}
+	hub.ctx, hub.stop = context.WithCancel(context.Background())
+	hub.typing = newTypingTracker(typingBroadcastDebounce, typingIdleExpiry, hub.broadcastTypingStatus)
+	return hub
 }
 
 func (h *Hub) AddClient(client *Client) []PeerSnapshot {
@@ -30,23 +41,51 @@ func (h *Hub) AddClient(client *Client) []PeerSnapshot {
 		peers = append(peers, PeerSnapshot{
 			UserID:              peer.userID,
 			Username:            peer.username,
+			DisplayName:         peer.getDisplayName(),
+			Presence:            h.presence.Status(peer.userID),
 			DeviceID:            peer.deviceID,
 			DeviceName:          peer.deviceName,
 			PublicKeyJWK:        pub,
 			SigningPublicKeyJWK: signing,
+			Signature:           peer.getAnnounceSignature(),
+			Online:              true,
 		})
 	}
 
+	h.lastConnID++
+	client.id = h.lastConnID
+	client.ctx, client.cancel = context.WithCancel(h.ctx)
 	roomClients[client] = struct{}{}
 	return peers
 }
 
 func (h *Hub) KickUserDevice(userID int64, deviceID string, code int, reason string) {
+	h.kick(func(client *Client) bool {
+		return client.userID == userID && client.deviceID == deviceID
+	}, code, reason)
+}
+
+// KickUser closes every connection the user holds, across all devices.
+func (h *Hub) KickUser(userID int64, code int, reason string) {
+	h.kick(func(client *Client) bool {
+		return client.userID == userID
+	}, code, reason)
+}
+
+// KickRoomGuests closes the room's guest connections, for when their links
+// are revoked or the room stops being announcement-only.
+func (h *Hub) KickRoomGuests(roomID int64, code int, reason string) {
+	h.kick(func(client *Client) bool {
+		return client.guest && client.roomID == roomID
+	}, code, reason)
+}
+
+func (h *Hub) kick(match func(*Client) bool, code int, reason string) {
 	h.mu.RLock()
 	targets := make([]*Client, 0, 4)
 	for _, roomClients := range h.rooms {
 		for client := range roomClients {
-			if client.userID == userID && client.deviceID == deviceID {
+			if match(client) {
 				targets = append(targets, client)
 			}
 		}
@@ -69,6 +108,9 @@ func (h *Hub) KickUserDevice(userID int64, deviceID string, code int, reason str
 }
 
 func (h *Hub) RemoveClient(client *Client) {
+	if client.cancel != nil {
+		client.cancel()
+	}
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
@@ -84,32 +126,64 @@ func (h *Hub) RemoveClient(client *Client) {
 }
 
 func (h *Hub) Broadcast(roomID int64, payload []byte) {
+	h.broadcast(roomID, payload, nil, nil)
+}
+
+// BroadcastFrom fans out a frame about fromUserID's own activity, skipping
+// recipients that fromUserID has blocked.
+func (h *Hub) BroadcastFrom(roomID, fromUserID int64, payload []byte) {
+	h.broadcast(roomID, payload, h.blockedBy(fromUserID), nil)
+}
+
+// BroadcastTracked is Broadcast for frames backed by a logged event; done
+// receives the report telling the caller who has to catch up through sync.
+// For rooms handed to the fan-out pool done runs on a worker, after the
+// call returned. A non-zero fromUserID applies BroadcastFrom's block filter.
+func (h *Hub) BroadcastTracked(roomID, fromUserID int64, payload []byte, done func(deliveryReport)) {
+	if fromUserID > 0 {
+		h.broadcast(roomID, payload, h.blockedBy(fromUserID), done)
+		return
+	}
+	h.broadcast(roomID, payload, nil, done)
+}
+
+func (h *Hub) blockedBy(fromUserID int64) func(*Client) bool {
+	return func(client *Client) bool {
+		return h.blocks.Blocks(fromUserID, client.userID)
+	}
+}
+
+// broadcast delivers inline for ordinary rooms and shards rooms above the
+// fan-out threshold across the worker pool, so a sender's read loop never
+// waits on thousands of queue writes.
+func (h *Hub) broadcast(roomID int64, payload []byte, skip func(*Client) bool, done func(deliveryReport)) {
+	var report deliveryReport
 	h.mu.RLock()
 	roomClients, ok := h.rooms[roomID]
 	if !ok {
 		h.mu.RUnlock()
+		if done != nil {
+			done(report)
+		}
 		return
 	}
 	clients := make([]*Client, 0, len(roomClients))
 	for client := range roomClients {
+		if skip != nil && skip(client) {
+			report.addSkipped(client.userID)
+			continue
+		}
 		clients = append(clients, client)
 	}
 	h.mu.RUnlock()
 
-	for _, client := range clients {
-		select {
-		case client.send <- payload:
-		default:
-			logger.Warn(
-				"websocket_broadcast_drop",
-				"user_id",
-				client.userID,
-				"room_id",
-				roomID,
-				"reason",
-				"send queue full",
-			)
-		}
+	if len(clients) > h.fanoutThreshold {
+		h.fanout.dispatch(roomID, payload, clients, report, done)
+		return
+	}
+	report.merge(deliverBroadcast(roomID, payload, clients))
+	if done != nil {
+		done(report)
 	}
 }
 
@@ -132,7 +206,7 @@ func (h *Hub) Unicast(roomID int64, userID int64, payload []byte) {
 		select {
 		case client.send <- payload:
 		default:
-			logger.Warn(
+			client.log().Warn(
 				"websocket_unicast_drop",
 				"user_id",
 				client.userID,
@@ -145,6 +219,36 @@ func (h *Hub) Unicast(roomID int64, userID int64, payload []byte) {
 	}
 }
 
+// SendToUser delivers a payload to every connection of a user across rooms.
+func (h *Hub) SendToUser(userID int64, payload []byte) {
+	h.mu.RLock()
+	targets := make([]*Client, 0, 4)
+	for _, roomClients := range h.rooms {
+		for client := range roomClients {
+			if client.userID == userID {
+				targets = append(targets, client)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range targets {
+		select {
+		case client.send <- payload:
+		default:
+			client.log().Warn(
+				"websocket_user_send_drop",
+				"user_id",
+				client.userID,
+				"room_id",
+				client.roomID,
+				"reason",
+				"send queue full",
+			)
+		}
+	}
+}
+
 func (h *Hub) UnicastToDevice(roomID int64, userID int64, deviceID string, payload []byte) {
 	trimmedDeviceID := normalizeDeviceID(deviceID)
 	if trimmedDeviceID == "" {
@@ -170,7 +274,7 @@ func (h *Hub) UnicastToDevice(roomID int64, userID int64, deviceID string, paylo
 		select {
 		case client.send <- payload:
 		default:
-			logger.Warn(
+			client.log().Warn(
 				"websocket_unicast_device_drop",
 				"user_id",
 				client.userID,
@@ -185,7 +289,98 @@ func (h *Hub) UnicastToDevice(roomID int64, userID int64, deviceID string, paylo
 	}
 }
 
+// SendToDevice delivers a payload to every connection a single device holds,
+// across rooms, and reports how many connections it reached.
+func (h *Hub) SendToDevice(userID int64, deviceID string, payload []byte) int {
+	h.mu.RLock()
+	targets := make([]*Client, 0, 2)
+	for _, roomClients := range h.rooms {
+		for client := range roomClients {
+			if client.userID == userID && client.deviceID == deviceID {
+				targets = append(targets, client)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	delivered := 0
+	for _, client := range targets {
+		select {
+		case client.send <- payload:
+			delivered++
+		default:
+			client.log().Warn(
+				"websocket_device_send_drop",
+				"user_id",
+				client.userID,
+				"device_id",
+				client.deviceID,
+				"room_id",
+				client.roomID,
+				"reason",
+				"send queue full",
+			)
+		}
+	}
+	return delivered
+}
+
+// DeviceConnected reports whether the device has a live connection to the
+// room. An empty deviceID matches any of the user's devices.
+func (h *Hub) DeviceConnected(roomID int64, userID int64, deviceID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.rooms[roomID] {
+		if client.userID == userID && (deviceID == "" || client.deviceID == deviceID) {
+			return true
+		}
+	}
+	return false
+}
+
+type hubConnectionStats struct {
+	Connections int `json:"connections"`
+	Rooms       int `json:"rooms"`
+	Users       int `json:"users"`
+	Guests      int `json:"guests"`
+	// AverageRTTMillis averages the rolling RTT of connections that have
+	// answered at least one heartbeat.
+	AverageRTTMillis float64 `json:"avgRttMs"`
+}
+
+func (h *Hub) ConnectionStats() hubConnectionStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	users := make(map[int64]struct{})
+	stats := hubConnectionStats{Rooms: len(h.rooms)}
+	var rttTotal float64
+	var measured int
+	for _, roomClients := range h.rooms {
+		stats.Connections += len(roomClients)
+		for client := range roomClients {
+			if latency := client.latency(); latency.Samples > 0 {
+				rttTotal += latency.AverageRTTMillis
+				measured++
+			}
+			if client.guest {
+				stats.Guests++
+				continue
+			}
+			users[client.userID] = struct{}{}
+		}
+	}
+	stats.Users = len(users)
+	if measured > 0 {
+		stats.AverageRTTMillis = rttTotal / float64(measured)
+	}
+	return stats
+}
+
+// Shutdown cancels every connection context, so frame handlers stuck in the
+// database return promptly, and then closes the connections.
 func (h *Hub) Shutdown() {
+	h.stop()
 	h.mu.Lock()
 	clients := make([]*Client, 0, len(h.rooms))
 	for _, roomClients := range h.rooms {
@@ -195,6 +390,7 @@ func (h *Hub) Shutdown() {
 	}
 	h.rooms = make(map[int64]map[*Client]struct{})
 	h.mu.Unlock()
+	h.typing.Stop()
 
 	deadline := time.Now().Add(1 * time.Second)
 	for _, client := range clients {
@@ -207,6 +403,41 @@ func (h *Hub) Shutdown() {
 	}
 }
 
+// SetDisplayName updates the display name live connections of userID report
+// in peer snapshots and message broadcasts.
+func (h *Hub) SetDisplayName(userID int64, displayName string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, roomClients := range h.rooms {
+		for client := range roomClients {
+			if client.userID == userID {
+				client.setDisplayName(displayName)
+			}
+		}
+	}
+}
+
+// context returns the connection's context, or Background for clients that
+// were never added to a hub.
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+func (c *Client) setDisplayName(displayName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.displayName = displayName
+}
+
+func (c *Client) getDisplayName() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.displayName
+}
+
 func (c *Client) setPublicKey(publicKey json.RawMessage) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -237,6 +468,22 @@ func (c *Client) getSigningPublicKey() json.RawMessage {
 	return append([]byte(nil), c.signingPublicKey...)
 }
 
+// setAnnouncedKeys replaces the keys together with the signature that vouches
+// for them.
+func (c *Client) setAnnouncedKeys(publicKey, signingKey json.RawMessage, signature string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.publicKey = append([]byte(nil), publicKey...)
+	c.signingPublicKey = append([]byte(nil), signingKey...)
+	c.announceSignature = signature
+}
+
+func (c *Client) getAnnounceSignature() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.announceSignature
+}
+
 func (c *Client) getAnnouncedKeys() (json.RawMessage, json.RawMessage) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"runtime"
+	"sync"
+)
+
+const (
+	// defaultFanoutThreshold is the number of recipients above which a
+	// broadcast leaves the caller's goroutine. Smaller rooms stay inline so
+	// their frames keep strict ordering with unicasts sent right after.
+	defaultFanoutThreshold = 256
+	fanoutQueueSize        = 1024
+)
+
+// fanoutJob delivers one shard of a broadcast.
+type fanoutJob struct {
+	roomID  int64
+	payload []byte
+	clients []*Client
+	done    func(deliveryReport)
+}
+
+// fanoutPool runs large broadcasts on a fixed set of workers. A connection is
+// always handled by the worker its id maps to and each worker drains its
+// queue in order, so one connection never sees two broadcasts reordered.
+type fanoutPool struct {
+	once    sync.Once
+	size    int
+	workers []chan fanoutJob
+}
+
+func newFanoutPool(size int) *fanoutPool {
+	if size < 2 {
+		size = 2
+	}
+	return &fanoutPool{size: size}
+}
+
+func defaultFanoutWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+func (p *fanoutPool) start() {
+	p.once.Do(func() {
+		p.workers = make([]chan fanoutJob, p.size)
+		for i := range p.workers {
+			queue := make(chan fanoutJob, fanoutQueueSize)
+			p.workers[i] = queue
+			go func() {
+				for job := range queue {
+					job.done(deliverBroadcast(job.roomID, job.payload, job.clients))
+				}
+			}()
+		}
+	})
+}
+
+// dispatch shards clients across the workers and calls done once with the
+// merged report after every shard finished. Enqueueing blocks only when a
+// worker's queue is full, which is the back-pressure we want.
+func (p *fanoutPool) dispatch(roomID int64, payload []byte, clients []*Client, base deliveryReport, done func(deliveryReport)) {
+	p.start()
+	shards := make([][]*Client, p.size)
+	for _, client := range clients {
+		index := int(uint64(client.id) % uint64(p.size))
+		shards[index] = append(shards[index], client)
+	}
+
+	pending := 0
+	for _, shard := range shards {
+		if len(shard) > 0 {
+			pending++
+		}
+	}
+	var mu sync.Mutex
+	merged := base
+	finish := func(report deliveryReport) {
+		mu.Lock()
+		merged.merge(report)
+		pending--
+		last := pending == 0
+		mu.Unlock()
+		if last && done != nil {
+			done(merged)
+		}
+	}
+	for index, shard := range shards {
+		if len(shard) == 0 {
+			continue
+		}
+		p.workers[index] <- fanoutJob{roomID: roomID, payload: payload, clients: shard, done: finish}
+	}
+}
+
+// deliverBroadcast queues payload on each client without blocking.
+func deliverBroadcast(roomID int64, payload []byte, clients []*Client) deliveryReport {
+	var report deliveryReport
+	for _, client := range clients {
+		select {
+		case client.send <- payload:
+			report.addDelivered(client.userID)
+		default:
+			report.addDropped(client.userID)
+			client.log().Warn(
+				"websocket_broadcast_drop",
+				"user_id",
+				client.userID,
+				"room_id",
+				roomID,
+				"reason",
+				"send queue full",
+			)
+		}
+	}
+	return report
+}
+
+func (r *deliveryReport) merge(other deliveryReport) {
+	for userID := range other.delivered {
+		r.addDelivered(userID)
+	}
+	for userID := range other.dropped {
+		r.addDropped(userID)
+	}
+	for userID := range other.skipped {
+		r.addSkipped(userID)
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBroadcastLargeRoomUsesFanoutPool(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	hub.fanoutThreshold = 4
+	clients := make([]*Client, 0, 10)
+	for i := int64(1); i <= 10; i++ {
+		size := 2
+		if i == 10 {
+			size = 0
+		}
+		client := &Client{userID: i, roomID: 5, send: make(chan []byte, size)}
+		hub.AddClient(client)
+		clients = append(clients, client)
+	}
+	hub.blocks.Add(1, 9)
+
+	reports := make(chan deliveryReport, 2)
+	hub.BroadcastTracked(5, 1, []byte(`{"n":1}`), func(r deliveryReport) { reports <- r })
+	hub.BroadcastTracked(5, 1, []byte(`{"n":2}`), func(r deliveryReport) { reports <- r })
+
+	for i := 0; i < 2; i++ {
+		select {
+		case report := <-reports:
+			settled := report.settled()
+			sort.Slice(settled, func(a, b int) bool { return settled[a] < settled[b] })
+			if len(settled) != 9 || settled[8] != 9 {
+				t.Fatalf("expected users 1-9 settled, got %v", settled)
+			}
+			if dropped := report.droppedUsers(); len(dropped) != 1 || dropped[0] != 10 {
+				t.Fatalf("expected user 10 dropped, got %v", dropped)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("fan-out did not report")
+		}
+	}
+	for _, client := range clients[:8] {
+		if first, second := string(<-client.send), string(<-client.send); first != `{"n":1}` || second != `{"n":2}` {
+			t.Fatalf("user %d got frames out of order: %s, %s", client.userID, first, second)
+		}
+	}
+	if len(clients[8].send) != 0 {
+		t.Fatal("expected blocked user to be skipped")
+	}
+}
+
+func TestDeliveryReportMerge(t *testing.T) {
+	t.Parallel()
+
+	var report deliveryReport
+	report.addDelivered(1)
+	var other deliveryReport
+	other.addDropped(1)
+	other.addSkipped(2)
+	report.merge(other)
+	if len(report.settled()) != 1 || len(report.droppedUsers()) != 1 {
+		t.Fatalf("unexpected merged report: settled=%v dropped=%v", report.settled(), report.droppedUsers())
+	}
+}
@@ -11,8 +11,7 @@ func TestHubAddClientAndPeerSnapshot(t *testing.T) {
 	hub := NewHub()
 
 	first := &Client{roomID: 1, userID: 1, username: "alice", send: make(chan []byte, 2)}
-	first.setPublicKey(json.RawMessage(`{"k":"pub-1"}`))
-	first.setSigningPublicKey(json.RawMessage(`{"k":"sig-1"}`))
+	first.setAnnouncedKeys(json.RawMessage(`{"k":"pub-1"}`), json.RawMessage(`{"k":"sig-1"}`), "announce-sig")
 	if peers := hub.AddClient(first); len(peers) != 0 {
 		t.Fatalf("expected no peers for first join, got %d", len(peers))
 	}
@@ -22,7 +21,7 @@ func TestHubAddClientAndPeerSnapshot(t *testing.T) {
 	if len(peers) != 1 {
 		t.Fatalf("expected 1 peer, got %d", len(peers))
 	}
-	if peers[0].UserID != 1 || peers[0].Username != "alice" {
+	if peers[0].UserID != 1 || peers[0].Username != "alice" || peers[0].Signature != "announce-sig" || !peers[0].Online {
 		t.Fatalf("unexpected peer snapshot: %+v", peers[0])
 	}
 }
@@ -109,3 +108,105 @@ func TestHubUnicastToDevice(t *testing.T) {
 	default:
 	}
 }
+
+func TestHubConnectionStats(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	hub.AddClient(&Client{roomID: 1, userID: 1, deviceID: "a", send: make(chan []byte, 1)})
+	hub.AddClient(&Client{roomID: 2, userID: 1, deviceID: "a", send: make(chan []byte, 1)})
+	hub.AddClient(&Client{roomID: 2, userID: 2, deviceID: "b", send: make(chan []byte, 1)})
+
+	stats := hub.ConnectionStats()
+	if stats.Connections != 3 || stats.Rooms != 2 || stats.Users != 2 {
+		t.Fatalf("unexpected connection stats: %+v", stats)
+	}
+}
+
+func TestHubBroadcastFromSkipsBlockedRecipients(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	alice := &Client{roomID: 7, userID: 1, username: "alice", send: make(chan []byte, 2)}
+	bob := &Client{roomID: 7, userID: 2, username: "bob", send: make(chan []byte, 2)}
+	hub.AddClient(alice)
+	hub.AddClient(bob)
+
+	hub.blocks.Add(1, 2)
+	if !hub.blocks.Between(2, 1) || hub.blocks.Blocks(2, 1) {
+		t.Fatalf("expected a one-way block visible through Between")
+	}
+
+	hub.BroadcastFrom(7, 1, []byte("typing"))
+	if got := <-alice.send; string(got) != "typing" {
+		t.Fatalf("unexpected alice payload: %q", string(got))
+	}
+	select {
+	case <-bob.send:
+		t.Fatalf("blocked user should not receive the blocker's activity")
+	default:
+	}
+
+	hub.BroadcastFrom(7, 2, []byte("receipt"))
+	if got := <-alice.send; string(got) != "receipt" {
+		t.Fatalf("blocker should still see the blocked user's activity, got %q", string(got))
+	}
+	<-bob.send
+
+	hub.blocks.Remove(1, 2)
+	hub.BroadcastFrom(7, 1, []byte("typing"))
+	<-alice.send
+	if got := <-bob.send; string(got) != "typing" {
+		t.Fatalf("expected delivery after unblock, got %q", string(got))
+	}
+}
+
+func TestHubSendToDeviceReachesEveryRoom(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	targetRoom7 := &Client{roomID: 7, userID: 2, deviceID: "device-b", send: make(chan []byte, 1)}
+	targetRoom8 := &Client{roomID: 8, userID: 2, deviceID: "device-b", send: make(chan []byte, 1)}
+	otherDevice := &Client{roomID: 7, userID: 2, deviceID: "device-c", send: make(chan []byte, 1)}
+	hub.AddClient(targetRoom7)
+	hub.AddClient(targetRoom8)
+	hub.AddClient(otherDevice)
+
+	if got := hub.SendToDevice(2, "device-b", []byte("session_reset")); got != 2 {
+		t.Fatalf("expected delivery to 2 connections, got %d", got)
+	}
+	<-targetRoom7.send
+	<-targetRoom8.send
+	select {
+	case <-otherDevice.send:
+		t.Fatalf("other device should not receive the frame")
+	default:
+	}
+
+	if got := hub.SendToDevice(2, "device-x", []byte("session_reset")); got != 0 {
+		t.Fatalf("expected no delivery to an offline device, got %d", got)
+	}
+}
+
+func TestClientContextEndsWithConnection(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	leaving := &Client{userID: 1, roomID: 3, send: make(chan []byte, 1)}
+	staying := &Client{userID: 2, roomID: 3, send: make(chan []byte, 1)}
+	hub.AddClient(leaving)
+	hub.AddClient(staying)
+
+	hub.RemoveClient(leaving)
+	if leaving.context().Err() == nil {
+		t.Fatal("expected context of a removed client to be cancelled")
+	}
+	if staying.context().Err() != nil {
+		t.Fatal("expected other connections to keep their context")
+	}
+
+	hub.stop()
+	if staying.context().Err() == nil {
+		t.Fatal("expected hub shutdown to cancel connection contexts")
+	}
+}
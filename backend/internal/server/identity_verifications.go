@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type identityVerification struct {
+	Verified   bool
+	VerifiedAt *string
+}
+
+// loadIdentityVerification reports whether verifierID marked the target's
+// given fingerprint as verified. A mark for an older fingerprint does not
+// count.
+func (a *App) loadIdentityVerification(ctx context.Context, verifierID, targetUserID int64, fingerprint string) (identityVerification, error) {
+	var verifiedAt time.Time
+	err := a.db.QueryRowContext(ctx, `
+SELECT verified_at
+FROM identity_verifications
+WHERE verifier_id = $1 AND target_user_id = $2 AND fingerprint = $3
+`, verifierID, targetUserID, fingerprint).Scan(&verifiedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return identityVerification{}, nil
+	}
+	if err != nil {
+		return identityVerification{}, err
+	}
+	value := verifiedAt.UTC().Format(time.RFC3339Nano)
+	return identityVerification{Verified: true, VerifiedAt: &value}, nil
+}
+
+// clearIdentityVerificationsTx drops marks on a fingerprint that just stopped
+// being current and returns the users who had verified it.
+func clearIdentityVerificationsTx(ctx context.Context, tx *sql.Tx, targetUserID int64, fingerprint string) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+DELETE FROM identity_verifications
+WHERE target_user_id = $1 AND fingerprint = $2
+RETURNING verifier_id
+`, targetUserID, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	verifiers := make([]int64, 0, 4)
+	for rows.Next() {
+		var verifierID int64
+		if err := rows.Scan(&verifierID); err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, verifierID)
+	}
+	return verifiers, rows.Err()
+}
+
+// notifyVerificationsCleared tells each former verifier, on every connection,
+// that the identity they verified is gone.
+func (a *App) notifyVerificationsCleared(change identityKeyChange) {
+	for _, verifierID := range change.ClearedVerifiers {
+		payload, err := json.Marshal(map[string]any{
+			"type":                "identity_verification_cleared",
+			"userId":              change.UserID,
+			"username":            change.Username,
+			"previousFingerprint": change.PreviousFingerprint,
+			"fingerprint":         change.Fingerprint,
+		})
+		if err != nil {
+			continue
+		}
+		a.hub.SendToUser(verifierID, payload)
+	}
+}
+
+func (a *App) handleSignalVerificationSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "api" || parts[1] != "signal" || parts[2] != "verifications" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	targetUserID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || targetUserID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if targetUserID == auth.UserID {
+		respondError(w, http.StatusBadRequest, "cannot verify yourself")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Fingerprint string `json:"fingerprint"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		req.Fingerprint = strings.TrimSpace(req.Fingerprint)
+		if req.Fingerprint == "" {
+			respondError(w, http.StatusBadRequest, "fingerprint is required")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if err := a.ensureSharedRoom(ctx, auth.UserID, targetUserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusForbidden, "target user is not in any shared room")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to validate room relationship")
+			return
+		}
+		identityKey, _, err := a.loadSafetyNumberIdentity(ctx, targetUserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "target identity key is not published")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to load target identity")
+			return
+		}
+		currentFingerprint, err := keyFingerprint(identityKey)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to fingerprint target identity")
+			return
+		}
+		// The client must echo the fingerprint it compared, so a key that
+		// rotated while the user was comparing is never marked verified.
+		if req.Fingerprint != currentFingerprint {
+			respondErrorDetails(w, http.StatusConflict, "fingerprint_mismatch", "fingerprint does not match the target's current identity key", map[string]any{
+				"currentFingerprint": currentFingerprint,
+			})
+			return
+		}
+
+		var verifiedAt time.Time
+		err = a.db.QueryRowContext(ctx, `
+INSERT INTO identity_verifications(verifier_id, target_user_id, fingerprint, verified_at)
+VALUES ($1, $2, $3, NOW())
+ON CONFLICT (verifier_id, target_user_id) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint,
+    verified_at = EXCLUDED.verified_at
+RETURNING verified_at
+`, auth.UserID, targetUserID, currentFingerprint).Scan(&verifiedAt)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to store verification")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{
+			"targetUserId": targetUserID,
+			"fingerprint":  currentFingerprint,
+			"verified":     true,
+			"verifiedAt":   verifiedAt.UTC().Format(time.RFC3339Nano),
+		})
+
+	case http.MethodDelete:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if _, err := a.db.ExecContext(ctx,
+			`DELETE FROM identity_verifications WHERE verifier_id = $1 AND target_user_id = $2`,
+			auth.UserID, targetUserID,
+		); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to clear verification")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"targetUserId": targetUserID, "verified": false})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
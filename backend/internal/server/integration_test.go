@@ -0,0 +1,393 @@
+//go:build integration
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"golang.org/x/crypto/bcrypt"
+
+	"e2ee-chat/backend/client"
+)
+
+// The integration suite boots the whole App against a throwaway Postgres
+// container. Run it with:
+//
+//	go test -tags integration -run Integration ./internal/server/
+//
+// It skips when no Docker daemon is reachable, except under CI. Setting
+// INTEGRATION_SQLITE=1 runs the same suite against the SQLite backend instead.
+
+const (
+	integrationDBPassword    = "integration-db-secret"
+	integrationAdminPassword = "integration-admin-password"
+)
+
+type integrationHarness struct {
+	app    *App
+	db     *sql.DB
+	server *httptest.Server
+}
+
+func newIntegrationHarness(t *testing.T) *integrationHarness {
+	t.Helper()
+
+	dsn := integrationDatabaseURL(t)
+	db, err := openDatabase(dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := runMigrations(db); err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(integrationAdminPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash admin password: %v", err)
+	}
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("DATABASE_URL", dsn)
+	t.Setenv("JWT_SECRET", "integration-jwt-secret-0123456789abcdef")
+	t.Setenv("ADMIN_USERNAME", "admin")
+	t.Setenv("ADMIN_PASSWORD_HASH", string(hash))
+	t.Setenv("CORS_ORIGIN", "http://localhost:8088")
+	cfg, err := loadRuntimeConfig()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if err := bootstrapAdminSecurity(db, cfg.AdminUsername, cfg.AdminUsernames, cfg.AdminPasswordHash, cfg.AdminRoomName); err != nil {
+		t.Fatalf("bootstrap admin: %v", err)
+	}
+
+	app := newApp(db, cfg)
+	ctx := context.Background()
+	if err := app.ipDenylist.Start(ctx); err != nil {
+		t.Fatalf("start ip denylist: %v", err)
+	}
+	t.Cleanup(app.ipDenylist.Stop)
+	if err := app.features.Start(ctx); err != nil {
+		t.Fatalf("start feature flags: %v", err)
+	}
+	t.Cleanup(app.features.Stop)
+	if err := app.hub.blocks.Load(ctx, db); err != nil {
+		t.Fatalf("load blocks: %v", err)
+	}
+	app.messages = newMessagePipeline(app.flushMessageBatch, cfg.MessageBatchSize, cfg.MessageBatchMaxLatency)
+	t.Cleanup(app.messages.Close)
+
+	server := httptest.NewServer(app.routes())
+	t.Cleanup(func() {
+		app.hub.Shutdown()
+		server.Close()
+	})
+	return &integrationHarness{app: app, db: db, server: server}
+}
+
+// integrationDatabaseURL starts a Postgres container, or with
+// INTEGRATION_SQLITE=1 set uses a database file in the test's temp dir.
+func integrationDatabaseURL(t *testing.T) string {
+	t.Helper()
+
+	if os.Getenv("INTEGRATION_SQLITE") == "1" {
+		return "sqlite://" + filepath.Join(t.TempDir(), "chat.db")
+	}
+	// CI must run the suite, so a missing daemon fails there.
+	unavailable := t.Skipf
+	if os.Getenv("CI") != "" {
+		unavailable = t.Fatalf
+	}
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		unavailable("docker unavailable: %v", err)
+	}
+	if err := pool.Client.Ping(); err != nil {
+		unavailable("docker unavailable: %v", err)
+	}
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "postgres",
+		Tag:        "16-alpine",
+		Env: []string{
+			"POSTGRES_USER=chat",
+			"POSTGRES_PASSWORD=" + integrationDBPassword,
+			"POSTGRES_DB=chat",
+		},
+	}, func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		t.Fatalf("start postgres: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Purge(resource); err != nil {
+			t.Logf("purge postgres: %v", err)
+		}
+	})
+	// A crashed run must not leave the container behind for long.
+	_ = resource.Expire(600)
+
+	dsn := fmt.Sprintf("postgres://chat:%s@%s/chat?sslmode=disable", integrationDBPassword, resource.GetHostPort("5432/tcp"))
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+	pool.MaxWait = 2 * time.Minute
+	if err := pool.Retry(db.Ping); err != nil {
+		t.Fatalf("postgres not ready: %v", err)
+	}
+	return dsn
+}
+
+// signIn returns a client signed in as username, creating the account
+// through the admin API first when admin is given.
+func (h *integrationHarness) signIn(t *testing.T, ctx context.Context, admin *client.Client, username, password string) (*client.Client, *client.Session) {
+	t.Helper()
+	if admin != nil {
+		err := admin.Do(ctx, http.MethodPost, "/api/admin/users", map[string]string{
+			"username": username,
+			"password": password,
+			"role":     "user",
+		}, nil)
+		if err != nil {
+			t.Fatalf("create %s: %v", username, err)
+		}
+	}
+	c, err := client.New(h.server.URL, client.WithDeviceName(username+" integration"))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	session, err := c.Login(ctx, username, password)
+	if err != nil {
+		t.Fatalf("login %s: %v", username, err)
+	}
+	return c, session
+}
+
+func TestIntegrationRoomMessageFlow(t *testing.T) {
+	h := newIntegrationHarness(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	admin, _ := h.signIn(t, ctx, nil, "admin", integrationAdminPassword)
+	alice, aliceSession := h.signIn(t, ctx, admin, "alice", "alice-password-1")
+	bob, bobSession := h.signIn(t, ctx, admin, "bob", "bob-password-1")
+
+	room, err := alice.CreateRoom(ctx, "integration")
+	if err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	var invite struct {
+		InviteToken string `json:"inviteToken"`
+	}
+	if err := alice.Do(ctx, http.MethodPost, fmt.Sprintf("/api/rooms/%d/invite", room.ID), nil, &invite); err != nil {
+		t.Fatalf("issue invite: %v", err)
+	}
+	if joined, err := bob.JoinInvite(ctx, invite.InviteToken); err != nil || joined.ID != room.ID {
+		t.Fatalf("join invite = %+v, %v", joined, err)
+	}
+
+	bobPeers := make(chan client.PeersEvent, 1)
+	bobMessages := make(chan client.CiphertextEvent, 1)
+	bobConn := bob.Room(room.ID, client.Handlers{
+		OnPeers:      func(e client.PeersEvent) { bobPeers <- e },
+		OnCiphertext: func(e client.CiphertextEvent) { bobMessages <- e },
+	})
+	alicePeers := make(chan client.PeersEvent, 1)
+	aliceErrors := make(chan client.ProtocolErrorEvent, 1)
+	aliceConn := alice.Room(room.ID, client.Handlers{
+		OnPeers:         func(e client.PeersEvent) { alicePeers <- e },
+		OnProtocolError: func(e client.ProtocolErrorEvent) { aliceErrors <- e },
+	})
+	go func() { _ = bobConn.Run(ctx) }()
+	waitFor(t, ctx, bobPeers)
+	go func() { _ = aliceConn.Run(ctx) }()
+	waitFor(t, ctx, alicePeers)
+
+	signingKey, signingJWK := makeECDSAP256JWK(t)
+	_, publicJWK := makeECDSAP256JWK(t)
+	announce, err := canonicalKeyAnnouncePayload(aliceSession.User.ID, aliceSession.Device.DeviceID, publicJWK, signingJWK)
+	if err != nil {
+		t.Fatalf("canonical key announce: %v", err)
+	}
+	if err := aliceConn.Send(WSIncoming{
+		Type:                "key_announce",
+		PublicKeyJWK:        publicJWK,
+		SigningPublicKeyJWK: signingJWK,
+		Signature:           signWithECDSA(t, signingKey, announce),
+	}); err != nil {
+		t.Fatalf("announce keys: %v", err)
+	}
+
+	payload := CipherPayload{
+		Version:    3,
+		Ciphertext: "aW50ZWdyYXRpb24tY2lwaGVydGV4dA==",
+		MessageIV:  "aW50ZWdyYXRpb24taXY=",
+		WrappedKeys: map[string]WrappedKey{
+			fmt.Sprintf("%d:%s", bobSession.User.ID, bobSession.Device.DeviceID): {
+				IV: "d3JhcC1pdg==", WrappedKey: "d3JhcC1rZXk=", MessageNumber: 1, SessionVersion: 1,
+			},
+		},
+		SenderPublicJWK:     publicJWK,
+		SenderSigningPubJWK: signingJWK,
+		ContentType:         "text/plain",
+		SenderDeviceID:      aliceSession.Device.DeviceID,
+		EncryptionScheme:    encryptionSchemeDoubleRatchet,
+		Nonce:               "integration-nonce-0001",
+		SignedAt:            time.Now().UnixMilli(),
+	}
+	canonical, err := canonicalSignaturePayload(payload)
+	if err != nil {
+		t.Fatalf("canonical payload: %v", err)
+	}
+	payload.Signature = signWithECDSA(t, signingKey, canonical)
+	if err := aliceConn.Send(WSIncoming{
+		Type:                "ciphertext",
+		Version:             payload.Version,
+		Ciphertext:          payload.Ciphertext,
+		MessageIV:           payload.MessageIV,
+		WrappedKeys:         payload.WrappedKeys,
+		SenderPublicJWK:     payload.SenderPublicJWK,
+		SenderSigningPubJWK: payload.SenderSigningPubJWK,
+		Signature:           payload.Signature,
+		ContentType:         payload.ContentType,
+		SenderDeviceID:      payload.SenderDeviceID,
+		EncryptionScheme:    payload.EncryptionScheme,
+		Nonce:               payload.Nonce,
+		SignedAt:            payload.SignedAt,
+	}); err != nil {
+		t.Fatalf("send ciphertext: %v", err)
+	}
+
+	var delivered client.CiphertextEvent
+	select {
+	case delivered = <-bobMessages:
+	case frame := <-aliceErrors:
+		t.Fatalf("ciphertext rejected: %+v", frame)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the ciphertext broadcast")
+	}
+	if delivered.SenderID != aliceSession.User.ID || delivered.RoomID != room.ID || delivered.Seq != 1 {
+		t.Fatalf("unexpected broadcast: %+v", delivered)
+	}
+
+	page, err := bob.Messages(ctx, room.ID, client.HistoryOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].ID != delivered.ID {
+		t.Fatalf("unexpected history: %+v", page.Messages)
+	}
+	var stored CipherPayload
+	if err := json.Unmarshal(page.Messages[0].Payload, &stored); err != nil {
+		t.Fatalf("decode stored payload: %v", err)
+	}
+	if stored.Ciphertext != payload.Ciphertext || stored.Signature != payload.Signature {
+		t.Fatalf("stored payload differs from what was sent: %+v", stored)
+	}
+}
+
+func TestIntegrationAccountErasure(t *testing.T) {
+	h := newIntegrationHarness(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	admin, _ := h.signIn(t, ctx, nil, "admin", integrationAdminPassword)
+	carol, carolSession := h.signIn(t, ctx, admin, "carol", "carol-password-1")
+	userID := carolSession.User.ID
+
+	openRoom, err := carol.CreateRoom(ctx, "erasure-open")
+	if err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	heldRoom, err := carol.CreateRoom(ctx, "erasure-held")
+	if err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	if _, err := h.db.ExecContext(ctx, `UPDATE rooms SET legal_hold_at = NOW(), legal_hold_reason = 'test' WHERE id = $1`, heldRoom.ID); err != nil {
+		t.Fatalf("hold room: %v", err)
+	}
+	messageIDs := make(map[int64]int64, 2)
+	for _, roomID := range []int64{openRoom.ID, heldRoom.ID} {
+		tx, err := h.db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		stamp, err := insertMessageTx(ctx, tx, roomID, userID, []byte(`{"ciphertext":"c2VjcmV0"}`), nil)
+		if err != nil {
+			t.Fatalf("insert message: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		messageIDs[roomID] = stamp.ID
+	}
+	if _, err := h.db.ExecContext(ctx, `UPDATE users SET erasure_scheduled_at = NOW() - INTERVAL '1 minute' WHERE id = $1`, userID); err != nil {
+		t.Fatalf("schedule erasure: %v", err)
+	}
+
+	erased, err := newAccountErasureJob(h.app).Run(ctx)
+	if err != nil || erased != 1 {
+		t.Fatalf("erasure run = %d, %v", erased, err)
+	}
+
+	var erasedAt sql.NullTime
+	var email sql.NullString
+	if err := h.db.QueryRowContext(ctx, `SELECT erased_at, email FROM users WHERE id = $1`, userID).Scan(&erasedAt, &email); err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	if !erasedAt.Valid || email.Valid {
+		t.Fatalf("account not erased: erased_at=%v email=%v", erasedAt, email)
+	}
+	var devices, identityKeys int
+	if err := h.db.QueryRowContext(ctx, `
+SELECT (SELECT COUNT(*) FROM user_devices WHERE user_id = $1),
+       (SELECT COUNT(*) FROM signal_device_identity_keys WHERE user_id = $1)
+`, userID).Scan(&devices, &identityKeys); err != nil {
+		t.Fatalf("count devices: %v", err)
+	}
+	if devices != 0 || identityKeys != 0 {
+		t.Fatalf("devices=%d identityKeys=%d remain after erasure", devices, identityKeys)
+	}
+	for roomID, messageID := range messageIDs {
+		var payload string
+		var revoked bool
+		if err := h.db.QueryRowContext(ctx,
+			`SELECT payload::text, revoked_at IS NOT NULL FROM messages WHERE id = $1`, messageID,
+		).Scan(&payload, &revoked); err != nil {
+			t.Fatalf("load message: %v", err)
+		}
+		if roomID == heldRoom.ID {
+			if revoked || payload == "{}" {
+				t.Fatalf("held message was erased: payload=%s revoked=%v", payload, revoked)
+			}
+			continue
+		}
+		if !revoked || payload != "{}" {
+			t.Fatalf("message not erased: payload=%s revoked=%v", payload, revoked)
+		}
+	}
+}
+
+func waitFor[T any](t *testing.T, ctx context.Context, ch <-chan T) T {
+	t.Helper()
+	select {
+	case value := <-ch:
+		return value
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for %T", *new(T))
+		panic("unreachable")
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	adminRoutePrefix          = "/api/admin/"
+	ipDenylistRefreshInterval = 30 * time.Second
+)
+
+// parseCIDRList reads a comma-separated list of CIDRs. Bare addresses are
+// taken as single-host prefixes.
+func parseCIDRList(raw string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := parseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func parseCIDR(value string) (netip.Prefix, error) {
+	if !strings.Contains(value, "/") {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address %q", value)
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", value)
+	}
+	return prefix.Masked(), nil
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr resolves the request's client address with the same proxy rules
+// as rate limiting.
+func (a *App) clientAddr(r *http.Request) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(clientKeyFromRequest(r, a.trustProxyHeaders))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// ipDenylist caches the ip_denylist table in memory so admin requests are not
+// each charged a query. It refreshes on a timer and after every change made
+// through the admin API.
+type ipDenylist struct {
+	db       *sql.DB
+	prefixes atomic.Pointer[[]netip.Prefix]
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newIPDenylist(db *sql.DB) *ipDenylist {
+	return &ipDenylist{
+		db:   db,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (d *ipDenylist) Contains(addr netip.Addr) bool {
+	if d == nil {
+		return false
+	}
+	prefixes := d.prefixes.Load()
+	return prefixes != nil && prefixesContain(*prefixes, addr)
+}
+
+func (d *ipDenylist) Refresh(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	rows, err := d.db.QueryContext(ctx, `
+SELECT cidr::text FROM ip_denylist WHERE expires_at IS NULL OR expires_at > NOW()
+`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	prefixes := make([]netip.Prefix, 0, 16)
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return err
+		}
+		prefix, err := parseCIDR(raw)
+		if err != nil {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	d.prefixes.Store(&prefixes)
+	return nil
+}
+
+// Start loads the list once synchronously so the first requests are already
+// filtered, then keeps it fresh in the background.
+func (d *ipDenylist) Start(ctx context.Context) error {
+	if err := d.Refresh(ctx); err != nil {
+		return err
+	}
+	go d.run()
+	return nil
+}
+
+func (d *ipDenylist) Stop() {
+	if d == nil {
+		return
+	}
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+	<-d.done
+}
+
+func (d *ipDenylist) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(ipDenylistRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := d.Refresh(ctx); err != nil {
+			logger.Warn("ip_denylist_refresh_failed", "error", err)
+		}
+		cancel()
+	}
+}
+
+// withAdminIPFilter rejects admin requests from addresses outside the
+// configured allowlist or on the denylist. It runs before authentication so
+// blocked addresses never reach token parsing.
+func (a *App) withAdminIPFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, adminRoutePrefix) && r.URL.Path != strings.TrimSuffix(adminRoutePrefix, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !a.adminAddressAllowed(r) {
+			requestLogger(r.Context()).Warn("admin_ip_rejected", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			respondErrorCode(w, http.StatusForbidden, "ip_denied", "access denied from this address")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *App) adminAddressAllowed(r *http.Request) bool {
+	if len(a.adminAllowlist) == 0 && a.ipDenylist == nil {
+		return true
+	}
+	addr, ok := a.clientAddr(r)
+	if !ok {
+		return false
+	}
+	if len(a.adminAllowlist) > 0 && !prefixesContain(a.adminAllowlist, addr) {
+		return false
+	}
+	return !a.ipDenylist.Contains(addr)
+}
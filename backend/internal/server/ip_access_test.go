@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	t.Parallel()
+
+	prefixes, err := parseCIDRList(" 10.0.0.0/8, 203.0.113.7 ,2001:db8::/32,")
+	if err != nil {
+		t.Fatalf("parseCIDRList: %v", err)
+	}
+	if len(prefixes) != 3 || prefixes[1].String() != "203.0.113.7/32" {
+		t.Fatalf("unexpected prefixes: %v", prefixes)
+	}
+	if prefix, err := parseCIDR("10.1.2.3/8"); err != nil || prefix.String() != "10.0.0.0/8" {
+		t.Fatalf("expected host bits to be masked, got %v %v", prefix, err)
+	}
+	if _, err := parseCIDRList("10.0.0.0/8,not-an-ip"); err == nil {
+		t.Fatalf("expected invalid entry to be rejected")
+	}
+}
+
+func TestWithAdminIPFilter(t *testing.T) {
+	t.Parallel()
+
+	denylist := newIPDenylist(nil)
+	denied := []netip.Prefix{netip.MustParsePrefix("10.0.5.0/24")}
+	denylist.prefixes.Store(&denied)
+	app := &App{
+		adminAllowlist: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")},
+		ipDenylist:     denylist,
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	cases := []struct {
+		path       string
+		remoteAddr string
+		expected   int
+	}{
+		{path: "/api/admin/users", remoteAddr: "10.0.1.2:4000", expected: http.StatusNoContent},
+		{path: "/api/admin/users", remoteAddr: "192.0.2.1:4000", expected: http.StatusForbidden},
+		{path: "/api/admin/stats", remoteAddr: "10.0.5.9:4000", expected: http.StatusForbidden},
+		{path: "/api/rooms", remoteAddr: "192.0.2.1:4000", expected: http.StatusNoContent},
+	}
+	for _, item := range cases {
+		request := httptest.NewRequest(http.MethodGet, item.path, nil)
+		request.RemoteAddr = item.remoteAddr
+		response := httptest.NewRecorder()
+		app.withAdminIPFilter(next).ServeHTTP(response, request)
+		if response.Code != item.expected {
+			t.Fatalf("%s from %s: expected %d, got %d", item.path, item.remoteAddr, item.expected, response.Code)
+		}
+	}
+}
+
+func TestAdminIPDenylistRejectsSelfLockout(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	request := httptest.NewRequest(http.MethodPost, "/api/admin/ip-denylist", strings.NewReader(`{"cidr":"192.0.2.0/24"}`))
+	request.RemoteAddr = "192.0.2.10:5000"
+	response := httptest.NewRecorder()
+	app.handleAdminIPDenylist(response, request, AuthContext{UserID: 1, Role: "admin"})
+	if response.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", response.Code)
+	}
+	if payload := decodeBodyMap(t, response); payload["code"] != "self_lockout" {
+		t.Fatalf("unexpected payload: %#v", payload)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanonicalJSONMatchesRFC8785(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			// RFC 8785 section 3.2.2 example.
+			name: "rfc example",
+			in:   `{"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001], "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/", "literals": [null, true, false]}`,
+			want: `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		{
+			// RFC 8785 section 3.2.3 sorting example: UTF-16 order puts the
+			// surrogate pair before U+FB33 although UTF-8 order would not.
+			name: "utf16 ordering",
+			in:   `{"\u20ac":"Euro Sign","\r":"Carriage Return","\ufb33":"Hebrew Letter Dalet With Dagesh","1":"One","\ud83d\ude00":"Emoji: Grinning Face","\u0080":"Control","\u00f6":"Latin Small Letter O With Diaeresis"}`,
+			want: "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001F600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+		},
+		{
+			name: "no html escaping",
+			in:   `{"b":"<a href='x'>&</a>","a":"\u2028"}`,
+			want: "{\"a\":\"\u2028\",\"b\":\"<a href='x'>&</a>\"}",
+		},
+		{
+			name: "nested",
+			in:   ` { "z" : { "b" : [ 1 , -0 , 1e21 , 1e-7 ] , "a" : {} } , "y" : [] } `,
+			want: `{"y":[],"z":{"a":{},"b":[1,0,1e+21,1e-7]}}`,
+		},
+	}
+	for _, tc := range cases {
+		got, err := canonicalJSON([]byte(tc.in))
+		if err != nil {
+			t.Fatalf("%s: canonicalJSON: %v", tc.name, err)
+		}
+		if string(got) != tc.want {
+			t.Fatalf("%s:\n got %s\nwant %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCanonicalJSONRejectsInvalidInput(t *testing.T) {
+	t.Parallel()
+
+	for _, in := range []string{``, `{`, `{} {}`, `[1e400]`} {
+		if _, err := canonicalJSON([]byte(in)); err == nil {
+			t.Fatalf("expected %q to be rejected", in)
+		}
+	}
+}
+
+func TestCanonicalSignaturePayloadJCS(t *testing.T) {
+	privateKey, signingJWK := makeECDSAP256JWK(t)
+	payload := CipherPayload{
+		Version:    3,
+		Ciphertext: "ciphertext-value",
+		MessageIV:  "iv-value",
+		WrappedKeys: map[string]WrappedKey{
+			"7:device-b": {IV: "wrap-iv", WrappedKey: "wrap-key", MessageNumber: 1, SessionVersion: 1},
+		},
+		SenderPublicJWK:           signingJWK,
+		SenderSigningPubJWK:       signingJWK,
+		ContentType:               "text/plain; charset=<utf-8>",
+		SenderDeviceID:            "device-a",
+		EncryptionScheme:          encryptionSchemeDoubleRatchet,
+		SignatureCanonicalization: signatureCanonicalizationJCS,
+	}
+
+	canonical, err := canonicalSignaturePayload(payload)
+	if err != nil {
+		t.Fatalf("canonical signature payload: %v", err)
+	}
+	if again, err := canonicalJSON(canonical); err != nil || string(again) != string(canonical) {
+		t.Fatalf("expected canonical payload to already be in JCS form: %s", canonical)
+	}
+	payload.Signature = signWithECDSA(t, privateKey, canonical)
+	if err := verifyCipherSignature(payload); err != nil {
+		t.Fatalf("verify jcs signature: %v", err)
+	}
+
+	// Stripping the declaration must not let the signature verify under the
+	// legacy form.
+	downgraded := payload
+	downgraded.SignatureCanonicalization = ""
+	if err := verifyCipherSignature(downgraded); err == nil {
+		t.Fatal("expected downgraded payload to fail verification")
+	}
+
+	unknown := payload
+	unknown.SignatureCanonicalization = "c14n"
+	if _, err := canonicalSignaturePayload(unknown); !errors.Is(err, errUnsupportedCanonicalization) {
+		t.Fatalf("expected unsupported canonicalization error, got %v", err)
+	}
+	if err := validateV3CipherPayload(unknown); !errors.Is(err, errInvalidPayloadFormat) {
+		t.Fatalf("expected invalid payload format, got %v", err)
+	}
+}
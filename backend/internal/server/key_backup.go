@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxKeyBackupCiphertextBytes = 512 << 10
+	minKeyBackupHashLength      = 16
+	maxKeyBackupHashLength      = 128
+	keyBackupVerificationHeader = "X-Key-Backup-Verification"
+)
+
+var errKeyBackupVersionConflict = errors.New("key backup version conflict")
+
+// validateKeyBackupUpload checks the shape of an opaque backup. The ciphertext
+// is sealed under a key derived from the user's recovery secret and the
+// verification hash is derived from the same secret, so neither tells the
+// server anything about the material inside.
+func validateKeyBackupUpload(version int64, ciphertext, verificationHash string) error {
+	if version <= 0 {
+		return errors.New("version must be positive")
+	}
+	if ciphertext == "" {
+		return errors.New("ciphertext is required")
+	}
+	if len(ciphertext) > base64.StdEncoding.EncodedLen(maxKeyBackupCiphertextBytes) {
+		return errors.New("ciphertext is too large")
+	}
+	if _, err := base64.StdEncoding.DecodeString(ciphertext); err != nil {
+		return errors.New("ciphertext must be base64")
+	}
+	if len(verificationHash) < minKeyBackupHashLength || len(verificationHash) > maxKeyBackupHashLength {
+		return errors.New("verificationHash must be between 16 and 128 characters")
+	}
+	return nil
+}
+
+// storeKeyBackup replaces the user's backup only when version is exactly one
+// past the stored one (or 1 for a first upload), so two devices racing to
+// upload cannot silently overwrite each other. On conflict it returns the
+// stored version.
+func (a *App) storeKeyBackup(ctx context.Context, userID, version int64, ciphertext, verificationHash string) (int64, time.Time, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var current int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT version FROM account_key_backups WHERE user_id = $1 FOR UPDATE`,
+		userID,
+	).Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, err
+	}
+	if version != current+1 {
+		return current, time.Time{}, errKeyBackupVersionConflict
+	}
+
+	var updatedAt time.Time
+	err = tx.QueryRowContext(ctx, `
+INSERT INTO account_key_backups(user_id, version, ciphertext, verification_hash, updated_at)
+VALUES ($1, $2, $3, $4, NOW())
+ON CONFLICT (user_id) DO UPDATE
+SET version = EXCLUDED.version,
+    ciphertext = EXCLUDED.ciphertext,
+    verification_hash = EXCLUDED.verification_hash,
+    updated_at = EXCLUDED.updated_at
+RETURNING updated_at
+`, userID, version, ciphertext, verificationHash).Scan(&updatedAt)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, time.Time{}, err
+	}
+	return version, updatedAt, nil
+}
+
+func (a *App) handleAccountKeyBackup(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	switch r.Method {
+	case http.MethodGet:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		var version int64
+		var ciphertext, verificationHash string
+		var updatedAt time.Time
+		err := a.db.QueryRowContext(ctx, `
+SELECT version, ciphertext, verification_hash, updated_at
+FROM account_key_backups
+WHERE user_id = $1
+`, auth.UserID).Scan(&version, &ciphertext, &verificationHash, &updatedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondErrorCode(w, http.StatusNotFound, "key_backup_missing", "no key backup stored")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to load key backup")
+			return
+		}
+
+		response := map[string]any{
+			"version":   version,
+			"updatedAt": updatedAt.UTC().Format(time.RFC3339Nano),
+		}
+		// Without the verification hash only the metadata is returned, so a
+		// stolen session cannot pull the blob for offline guessing.
+		presented := strings.TrimSpace(r.Header.Get(keyBackupVerificationHeader))
+		if presented == "" {
+			respondJSON(w, http.StatusOK, response)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(verificationHash)) != 1 {
+			requestLogger(r.Context()).Warn("key_backup_verification_failed", "user_id", auth.UserID)
+			respondErrorCode(w, http.StatusForbidden, "key_backup_verification_failed", "verification hash does not match")
+			return
+		}
+		response["ciphertext"] = ciphertext
+		respondJSON(w, http.StatusOK, response)
+
+	case http.MethodPut:
+		var req struct {
+			Version          int64  `json:"version"`
+			Ciphertext       string `json:"ciphertext"`
+			VerificationHash string `json:"verificationHash"`
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, int64(base64.StdEncoding.EncodedLen(maxKeyBackupCiphertextBytes))+4<<10)
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		req.VerificationHash = strings.TrimSpace(req.VerificationHash)
+		if err := validateKeyBackupUpload(req.Version, req.Ciphertext, req.VerificationHash); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		version, updatedAt, err := a.storeKeyBackup(ctx, auth.UserID, req.Version, req.Ciphertext, req.VerificationHash)
+		if err != nil {
+			if errors.Is(err, errKeyBackupVersionConflict) {
+				respondErrorDetails(w, http.StatusConflict, "key_backup_version_conflict", "key backup version is stale", map[string]any{
+					"currentVersion": version,
+				})
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to store key backup")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{
+			"version":   version,
+			"updatedAt": updatedAt.UTC().Format(time.RFC3339Nano),
+		})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
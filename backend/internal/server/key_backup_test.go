@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateKeyBackupUpload(t *testing.T) {
+	t.Parallel()
+
+	ciphertext := base64.StdEncoding.EncodeToString([]byte("sealed backup"))
+	hash := strings.Repeat("a", 32)
+	tests := []struct {
+		name       string
+		version    int64
+		ciphertext string
+		hash       string
+		wantErr    bool
+	}{
+		{name: "valid", version: 1, ciphertext: ciphertext, hash: hash},
+		{name: "zero version", version: 0, ciphertext: ciphertext, hash: hash, wantErr: true},
+		{name: "missing ciphertext", version: 1, hash: hash, wantErr: true},
+		{name: "not base64", version: 1, ciphertext: "not base64!", hash: hash, wantErr: true},
+		{name: "short hash", version: 1, ciphertext: ciphertext, hash: "abc", wantErr: true},
+		{name: "too large", version: 1, ciphertext: strings.Repeat("A", base64.StdEncoding.EncodedLen(maxKeyBackupCiphertextBytes)+4), hash: hash, wantErr: true},
+	}
+	for _, tc := range tests {
+		err := validateKeyBackupUpload(tc.version, tc.ciphertext, tc.hash)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s: expected error=%v, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
+
+func TestHandleAccountKeyBackupRejectsInvalidUpload(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	request := httptest.NewRequest(http.MethodPut, "/api/account/key-backup", strings.NewReader(`{"version":1,"ciphertext":"","verificationHash":"short"}`))
+	response := httptest.NewRecorder()
+	app.handleAccountKeyBackup(response, request, AuthContext{UserID: 1})
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", response.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodDelete, "/api/account/key-backup", nil)
+	response = httptest.NewRecorder()
+	app.handleAccountKeyBackup(response, request, AuthContext{UserID: 1})
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", response.Code)
+	}
+}
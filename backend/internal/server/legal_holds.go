@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	legalHoldSubjectUser = "user"
+	legalHoldSubjectRoom = "room"
+
+	errCodeLegalHold       = "legal_hold"
+	maxLegalHoldReasonLen  = 500
+	maxLegalHoldListLength = 1000
+)
+
+// legalHoldTables maps the path segment of a hold route to its subject and
+// table. Both tables carry the same legal_hold_* columns.
+var legalHoldTables = map[string]struct {
+	subject string
+	table   string
+}{
+	"users": {subject: legalHoldSubjectUser, table: "users"},
+	"rooms": {subject: legalHoldSubjectRoom, table: "rooms"},
+}
+
+// A legal hold freezes what the server would otherwise destroy for a user or
+// room: a held user's scheduled erasure waits until the hold is released,
+// erasing any other account leaves its messages in held rooms intact, and a
+// room that is held, or holds messages of a held user, cannot be deleted.
+// Revocation only ever marks messages, so it needs no check. Holds are not
+// shown to their subjects.
+type legalHold struct {
+	Subject  string `json:"subject"`
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Reason   string `json:"reason"`
+	PlacedBy *int64 `json:"placedBy,omitempty"`
+	PlacedAt string `json:"placedAt"`
+}
+
+// handleAdminLegalHolds serves GET /api/admin/legal-holds, every held user
+// and room.
+func (a *App) handleAdminLegalHolds(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT 'user', id, COALESCE(former_username, username), COALESCE(legal_hold_reason, ''), legal_hold_by, legal_hold_at
+FROM users WHERE legal_hold_at IS NOT NULL
+UNION ALL
+SELECT 'room', id, name, COALESCE(legal_hold_reason, ''), legal_hold_by, legal_hold_at
+FROM rooms WHERE legal_hold_at IS NOT NULL
+ORDER BY 6 DESC
+LIMIT $1
+`, maxLegalHoldListLength)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list legal holds")
+		return
+	}
+	defer rows.Close()
+
+	holds := make([]legalHold, 0, 16)
+	for rows.Next() {
+		var hold legalHold
+		var placedBy sql.NullInt64
+		var placedAt time.Time
+		if err := rows.Scan(&hold.Subject, &hold.ID, &hold.Name, &hold.Reason, &placedBy, &placedAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode legal holds")
+			return
+		}
+		if placedBy.Valid {
+			value := placedBy.Int64
+			hold.PlacedBy = &value
+		}
+		hold.PlacedAt = placedAt.UTC().Format(time.RFC3339Nano)
+		holds = append(holds, hold)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to list legal holds")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"holds": holds})
+}
+
+// handleAdminLegalHoldSubroutes serves PUT and DELETE on
+// /api/admin/legal-holds/{users|rooms}/{id}, placing and releasing a hold.
+// Both are audited, and the release entry carries the reason the hold was
+// placed with.
+func (a *App) handleAdminLegalHoldSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 5 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "legal-holds" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	target, ok := legalHoldTables[parts[3]]
+	if !ok {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	subjectID, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil || subjectID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid "+target.subject+" id")
+		return
+	}
+
+	var reason string
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		reason = strings.TrimSpace(req.Reason)
+		if reason == "" {
+			respondError(w, http.StatusBadRequest, "reason is required")
+			return
+		}
+		if len(reason) > maxLegalHoldReasonLen {
+			respondError(w, http.StatusBadRequest, "reason is too long")
+			return
+		}
+	case http.MethodDelete:
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to update legal hold")
+		return
+	}
+	defer tx.Rollback()
+
+	var heldAt sql.NullTime
+	var heldReason sql.NullString
+	err = tx.QueryRowContext(ctx,
+		`SELECT legal_hold_at, legal_hold_reason FROM `+target.table+` WHERE id = $1 FOR UPDATE`,
+		subjectID,
+	).Scan(&heldAt, &heldReason)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, target.subject+" not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load "+target.subject)
+		return
+	}
+
+	action := auditLegalHoldPlaced
+	details := map[string]any{"subject": target.subject, "id": subjectID}
+	if r.Method == http.MethodPut {
+		if heldAt.Valid {
+			respondErrorCode(w, http.StatusConflict, "already_held", target.subject+" is already under legal hold")
+			return
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE `+target.table+` SET legal_hold_at = NOW(), legal_hold_reason = $2, legal_hold_by = $3 WHERE id = $1`,
+			subjectID, reason, auth.UserID,
+		)
+		details["reason"] = reason
+	} else {
+		if !heldAt.Valid {
+			respondError(w, http.StatusNotFound, target.subject+" is not under legal hold")
+			return
+		}
+		action = auditLegalHoldReleased
+		_, err = tx.ExecContext(ctx,
+			`UPDATE `+target.table+` SET legal_hold_at = NULL, legal_hold_reason = NULL, legal_hold_by = NULL WHERE id = $1`,
+			subjectID,
+		)
+		details["reason"] = heldReason.String
+		details["placedAt"] = heldAt.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to update legal hold")
+		return
+	}
+	var targetUserID int64
+	if target.subject == legalHoldSubjectUser {
+		targetUserID = subjectID
+	}
+	if err := recordAdminAudit(ctx, tx, auth.UserID, action, targetUserID, details); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to record audit entry")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to update legal hold")
+		return
+	}
+
+	held := r.Method == http.MethodPut
+	requestLogger(r.Context()).Warn("legal_hold_changed",
+		"subject", target.subject,
+		"subject_id", subjectID,
+		"held", held,
+		"admin_user_id", auth.UserID,
+	)
+	respondJSON(w, http.StatusOK, map[string]any{"subject": target.subject, "id": subjectID, "held": held})
+}
+
+// roomDeletionHeld reports whether deleting roomID would destroy held
+// content: the room itself is held, or it holds messages from a held user.
+func roomDeletionHeld(ctx context.Context, q sqlQueryer, roomID int64) (bool, error) {
+	var held bool
+	err := q.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM rooms WHERE id = $1 AND legal_hold_at IS NOT NULL)
+    OR EXISTS (
+        SELECT 1 FROM messages m JOIN users u ON u.id = m.sender_id
+        WHERE m.room_id = $1 AND u.legal_hold_at IS NOT NULL
+    )
+`, roomID).Scan(&held)
+	return held, err
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminLegalHoldSubroutesValidateBeforeStorage(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{method: http.MethodPut, path: "/api/admin/legal-holds/devices/1", body: `{"reason":"case 7"}`, status: http.StatusNotFound},
+		{method: http.MethodPut, path: "/api/admin/legal-holds/users", body: `{"reason":"case 7"}`, status: http.StatusNotFound},
+		{method: http.MethodPut, path: "/api/admin/legal-holds/rooms/abc", body: `{"reason":"case 7"}`, status: http.StatusBadRequest},
+		{method: http.MethodPut, path: "/api/admin/legal-holds/users/3", body: `{"reason":"  "}`, status: http.StatusBadRequest},
+		{method: http.MethodPut, path: "/api/admin/legal-holds/users/3", body: `{"reason":"` + strings.Repeat("x", maxLegalHoldReasonLen+1) + `"}`, status: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/api/admin/legal-holds/rooms/3", body: `{}`, status: http.StatusMethodNotAllowed},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		rec := httptest.NewRecorder()
+		app.handleAdminLegalHoldSubroutes(rec, req, AuthContext{UserID: 1, Role: "admin"})
+		if rec.Code != tc.status {
+			t.Fatalf("%s %s: expected %d, got %d: %s", tc.method, tc.path, tc.status, rec.Code, rec.Body.String())
+		}
+	}
+}
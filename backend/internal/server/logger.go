@@ -1,13 +1,150 @@
 package server
 
 import (
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
-var logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-	Level: slog.LevelInfo,
-}))
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+
+	maxLogLevelOverride = 24 * time.Hour
+)
+
+// logLevel is shared by every handler the logger is built with, so changing
+// it takes effect immediately for all goroutines.
+var logLevel = new(slog.LevelVar)
+
+var logger = newLogger(os.Stdout, logFormatJSON)
+
+var logLevels = &logLevelController{}
+
+type loggingConfig struct {
+	Level  slog.Level
+	Format string
+}
+
+func newLogger(w io.Writer, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: logLevel}
+	if format == logFormatText {
+		return slog.New(slog.NewTextHandler(w, options))
+	}
+	return slog.New(slog.NewJSONHandler(w, options))
+}
+
+// configureLogging applies LOG_LEVEL and LOG_FORMAT. It must run before any
+// background goroutine starts logging.
+func configureLogging(cfg loggingConfig) {
+	logLevels.Configure(cfg.Level)
+	logger = newLogger(os.Stdout, cfg.Format)
+}
+
+func parseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", value)
+	}
+}
+
+func parseLogFormat(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", logFormatJSON:
+		return logFormatJSON, nil
+	case logFormatText:
+		return logFormatText, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q", value)
+	}
+}
+
+// logLevelController lets admins raise or lower the level at runtime. An
+// override with a duration reverts to the configured level on its own so a
+// forgotten debug switch does not flood the logs.
+type logLevelController struct {
+	mu         sync.Mutex
+	configured slog.Level
+	revertsAt  time.Time
+	timer      *time.Timer
+}
+
+type logLevelState struct {
+	Level           string  `json:"level"`
+	ConfiguredLevel string  `json:"configuredLevel"`
+	RevertsAt       *string `json:"revertsAt,omitempty"`
+}
+
+func (c *logLevelController) Configure(level slog.Level) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopTimerLocked()
+	c.configured = level
+	logLevel.Set(level)
+}
+
+// Override sets level now. A positive duration schedules a revert to the
+// configured level; zero keeps it until the next override or restart.
+func (c *logLevelController) Override(level slog.Level, duration time.Duration) logLevelState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopTimerLocked()
+	logLevel.Set(level)
+	if duration > 0 {
+		c.revertsAt = time.Now().Add(duration)
+		var timer *time.Timer
+		timer = time.AfterFunc(duration, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.timer != timer {
+				return
+			}
+			c.timer = nil
+			c.revertsAt = time.Time{}
+			logLevel.Set(c.configured)
+		})
+		c.timer = timer
+	}
+	return c.stateLocked()
+}
+
+func (c *logLevelController) State() logLevelState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stateLocked()
+}
+
+func (c *logLevelController) stopTimerLocked() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.revertsAt = time.Time{}
+}
+
+func (c *logLevelController) stateLocked() logLevelState {
+	state := logLevelState{
+		Level:           strings.ToLower(logLevel.Level().String()),
+		ConfiguredLevel: strings.ToLower(c.configured.String()),
+	}
+	if !c.revertsAt.IsZero() {
+		value := c.revertsAt.UTC().Format(time.RFC3339Nano)
+		state.RevertsAt = &value
+	}
+	return state
+}
 
 func fatalLog(message string, args ...any) {
 	logger.Error(message, args...)
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestParseLogSettings(t *testing.T) {
+	t.Parallel()
+
+	for value, expected := range map[string]slog.Level{"": slog.LevelInfo, "DEBUG": slog.LevelDebug, "warning": slog.LevelWarn, "error": slog.LevelError} {
+		level, err := parseLogLevel(value)
+		if err != nil || level != expected {
+			t.Fatalf("parseLogLevel(%q) = %v, %v", value, level, err)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Fatalf("expected unknown level to be rejected")
+	}
+	if format, err := parseLogFormat("TEXT"); err != nil || format != logFormatText {
+		t.Fatalf("parseLogFormat(TEXT) = %q, %v", format, err)
+	}
+	if _, err := parseLogFormat("xml"); err == nil {
+		t.Fatalf("expected unknown format to be rejected")
+	}
+}
+
+func TestLogLevelOverrideReverts(t *testing.T) {
+	previous := logLevel.Level()
+	t.Cleanup(func() { logLevel.Set(previous) })
+
+	controller := &logLevelController{}
+	controller.Configure(slog.LevelWarn)
+	state := controller.Override(slog.LevelDebug, 20*time.Millisecond)
+	if state.Level != "debug" || state.ConfiguredLevel != "warn" || state.RevertsAt == nil {
+		t.Fatalf("unexpected override state: %+v", state)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for logLevel.Level() != slog.LevelWarn {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected level to revert to warn, still %v", logLevel.Level())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if controller.State().RevertsAt != nil {
+		t.Fatalf("expected revert time to clear")
+	}
+
+	controller.Override(slog.LevelError, 0)
+	if logLevel.Level() != slog.LevelError || controller.State().RevertsAt != nil {
+		t.Fatalf("expected permanent override without revert time")
+	}
+}
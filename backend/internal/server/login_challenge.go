@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	loginChallengeHCaptcha  = "hcaptcha"
+	loginChallengeTurnstile = "turnstile"
+	loginChallengePoW       = "pow"
+
+	defaultLoginChallengeAfterFailures = 3
+	defaultLoginPoWDifficulty          = 20
+	maxLoginPoWDifficulty              = 32
+	loginFailureWindow                 = 15 * time.Minute
+	loginPoWChallengeTTL               = 5 * time.Minute
+
+	hcaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+	turnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+)
+
+type loginChallengeConfig struct {
+	// Provider is empty when challenges are disabled.
+	Provider      string
+	Secret        string
+	AfterFailures int
+	PoWDifficulty int
+}
+
+func (c loginChallengeConfig) validate() error {
+	switch c.Provider {
+	case "":
+		return nil
+	case loginChallengeHCaptcha, loginChallengeTurnstile:
+		if c.Secret == "" {
+			return fmt.Errorf("LOGIN_CHALLENGE_SECRET is required for %s", c.Provider)
+		}
+	case loginChallengePoW:
+		if c.PoWDifficulty > maxLoginPoWDifficulty {
+			return fmt.Errorf("LOGIN_POW_DIFFICULTY must be at most %d", maxLoginPoWDifficulty)
+		}
+	default:
+		return fmt.Errorf("LOGIN_CHALLENGE_PROVIDER must be hcaptcha, turnstile or pow")
+	}
+	return nil
+}
+
+type loginFailure struct {
+	count int
+	last  time.Time
+}
+
+// loginChallenge asks clients that keep failing to log in from one address to
+// solve a CAPTCHA or a proof-of-work puzzle first. Unlike the rate limiters it
+// never locks anyone out; it only makes each further guess cost something.
+type loginChallenge struct {
+	cfg       loginChallengeConfig
+	hmacKey   []byte
+	verifyURL string
+	client    *http.Client
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]*loginFailure
+	// spent holds the salts of solved proof-of-work challenges until they
+	// expire, so one solution buys one login attempt.
+	spent            map[string]time.Time
+	lastSpentCleanup time.Time
+}
+
+func newLoginChallenge(cfg loginChallengeConfig, secret []byte) *loginChallenge {
+	if cfg.Provider == "" {
+		return nil
+	}
+	if cfg.AfterFailures <= 0 {
+		cfg.AfterFailures = defaultLoginChallengeAfterFailures
+	}
+	if cfg.PoWDifficulty <= 0 {
+		cfg.PoWDifficulty = defaultLoginPoWDifficulty
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("login-pow-challenge"))
+	challenge := &loginChallenge{
+		cfg:      cfg,
+		hmacKey:  mac.Sum(nil),
+		client:   &http.Client{Timeout: 5 * time.Second},
+		now:      time.Now,
+		failures: make(map[string]*loginFailure),
+		spent:    make(map[string]time.Time),
+	}
+	switch cfg.Provider {
+	case loginChallengeHCaptcha:
+		challenge.verifyURL = hcaptchaVerifyURL
+	case loginChallengeTurnstile:
+		challenge.verifyURL = turnstileVerifyURL
+	}
+	return challenge
+}
+
+// Required reports whether key has failed often enough to need a challenge.
+func (c *loginChallenge) Required(key string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	failure, ok := c.failures[key]
+	if !ok {
+		return false
+	}
+	if c.now().Sub(failure.last) > loginFailureWindow {
+		delete(c.failures, key)
+		return false
+	}
+	return failure.count >= c.cfg.AfterFailures
+}
+
+func (c *loginChallenge) RecordFailure(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, failure := range c.failures {
+		if now.Sub(failure.last) > loginFailureWindow {
+			delete(c.failures, k)
+		}
+	}
+	failure, ok := c.failures[key]
+	if !ok {
+		failure = &loginFailure{}
+		c.failures[key] = failure
+	}
+	failure.count++
+	failure.last = now
+}
+
+func (c *loginChallenge) Reset(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.failures, key)
+	c.mu.Unlock()
+}
+
+// Verify checks a solved challenge. For CAPTCHA providers token is the widget
+// response; for proof of work it is a challenge from NewPoWChallenge and nonce
+// the client's solution.
+func (c *loginChallenge) Verify(ctx context.Context, token, nonce, remoteIP string) bool {
+	if c == nil {
+		return true
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return false
+	}
+	if c.cfg.Provider == loginChallengePoW {
+		return c.verifyPoW(token, strings.TrimSpace(nonce))
+	}
+	return c.verifyCaptcha(ctx, token, remoteIP)
+}
+
+func (c *loginChallenge) verifyCaptcha(ctx context.Context, token, remoteIP string) bool {
+	form := url.Values{"secret": {c.cfg.Secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		logger.Warn("login_challenge_verify_failed", "provider", c.cfg.Provider, "error", err)
+		return false
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&result) != nil {
+		logger.Warn("login_challenge_verify_failed", "provider", c.cfg.Provider, "status", resp.StatusCode)
+		return false
+	}
+	return result.Success
+}
+
+// NewPoWChallenge returns a signed challenge: an expiry and random salt
+// signed with the server key, so any replica can check it. Only the set of
+// spent challenges is kept, per replica and only until they expire.
+func (c *loginChallenge) NewPoWChallenge() (string, error) {
+	raw := make([]byte, 8+16, 8+16+sha256.Size)
+	binary.BigEndian.PutUint64(raw, uint64(c.now().Add(loginPoWChallengeTTL).Unix()))
+	if _, err := rand.Read(raw[8:]); err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, c.hmacKey)
+	mac.Write(raw)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(raw)), nil
+}
+
+// verifyPoW accepts nonce when SHA-256(challenge ":" nonce) starts with the
+// configured number of zero bits.
+func (c *loginChallenge) verifyPoW(challenge, nonce string) bool {
+	if nonce == "" || len(nonce) > 64 {
+		return false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(raw) != 8+16+sha256.Size {
+		return false
+	}
+	mac := hmac.New(sha256.New, c.hmacKey)
+	mac.Write(raw[:24])
+	if !hmac.Equal(mac.Sum(nil), raw[24:]) {
+		return false
+	}
+	now := c.now()
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(raw[:8])), 0)
+	if now.After(expiresAt) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	if leadingZeroBits(sum[:]) < c.cfg.PoWDifficulty {
+		return false
+	}
+	return c.spendPoW(string(raw[8:24]), expiresAt, now)
+}
+
+// spendPoW marks the challenge with salt as used and reports whether it was
+// still unused. Entries are dropped once expired, when the challenge would
+// fail the expiry check anyway, which bounds the set by one TTL of logins.
+func (c *loginChallenge) spendPoW(salt string, expiresAt, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastSpentCleanup.IsZero() || now.Sub(c.lastSpentCleanup) >= loginPoWChallengeTTL {
+		for key, expiry := range c.spent {
+			if now.After(expiry) {
+				delete(c.spent, key)
+			}
+		}
+		c.lastSpentCleanup = now
+	}
+	if _, ok := c.spent[salt]; ok {
+		return false
+	}
+	c.spent[salt] = expiresAt
+	return true
+}
+
+func leadingZeroBits(sum []byte) int {
+	count := 0
+	for _, b := range sum {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// respondChallengeRequired tells the client which challenge to solve; proof
+// of work comes with a fresh puzzle.
+func (c *loginChallenge) respondChallengeRequired(w http.ResponseWriter) {
+	challenge := map[string]any{"provider": c.cfg.Provider}
+	if c.cfg.Provider == loginChallengePoW {
+		token, err := c.NewPoWChallenge()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to issue login challenge")
+			return
+		}
+		challenge["challenge"] = token
+		challenge["difficulty"] = c.cfg.PoWDifficulty
+	}
+	respondErrorDetails(w, http.StatusForbidden, "challenge_required", "login challenge required", map[string]any{
+		"challenge": challenge,
+	})
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func solveLoginPoW(t *testing.T, challenge string, difficulty int) string {
+	t.Helper()
+	for i := 0; i < 1<<24; i++ {
+		nonce := strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+		if leadingZeroBits(sum[:]) >= difficulty {
+			return nonce
+		}
+	}
+	t.Fatal("no proof-of-work solution found")
+	return ""
+}
+
+func TestLoginChallengeRequiredAfterFailures(t *testing.T) {
+	t.Parallel()
+
+	challenge := newLoginChallenge(loginChallengeConfig{Provider: loginChallengePoW, AfterFailures: 2}, []byte("secret"))
+	now := time.Unix(1_700_000_000, 0)
+	challenge.now = func() time.Time { return now }
+
+	challenge.RecordFailure("203.0.113.7")
+	if challenge.Required("203.0.113.7") {
+		t.Fatal("expected one failure to stay below the threshold")
+	}
+	challenge.RecordFailure("203.0.113.7")
+	if !challenge.Required("203.0.113.7") || challenge.Required("203.0.113.8") {
+		t.Fatal("expected only the failing address to need a challenge")
+	}
+	now = now.Add(loginFailureWindow + time.Second)
+	if challenge.Required("203.0.113.7") {
+		t.Fatal("expected failures to expire after the window")
+	}
+	challenge.RecordFailure("203.0.113.7")
+	challenge.RecordFailure("203.0.113.7")
+	challenge.Reset("203.0.113.7")
+	if challenge.Required("203.0.113.7") {
+		t.Fatal("expected a successful login to reset failures")
+	}
+
+	var disabled *loginChallenge
+	disabled.RecordFailure("203.0.113.7")
+	if disabled.Required("203.0.113.7") || !disabled.Verify(context.Background(), "", "", "") {
+		t.Fatal("expected a disabled challenge to never be required")
+	}
+}
+
+func TestLoginChallengeVerifiesProofOfWork(t *testing.T) {
+	t.Parallel()
+
+	challenge := newLoginChallenge(loginChallengeConfig{Provider: loginChallengePoW, PoWDifficulty: 8}, []byte("secret"))
+	token, err := challenge.NewPoWChallenge()
+	if err != nil {
+		t.Fatalf("issue challenge: %v", err)
+	}
+	nonce := solveLoginPoW(t, token, 8)
+	if !challenge.Verify(context.Background(), token, nonce, "") {
+		t.Fatal("expected solved challenge to verify")
+	}
+	if challenge.Verify(context.Background(), token, nonce, "") {
+		t.Fatal("expected a spent challenge to be rejected")
+	}
+
+	other := newLoginChallenge(loginChallengeConfig{Provider: loginChallengePoW, PoWDifficulty: 8}, []byte("other"))
+	if other.Verify(context.Background(), token, nonce, "") {
+		t.Fatal("expected challenge signed with another key to be rejected")
+	}
+	challenge.now = func() time.Time { return time.Now().Add(loginPoWChallengeTTL + time.Minute) }
+	if challenge.Verify(context.Background(), token, nonce, "") {
+		t.Fatal("expected expired challenge to be rejected")
+	}
+}
+
+func TestLoginChallengeVerifiesCaptcha(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		success := r.PostForm.Get("secret") == "captcha-secret" && r.PostForm.Get("response") == "good"
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"success":` + strconv.FormatBool(success) + `}`))
+	}))
+	defer server.Close()
+
+	challenge := newLoginChallenge(loginChallengeConfig{Provider: loginChallengeTurnstile, Secret: "captcha-secret"}, []byte("secret"))
+	challenge.verifyURL = server.URL
+	if !challenge.Verify(context.Background(), "good", "", "203.0.113.7") {
+		t.Fatal("expected accepted captcha response to verify")
+	}
+	if challenge.Verify(context.Background(), "bad", "", "203.0.113.7") {
+		t.Fatal("expected rejected captcha response to fail")
+	}
+}
+
+func TestHandleLoginRequiresChallenge(t *testing.T) {
+	t.Parallel()
+
+	app := &App{loginChallenge: newLoginChallenge(loginChallengeConfig{Provider: loginChallengePoW, AfterFailures: 1}, []byte("secret"))}
+	request := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"username":"alice","password":"password123"}`))
+	app.loginChallenge.RecordFailure(clientKeyFromRequest(request, false))
+	response := httptest.NewRecorder()
+
+	app.handleLogin(response, request)
+
+	if response.Code != http.StatusForbidden {
+		t.Fatalf("expected %d, got %d", http.StatusForbidden, response.Code)
+	}
+	payload := decodeBodyMap(t, response)
+	details, _ := payload["details"].(map[string]any)
+	details, _ = details["challenge"].(map[string]any)
+	if payload["code"] != "challenge_required" || details["provider"] != loginChallengePoW || details["challenge"] == "" {
+		t.Fatalf("unexpected payload: %#v", payload)
+	}
+}
+
+func TestLoginChallengeConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, cfg := range []loginChallengeConfig{
+		{Provider: "recaptcha"},
+		{Provider: loginChallengeHCaptcha},
+		{Provider: loginChallengePoW, PoWDifficulty: maxLoginPoWDifficulty + 1},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Fatalf("expected %+v to be rejected", cfg)
+		}
+	}
+	if err := (loginChallengeConfig{}).validate(); err != nil {
+		t.Fatalf("expected disabled challenge to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsCloseMaintenance is sent to WebSocket upgrades refused during
+	// maintenance so clients can tell it apart from an auth failure.
+	wsCloseMaintenance = 4503
+
+	defaultMaintenanceReason = "server is under maintenance"
+	maxMaintenanceReasonLen  = 280
+)
+
+// maintenanceState is what REST callers and connected clients are told.
+type maintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+	Since   string `json:"since,omitempty"`
+}
+
+// maintenanceMode holds the toggle in memory. MAINTENANCE_MODE sets it at
+// startup and the admin endpoint flips it at runtime, per instance.
+type maintenanceMode struct {
+	mu      sync.RWMutex
+	enabled bool
+	reason  string
+	since   time.Time
+}
+
+func (m *maintenanceMode) State() maintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.enabled {
+		return maintenanceState{}
+	}
+	return maintenanceState{Enabled: true, Reason: m.reason, Since: m.since.UTC().Format(time.RFC3339Nano)}
+}
+
+func (m *maintenanceMode) Enabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled
+}
+
+// Set updates the mode and reports whether anything changed. The start time
+// is kept when only the reason is edited.
+func (m *maintenanceMode) Set(enabled bool, reason string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !enabled {
+		reason = ""
+	} else if reason == "" {
+		reason = defaultMaintenanceReason
+	}
+	if m.enabled == enabled && m.reason == reason {
+		return false
+	}
+	if enabled && !m.enabled {
+		m.since = time.Now()
+	}
+	m.enabled = enabled
+	m.reason = reason
+	return true
+}
+
+// maintenanceExempt lists what keeps working during maintenance: health
+// probes, the admin API, and sign-in so an admin whose access token expired
+// can still turn maintenance off. WebSocket paths are refused by their own
+// handlers with a close code instead of a 503.
+func maintenanceExempt(path string) bool {
+	switch path {
+	case "/healthz", "/livez", "/readyz", "/api/login", "/api/refresh", "/api/logout", "/ws", "/ws/guest":
+		return true
+	}
+	return path == strings.TrimSuffix(adminRoutePrefix, "/") || strings.HasPrefix(path, adminRoutePrefix)
+}
+
+func (a *App) withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.maintenance.Enabled() || maintenanceExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		state := a.maintenance.State()
+		respondErrorDetails(w, http.StatusServiceUnavailable, "maintenance", "server is under maintenance", map[string]any{
+			"reason": state.Reason,
+			"since":  state.Since,
+		})
+	})
+}
+
+// rejectMaintenanceUpgrade completes the upgrade only to close it with
+// wsCloseMaintenance. It reports false when maintenance is off.
+func (a *App) rejectMaintenanceUpgrade(w http.ResponseWriter, r *http.Request) bool {
+	if !a.maintenance.Enabled() {
+		return false
+	}
+	conn, err := a.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return true
+	}
+	_ = conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(wsCloseMaintenance, a.maintenance.State().Reason),
+		time.Now().Add(time.Second),
+	)
+	_ = conn.Close()
+	return true
+}
+
+// NotifyMaintenance sends every connection a maintenance frame. Connections
+// stay open; clients decide whether to keep reading.
+func (h *Hub) NotifyMaintenance(state maintenanceState) int {
+	payload, err := json.Marshal(struct {
+		Type string `json:"type"`
+		maintenanceState
+	}{Type: "maintenance", maintenanceState: state})
+	if err != nil {
+		return 0
+	}
+
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.rooms))
+	for _, roomClients := range h.rooms {
+		for client := range roomClients {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		select {
+		case client.send <- payload:
+		default:
+			client.log().Warn("websocket_maintenance_notice_drop", "user_id", client.userID, "room_id", client.roomID, "reason", "send queue full")
+		}
+	}
+	return len(clients)
+}
+
+func (a *App) handleAdminMaintenance(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, http.StatusOK, a.maintenance.State())
+
+	case http.MethodPut:
+		var req struct {
+			Enabled bool   `json:"enabled"`
+			Reason  string `json:"reason"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if len(req.Reason) > maxMaintenanceReasonLen {
+			respondError(w, http.StatusBadRequest, "reason is too long")
+			return
+		}
+		if !a.maintenance.Set(req.Enabled, req.Reason) {
+			respondJSON(w, http.StatusOK, a.maintenance.State())
+			return
+		}
+		state := a.maintenance.State()
+		notified := a.hub.NotifyMaintenance(state)
+		requestLogger(r.Context()).Warn(
+			"maintenance_mode_changed",
+			"enabled", state.Enabled,
+			"reason", state.Reason,
+			"connections_notified", notified,
+			"admin_user_id", auth.UserID,
+		)
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if err := recordAdminAudit(ctx, a.db, auth.UserID, auditMaintenanceChanged, 0, map[string]any{
+			"enabled": state.Enabled,
+			"reason":  state.Reason,
+		}); err != nil {
+			requestLogger(r.Context()).Warn("admin_audit_write_failed", "action", auditMaintenanceChanged, "error", err)
+		}
+		respondJSON(w, http.StatusOK, state)
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
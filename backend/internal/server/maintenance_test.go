@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaintenanceBlocksRESTExceptHealthAndAdmin(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	handler := app.withMaintenance(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/rooms", nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected requests through while maintenance is off, got %d", recorder.Code)
+	}
+
+	app.maintenance.Set(true, "database upgrade")
+	for path, want := range map[string]int{
+		"/api/rooms":       http.StatusServiceUnavailable,
+		"/api/sync":        http.StatusServiceUnavailable,
+		"/healthz":         http.StatusNoContent,
+		"/readyz":          http.StatusNoContent,
+		"/api/admin/stats": http.StatusNoContent,
+		"/api/login":       http.StatusNoContent,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != want {
+			t.Fatalf("%s: expected %d, got %d", path, want, recorder.Code)
+		}
+		if want != http.StatusServiceUnavailable {
+			continue
+		}
+		var body struct {
+			Code    string `json:"code"`
+			Details struct {
+				Reason string `json:"reason"`
+			} `json:"details"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil || body.Code != "maintenance" || body.Details.Reason != "database upgrade" {
+			t.Fatalf("%s: unexpected maintenance body %s", path, recorder.Body.String())
+		}
+	}
+}
+
+func TestMaintenanceModeSetReportsChanges(t *testing.T) {
+	t.Parallel()
+
+	var mode maintenanceMode
+	if mode.Set(false, "") {
+		t.Fatal("expected disabling an already disabled mode to be a no-op")
+	}
+	if !mode.Set(true, "") || mode.State().Reason != defaultMaintenanceReason {
+		t.Fatalf("expected default reason, got %+v", mode.State())
+	}
+	since := mode.State().Since
+	if !mode.Set(true, "new window") || mode.State().Since != since {
+		t.Fatalf("expected reason edit to keep the start time, got %+v", mode.State())
+	}
+	if !mode.Set(false, "ignored") || mode.State() != (maintenanceState{}) {
+		t.Fatalf("expected cleared state, got %+v", mode.State())
+	}
+}
+
+func TestHubNotifyMaintenanceReachesEveryConnection(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	alice := &Client{roomID: 1, userID: 1, send: make(chan []byte, 1)}
+	bob := &Client{roomID: 2, userID: 2, send: make(chan []byte, 1)}
+	hub.AddClient(alice)
+	hub.AddClient(bob)
+
+	if notified := hub.NotifyMaintenance(maintenanceState{Enabled: true, Reason: "upgrade"}); notified != 2 {
+		t.Fatalf("expected 2 clients notified, got %d", notified)
+	}
+	for _, client := range []*Client{alice, bob} {
+		var frame struct {
+			Type    string `json:"type"`
+			Enabled bool   `json:"enabled"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.Unmarshal(<-client.send, &frame); err != nil {
+			t.Fatalf("decode frame: %v", err)
+		}
+		if frame.Type != "maintenance" || !frame.Enabled || frame.Reason != "upgrade" {
+			t.Fatalf("unexpected maintenance frame: %+v", frame)
+		}
+	}
+}
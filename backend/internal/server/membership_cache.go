@@ -0,0 +1,159 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultMembershipCacheTTL = 30 * time.Second
+
+type membershipKey struct {
+	roomID int64
+	userID int64
+}
+
+type membershipEntry struct {
+	member    bool
+	expiresAt time.Time
+}
+
+// membershipCache memoizes room membership lookups for hot WS paths. Both
+// positive and negative answers are cached; writers that change membership
+// must invalidate the affected keys.
+type membershipCache struct {
+	mu          sync.Mutex
+	entries     map[membershipKey]membershipEntry
+	ttl         time.Duration
+	lastCleanup time.Time
+	now         func() time.Time
+	hits        atomic.Int64
+	misses      atomic.Int64
+}
+
+type membershipCacheStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+	Entries int     `json:"entries"`
+}
+
+func newMembershipCache(ttl time.Duration) *membershipCache {
+	if ttl <= 0 {
+		ttl = defaultMembershipCacheTTL
+	}
+	return &membershipCache{
+		entries: make(map[membershipKey]membershipEntry),
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+func (c *membershipCache) Lookup(userID, roomID int64) (member bool, found bool) {
+	if c == nil {
+		return false, false
+	}
+	now := c.now()
+	key := membershipKey{roomID: roomID, userID: userID}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && !now.Before(entry.expiresAt) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		c.misses.Add(1)
+		return false, false
+	}
+	c.hits.Add(1)
+	return entry.member, true
+}
+
+func (c *membershipCache) Store(userID, roomID int64, member bool) {
+	if c == nil {
+		return
+	}
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastCleanup.IsZero() || now.Sub(c.lastCleanup) >= c.ttl {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		c.lastCleanup = now
+	}
+	c.entries[membershipKey{roomID: roomID, userID: userID}] = membershipEntry{
+		member:    member,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+func (c *membershipCache) Invalidate(userID, roomID int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, membershipKey{roomID: roomID, userID: userID})
+}
+
+func (c *membershipCache) InvalidateRoom(roomID int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if key.roomID == roomID {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *membershipCache) InvalidateUser(userID int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if key.userID == userID {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Reset drops every entry, for bulk changes such as a backup restore.
+func (c *membershipCache) Reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[membershipKey]membershipEntry)
+}
+
+func (c *membershipCache) Stats() membershipCacheStats {
+	if c == nil {
+		return membershipCacheStats{}
+	}
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	stats := membershipCacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
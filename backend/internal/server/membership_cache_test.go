@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMembershipCacheLookupAndExpiry(t *testing.T) {
+	t.Parallel()
+
+	cache := newMembershipCache(time.Minute)
+	now := time.Unix(1_700_000_000, 0)
+	cache.now = func() time.Time { return now }
+
+	if _, found := cache.Lookup(1, 10); found {
+		t.Fatalf("expected miss on empty cache")
+	}
+	cache.Store(1, 10, true)
+	cache.Store(2, 10, false)
+
+	if member, found := cache.Lookup(1, 10); !found || !member {
+		t.Fatalf("expected cached positive membership")
+	}
+	if member, found := cache.Lookup(2, 10); !found || member {
+		t.Fatalf("expected cached negative membership")
+	}
+
+	now = now.Add(time.Minute)
+	if _, found := cache.Lookup(1, 10); found {
+		t.Fatalf("expected entry to expire after ttl")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.HitRate != 0.5 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMembershipCacheInvalidation(t *testing.T) {
+	t.Parallel()
+
+	cache := newMembershipCache(time.Minute)
+	cache.Store(1, 10, true)
+	cache.Store(1, 11, true)
+	cache.Store(2, 10, true)
+
+	cache.Invalidate(1, 11)
+	if _, found := cache.Lookup(1, 11); found {
+		t.Fatalf("expected single entry invalidation")
+	}
+
+	cache.InvalidateRoom(10)
+	if _, found := cache.Lookup(1, 10); found {
+		t.Fatalf("expected room invalidation to drop user 1")
+	}
+	if _, found := cache.Lookup(2, 10); found {
+		t.Fatalf("expected room invalidation to drop user 2")
+	}
+
+	cache.Store(3, 12, true)
+	cache.InvalidateUser(3)
+	if _, found := cache.Lookup(3, 12); found {
+		t.Fatalf("expected user invalidation")
+	}
+
+	var nilCache *membershipCache
+	if _, found := nilCache.Lookup(1, 1); found {
+		t.Fatalf("nil cache should always miss")
+	}
+}
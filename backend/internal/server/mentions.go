@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const maxMentionsPerMessage = 50
+
+var errTooManyMentions = errors.New("too many mentions")
+
+// normalizeMentions deduplicates mention targets and drops the sender, who
+// never needs to be notified about their own message.
+func normalizeMentions(mentions []int64, senderID int64) ([]int64, error) {
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+	seen := make(map[int64]struct{}, len(mentions))
+	normalized := make([]int64, 0, len(mentions))
+	for _, userID := range mentions {
+		if userID <= 0 {
+			return nil, errInvalidPayloadFormat
+		}
+		if userID == senderID {
+			continue
+		}
+		if _, found := seen[userID]; found {
+			continue
+		}
+		seen[userID] = struct{}{}
+		normalized = append(normalized, userID)
+	}
+	if len(normalized) > maxMentionsPerMessage {
+		return nil, errTooManyMentions
+	}
+	return normalized, nil
+}
+
+// filterRoomMembers returns the subset of userIDs that are members of the room,
+// preserving input order.
+func (a *App) filterRoomMembers(ctx context.Context, roomID int64, userIDs []int64) ([]int64, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT user_id FROM room_members WHERE room_id = $1 AND user_id = ANY($2::BIGINT[])`,
+		roomID, userIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make(map[int64]struct{}, len(userIDs))
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		members[userID] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	filtered := make([]int64, 0, len(members))
+	for _, userID := range userIDs {
+		if _, found := members[userID]; found {
+			filtered = append(filtered, userID)
+		}
+	}
+	return filtered, nil
+}
+
+// notifyMentions sends a targeted mention event to every mentioned member on
+// all of their connected devices, regardless of which room they have open or
+// whether they muted this one. Members in do not disturb are skipped.
+func (a *App) notifyMentions(
+	roomID int64,
+	messageID int64,
+	senderID int64,
+	senderUsername string,
+	createdAt time.Time,
+	mentions []int64,
+) {
+	if len(mentions) == 0 {
+		return
+	}
+	payload, err := json.Marshal(map[string]any{
+		"type":         "mention",
+		"roomId":       roomID,
+		"messageId":    messageID,
+		"fromUserId":   senderID,
+		"fromUsername": senderUsername,
+		"createdAt":    createdAt.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return
+	}
+	for _, userID := range mentions {
+		if a.hub.presence.Status(userID) == presenceDND {
+			continue
+		}
+		a.hub.SendToUser(userID, payload)
+	}
+}
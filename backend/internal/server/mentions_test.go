@@ -0,0 +1,56 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeMentions(t *testing.T) {
+	t.Parallel()
+
+	mentions, err := normalizeMentions([]int64{4, 2, 4, 9, 2}, 9)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mentions) != 2 || mentions[0] != 4 || mentions[1] != 2 {
+		t.Fatalf("unexpected normalized mentions: %v", mentions)
+	}
+
+	if _, err := normalizeMentions([]int64{3, 0}, 1); !errors.Is(err, errInvalidPayloadFormat) {
+		t.Fatalf("expected errInvalidPayloadFormat, got %v", err)
+	}
+
+	tooMany := make([]int64, 0, maxMentionsPerMessage+1)
+	for userID := int64(1); userID <= maxMentionsPerMessage+1; userID += 1 {
+		tooMany = append(tooMany, userID+100)
+	}
+	if _, err := normalizeMentions(tooMany, 1); !errors.Is(err, errTooManyMentions) {
+		t.Fatalf("expected errTooManyMentions, got %v", err)
+	}
+}
+
+func TestHubSendToUser(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	aliceRoomOne := &Client{roomID: 1, userID: 1, username: "alice", send: make(chan []byte, 1)}
+	aliceRoomTwo := &Client{roomID: 2, userID: 1, username: "alice", send: make(chan []byte, 1)}
+	bob := &Client{roomID: 1, userID: 2, username: "bob", send: make(chan []byte, 1)}
+	hub.AddClient(aliceRoomOne)
+	hub.AddClient(aliceRoomTwo)
+	hub.AddClient(bob)
+
+	hub.SendToUser(1, []byte("mention"))
+
+	if got := <-aliceRoomOne.send; string(got) != "mention" {
+		t.Fatalf("unexpected payload in room one: %q", string(got))
+	}
+	if got := <-aliceRoomTwo.send; string(got) != "mention" {
+		t.Fatalf("unexpected payload in room two: %q", string(got))
+	}
+	select {
+	case <-bob.send:
+		t.Fatalf("bob should not receive alice's mention")
+	default:
+	}
+}
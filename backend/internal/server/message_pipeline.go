@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMessageBatchSize      = 64
+	defaultMessageBatchLatencyMS = 10
+	messagePipelineShards        = 8
+	messagePipelineQueuePerShard = 1024
+	messagePipelineFlushTimeout  = 10 * time.Second
+)
+
+var errMessagePipelineClosed = errors.New("message pipeline is closed")
+
+type pendingMessage struct {
+	roomID   int64
+	senderID int64
+	payload  CipherPayload
+	mentions []int64
+	// done runs on the shard worker after the batch commits, so callbacks for
+	// one room observe the same order in which frames were enqueued.
+	done func(stamp messageStamp, err error)
+}
+
+type storedMessageResult struct {
+	stamp messageStamp
+	err   error
+}
+
+type messageFlushFunc func(ctx context.Context, batch []pendingMessage) []storedMessageResult
+
+// messagePipeline takes ciphertext persistence off the WS read loop. Messages
+// are sharded by room so per-room ordering is preserved, and each shard
+// flushes once it has maxBatch messages or its oldest message has waited
+// maxLatency.
+type messagePipeline struct {
+	shards     []chan pendingMessage
+	flush      messageFlushFunc
+	maxBatch   int
+	maxLatency time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newMessagePipeline(flush messageFlushFunc, maxBatch int, maxLatency time.Duration) *messagePipeline {
+	if maxBatch <= 0 {
+		maxBatch = defaultMessageBatchSize
+	}
+	if maxLatency <= 0 {
+		maxLatency = time.Duration(defaultMessageBatchLatencyMS) * time.Millisecond
+	}
+	p := &messagePipeline{
+		shards:     make([]chan pendingMessage, messagePipelineShards),
+		flush:      flush,
+		maxBatch:   maxBatch,
+		maxLatency: maxLatency,
+	}
+	for i := range p.shards {
+		p.shards[i] = make(chan pendingMessage, messagePipelineQueuePerShard)
+		p.wg.Add(1)
+		go p.run(p.shards[i])
+	}
+	return p
+}
+
+// Enqueue blocks when the room's shard is full, applying backpressure to the
+// sending connection instead of dropping messages.
+func (p *messagePipeline) Enqueue(ctx context.Context, msg pendingMessage) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errMessagePipelineClosed
+	}
+	shard := p.shards[uint64(msg.roomID)%uint64(len(p.shards))]
+	select {
+	case shard <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting messages and waits for queued ones to be flushed.
+func (p *messagePipeline) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	for _, shard := range p.shards {
+		close(shard)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *messagePipeline) run(queue <-chan pendingMessage) {
+	defer p.wg.Done()
+
+	batch := make([]pendingMessage, 0, p.maxBatch)
+	timer := time.NewTimer(p.maxLatency)
+	timer.Stop()
+
+	for {
+		var timeout <-chan time.Time
+		if len(batch) > 0 {
+			timeout = timer.C
+		}
+		select {
+		case msg, ok := <-queue:
+			if !ok {
+				p.flushBatch(batch)
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(p.maxLatency)
+			}
+			batch = append(batch, msg)
+			if len(batch) < p.maxBatch {
+				continue
+			}
+			timer.Stop()
+		case <-timeout:
+		}
+		p.flushBatch(batch)
+		batch = batch[:0]
+	}
+}
+
+func (p *messagePipeline) flushBatch(batch []pendingMessage) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), messagePipelineFlushTimeout)
+	results := p.flush(ctx, batch)
+	cancel()
+
+	for i, msg := range batch {
+		if msg.done == nil {
+			continue
+		}
+		result := storedMessageResult{err: errors.New("message batch result missing")}
+		if i < len(results) {
+			result = results[i]
+		}
+		msg.done(result.stamp, result.err)
+	}
+}
+
+// flushMessageBatch persists a batch with one multi-row INSERT. If the batch
+// fails as a whole it falls back to per-message inserts so a single bad row
+// cannot take its neighbours down with it.
+func (a *App) flushMessageBatch(ctx context.Context, batch []pendingMessage) []storedMessageResult {
+	ctx, s := a.tracer.StartSpan(ctx, "db.store_message_batch", spanKindClient,
+		attrString("db.system", "postgresql"),
+		attrInt("batch_size", int64(len(batch))),
+	)
+	results, err := a.storeMessageBatch(ctx, batch)
+	s.End(err)
+	if err == nil {
+		return results
+	}
+	logger.Warn("store_message_batch_failed", "size", len(batch), "error", err)
+
+	results = make([]storedMessageResult, len(batch))
+	for i, msg := range batch {
+		stamp, err := a.storeMessage(ctx, msg.roomID, msg.senderID, msg.payload, msg.mentions)
+		results[i] = storedMessageResult{stamp: stamp, err: err}
+	}
+	return results
+}
+
+func (a *App) storeMessageBatch(ctx context.Context, batch []pendingMessage) ([]storedMessageResult, error) {
+	roomIDs := make([]int64, len(batch))
+	senderIDs := make([]int64, len(batch))
+	payloads := make([]string, len(batch))
+	payloadJSON := make([][]byte, len(batch))
+	for i, msg := range batch {
+		encoded, err := json.Marshal(msg.payload)
+		if err != nil {
+			return nil, err
+		}
+		roomIDs[i] = msg.roomID
+		senderIDs[i] = msg.senderID
+		payloads[i] = string(encoded)
+		payloadJSON[i] = encoded
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Charges are summed per sender and applied in ascending user order, so
+	// two batches locking the same usage rows cannot deadlock. A quota
+	// rejection fails the whole batch; the per-message fallback then rejects
+	// only the offending sends.
+	type quotaCharge struct{ messages, bytes int }
+	charges := make(map[int64]*quotaCharge)
+	for i, msg := range batch {
+		charge := charges[msg.senderID]
+		if charge == nil {
+			charge = &quotaCharge{}
+			charges[msg.senderID] = charge
+		}
+		charge.messages++
+		charge.bytes += len(payloadJSON[i])
+	}
+	chargedUsers := make([]int64, 0, len(charges))
+	for userID := range charges {
+		chargedUsers = append(chargedUsers, userID)
+	}
+	sort.Slice(chargedUsers, func(i, j int) bool { return chargedUsers[i] < chargedUsers[j] })
+	for _, userID := range chargedUsers {
+		charge := charges[userID]
+		if err := a.chargeMessageQuotaTx(ctx, tx, userID, charge.messages, charge.bytes); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rows are inserted in ordinality order, so ascending ids map back onto
+	// batch positions.
+	rows, err := tx.QueryContext(ctx, `
+INSERT INTO messages(room_id, sender_id, payload)
+SELECT b.room_id, b.sender_id, b.payload::jsonb
+FROM unnest($1::BIGINT[], $2::BIGINT[], $3::TEXT[]) WITH ORDINALITY AS b(room_id, sender_id, payload, ord)
+ORDER BY b.ord
+RETURNING id, room_seq, created_at
+`, roomIDs, senderIDs, payloads)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]storedMessageResult, 0, len(batch))
+	for rows.Next() {
+		var item storedMessageResult
+		if err := rows.Scan(&item.stamp.ID, &item.stamp.Seq, &item.stamp.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results = append(results, item)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(results) != len(batch) {
+		return nil, errors.New("message batch insert returned unexpected row count")
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].stamp.ID < results[j].stamp.ID })
+
+	mentionMessageIDs := make([]int64, 0)
+	mentionUserIDs := make([]int64, 0)
+	for i, msg := range batch {
+		for _, userID := range msg.mentions {
+			mentionMessageIDs = append(mentionMessageIDs, results[i].stamp.ID)
+			mentionUserIDs = append(mentionUserIDs, userID)
+		}
+	}
+	if len(mentionMessageIDs) > 0 {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO message_mentions(message_id, user_id)
+SELECT * FROM unnest($1::BIGINT[], $2::BIGINT[])
+ON CONFLICT DO NOTHING
+`, mentionMessageIDs, mentionUserIDs); err != nil {
+			return nil, err
+		}
+	}
+	for i, msg := range batch {
+		if _, err := recordEvent(ctx, tx, msg.roomID, results[i].stamp.ID, msg.senderID, eventMessageCreated, payloadJSON[i]); err != nil {
+			return nil, err
+		}
+		if err := enqueueWebhookEvent(ctx, tx, msg.roomID, webhookEventMessageCreated, messageWebhookData(results[i].stamp.ID, msg.senderID, results[i].stamp.CreatedAt)); err != nil {
+			return nil, err
+		}
+		if err := a.federation.enqueueMessage(ctx, tx, msg.roomID, msg.senderID, localFederationEnvelope(results[i].stamp.ID, results[i].stamp.CreatedAt, payloadJSON[i])); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	a.webhooks.Notify()
+	a.federation.Notify()
+	return results, nil
+}
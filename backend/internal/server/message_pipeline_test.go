@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type flushRecorder struct {
+	mu      sync.Mutex
+	batches [][]int64
+	nextID  int64
+}
+
+func (r *flushRecorder) flush(_ context.Context, batch []pendingMessage) []storedMessageResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	senders := make([]int64, 0, len(batch))
+	results := make([]storedMessageResult, 0, len(batch))
+	for _, msg := range batch {
+		senders = append(senders, msg.senderID)
+		r.nextID++
+		results = append(results, storedMessageResult{stamp: messageStamp{ID: r.nextID, Seq: r.nextID, CreatedAt: time.Now()}})
+	}
+	r.batches = append(r.batches, senders)
+	return results
+}
+
+func TestMessagePipelineBatchesBySize(t *testing.T) {
+	t.Parallel()
+
+	recorder := &flushRecorder{}
+	pipeline := newMessagePipeline(recorder.flush, 3, time.Hour)
+
+	var mu sync.Mutex
+	delivered := make([]int64, 0, 6)
+	var wg sync.WaitGroup
+	for sender := int64(1); sender <= 6; sender++ {
+		wg.Add(1)
+		err := pipeline.Enqueue(context.Background(), pendingMessage{
+			roomID:   7,
+			senderID: sender,
+			done: func(stamp messageStamp, err error) {
+				defer wg.Done()
+				if err != nil {
+					t.Errorf("unexpected flush error: %v", err)
+				}
+				mu.Lock()
+				delivered = append(delivered, stamp.Seq)
+				mu.Unlock()
+			},
+		})
+		if err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+	}
+	wg.Wait()
+	pipeline.Close()
+
+	if len(recorder.batches) != 2 || len(recorder.batches[0]) != 3 || len(recorder.batches[1]) != 3 {
+		t.Fatalf("expected two batches of three, got %v", recorder.batches)
+	}
+	for i, id := range delivered {
+		if id != int64(i+1) {
+			t.Fatalf("expected in-order delivery, got %v", delivered)
+		}
+	}
+}
+
+func TestMessagePipelineFlushesAfterLatency(t *testing.T) {
+	t.Parallel()
+
+	recorder := &flushRecorder{}
+	pipeline := newMessagePipeline(recorder.flush, 100, 10*time.Millisecond)
+	defer pipeline.Close()
+
+	done := make(chan int64, 1)
+	if err := pipeline.Enqueue(context.Background(), pendingMessage{
+		roomID:   1,
+		senderID: 2,
+		done: func(stamp messageStamp, _ error) {
+			done <- stamp.ID
+		},
+	}); err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+
+	select {
+	case id := <-done:
+		if id != 1 {
+			t.Fatalf("unexpected message id %d", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected partial batch to flush after max latency")
+	}
+}
+
+func TestMessagePipelineRejectsAfterClose(t *testing.T) {
+	t.Parallel()
+
+	recorder := &flushRecorder{}
+	pipeline := newMessagePipeline(recorder.flush, 10, time.Hour)
+	if err := pipeline.Enqueue(context.Background(), pendingMessage{roomID: 1, senderID: 1}); err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+	pipeline.Close()
+
+	if len(recorder.batches) != 1 {
+		t.Fatalf("expected pending message to be flushed on close, got %v", recorder.batches)
+	}
+	if err := pipeline.Enqueue(context.Background(), pendingMessage{roomID: 1}); err != errMessagePipelineClosed {
+		t.Fatalf("expected closed error, got %v", err)
+	}
+}
+
+func TestStoreMessageBatchChargesEachSenderOnce(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	adminID, roomID := seedSQLiteTestRoom(t, db)
+	memberID := insertSQLiteTestMember(t, db, roomID, "member", "user")
+	payload := signedTestCipherPayload(t)
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+
+	app := &App{db: db}
+	batch := []pendingMessage{
+		{roomID: roomID, senderID: memberID, payload: payload},
+		{roomID: roomID, senderID: adminID, payload: payload},
+		{roomID: roomID, senderID: memberID, payload: payload},
+	}
+	if _, err := app.storeMessageBatch(context.Background(), batch); err != nil {
+		t.Fatalf("store batch: %v", err)
+	}
+
+	for userID, want := range map[int64]int64{adminID: 1, memberID: 2} {
+		var messagesToday, storedBytes int64
+		if err := db.QueryRowContext(context.Background(),
+			`SELECT messages_today, stored_bytes FROM user_quota_usage WHERE user_id = $1`, userID,
+		).Scan(&messagesToday, &storedBytes); err != nil {
+			t.Fatalf("load usage for %d: %v", userID, err)
+		}
+		if messagesToday != want || storedBytes != want*int64(len(encoded)) {
+			t.Fatalf("user %d: expected %d messages, got %d messages and %d bytes", userID, want, messagesToday, storedBytes)
+		}
+	}
+
+	app.dailyMessageLimit = 2
+	_, err = app.storeMessageBatch(context.Background(), batch[:1])
+	var quotaErr *messageQuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Code != quotaCodeDailyMessages {
+		t.Fatalf("expected the third message to hit the daily limit, got %v", err)
+	}
+}
@@ -33,19 +33,21 @@ func (a *App) withSecurityHeaders(next http.Handler) http.Handler {
 func (a *App) withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
-		if a.corsOrigin == "*" {
+		allowedOrigins := a.allowedCORSOrigin()
+		if allowedOrigins == "*" {
 			if origin == "" {
 				w.Header().Set("Access-Control-Allow-Origin", "*")
 			} else {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 			}
-		} else if origin == a.corsOrigin {
+		} else if origin != "" && corsOriginAllowed(allowedOrigins, origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 		}
 		w.Header().Set("Vary", "Origin")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-CSRF-Token")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-CSRF-Token, X-Guest-Token, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -55,6 +57,33 @@ func (a *App) withCORS(next http.Handler) http.Handler {
 	})
 }
 
+func (a *App) allowedCORSOrigin() string {
+	a.settingsMu.RLock()
+	defer a.settingsMu.RUnlock()
+	return a.corsOrigin
+}
+
+// checkWSOrigin applies the CORS origin to WebSocket upgrades, which browsers
+// do not preflight.
+func (a *App) checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || corsOriginAllowed(a.allowedCORSOrigin(), origin)
+}
+
+// corsOriginAllowed matches origin against the comma-separated CORS_ORIGIN
+// allowlist. A trailing slash in the configured entry is ignored.
+func corsOriginAllowed(allowedOrigins, origin string) bool {
+	if strings.TrimSpace(allowedOrigins) == "*" {
+		return true
+	}
+	for _, entry := range strings.Split(allowedOrigins, ",") {
+		if strings.TrimRight(strings.TrimSpace(entry), "/") == origin {
+			return true
+		}
+	}
+	return false
+}
+
 func requiresCSRF(method string) bool {
 	switch method {
 	case http.MethodGet, http.MethodHead, http.MethodOptions:
@@ -81,60 +110,60 @@ func (a *App) withAuth(next func(http.ResponseWriter, *http.Request, AuthContext
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenString, authSource := authTokenFromRequest(r)
 		if tokenString == "" {
-			respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "authorization required"})
+			respondError(w, http.StatusUnauthorized, "authorization required")
 			return
 		}
 		claims, err := a.parseToken(tokenString)
 		if err != nil {
-			respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid token"})
+			respondError(w, http.StatusUnauthorized, "invalid token")
 			return
 		}
 		if authSource == "cookie" && requiresCSRF(r.Method) && !validateCSRFToken(r) {
-			respondJSON(w, http.StatusForbidden, map[string]any{"error": "csrf token validation failed"})
+			respondError(w, http.StatusForbidden, "csrf token validation failed")
 			return
 		}
 		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 		defer cancel()
-		role, err := a.ensureUserIdentity(ctx, claims.UserID, claims.Username)
+		identity, err := a.ensureUserIdentity(ctx, claims.UserID, claims.Username)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidIdentity) {
-				respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "authorization required"})
+				respondError(w, http.StatusUnauthorized, "authorization required")
 				return
 			}
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to validate identity"})
+			respondError(w, http.StatusInternalServerError, "failed to validate identity")
 			return
 		}
-		if role != claims.Role {
-			respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "token role mismatch"})
+		if !identity.matchesClaims(claims) {
+			respondError(w, http.StatusUnauthorized, "token role mismatch")
 			return
 		}
 		device, err := a.validateDeviceClaim(ctx, claims.UserID, claims.DeviceID, claims.DeviceSessionVersion)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidIdentity) {
-				respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "device session expired"})
+				respondError(w, http.StatusUnauthorized, "device session expired")
 				return
 			}
-			respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to validate device session"})
+			respondError(w, http.StatusInternalServerError, "failed to validate device session")
 			return
 		}
-		next(w, r, AuthContext{
-			UserID:               claims.UserID,
-			Username:             claims.Username,
-			Role:                 role,
-			DeviceID:             device.DeviceID,
-			DeviceName:           device.DeviceName,
-			DeviceSessionVersion: device.SessionVersion,
-			DeviceLastSeenAt:     device.LastSeenAt,
-		})
-	}
-}
-
-func (a *App) withAdmin(next func(http.ResponseWriter, *http.Request, AuthContext)) func(http.ResponseWriter, *http.Request, AuthContext) {
-	return func(w http.ResponseWriter, r *http.Request, auth AuthContext) {
-		if auth.Role != "admin" {
-			respondJSON(w, http.StatusForbidden, map[string]any{"error": "admin authorization required"})
+		if required := a.policyPending(identity); required > 0 && !policyGateExemptPaths[r.URL.Path] {
+			respondPolicyAcceptanceRequired(w, required, identity.AcceptedPolicyVersion)
 			return
 		}
-		next(w, r, auth)
+		next(w, r, AuthContext{
+			UserID:                claims.UserID,
+			Username:              claims.Username,
+			DisplayName:           identity.DisplayName,
+			Role:                  identity.Role,
+			CustomRole:            identity.Grant.Name,
+			RoleVersion:           identity.Grant.Version,
+			Permissions:           identity.Grant.Permissions,
+			DeviceID:              device.DeviceID,
+			DeviceName:            device.DeviceName,
+			DeviceSessionVersion:  device.SessionVersion,
+			DeviceLastSeenAt:      device.LastSeenAt,
+			PolicyVersion:         a.requiredPolicyVersion(identity),
+			AcceptedPolicyVersion: identity.AcceptedPolicyVersion,
+		})
 	}
 }
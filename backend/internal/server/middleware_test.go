@@ -73,6 +73,37 @@ func TestWithCORS(t *testing.T) {
 	})
 }
 
+func TestCORSOriginAllowlist(t *testing.T) {
+	t.Parallel()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	app := &App{corsOrigin: "https://chat.example.com, https://staging.chat.example.com/"}
+
+	for origin, allowed := range map[string]bool{
+		"https://chat.example.com":         true,
+		"https://staging.chat.example.com": true,
+		"https://evil.example.com":         false,
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		request.Header.Set("Origin", origin)
+		response := httptest.NewRecorder()
+		app.withCORS(next).ServeHTTP(response, request)
+
+		got := response.Header().Get("Access-Control-Allow-Origin")
+		if allowed && got != origin {
+			t.Fatalf("expected %s to be allowed, got %q", origin, got)
+		}
+		if !allowed && got != "" {
+			t.Fatalf("expected %s to be rejected, got %q", origin, got)
+		}
+		if app.checkWSOrigin(request) != allowed {
+			t.Fatalf("expected websocket origin check for %s to be %v", origin, allowed)
+		}
+	}
+}
+
 func TestWithSecurityHeaders(t *testing.T) {
 	t.Parallel()
 
@@ -133,7 +164,7 @@ func TestWithAuthRequiresCSRFForCookieAuth(t *testing.T) {
 	t.Parallel()
 
 	app := &App{jwtSecret: []byte("0123456789abcdef0123456789abcdef")}
-	token, err := app.issueToken(1, "alice", "user", "device-test-1", 1)
+	token, err := app.issueToken(1, "alice", "user", roleGrant{}, "device-test-1", 1)
 	if err != nil {
 		t.Fatalf("issue token: %v", err)
 	}
@@ -152,24 +183,78 @@ func TestWithAuthRequiresCSRFForCookieAuth(t *testing.T) {
 	}
 }
 
-func TestWithAdmin(t *testing.T) {
+func TestWithPermission(t *testing.T) {
 	t.Parallel()
 
 	app := &App{}
-	handler := app.withAdmin(func(w http.ResponseWriter, _ *http.Request, _ AuthContext) {
+	handler := app.withPermission(permViewAudit, func(w http.ResponseWriter, _ *http.Request, _ AuthContext) {
 		w.WriteHeader(http.StatusNoContent)
 	})
 
-	request := httptest.NewRequest(http.MethodGet, "/api/admin/users", nil)
+	request := httptest.NewRequest(http.MethodGet, "/api/admin/audit-log", nil)
+	for _, tc := range []struct {
+		name string
+		auth AuthContext
+		want int
+	}{
+		{name: "user", auth: AuthContext{UserID: 2, Username: "bob", Role: "user"}, want: http.StatusForbidden},
+		{name: "other permission", auth: AuthContext{UserID: 3, Username: "carol", Role: "user", CustomRole: "support", Permissions: []string{permManageUsers}}, want: http.StatusForbidden},
+		{name: "custom role", auth: AuthContext{UserID: 4, Username: "dave", Role: "user", CustomRole: "auditor", Permissions: []string{permViewAudit}}, want: http.StatusNoContent},
+		{name: "admin", auth: AuthContext{UserID: 1, Username: "admin", Role: "admin"}, want: http.StatusNoContent},
+	} {
+		response := httptest.NewRecorder()
+		handler(response, request, tc.auth)
+		if response.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d", tc.name, tc.want, response.Code)
+		}
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	t.Parallel()
+
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestIDFromContext(r.Context())
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	request.Header.Set(requestIDHeader, "support-1234")
 	response := httptest.NewRecorder()
-	handler(response, request, AuthContext{UserID: 2, Username: "bob", Role: "user"})
-	if response.Code != http.StatusForbidden {
-		t.Fatalf("expected %d, got %d", http.StatusForbidden, response.Code)
+	handler.ServeHTTP(response, request)
+	if seen != "support-1234" || response.Header().Get(requestIDHeader) != "support-1234" {
+		t.Fatalf("expected caller request id to propagate, got context %q header %q", seen, response.Header().Get(requestIDHeader))
 	}
 
-	adminResponse := httptest.NewRecorder()
-	handler(adminResponse, request, AuthContext{UserID: 1, Username: "admin", Role: "admin"})
-	if adminResponse.Code != http.StatusNoContent {
-		t.Fatalf("expected %d, got %d", http.StatusNoContent, adminResponse.Code)
+	request = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	request.Header.Set(requestIDHeader, "bad id\nwith newline")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if seen == "" || seen == "bad id\nwith newline" {
+		t.Fatalf("expected invalid request id to be replaced, got %q", seen)
+	}
+	if response.Header().Get(requestIDHeader) != seen {
+		t.Fatalf("expected response header to match generated id")
+	}
+}
+
+func TestApplyReloadableConfigUpdatesCORSOrigin(t *testing.T) {
+	previous := logLevels.State()
+	t.Cleanup(func() {
+		level, _ := parseLogLevel(previous.ConfiguredLevel)
+		logLevels.Configure(level)
+	})
+
+	app := &App{corsOrigin: "https://old.example.com"}
+	app.applyReloadableConfig(runtimeConfig{CORSOrigin: "https://new.example.com"})
+
+	request := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	request.Header.Set("Origin", "https://new.example.com")
+	if !app.checkWSOrigin(request) {
+		t.Fatalf("expected reloaded origin to be accepted for websocket upgrades")
+	}
+	request.Header.Set("Origin", "https://old.example.com")
+	if app.checkWSOrigin(request) {
+		t.Fatalf("expected previous origin to be rejected after reload")
 	}
 }
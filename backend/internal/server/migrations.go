@@ -9,25 +9,41 @@ import (
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
-//go:embed migrations/*.sql
+//go:embed migrations/*.sql migrations/sqlite/*.sql
 var migrationsFS embed.FS
 
+// SQLite databases start from a baseline equivalent to the PostgreSQL
+// migrations of the same version; later versions ship a file in both
+// directories.
 func runMigrations(db *sql.DB) error {
-	driver, err := postgres.WithInstance(db, &postgres.Config{MigrationsTable: "schema_migrations"})
+	var (
+		driver     database.Driver
+		driverName = "postgres"
+		sourceDir  = "migrations"
+		err        error
+	)
+	if isSQLiteDB(db) {
+		driverName, sourceDir = "sqlite", "migrations/sqlite"
+		driver, err = sqlite.WithInstance(db, &sqlite.Config{MigrationsTable: "schema_migrations"})
+	} else {
+		driver, err = postgres.WithInstance(db, &postgres.Config{MigrationsTable: "schema_migrations"})
+	}
 	if err != nil {
 		return fmt.Errorf("initialize migration driver: %w", err)
 	}
 
-	sourceDriver, err := iofs.New(migrationsFS, "migrations")
+	sourceDriver, err := iofs.New(migrationsFS, sourceDir)
 	if err != nil {
 		return fmt.Errorf("initialize migration source: %w", err)
 	}
 
-	migrator, err := migrate.NewWithInstance("iofs", sourceDriver, "postgres", driver)
+	migrator, err := migrate.NewWithInstance("iofs", sourceDriver, driverName, driver)
 	if err != nil {
 		return fmt.Errorf("create migrator: %w", err)
 	}
@@ -38,7 +54,10 @@ func runMigrations(db *sql.DB) error {
 	return nil
 }
 
-func bootstrapAdminSecurity(db *sql.DB, adminUsername, adminPasswordHash, adminRoomName string) error {
+// bootstrapAdminSecurity makes adminUsername the owner of the admin room and
+// keeps the admin role to it, the existing accounts named in extraAdmins and
+// accounts promoted through an accepted admin invitation.
+func bootstrapAdminSecurity(db *sql.DB, adminUsername string, extraAdmins []string, adminPasswordHash, adminRoomName string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -48,9 +67,25 @@ func bootstrapAdminSecurity(db *sql.DB, adminUsername, adminPasswordHash, adminR
 	}
 	defer tx.Rollback()
 
+	if extraAdmins == nil {
+		extraAdmins = []string{}
+	}
+
+	// Only demote stray admins; bot accounts keep their role.
+	if _, err := tx.ExecContext(ctx, `
+UPDATE users SET role = 'user'
+WHERE role = 'admin'
+  AND username <> $1
+  AND NOT (username = ANY($2::TEXT[]))
+  AND admin_granted_at IS NULL
+`, adminUsername, extraAdmins); err != nil {
+		return err
+	}
+	// Configured admins sign in with their own password, so only accounts
+	// that already exist can be promoted.
 	if _, err := tx.ExecContext(ctx,
-		`UPDATE users SET role = 'user' WHERE username <> $1`,
-		adminUsername,
+		`UPDATE users SET role = 'admin' WHERE username = ANY($1::TEXT[]) AND role = 'user' AND deleted_at IS NULL`,
+		extraAdmins,
 	); err != nil {
 		return err
 	}
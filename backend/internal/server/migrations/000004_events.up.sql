@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS events (
+    id BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    message_id BIGINT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    actor_id BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_room_id_id
+    ON events(room_id, id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS message_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    payload JSONB NOT NULL,
+    authored_at TIMESTAMPTZ NOT NULL,
+    replaced_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_revisions_message_id
+    ON message_revisions(message_id, id);
@@ -0,0 +1,7 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS revoked_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL;
+
+UPDATE messages
+SET revoked_by = sender_id
+WHERE revoked_at IS NOT NULL
+  AND revoked_by IS NULL;
@@ -0,0 +1,8 @@
+ALTER TABLE room_members
+    DROP CONSTRAINT IF EXISTS room_members_notification_mode_check;
+
+ALTER TABLE room_members
+    DROP COLUMN IF EXISTS muted_until;
+
+ALTER TABLE room_members
+    DROP COLUMN IF EXISTS notification_mode;
@@ -0,0 +1,18 @@
+ALTER TABLE room_members
+    ADD COLUMN IF NOT EXISTS notification_mode TEXT NOT NULL DEFAULT 'all';
+
+ALTER TABLE room_members
+    ADD COLUMN IF NOT EXISTS muted_until TIMESTAMPTZ NULL;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conname = 'room_members_notification_mode_check'
+    ) THEN
+        ALTER TABLE room_members
+            ADD CONSTRAINT room_members_notification_mode_check
+            CHECK (notification_mode IN ('all', 'mentions_only', 'muted'));
+    END IF;
+END
+$$;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_messages_room_id_created_at ON messages(room_id, created_at DESC, id DESC);
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS trg_room_message_stats_delete ON messages;
+DROP TRIGGER IF EXISTS trg_room_message_stats_update ON messages;
+DROP TRIGGER IF EXISTS trg_room_message_stats_insert ON messages;
+DROP FUNCTION IF EXISTS room_message_stats_apply();
+DROP TABLE IF EXISTS room_sender_message_stats;
+DROP TABLE IF EXISTS room_message_stats;
@@ -0,0 +1,83 @@
+CREATE TABLE IF NOT EXISTS room_message_stats (
+    room_id BIGINT PRIMARY KEY REFERENCES rooms(id) ON DELETE CASCADE,
+    message_count BIGINT NOT NULL DEFAULT 0,
+    payload_bytes BIGINT NOT NULL DEFAULT 0,
+    first_message_at TIMESTAMPTZ NULL,
+    last_message_at TIMESTAMPTZ NULL
+);
+
+CREATE TABLE IF NOT EXISTS room_sender_message_stats (
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_count BIGINT NOT NULL DEFAULT 0,
+    payload_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (room_id, sender_id)
+);
+
+CREATE OR REPLACE FUNCTION room_message_stats_apply() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO room_message_stats(room_id, message_count, payload_bytes, first_message_at, last_message_at)
+        VALUES (NEW.room_id, 1, octet_length(NEW.payload::text), NEW.created_at, NEW.created_at)
+        ON CONFLICT (room_id) DO UPDATE SET
+            message_count = room_message_stats.message_count + 1,
+            payload_bytes = room_message_stats.payload_bytes + EXCLUDED.payload_bytes,
+            first_message_at = LEAST(room_message_stats.first_message_at, EXCLUDED.first_message_at),
+            last_message_at = GREATEST(room_message_stats.last_message_at, EXCLUDED.last_message_at);
+
+        INSERT INTO room_sender_message_stats(room_id, sender_id, message_count, payload_bytes)
+        VALUES (NEW.room_id, NEW.sender_id, 1, octet_length(NEW.payload::text))
+        ON CONFLICT (room_id, sender_id) DO UPDATE SET
+            message_count = room_sender_message_stats.message_count + 1,
+            payload_bytes = room_sender_message_stats.payload_bytes + EXCLUDED.payload_bytes;
+        RETURN NEW;
+    ELSIF TG_OP = 'UPDATE' THEN
+        UPDATE room_message_stats
+        SET payload_bytes = payload_bytes + octet_length(NEW.payload::text) - octet_length(OLD.payload::text)
+        WHERE room_id = NEW.room_id;
+        UPDATE room_sender_message_stats
+        SET payload_bytes = payload_bytes + octet_length(NEW.payload::text) - octet_length(OLD.payload::text)
+        WHERE room_id = NEW.room_id AND sender_id = NEW.sender_id;
+        RETURN NEW;
+    ELSE
+        UPDATE room_message_stats
+        SET message_count = message_count - 1,
+            payload_bytes = payload_bytes - octet_length(OLD.payload::text),
+            first_message_at = (SELECT MIN(created_at) FROM messages WHERE room_id = OLD.room_id),
+            last_message_at = (SELECT MAX(created_at) FROM messages WHERE room_id = OLD.room_id)
+        WHERE room_id = OLD.room_id;
+        UPDATE room_sender_message_stats
+        SET message_count = message_count - 1,
+            payload_bytes = payload_bytes - octet_length(OLD.payload::text)
+        WHERE room_id = OLD.room_id AND sender_id = OLD.sender_id;
+        RETURN OLD;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_room_message_stats_insert ON messages;
+CREATE TRIGGER trg_room_message_stats_insert
+    AFTER INSERT ON messages
+    FOR EACH ROW EXECUTE FUNCTION room_message_stats_apply();
+
+DROP TRIGGER IF EXISTS trg_room_message_stats_update ON messages;
+CREATE TRIGGER trg_room_message_stats_update
+    AFTER UPDATE OF payload ON messages
+    FOR EACH ROW EXECUTE FUNCTION room_message_stats_apply();
+
+DROP TRIGGER IF EXISTS trg_room_message_stats_delete ON messages;
+CREATE TRIGGER trg_room_message_stats_delete
+    AFTER DELETE ON messages
+    FOR EACH ROW EXECUTE FUNCTION room_message_stats_apply();
+
+INSERT INTO room_message_stats(room_id, message_count, payload_bytes, first_message_at, last_message_at)
+SELECT room_id, COUNT(*), COALESCE(SUM(octet_length(payload::text)), 0), MIN(created_at), MAX(created_at)
+FROM messages
+GROUP BY room_id
+ON CONFLICT (room_id) DO NOTHING;
+
+INSERT INTO room_sender_message_stats(room_id, sender_id, message_count, payload_bytes)
+SELECT room_id, sender_id, COUNT(*), COALESCE(SUM(octet_length(payload::text)), 0)
+FROM messages
+GROUP BY room_id, sender_id
+ON CONFLICT (room_id, sender_id) DO NOTHING;
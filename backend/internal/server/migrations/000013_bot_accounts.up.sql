@@ -0,0 +1,19 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users
+    ADD CONSTRAINT users_role_check
+    CHECK (role IN ('admin', 'user', 'bot'));
+
+CREATE TABLE IF NOT EXISTS bot_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    bot_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    room_ids BIGINT[] NOT NULL,
+    created_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NULL,
+    revoked_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_bot_tokens_bot_user_id
+    ON bot_tokens(bot_user_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS federation_inbound;
+DROP TABLE IF EXISTS federation_outbox;
+DROP TABLE IF EXISTS federation_room_links;
+DROP TABLE IF EXISTS federation_peers;
@@ -0,0 +1,51 @@
+CREATE TABLE IF NOT EXISTS federation_peers (
+    id BIGSERIAL PRIMARY KEY,
+    server_id TEXT NOT NULL UNIQUE,
+    endpoint_url TEXT NOT NULL,
+    shared_secret TEXT NOT NULL,
+    relay_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    disabled_at TIMESTAMPTZ NULL
+);
+
+CREATE TABLE IF NOT EXISTS federation_room_links (
+    id BIGSERIAL PRIMARY KEY,
+    peer_id BIGINT NOT NULL REFERENCES federation_peers(id) ON DELETE CASCADE,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    remote_room_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (peer_id, room_id),
+    UNIQUE (peer_id, remote_room_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_room_links_room_id
+    ON federation_room_links(room_id);
+
+CREATE TABLE IF NOT EXISTS federation_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    peer_id BIGINT NOT NULL REFERENCES federation_peers(id) ON DELETE CASCADE,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_status_code INT NULL,
+    last_error TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_outbox_pending
+    ON federation_outbox(next_attempt_at)
+    WHERE status = 'pending';
+
+-- Relayed messages are keyed by where they were first written, so a message
+-- reaching this server over two paths is stored once.
+CREATE TABLE IF NOT EXISTS federation_inbound (
+    origin_server TEXT NOT NULL,
+    origin_message_id BIGINT NOT NULL,
+    peer_id BIGINT NULL REFERENCES federation_peers(id) ON DELETE SET NULL,
+    message_id BIGINT NULL REFERENCES messages(id) ON DELETE SET NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (origin_server, origin_message_id)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS ip_denylist (
+    id BIGSERIAL PRIMARY KEY,
+    cidr CIDR NOT NULL UNIQUE,
+    reason TEXT NOT NULL DEFAULT '',
+    created_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS forwarded_from_message_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS forwarded_from_room_id;
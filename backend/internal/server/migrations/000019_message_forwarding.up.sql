@@ -0,0 +1,2 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS forwarded_from_room_id BIGINT NULL REFERENCES rooms(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS forwarded_from_message_id BIGINT NULL REFERENCES messages(id) ON DELETE SET NULL;
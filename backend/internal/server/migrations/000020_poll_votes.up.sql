@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS poll_votes (
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    option_index INT NOT NULL CHECK (option_index >= 0),
+    voted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id)
+);
@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS signal_identity_keys (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    identity_key_jwk JSONB NOT NULL,
+    identity_signing_public_key_jwk JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS signal_identity_key_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    fingerprint TEXT NOT NULL,
+    identity_key_jwk JSONB NOT NULL,
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE(user_id, fingerprint)
+);
+
+CREATE TABLE IF NOT EXISTS signal_signed_prekeys (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    key_id BIGINT NOT NULL,
+    public_key_jwk JSONB NOT NULL,
+    signature TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS signal_one_time_prekeys (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key_id BIGINT NOT NULL,
+    public_key_jwk JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    consumed_at TIMESTAMPTZ NULL,
+    PRIMARY KEY (user_id, key_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_signal_one_time_prekeys_available
+    ON signal_one_time_prekeys(user_id, consumed_at, key_id);
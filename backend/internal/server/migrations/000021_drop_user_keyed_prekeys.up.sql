@@ -0,0 +1,6 @@
+-- Prekey storage moved to the signal_device_* tables in 000003; the
+-- user-keyed tables have been empty and unused since.
+DROP TABLE IF EXISTS signal_one_time_prekeys;
+DROP TABLE IF EXISTS signal_signed_prekeys;
+DROP TABLE IF EXISTS signal_identity_key_history;
+DROP TABLE IF EXISTS signal_identity_keys;
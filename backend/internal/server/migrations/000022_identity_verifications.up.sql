@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS identity_verifications (
+    verifier_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    fingerprint TEXT NOT NULL,
+    verified_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (verifier_id, target_user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_identity_verifications_target
+    ON identity_verifications(target_user_id, fingerprint);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS account_key_backups (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL CHECK (version > 0),
+    ciphertext TEXT NOT NULL,
+    verification_hash TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
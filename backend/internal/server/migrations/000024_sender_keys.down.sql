@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS room_sender_key_recipients;
+DROP TABLE IF EXISTS room_sender_keys;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS room_sender_keys (
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    sender_id BIGINT NOT NULL,
+    sender_device_id TEXT NOT NULL,
+    key_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (room_id, sender_id, sender_device_id),
+    FOREIGN KEY (sender_id, sender_device_id)
+        REFERENCES user_devices(user_id, device_id)
+        ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS room_sender_key_recipients (
+    room_id BIGINT NOT NULL,
+    sender_id BIGINT NOT NULL,
+    sender_device_id TEXT NOT NULL,
+    recipient_user_id BIGINT NOT NULL,
+    recipient_device_id TEXT NOT NULL,
+    key_id TEXT NOT NULL,
+    distributed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (room_id, sender_id, sender_device_id, recipient_user_id, recipient_device_id),
+    FOREIGN KEY (room_id, sender_id, sender_device_id)
+        REFERENCES room_sender_keys(room_id, sender_id, sender_device_id)
+        ON DELETE CASCADE,
+    FOREIGN KEY (recipient_user_id, recipient_device_id)
+        REFERENCES user_devices(user_id, device_id)
+        ON DELETE CASCADE
+);
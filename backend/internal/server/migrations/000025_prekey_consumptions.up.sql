@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS signal_prekey_consumptions (
+    id BIGSERIAL PRIMARY KEY,
+    owner_user_id BIGINT NOT NULL,
+    owner_device_id TEXT NOT NULL,
+    key_id BIGINT NULL,
+    requester_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requester_device_id TEXT NOT NULL,
+    consumed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (owner_user_id, owner_device_id)
+        REFERENCES user_devices(user_id, device_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_signal_prekey_consumptions_owner
+    ON signal_prekey_consumptions(owner_user_id, consumed_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_signal_prekey_consumptions_requester
+    ON signal_prekey_consumptions(requester_user_id, owner_user_id, consumed_at);
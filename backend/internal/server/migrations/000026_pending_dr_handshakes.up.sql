@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS pending_dr_handshakes (
+    id BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    from_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    from_device_id TEXT NOT NULL,
+    to_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    to_device_id TEXT NOT NULL DEFAULT '',
+    frame JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_pending_dr_handshakes_recipient
+    ON pending_dr_handshakes(to_user_id, to_device_id, id);
+
+CREATE INDEX IF NOT EXISTS idx_pending_dr_handshakes_expires
+    ON pending_dr_handshakes(expires_at);
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS user_quotas (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    daily_message_limit INTEGER NULL,
+    storage_quota_bytes BIGINT NULL,
+    updated_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS user_quota_usage (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    usage_day DATE NOT NULL,
+    messages_today INTEGER NOT NULL DEFAULT 0,
+    stored_bytes BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO user_quota_usage(user_id, usage_day, messages_today, stored_bytes)
+SELECT sender_id, (NOW() AT TIME ZONE 'UTC')::date, 0, SUM(octet_length(payload::text))
+FROM messages
+GROUP BY sender_id
+ON CONFLICT (user_id) DO NOTHING;
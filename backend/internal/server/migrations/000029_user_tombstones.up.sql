@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS former_username TEXT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at
+    ON users(deleted_at)
+    WHERE deleted_at IS NOT NULL;
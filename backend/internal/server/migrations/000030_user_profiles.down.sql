@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS profile_updated_at;
+ALTER TABLE users DROP COLUMN IF EXISTS bio;
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
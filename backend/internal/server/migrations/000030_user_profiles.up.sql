@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio TEXT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_updated_at TIMESTAMPTZ NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS hide_last_seen;
+ALTER TABLE users DROP COLUMN IF EXISTS last_seen_at;
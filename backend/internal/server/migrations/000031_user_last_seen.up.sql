@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS hide_last_seen BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users u
+SET last_seen_at = d.last_seen_at
+FROM (
+    SELECT user_id, MAX(last_seen_at) AS last_seen_at
+    FROM user_devices
+    GROUP BY user_id
+) d
+WHERE d.user_id = u.id;
@@ -0,0 +1 @@
+ALTER TABLE rooms DROP COLUMN IF EXISTS guest_links_revoked_at;
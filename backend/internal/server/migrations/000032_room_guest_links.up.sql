@@ -0,0 +1 @@
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS guest_links_revoked_at TIMESTAMPTZ NULL;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    target_user_id BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    details JSONB NOT NULL DEFAULT '{}'::jsonb,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_created_at
+    ON admin_audit_log(created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_target
+    ON admin_audit_log(target_user_id, created_at DESC)
+    WHERE target_user_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS room_join_requests;
+ALTER TABLE rooms DROP COLUMN IF EXISTS knock_enabled;
@@ -0,0 +1,15 @@
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS knock_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS room_join_requests (
+    id BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'denied')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    decided_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMPTZ NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_room_join_requests_pending
+    ON room_join_requests(room_id, user_id)
+    WHERE status = 'pending';
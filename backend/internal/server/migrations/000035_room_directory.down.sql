@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_rooms_directory;
+ALTER TABLE rooms DROP COLUMN IF EXISTS visibility;
@@ -0,0 +1,7 @@
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'private'
+        CHECK (visibility IN ('private', 'directory'));
+
+CREATE INDEX IF NOT EXISTS idx_rooms_directory
+    ON rooms(id)
+    WHERE visibility = 'directory';
@@ -0,0 +1,3 @@
+ALTER TABLE rooms
+    DROP COLUMN IF EXISTS allowed_encryption_schemes,
+    DROP COLUMN IF EXISTS min_payload_version;
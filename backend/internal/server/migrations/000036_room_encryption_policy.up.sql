@@ -0,0 +1,4 @@
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS min_payload_version INTEGER NOT NULL DEFAULT 3
+        CHECK (min_payload_version >= 3),
+    ADD COLUMN IF NOT EXISTS allowed_encryption_schemes TEXT[] NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS undelivered_events (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event_id BIGINT NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL CHECK (reason IN ('queue_full', 'offline')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_undelivered_events_created_at
+    ON undelivered_events(created_at);
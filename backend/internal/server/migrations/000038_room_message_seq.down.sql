@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS trg_messages_assign_room_seq ON messages;
+DROP FUNCTION IF EXISTS messages_assign_room_seq();
+DROP INDEX IF EXISTS idx_messages_room_seq;
+ALTER TABLE messages DROP COLUMN IF EXISTS room_seq;
+ALTER TABLE rooms DROP COLUMN IF EXISTS last_message_seq;
@@ -0,0 +1,44 @@
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS last_message_seq BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS room_seq BIGINT NULL;
+
+UPDATE messages m
+SET room_seq = numbered.seq
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY id) AS seq
+    FROM messages
+) numbered
+WHERE m.id = numbered.id;
+
+UPDATE rooms r
+SET last_message_seq = COALESCE((SELECT MAX(m.room_seq) FROM messages m WHERE m.room_id = r.id), 0);
+
+ALTER TABLE messages
+    ALTER COLUMN room_seq SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_room_seq
+    ON messages(room_id, room_seq);
+
+-- Updating the room row locks it until commit, so concurrent inserts into one
+-- room take numbers in commit order. Rows that arrive with a sequence, such as
+-- a backup restore, keep it and only move the counter forward.
+CREATE OR REPLACE FUNCTION messages_assign_room_seq() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.room_seq IS NULL THEN
+        UPDATE rooms SET last_message_seq = last_message_seq + 1
+        WHERE id = NEW.room_id
+        RETURNING last_message_seq INTO NEW.room_seq;
+    ELSE
+        UPDATE rooms SET last_message_seq = GREATEST(last_message_seq, NEW.room_seq)
+        WHERE id = NEW.room_id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_messages_assign_room_seq ON messages;
+CREATE TRIGGER trg_messages_assign_room_seq
+    BEFORE INSERT ON messages
+    FOR EACH ROW EXECUTE FUNCTION messages_assign_room_seq();
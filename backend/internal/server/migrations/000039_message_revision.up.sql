@@ -0,0 +1,6 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS revision INT NOT NULL DEFAULT 0;
+
+UPDATE messages m
+SET revision = (SELECT COUNT(*) FROM message_revisions mr WHERE mr.message_id = m.id)
+WHERE m.edited_at IS NOT NULL OR m.revoked_at IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS admin_invitations;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS admin_granted_by,
+    DROP COLUMN IF EXISTS admin_granted_at;
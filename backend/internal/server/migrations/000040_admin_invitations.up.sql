@@ -0,0 +1,10 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS admin_granted_at TIMESTAMPTZ NULL,
+    ADD COLUMN IF NOT EXISTS admin_granted_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS admin_invitations (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    invited_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_custom_role;
+
+ALTER TABLE users DROP COLUMN IF EXISTS custom_role;
+
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name TEXT PRIMARY KEY,
+    permissions TEXT[] NOT NULL DEFAULT '{}',
+    version INT NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS custom_role TEXT NULL REFERENCES roles(name) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_custom_role ON users(custom_role) WHERE custom_role IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS account_onboarding_tokens;
+DROP TABLE IF EXISTS email_outbox;
+DROP INDEX IF EXISTS idx_users_email_unique;
+
+ALTER TABLE users DROP COLUMN IF EXISTS email;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS password_reset_requests (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending',
+    requested_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    requested_ip TEXT NOT NULL DEFAULT '',
+    decided_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMPTZ NULL,
+    token_hash TEXT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NULL,
+    used_at TIMESTAMPTZ NULL
+);
+
+-- One open request per user: repeated requests do not pile up in the queue.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_password_reset_requests_open
+    ON password_reset_requests(user_id) WHERE status IN ('pending', 'approved');
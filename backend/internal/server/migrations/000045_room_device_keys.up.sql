@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS room_device_keys (
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL,
+    device_id TEXT NOT NULL,
+    public_key_jwk JSONB NOT NULL,
+    signing_public_key_jwk JSONB NOT NULL,
+    signature TEXT NOT NULL,
+    announced_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (room_id, user_id, device_id),
+    FOREIGN KEY (user_id, device_id)
+        REFERENCES user_devices(user_id, device_id)
+        ON DELETE CASCADE
+);
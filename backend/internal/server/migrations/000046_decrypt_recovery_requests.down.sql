@@ -0,0 +1 @@
+DROP TABLE IF EXISTS decrypt_recovery_requests;
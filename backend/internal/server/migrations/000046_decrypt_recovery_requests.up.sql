@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS decrypt_recovery_requests (
+    id BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    requester_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requester_device_id TEXT NOT NULL,
+    sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    sender_device_id TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL DEFAULT 'resync',
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'delivered', 'fulfilled')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_attempt_at TIMESTAMPTZ,
+    fulfilled_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (message_id, requester_id, requester_device_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_decrypt_recovery_requests_sender
+    ON decrypt_recovery_requests(room_id, sender_id, id)
+    WHERE status <> 'fulfilled';
+
+CREATE INDEX IF NOT EXISTS idx_decrypt_recovery_requests_expires
+    ON decrypt_recovery_requests(expires_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS history_backfill_keys;
+DROP TABLE IF EXISTS history_backfills;
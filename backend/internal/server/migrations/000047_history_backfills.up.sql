@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS history_backfills (
+    id BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    requester_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requester_device_id TEXT NOT NULL,
+    from_message_id BIGINT NOT NULL,
+    up_to_message_id BIGINT NOT NULL,
+    message_count INTEGER NOT NULL,
+    keys_delivered INTEGER NOT NULL DEFAULT 0,
+    responses INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'open'
+        CHECK (status IN ('open', 'completed')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (room_id, requester_id, requester_device_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_history_backfills_open
+    ON history_backfills(room_id, id)
+    WHERE status = 'open';
+
+CREATE TABLE IF NOT EXISTS history_backfill_keys (
+    backfill_id BIGINT NOT NULL REFERENCES history_backfills(id) ON DELETE CASCADE,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    responder_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    delivered_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (backfill_id, message_id)
+);
@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS trg_messages_assign_key_epoch ON messages;
+DROP FUNCTION IF EXISTS messages_assign_key_epoch();
+DROP TRIGGER IF EXISTS trg_room_members_bump_key_epoch ON room_members;
+DROP FUNCTION IF EXISTS room_members_bump_key_epoch();
+
+ALTER TABLE messages DROP COLUMN IF EXISTS key_epoch;
+ALTER TABLE rooms
+    DROP COLUMN IF EXISTS key_epoch_changed_at,
+    DROP COLUMN IF EXISTS key_epoch;
@@ -0,0 +1,44 @@
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS key_epoch BIGINT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS key_epoch_changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS key_epoch BIGINT NULL;
+
+-- Any membership change means the next message must be keyed for a different
+-- set of devices, so the room moves to a new epoch. Running in the same
+-- statement keeps the bump atomic with the membership change.
+CREATE OR REPLACE FUNCTION room_members_bump_key_epoch() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE rooms
+    SET key_epoch = key_epoch + 1, key_epoch_changed_at = NOW()
+    WHERE id = COALESCE(NEW.room_id, OLD.room_id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_room_members_bump_key_epoch ON room_members;
+CREATE TRIGGER trg_room_members_bump_key_epoch
+    AFTER INSERT OR DELETE ON room_members
+    FOR EACH ROW EXECUTE FUNCTION room_members_bump_key_epoch();
+
+-- Messages keep the epoch their payload was tagged with. Untagged payloads
+-- from clients that do not track epochs are recorded under the room's
+-- current one.
+CREATE OR REPLACE FUNCTION messages_assign_key_epoch() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.key_epoch IS NULL THEN
+        IF (NEW.payload ->> 'keyEpoch') ~ '^[1-9][0-9]{0,17}$' THEN
+            NEW.key_epoch := (NEW.payload ->> 'keyEpoch')::BIGINT;
+        ELSE
+            SELECT key_epoch INTO NEW.key_epoch FROM rooms WHERE id = NEW.room_id;
+        END IF;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_messages_assign_key_epoch ON messages;
+CREATE TRIGGER trg_messages_assign_key_epoch
+    BEFORE INSERT ON messages
+    FOR EACH ROW EXECUTE FUNCTION messages_assign_key_epoch();
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS admin_broadcasts (
+    id BIGSERIAL PRIMARY KEY,
+    message TEXT NOT NULL,
+    severity TEXT NOT NULL DEFAULT 'info' CHECK (severity IN ('info', 'warning', 'critical')),
+    audience TEXT NOT NULL CHECK (audience IN ('all', 'room', 'role')),
+    room_id BIGINT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    role TEXT NULL,
+    created_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    signature TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    CHECK ((audience = 'room') = (room_id IS NOT NULL)),
+    CHECK ((audience = 'role') = (role IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_broadcasts_expires_at
+    ON admin_broadcasts(expires_at);
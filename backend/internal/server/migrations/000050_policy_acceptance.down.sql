@@ -0,0 +1,5 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS policy_accepted_at,
+    DROP COLUMN IF EXISTS accepted_policy_version;
+
+DROP TABLE IF EXISTS policy_versions;
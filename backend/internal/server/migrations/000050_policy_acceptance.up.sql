@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS policy_versions (
+    version INTEGER PRIMARY KEY CHECK (version > 0),
+    note TEXT NOT NULL DEFAULT '',
+    created_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS accepted_policy_version INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS policy_accepted_at TIMESTAMPTZ NULL;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_users_erasure_scheduled_at;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS erased_at,
+    DROP COLUMN IF EXISTS erasure_scheduled_at,
+    DROP COLUMN IF EXISTS erasure_requested_at;
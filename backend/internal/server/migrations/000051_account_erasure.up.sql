@@ -0,0 +1,8 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS erasure_requested_at TIMESTAMPTZ NULL,
+    ADD COLUMN IF NOT EXISTS erasure_scheduled_at TIMESTAMPTZ NULL,
+    ADD COLUMN IF NOT EXISTS erased_at TIMESTAMPTZ NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_erasure_scheduled_at
+    ON users(erasure_scheduled_at)
+    WHERE erasure_scheduled_at IS NOT NULL;
@@ -0,0 +1,9 @@
+ALTER TABLE rooms
+    DROP COLUMN IF EXISTS legal_hold_by,
+    DROP COLUMN IF EXISTS legal_hold_reason,
+    DROP COLUMN IF EXISTS legal_hold_at;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS legal_hold_by,
+    DROP COLUMN IF EXISTS legal_hold_reason,
+    DROP COLUMN IF EXISTS legal_hold_at;
@@ -0,0 +1,9 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS legal_hold_at TIMESTAMPTZ NULL,
+    ADD COLUMN IF NOT EXISTS legal_hold_reason TEXT NULL,
+    ADD COLUMN IF NOT EXISTS legal_hold_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS legal_hold_at TIMESTAMPTZ NULL,
+    ADD COLUMN IF NOT EXISTS legal_hold_reason TEXT NULL,
+    ADD COLUMN IF NOT EXISTS legal_hold_by BIGINT NULL REFERENCES users(id) ON DELETE SET NULL;
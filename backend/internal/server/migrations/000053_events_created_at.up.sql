@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_events_created_at
+    ON events(created_at);
@@ -0,0 +1,58 @@
+-- Reverses 000052_baseline.up.sql.
+DROP TRIGGER IF EXISTS trg_messages_assign_room_seq;
+DROP TRIGGER IF EXISTS trg_messages_keep_room_seq;
+DROP TRIGGER IF EXISTS trg_messages_stamp;
+DROP TRIGGER IF EXISTS trg_room_message_stats_insert;
+DROP TRIGGER IF EXISTS trg_room_message_stats_update;
+DROP TRIGGER IF EXISTS trg_room_message_stats_delete;
+DROP TRIGGER IF EXISTS trg_room_members_bump_key_epoch_insert;
+DROP TRIGGER IF EXISTS trg_room_members_bump_key_epoch_delete;
+DROP TABLE IF EXISTS policy_versions;
+DROP TABLE IF EXISTS admin_broadcasts;
+DROP TABLE IF EXISTS history_backfill_keys;
+DROP TABLE IF EXISTS history_backfills;
+DROP TABLE IF EXISTS decrypt_recovery_requests;
+DROP TABLE IF EXISTS room_device_keys;
+DROP TABLE IF EXISTS feature_flags;
+DROP TABLE IF EXISTS password_reset_requests;
+DROP TABLE IF EXISTS account_onboarding_tokens;
+DROP TABLE IF EXISTS email_outbox;
+DROP TABLE IF EXISTS admin_invitations;
+DROP TABLE IF EXISTS undelivered_events;
+DROP TABLE IF EXISTS room_join_requests;
+DROP TABLE IF EXISTS admin_audit_log;
+DROP TABLE IF EXISTS user_quota_usage;
+DROP TABLE IF EXISTS user_quotas;
+DROP TABLE IF EXISTS pending_dr_handshakes;
+DROP TABLE IF EXISTS signal_prekey_consumptions;
+DROP TABLE IF EXISTS room_sender_key_recipients;
+DROP TABLE IF EXISTS room_sender_keys;
+DROP TABLE IF EXISTS account_key_backups;
+DROP TABLE IF EXISTS identity_verifications;
+DROP TABLE IF EXISTS poll_votes;
+DROP TABLE IF EXISTS user_blocks;
+DROP TABLE IF EXISTS message_reports;
+DROP TABLE IF EXISTS ip_denylist;
+DROP TABLE IF EXISTS federation_inbound;
+DROP TABLE IF EXISTS federation_outbox;
+DROP TABLE IF EXISTS federation_room_links;
+DROP TABLE IF EXISTS federation_peers;
+DROP TABLE IF EXISTS bot_tokens;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS room_webhooks;
+DROP TABLE IF EXISTS room_sender_message_stats;
+DROP TABLE IF EXISTS room_message_stats;
+DROP TABLE IF EXISTS message_mentions;
+DROP TABLE IF EXISTS message_revisions;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS signal_device_one_time_prekeys;
+DROP TABLE IF EXISTS signal_device_signed_prekeys;
+DROP TABLE IF EXISTS signal_device_identity_key_history;
+DROP TABLE IF EXISTS signal_device_identity_keys;
+DROP TABLE IF EXISTS user_devices;
+DROP TABLE IF EXISTS auth_refresh_tokens;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS room_members;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS roles;
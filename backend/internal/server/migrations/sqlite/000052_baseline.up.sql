@@ -0,0 +1,729 @@
+-- SQLite schema equivalent to PostgreSQL migrations 000001 through 000052;
+-- a later schema change adds its SQLite migration here under the same
+-- version. Timestamps are UTC text in the form NOW() produces through the
+-- sqlite driver, arrays and JSONB values are JSON text, and the plpgsql
+-- triggers are rewritten as SQLite triggers.
+
+CREATE TABLE roles (
+    name TEXT PRIMARY KEY,
+    permissions TEXT NOT NULL DEFAULT '[]',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT UNIQUE NOT NULL,
+    password_hash TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('admin', 'user', 'bot')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    suspended_at TIMESTAMPTZ NULL,
+    deleted_at TIMESTAMPTZ NULL,
+    former_username TEXT NULL,
+    display_name TEXT NULL,
+    bio TEXT NULL,
+    profile_updated_at TIMESTAMPTZ NULL,
+    last_seen_at TIMESTAMPTZ NULL,
+    hide_last_seen BOOLEAN NOT NULL DEFAULT FALSE,
+    admin_granted_at TIMESTAMPTZ NULL,
+    admin_granted_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    custom_role TEXT NULL REFERENCES roles(name) ON DELETE SET NULL,
+    email TEXT NULL,
+    accepted_policy_version INTEGER NOT NULL DEFAULT 0,
+    policy_accepted_at TIMESTAMPTZ NULL,
+    erasure_requested_at TIMESTAMPTZ NULL,
+    erasure_scheduled_at TIMESTAMPTZ NULL,
+    erased_at TIMESTAMPTZ NULL,
+    legal_hold_at TIMESTAMPTZ NULL,
+    legal_hold_reason TEXT NULL,
+    legal_hold_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_users_custom_role ON users(custom_role) WHERE custom_role IS NOT NULL;
+CREATE UNIQUE INDEX idx_users_email_unique ON users(LOWER(email)) WHERE email IS NOT NULL;
+CREATE INDEX idx_users_erasure_scheduled_at ON users(erasure_scheduled_at) WHERE erasure_scheduled_at IS NOT NULL;
+
+CREATE TABLE rooms (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE NOT NULL,
+    created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    is_system BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    announcement_only BOOLEAN NOT NULL DEFAULT FALSE,
+    allowed_content_types TEXT NULL,
+    guest_links_revoked_at TIMESTAMPTZ NULL,
+    knock_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    visibility TEXT NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'directory')),
+    min_payload_version INTEGER NOT NULL DEFAULT 3 CHECK (min_payload_version >= 3),
+    allowed_encryption_schemes TEXT NULL,
+    last_message_seq INTEGER NOT NULL DEFAULT 0,
+    key_epoch INTEGER NOT NULL DEFAULT 1,
+    key_epoch_changed_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    legal_hold_at TIMESTAMPTZ NULL,
+    legal_hold_reason TEXT NULL,
+    legal_hold_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_rooms_directory ON rooms(id) WHERE visibility = 'directory';
+
+CREATE TABLE room_members (
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    last_read_message_id INTEGER NOT NULL DEFAULT 0,
+    notification_mode TEXT NOT NULL DEFAULT 'all' CHECK (notification_mode IN ('all', 'mentions_only', 'muted')),
+    muted_until TIMESTAMPTZ NULL,
+    PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX idx_room_members_user_id ON room_members(user_id);
+
+CREATE TABLE messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    sender_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    edited_at TIMESTAMPTZ NULL,
+    revoked_at TIMESTAMPTZ NULL,
+    revoked_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    forwarded_from_room_id INTEGER NULL REFERENCES rooms(id) ON DELETE SET NULL,
+    forwarded_from_message_id INTEGER NULL REFERENCES messages(id) ON DELETE SET NULL,
+    -- Assigned by trg_messages_stamp right after the insert.
+    room_seq INTEGER NULL,
+    revision INTEGER NOT NULL DEFAULT 0,
+    key_epoch INTEGER NULL
+);
+
+CREATE INDEX idx_messages_room_id_id ON messages(room_id, id DESC);
+CREATE INDEX idx_messages_room_id_created_at ON messages(room_id, created_at DESC, id DESC);
+CREATE INDEX idx_messages_created_at ON messages(created_at DESC);
+CREATE UNIQUE INDEX idx_messages_room_seq ON messages(room_id, room_seq);
+
+CREATE TABLE auth_refresh_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    last_used_at TIMESTAMPTZ NULL,
+    revoked_at TIMESTAMPTZ NULL,
+    device_id TEXT NOT NULL,
+    device_session_version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT auth_refresh_tokens_user_device_fk
+        FOREIGN KEY (user_id, device_id)
+        REFERENCES user_devices(user_id, device_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX idx_auth_refresh_tokens_user_id ON auth_refresh_tokens(user_id);
+CREATE INDEX idx_auth_refresh_tokens_expires_at ON auth_refresh_tokens(expires_at);
+CREATE INDEX idx_auth_refresh_tokens_user_device ON auth_refresh_tokens(user_id, device_id);
+
+CREATE TABLE user_devices (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id TEXT NOT NULL,
+    device_name TEXT NOT NULL DEFAULT 'Unnamed device',
+    session_version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    revoked_at TIMESTAMPTZ NULL,
+    PRIMARY KEY (user_id, device_id)
+);
+
+CREATE INDEX idx_user_devices_user_id_last_seen ON user_devices(user_id, last_seen_at DESC);
+CREATE INDEX idx_user_devices_active ON user_devices(user_id, revoked_at) WHERE revoked_at IS NULL;
+
+CREATE TABLE signal_device_identity_keys (
+    user_id INTEGER NOT NULL,
+    device_id TEXT NOT NULL,
+    identity_key_jwk TEXT NOT NULL,
+    identity_signing_public_key_jwk TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (user_id, device_id),
+    FOREIGN KEY (user_id, device_id) REFERENCES user_devices(user_id, device_id) ON DELETE CASCADE
+);
+
+CREATE TABLE signal_device_identity_key_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    device_id TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    identity_key_jwk TEXT NOT NULL,
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    UNIQUE (user_id, device_id, fingerprint),
+    FOREIGN KEY (user_id, device_id) REFERENCES user_devices(user_id, device_id) ON DELETE CASCADE
+);
+
+CREATE TABLE signal_device_signed_prekeys (
+    user_id INTEGER NOT NULL,
+    device_id TEXT NOT NULL,
+    key_id INTEGER NOT NULL,
+    public_key_jwk TEXT NOT NULL,
+    signature TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (user_id, device_id),
+    FOREIGN KEY (user_id, device_id) REFERENCES user_devices(user_id, device_id) ON DELETE CASCADE
+);
+
+CREATE TABLE signal_device_one_time_prekeys (
+    user_id INTEGER NOT NULL,
+    device_id TEXT NOT NULL,
+    key_id INTEGER NOT NULL,
+    public_key_jwk TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    consumed_at TIMESTAMPTZ NULL,
+    PRIMARY KEY (user_id, device_id, key_id),
+    FOREIGN KEY (user_id, device_id) REFERENCES user_devices(user_id, device_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_signal_device_one_time_prekeys_available
+    ON signal_device_one_time_prekeys(user_id, device_id, consumed_at, key_id);
+
+CREATE TABLE events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    message_id INTEGER NULL REFERENCES messages(id) ON DELETE CASCADE,
+    actor_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE INDEX idx_events_room_id_id ON events(room_id, id);
+
+CREATE TABLE message_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    payload TEXT NOT NULL,
+    authored_at TIMESTAMPTZ NOT NULL,
+    replaced_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE INDEX idx_message_revisions_message_id ON message_revisions(message_id, id);
+
+CREATE TABLE message_mentions (
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_message_mentions_user_id ON message_mentions(user_id, message_id DESC);
+
+CREATE TABLE room_message_stats (
+    room_id INTEGER PRIMARY KEY REFERENCES rooms(id) ON DELETE CASCADE,
+    message_count INTEGER NOT NULL DEFAULT 0,
+    payload_bytes INTEGER NOT NULL DEFAULT 0,
+    first_message_at TIMESTAMPTZ NULL,
+    last_message_at TIMESTAMPTZ NULL
+);
+
+CREATE TABLE room_sender_message_stats (
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    sender_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_count INTEGER NOT NULL DEFAULT 0,
+    payload_bytes INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (room_id, sender_id)
+);
+
+-- room_webhooks.room_id deliberately has no FK: a room's webhooks must
+-- outlive the room long enough to deliver room_deleted.
+CREATE TABLE room_webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    event_types TEXT NOT NULL,
+    created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    disabled_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX idx_room_webhooks_room_active ON room_webhooks(room_id) WHERE disabled_at IS NULL;
+
+CREATE TABLE webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER NOT NULL REFERENCES room_webhooks(id) ON DELETE CASCADE,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_status_code INTEGER NULL,
+    last_error TEXT NULL,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    delivered_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX idx_webhook_deliveries_pending ON webhook_deliveries(next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, id DESC);
+
+CREATE TABLE bot_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    bot_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    room_ids TEXT NOT NULL,
+    created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    last_used_at TIMESTAMPTZ NULL,
+    revoked_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX idx_bot_tokens_bot_user_id ON bot_tokens(bot_user_id);
+
+CREATE TABLE federation_peers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id TEXT NOT NULL UNIQUE,
+    endpoint_url TEXT NOT NULL,
+    shared_secret TEXT NOT NULL,
+    relay_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    disabled_at TIMESTAMPTZ NULL
+);
+
+CREATE TABLE federation_room_links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    peer_id INTEGER NOT NULL REFERENCES federation_peers(id) ON DELETE CASCADE,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    remote_room_id INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    UNIQUE (peer_id, room_id),
+    UNIQUE (peer_id, remote_room_id)
+);
+
+CREATE INDEX idx_federation_room_links_room_id ON federation_room_links(room_id);
+
+CREATE TABLE federation_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    peer_id INTEGER NOT NULL REFERENCES federation_peers(id) ON DELETE CASCADE,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    last_status_code INTEGER NULL,
+    last_error TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    delivered_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX idx_federation_outbox_pending ON federation_outbox(next_attempt_at) WHERE status = 'pending';
+
+CREATE TABLE federation_inbound (
+    origin_server TEXT NOT NULL,
+    origin_message_id INTEGER NOT NULL,
+    peer_id INTEGER NULL REFERENCES federation_peers(id) ON DELETE SET NULL,
+    message_id INTEGER NULL REFERENCES messages(id) ON DELETE SET NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (origin_server, origin_message_id)
+);
+
+CREATE TABLE ip_denylist (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    cidr TEXT NOT NULL UNIQUE,
+    reason TEXT NOT NULL DEFAULT '',
+    created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    expires_at TIMESTAMPTZ NULL
+);
+
+CREATE TABLE message_reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    reporter_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL CHECK (reason IN ('spam', 'harassment', 'illegal_content', 'impersonation', 'other')),
+    note TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'dismissed', 'actioned')),
+    action TEXT NULL CHECK (action IN ('dismiss', 'revoke_message', 'suspend_sender')),
+    reviewed_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    UNIQUE (message_id, reporter_id)
+);
+
+CREATE INDEX idx_message_reports_status_created ON message_reports(status, created_at);
+
+CREATE TABLE user_blocks (
+    blocker_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX idx_user_blocks_blocked ON user_blocks(blocked_id);
+
+CREATE TABLE poll_votes (
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    option_index INTEGER NOT NULL CHECK (option_index >= 0),
+    voted_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE TABLE identity_verifications (
+    verifier_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    fingerprint TEXT NOT NULL,
+    verified_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (verifier_id, target_user_id)
+);
+
+CREATE INDEX idx_identity_verifications_target ON identity_verifications(target_user_id, fingerprint);
+
+CREATE TABLE account_key_backups (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL CHECK (version > 0),
+    ciphertext TEXT NOT NULL,
+    verification_hash TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE TABLE room_sender_keys (
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    sender_id INTEGER NOT NULL,
+    sender_device_id TEXT NOT NULL,
+    key_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (room_id, sender_id, sender_device_id),
+    FOREIGN KEY (sender_id, sender_device_id) REFERENCES user_devices(user_id, device_id) ON DELETE CASCADE
+);
+
+CREATE TABLE room_sender_key_recipients (
+    room_id INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    sender_device_id TEXT NOT NULL,
+    recipient_user_id INTEGER NOT NULL,
+    recipient_device_id TEXT NOT NULL,
+    key_id TEXT NOT NULL,
+    distributed_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (room_id, sender_id, sender_device_id, recipient_user_id, recipient_device_id),
+    FOREIGN KEY (room_id, sender_id, sender_device_id)
+        REFERENCES room_sender_keys(room_id, sender_id, sender_device_id) ON DELETE CASCADE,
+    FOREIGN KEY (recipient_user_id, recipient_device_id)
+        REFERENCES user_devices(user_id, device_id) ON DELETE CASCADE
+);
+
+CREATE TABLE signal_prekey_consumptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_user_id INTEGER NOT NULL,
+    owner_device_id TEXT NOT NULL,
+    key_id INTEGER NULL,
+    requester_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requester_device_id TEXT NOT NULL,
+    consumed_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    FOREIGN KEY (owner_user_id, owner_device_id) REFERENCES user_devices(user_id, device_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_signal_prekey_consumptions_owner ON signal_prekey_consumptions(owner_user_id, consumed_at DESC);
+CREATE INDEX idx_signal_prekey_consumptions_requester
+    ON signal_prekey_consumptions(requester_user_id, owner_user_id, consumed_at);
+
+CREATE TABLE pending_dr_handshakes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    from_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    from_device_id TEXT NOT NULL,
+    to_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    to_device_id TEXT NOT NULL DEFAULT '',
+    frame TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_pending_dr_handshakes_recipient ON pending_dr_handshakes(to_user_id, to_device_id, id);
+CREATE INDEX idx_pending_dr_handshakes_expires ON pending_dr_handshakes(expires_at);
+
+CREATE TABLE user_quotas (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    daily_message_limit INTEGER NULL,
+    storage_quota_bytes INTEGER NULL,
+    updated_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE TABLE user_quota_usage (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    usage_day TEXT NOT NULL,
+    messages_today INTEGER NOT NULL DEFAULT 0,
+    stored_bytes INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE admin_audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    target_user_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    details TEXT NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE INDEX idx_admin_audit_log_created_at ON admin_audit_log(created_at DESC, id DESC);
+CREATE INDEX idx_admin_audit_log_target ON admin_audit_log(target_user_id, created_at DESC)
+    WHERE target_user_id IS NOT NULL;
+
+CREATE TABLE room_join_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'denied')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    decided_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMPTZ NULL
+);
+
+CREATE UNIQUE INDEX idx_room_join_requests_pending ON room_join_requests(room_id, user_id) WHERE status = 'pending';
+
+CREATE TABLE undelivered_events (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL CHECK (reason IN ('queue_full', 'offline')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (user_id, event_id)
+);
+
+CREATE INDEX idx_undelivered_events_created_at ON undelivered_events(created_at);
+
+CREATE TABLE admin_invitations (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    invited_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE email_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NULL REFERENCES users(id) ON DELETE CASCADE,
+    template TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    sent_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX idx_email_outbox_pending ON email_outbox(next_attempt_at) WHERE status = 'pending';
+
+CREATE TABLE account_onboarding_tokens (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE password_reset_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending',
+    requested_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    requested_ip TEXT NOT NULL DEFAULT '',
+    decided_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMPTZ NULL,
+    token_hash TEXT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NULL,
+    used_at TIMESTAMPTZ NULL
+);
+
+CREATE UNIQUE INDEX idx_password_reset_requests_open
+    ON password_reset_requests(user_id) WHERE status IN ('pending', 'approved');
+
+CREATE TABLE feature_flags (
+    name TEXT PRIMARY KEY,
+    enabled BOOLEAN NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    updated_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE TABLE room_device_keys (
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL,
+    device_id TEXT NOT NULL,
+    public_key_jwk TEXT NOT NULL,
+    signing_public_key_jwk TEXT NOT NULL,
+    signature TEXT NOT NULL,
+    announced_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (room_id, user_id, device_id),
+    FOREIGN KEY (user_id, device_id) REFERENCES user_devices(user_id, device_id) ON DELETE CASCADE
+);
+
+CREATE TABLE decrypt_recovery_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    requester_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requester_device_id TEXT NOT NULL,
+    sender_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    sender_device_id TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL DEFAULT 'resync',
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'fulfilled')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    last_attempt_at TIMESTAMPTZ,
+    fulfilled_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (message_id, requester_id, requester_device_id)
+);
+
+CREATE INDEX idx_decrypt_recovery_requests_sender
+    ON decrypt_recovery_requests(room_id, sender_id, id) WHERE status <> 'fulfilled';
+CREATE INDEX idx_decrypt_recovery_requests_expires ON decrypt_recovery_requests(expires_at);
+
+CREATE TABLE history_backfills (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    requester_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requester_device_id TEXT NOT NULL,
+    from_message_id INTEGER NOT NULL,
+    up_to_message_id INTEGER NOT NULL,
+    message_count INTEGER NOT NULL,
+    keys_delivered INTEGER NOT NULL DEFAULT 0,
+    responses INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'completed')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (room_id, requester_id, requester_device_id)
+);
+
+CREATE INDEX idx_history_backfills_open ON history_backfills(room_id, id) WHERE status = 'open';
+
+CREATE TABLE history_backfill_keys (
+    backfill_id INTEGER NOT NULL REFERENCES history_backfills(id) ON DELETE CASCADE,
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    responder_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    delivered_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    PRIMARY KEY (backfill_id, message_id)
+);
+
+CREATE TABLE admin_broadcasts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message TEXT NOT NULL,
+    severity TEXT NOT NULL DEFAULT 'info' CHECK (severity IN ('info', 'warning', 'critical')),
+    audience TEXT NOT NULL CHECK (audience IN ('all', 'room', 'role')),
+    room_id INTEGER NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    role TEXT NULL,
+    created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    signature TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    CHECK ((audience = 'room') = (room_id IS NOT NULL)),
+    CHECK ((audience = 'role') = (role IS NOT NULL))
+);
+
+CREATE INDEX idx_admin_broadcasts_expires_at ON admin_broadcasts(expires_at);
+
+CREATE TABLE policy_versions (
+    version INTEGER PRIMARY KEY CHECK (version > 0),
+    note TEXT NOT NULL DEFAULT '',
+    created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+-- A room's message counter moves before the row is written, so RETURNING
+-- can read the new sequence number from rooms; trg_messages_stamp then
+-- copies it onto the row. Rows that arrive with a sequence, such as a
+-- backup restore, keep it and only move the counter forward.
+CREATE TRIGGER trg_messages_assign_room_seq
+    BEFORE INSERT ON messages
+    FOR EACH ROW WHEN NEW.room_seq IS NULL
+BEGIN
+    UPDATE rooms SET last_message_seq = last_message_seq + 1 WHERE id = NEW.room_id;
+END;
+
+CREATE TRIGGER trg_messages_keep_room_seq
+    BEFORE INSERT ON messages
+    FOR EACH ROW WHEN NEW.room_seq IS NOT NULL
+BEGIN
+    UPDATE rooms SET last_message_seq = MAX(last_message_seq, NEW.room_seq) WHERE id = NEW.room_id;
+END;
+
+-- Messages keep the epoch their payload was tagged with. Untagged payloads
+-- from clients that do not track epochs are recorded under the room's
+-- current one.
+CREATE TRIGGER trg_messages_stamp
+    AFTER INSERT ON messages
+    FOR EACH ROW WHEN NEW.room_seq IS NULL OR NEW.key_epoch IS NULL
+BEGIN
+    UPDATE messages
+    SET room_seq = COALESCE(NEW.room_seq, (SELECT last_message_seq FROM rooms WHERE id = NEW.room_id)),
+        key_epoch = COALESCE(NEW.key_epoch, CASE
+            WHEN CAST(NEW.payload ->> 'keyEpoch' AS TEXT) GLOB '[1-9]*'
+                AND CAST(NEW.payload ->> 'keyEpoch' AS TEXT) NOT GLOB '*[^0-9]*'
+                AND length(CAST(NEW.payload ->> 'keyEpoch' AS TEXT)) <= 18
+            THEN CAST(NEW.payload ->> 'keyEpoch' AS INTEGER)
+            ELSE (SELECT key_epoch FROM rooms WHERE id = NEW.room_id)
+        END)
+    WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER trg_room_message_stats_insert
+    AFTER INSERT ON messages
+BEGIN
+    INSERT INTO room_message_stats(room_id, message_count, payload_bytes, first_message_at, last_message_at)
+    VALUES (NEW.room_id, 1, octet_length(NEW.payload), NEW.created_at, NEW.created_at)
+    ON CONFLICT (room_id) DO UPDATE SET
+        message_count = message_count + 1,
+        payload_bytes = payload_bytes + excluded.payload_bytes,
+        first_message_at = MIN(COALESCE(first_message_at, excluded.first_message_at), excluded.first_message_at),
+        last_message_at = MAX(COALESCE(last_message_at, excluded.last_message_at), excluded.last_message_at);
+
+    INSERT INTO room_sender_message_stats(room_id, sender_id, message_count, payload_bytes)
+    VALUES (NEW.room_id, NEW.sender_id, 1, octet_length(NEW.payload))
+    ON CONFLICT (room_id, sender_id) DO UPDATE SET
+        message_count = message_count + 1,
+        payload_bytes = payload_bytes + excluded.payload_bytes;
+END;
+
+CREATE TRIGGER trg_room_message_stats_update
+    AFTER UPDATE OF payload ON messages
+BEGIN
+    UPDATE room_message_stats
+    SET payload_bytes = payload_bytes + octet_length(NEW.payload) - octet_length(OLD.payload)
+    WHERE room_id = NEW.room_id;
+    UPDATE room_sender_message_stats
+    SET payload_bytes = payload_bytes + octet_length(NEW.payload) - octet_length(OLD.payload)
+    WHERE room_id = NEW.room_id AND sender_id = NEW.sender_id;
+END;
+
+CREATE TRIGGER trg_room_message_stats_delete
+    AFTER DELETE ON messages
+BEGIN
+    UPDATE room_message_stats
+    SET message_count = message_count - 1,
+        payload_bytes = payload_bytes - octet_length(OLD.payload),
+        first_message_at = (SELECT MIN(created_at) FROM messages WHERE room_id = OLD.room_id),
+        last_message_at = (SELECT MAX(created_at) FROM messages WHERE room_id = OLD.room_id)
+    WHERE room_id = OLD.room_id;
+    UPDATE room_sender_message_stats
+    SET message_count = message_count - 1,
+        payload_bytes = payload_bytes - octet_length(OLD.payload)
+    WHERE room_id = OLD.room_id AND sender_id = OLD.sender_id;
+END;
+
+-- Any membership change means the next message must be keyed for a
+-- different set of devices, so the room moves to a new epoch.
+CREATE TRIGGER trg_room_members_bump_key_epoch_insert
+    AFTER INSERT ON room_members
+BEGIN
+    UPDATE rooms
+    SET key_epoch = key_epoch + 1, key_epoch_changed_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+    WHERE id = NEW.room_id;
+END;
+
+CREATE TRIGGER trg_room_members_bump_key_epoch_delete
+    AFTER DELETE ON room_members
+BEGIN
+    UPDATE rooms
+    SET key_epoch = key_epoch + 1, key_epoch_changed_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+    WHERE id = OLD.room_id;
+END;
@@ -0,0 +1,464 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The MQTT bridge mirrors the event log onto per-room topics for dashboards
+// and automations. Only metadata leaves the server: event type, IDs and
+// timestamps, never ciphertext or event payloads. Messages are published
+// at QoS 0 to
+//
+//	<prefix>/rooms/<roomId>/events/<eventType>
+//
+// so subscribers can filter with wildcards such as <prefix>/rooms/+/events/#.
+// Delivery is at most once and starts from the newest event when the bridge
+// starts; consumers that need history use /api/sync. Each replica runs its
+// own bridge, so brokers behind a multi-replica deployment see one copy per
+// replica.
+const (
+	defaultMQTTTopicPrefix = "e2ee-chat"
+
+	mqttProtocolLevel   = 4 // MQTT 3.1.1
+	mqttKeepAlive       = 60 * time.Second
+	mqttDialTimeout     = 10 * time.Second
+	mqttPollInterval    = time.Second
+	mqttEventBatchSize  = 200
+	mqttRedialBaseDelay = 5 * time.Second
+	mqttRedialMaxDelay  = 2 * time.Minute
+	mqttMaxPacketBytes  = 1 << 20
+
+	mqttPacketConnect    = 0x10
+	mqttPacketConnack    = 0x20
+	mqttPacketPublish    = 0x30
+	mqttPacketPingreq    = 0xc0
+	mqttPacketDisconnect = 0xe0
+)
+
+var errMQTTPacketTooLarge = errors.New("mqtt packet too large")
+
+type mqttConfig struct {
+	BrokerURL   string
+	ClientID    string
+	Username    string
+	Password    string
+	TopicPrefix string
+}
+
+func (cfg mqttConfig) enabled() bool {
+	return cfg.BrokerURL != ""
+}
+
+func validateMQTTConfig(cfg mqttConfig) error {
+	if !cfg.enabled() {
+		if cfg.Username != "" || cfg.Password != "" || cfg.ClientID != "" {
+			return errors.New("MQTT_BROKER_URL must be set when other MQTT_* settings are")
+		}
+		return nil
+	}
+	if _, _, err := mqttBrokerAddress(cfg.BrokerURL); err != nil {
+		return fmt.Errorf("MQTT_BROKER_URL: %w", err)
+	}
+	if cfg.Password != "" && cfg.Username == "" {
+		return errors.New("MQTT_PASSWORD requires MQTT_USERNAME")
+	}
+	if cfg.TopicPrefix == "" || strings.ContainsAny(cfg.TopicPrefix, "+#\x00") || strings.HasPrefix(cfg.TopicPrefix, "$") {
+		return errors.New("MQTT_TOPIC_PREFIX must be a topic without wildcards")
+	}
+	return nil
+}
+
+// mqttBrokerAddress resolves mqtt:// and tcp:// to plain connections and
+// mqtts:// and ssl:// to TLS, filling in the IANA ports when none is given.
+func mqttBrokerAddress(raw string) (string, bool, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", false, err
+	}
+	if parsed.Hostname() == "" {
+		return "", false, errors.New("broker url must include a host")
+	}
+	var useTLS bool
+	var defaultPort string
+	switch parsed.Scheme {
+	case "mqtt", "tcp":
+		defaultPort = "1883"
+	case "mqtts", "ssl":
+		useTLS = true
+		defaultPort = "8883"
+	default:
+		return "", false, errors.New("broker url must use mqtt, mqtts, tcp or ssl")
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(parsed.Hostname(), port), useTLS, nil
+}
+
+func defaultMQTTClientID() string {
+	buf := make([]byte, 6)
+	randomBytes(buf)
+	return "e2ee-chat-" + hex.EncodeToString(buf)
+}
+
+// mqttEvent is the metadata published for one event log entry.
+type mqttEvent struct {
+	EventID   int64  `json:"eventId"`
+	Type      string `json:"type"`
+	RoomID    int64  `json:"roomId"`
+	MessageID *int64 `json:"messageId,omitempty"`
+	ActorID   *int64 `json:"actorId,omitempty"`
+	CreatedAt string `json:"createdAt"`
+}
+
+func (cfg mqttConfig) topicFor(event mqttEvent) string {
+	return cfg.TopicPrefix + "/rooms/" + strconv.FormatInt(event.RoomID, 10) + "/events/" + event.Type
+}
+
+func appendMQTTRemainingLength(buf []byte, length int) []byte {
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		buf = append(buf, digit)
+		if length == 0 {
+			return buf
+		}
+	}
+}
+
+func appendMQTTString(buf []byte, value string) []byte {
+	buf = append(buf, byte(len(value)>>8), byte(len(value)))
+	return append(buf, value...)
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	packet := appendMQTTRemainingLength([]byte{header}, len(body))
+	return append(packet, body...)
+}
+
+func mqttConnectPacket(cfg mqttConfig) []byte {
+	// Clean session: the bridge only publishes, so there is no broker-side
+	// state worth keeping.
+	flags := byte(0x02)
+	if cfg.Username != "" {
+		flags |= 0x80
+	}
+	if cfg.Password != "" {
+		flags |= 0x40
+	}
+	keepAlive := int(mqttKeepAlive / time.Second)
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, mqttProtocolLevel, flags, byte(keepAlive>>8), byte(keepAlive))
+	body = appendMQTTString(body, cfg.ClientID)
+	if cfg.Username != "" {
+		body = appendMQTTString(body, cfg.Username)
+	}
+	if cfg.Password != "" {
+		body = appendMQTTString(body, cfg.Password)
+	}
+	return mqttPacket(mqttPacketConnect, body)
+}
+
+func mqttPublishPacket(topic string, payload []byte) []byte {
+	body := appendMQTTString(nil, topic)
+	return mqttPacket(mqttPacketPublish, append(body, payload...))
+}
+
+// readMQTTPacket reads one packet and returns its first header byte and
+// body.
+func readMQTTPacket(r io.ByteReader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed mqtt remaining length")
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if length > mqttMaxPacketBytes {
+		return 0, nil, errMQTTPacketTooLarge
+	}
+	body := make([]byte, length)
+	for i := range body {
+		if body[i], err = r.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+	}
+	return header, body, nil
+}
+
+// mqttBridge tails the events table and publishes each new entry. It keeps
+// one broker connection and redials with backoff when it drops; events that
+// arrive while the broker is unreachable are published once it is back.
+type mqttBridge struct {
+	db   *sql.DB
+	cfg  mqttConfig
+	dial func(ctx context.Context) (net.Conn, error)
+	now  func() time.Time
+
+	conn         net.Conn
+	lastWrite    time.Time
+	cursor       int64
+	cursorLoaded bool
+	failures     int
+	redialAt     time.Time
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newMQTTBridge(db *sql.DB, cfg mqttConfig) *mqttBridge {
+	if cfg.ClientID == "" {
+		cfg.ClientID = defaultMQTTClientID()
+	}
+	return &mqttBridge{
+		db:   db,
+		cfg:  cfg,
+		dial: cfg.dialBroker,
+		now:  time.Now,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (cfg mqttConfig) dialBroker(ctx context.Context) (net.Conn, error) {
+	addr, useTLS, err := mqttBrokerAddress(cfg.BrokerURL)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: mqttDialTimeout}
+	if !useTLS {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+	return tlsDialer.DialContext(ctx, "tcp", addr)
+}
+
+func (b *mqttBridge) Start() {
+	go b.run()
+}
+
+func (b *mqttBridge) Stop() {
+	if b == nil {
+		return
+	}
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+	<-b.done
+}
+
+func (b *mqttBridge) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(mqttPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.step(); err != nil {
+			logger.Warn("mqtt_bridge_failed", "error", err)
+		}
+		select {
+		case <-b.stop:
+			b.disconnect()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// step publishes everything logged since the last call, connecting first
+// if needed, and keeps an idle connection alive.
+func (b *mqttBridge) step() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if !b.cursorLoaded {
+		if err := b.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM events`).Scan(&b.cursor); err != nil {
+			return fmt.Errorf("load event cursor: %w", err)
+		}
+		b.cursorLoaded = true
+	}
+	if b.conn == nil {
+		if b.now().Before(b.redialAt) {
+			return nil
+		}
+		if err := b.connect(ctx); err != nil {
+			b.failures++
+			b.redialAt = b.now().Add(mqttRedialDelay(b.failures))
+			return fmt.Errorf("connect to broker: %w", err)
+		}
+		b.failures = 0
+	}
+
+	for {
+		events, err := b.loadEvents(ctx)
+		if err != nil {
+			return fmt.Errorf("load events: %w", err)
+		}
+		for _, event := range events {
+			if err := b.publish(event); err != nil {
+				b.dropConn()
+				return fmt.Errorf("publish event %d: %w", event.EventID, err)
+			}
+			b.cursor = event.EventID
+		}
+		if len(events) < mqttEventBatchSize {
+			break
+		}
+	}
+	if b.now().Sub(b.lastWrite) >= mqttKeepAlive/2 {
+		if err := b.write(mqttPacket(mqttPacketPingreq, nil)); err != nil {
+			b.dropConn()
+			return fmt.Errorf("ping broker: %w", err)
+		}
+	}
+	return nil
+}
+
+func mqttRedialDelay(failures int) time.Duration {
+	delay := mqttRedialBaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= mqttRedialMaxDelay {
+			return mqttRedialMaxDelay
+		}
+	}
+	return delay
+}
+
+func (b *mqttBridge) loadEvents(ctx context.Context) ([]mqttEvent, error) {
+	rows, err := b.db.QueryContext(ctx, `
+SELECT id, event_type, room_id, message_id, actor_id, created_at
+FROM events
+WHERE id > $1
+ORDER BY id ASC
+LIMIT $2
+`, b.cursor, mqttEventBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]mqttEvent, 0, mqttEventBatchSize)
+	for rows.Next() {
+		var event mqttEvent
+		var messageID, actorID sql.NullInt64
+		var createdAt time.Time
+		if err := rows.Scan(&event.EventID, &event.Type, &event.RoomID, &messageID, &actorID, &createdAt); err != nil {
+			return nil, err
+		}
+		if messageID.Valid {
+			value := messageID.Int64
+			event.MessageID = &value
+		}
+		if actorID.Valid {
+			value := actorID.Int64
+			event.ActorID = &value
+		}
+		event.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (b *mqttBridge) connect(ctx context.Context) error {
+	conn, err := b.dial(ctx)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(b.now().Add(mqttDialTimeout))
+	if _, err := conn.Write(mqttConnectPacket(b.cfg)); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	header, body, err := readMQTTPacket(reader)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if header != mqttPacketConnack || len(body) != 2 {
+		_ = conn.Close()
+		return fmt.Errorf("unexpected packet 0x%02x instead of CONNACK", header)
+	}
+	if body[1] != 0 {
+		_ = conn.Close()
+		return fmt.Errorf("broker refused connection with code %d", body[1])
+	}
+	_ = conn.SetDeadline(time.Time{})
+	// Nothing the broker sends afterwards matters to a QoS 0 publisher, but
+	// it must be read so PINGRESPs never back up.
+	go func() {
+		_, _ = io.Copy(io.Discard, reader)
+	}()
+	b.conn = conn
+	b.lastWrite = b.now()
+	return nil
+}
+
+func (b *mqttBridge) publish(event mqttEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.write(mqttPublishPacket(b.cfg.topicFor(event), payload))
+}
+
+func (b *mqttBridge) write(packet []byte) error {
+	_ = b.conn.SetWriteDeadline(b.now().Add(mqttDialTimeout))
+	if _, err := b.conn.Write(packet); err != nil {
+		return err
+	}
+	b.lastWrite = b.now()
+	return nil
+}
+
+func (b *mqttBridge) dropConn() {
+	if b.conn == nil {
+		return
+	}
+	_ = b.conn.Close()
+	b.conn = nil
+	b.failures++
+	b.redialAt = b.now().Add(mqttRedialDelay(b.failures))
+}
+
+func (b *mqttBridge) disconnect() {
+	if b.conn == nil {
+		return
+	}
+	_ = b.write(mqttPacket(mqttPacketDisconnect, nil))
+	_ = b.conn.Close()
+	b.conn = nil
+}
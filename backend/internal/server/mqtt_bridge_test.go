@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMQTTRemainingLength(t *testing.T) {
+	t.Parallel()
+
+	cases := map[int][]byte{
+		0:       {0x00},
+		127:     {0x7f},
+		128:     {0x80, 0x01},
+		321:     {0xc1, 0x02},
+		2097152: {0x80, 0x80, 0x80, 0x01},
+	}
+	for length, want := range cases {
+		if got := appendMQTTRemainingLength(nil, length); !bytes.Equal(got, want) {
+			t.Fatalf("length %d: expected %x, got %x", length, want, got)
+		}
+	}
+}
+
+func TestValidateMQTTConfig(t *testing.T) {
+	t.Parallel()
+
+	valid := mqttConfig{BrokerURL: "mqtts://broker.example:8883", TopicPrefix: "chat"}
+	if err := validateMQTTConfig(valid); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+	invalid := []mqttConfig{
+		{Username: "bridge"},
+		{BrokerURL: "http://broker.example", TopicPrefix: "chat"},
+		{BrokerURL: "mqtt://broker.example", TopicPrefix: "chat/#"},
+		{BrokerURL: "mqtt://broker.example", TopicPrefix: "chat", Password: "secret"},
+	}
+	for _, cfg := range invalid {
+		if err := validateMQTTConfig(cfg); err == nil {
+			t.Fatalf("expected %+v to be rejected", cfg)
+		}
+	}
+	if addr, useTLS, err := mqttBrokerAddress("ssl://broker.example"); err != nil || addr != "broker.example:8883" || !useTLS {
+		t.Fatalf("unexpected broker address %s tls=%v err=%v", addr, useTLS, err)
+	}
+}
+
+func TestMQTTBridgeConnectsAndPublishesMetadata(t *testing.T) {
+	t.Parallel()
+
+	bridgeSide, brokerSide := net.Pipe()
+	defer brokerSide.Close()
+	bridge := newMQTTBridge(nil, mqttConfig{
+		BrokerURL:   "mqtt://broker.example",
+		ClientID:    "bridge-1",
+		Username:    "bridge",
+		Password:    "secret",
+		TopicPrefix: "chat",
+	})
+	bridge.dial = func(context.Context) (net.Conn, error) { return bridgeSide, nil }
+
+	broker := bufio.NewReader(brokerSide)
+	connected := make(chan error, 1)
+	go func() {
+		connected <- bridge.connect(context.Background())
+	}()
+	header, body, err := readMQTTPacket(broker)
+	if err != nil || header != mqttPacketConnect {
+		t.Fatalf("expected CONNECT, got 0x%02x err=%v", header, err)
+	}
+	if !bytes.Contains(body, []byte("bridge-1")) || body[7] != 0xc2 {
+		t.Fatalf("unexpected CONNECT body %x", body)
+	}
+	if _, err := brokerSide.Write([]byte{mqttPacketConnack, 0x02, 0x00, 0x00}); err != nil {
+		t.Fatalf("write CONNACK: %v", err)
+	}
+	if err := <-connected; err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	messageID := int64(42)
+	published := make(chan error, 1)
+	go func() {
+		published <- bridge.publish(mqttEvent{EventID: 7, Type: eventMessageCreated, RoomID: 3, MessageID: &messageID, CreatedAt: time.Unix(0, 0).UTC().Format(time.RFC3339Nano)})
+	}()
+	_ = brokerSide.SetReadDeadline(time.Now().Add(2 * time.Second))
+	header, body, err = readMQTTPacket(broker)
+	if err != nil || header != mqttPacketPublish {
+		t.Fatalf("expected PUBLISH, got 0x%02x err=%v", header, err)
+	}
+	if err := <-published; err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	topicLength := int(body[0])<<8 | int(body[1])
+	if topic := string(body[2 : 2+topicLength]); topic != "chat/rooms/3/events/message_created" {
+		t.Fatalf("unexpected topic %q", topic)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(body[2+topicLength:], &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload["eventId"] != float64(7) || payload["messageId"] != float64(42) || payload["roomId"] != float64(3) {
+		t.Fatalf("unexpected payload %v", payload)
+	}
+	if _, ok := payload["actorId"]; ok {
+		t.Fatalf("expected absent actor to be omitted, got %v", payload)
+	}
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	notificationModeAll          = "all"
+	notificationModeMentionsOnly = "mentions_only"
+	notificationModeMuted        = "muted"
+)
+
+type NotificationSettings struct {
+	Mode       string  `json:"mode"`
+	MutedUntil *string `json:"mutedUntil,omitempty"`
+}
+
+type notificationPreference struct {
+	Mode       string
+	MutedUntil sql.NullTime
+}
+
+func normalizeNotificationMode(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", notificationModeAll:
+		return notificationModeAll
+	case notificationModeMentionsOnly:
+		return notificationModeMentionsOnly
+	case notificationModeMuted:
+		return notificationModeMuted
+	default:
+		return ""
+	}
+}
+
+// shouldNotify reports whether a room event should produce a notification for
+// a member. A temporary mute silences everything except mentions until it
+// lapses; after that the persistent mode applies.
+func shouldNotify(pref notificationPreference, now time.Time, mentioned bool) bool {
+	if mentioned {
+		return true
+	}
+	if pref.MutedUntil.Valid && pref.MutedUntil.Time.After(now) {
+		return false
+	}
+	switch pref.Mode {
+	case notificationModeMuted, notificationModeMentionsOnly:
+		return false
+	default:
+		return true
+	}
+}
+
+func (a *App) loadNotificationPreferences(ctx context.Context, roomID int64, userIDs []int64) (map[int64]notificationPreference, error) {
+	prefs := make(map[int64]notificationPreference, len(userIDs))
+	if len(userIDs) == 0 {
+		return prefs, nil
+	}
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT user_id, notification_mode, muted_until FROM room_members WHERE room_id = $1 AND user_id = ANY($2::BIGINT[])`,
+		roomID, userIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int64
+		var pref notificationPreference
+		if err := rows.Scan(&userID, &pref.Mode, &pref.MutedUntil); err != nil {
+			return nil, err
+		}
+		prefs[userID] = pref
+	}
+	return prefs, rows.Err()
+}
+
+func toNotificationSettings(pref notificationPreference) NotificationSettings {
+	settings := NotificationSettings{Mode: pref.Mode}
+	if settings.Mode == "" {
+		settings.Mode = notificationModeAll
+	}
+	if pref.MutedUntil.Valid {
+		value := pref.MutedUntil.Time.UTC().Format(time.RFC3339Nano)
+		settings.MutedUntil = &value
+	}
+	return settings
+}
+
+// notifyRoomMessage tells members who are connected to other rooms that a
+// message arrived, as far as their notification settings allow. Mentioned
+// members get the mention event instead; the sender, guests, members in do
+// not disturb and members the sender has blocked are skipped.
+func (a *App) notifyRoomMessage(
+	ctx context.Context,
+	roomID int64,
+	messageID int64,
+	senderID int64,
+	senderUsername string,
+	createdAt time.Time,
+	mentions []int64,
+) {
+	mentioned := make(map[int64]bool, len(mentions))
+	for _, userID := range mentions {
+		mentioned[userID] = true
+	}
+	candidates := make([]int64, 0)
+	for _, userID := range a.hub.usersOutsideRoom(roomID) {
+		if userID != senderID && !mentioned[userID] {
+			candidates = append(candidates, userID)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	prefs, err := a.loadNotificationPreferences(ctx, roomID, candidates)
+	if err != nil {
+		logger.Warn("load_notification_preferences_failed", "room_id", roomID, "message_id", messageID, "error", err)
+		return
+	}
+	now := time.Now()
+	recipients := make(map[int64]bool, len(prefs))
+	for userID, pref := range prefs {
+		if !shouldNotify(pref, now, false) {
+			continue
+		}
+		if a.hub.presence.Status(userID) == presenceDND || a.hub.blocks.Blocks(senderID, userID) {
+			continue
+		}
+		recipients[userID] = true
+	}
+	if len(recipients) == 0 {
+		return
+	}
+	payload, err := json.Marshal(map[string]any{
+		"type":         "message_notification",
+		"roomId":       roomID,
+		"messageId":    messageID,
+		"fromUserId":   senderID,
+		"fromUsername": senderUsername,
+		"createdAt":    createdAt.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return
+	}
+	a.hub.NotifyOutsideRoom(roomID, payload, func(userID int64) bool {
+		return recipients[userID]
+	})
+}
+
+// usersOutsideRoom lists the non-guest users with a connection to any room
+// other than roomID.
+func (h *Hub) usersOutsideRoom(roomID int64) []int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	seen := make(map[int64]struct{})
+	users := make([]int64, 0)
+	for id, roomClients := range h.rooms {
+		if id == roomID {
+			continue
+		}
+		for client := range roomClients {
+			if _, found := seen[client.userID]; client.guest || found {
+				continue
+			}
+			seen[client.userID] = struct{}{}
+			users = append(users, client.userID)
+		}
+	}
+	return users
+}
+
+// NotifyOutsideRoom sends payload to every non-guest connection outside
+// roomID whose user matches, and reports how many connections it reached.
+func (h *Hub) NotifyOutsideRoom(roomID int64, payload []byte, match func(userID int64) bool) int {
+	h.mu.RLock()
+	clients := make([]*Client, 0)
+	for id, roomClients := range h.rooms {
+		if id == roomID {
+			continue
+		}
+		for client := range roomClients {
+			if client.guest || !match(client.userID) {
+				continue
+			}
+			clients = append(clients, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		select {
+		case client.send <- payload:
+		default:
+			client.log().Warn("websocket_notification_drop", "user_id", client.userID, "room_id", client.roomID, "reason", "send queue full")
+		}
+	}
+	return len(clients)
+}
+
+func (a *App) handleRoomNotificationSettings(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodPut {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Mode       string  `json:"mode"`
+		MutedUntil *string `json:"mutedUntil"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	mode := normalizeNotificationMode(req.Mode)
+	if mode == "" {
+		respondError(w, http.StatusBadRequest, "mode must be one of all, mentions_only, muted")
+		return
+	}
+	var mutedUntil sql.NullTime
+	if req.MutedUntil != nil && strings.TrimSpace(*req.MutedUntil) != "" {
+		parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(*req.MutedUntil))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "mutedUntil must be an RFC3339 timestamp")
+			return
+		}
+		if !parsed.After(time.Now()) {
+			respondError(w, http.StatusBadRequest, "mutedUntil must be in the future")
+			return
+		}
+		mutedUntil = sql.NullTime{Time: parsed.UTC(), Valid: true}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var pref notificationPreference
+	err := a.db.QueryRowContext(ctx, `
+UPDATE room_members
+SET notification_mode = $3, muted_until = $4
+WHERE room_id = $1 AND user_id = $2
+RETURNING notification_mode, muted_until
+`, roomID, auth.UserID, mode, mutedUntil).Scan(&pref.Mode, &pref.MutedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "not a room member")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to update notification settings")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"roomId":               roomID,
+		"notificationSettings": toNotificationSettings(pref),
+	})
+}
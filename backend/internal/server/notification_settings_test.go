@@ -0,0 +1,104 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShouldNotify(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	future := sql.NullTime{Time: now.Add(time.Hour), Valid: true}
+	past := sql.NullTime{Time: now.Add(-time.Hour), Valid: true}
+
+	cases := []struct {
+		name      string
+		pref      notificationPreference
+		mentioned bool
+		expected  bool
+	}{
+		{name: "default", pref: notificationPreference{Mode: notificationModeAll}, expected: true},
+		{name: "muted", pref: notificationPreference{Mode: notificationModeMuted}, expected: false},
+		{name: "muted but mentioned", pref: notificationPreference{Mode: notificationModeMuted}, mentioned: true, expected: true},
+		{name: "mentions only", pref: notificationPreference{Mode: notificationModeMentionsOnly}, expected: false},
+		{name: "temporary mute", pref: notificationPreference{Mode: notificationModeAll, MutedUntil: future}, expected: false},
+		{name: "lapsed mute", pref: notificationPreference{Mode: notificationModeAll, MutedUntil: past}, expected: true},
+	}
+	for _, item := range cases {
+		item := item
+		t.Run(item.name, func(t *testing.T) {
+			t.Parallel()
+			if got := shouldNotify(item.pref, now, item.mentioned); got != item.expected {
+				t.Fatalf("expected %v, got %v", item.expected, got)
+			}
+		})
+	}
+}
+
+func TestHandleRoomNotificationSettingsValidation(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	auth := AuthContext{UserID: 1, Username: "alice", Role: "user"}
+
+	cases := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{name: "wrong method", method: http.MethodGet, status: http.StatusMethodNotAllowed},
+		{name: "unknown mode", method: http.MethodPut, body: `{"mode":"loud"}`, status: http.StatusBadRequest},
+		{name: "bad timestamp", method: http.MethodPut, body: `{"mode":"all","mutedUntil":"tomorrow"}`, status: http.StatusBadRequest},
+		{name: "past timestamp", method: http.MethodPut, body: `{"mode":"all","mutedUntil":"2001-01-01T00:00:00Z"}`, status: http.StatusBadRequest},
+	}
+	for _, item := range cases {
+		item := item
+		t.Run(item.name, func(t *testing.T) {
+			request := httptest.NewRequest(item.method, "/api/rooms/1/notification-settings", strings.NewReader(item.body))
+			response := httptest.NewRecorder()
+
+			app.handleRoomNotificationSettings(response, request, auth, 1)
+
+			if response.Code != item.status {
+				t.Fatalf("expected %d, got %d", item.status, response.Code)
+			}
+		})
+	}
+}
+
+func TestHubNotifyOutsideRoom(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	inRoom := &Client{roomID: 1, userID: 1, username: "alice", send: make(chan []byte, 1)}
+	elsewhere := &Client{roomID: 2, userID: 1, username: "alice", send: make(chan []byte, 1)}
+	muted := &Client{roomID: 2, userID: 2, username: "bob", send: make(chan []byte, 1)}
+	guest := &Client{roomID: 3, userID: 3, username: "guest", guest: true, send: make(chan []byte, 1)}
+	for _, client := range []*Client{inRoom, elsewhere, muted, guest} {
+		hub.AddClient(client)
+	}
+
+	if users := hub.usersOutsideRoom(1); len(users) != 2 {
+		t.Fatalf("expected alice and bob outside room 1, got %v", users)
+	}
+	sent := hub.NotifyOutsideRoom(1, []byte("notify"), func(userID int64) bool { return userID != 2 })
+	if sent != 1 {
+		t.Fatalf("expected one connection notified, got %d", sent)
+	}
+	if got := <-elsewhere.send; string(got) != "notify" {
+		t.Fatalf("unexpected payload: %q", string(got))
+	}
+	for _, client := range []*Client{inRoom, muted, guest} {
+		select {
+		case <-client.send:
+			t.Fatalf("user %d should not be notified", client.userID)
+		default:
+		}
+	}
+}
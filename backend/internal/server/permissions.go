@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Permissions gate the admin API. The built-in admin role holds all of them;
+// other accounts get the permissions of the custom role assigned to them.
+const (
+	permManageUsers     = "manage_users"
+	permManageRooms     = "manage_rooms"
+	permViewAudit       = "view_audit"
+	permViewStats       = "view_stats"
+	permModerateReports = "moderate_reports"
+	permManageBots      = "manage_bots"
+	permManageServer    = "manage_server"
+	permManageRoles     = "manage_roles"
+)
+
+// grantablePermissions can be put into custom roles. manage_roles stays with
+// admins so a custom role can never widen itself.
+var grantablePermissions = []string{
+	permManageUsers,
+	permManageRooms,
+	permViewAudit,
+	permViewStats,
+	permModerateReports,
+	permManageBots,
+	permManageServer,
+}
+
+// roleGrant is the custom role an account holds, if any. Version changes
+// every time the role's permissions do; access tokens carry it so an edit
+// invalidates sessions issued under the old permissions.
+type roleGrant struct {
+	Name        string
+	Version     int
+	Permissions []string
+}
+
+// matchesClaims reports whether a token was issued under the account's
+// current role and custom role version.
+func (identity userIdentity) matchesClaims(claims *Claims) bool {
+	return identity.Role == claims.Role &&
+		identity.Grant.Name == claims.CustomRole &&
+		identity.Grant.Version == claims.RoleVersion
+}
+
+// Can reports whether the caller holds permission.
+func (auth AuthContext) Can(permission string) bool {
+	if auth.Role == "admin" {
+		return true
+	}
+	return slices.Contains(auth.Permissions, permission)
+}
+
+// withPermission admits callers holding permission.
+func (a *App) withPermission(permission string, next func(http.ResponseWriter, *http.Request, AuthContext)) func(http.ResponseWriter, *http.Request, AuthContext) {
+	return func(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+		if !auth.Can(permission) {
+			respondErrorDetails(w, http.StatusForbidden, "permission_denied", "permission required", map[string]any{"permission": permission})
+			return
+		}
+		next(w, r, auth)
+	}
+}
+
+// normalizePermissions validates a custom role's permission list and returns
+// it sorted without duplicates.
+func normalizePermissions(permissions []string) ([]string, bool) {
+	normalized := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		if !slices.Contains(grantablePermissions, permission) {
+			return nil, false
+		}
+		if !slices.Contains(normalized, permission) {
+			normalized = append(normalized, permission)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized, true
+}
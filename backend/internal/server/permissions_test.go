@@ -0,0 +1,46 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizePermissions(t *testing.T) {
+	t.Parallel()
+
+	permissions, ok := normalizePermissions([]string{permViewAudit, permManageUsers, permViewAudit})
+	if !ok || !slices.Equal(permissions, []string{permManageUsers, permViewAudit}) {
+		t.Fatalf("unexpected permissions: %v (%v)", permissions, ok)
+	}
+	if _, ok := normalizePermissions([]string{permManageRoles}); ok {
+		t.Fatal("expected manage_roles to be non-grantable")
+	}
+	if _, ok := normalizePermissions([]string{"launch_missiles"}); ok {
+		t.Fatal("expected unknown permission to be rejected")
+	}
+}
+
+func TestIdentityMatchesClaims(t *testing.T) {
+	t.Parallel()
+
+	identity := userIdentity{Role: "user", Grant: roleGrant{Name: "auditor", Version: 2}}
+	if !identity.matchesClaims(&Claims{Role: "user", CustomRole: "auditor", RoleVersion: 2}) {
+		t.Fatal("expected matching claims to pass")
+	}
+	if identity.matchesClaims(&Claims{Role: "user", CustomRole: "auditor", RoleVersion: 1}) {
+		t.Fatal("expected a token from an older role version to be rejected")
+	}
+	if identity.matchesClaims(&Claims{Role: "user"}) {
+		t.Fatal("expected a token issued before the role was assigned to be rejected")
+	}
+}
+
+func TestValidCustomRoleName(t *testing.T) {
+	t.Parallel()
+
+	for name, want := range map[string]bool{"auditor": true, "support-tier_2": true, "admin": false, "ab": false, "Auditor": false, "1st": false} {
+		if got := validCustomRoleName(name); got != want {
+			t.Fatalf("validCustomRoleName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
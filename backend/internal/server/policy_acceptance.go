@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	errCodePolicyAcceptanceRequired = "policy_acceptance_required"
+	errCodePolicyVersionMismatch    = "policy_version_mismatch"
+
+	maxPolicyNoteLen     = 500
+	maxPolicyPendingList = 100
+)
+
+// policyGateExemptPaths are the authenticated routes a user who has not
+// accepted the current policy may still reach: enough to learn which version
+// is required and to accept it, or to take their data and leave. Login,
+// refresh and logout do not go through withAuth and are never gated.
+var policyGateExemptPaths = map[string]bool{
+	"/api/session":               true,
+	"/api/account/accept-policy": true,
+	"/api/account/export":        true,
+	"/api/account/delete":        true,
+}
+
+// requiredPolicyVersion is the version users must have accepted: the newest
+// one published through the admin API, or POLICY_VERSION if that is higher.
+// Zero means no policy is in force.
+func (a *App) requiredPolicyVersion(identity userIdentity) int {
+	return max(a.policyVersion, identity.PublishedPolicyVersion)
+}
+
+// policyPending reports the version identity still has to accept, or zero.
+func (a *App) policyPending(identity userIdentity) int {
+	if required := a.requiredPolicyVersion(identity); identity.AcceptedPolicyVersion < required {
+		return required
+	}
+	return 0
+}
+
+func respondPolicyAcceptanceRequired(w http.ResponseWriter, required, accepted int) {
+	respondErrorDetails(w, http.StatusPreconditionRequired, errCodePolicyAcceptanceRequired, "policy acceptance required", map[string]any{
+		"requiredVersion": required,
+		"acceptedVersion": accepted,
+	})
+}
+
+// handleAccountAcceptPolicy serves POST /api/account/accept-policy. The
+// client names the version it showed the user, so a version published while
+// the dialog was open is not accepted unseen.
+func (a *App) handleAccountAcceptPolicy(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Version int `json:"version"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if req.Version <= 0 {
+		respondError(w, http.StatusBadRequest, "version is required")
+		return
+	}
+	if req.Version != auth.PolicyVersion {
+		respondErrorDetails(w, http.StatusConflict, errCodePolicyVersionMismatch, "policy version is not current", map[string]any{
+			"requiredVersion": auth.PolicyVersion,
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var acceptedAt time.Time
+	err := a.db.QueryRowContext(ctx, `
+UPDATE users
+SET accepted_policy_version = $2, policy_accepted_at = NOW()
+WHERE id = $1 AND accepted_policy_version < $2
+RETURNING policy_accepted_at
+`, auth.UserID, req.Version).Scan(&acceptedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Already accepted; report the stored acceptance unchanged.
+		var stored sql.NullTime
+		err = a.db.QueryRowContext(ctx, `SELECT policy_accepted_at FROM users WHERE id = $1`, auth.UserID).Scan(&stored)
+		acceptedAt = stored.Time
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to record policy acceptance")
+		return
+	}
+	requestLogger(r.Context()).Info("policy_accepted", "user_id", auth.UserID, "version", req.Version)
+	respondJSON(w, http.StatusOK, map[string]any{
+		"acceptedVersion": req.Version,
+		"acceptedAt":      acceptedAt.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+type policyVersion struct {
+	Version       int    `json:"version"`
+	Note          string `json:"note,omitempty"`
+	CreatedBy     int64  `json:"createdBy,omitempty"`
+	CreatedAt     string `json:"createdAt"`
+	AcceptedUsers int    `json:"acceptedUsers"`
+}
+
+type policyPendingUser struct {
+	UserID          int64  `json:"userId"`
+	Username        string `json:"username"`
+	AcceptedVersion int    `json:"acceptedVersion"`
+	AcceptedAt      string `json:"acceptedAt,omitempty"`
+}
+
+// handleAdminPolicy serves /api/admin/policy. GET reports the version in
+// force and how many active users have accepted it; POST publishes a new
+// version, which every user must accept before their next request.
+func (a *App) handleAdminPolicy(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	switch r.Method {
+	case http.MethodGet:
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		status, err := a.policyStatus(ctx)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to load policy status")
+			return
+		}
+		respondJSON(w, http.StatusOK, status)
+
+	case http.MethodPost:
+		var req struct {
+			Version int    `json:"version"`
+			Note    string `json:"note"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		req.Note = strings.TrimSpace(req.Note)
+		if len(req.Note) > maxPolicyNoteLen {
+			respondError(w, http.StatusBadRequest, "note is too long")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		current, err := a.currentPolicyVersion(ctx)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to load policy version")
+			return
+		}
+		if req.Version == 0 {
+			req.Version = current + 1
+		}
+		if req.Version <= current {
+			respondErrorDetails(w, http.StatusConflict, errCodePolicyVersionMismatch, "version must be above the current policy version", map[string]any{
+				"currentVersion": current,
+			})
+			return
+		}
+
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to publish policy version")
+			return
+		}
+		defer tx.Rollback()
+		var createdAt time.Time
+		err = tx.QueryRowContext(ctx, `
+INSERT INTO policy_versions(version, note, created_by)
+VALUES ($1, $2, $3)
+ON CONFLICT (version) DO NOTHING
+RETURNING created_at
+`, req.Version, req.Note, auth.UserID).Scan(&createdAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusConflict, "policy version already exists")
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to publish policy version")
+			return
+		}
+		if err := recordAdminAudit(ctx, tx, auth.UserID, auditPolicyPublished, 0, map[string]any{
+			"version":         req.Version,
+			"previousVersion": current,
+		}); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to record audit entry")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to publish policy version")
+			return
+		}
+
+		// Open connections were admitted under the old version; tell them so
+		// clients can prompt now rather than on their next REST call.
+		notified := 0
+		if payload, err := json.Marshal(map[string]any{"type": "policy_updated", "version": req.Version}); err == nil {
+			notified = a.hub.NotifyUsers(payload, nil)
+		}
+		requestLogger(r.Context()).Warn(
+			"policy_version_published",
+			"version", req.Version,
+			"previous_version", current,
+			"connections_notified", notified,
+			"admin_user_id", auth.UserID,
+		)
+		respondJSON(w, http.StatusCreated, policyVersion{
+			Version:   req.Version,
+			Note:      req.Note,
+			CreatedBy: auth.UserID,
+			CreatedAt: createdAt.UTC().Format(time.RFC3339Nano),
+		})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *App) currentPolicyVersion(ctx context.Context) (int, error) {
+	var published int
+	if err := a.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM policy_versions`).Scan(&published); err != nil {
+		return 0, err
+	}
+	return max(a.policyVersion, published), nil
+}
+
+// policyStatus counts acceptances among active human accounts and lists the
+// first of those still pending.
+func (a *App) policyStatus(ctx context.Context) (map[string]any, error) {
+	current, err := a.currentPolicyVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var totalUsers, acceptedUsers int
+	if err := a.db.QueryRowContext(ctx, `
+SELECT COUNT(*), COUNT(*) FILTER (WHERE accepted_policy_version >= $1)
+FROM users
+WHERE deleted_at IS NULL AND role IN ('admin', 'user')
+`, current).Scan(&totalUsers, &acceptedUsers); err != nil {
+		return nil, err
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT p.version, p.note, COALESCE(p.created_by, 0), p.created_at,
+       (SELECT COUNT(*) FROM users u
+        WHERE u.deleted_at IS NULL AND u.role IN ('admin', 'user') AND u.accepted_policy_version >= p.version)
+FROM policy_versions p
+ORDER BY p.version DESC
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := make([]policyVersion, 0)
+	for rows.Next() {
+		var item policyVersion
+		var createdAt time.Time
+		if err := rows.Scan(&item.Version, &item.Note, &item.CreatedBy, &createdAt, &item.AcceptedUsers); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		versions = append(versions, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pendingRows, err := a.db.QueryContext(ctx, `
+SELECT id, username, accepted_policy_version, policy_accepted_at
+FROM users
+WHERE deleted_at IS NULL AND role IN ('admin', 'user') AND accepted_policy_version < $1
+ORDER BY id ASC
+LIMIT $2
+`, current, maxPolicyPendingList)
+	if err != nil {
+		return nil, err
+	}
+	defer pendingRows.Close()
+	pending := make([]policyPendingUser, 0)
+	for pendingRows.Next() {
+		var item policyPendingUser
+		var acceptedAt sql.NullTime
+		if err := pendingRows.Scan(&item.UserID, &item.Username, &item.AcceptedVersion, &acceptedAt); err != nil {
+			return nil, err
+		}
+		if acceptedAt.Valid {
+			item.AcceptedAt = acceptedAt.Time.UTC().Format(time.RFC3339Nano)
+		}
+		pending = append(pending, item)
+	}
+	if err := pendingRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"currentVersion":    current,
+		"configuredVersion": a.policyVersion,
+		"totalUsers":        totalUsers,
+		"acceptedUsers":     acceptedUsers,
+		"pendingUsers":      totalUsers - acceptedUsers,
+		"pending":           pending,
+		"versions":          versions,
+	}, nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPolicyPending(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		configured int
+		identity   userIdentity
+		want       int
+	}{
+		{configured: 0, identity: userIdentity{}, want: 0},
+		{configured: 2, identity: userIdentity{AcceptedPolicyVersion: 2}, want: 0},
+		{configured: 2, identity: userIdentity{AcceptedPolicyVersion: 1}, want: 2},
+		{configured: 2, identity: userIdentity{AcceptedPolicyVersion: 2, PublishedPolicyVersion: 3}, want: 3},
+		{configured: 5, identity: userIdentity{AcceptedPolicyVersion: 4, PublishedPolicyVersion: 3}, want: 5},
+	}
+	for _, tc := range cases {
+		app := &App{policyVersion: tc.configured}
+		if got := app.policyPending(tc.identity); got != tc.want {
+			t.Fatalf("configured %d, identity %+v: expected %d, got %d", tc.configured, tc.identity, tc.want, got)
+		}
+	}
+}
+
+func TestAcceptPolicyRejectsVersionOtherThanCurrent(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	req := httptest.NewRequest(http.MethodPost, "/api/account/accept-policy", strings.NewReader(`{"version":2}`))
+	rec := httptest.NewRecorder()
+	app.handleAccountAcceptPolicy(rec, req, AuthContext{UserID: 1, PolicyVersion: 3, AcceptedPolicyVersion: 1})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body apiError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Code != errCodePolicyVersionMismatch || body.Details["requiredVersion"] != float64(3) {
+		t.Fatalf("unexpected error body %+v", body)
+	}
+}
+
+func TestPolicyAcceptanceRequiredResponse(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	respondPolicyAcceptanceRequired(rec, 4, 3)
+	if rec.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected 428, got %d", rec.Code)
+	}
+	var body apiError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Code != errCodePolicyAcceptanceRequired || body.Details["requiredVersion"] != float64(4) || body.Details["acceptedVersion"] != float64(3) {
+		t.Fatalf("unexpected error body %+v", body)
+	}
+	if !policyGateExemptPaths["/api/account/accept-policy"] || policyGateExemptPaths["/api/rooms"] {
+		t.Fatal("unexpected gate exemptions")
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	pollContentType = "poll_create"
+	minPollOptions  = 2
+	maxPollOptions  = 12
+)
+
+var errPollOptionOutOfRange = errors.New("poll option out of range")
+
+// validatePollPayload checks the plaintext option count a poll carries next to
+// its ciphertext. The question and option labels stay encrypted; the server
+// only learns how many options there are so it can validate votes. Edits do
+// not carry the count, so a poll cannot be edited into a different shape.
+func validatePollPayload(payload CipherPayload) error {
+	if payload.ContentType != pollContentType {
+		if payload.PollOptionCount != 0 {
+			return fmt.Errorf("%w: pollOptionCount is only valid on polls", errInvalidPayloadFormat)
+		}
+		return nil
+	}
+	if payload.PollOptionCount < minPollOptions || payload.PollOptionCount > maxPollOptions {
+		return fmt.Errorf("%w: polls need between %d and %d options", errInvalidPayloadFormat, minPollOptions, maxPollOptions)
+	}
+	return nil
+}
+
+type pollTally struct {
+	RoomID      int64   `json:"roomId"`
+	PollID      int64   `json:"pollId"`
+	OptionCount int     `json:"optionCount"`
+	Counts      []int64 `json:"counts"`
+	TotalVotes  int64   `json:"totalVotes"`
+	MyVote      *int    `json:"myVote,omitempty"`
+}
+
+// loadPollOptionCount returns sql.ErrNoRows unless pollID is a live poll in
+// the room.
+func (a *App) loadPollOptionCount(ctx context.Context, roomID, pollID int64) (int, error) {
+	var optionCount int
+	err := a.db.QueryRowContext(ctx, `
+SELECT COALESCE((payload->>'pollOptionCount')::int, 0)
+FROM messages
+WHERE id = $1 AND room_id = $2 AND revoked_at IS NULL AND payload->>'contentType' = $3
+`, pollID, roomID, pollContentType).Scan(&optionCount)
+	return optionCount, err
+}
+
+// recordPollVote stores or replaces the member's single vote on a poll.
+func (a *App) recordPollVote(ctx context.Context, roomID, pollID, userID int64, optionIndex int) error {
+	optionCount, err := a.loadPollOptionCount(ctx, roomID, pollID)
+	if err != nil {
+		return err
+	}
+	if optionIndex < 0 || optionIndex >= optionCount {
+		return errPollOptionOutOfRange
+	}
+	_, err = a.db.ExecContext(ctx, `
+INSERT INTO poll_votes(message_id, user_id, option_index)
+VALUES ($1, $2, $3)
+ON CONFLICT (message_id, user_id) DO UPDATE SET option_index = EXCLUDED.option_index, voted_at = NOW()
+`, pollID, userID, optionIndex)
+	return err
+}
+
+func (a *App) loadPollTally(ctx context.Context, roomID, pollID, viewerID int64) (pollTally, error) {
+	optionCount, err := a.loadPollOptionCount(ctx, roomID, pollID)
+	if err != nil {
+		return pollTally{}, err
+	}
+	tally := pollTally{
+		RoomID:      roomID,
+		PollID:      pollID,
+		OptionCount: optionCount,
+		Counts:      make([]int64, optionCount),
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT option_index, COUNT(*), BOOL_OR(user_id = $2)
+FROM poll_votes
+WHERE message_id = $1
+GROUP BY option_index
+`, pollID, viewerID)
+	if err != nil {
+		return pollTally{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var optionIndex int
+		var count int64
+		var mine bool
+		if err := rows.Scan(&optionIndex, &count, &mine); err != nil {
+			return pollTally{}, err
+		}
+		if optionIndex < 0 || optionIndex >= optionCount {
+			continue
+		}
+		tally.Counts[optionIndex] = count
+		tally.TotalVotes += count
+		if mine {
+			value := optionIndex
+			tally.MyVote = &value
+		}
+	}
+	return tally, rows.Err()
+}
+
+func (a *App) handleRoomPoll(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64, parts []string) {
+	if len(parts) != 1 {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	pollID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || pollID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid poll id")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "not a room member")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+		return
+	}
+	tally, err := a.loadPollTally(ctx, roomID, pollID, auth.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "poll not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load poll")
+		return
+	}
+	respondJSON(w, http.StatusOK, tally)
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidatePollPayload(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		payload CipherPayload
+		valid   bool
+	}{
+		{name: "plain message", payload: CipherPayload{ContentType: "text"}, valid: true},
+		{name: "plain message with count", payload: CipherPayload{ContentType: "text", PollOptionCount: 3}},
+		{name: "poll", payload: CipherPayload{ContentType: pollContentType, PollOptionCount: 3}, valid: true},
+		{name: "poll without count", payload: CipherPayload{ContentType: pollContentType}},
+		{name: "poll with one option", payload: CipherPayload{ContentType: pollContentType, PollOptionCount: 1}},
+		{name: "poll with too many options", payload: CipherPayload{ContentType: pollContentType, PollOptionCount: maxPollOptions + 1}},
+	}
+	for _, item := range cases {
+		err := validatePollPayload(item.payload)
+		if item.valid && err != nil {
+			t.Fatalf("%s: unexpected error %v", item.name, err)
+		}
+		if !item.valid && !errors.Is(err, errInvalidPayloadFormat) {
+			t.Fatalf("%s: expected invalid payload format, got %v", item.name, err)
+		}
+	}
+}
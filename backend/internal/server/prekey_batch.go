@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// normalizePreKeyBatchUserIDs dedupes and sorts the requested users. Sorting
+// keeps the one-time prekey row locks in a stable order across concurrent
+// batches.
+func normalizePreKeyBatchUserIDs(userIDs []int64) ([]int64, error) {
+	if len(userIDs) == 0 {
+		return nil, errors.New("userIds is required")
+	}
+	seen := make(map[int64]struct{}, len(userIDs))
+	normalized := make([]int64, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if userID <= 0 {
+			return nil, errors.New("invalid user id")
+		}
+		if _, ok := seen[userID]; ok {
+			continue
+		}
+		seen[userID] = struct{}{}
+		normalized = append(normalized, userID)
+	}
+	if len(normalized) > maxPreKeyBundleBatchUsers {
+		return nil, errors.New("too many users in one batch")
+	}
+	sort.Slice(normalized, func(i, j int) bool { return normalized[i] < normalized[j] })
+	return normalized, nil
+}
+
+// loadSharedRoomUsers returns the subset of userIDs that share at least one
+// room with userID. The caller always counts as sharing a room with itself.
+func (a *App) loadSharedRoomUsers(ctx context.Context, userID int64, userIDs []int64) (map[int64]struct{}, error) {
+	rows, err := a.db.QueryContext(ctx, `
+SELECT DISTINCT rm_right.user_id
+FROM room_members rm_left
+JOIN room_members rm_right ON rm_left.room_id = rm_right.room_id
+WHERE rm_left.user_id = $1 AND rm_right.user_id = ANY($2::BIGINT[])
+`, userID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shared := make(map[int64]struct{}, len(userIDs))
+	shared[userID] = struct{}{}
+	for rows.Next() {
+		var sharedID int64
+		if err := rows.Scan(&sharedID); err != nil {
+			return nil, err
+		}
+		shared[sharedID] = struct{}{}
+	}
+	return shared, rows.Err()
+}
+
+func (a *App) loadBotAccounts(ctx context.Context, userIDs []int64) (map[int64]struct{}, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT id FROM users WHERE id = ANY($1::BIGINT[]) AND role = $2`,
+		userIDs, roleBot,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bots := make(map[int64]struct{})
+	for rows.Next() {
+		var botID int64
+		if err := rows.Scan(&botID); err != nil {
+			return nil, err
+		}
+		bots[botID] = struct{}{}
+	}
+	return bots, rows.Err()
+}
+
+// handleSignalPreKeyBundleBatch fetches bundles for many users in one round
+// trip, as when joining a large room. Every target must share a room with the
+// caller or the whole batch is refused; one-time prekeys for all targets are
+// consumed in a single transaction.
+func (a *App) handleSignalPreKeyBundleBatch(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		UserIDs []int64 `json:"userIds"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	userIDs, err := normalizePreKeyBatchUserIDs(req.UserIDs)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+	defer cancel()
+
+	shared, err := a.loadSharedRoomUsers(ctx, auth.UserID, userIDs)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to validate room relationship")
+		return
+	}
+	unrelated := make([]int64, 0)
+	for _, userID := range userIDs {
+		if _, ok := shared[userID]; !ok {
+			unrelated = append(unrelated, userID)
+		}
+	}
+	if len(unrelated) > 0 {
+		respondErrorDetails(w, http.StatusForbidden, "no_shared_room", "target users are not in any shared room", map[string]any{
+			"userIds": unrelated,
+		})
+		return
+	}
+	bots, err := a.loadBotAccounts(ctx, userIDs)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load target users")
+		return
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to begin transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	bundles := make([]SignalPreKeyBundleResponse, 0, len(userIDs))
+	missing := make([]map[string]any, 0)
+	for _, userID := range userIDs {
+		if _, isBot := bots[userID]; isBot {
+			missing = append(missing, map[string]any{"userId": userID, "code": "bot_account"})
+			continue
+		}
+		if err := a.checkPreKeyFetchBudgetTx(ctx, tx, auth.UserID, userID); err != nil {
+			if errors.Is(err, errPreKeyFetchBudgetExceeded) {
+				missing = append(missing, map[string]any{"userId": userID, "code": "prekey_fetch_limited"})
+				continue
+			}
+			respondError(w, http.StatusInternalServerError, "failed to check prekey fetch budget")
+			return
+		}
+		bundle, err := loadPreKeyBundleTx(ctx, tx, userID, &auth)
+		if err != nil {
+			if errors.Is(err, errPreKeyBundleNotPublished) {
+				missing = append(missing, map[string]any{"userId": userID, "code": "prekey_bundle_not_published"})
+				continue
+			}
+			requestLogger(r.Context()).Error("prekey_bundle_batch_load_failed", "target_user_id", userID, "error", err)
+			respondError(w, http.StatusInternalServerError, "failed to load target prekey bundles")
+			return
+		}
+		bundles = append(bundles, bundle)
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to finalize prekey bundle fetch")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"bundles": bundles, "missing": missing})
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizePreKeyBatchUserIDs(t *testing.T) {
+	t.Parallel()
+
+	got, err := normalizePreKeyBatchUserIDs([]int64{9, 3, 9, 1, 3})
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if want := []int64{1, 3, 9}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if _, err := normalizePreKeyBatchUserIDs(nil); err == nil {
+		t.Fatalf("expected empty batch to be rejected")
+	}
+	if _, err := normalizePreKeyBatchUserIDs([]int64{4, 0}); err == nil {
+		t.Fatalf("expected non-positive user id to be rejected")
+	}
+
+	tooMany := make([]int64, 0, maxPreKeyBundleBatchUsers+1)
+	for i := int64(1); i <= maxPreKeyBundleBatchUsers+1; i++ {
+		tooMany = append(tooMany, i)
+	}
+	if _, err := normalizePreKeyBatchUserIDs(tooMany); err == nil {
+		t.Fatalf("expected oversized batch to be rejected")
+	}
+}
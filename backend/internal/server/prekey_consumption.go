@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPreKeyFetchesPerTargetHour = 20
+	defaultPreKeyConsumptionLimit     = 100
+	maxPreKeyConsumptionLimit         = 500
+	preKeyConsumptionRetention        = 30 * 24 * time.Hour
+)
+
+var errPreKeyFetchBudgetExceeded = errors.New("too many prekey bundle fetches for this user")
+
+func (a *App) preKeyFetchLimit() int {
+	if a.preKeyFetchesPerTargetHour <= 0 {
+		return defaultPreKeyFetchesPerTargetHour
+	}
+	return a.preKeyFetchesPerTargetHour
+}
+
+// preKeyFetchLockKey derives the advisory lock key that serializes fetches
+// by one requester from one owner.
+func preKeyFetchLockKey(requesterID, targetUserID int64) int64 {
+	h := fnv.New64a()
+	var buf [17]byte
+	buf[0] = 'p'
+	binary.BigEndian.PutUint64(buf[1:9], uint64(requesterID))
+	binary.BigEndian.PutUint64(buf[9:], uint64(targetUserID))
+	_, _ = h.Write(buf[:])
+	return int64(h.Sum64())
+}
+
+// checkPreKeyFetchBudgetTx refuses to serve another bundle once the requester
+// has fetched the target's bundle too often in the past hour. Each fetch
+// claims a one-time prekey per device, so an unbounded requester could drain
+// the target's supply and force everyone else onto the signed prekey. The
+// advisory lock holds concurrent fetches for the same pair until this
+// transaction has recorded its consumption, so they cannot all pass the
+// count together.
+func (a *App) checkPreKeyFetchBudgetTx(ctx context.Context, tx *sql.Tx, requesterID, targetUserID int64) error {
+	if requesterID == targetUserID {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, preKeyFetchLockKey(requesterID, targetUserID)); err != nil {
+		return err
+	}
+	var served int
+	err := tx.QueryRowContext(ctx, `
+SELECT COALESCE(MAX(served), 0)
+FROM (
+  SELECT COUNT(*) AS served
+  FROM signal_prekey_consumptions
+  WHERE requester_user_id = $1
+    AND owner_user_id = $2
+    AND consumed_at > NOW() - INTERVAL '1 hour'
+  GROUP BY owner_device_id
+) per_device
+`, requesterID, targetUserID).Scan(&served)
+	if err != nil {
+		return err
+	}
+	if served >= a.preKeyFetchLimit() {
+		return errPreKeyFetchBudgetExceeded
+	}
+	return nil
+}
+
+// recordPreKeyConsumptionTx logs one served device bundle. keyID is nil when
+// the device had no one-time prekeys left.
+func recordPreKeyConsumptionTx(ctx context.Context, tx *sql.Tx, ownerUserID int64, ownerDeviceID string, keyID *int64, requester AuthContext, servedAt time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO signal_prekey_consumptions(owner_user_id, owner_device_id, key_id, requester_user_id, requester_device_id, consumed_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+`, ownerUserID, ownerDeviceID, keyID, requester.UserID, requester.DeviceID, servedAt)
+	return err
+}
+
+// handleSignalPreKeyConsumption lists who fetched the caller's prekey bundles,
+// newest first, so the owner can spot unexpected session setups.
+func (a *App) handleSignalPreKeyConsumption(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limit := defaultPreKeyConsumptionLimit
+	if value := strings.TrimSpace(r.URL.Query().Get("limit")); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 && parsed <= maxPreKeyConsumptionLimit {
+			limit = parsed
+		}
+	}
+	deviceID := normalizeDeviceID(r.URL.Query().Get("deviceId"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT c.owner_device_id, c.key_id, c.requester_user_id, u.username, c.requester_device_id, c.consumed_at
+FROM signal_prekey_consumptions c
+JOIN users u ON u.id = c.requester_user_id
+WHERE c.owner_user_id = $1
+  AND ($2 = '' OR c.owner_device_id = $2)
+  AND c.consumed_at > $3
+ORDER BY c.consumed_at DESC, c.id DESC
+LIMIT $4
+`, auth.UserID, deviceID, time.Now().Add(-preKeyConsumptionRetention), limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load prekey consumption")
+		return
+	}
+	defer rows.Close()
+
+	entries := make([]map[string]any, 0, limit)
+	for rows.Next() {
+		var ownerDeviceID, requesterUsername, requesterDeviceID string
+		var keyID sql.NullInt64
+		var requesterUserID int64
+		var consumedAt time.Time
+		if err := rows.Scan(&ownerDeviceID, &keyID, &requesterUserID, &requesterUsername, &requesterDeviceID, &consumedAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode prekey consumption")
+			return
+		}
+		entry := map[string]any{
+			"deviceId":          ownerDeviceID,
+			"oneTimePreKeyId":   nil,
+			"requesterUserId":   requesterUserID,
+			"requesterUsername": requesterUsername,
+			"requesterDeviceId": requesterDeviceID,
+			"consumedAt":        consumedAt.UTC().Format(time.RFC3339Nano),
+		}
+		if keyID.Valid {
+			entry["oneTimePreKeyId"] = keyID.Int64
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to iterate prekey consumption")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
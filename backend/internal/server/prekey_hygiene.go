@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultConsumedPreKeyRetentionDays = 30
+	defaultSignedPreKeyMaxAgeDays      = 30
+	preKeyHygieneInterval              = time.Hour
+	preKeyHygieneTimeout               = 30 * time.Second
+	maxStaleSignedPreKeyDevices        = 500
+)
+
+type preKeyHygieneConfig struct {
+	ConsumedRetention  time.Duration
+	SignedPreKeyMaxAge time.Duration
+}
+
+func (c preKeyHygieneConfig) withDefaults() preKeyHygieneConfig {
+	if c.ConsumedRetention <= 0 {
+		c.ConsumedRetention = defaultConsumedPreKeyRetentionDays * 24 * time.Hour
+	}
+	if c.SignedPreKeyMaxAge <= 0 {
+		c.SignedPreKeyMaxAge = defaultSignedPreKeyMaxAgeDays * 24 * time.Hour
+	}
+	return c
+}
+
+type staleSignedPreKey struct {
+	UserID     int64  `json:"userId"`
+	Username   string `json:"username"`
+	DeviceID   string `json:"deviceId"`
+	DeviceName string `json:"deviceName"`
+	KeyID      int64  `json:"keyId"`
+	UpdatedAt  string `json:"updatedAt"`
+	LastSeenAt string `json:"lastSeenAt"`
+}
+
+type preKeyHygieneReport struct {
+	RanAt                    string              `json:"ranAt"`
+	DeletedConsumedPreKeys   int64               `json:"deletedConsumedPreKeys"`
+	DeletedPreKeyFetches     int64               `json:"deletedPreKeyFetches"`
+	ConsumedRetentionDays    int                 `json:"consumedRetentionDays"`
+	SignedPreKeyMaxAgeDays   int                 `json:"signedPreKeyMaxAgeDays"`
+	StaleSignedPreKeys       []staleSignedPreKey `json:"staleSignedPreKeys"`
+	StaleSignedPreKeysCapped bool                `json:"staleSignedPreKeysCapped"`
+}
+
+// preKeyHygieneJob prunes consumed one-time prekeys and expired bundle fetch
+// records, and records which active devices have not rotated their signed
+// prekey recently. The latest report is kept in memory for the admin endpoint.
+type preKeyHygieneJob struct {
+	db     *sql.DB
+	cfg    preKeyHygieneConfig
+	report atomic.Pointer[preKeyHygieneReport]
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newPreKeyHygieneJob(db *sql.DB, cfg preKeyHygieneConfig) *preKeyHygieneJob {
+	return &preKeyHygieneJob{
+		db:   db,
+		cfg:  cfg.withDefaults(),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (j *preKeyHygieneJob) Start() {
+	go j.run()
+}
+
+func (j *preKeyHygieneJob) Stop() {
+	if j == nil {
+		return
+	}
+	j.stopOnce.Do(func() {
+		close(j.stop)
+	})
+	<-j.done
+}
+
+func (j *preKeyHygieneJob) run() {
+	defer close(j.done)
+	ticker := time.NewTicker(preKeyHygieneInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), preKeyHygieneTimeout)
+		report, err := j.Run(ctx)
+		cancel()
+		if err != nil {
+			logger.Warn("prekey_hygiene_failed", "error", err)
+		} else {
+			logger.Info("prekey_hygiene_completed",
+				"deleted_consumed_prekeys", report.DeletedConsumedPreKeys,
+				"deleted_prekey_fetches", report.DeletedPreKeyFetches,
+				"stale_signed_prekeys", len(report.StaleSignedPreKeys),
+			)
+		}
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run performs one sweep and stores its report as the latest.
+func (j *preKeyHygieneJob) Run(ctx context.Context) (preKeyHygieneReport, error) {
+	now := time.Now().UTC()
+	result, err := j.db.ExecContext(ctx, `
+DELETE FROM signal_device_one_time_prekeys
+WHERE consumed_at IS NOT NULL AND consumed_at < $1
+`, now.Add(-j.cfg.ConsumedRetention))
+	if err != nil {
+		return preKeyHygieneReport{}, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return preKeyHygieneReport{}, err
+	}
+	result, err = j.db.ExecContext(ctx, `
+DELETE FROM signal_prekey_consumptions
+WHERE consumed_at < $1
+`, now.Add(-preKeyConsumptionRetention))
+	if err != nil {
+		return preKeyHygieneReport{}, err
+	}
+	deletedFetches, err := result.RowsAffected()
+	if err != nil {
+		return preKeyHygieneReport{}, err
+	}
+
+	stale, capped, err := j.loadStaleSignedPreKeys(ctx, now.Add(-j.cfg.SignedPreKeyMaxAge))
+	if err != nil {
+		return preKeyHygieneReport{}, err
+	}
+	report := preKeyHygieneReport{
+		RanAt:                    now.Format(time.RFC3339Nano),
+		DeletedConsumedPreKeys:   deleted,
+		DeletedPreKeyFetches:     deletedFetches,
+		ConsumedRetentionDays:    int(j.cfg.ConsumedRetention / (24 * time.Hour)),
+		SignedPreKeyMaxAgeDays:   int(j.cfg.SignedPreKeyMaxAge / (24 * time.Hour)),
+		StaleSignedPreKeys:       stale,
+		StaleSignedPreKeysCapped: capped,
+	}
+	j.report.Store(&report)
+	return report, nil
+}
+
+// loadStaleSignedPreKeys lists active devices whose signed prekey was last
+// uploaded before cutoff, oldest first. Revoked devices are skipped since
+// nobody can start a session with them anyway.
+func (j *preKeyHygieneJob) loadStaleSignedPreKeys(ctx context.Context, cutoff time.Time) ([]staleSignedPreKey, bool, error) {
+	rows, err := j.db.QueryContext(ctx, `
+SELECT sp.user_id, u.username, sp.device_id, d.device_name, sp.key_id, sp.updated_at, d.last_seen_at
+FROM signal_device_signed_prekeys sp
+JOIN user_devices d ON d.user_id = sp.user_id AND d.device_id = sp.device_id
+JOIN users u ON u.id = sp.user_id
+WHERE d.revoked_at IS NULL AND sp.updated_at < $1
+ORDER BY sp.updated_at ASC, sp.user_id ASC, sp.device_id ASC
+LIMIT $2
+`, cutoff, maxStaleSignedPreKeyDevices+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	stale := make([]staleSignedPreKey, 0, 16)
+	for rows.Next() {
+		var item staleSignedPreKey
+		var updatedAt, lastSeenAt time.Time
+		if err := rows.Scan(&item.UserID, &item.Username, &item.DeviceID, &item.DeviceName, &item.KeyID, &updatedAt, &lastSeenAt); err != nil {
+			return nil, false, err
+		}
+		item.UpdatedAt = updatedAt.UTC().Format(time.RFC3339Nano)
+		item.LastSeenAt = lastSeenAt.UTC().Format(time.RFC3339Nano)
+		stale = append(stale, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(stale) > maxStaleSignedPreKeyDevices {
+		return stale[:maxStaleSignedPreKeyDevices], true, nil
+	}
+	return stale, false, nil
+}
+
+// Latest returns the most recent report, if a sweep has completed.
+func (j *preKeyHygieneJob) Latest() (preKeyHygieneReport, bool) {
+	if j == nil {
+		return preKeyHygieneReport{}, false
+	}
+	report := j.report.Load()
+	if report == nil {
+		return preKeyHygieneReport{}, false
+	}
+	return *report, true
+}
+
+// handleAdminSignalHygiene returns the latest sweep. POST runs a sweep now
+// instead of waiting for the next tick.
+func (a *App) handleAdminSignalHygiene(w http.ResponseWriter, r *http.Request, _ AuthContext) {
+	switch r.Method {
+	case http.MethodGet:
+		report, ok := a.preKeyHygiene.Latest()
+		if !ok {
+			respondJSON(w, http.StatusOK, map[string]any{"report": nil})
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"report": report})
+	case http.MethodPost:
+		if a.preKeyHygiene == nil {
+			respondError(w, http.StatusServiceUnavailable, "prekey hygiene job is not running")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), preKeyHygieneTimeout)
+		defer cancel()
+		report, err := a.preKeyHygiene.Run(ctx)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to run prekey hygiene")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"report": report})
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreKeyHygieneConfigDefaults(t *testing.T) {
+	t.Parallel()
+
+	cfg := preKeyHygieneConfig{}.withDefaults()
+	if cfg.ConsumedRetention != 30*24*time.Hour || cfg.SignedPreKeyMaxAge != 30*24*time.Hour {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+	custom := preKeyHygieneConfig{ConsumedRetention: time.Hour, SignedPreKeyMaxAge: 2 * time.Hour}.withDefaults()
+	if custom.ConsumedRetention != time.Hour || custom.SignedPreKeyMaxAge != 2*time.Hour {
+		t.Fatalf("expected explicit values to be kept, got %+v", custom)
+	}
+}
+
+func TestAdminSignalHygieneBeforeFirstSweep(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	rec := httptest.NewRecorder()
+	app.handleAdminSignalHygiene(rec, httptest.NewRequest(http.MethodGet, "/api/admin/signal/hygiene", nil), AuthContext{})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"report":null`) {
+		t.Fatalf("expected empty report, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	app.handleAdminSignalHygiene(rec, httptest.NewRequest(http.MethodPost, "/api/admin/signal/hygiene", nil), AuthContext{})
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a running job, got %d", rec.Code)
+	}
+}
+
+func TestPreKeyHygienePrunesFetchLog(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+
+	ctx := context.Background()
+	var userID int64
+	if err := db.QueryRowContext(ctx,
+		`INSERT INTO users(username, password_hash) VALUES ($1, $2) RETURNING id`, "alice", "hash",
+	).Scan(&userID); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO user_devices(user_id, device_id) VALUES ($1, $2)`, userID, "phone"); err != nil {
+		t.Fatalf("insert device: %v", err)
+	}
+	now := time.Now()
+	for _, consumedAt := range []time.Time{now.Add(-preKeyConsumptionRetention - time.Hour), now.Add(-time.Hour)} {
+		if _, err := db.ExecContext(ctx, `
+INSERT INTO signal_prekey_consumptions(owner_user_id, owner_device_id, requester_user_id, requester_device_id, consumed_at)
+VALUES ($1, $2, $1, $2, $3)
+`, userID, "phone", consumedAt); err != nil {
+			t.Fatalf("insert consumption: %v", err)
+		}
+	}
+
+	report, err := newPreKeyHygieneJob(db, preKeyHygieneConfig{}).Run(ctx)
+	if err != nil {
+		t.Fatalf("run hygiene: %v", err)
+	}
+	var remaining int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM signal_prekey_consumptions`).Scan(&remaining); err != nil {
+		t.Fatalf("count consumptions: %v", err)
+	}
+	if report.DeletedPreKeyFetches != 1 || remaining != 1 {
+		t.Fatalf("deleted %d, %d left; want the expired fetch pruned", report.DeletedPreKeyFetches, remaining)
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const (
+	presenceOnline  = "online"
+	presenceAway    = "away"
+	presenceDND     = "dnd"
+	presenceOffline = "offline"
+)
+
+func validPresenceStatus(status string) bool {
+	switch status {
+	case presenceOnline, presenceAway, presenceDND:
+		return true
+	default:
+		return false
+	}
+}
+
+type devicePresence struct {
+	status      string
+	connections int
+}
+
+// presenceTracker keeps the status each connected device last reported. A
+// device is online until it sends a presence_update and drops out when its
+// last connection closes. A user's status aggregates their devices: do not
+// disturb on any device wins, then online, then away.
+type presenceTracker struct {
+	mu    sync.Mutex
+	users map[int64]map[string]*devicePresence
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{users: make(map[int64]map[string]*devicePresence)}
+}
+
+// Connect counts a new connection for the device and reports the user's
+// status afterwards and whether it changed.
+func (p *presenceTracker) Connect(userID int64, deviceID string) (string, bool) {
+	if p == nil {
+		return presenceOnline, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	before := p.statusLocked(userID)
+	devices, ok := p.users[userID]
+	if !ok {
+		devices = make(map[string]*devicePresence)
+		p.users[userID] = devices
+	}
+	device, ok := devices[deviceID]
+	if !ok {
+		device = &devicePresence{status: presenceOnline}
+		devices[deviceID] = device
+	}
+	device.connections++
+	after := p.statusLocked(userID)
+	return after, after != before
+}
+
+// Disconnect releases one of the device's connections; the device's status
+// is forgotten with its last one.
+func (p *presenceTracker) Disconnect(userID int64, deviceID string) (string, bool) {
+	if p == nil {
+		return presenceOffline, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	before := p.statusLocked(userID)
+	device, ok := p.users[userID][deviceID]
+	if !ok {
+		return before, false
+	}
+	device.connections--
+	if device.connections <= 0 {
+		delete(p.users[userID], deviceID)
+		if len(p.users[userID]) == 0 {
+			delete(p.users, userID)
+		}
+	}
+	after := p.statusLocked(userID)
+	return after, after != before
+}
+
+// Set records the status a connected device reported.
+func (p *presenceTracker) Set(userID int64, deviceID string, status string) (string, bool) {
+	if p == nil {
+		return status, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	before := p.statusLocked(userID)
+	device, ok := p.users[userID][deviceID]
+	if !ok {
+		return before, false
+	}
+	device.status = status
+	after := p.statusLocked(userID)
+	return after, after != before
+}
+
+func (p *presenceTracker) Status(userID int64) string {
+	if p == nil {
+		return presenceOffline
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.statusLocked(userID)
+}
+
+func (p *presenceTracker) statusLocked(userID int64) string {
+	devices := p.users[userID]
+	if len(devices) == 0 {
+		return presenceOffline
+	}
+	status := presenceAway
+	for _, device := range devices {
+		switch device.status {
+		case presenceDND:
+			return presenceDND
+		case presenceOnline:
+			status = presenceOnline
+		}
+	}
+	return status
+}
+
+// BroadcastPresence sends a presence delta to every room the user has a live
+// connection in, plus extraRoomID, which is the room a closing connection has
+// already been removed from.
+func (h *Hub) BroadcastPresence(userID int64, username string, status string, extraRoomID int64) {
+	h.mu.RLock()
+	roomIDs := make([]int64, 0, 4)
+	for roomID, roomClients := range h.rooms {
+		if roomID == extraRoomID {
+			continue
+		}
+		for client := range roomClients {
+			if client.userID == userID {
+				roomIDs = append(roomIDs, roomID)
+				break
+			}
+		}
+	}
+	h.mu.RUnlock()
+	if extraRoomID > 0 {
+		roomIDs = append(roomIDs, extraRoomID)
+	}
+
+	for _, roomID := range roomIDs {
+		payload, err := json.Marshal(map[string]any{
+			"type":     "presence",
+			"roomId":   roomID,
+			"userId":   userID,
+			"username": username,
+			"status":   status,
+		})
+		if err != nil {
+			return
+		}
+		h.BroadcastFrom(roomID, userID, payload)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPresenceTrackerAggregatesDevices(t *testing.T) {
+	t.Parallel()
+
+	tracker := newPresenceTracker()
+	if status, changed := tracker.Connect(1, "phone"); !changed || status != presenceOnline {
+		t.Fatalf("expected first connection to go online, got %q %v", status, changed)
+	}
+	if _, changed := tracker.Connect(1, "laptop"); changed {
+		t.Fatal("expected second online device to leave status unchanged")
+	}
+	if _, changed := tracker.Set(1, "phone", presenceAway); changed {
+		t.Fatal("expected away phone with online laptop to stay online")
+	}
+	if status, changed := tracker.Set(1, "laptop", presenceAway); !changed || status != presenceAway {
+		t.Fatalf("expected all-away devices to aggregate to away, got %q %v", status, changed)
+	}
+	if status, _ := tracker.Set(1, "laptop", presenceDND); status != presenceDND {
+		t.Fatalf("expected dnd to win, got %q", status)
+	}
+	if status, changed := tracker.Disconnect(1, "laptop"); !changed || status != presenceAway {
+		t.Fatalf("expected dnd to clear with its device, got %q %v", status, changed)
+	}
+	if _, changed := tracker.Set(1, "tablet", presenceDND); changed {
+		t.Fatal("expected unknown device update to be ignored")
+	}
+	if status, changed := tracker.Disconnect(1, "phone"); !changed || status != presenceOffline {
+		t.Fatalf("expected last disconnect to go offline, got %q %v", status, changed)
+	}
+}
+
+func TestPresenceTrackerCountsConnectionsPerDevice(t *testing.T) {
+	t.Parallel()
+
+	tracker := newPresenceTracker()
+	tracker.Connect(1, "phone")
+	tracker.Connect(1, "phone")
+	tracker.Set(1, "phone", presenceAway)
+	if status, changed := tracker.Disconnect(1, "phone"); changed || status != presenceAway {
+		t.Fatalf("expected device with a remaining connection to keep its status, got %q %v", status, changed)
+	}
+}
+
+func TestHubBroadcastPresenceReachesUserRooms(t *testing.T) {
+	t.Parallel()
+
+	hub := NewHub()
+	alice := &Client{userID: 1, deviceID: "a", roomID: 7, send: make(chan []byte, 4)}
+	bob := &Client{userID: 2, deviceID: "b", roomID: 7, send: make(chan []byte, 4)}
+	carol := &Client{userID: 3, deviceID: "c", roomID: 8, send: make(chan []byte, 4)}
+	hub.AddClient(alice)
+	hub.AddClient(bob)
+	hub.AddClient(carol)
+
+	hub.BroadcastPresence(1, "alice", presenceAway, 0)
+	var frame struct {
+		Type   string `json:"type"`
+		RoomID int64  `json:"roomId"`
+		UserID int64  `json:"userId"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(<-bob.send, &frame); err != nil {
+		t.Fatalf("decode presence frame: %v", err)
+	}
+	if frame.Type != "presence" || frame.RoomID != 7 || frame.UserID != 1 || frame.Status != presenceAway {
+		t.Fatalf("unexpected presence frame: %+v", frame)
+	}
+	select {
+	case payload := <-carol.send:
+		t.Fatalf("expected room without alice to get nothing, got %s", payload)
+	default:
+	}
+
+	hub.BroadcastPresence(1, "alice", presenceOffline, 8)
+	if err := json.Unmarshal(<-carol.send, &frame); err != nil || frame.RoomID != 8 || frame.Status != presenceOffline {
+		t.Fatalf("expected offline delta in the extra room, got %+v %v", frame, err)
+	}
+}
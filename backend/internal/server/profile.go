@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 280
+)
+
+type userProfile struct {
+	UserID      int64   `json:"userId"`
+	Username    string  `json:"username"`
+	DisplayName string  `json:"displayName,omitempty"`
+	Bio         string  `json:"bio,omitempty"`
+	UpdatedAt   *string `json:"updatedAt,omitempty"`
+	// HideLastSeen keeps last_seen_at out of member listings for everyone
+	// but the user.
+	HideLastSeen bool `json:"hideLastSeen"`
+}
+
+// normalizeProfileText trims value and rejects control characters, which
+// would let one member spoof line breaks in another client's member list.
+// Newlines are allowed in the bio only.
+func normalizeProfileText(value string, maxLength int, allowNewlines bool) (string, bool) {
+	value = strings.TrimSpace(value)
+	if utf8.RuneCountInString(value) > maxLength {
+		return "", false
+	}
+	for _, r := range value {
+		if allowNewlines && r == '\n' {
+			continue
+		}
+		if unicode.IsControl(r) {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+func (a *App) loadUserProfile(ctx context.Context, userID int64) (userProfile, error) {
+	profile := userProfile{UserID: userID}
+	var updatedAt sql.NullTime
+	err := a.db.QueryRowContext(ctx, `
+SELECT username, COALESCE(display_name, ''), COALESCE(bio, ''), profile_updated_at, hide_last_seen
+FROM users
+WHERE id = $1 AND deleted_at IS NULL
+`, userID).Scan(&profile.Username, &profile.DisplayName, &profile.Bio, &updatedAt, &profile.HideLastSeen)
+	if err != nil {
+		return userProfile{}, err
+	}
+	if updatedAt.Valid {
+		value := updatedAt.Time.UTC().Format(time.RFC3339Nano)
+		profile.UpdatedAt = &value
+	}
+	return profile, nil
+}
+
+// handleAccountProfile reads or updates the caller's public profile and
+// last-seen privacy. An empty string clears a field; an absent field is left
+// unchanged.
+func (a *App) handleAccountProfile(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	profileChanged := false
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var req struct {
+			DisplayName  *string `json:"displayName"`
+			Bio          *string `json:"bio"`
+			HideLastSeen *bool   `json:"hideLastSeen"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		if req.DisplayName == nil && req.Bio == nil && req.HideLastSeen == nil {
+			respondError(w, http.StatusBadRequest, "no profile changes requested")
+			return
+		}
+		if req.DisplayName != nil {
+			value, ok := normalizeProfileText(*req.DisplayName, maxDisplayNameLength, false)
+			if !ok {
+				respondError(w, http.StatusBadRequest, "displayName must be at most 64 characters without control characters")
+				return
+			}
+			req.DisplayName = &value
+		}
+		if req.Bio != nil {
+			value, ok := normalizeProfileText(*req.Bio, maxBioLength, true)
+			if !ok {
+				respondError(w, http.StatusBadRequest, "bio must be at most 280 characters without control characters")
+				return
+			}
+			req.Bio = &value
+		}
+		profileChanged = req.DisplayName != nil || req.Bio != nil
+		if _, err := a.db.ExecContext(ctx, `
+UPDATE users
+SET display_name = CASE WHEN $2 THEN NULLIF($3, '') ELSE display_name END,
+    bio = CASE WHEN $4 THEN NULLIF($5, '') ELSE bio END,
+    hide_last_seen = COALESCE($6, hide_last_seen),
+    profile_updated_at = CASE WHEN $2 OR $4 THEN NOW() ELSE profile_updated_at END
+WHERE id = $1 AND deleted_at IS NULL
+`, auth.UserID, req.DisplayName != nil, stringOrEmpty(req.DisplayName), req.Bio != nil, stringOrEmpty(req.Bio), req.HideLastSeen); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update profile")
+			return
+		}
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	profile, err := a.loadUserProfile(ctx, auth.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load profile")
+		return
+	}
+	if profileChanged {
+		a.hub.SetDisplayName(auth.UserID, profile.DisplayName)
+		a.broadcastProfileUpdated(ctx, profile)
+		requestLogger(r.Context()).Info("profile_updated", "user_id", auth.UserID)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"profile": profile})
+}
+
+// broadcastProfileUpdated tells every room the user belongs to about the new
+// profile so clients can refresh member lists without refetching.
+func (a *App) broadcastProfileUpdated(ctx context.Context, profile userProfile) {
+	rows, err := a.db.QueryContext(ctx, `SELECT room_id FROM room_members WHERE user_id = $1`, profile.UserID)
+	if err != nil {
+		logger.Warn("profile_broadcast_failed", "user_id", profile.UserID, "error", err)
+		return
+	}
+	defer rows.Close()
+	roomIDs := make([]int64, 0, 8)
+	for rows.Next() {
+		var roomID int64
+		if err := rows.Scan(&roomID); err != nil {
+			logger.Warn("profile_broadcast_failed", "user_id", profile.UserID, "error", err)
+			return
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+	for _, roomID := range roomIDs {
+		payload, err := json.Marshal(map[string]any{
+			"type":        "profile_updated",
+			"roomId":      roomID,
+			"userId":      profile.UserID,
+			"username":    profile.Username,
+			"displayName": profile.DisplayName,
+			"bio":         profile.Bio,
+		})
+		if err != nil {
+			continue
+		}
+		a.hub.Broadcast(roomID, payload)
+	}
+}
+
+func stringOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// markUserLastSeen records a closed connection as the user's last activity.
+// REST activity is recorded by touchDevice.
+func (a *App) markUserLastSeen(userID int64) {
+	if a.db == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := a.db.ExecContext(ctx, `UPDATE users SET last_seen_at = NOW() WHERE id = $1`, userID); err != nil {
+		logger.Warn("mark_last_seen_failed", "user_id", userID, "error", err)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeProfileText(t *testing.T) {
+	t.Parallel()
+
+	if value, ok := normalizeProfileText("  Alice  ", maxDisplayNameLength, false); !ok || value != "Alice" {
+		t.Fatalf("expected trimmed display name, got %q %v", value, ok)
+	}
+	if _, ok := normalizeProfileText("Ali\nce", maxDisplayNameLength, false); ok {
+		t.Fatal("expected newline in display name to be rejected")
+	}
+	if value, ok := normalizeProfileText("line one\nline two", maxBioLength, true); !ok || value != "line one\nline two" {
+		t.Fatalf("expected newline in bio to be kept, got %q %v", value, ok)
+	}
+	if _, ok := normalizeProfileText("bell\a", maxBioLength, true); ok {
+		t.Fatal("expected control character to be rejected")
+	}
+	if _, ok := normalizeProfileText(strings.Repeat("é", maxDisplayNameLength+1), maxDisplayNameLength, false); ok {
+		t.Fatal("expected overlong display name to be rejected")
+	}
+}
+
+func TestHandleAccountProfileRejectsInvalidInput(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	for _, tc := range []struct {
+		method string
+		body   string
+		status int
+	}{
+		{http.MethodPost, `{}`, http.StatusMethodNotAllowed},
+		{http.MethodPatch, `{}`, http.StatusBadRequest},
+		{http.MethodPatch, `{"displayName":"` + strings.Repeat("a", maxDisplayNameLength+1) + `"}`, http.StatusBadRequest},
+		{http.MethodPatch, `{"bio":"tab\tbio"}`, http.StatusBadRequest},
+		{http.MethodPatch, `{"hideLastSeen":"yes"}`, http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, "/api/account/profile", strings.NewReader(tc.body))
+		app.handleAccountProfile(rec, req, AuthContext{UserID: 1, Username: "alice"})
+		if rec.Code != tc.status {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.body, tc.status, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultDailyMessageLimit = 5000
+	defaultStorageQuotaMB    = 1024
+
+	quotaCodeDailyMessages = "daily_message_limit"
+	quotaCodeStorage       = "storage_quota_exceeded"
+)
+
+// messageQuotaError reports which quota a message would exceed. The
+// transaction that charged it is rolled back, so usage is left unchanged.
+type messageQuotaError struct {
+	Code  string
+	Limit int64
+}
+
+func (e *messageQuotaError) Error() string {
+	switch e.Code {
+	case quotaCodeDailyMessages:
+		return fmt.Sprintf("daily message limit of %d reached", e.Limit)
+	default:
+		return fmt.Sprintf("stored message quota of %d bytes reached", e.Limit)
+	}
+}
+
+type messageQuota struct {
+	DailyMessages int64
+	StorageBytes  int64
+}
+
+func (a *App) defaultMessageQuota() messageQuota {
+	quota := messageQuota{DailyMessages: int64(a.dailyMessageLimit), StorageBytes: a.storageQuotaBytes}
+	if quota.DailyMessages <= 0 {
+		quota.DailyMessages = defaultDailyMessageLimit
+	}
+	if quota.StorageBytes <= 0 {
+		quota.StorageBytes = defaultStorageQuotaMB << 20
+	}
+	return quota
+}
+
+// loadMessageQuota returns the user's effective limits: per-user overrides
+// where an admin set them, the configured defaults otherwise.
+func (a *App) loadMessageQuota(ctx context.Context, q sqlQueryer, userID int64) (messageQuota, bool, bool, error) {
+	quota := a.defaultMessageQuota()
+	var daily, storage sql.NullInt64
+	err := q.QueryRowContext(ctx,
+		`SELECT daily_message_limit, storage_quota_bytes FROM user_quotas WHERE user_id = $1`,
+		userID,
+	).Scan(&daily, &storage)
+	if errors.Is(err, sql.ErrNoRows) {
+		return quota, false, false, nil
+	}
+	if err != nil {
+		return messageQuota{}, false, false, err
+	}
+	if daily.Valid {
+		quota.DailyMessages = daily.Int64
+	}
+	if storage.Valid {
+		quota.StorageBytes = storage.Int64
+	}
+	return quota, daily.Valid, storage.Valid, nil
+}
+
+// checkMessageQuota compares usage after charging a message against quota.
+func checkMessageQuota(quota messageQuota, messagesToday, storedBytes int64) error {
+	if messagesToday > quota.DailyMessages {
+		return &messageQuotaError{Code: quotaCodeDailyMessages, Limit: quota.DailyMessages}
+	}
+	if storedBytes > quota.StorageBytes {
+		return &messageQuotaError{Code: quotaCodeStorage, Limit: quota.StorageBytes}
+	}
+	return nil
+}
+
+// chargeMessageQuotaTx counts messages totalling payloadBytes against the
+// sender's quotas. The usage row is locked for the rest of the transaction,
+// so concurrent sends by the same user are charged one at a time. Days are
+// counted in UTC.
+func (a *App) chargeMessageQuotaTx(ctx context.Context, tx *sql.Tx, userID int64, messages, payloadBytes int) error {
+	quota, _, _, err := a.loadMessageQuota(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	var messagesToday, storedBytes int64
+	if err := tx.QueryRowContext(ctx, `
+INSERT INTO user_quota_usage(user_id, usage_day, messages_today, stored_bytes)
+VALUES ($1, (NOW() AT TIME ZONE 'UTC')::date, $3, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET messages_today = CASE
+        WHEN user_quota_usage.usage_day = EXCLUDED.usage_day THEN user_quota_usage.messages_today + EXCLUDED.messages_today
+        ELSE EXCLUDED.messages_today
+    END,
+    usage_day = EXCLUDED.usage_day,
+    stored_bytes = user_quota_usage.stored_bytes + EXCLUDED.stored_bytes
+RETURNING messages_today, stored_bytes
+`, userID, payloadBytes, messages).Scan(&messagesToday, &storedBytes); err != nil {
+		return err
+	}
+	if messages == 0 {
+		// Edits are charged bytes only and never hit the daily limit.
+		messagesToday = 0
+	}
+	return checkMessageQuota(quota, messagesToday, storedBytes)
+}
+
+// messageStoredBytesExpr is what a message m holds against its sender's
+// storage quota: the current payload and the revisions its edits kept.
+const messageStoredBytesExpr = `octet_length(m.payload::text) + COALESCE(
+    (SELECT SUM(octet_length(r.payload::text)) FROM message_revisions r WHERE r.message_id = m.id), 0)`
+
+// releaseMessageQuotaTx gives the stored bytes of the unrevoked messages
+// matching filter back to their senders. Callers run it before revoking or
+// deleting those messages; revoked ones were released already.
+func releaseMessageQuotaTx(ctx context.Context, exec sqlExecer, filter string, args ...any) error {
+	_, err := exec.ExecContext(ctx, `
+UPDATE user_quota_usage
+SET stored_bytes = CASE WHEN stored_bytes > released.bytes THEN stored_bytes - released.bytes ELSE 0 END
+FROM (
+    SELECT m.sender_id, SUM(`+messageStoredBytesExpr+`) AS bytes
+    FROM messages m
+    WHERE m.revoked_at IS NULL AND `+filter+`
+    GROUP BY m.sender_id
+) AS released
+WHERE user_quota_usage.user_id = released.sender_id
+`, args...)
+	return err
+}
+
+// respondMessageQuotaError writes a 429 for quota errors and reports whether
+// err was one.
+func respondMessageQuotaError(w http.ResponseWriter, err error) bool {
+	var quotaErr *messageQuotaError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	respondErrorDetails(w, http.StatusTooManyRequests, quotaErr.Code, quotaErr.Error(), map[string]any{
+		"limit": quotaErr.Limit,
+	})
+	return true
+}
+
+type userQuotaResponse struct {
+	UserID               int64 `json:"userId"`
+	DailyMessageLimit    int64 `json:"dailyMessageLimit"`
+	StorageQuotaBytes    int64 `json:"storageQuotaBytes"`
+	DailyMessageOverride bool  `json:"dailyMessageOverride"`
+	StorageQuotaOverride bool  `json:"storageQuotaOverride"`
+	MessagesToday        int64 `json:"messagesToday"`
+	StoredBytes          int64 `json:"storedBytes"`
+}
+
+func (a *App) userExists(ctx context.Context, userID int64) (bool, error) {
+	var exists bool
+	err := a.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists)
+	return exists, err
+}
+
+func (a *App) loadUserQuota(ctx context.Context, userID int64) (userQuotaResponse, error) {
+	exists, err := a.userExists(ctx, userID)
+	if err != nil {
+		return userQuotaResponse{}, err
+	}
+	if !exists {
+		return userQuotaResponse{}, sql.ErrNoRows
+	}
+	quota, dailyOverride, storageOverride, err := a.loadMessageQuota(ctx, a.db, userID)
+	if err != nil {
+		return userQuotaResponse{}, err
+	}
+	resp := userQuotaResponse{
+		UserID:               userID,
+		DailyMessageLimit:    quota.DailyMessages,
+		StorageQuotaBytes:    quota.StorageBytes,
+		DailyMessageOverride: dailyOverride,
+		StorageQuotaOverride: storageOverride,
+	}
+	err = a.db.QueryRowContext(ctx, `
+SELECT CASE WHEN usage_day = (NOW() AT TIME ZONE 'UTC')::date THEN messages_today ELSE 0 END, stored_bytes
+FROM user_quota_usage
+WHERE user_id = $1
+`, userID).Scan(&resp.MessagesToday, &resp.StoredBytes)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return userQuotaResponse{}, err
+	}
+	return resp, nil
+}
+
+// parseQuotaOverride reads an optional override: absent leaves it unchanged,
+// null reverts to the configured default, a positive number sets it.
+func parseQuotaOverride(raw json.RawMessage, field string) (set bool, value *int64, err error) {
+	if len(raw) == 0 {
+		return false, nil, nil
+	}
+	if string(raw) == "null" {
+		return true, nil, nil
+	}
+	var parsed int64
+	if err := json.Unmarshal(raw, &parsed); err != nil || parsed <= 0 {
+		return false, nil, fmt.Errorf("%s must be a positive integer or null", field)
+	}
+	return true, &parsed, nil
+}
+
+// handleAdminUserQuota shows a user's quotas and usage, and lets an admin
+// override the limits or recount stored bytes from the messages table.
+func (a *App) handleAdminUserQuota(w http.ResponseWriter, r *http.Request, auth AuthContext, userID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var req struct {
+			DailyMessageLimit json.RawMessage `json:"dailyMessageLimit"`
+			StorageQuotaBytes json.RawMessage `json:"storageQuotaBytes"`
+			RecalculateUsage  bool            `json:"recalculateUsage"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		setDaily, daily, err := parseQuotaOverride(req.DailyMessageLimit, "dailyMessageLimit")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		setStorage, storage, err := parseQuotaOverride(req.StorageQuotaBytes, "storageQuotaBytes")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !setDaily && !setStorage && !req.RecalculateUsage {
+			respondError(w, http.StatusBadRequest, "no quota changes requested")
+			return
+		}
+		exists, err := a.userExists(ctx, userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to load user")
+			return
+		}
+		if !exists {
+			respondError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if err := a.updateUserQuota(ctx, auth.UserID, userID, setDaily, daily, setStorage, storage, req.RecalculateUsage); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update user quota")
+			return
+		}
+		requestLogger(r.Context()).Info("user_quota_updated",
+			"user_id", userID,
+			"admin_id", auth.UserID,
+			"recalculated", req.RecalculateUsage,
+		)
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	resp, err := a.loadUserQuota(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load user quota")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"quota": resp})
+}
+
+func (a *App) updateUserQuota(ctx context.Context, adminID, userID int64, setDaily bool, daily *int64, setStorage bool, storage *int64, recalculate bool) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if setDaily || setStorage {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO user_quotas(user_id, daily_message_limit, storage_quota_bytes, updated_by, updated_at)
+VALUES ($1, $3, $5, $6, NOW())
+ON CONFLICT (user_id) DO UPDATE
+SET daily_message_limit = CASE WHEN $2 THEN EXCLUDED.daily_message_limit ELSE user_quotas.daily_message_limit END,
+    storage_quota_bytes = CASE WHEN $4 THEN EXCLUDED.storage_quota_bytes ELSE user_quotas.storage_quota_bytes END,
+    updated_by = EXCLUDED.updated_by,
+    updated_at = EXCLUDED.updated_at
+`, userID, setDaily, daily, setStorage, storage, adminID); err != nil {
+			return err
+		}
+	}
+	if recalculate {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO user_quota_usage(user_id, usage_day, messages_today, stored_bytes)
+VALUES ($1, (NOW() AT TIME ZONE 'UTC')::date, 0,
+        (SELECT COALESCE(SUM(`+messageStoredBytesExpr+`), 0) FROM messages m WHERE m.sender_id = $1 AND m.revoked_at IS NULL))
+ON CONFLICT (user_id) DO UPDATE
+SET stored_bytes = EXCLUDED.stored_bytes
+`, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckMessageQuota(t *testing.T) {
+	t.Parallel()
+
+	quota := messageQuota{DailyMessages: 2, StorageBytes: 100}
+	if err := checkMessageQuota(quota, 2, 100); err != nil {
+		t.Fatalf("expected usage at the limit to pass, got %v", err)
+	}
+
+	var quotaErr *messageQuotaError
+	if err := checkMessageQuota(quota, 3, 10); !errors.As(err, &quotaErr) || quotaErr.Code != quotaCodeDailyMessages || quotaErr.Limit != 2 {
+		t.Fatalf("expected daily limit error, got %v", err)
+	}
+	if err := checkMessageQuota(quota, 1, 101); !errors.As(err, &quotaErr) || quotaErr.Code != quotaCodeStorage || quotaErr.Limit != 100 {
+		t.Fatalf("expected storage quota error, got %v", err)
+	}
+}
+
+func TestDefaultMessageQuota(t *testing.T) {
+	t.Parallel()
+
+	quota := (&App{}).defaultMessageQuota()
+	if quota.DailyMessages != defaultDailyMessageLimit || quota.StorageBytes != defaultStorageQuotaMB<<20 {
+		t.Fatalf("unexpected fallback quota: %+v", quota)
+	}
+	quota = (&App{dailyMessageLimit: 10, storageQuotaBytes: 2048}).defaultMessageQuota()
+	if quota.DailyMessages != 10 || quota.StorageBytes != 2048 {
+		t.Fatalf("unexpected configured quota: %+v", quota)
+	}
+}
+
+func TestParseQuotaOverride(t *testing.T) {
+	t.Parallel()
+
+	if set, _, err := parseQuotaOverride(nil, "dailyMessageLimit"); set || err != nil {
+		t.Fatalf("expected an absent field to leave the override unchanged")
+	}
+	if set, value, err := parseQuotaOverride(json.RawMessage(`null`), "dailyMessageLimit"); !set || value != nil || err != nil {
+		t.Fatalf("expected null to clear the override")
+	}
+	if set, value, err := parseQuotaOverride(json.RawMessage(`250`), "dailyMessageLimit"); !set || value == nil || *value != 250 || err != nil {
+		t.Fatalf("expected 250 to set the override")
+	}
+	for _, raw := range []string{`0`, `-1`, `"10"`, `1.5`} {
+		if _, _, err := parseQuotaOverride(json.RawMessage(raw), "dailyMessageLimit"); err == nil {
+			t.Fatalf("expected %s to be rejected", raw)
+		}
+	}
+}
+
+func TestRespondMessageQuotaError(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	if respondMessageQuotaError(rec, errors.New("boom")) {
+		t.Fatalf("expected non-quota errors to be left to the caller")
+	}
+	rec = httptest.NewRecorder()
+	if !respondMessageQuotaError(rec, &messageQuotaError{Code: quotaCodeStorage, Limit: 10}) {
+		t.Fatalf("expected quota error to be handled")
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if rec.Code != http.StatusTooManyRequests || body["code"] != quotaCodeStorage {
+		t.Fatalf("unexpected response: %d %v", rec.Code, body)
+	}
+}
+
+func loadStoredBytes(t *testing.T, db *sql.DB, userID int64) int64 {
+	t.Helper()
+	var storedBytes int64
+	if err := db.QueryRowContext(context.Background(),
+		`SELECT stored_bytes FROM user_quota_usage WHERE user_id = $1`, userID,
+	).Scan(&storedBytes); err != nil {
+		t.Fatalf("load usage: %v", err)
+	}
+	return storedBytes
+}
+
+func TestEditMessageChargesRevisionBytes(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	_, roomID := seedSQLiteTestRoom(t, db)
+	senderID := insertSQLiteTestMember(t, db, roomID, "alice", "user")
+	ctx := context.Background()
+	app := &App{db: db}
+	stamp, err := app.storeMessage(ctx, roomID, senderID, CipherPayload{Ciphertext: "YQ=="}, nil)
+	if err != nil {
+		t.Fatalf("store message: %v", err)
+	}
+	sent := loadStoredBytes(t, db, senderID)
+
+	edit := CipherPayload{Ciphertext: "YmJi"}
+	encoded, err := json.Marshal(edit)
+	if err != nil {
+		t.Fatalf("marshal edit: %v", err)
+	}
+	if _, _, _, err := app.editMessage(ctx, roomID, stamp.ID, senderID, edit, nil); err != nil {
+		t.Fatalf("edit message: %v", err)
+	}
+	if got := loadStoredBytes(t, db, senderID); got != sent+int64(len(encoded)) {
+		t.Fatalf("expected the edit to add %d bytes to %d, got %d", len(encoded), sent, got)
+	}
+
+	app.storageQuotaBytes = sent + int64(len(encoded))
+	_, _, _, err = app.editMessage(ctx, roomID, stamp.ID, senderID, edit, nil)
+	var quotaErr *messageQuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Code != quotaCodeStorage {
+		t.Fatalf("expected the edit to exceed the storage quota, got %v", err)
+	}
+}
+
+func TestRevokeAndErasureReleaseStoredBytes(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	adminID, roomID := seedSQLiteTestRoom(t, db)
+	senderID := insertSQLiteTestMember(t, db, roomID, "alice", "user")
+	ctx := context.Background()
+	app := &App{db: db}
+	first, err := app.storeMessage(ctx, roomID, senderID, CipherPayload{Ciphertext: "YQ=="}, nil)
+	if err != nil {
+		t.Fatalf("store message: %v", err)
+	}
+	if _, _, _, err := app.editMessage(ctx, roomID, first.ID, senderID, CipherPayload{Ciphertext: "YmJi"}, nil); err != nil {
+		t.Fatalf("edit message: %v", err)
+	}
+	withFirst := loadStoredBytes(t, db, senderID)
+	second, err := app.storeMessage(ctx, roomID, senderID, CipherPayload{Ciphertext: "Yw=="}, nil)
+	if err != nil {
+		t.Fatalf("store message: %v", err)
+	}
+	third, err := app.storeMessage(ctx, roomID, senderID, CipherPayload{Ciphertext: "ZA=="}, nil)
+	if err != nil {
+		t.Fatalf("store message: %v", err)
+	}
+	perMessage := (loadStoredBytes(t, db, senderID) - withFirst) / 2
+
+	if _, _, err := app.revokeMessage(ctx, roomID, first.ID, senderID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if got := loadStoredBytes(t, db, senderID); got != 2*perMessage {
+		t.Fatalf("expected the revoke to release the payload and its revision, got %d", got)
+	}
+	if _, _, _, err := app.moderatorRevokeMessage(ctx, roomID, second.ID, adminID); err != nil {
+		t.Fatalf("moderator revoke: %v", err)
+	}
+	if got := loadStoredBytes(t, db, senderID); got != perMessage {
+		t.Fatalf("expected the moderator revoke to release %d bytes, got %d", perMessage, got)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := eraseAccountTx(ctx, tx, senderID); err != nil {
+		t.Fatalf("erase account: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if got := loadStoredBytes(t, db, senderID); got != 0 {
+		t.Fatalf("expected erasure to release message %d, got %d bytes", third.ID, got)
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -60,14 +61,21 @@ func perMinuteLimit(tokens int) rate.Limit {
 }
 
 func (l *keyedRateLimiter) Allow(key string) bool {
+	allowed, _ := l.Check(key)
+	return allowed
+}
+
+// Check consumes a token for key when one is available; otherwise it reports
+// how long until the next token, for Retry-After.
+func (l *keyedRateLimiter) Check(key string) (bool, time.Duration) {
 	if l == nil {
-		return true
+		return true, 0
 	}
 	now := l.now()
 	return l.allowAt(key, now)
 }
 
-func (l *keyedRateLimiter) allowAt(key string, now time.Time) bool {
+func (l *keyedRateLimiter) allowAt(key string, now time.Time) (bool, time.Duration) {
 	normalized := strings.TrimSpace(key)
 	if normalized == "" {
 		normalized = "unknown"
@@ -88,7 +96,16 @@ func (l *keyedRateLimiter) allowAt(key string, now time.Time) bool {
 	}
 	entry.lastSeen = now
 
-	return entry.limiter.AllowN(now, 1)
+	if entry.limiter.AllowN(now, 1) {
+		return true, 0
+	}
+	if entry.limiter.Limit() <= 0 {
+		return false, time.Minute
+	}
+	reservation := entry.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	reservation.CancelAt(now)
+	return false, delay
 }
 
 func (l *keyedRateLimiter) cleanupLocked(now time.Time) {
@@ -152,10 +169,42 @@ func normalizeClientIPCandidate(raw string) string {
 }
 
 func respondRateLimited(w http.ResponseWriter, message string) {
+	respondRateLimitedAfter(w, message, time.Minute)
+}
+
+// respondRateLimitedAfter is the shared 429 body; Retry-After is rounded up to
+// whole seconds and never below one.
+func respondRateLimitedAfter(w http.ResponseWriter, message string, retryAfter time.Duration) {
 	trimmed := strings.TrimSpace(message)
 	if trimmed == "" {
 		trimmed = "too many requests"
 	}
-	w.Header().Set("Retry-After", "60")
-	respondJSON(w, http.StatusTooManyRequests, map[string]any{"error": trimmed})
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	respondErrorDetails(w, http.StatusTooManyRequests, "rate_limited", trimmed, map[string]any{
+		"retryAfterSeconds": seconds,
+	})
+}
+
+// SetLimit changes the rate for new and already-tracked keys without
+// resetting the tokens they have used.
+func (l *keyedRateLimiter) SetLimit(limit rate.Limit, burst int) {
+	if l == nil {
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.burst = burst
+	now := l.now()
+	for _, entry := range l.entries {
+		entry.limiter.SetLimitAt(now, limit)
+		entry.limiter.SetBurstAt(now, burst)
+	}
 }
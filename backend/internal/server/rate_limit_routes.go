@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Route rate limit policy names.
+const (
+	rateLimitPreKeyFetch   = "prekey_fetch"
+	rateLimitInviteJoin    = "invite_join"
+	rateLimitRoomCreate    = "room_create"
+	rateLimitSessionReset  = "session_reset"
+	rateLimitAccountExport = "account_export"
+)
+
+type routeRateLimit struct {
+	PerMinute int
+	Burst     int
+}
+
+// routeRateLimitPolicy limits one authenticated route per user. Method, when
+// set, restricts the policy to that method so reads on a shared path stay
+// unlimited.
+type routeRateLimitPolicy struct {
+	name      string
+	envPrefix string
+	method    string
+	message   string
+	defaults  routeRateLimit
+}
+
+var routeRateLimitPolicies = []routeRateLimitPolicy{
+	{
+		name:      rateLimitPreKeyFetch,
+		envPrefix: "RATE_LIMIT_PREKEY_FETCH",
+		message:   "too many prekey bundle requests",
+		defaults:  routeRateLimit{PerMinute: 120, Burst: 30},
+	},
+	{
+		name:      rateLimitInviteJoin,
+		envPrefix: "RATE_LIMIT_INVITE_JOIN",
+		method:    http.MethodPost,
+		message:   "too many invite join attempts",
+		defaults:  routeRateLimit{PerMinute: 20, Burst: 10},
+	},
+	{
+		name:      rateLimitRoomCreate,
+		envPrefix: "RATE_LIMIT_ROOM_CREATE",
+		method:    http.MethodPost,
+		message:   "too many rooms created",
+		defaults:  routeRateLimit{PerMinute: 10, Burst: 5},
+	},
+	{
+		name:      rateLimitSessionReset,
+		envPrefix: "RATE_LIMIT_SESSION_RESET",
+		method:    http.MethodPost,
+		message:   "too many session reset requests",
+		defaults:  routeRateLimit{PerMinute: 6, Burst: 3},
+	},
+	{
+		name:      rateLimitAccountExport,
+		envPrefix: "RATE_LIMIT_ACCOUNT_EXPORT",
+		method:    http.MethodGet,
+		message:   "too many account exports",
+		defaults:  routeRateLimit{PerMinute: 1, Burst: 2},
+	},
+}
+
+func findRouteRateLimitPolicy(name string) (routeRateLimitPolicy, bool) {
+	for _, policy := range routeRateLimitPolicies {
+		if policy.name == name {
+			return policy, true
+		}
+	}
+	return routeRateLimitPolicy{}, false
+}
+
+// loadRouteRateLimits reads <PREFIX>_PER_MINUTE and <PREFIX>_BURST for every
+// policy.
+func loadRouteRateLimits() (map[string]routeRateLimit, error) {
+	limits := make(map[string]routeRateLimit, len(routeRateLimitPolicies))
+	for _, policy := range routeRateLimitPolicies {
+		perMinute, err := readPositiveIntEnv(policy.envPrefix+"_PER_MINUTE", policy.defaults.PerMinute)
+		if err != nil {
+			return nil, err
+		}
+		burst, err := readPositiveIntEnv(policy.envPrefix+"_BURST", policy.defaults.Burst)
+		if err != nil {
+			return nil, err
+		}
+		limits[policy.name] = routeRateLimit{PerMinute: perMinute, Burst: burst}
+	}
+	return limits, nil
+}
+
+func newRouteRateLimiters(limits map[string]routeRateLimit) map[string]*keyedRateLimiter {
+	limiters := make(map[string]*keyedRateLimiter, len(limits))
+	for name, limit := range limits {
+		limiters[name] = newKeyedRateLimiter(perMinuteLimit(limit.PerMinute), limit.Burst, defaultRateLimitEntryTTL)
+	}
+	return limiters
+}
+
+// withRouteRateLimit applies the named policy to an authenticated handler,
+// keyed by user so clients behind one NAT do not share a budget.
+func (a *App) withRouteRateLimit(name string, next func(http.ResponseWriter, *http.Request, AuthContext)) func(http.ResponseWriter, *http.Request, AuthContext) {
+	policy, ok := findRouteRateLimitPolicy(name)
+	if !ok {
+		panic(fmt.Sprintf("unknown rate limit policy %q", name))
+	}
+	return func(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+		if policy.method == "" || r.Method == policy.method {
+			allowed, retryAfter := a.routeLimiters[policy.name].Check(strconv.FormatInt(auth.UserID, 10))
+			if !allowed {
+				respondRateLimitedAfter(w, policy.message, retryAfter)
+				return
+			}
+		}
+		next(w, r, auth)
+	}
+}
@@ -25,6 +25,29 @@ func TestKeyedRateLimiterAllowsAndBlocks(t *testing.T) {
 	}
 }
 
+func TestKeyedRateLimiterSetLimitAppliesToTrackedKeys(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newKeyedRateLimiter(1, 1, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	if !limiter.Allow("203.0.113.10") {
+		t.Fatalf("expected first request to pass")
+	}
+	limiter.SetLimit(1, 3)
+	now = now.Add(2 * time.Second)
+	for i := 0; i < 2; i++ {
+		if !limiter.Allow("203.0.113.10") {
+			t.Fatalf("expected raised burst to allow request %d", i+1)
+		}
+	}
+	if !limiter.Allow("198.51.100.7") || !limiter.Allow("198.51.100.7") {
+		t.Fatalf("expected new keys to use the raised burst")
+	}
+
+	var missing *keyedRateLimiter
+	missing.SetLimit(1, 1)
+}
+
 func TestKeyedRateLimiterCleanup(t *testing.T) {
 	now := time.Unix(0, 0)
 	limiter := newKeyedRateLimiter(10, 10, 2*time.Second)
@@ -80,7 +103,72 @@ func TestRespondRateLimited(t *testing.T) {
 		t.Fatalf("expected Retry-After header")
 	}
 	payload := decodeBodyMap(t, response)
-	if payload["error"] != "too many login attempts" {
+	details, _ := payload["details"].(map[string]any)
+	if payload["message"] != "too many login attempts" || details["retryAfterSeconds"] != float64(60) {
+		t.Fatalf("unexpected payload: %#v", payload)
+	}
+}
+
+func TestKeyedRateLimiterCheckReportsRetryAfter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newKeyedRateLimiter(perMinuteLimit(6), 1, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	if allowed, _ := limiter.Check("user"); !allowed {
+		t.Fatalf("expected first request to pass")
+	}
+	allowed, retryAfter := limiter.Check("user")
+	if allowed || retryAfter <= 9*time.Second || retryAfter > 10*time.Second {
+		t.Fatalf("expected ~10s retry, got allowed=%v retryAfter=%v", allowed, retryAfter)
+	}
+	now = now.Add(10 * time.Second)
+	if allowed, _ := limiter.Check("user"); !allowed {
+		t.Fatalf("expected request to pass once the reported delay elapsed")
+	}
+}
+
+func TestWithRouteRateLimit(t *testing.T) {
+	app := &App{routeLimiters: newRouteRateLimiters(map[string]routeRateLimit{
+		rateLimitRoomCreate: {PerMinute: 1, Burst: 1},
+	})}
+	handler := app.withRouteRateLimit(rateLimitRoomCreate, func(w http.ResponseWriter, _ *http.Request, _ AuthContext) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	serve := func(method string, userID int64) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler(response, httptest.NewRequest(method, "/api/rooms", nil), AuthContext{UserID: userID})
+		return response
+	}
+	if response := serve(http.MethodPost, 1); response.Code != http.StatusNoContent {
+		t.Fatalf("expected first create to pass, got %d", response.Code)
+	}
+	response := serve(http.MethodPost, 1)
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d", response.Code)
+	}
+	if payload := decodeBodyMap(t, response); payload["code"] != "rate_limited" {
 		t.Fatalf("unexpected payload: %#v", payload)
 	}
+	if response := serve(http.MethodGet, 1); response.Code != http.StatusNoContent {
+		t.Fatalf("expected listing rooms to stay unlimited, got %d", response.Code)
+	}
+	if response := serve(http.MethodPost, 2); response.Code != http.StatusNoContent {
+		t.Fatalf("expected other users to keep their own budget, got %d", response.Code)
+	}
+}
+
+func TestLoadRouteRateLimits(t *testing.T) {
+	t.Setenv("RATE_LIMIT_INVITE_JOIN_PER_MINUTE", "5")
+	limits, err := loadRouteRateLimits()
+	if err != nil {
+		t.Fatalf("loadRouteRateLimits: %v", err)
+	}
+	if limits[rateLimitInviteJoin].PerMinute != 5 || limits[rateLimitPreKeyFetch].PerMinute != 120 {
+		t.Fatalf("unexpected limits: %+v", limits)
+	}
+	t.Setenv("RATE_LIMIT_ROOM_CREATE_BURST", "0")
+	if _, err := loadRouteRateLimits(); err == nil {
+		t.Fatalf("expected zero burst to be rejected")
+	}
 }
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// customRoleNamePattern keeps role names URL-safe and distinct from the
+// built-in roles.
+var customRoleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{2,31}$`)
+
+func validCustomRoleName(name string) bool {
+	switch name {
+	case "admin", "user", "bot":
+		return false
+	}
+	return customRoleNamePattern.MatchString(name)
+}
+
+type customRoleResp struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Version     int      `json:"version"`
+	MemberCount int      `json:"memberCount"`
+	CreatedAt   string   `json:"createdAt"`
+	UpdatedAt   string   `json:"updatedAt"`
+}
+
+func (a *App) listCustomRoles(ctx context.Context) ([]customRoleResp, error) {
+	rows, err := a.db.QueryContext(ctx, `
+SELECT r.name,
+       array_to_json(r.permissions),
+       r.version,
+       (SELECT COUNT(*) FROM users u WHERE u.custom_role = r.name AND u.deleted_at IS NULL),
+       r.created_at,
+       r.updated_at
+FROM roles r
+ORDER BY r.name ASC
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]customRoleResp, 0, 8)
+	for rows.Next() {
+		var role customRoleResp
+		var permissions []byte
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&role.Name, &permissions, &role.Version, &role.MemberCount, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(permissions, &role.Permissions); err != nil {
+			return nil, err
+		}
+		role.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		role.UpdatedAt = updatedAt.UTC().Format(time.RFC3339Nano)
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// handleAdminRoles lists custom roles with the permissions they may hold
+// (GET) and defines new ones (POST).
+func (a *App) handleAdminRoles(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		roles, err := a.listCustomRoles(ctx)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to list roles")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"roles": roles, "permissions": grantablePermissions})
+
+	case http.MethodPost:
+		var req struct {
+			Name        string   `json:"name"`
+			Permissions []string `json:"permissions"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		req.Name = strings.ToLower(strings.TrimSpace(req.Name))
+		if !validCustomRoleName(req.Name) {
+			respondError(w, http.StatusBadRequest, "name must be 3-32 lowercase letters, digits, '-' or '_' and not a built-in role")
+			return
+		}
+		permissions, ok := normalizePermissions(req.Permissions)
+		if !ok {
+			respondError(w, http.StatusBadRequest, "unknown or non-grantable permission")
+			return
+		}
+
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create role")
+			return
+		}
+		defer tx.Rollback()
+		result, err := tx.ExecContext(ctx,
+			`INSERT INTO roles(name, permissions) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING`,
+			req.Name, permissions,
+		)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create role")
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			respondErrorCode(w, http.StatusConflict, "role_exists", "role already exists")
+			return
+		}
+		if err := recordAdminAudit(ctx, tx, auth.UserID, auditRoleCreated, 0, map[string]any{"role": req.Name, "permissions": permissions}); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create role")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create role")
+			return
+		}
+		requestLogger(r.Context()).Warn("role_created", "role", req.Name, "admin_user_id", auth.UserID)
+		respondJSON(w, http.StatusCreated, map[string]any{"name": req.Name, "permissions": permissions, "version": 1})
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAdminRoleSubroutes serves /api/admin/roles/{name}. PUT replaces the
+// permissions and bumps the version, which signs out every holder until
+// their next token refresh; DELETE removes the role from its holders.
+func (a *App) handleAdminRoleSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "roles" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	name := parts[3]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Permissions []string `json:"permissions"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json body")
+			return
+		}
+		permissions, ok := normalizePermissions(req.Permissions)
+		if !ok {
+			respondError(w, http.StatusBadRequest, "unknown or non-grantable permission")
+			return
+		}
+
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update role")
+			return
+		}
+		defer tx.Rollback()
+		var version int
+		err = tx.QueryRowContext(ctx, `
+UPDATE roles
+SET permissions = $2, version = version + 1, updated_at = NOW()
+WHERE name = $1
+RETURNING version
+`, name, permissions).Scan(&version)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "role not found")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to update role")
+			return
+		}
+		if err := recordAdminAudit(ctx, tx, auth.UserID, auditRoleUpdated, 0, map[string]any{"role": name, "permissions": permissions, "version": version}); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update role")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to update role")
+			return
+		}
+		requestLogger(r.Context()).Warn("role_updated", "role", name, "version", version, "admin_user_id", auth.UserID)
+		respondJSON(w, http.StatusOK, map[string]any{"name": name, "permissions": permissions, "version": version})
+
+	case http.MethodDelete:
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to delete role")
+			return
+		}
+		defer tx.Rollback()
+		result, err := tx.ExecContext(ctx, `DELETE FROM roles WHERE name = $1`, name)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to delete role")
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			respondError(w, http.StatusNotFound, "role not found")
+			return
+		}
+		if err := recordAdminAudit(ctx, tx, auth.UserID, auditRoleDeleted, 0, map[string]any{"role": name}); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to delete role")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to delete role")
+			return
+		}
+		requestLogger(r.Context()).Warn("role_deleted", "role", name, "admin_user_id", auth.UserID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAdminUserRole serves PUT /api/admin/users/{id}/role. An empty role
+// removes the user's custom role. Only regular user accounts can hold one.
+func (a *App) handleAdminUserRole(w http.ResponseWriter, r *http.Request, auth AuthContext, userID int64) {
+	if r.Method != http.MethodPut {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	req.Role = strings.TrimSpace(req.Role)
+	var customRole any
+	if req.Role != "" {
+		customRole = req.Role
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to assign role")
+		return
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRowContext(ctx,
+		`SELECT role FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		userID,
+	).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to assign role")
+		return
+	}
+	if role != "user" {
+		respondError(w, http.StatusBadRequest, "custom roles can only be assigned to user accounts")
+		return
+	}
+	if customRole != nil {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)`, customRole).Scan(&exists); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to assign role")
+			return
+		}
+		if !exists {
+			respondError(w, http.StatusNotFound, "role not found")
+			return
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET custom_role = $2 WHERE id = $1`, userID, customRole); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to assign role")
+		return
+	}
+	if err := recordAdminAudit(ctx, tx, auth.UserID, auditRoleAssigned, userID, map[string]any{"role": req.Role}); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to assign role")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to assign role")
+		return
+	}
+	requestLogger(r.Context()).Warn("role_assigned", "user_id", userID, "role", req.Role, "admin_user_id", auth.UserID)
+	respondJSON(w, http.StatusOK, map[string]any{"userId": userID, "role": req.Role})
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	maxRoomContentTypes      = 32
+	maxRoomContentTypeLength = 64
+)
+
+// normalizeAllowedContentTypes validates a room's content type allowlist.
+// Entries are exact contentType values such as "text/plain", or a
+// "<type>/*" wildcard covering every subtype.
+func normalizeAllowedContentTypes(values []string) ([]string, error) {
+	if len(values) == 0 {
+		return nil, errors.New("allowedContentTypes must not be empty; use null to allow every content type")
+	}
+	seen := make(map[string]struct{}, len(values))
+	normalized := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if !validContentTypePattern(value) {
+			return nil, fmt.Errorf("invalid content type %q", value)
+		}
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		normalized = append(normalized, value)
+	}
+	if len(normalized) > maxRoomContentTypes {
+		return nil, fmt.Errorf("at most %d content types are allowed", maxRoomContentTypes)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func validContentTypePattern(value string) bool {
+	if value == "" || len(value) > maxRoomContentTypeLength {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(value, "/*"); ok {
+		value = prefix
+		if value == "" || strings.Contains(value, "/") {
+			return false
+		}
+	}
+	for _, ch := range value {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9':
+		case ch == '/', ch == '.', ch == '+', ch == '-', ch == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// decideRoomContentType checks a payload's declared contentType against the
+// room's allowlist. A nil allowlist leaves the room unrestricted; a payload
+// without a contentType never matches a restricted room.
+func decideRoomContentType(allowed []string, contentType string) roomAccessDecision {
+	if allowed == nil {
+		return roomAccessDecision{Allowed: true}
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if contentType != "" {
+		for _, pattern := range allowed {
+			if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+				if strings.HasPrefix(contentType, prefix+"/") {
+					return roomAccessDecision{Allowed: true}
+				}
+				continue
+			}
+			if pattern == contentType {
+				return roomAccessDecision{Allowed: true}
+			}
+		}
+	}
+	return roomAccessDecision{
+		Allowed: false,
+		Code:    "content_type_not_allowed",
+		Error:   "content type is not allowed in this room",
+	}
+}
+
+// scanAllowedContentTypes decodes array_to_json(allowed_content_types)::TEXT.
+func scanAllowedContentTypes(raw sql.NullString) ([]string, error) {
+	if !raw.Valid {
+		return nil, nil
+	}
+	allowed := []string{}
+	if err := json.Unmarshal([]byte(raw.String), &allowed); err != nil {
+		return nil, err
+	}
+	return allowed, nil
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// storeAnnouncedKeys keeps a device's latest verified key announce for the
+// room, so members who connect later still learn its keys while it is
+// offline.
+func (a *App) storeAnnouncedKeys(ctx context.Context, roomID, userID int64, deviceID string, publicKey, signingKey json.RawMessage, signature string) error {
+	_, err := a.db.ExecContext(ctx, `
+INSERT INTO room_device_keys(room_id, user_id, device_id, public_key_jwk, signing_public_key_jwk, signature, announced_at)
+VALUES ($1, $2, $3, $4, $5, $6, NOW())
+ON CONFLICT (room_id, user_id, device_id) DO UPDATE
+SET public_key_jwk = EXCLUDED.public_key_jwk,
+    signing_public_key_jwk = EXCLUDED.signing_public_key_jwk,
+    signature = EXCLUDED.signature,
+    announced_at = EXCLUDED.announced_at
+`, roomID, userID, deviceID, []byte(publicKey), []byte(signingKey), signature)
+	return err
+}
+
+// loadAnnouncedKeys returns the stored announces of the room's current
+// members. Devices that were revoked or whose owner left are skipped even
+// though their rows linger until the device or room is deleted.
+func (a *App) loadAnnouncedKeys(ctx context.Context, roomID int64) ([]PeerSnapshot, error) {
+	rows, err := a.db.QueryContext(ctx, `
+SELECT k.user_id, u.username, COALESCE(u.display_name, ''), k.device_id, d.device_name,
+       k.public_key_jwk::TEXT, k.signing_public_key_jwk::TEXT, k.signature
+FROM room_device_keys k
+JOIN room_members rm ON rm.room_id = k.room_id AND rm.user_id = k.user_id
+JOIN users u ON u.id = k.user_id
+JOIN user_devices d ON d.user_id = k.user_id AND d.device_id = k.device_id
+WHERE k.room_id = $1 AND d.revoked_at IS NULL
+ORDER BY k.user_id, k.device_id
+`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var peers []PeerSnapshot
+	for rows.Next() {
+		var peer PeerSnapshot
+		var publicKey, signingKey string
+		if err := rows.Scan(&peer.UserID, &peer.Username, &peer.DisplayName, &peer.DeviceID, &peer.DeviceName, &publicKey, &signingKey, &peer.Signature); err != nil {
+			return nil, err
+		}
+		peer.PublicKeyJWK = json.RawMessage(publicKey)
+		peer.SigningPublicKeyJWK = json.RawMessage(signingKey)
+		peers = append(peers, peer)
+	}
+	return peers, rows.Err()
+}
+
+// mergeStoredPeers adds stored announces for devices that are not connected
+// to the live peer list the hub returned on join. The joining device itself
+// is left out, as it is from the live list.
+func mergeStoredPeers(live, stored []PeerSnapshot, self *Client, presence func(int64) string) []PeerSnapshot {
+	type deviceKey struct {
+		userID   int64
+		deviceID string
+	}
+	seen := make(map[deviceKey]struct{}, len(live)+1)
+	seen[deviceKey{self.userID, self.deviceID}] = struct{}{}
+	for _, peer := range live {
+		seen[deviceKey{peer.UserID, peer.DeviceID}] = struct{}{}
+	}
+	merged := live
+	for _, peer := range stored {
+		key := deviceKey{peer.UserID, peer.DeviceID}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		peer.Presence = presence(peer.UserID)
+		merged = append(merged, peer)
+	}
+	return merged
+}
+
+// replayStoredAnnounce restores the joining device's last announce onto its
+// connection and re-broadcasts it as peer_key, so members do not wait for the
+// client to announce again after a reconnect.
+func (a *App) replayStoredAnnounce(client *Client, stored []PeerSnapshot) {
+	for _, peer := range stored {
+		if peer.UserID != client.userID || peer.DeviceID != client.deviceID {
+			continue
+		}
+		client.setAnnouncedKeys(peer.PublicKeyJWK, peer.SigningPublicKeyJWK, peer.Signature)
+		if payload, err := peerKeyFrame(client, peer.PublicKeyJWK, peer.SigningPublicKeyJWK, peer.Signature); err == nil {
+			a.hub.Broadcast(client.roomID, payload)
+		}
+		return
+	}
+}
+
+func peerKeyFrame(c *Client, publicKey, signingKey json.RawMessage, signature string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":                "peer_key",
+		"roomId":              c.roomID,
+		"userId":              c.userID,
+		"username":            c.username,
+		"displayName":         c.getDisplayName(),
+		"deviceId":            c.deviceID,
+		"deviceName":          c.deviceName,
+		"publicKeyJwk":        publicKey,
+		"signingPublicKeyJwk": signingKey,
+		"signature":           signature,
+	})
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMergeStoredPeersAddsOfflineDevices(t *testing.T) {
+	t.Parallel()
+
+	self := &Client{roomID: 1, userID: 1, deviceID: "device-a"}
+	live := []PeerSnapshot{
+		{UserID: 2, DeviceID: "device-b", PublicKeyJWK: json.RawMessage(`{"k":"live"}`), Online: true},
+	}
+	stored := []PeerSnapshot{
+		{UserID: 1, DeviceID: "device-a", PublicKeyJWK: json.RawMessage(`{"k":"self"}`)},
+		{UserID: 2, DeviceID: "device-b", PublicKeyJWK: json.RawMessage(`{"k":"stale"}`)},
+		{UserID: 2, DeviceID: "device-c", PublicKeyJWK: json.RawMessage(`{"k":"offline"}`), Signature: "sig-c"},
+	}
+
+	merged := mergeStoredPeers(live, stored, self, func(int64) string { return presenceOffline })
+	if len(merged) != 2 {
+		t.Fatalf("expected live peer plus one stored peer, got %+v", merged)
+	}
+	if string(merged[0].PublicKeyJWK) != `{"k":"live"}` {
+		t.Fatalf("expected live keys to win over stored ones, got %s", merged[0].PublicKeyJWK)
+	}
+	offline := merged[1]
+	if offline.DeviceID != "device-c" || offline.Online || offline.Signature != "sig-c" || offline.Presence != presenceOffline {
+		t.Fatalf("unexpected stored peer: %+v", offline)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	roomVisibilityPrivate   = "private"
+	roomVisibilityDirectory = "directory"
+
+	defaultDirectoryPageSize = 50
+	maxDirectoryPageSize     = 200
+)
+
+func validRoomVisibility(value string) bool {
+	return value == roomVisibilityPrivate || value == roomVisibilityDirectory
+}
+
+type directoryRoom struct {
+	ID               int64  `json:"id"`
+	Name             string `json:"name"`
+	CreatedAt        string `json:"createdAt"`
+	MemberCount      int    `json:"memberCount"`
+	AnnouncementOnly bool   `json:"announcementOnly"`
+	Joined           bool   `json:"joined"`
+	// IsJoinable is false once the caller belongs to the room or it is full.
+	IsJoinable bool `json:"isJoinable"`
+}
+
+// handleDirectoryRooms lists rooms whose owners chose to publish them. System
+// rooms are never listed. Pages are ordered by room id; pass the last id as
+// afterId for the next page.
+func (a *App) handleDirectoryRooms(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	limit := int64(defaultDirectoryPageSize)
+	if value := strings.TrimSpace(query.Get("limit")); value != "" {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed > 0 && parsed <= maxDirectoryPageSize {
+			limit = parsed
+		}
+	}
+	afterID := int64(0)
+	if value := strings.TrimSpace(query.Get("afterId")); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid afterId")
+			return
+		}
+		afterID = parsed
+	}
+	search := strings.TrimSpace(query.Get("q"))
+	if len(search) > 64 {
+		respondError(w, http.StatusBadRequest, "q must be at most 64 characters")
+		return
+	}
+	pattern := ""
+	if search != "" {
+		pattern = "%" + escapeLikePattern(search) + "%"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := a.readQuery(ctx, `
+SELECT r.id,
+       r.name,
+       r.created_at,
+       r.announcement_only,
+       (SELECT COUNT(*) FROM room_members rm WHERE rm.room_id = r.id),
+       EXISTS(SELECT 1 FROM room_members rm WHERE rm.room_id = r.id AND rm.user_id = $1)
+FROM rooms r
+WHERE r.visibility = 'directory'
+  AND NOT COALESCE(r.is_system, FALSE)
+  AND r.id > $2
+  AND ($3 = '' OR r.name ILIKE $3 ESCAPE '\')
+ORDER BY r.id ASC
+LIMIT $4
+`, auth.UserID, afterID, pattern, limit+1)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to fetch directory")
+		return
+	}
+	defer rows.Close()
+
+	maxMembers := a.roomLimits.withDefaults().MaxRoomMembers
+	rooms := make([]directoryRoom, 0, limit+1)
+	for rows.Next() {
+		var room directoryRoom
+		var createdAt time.Time
+		if err := rows.Scan(&room.ID, &room.Name, &createdAt, &room.AnnouncementOnly, &room.MemberCount, &room.Joined); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode directory")
+			return
+		}
+		room.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		room.IsJoinable = !room.Joined && room.MemberCount < maxMembers
+		rooms = append(rooms, room)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to fetch directory")
+		return
+	}
+
+	hasMore := len(rooms) > int(limit)
+	if hasMore {
+		rooms = rooms[:int(limit)]
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"rooms":   rooms,
+		"hasMore": hasMore,
+	})
+}
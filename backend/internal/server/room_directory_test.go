@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidRoomVisibility(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{roomVisibilityPrivate, roomVisibilityDirectory} {
+		if !validRoomVisibility(value) {
+			t.Fatalf("expected %q to be valid", value)
+		}
+	}
+	for _, value := range []string{"", "public", "Directory"} {
+		if validRoomVisibility(value) {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestDirectoryRoomsRejectsBadRequests(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	cases := []struct {
+		method string
+		target string
+		want   int
+	}{
+		{method: http.MethodPost, target: "/api/directory/rooms", want: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, target: "/api/directory/rooms?afterId=abc", want: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/api/directory/rooms?afterId=-1", want: http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		app.handleDirectoryRooms(recorder, httptest.NewRequest(tc.method, tc.target, nil), AuthContext{UserID: 1})
+		if recorder.Code != tc.want {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.target, tc.want, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	minSupportedPayloadVersion = 3
+	// maxPinnedPayloadVersion leaves room for future protocol versions while
+	// stopping a typo from locking a room out of every client.
+	maxPinnedPayloadVersion = 16
+
+	protocolErrorUpgradeRequired = "upgrade_required"
+)
+
+// roomEncryptionPolicy pins the oldest payload format a room accepts. A nil
+// scheme list accepts every scheme the server supports.
+type roomEncryptionPolicy struct {
+	MinPayloadVersion        int      `json:"minPayloadVersion"`
+	AllowedEncryptionSchemes []string `json:"allowedEncryptionSchemes"`
+}
+
+func validPinnedPayloadVersion(version int) bool {
+	return version >= minSupportedPayloadVersion && version <= maxPinnedPayloadVersion
+}
+
+func normalizeEncryptionSchemes(values []string) ([]string, error) {
+	if len(values) == 0 {
+		return nil, errors.New("allowedEncryptionSchemes must not be empty; use null to allow every scheme")
+	}
+	seen := make(map[string]struct{}, len(values))
+	normalized := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if value != encryptionSchemeDoubleRatchet && value != encryptionSchemeSenderKey {
+			return nil, fmt.Errorf("unsupported encryption scheme %q", value)
+		}
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		normalized = append(normalized, value)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// decideRoomEncryption rejects payloads older than the room's pinned version
+// or using a scheme the room has dropped. Both map to upgrade_required so
+// clients know to refresh and renegotiate rather than retry.
+func decideRoomEncryption(policy roomEncryptionPolicy, payload CipherPayload) roomAccessDecision {
+	if payload.Version < policy.MinPayloadVersion {
+		return roomAccessDecision{
+			Allowed: false,
+			Code:    protocolErrorUpgradeRequired,
+			Error:   fmt.Sprintf("room requires payload version %d or later", policy.MinPayloadVersion),
+		}
+	}
+	if policy.AllowedEncryptionSchemes == nil {
+		return roomAccessDecision{Allowed: true}
+	}
+	scheme := strings.TrimSpace(payload.EncryptionScheme)
+	for _, allowed := range policy.AllowedEncryptionSchemes {
+		if allowed == scheme {
+			return roomAccessDecision{Allowed: true}
+		}
+	}
+	return roomAccessDecision{
+		Allowed: false,
+		Code:    protocolErrorUpgradeRequired,
+		Error:   "encryption scheme is not allowed in this room",
+	}
+}
+
+// scanRoomEncryptionPolicy decodes min_payload_version and
+// array_to_json(allowed_encryption_schemes)::TEXT.
+func scanRoomEncryptionPolicy(minVersion int, schemesRaw sql.NullString) (roomEncryptionPolicy, error) {
+	policy := roomEncryptionPolicy{MinPayloadVersion: minVersion}
+	if !schemesRaw.Valid {
+		return policy, nil
+	}
+	policy.AllowedEncryptionSchemes = []string{}
+	if err := json.Unmarshal([]byte(schemesRaw.String), &policy.AllowedEncryptionSchemes); err != nil {
+		return roomEncryptionPolicy{}, err
+	}
+	return policy, nil
+}
+
+// roomPayloadPolicy is everything a room checks about a message payload,
+// whoever sends it.
+type roomPayloadPolicy struct {
+	encryption   roomEncryptionPolicy
+	keyEpoch     roomKeyEpoch
+	contentTypes []string
+}
+
+// roomPayloadPolicyColumns are scanned by scanRoomPayloadPolicy, in order.
+const roomPayloadPolicyColumns = `min_payload_version, array_to_json(allowed_encryption_schemes)::TEXT,
+       key_epoch, key_epoch_changed_at, array_to_json(allowed_content_types)::TEXT`
+
+// scanRoomPayloadPolicy scans roomPayloadPolicyColumns after any leading
+// destinations the caller selected first.
+func scanRoomPayloadPolicy(row *sql.Row, leading ...any) (roomPayloadPolicy, error) {
+	var policy roomPayloadPolicy
+	var minPayloadVersion int
+	var schemesRaw, contentTypesRaw sql.NullString
+	dest := append(leading, &minPayloadVersion, &schemesRaw, &policy.keyEpoch.Epoch, &policy.keyEpoch.ChangedAt, &contentTypesRaw)
+	if err := row.Scan(dest...); err != nil {
+		return roomPayloadPolicy{}, err
+	}
+	var err error
+	if policy.encryption, err = scanRoomEncryptionPolicy(minPayloadVersion, schemesRaw); err != nil {
+		return roomPayloadPolicy{}, err
+	}
+	if policy.contentTypes, err = scanAllowedContentTypes(contentTypesRaw); err != nil {
+		return roomPayloadPolicy{}, err
+	}
+	return policy, nil
+}
+
+// decideRoomPayload applies the encryption policy, the key epoch and then the
+// content type allowlist.
+func (a *App) decideRoomPayload(policy roomPayloadPolicy, payload CipherPayload) roomAccessDecision {
+	if decision := decideRoomEncryption(policy.encryption, payload); !decision.Allowed {
+		return decision
+	}
+	if decision := decideRoomKeyEpoch(policy.keyEpoch, payload.KeyEpoch, a.roomKeyEpochGrace(), time.Now()); !decision.Allowed {
+		return decision
+	}
+	return decideRoomContentType(policy.contentTypes, payload.ContentType)
+}
+
+// loadRoomPayloadDecision is the payload gate for edits, which change a
+// message that already passed the announcement check when it was sent. New
+// messages go through loadRoomSendDecision.
+func (a *App) loadRoomPayloadDecision(ctx context.Context, roomID int64, payload CipherPayload) (roomAccessDecision, error) {
+	policy, err := scanRoomPayloadPolicy(a.db.QueryRowContext(ctx,
+		`SELECT `+roomPayloadPolicyColumns+` FROM rooms WHERE id = $1`,
+		roomID,
+	))
+	if err != nil {
+		return roomAccessDecision{}, err
+	}
+	return a.decideRoomPayload(policy, payload), nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestDecideRoomEncryption(t *testing.T) {
+	t.Parallel()
+
+	open := roomEncryptionPolicy{MinPayloadVersion: 3}
+	if decision := decideRoomEncryption(open, CipherPayload{Version: 3, EncryptionScheme: encryptionSchemeSenderKey}); !decision.Allowed {
+		t.Fatalf("expected default policy to accept v3, got %+v", decision)
+	}
+
+	pinned := roomEncryptionPolicy{MinPayloadVersion: 4, AllowedEncryptionSchemes: []string{encryptionSchemeDoubleRatchet}}
+	old := decideRoomEncryption(pinned, CipherPayload{Version: 3, EncryptionScheme: encryptionSchemeDoubleRatchet})
+	if old.Allowed || old.Code != protocolErrorUpgradeRequired {
+		t.Fatalf("expected old version to require upgrade, got %+v", old)
+	}
+	scheme := decideRoomEncryption(pinned, CipherPayload{Version: 4, EncryptionScheme: encryptionSchemeSenderKey})
+	if scheme.Allowed || scheme.Code != protocolErrorUpgradeRequired {
+		t.Fatalf("expected dropped scheme to require upgrade, got %+v", scheme)
+	}
+	if decision := decideRoomEncryption(pinned, CipherPayload{Version: 5, EncryptionScheme: encryptionSchemeDoubleRatchet}); !decision.Allowed {
+		t.Fatalf("expected newer version to pass, got %+v", decision)
+	}
+}
+
+func TestNormalizeEncryptionSchemes(t *testing.T) {
+	t.Parallel()
+
+	got, err := normalizeEncryptionSchemes([]string{" sender_key_v1 ", "DOUBLE_RATCHET_V1", "SENDER_KEY_V1"})
+	if err != nil {
+		t.Fatalf("normalize schemes: %v", err)
+	}
+	if len(got) != 2 || got[0] != encryptionSchemeDoubleRatchet || got[1] != encryptionSchemeSenderKey {
+		t.Fatalf("unexpected schemes: %v", got)
+	}
+	if _, err := normalizeEncryptionSchemes(nil); err == nil {
+		t.Fatal("expected empty list to be rejected")
+	}
+	if _, err := normalizeEncryptionSchemes([]string{"AES_CBC"}); err == nil {
+		t.Fatal("expected unknown scheme to be rejected")
+	}
+}
+
+func TestScanRoomEncryptionPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := scanRoomEncryptionPolicy(3, sql.NullString{})
+	if err != nil || policy.MinPayloadVersion != 3 || policy.AllowedEncryptionSchemes != nil {
+		t.Fatalf("unexpected open policy: %+v %v", policy, err)
+	}
+	policy, err = scanRoomEncryptionPolicy(4, sql.NullString{String: `["SENDER_KEY_V1"]`, Valid: true})
+	if err != nil || len(policy.AllowedEncryptionSchemes) != 1 {
+		t.Fatalf("unexpected pinned policy: %+v %v", policy, err)
+	}
+}
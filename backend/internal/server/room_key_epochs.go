@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultRoomKeyEpochGrace = 5 * time.Minute
+
+	protocolErrorStaleKeyEpoch = "stale_key_epoch"
+)
+
+// roomKeyEpoch counts the room's membership changes. The database bumps it
+// on every join and removal; a client that keys a message for an older
+// member set tags it with an older epoch.
+type roomKeyEpoch struct {
+	Epoch     int64
+	ChangedAt time.Time
+}
+
+func (a *App) roomKeyEpochGrace() time.Duration {
+	if a.keyEpochGrace <= 0 {
+		return defaultRoomKeyEpochGrace
+	}
+	return a.keyEpochGrace
+}
+
+// decideRoomKeyEpoch accepts payloads tagged with the current epoch, and
+// older epochs only until grace has passed since the last change, so
+// messages already in flight when someone joined or left still go through.
+// Untagged payloads come from clients that do not track epochs and are let
+// through unchecked.
+func decideRoomKeyEpoch(current roomKeyEpoch, payloadEpoch int64, grace time.Duration, now time.Time) roomAccessDecision {
+	switch {
+	case payloadEpoch == 0 || payloadEpoch == current.Epoch:
+		return roomAccessDecision{Allowed: true}
+	case payloadEpoch > current.Epoch:
+		return roomAccessDecision{
+			Allowed: false,
+			Code:    protocolErrorStaleKeyEpoch,
+			Error:   fmt.Sprintf("room key epoch %d does not exist yet; current epoch is %d", payloadEpoch, current.Epoch),
+		}
+	case now.Before(current.ChangedAt.Add(grace)):
+		return roomAccessDecision{Allowed: true}
+	default:
+		return roomAccessDecision{
+			Allowed: false,
+			Code:    protocolErrorStaleKeyEpoch,
+			Error:   fmt.Sprintf("room membership changed; rekey for epoch %d and resend", current.Epoch),
+		}
+	}
+}
+
+func (a *App) loadRoomKeyEpoch(ctx context.Context, roomID int64) (roomKeyEpoch, error) {
+	var epoch roomKeyEpoch
+	err := a.db.QueryRowContext(ctx,
+		`SELECT key_epoch, key_epoch_changed_at FROM rooms WHERE id = $1`,
+		roomID,
+	).Scan(&epoch.Epoch, &epoch.ChangedAt)
+	return epoch, err
+}
+
+func (a *App) roomKeyEpochFrame(roomID int64, epoch roomKeyEpoch) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":              "room_key_epoch",
+		"roomId":            roomID,
+		"keyEpoch":          epoch.Epoch,
+		"keyEpochChangedAt": epoch.ChangedAt.UTC().Format(time.RFC3339Nano),
+		"graceSeconds":      int64(a.roomKeyEpochGrace() / time.Second),
+	})
+}
+
+// announceRoomKeyEpoch tells the room's connected devices that membership
+// changed, so they rekey before the grace period runs out.
+func (a *App) announceRoomKeyEpoch(ctx context.Context, roomID int64) {
+	epoch, err := a.loadRoomKeyEpoch(ctx, roomID)
+	if err != nil {
+		logger.Warn("load_room_key_epoch_failed", "room_id", roomID, "error", err)
+		return
+	}
+	if payload, err := a.roomKeyEpochFrame(roomID, epoch); err == nil {
+		a.hub.Broadcast(roomID, payload)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecideRoomKeyEpoch(t *testing.T) {
+	t.Parallel()
+
+	changedAt := time.Unix(1_700_000_000, 0)
+	current := roomKeyEpoch{Epoch: 4, ChangedAt: changedAt}
+	grace := time.Minute
+	inGrace := changedAt.Add(30 * time.Second)
+	afterGrace := changedAt.Add(2 * time.Minute)
+
+	for _, epoch := range []int64{0, 4} {
+		if decision := decideRoomKeyEpoch(current, epoch, grace, afterGrace); !decision.Allowed {
+			t.Fatalf("expected epoch %d to pass, got %+v", epoch, decision)
+		}
+	}
+	if decision := decideRoomKeyEpoch(current, 3, grace, inGrace); !decision.Allowed {
+		t.Fatalf("expected stale epoch inside grace to pass, got %+v", decision)
+	}
+	stale := decideRoomKeyEpoch(current, 3, grace, afterGrace)
+	if stale.Allowed || stale.Code != protocolErrorStaleKeyEpoch {
+		t.Fatalf("expected stale epoch after grace to be rejected, got %+v", stale)
+	}
+	future := decideRoomKeyEpoch(current, 5, grace, inGrace)
+	if future.Allowed || future.Code != protocolErrorStaleKeyEpoch {
+		t.Fatalf("expected future epoch to be rejected, got %+v", future)
+	}
+}
+
+func TestCanonicalSignaturePayloadSignsKeyEpoch(t *testing.T) {
+	privateKey, signingJWK := makeECDSAP256JWK(t)
+	payload := CipherPayload{
+		Version:    3,
+		Ciphertext: "ciphertext-value",
+		MessageIV:  "iv-value",
+		WrappedKeys: map[string]WrappedKey{
+			"7:device-b": {IV: "wrap-iv", WrappedKey: "wrap-key", MessageNumber: 1, SessionVersion: 1},
+		},
+		SenderPublicJWK:     signingJWK,
+		SenderSigningPubJWK: signingJWK,
+		EncryptionScheme:    encryptionSchemeDoubleRatchet,
+		KeyEpoch:            4,
+	}
+	canonical, err := canonicalSignaturePayload(payload)
+	if err != nil {
+		t.Fatalf("canonical signature payload: %v", err)
+	}
+	payload.Signature = signWithECDSA(t, privateKey, canonical)
+	if err := verifyCipherSignature(payload); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	retagged := payload
+	retagged.KeyEpoch = 5
+	if err := verifyCipherSignature(retagged); err == nil {
+		t.Fatal("expected a changed key epoch to break the signature")
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	joinRequestPending  = "pending"
+	joinRequestApproved = "approved"
+	joinRequestDenied   = "denied"
+)
+
+type joinRequestSnapshot struct {
+	ID        int64  `json:"id"`
+	RoomID    int64  `json:"roomId"`
+	UserID    int64  `json:"userId"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// decideKnock gates knock requests: only non-members of a knock-enabled,
+// non-system room may ask to be let in.
+func decideKnock(isSystem, knockEnabled, isMember bool) roomAccessDecision {
+	if isMember {
+		return roomAccessDecision{Allowed: false, Code: "already_member", Error: "already a room member"}
+	}
+	if isSystem || !knockEnabled {
+		return roomAccessDecision{Allowed: false, Code: "knock_disabled", Error: "room does not accept join requests"}
+	}
+	return roomAccessDecision{Allowed: true}
+}
+
+// handleRoomKnock records a pending join request and tells the room's
+// moderators about it. Knocking again while a request is pending returns the
+// existing request instead of creating a new one.
+func (a *App) handleRoomKnock(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var isSystem, knockEnabled, isMember bool
+	err := a.db.QueryRowContext(ctx, `
+SELECT COALESCE(r.is_system, FALSE),
+       r.knock_enabled,
+       EXISTS(SELECT 1 FROM room_members rm WHERE rm.room_id = r.id AND rm.user_id = $2)
+FROM rooms r
+WHERE r.id = $1
+`, roomID, auth.UserID).Scan(&isSystem, &knockEnabled, &isMember)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load room")
+		return
+	}
+	decision := decideKnock(isSystem, knockEnabled, isMember)
+	if !decision.Allowed {
+		status := http.StatusForbidden
+		if decision.Code == "already_member" {
+			status = http.StatusConflict
+		}
+		respondErrorCode(w, status, decision.Code, decision.Error)
+		return
+	}
+
+	request := joinRequestSnapshot{RoomID: roomID, UserID: auth.UserID, Username: auth.Username}
+	var createdAt time.Time
+	err = a.db.QueryRowContext(ctx, `
+INSERT INTO room_join_requests(room_id, user_id)
+VALUES ($1, $2)
+ON CONFLICT (room_id, user_id) WHERE status = 'pending' DO NOTHING
+RETURNING id, created_at
+`, roomID, auth.UserID).Scan(&request.ID, &createdAt)
+	created := err == nil
+	if errors.Is(err, sql.ErrNoRows) {
+		err = a.db.QueryRowContext(ctx,
+			`SELECT id, created_at FROM room_join_requests WHERE room_id = $1 AND user_id = $2 AND status = 'pending'`,
+			roomID, auth.UserID,
+		).Scan(&request.ID, &createdAt)
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to create join request")
+		return
+	}
+	request.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+
+	if created {
+		if err := a.notifyRoomModerators(ctx, roomID, map[string]any{
+			"type":    "join_request",
+			"roomId":  roomID,
+			"request": request,
+		}); err != nil {
+			requestLogger(r.Context()).Warn("join_request_notify_failed", "room_id", roomID, "error", err)
+		}
+		requestLogger(r.Context()).Info("join_request_created",
+			"room_id", roomID,
+			"user_id", auth.UserID,
+			"request_id", request.ID,
+		)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"request": request, "status": joinRequestPending})
+}
+
+// notifyRoomModerators sends frame to the room creator and to any admin who
+// belongs to the room, the same people decideMessageModeration lets act.
+func (a *App) notifyRoomModerators(ctx context.Context, roomID int64, frame map[string]any) error {
+	rows, err := a.db.QueryContext(ctx, `
+SELECT u.id
+FROM users u
+WHERE u.deleted_at IS NULL
+  AND (
+    u.id = (SELECT created_by FROM rooms WHERE id = $1)
+    OR (u.role = 'admin' AND EXISTS(SELECT 1 FROM room_members rm WHERE rm.room_id = $1 AND rm.user_id = u.id))
+  )
+`, roomID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var moderatorIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		moderatorIDs = append(moderatorIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	for _, id := range moderatorIDs {
+		a.hub.SendToUser(id, payload)
+	}
+	return nil
+}
+
+// handleRoomJoinRequests lists pending requests for the room's moderators.
+func (a *App) handleRoomJoinRequests(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if !a.requireJoinRequestModerator(ctx, w, auth, roomID) {
+		return
+	}
+	rows, err := a.db.QueryContext(ctx, `
+SELECT jr.id, jr.user_id, u.username, jr.created_at
+FROM room_join_requests jr
+JOIN users u ON u.id = jr.user_id
+WHERE jr.room_id = $1 AND jr.status = 'pending'
+ORDER BY jr.created_at ASC, jr.id ASC
+`, roomID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to fetch join requests")
+		return
+	}
+	defer rows.Close()
+
+	requests := []joinRequestSnapshot{}
+	for rows.Next() {
+		request := joinRequestSnapshot{RoomID: roomID}
+		var createdAt time.Time
+		if err := rows.Scan(&request.ID, &request.UserID, &request.Username, &createdAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode join requests")
+			return
+		}
+		request.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		requests = append(requests, request)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"roomId": roomID, "requests": requests})
+}
+
+// handleRoomJoinRequestDecision approves or denies one pending request.
+// Approval goes through addRoomMember, so the member limit and webhooks apply
+// exactly as for an invite join.
+func (a *App) handleRoomJoinRequestDecision(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64, parts []string) {
+	if len(parts) != 2 || (parts[1] != "approve" && parts[1] != "deny") {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	requestID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || requestID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid request id")
+		return
+	}
+	status := joinRequestDenied
+	if parts[1] == "approve" {
+		status = joinRequestApproved
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if !a.requireJoinRequestModerator(ctx, w, auth, roomID) {
+		return
+	}
+
+	var userID int64
+	var username string
+	err = a.db.QueryRowContext(ctx, `
+UPDATE room_join_requests
+SET status = $3, decided_by = $4, decided_at = NOW()
+WHERE id = $1 AND room_id = $2 AND status = 'pending'
+RETURNING user_id, (SELECT username FROM users WHERE users.id = room_join_requests.user_id)
+`, requestID, roomID, status, auth.UserID).Scan(&userID, &username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "join request not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to update join request")
+		return
+	}
+
+	if status == joinRequestApproved {
+		if err := a.addRoomMember(ctx, roomID, userID); err != nil {
+			// Put the request back so it can be approved once there is room.
+			if _, resetErr := a.db.ExecContext(ctx,
+				`UPDATE room_join_requests SET status = 'pending', decided_by = NULL, decided_at = NULL WHERE id = $1`,
+				requestID,
+			); resetErr != nil {
+				requestLogger(r.Context()).Warn("join_request_reset_failed", "request_id", requestID, "error", resetErr)
+			}
+			if errors.Is(err, errRoomFull) {
+				respondErrorCode(w, http.StatusConflict, "room_full", "room has reached its member limit")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "failed to join room")
+			return
+		}
+		if payload, err := json.Marshal(map[string]any{
+			"type":     "member_joined",
+			"roomId":   roomID,
+			"userId":   userID,
+			"username": username,
+		}); err == nil {
+			a.hub.Broadcast(roomID, payload)
+		}
+	}
+	if payload, err := json.Marshal(map[string]any{
+		"type":      "join_request_decided",
+		"roomId":    roomID,
+		"requestId": requestID,
+		"status":    status,
+	}); err == nil {
+		a.hub.SendToUser(userID, payload)
+	}
+
+	requestLogger(r.Context()).Info("join_request_decided",
+		"room_id", roomID,
+		"request_id", requestID,
+		"user_id", userID,
+		"status", status,
+		"actor_id", auth.UserID,
+	)
+	respondJSON(w, http.StatusOK, map[string]any{
+		"roomId":    roomID,
+		"requestId": requestID,
+		"userId":    userID,
+		"status":    status,
+	})
+}
+
+func (a *App) requireJoinRequestModerator(ctx context.Context, w http.ResponseWriter, auth AuthContext, roomID int64) bool {
+	decision, err := a.loadMessageModerationDecision(ctx, auth.UserID, auth.Role, roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "room not found")
+			return false
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load room")
+		return false
+	}
+	if !decision.Allowed {
+		respondErrorCode(w, http.StatusForbidden, decision.Code, "only room creator or admin can manage join requests")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecideKnock(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name                             string
+		isSystem, knockEnabled, isMember bool
+		wantAllowed                      bool
+		wantCode                         string
+	}{
+		{name: "enabled", knockEnabled: true, wantAllowed: true},
+		{name: "disabled", wantCode: "knock_disabled"},
+		{name: "system room", isSystem: true, knockEnabled: true, wantCode: "knock_disabled"},
+		{name: "member", knockEnabled: true, isMember: true, wantCode: "already_member"},
+	}
+	for _, tc := range cases {
+		decision := decideKnock(tc.isSystem, tc.knockEnabled, tc.isMember)
+		if decision.Allowed != tc.wantAllowed || decision.Code != tc.wantCode {
+			t.Fatalf("%s: got %+v", tc.name, decision)
+		}
+	}
+}
+
+func TestRoomJoinRequestDecisionRejectsBadRoutes(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	cases := []struct {
+		method string
+		parts  []string
+		want   int
+	}{
+		{method: http.MethodPost, parts: []string{"5"}, want: http.StatusNotFound},
+		{method: http.MethodPost, parts: []string{"5", "ignore"}, want: http.StatusNotFound},
+		{method: http.MethodGet, parts: []string{"5", "approve"}, want: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, parts: []string{"abc", "deny"}, want: http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, "/api/rooms/1/join-requests", nil)
+		app.handleRoomJoinRequestDecision(recorder, req, AuthContext{UserID: 1}, 1, tc.parts)
+		if recorder.Code != tc.want {
+			t.Fatalf("%s %v: expected %d, got %d", tc.method, tc.parts, tc.want, recorder.Code)
+		}
+	}
+}
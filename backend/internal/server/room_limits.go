@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	defaultMaxRoomMembers        = 500
+	defaultMaxWrappedKeys        = 1000
+	defaultMaxCipherPayloadBytes = 512 << 10
+
+	// wsFrameEnvelopeBytes is room for the frame fields around a cipher
+	// payload: type, message ID, mentions and the like.
+	wsFrameEnvelopeBytes = 16 << 10
+)
+
+var (
+	errRoomFull           = errors.New("room is full")
+	errTooManyWrappedKeys = errors.New("too many wrapped keys")
+	errPayloadTooLarge    = errors.New("payload too large")
+)
+
+// roomLimitsConfig bounds room fan-out: wrappedKeys grows with every member
+// device, so member count and payload size are capped together.
+type roomLimitsConfig struct {
+	MaxRoomMembers  int
+	MaxWrappedKeys  int
+	MaxPayloadBytes int
+}
+
+func (cfg roomLimitsConfig) withDefaults() roomLimitsConfig {
+	if cfg.MaxRoomMembers <= 0 {
+		cfg.MaxRoomMembers = defaultMaxRoomMembers
+	}
+	if cfg.MaxWrappedKeys <= 0 {
+		cfg.MaxWrappedKeys = defaultMaxWrappedKeys
+	}
+	if cfg.MaxPayloadBytes <= 0 {
+		cfg.MaxPayloadBytes = defaultMaxCipherPayloadBytes
+	}
+	return cfg
+}
+
+// maxFrameBytes is the largest decoded client frame that is dispatched.
+// Config validation keeps it within the transport read limit, so an oversized
+// message is refused with a protocol error and the connection stays open.
+func (cfg roomLimitsConfig) maxFrameBytes() int {
+	return cfg.withDefaults().MaxPayloadBytes + wsFrameEnvelopeBytes
+}
+
+func (cfg roomLimitsConfig) validateCipherPayload(payload CipherPayload) error {
+	limits := cfg.withDefaults()
+	if len(payload.WrappedKeys) > limits.MaxWrappedKeys {
+		return fmt.Errorf("%w: %d entries exceeds limit of %d", errTooManyWrappedKeys, len(payload.WrappedKeys), limits.MaxWrappedKeys)
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidPayloadFormat, err)
+	}
+	if len(encoded) > limits.MaxPayloadBytes {
+		return fmt.Errorf("%w: %d bytes exceeds limit of %d", errPayloadTooLarge, len(encoded), limits.MaxPayloadBytes)
+	}
+	return nil
+}
+
+// addRoomMember inserts the membership unless the room is at capacity. The
+// room row is locked so concurrent joins cannot overshoot the limit; joining
+// a room the user already belongs to always succeeds.
+func (a *App) addRoomMember(ctx context.Context, roomID, userID int64) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lockedID int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, roomID).Scan(&lockedID); err != nil {
+		return err
+	}
+	var alreadyMember bool
+	var memberCount int
+	if err := tx.QueryRowContext(ctx, `
+SELECT
+	EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2),
+	(SELECT COUNT(*) FROM room_members WHERE room_id = $1)
+`, roomID, userID).Scan(&alreadyMember, &memberCount); err != nil {
+		return err
+	}
+	if alreadyMember {
+		return nil
+	}
+	if memberCount >= a.roomLimits.withDefaults().MaxRoomMembers {
+		return errRoomFull
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO room_members(room_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		roomID, userID,
+	); err != nil {
+		return err
+	}
+	eventPayload, err := json.Marshal(map[string]any{"userId": userID})
+	if err != nil {
+		return err
+	}
+	if _, err := recordEvent(ctx, tx, roomID, 0, userID, eventMemberJoined, eventPayload); err != nil {
+		return err
+	}
+	if err := enqueueWebhookEvent(ctx, tx, roomID, webhookEventMemberJoined, map[string]any{
+		"userId": userID,
+	}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	a.membership.Invalidate(userID, roomID)
+	a.webhooks.Notify()
+	a.announceRoomKeyEpoch(ctx, roomID)
+	return nil
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3Service         = "s3"
+	s3DefaultRegion   = "us-east-1"
+	s3RequestTimeout  = 2 * time.Minute
+	s3MaxErrorBodyLen = 4 << 10
+)
+
+var errS3ObjectNotFound = errors.New("object not found")
+
+// s3Config addresses an S3-compatible bucket with path-style URLs, which MinIO,
+// Ceph and AWS all accept.
+type s3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+func (cfg s3Config) configured() bool {
+	return cfg.Bucket != ""
+}
+
+func validateS3Config(cfg s3Config) error {
+	if !cfg.configured() {
+		if cfg.Endpoint != "" || cfg.AccessKeyID != "" || cfg.SecretAccessKey != "" {
+			return errors.New("BACKUP_S3_BUCKET must be set when other BACKUP_S3_* settings are")
+		}
+		return nil
+	}
+	parsed, err := url.Parse(cfg.Endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("BACKUP_S3_ENDPOINT must be an http(s) URL")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return errors.New("BACKUP_S3_ACCESS_KEY_ID and BACKUP_S3_SECRET_ACCESS_KEY must be set")
+	}
+	return nil
+}
+
+type s3Client struct {
+	cfg    s3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+func newS3Client(cfg s3Config) *s3Client {
+	if cfg.Region == "" {
+		cfg.Region = s3DefaultRegion
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	return &s3Client{
+		cfg:    cfg,
+		client: &http.Client{Timeout: s3RequestTimeout},
+		now:    time.Now,
+	}
+}
+
+func (c *s3Client) PutObject(ctx context.Context, key string, body []byte, contentType string) error {
+	headers := map[string]string{"Content-Type": contentType}
+	response, err := c.do(ctx, http.MethodPut, key, nil, body, headers)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return s3ResponseError(response)
+}
+
+func (c *s3Client) GetObject(ctx context.Context, key string, maxBytes int64) ([]byte, error) {
+	response, err := c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, errS3ObjectNotFound
+	}
+	if err := s3ResponseError(response); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, fmt.Errorf("object %s exceeds %d bytes", key, maxBytes)
+	}
+	return body, nil
+}
+
+type s3Object struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// ListObjects returns up to 1000 keys under prefix; backups are few enough
+// that continuation tokens are not needed.
+func (c *s3Client) ListObjects(ctx context.Context, prefix string) ([]s3Object, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	response, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if err := s3ResponseError(response); err != nil {
+		return nil, err
+	}
+	var result struct {
+		Contents []struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		} `xml:"Contents"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(response.Body, 8<<20)).Decode(&result); err != nil {
+		return nil, err
+	}
+	objects := make([]s3Object, 0, len(result.Contents))
+	for _, item := range result.Contents {
+		objects = append(objects, s3Object{Key: item.Key, Size: item.Size, LastModified: item.LastModified})
+	}
+	return objects, nil
+}
+
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	canonicalURI := "/" + s3URIEncode(c.cfg.Bucket, false)
+	if key != "" {
+		canonicalURI += "/" + s3URIEncode(key, true)
+	}
+	target := c.cfg.Endpoint + canonicalURI
+	canonicalQuery := s3CanonicalQuery(query)
+	if canonicalQuery != "" {
+		target += "?" + canonicalQuery
+	}
+	request, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	c.sign(request, canonicalURI, canonicalQuery, body)
+	return c.client.Do(request)
+}
+
+// sign applies AWS Signature Version 4 over host, payload hash and date.
+func (c *s3Client) sign(request *http.Request, canonicalURI, canonicalQuery string, body []byte) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scopeDate := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		canonicalURI,
+		canonicalQuery,
+		"host:" + request.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := scopeDate + "/" + c.cfg.Region + "/" + s3Service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	signingKey := sigV4SigningKey(c.cfg.SecretAccessKey, scopeDate, c.cfg.Region, s3Service)
+	signature := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.cfg.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func sigV4SigningKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3URIEncode percent-encodes everything outside RFC 3986 unreserved
+// characters, leaving '/' intact for object keys.
+func s3URIEncode(value string, keepSlash bool) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			builder.WriteByte(ch)
+		case ch == '/' && keepSlash:
+			builder.WriteByte(ch)
+		default:
+			fmt.Fprintf(&builder, "%%%02X", ch)
+		}
+	}
+	return builder.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3URIEncode(key, false)+"="+s3URIEncode(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3ResponseError(response *http.Response) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(response.Body, s3MaxErrorBodyLen))
+	return fmt.Errorf("s3 request failed with status %d: %s", response.StatusCode, strings.TrimSpace(string(detail)))
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigV4SigningKey(t *testing.T) {
+	t.Parallel()
+
+	// Example from the AWS Signature Version 4 documentation.
+	key := sigV4SigningKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20150830", "us-east-1", "iam")
+	want := "c4afb1cc5771d871763a393e44b703571b55cc28424d1a5e86da6ed3c154a4b9"
+	if got := hex.EncodeToString(key); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestS3URIEncoding(t *testing.T) {
+	t.Parallel()
+
+	if got := s3URIEncode("backups/message backup+1.json", true); got != "backups/message%20backup%2B1.json" {
+		t.Fatalf("unexpected key encoding: %s", got)
+	}
+	if got := s3URIEncode("a/b", false); got != "a%2Fb" {
+		t.Fatalf("unexpected query encoding: %s", got)
+	}
+	query := url.Values{}
+	query.Set("prefix", "backups/")
+	query.Set("list-type", "2")
+	if got := s3CanonicalQuery(query); got != "list-type=2&prefix=backups%2F" {
+		t.Fatalf("unexpected canonical query: %s", got)
+	}
+}
+
+func TestValidateS3Config(t *testing.T) {
+	t.Parallel()
+
+	if err := validateS3Config(s3Config{}); err != nil {
+		t.Fatalf("expected empty config to be valid: %v", err)
+	}
+	if err := validateS3Config(s3Config{Endpoint: "https://s3.example.com"}); err == nil {
+		t.Fatalf("expected endpoint without bucket to fail")
+	}
+	if err := validateS3Config(s3Config{Endpoint: "s3.example.com", Bucket: "b", AccessKeyID: "a", SecretAccessKey: "s"}); err == nil {
+		t.Fatalf("expected endpoint without scheme to fail")
+	}
+	if err := validateS3Config(s3Config{Endpoint: "https://s3.example.com", Bucket: "b"}); err == nil {
+		t.Fatalf("expected missing credentials to fail")
+	}
+	if err := validateS3Config(s3Config{Endpoint: "https://s3.example.com", Bucket: "b", AccessKeyID: "a", SecretAccessKey: "s"}); err != nil {
+		t.Fatalf("expected full config to be valid: %v", err)
+	}
+}
+
+func TestS3ClientPutAndGet(t *testing.T) {
+	t.Parallel()
+
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=access/20240102/eu-west-1/s3/aws4_request") ||
+			r.Header.Get("X-Amz-Date") != "20240102T030405Z" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = body
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(body)
+		}
+	}))
+	defer server.Close()
+
+	client := newS3Client(s3Config{
+		Endpoint:        server.URL + "/",
+		Region:          "eu-west-1",
+		Bucket:          "chat",
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+	})
+	client.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	ctx := context.Background()
+	if err := client.PutObject(ctx, "backups/a.json.gz", []byte("snapshot"), "application/gzip"); err != nil {
+		t.Fatalf("put object: %v", err)
+	}
+	body, err := client.GetObject(ctx, "backups/a.json.gz", 1024)
+	if err != nil || string(body) != "snapshot" {
+		t.Fatalf("unexpected get result %q / %v", body, err)
+	}
+	if _, err := client.GetObject(ctx, "backups/a.json.gz", 4); err == nil {
+		t.Fatalf("expected size limit to be enforced")
+	}
+	if _, err := client.GetObject(ctx, "backups/missing", 1024); err != errS3ObjectNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// identityKeyChange describes a device re-uploading a different identity key.
+type identityKeyChange struct {
+	UserID              int64
+	Username            string
+	DeviceID            string
+	PreviousFingerprint string
+	Fingerprint         string
+	RoomIDs             []int64
+	ClearedVerifiers    []int64
+}
+
+// loadCurrentIdentityFingerprint locks the device's identity row for the rest
+// of the transaction and returns its fingerprint, or "" on first upload.
+func loadCurrentIdentityFingerprint(ctx context.Context, tx *sql.Tx, userID int64, deviceID string) (string, error) {
+	var identityKey json.RawMessage
+	err := tx.QueryRowContext(ctx, `
+SELECT identity_key_jwk
+FROM signal_device_identity_keys
+WHERE user_id = $1 AND device_id = $2
+FOR UPDATE
+`, userID, deviceID).Scan(&identityKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return keyFingerprint(identityKey)
+}
+
+// recordIdentityKeyChangeTx writes a safety_number_changed system event into
+// every room the user belongs to, so members syncing later still see it, and
+// drops verification marks on the old fingerprint.
+func recordIdentityKeyChangeTx(ctx context.Context, tx *sql.Tx, change *identityKeyChange) error {
+	rows, err := tx.QueryContext(ctx, `SELECT room_id FROM room_members WHERE user_id = $1 ORDER BY room_id ASC`, change.UserID)
+	if err != nil {
+		return err
+	}
+	roomIDs := make([]int64, 0, 8)
+	for rows.Next() {
+		var roomID int64
+		if err := rows.Scan(&roomID); err != nil {
+			rows.Close()
+			return err
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"userId":              change.UserID,
+		"deviceId":            change.DeviceID,
+		"previousFingerprint": change.PreviousFingerprint,
+		"fingerprint":         change.Fingerprint,
+	})
+	if err != nil {
+		return err
+	}
+	for _, roomID := range roomIDs {
+		if _, err := recordEvent(ctx, tx, roomID, 0, change.UserID, eventSafetyNumberChanged, payload); err != nil {
+			return err
+		}
+	}
+	change.RoomIDs = roomIDs
+
+	verifiers, err := clearIdentityVerificationsTx(ctx, tx, change.UserID, change.PreviousFingerprint)
+	if err != nil {
+		return err
+	}
+	change.ClearedVerifiers = verifiers
+	return nil
+}
+
+// broadcastIdentityKeyChange pushes the change to connected members of every
+// shared room once the transaction has committed.
+func (a *App) broadcastIdentityKeyChange(change identityKeyChange) {
+	changedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, roomID := range change.RoomIDs {
+		payload, err := json.Marshal(map[string]any{
+			"type":                "safety_number_changed",
+			"roomId":              roomID,
+			"userId":              change.UserID,
+			"username":            change.Username,
+			"deviceId":            change.DeviceID,
+			"previousFingerprint": change.PreviousFingerprint,
+			"fingerprint":         change.Fingerprint,
+			"changedAt":           changedAt,
+		})
+		if err != nil {
+			continue
+		}
+		a.hub.Broadcast(roomID, payload)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBroadcastIdentityKeyChangeReachesEverySharedRoom(t *testing.T) {
+	t.Parallel()
+
+	app := &App{hub: NewHub()}
+	first := &Client{roomID: 1, userID: 2, send: make(chan []byte, 1)}
+	second := &Client{roomID: 3, userID: 4, send: make(chan []byte, 1)}
+	unrelated := &Client{roomID: 5, userID: 6, send: make(chan []byte, 1)}
+	app.hub.AddClient(first)
+	app.hub.AddClient(second)
+	app.hub.AddClient(unrelated)
+
+	app.broadcastIdentityKeyChange(identityKeyChange{
+		UserID:              9,
+		Username:            "mallory",
+		DeviceID:            "device-a",
+		PreviousFingerprint: "old",
+		Fingerprint:         "new",
+		RoomIDs:             []int64{1, 3},
+	})
+
+	for _, client := range []*Client{first, second} {
+		var frame map[string]any
+		if err := json.Unmarshal(<-client.send, &frame); err != nil {
+			t.Fatalf("decode frame: %v", err)
+		}
+		if frame["type"] != "safety_number_changed" || frame["roomId"] != float64(client.roomID) || frame["fingerprint"] != "new" {
+			t.Fatalf("unexpected frame for room %d: %#v", client.roomID, frame)
+		}
+	}
+	select {
+	case <-unrelated.send:
+		t.Fatalf("rooms the user is not in should not be notified")
+	default:
+	}
+}
+
+func TestNotifyVerificationsClearedReachesFormerVerifiers(t *testing.T) {
+	t.Parallel()
+
+	app := &App{hub: NewHub()}
+	verifier := &Client{roomID: 1, userID: 2, send: make(chan []byte, 1)}
+	bystander := &Client{roomID: 1, userID: 3, send: make(chan []byte, 1)}
+	app.hub.AddClient(verifier)
+	app.hub.AddClient(bystander)
+
+	app.notifyVerificationsCleared(identityKeyChange{
+		UserID:              9,
+		Username:            "mallory",
+		PreviousFingerprint: "old",
+		Fingerprint:         "new",
+		ClearedVerifiers:    []int64{2},
+	})
+
+	var frame map[string]any
+	if err := json.Unmarshal(<-verifier.send, &frame); err != nil {
+		t.Fatalf("decode frame: %v", err)
+	}
+	if frame["type"] != "identity_verification_cleared" || frame["userId"] != float64(9) || frame["previousFingerprint"] != "old" {
+		t.Fatalf("unexpected frame: %#v", frame)
+	}
+	select {
+	case <-bystander.send:
+		t.Fatalf("users who never verified should not be notified")
+	default:
+	}
+}
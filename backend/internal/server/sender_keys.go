@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	encryptionSchemeDoubleRatchet = "DOUBLE_RATCHET_V1"
+	encryptionSchemeSenderKey     = "SENDER_KEY_V1"
+	maxSenderKeyIDLength          = 64
+)
+
+var (
+	errSenderKeyInvalid    = errors.New("invalid sender key distribution")
+	errSenderKeyUnknown    = errors.New("sender key has not been distributed")
+	errSenderKeyNotAllowed = errors.New("recipient cannot receive this sender key")
+	errSenderKeyOffline    = errors.New("recipient device is not connected")
+)
+
+// validSenderKeyID accepts the opaque identifiers clients mint for a sender
+// key chain. The server never sees the chain key itself.
+func validSenderKeyID(keyID string) bool {
+	if len(keyID) < 8 || len(keyID) > maxSenderKeyIDLength {
+		return false
+	}
+	for _, ch := range keyID {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '-', ch == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateSenderKeyPayload checks a SENDER_KEY_V1 message: the body is
+// encrypted once under the sender's chain key, so it carries a single key
+// reference instead of one wrapped key per recipient device.
+func validateSenderKeyPayload(payload CipherPayload) error {
+	if len(payload.WrappedKeys) != 0 {
+		return fmt.Errorf("%w: sender key payloads must not carry wrapped keys", errInvalidPayloadFormat)
+	}
+	if !validSenderKeyID(payload.SenderKeyID) {
+		return fmt.Errorf("%w: invalid sender key id", errInvalidPayloadFormat)
+	}
+	return nil
+}
+
+// loadSenderKeyID returns the key the device last distributed in the room, or
+// sql.ErrNoRows if it never distributed one.
+func (a *App) loadSenderKeyID(ctx context.Context, roomID, senderID int64, deviceID string) (string, error) {
+	var keyID string
+	err := a.db.QueryRowContext(ctx, `
+SELECT key_id
+FROM room_sender_keys
+WHERE room_id = $1 AND sender_id = $2 AND sender_device_id = $3
+`, roomID, senderID, deviceID).Scan(&keyID)
+	return keyID, err
+}
+
+// recordSenderKeyDistribution notes that a recipient device was handed the
+// sender's current key. Distributing a new key ID rotates the chain and
+// forgets who held the old one, so clients re-send it to every device.
+func (a *App) recordSenderKeyDistribution(ctx context.Context, roomID, senderID int64, senderDeviceID, keyID string, recipientID int64, recipientDeviceID string) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO room_sender_keys(room_id, sender_id, sender_device_id, key_id, created_at)
+VALUES ($1, $2, $3, $4, NOW())
+ON CONFLICT (room_id, sender_id, sender_device_id) DO UPDATE
+SET key_id = EXCLUDED.key_id,
+    created_at = CASE
+        WHEN room_sender_keys.key_id = EXCLUDED.key_id THEN room_sender_keys.created_at
+        ELSE EXCLUDED.created_at
+    END
+`, roomID, senderID, senderDeviceID, keyID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+DELETE FROM room_sender_key_recipients
+WHERE room_id = $1 AND sender_id = $2 AND sender_device_id = $3 AND key_id <> $4
+`, roomID, senderID, senderDeviceID, keyID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO room_sender_key_recipients(room_id, sender_id, sender_device_id, recipient_user_id, recipient_device_id, key_id, distributed_at)
+VALUES ($1, $2, $3, $4, $5, $6, NOW())
+ON CONFLICT (room_id, sender_id, sender_device_id, recipient_user_id, recipient_device_id) DO UPDATE
+SET key_id = EXCLUDED.key_id,
+    distributed_at = EXCLUDED.distributed_at
+`, roomID, senderID, senderDeviceID, recipientID, recipientDeviceID, keyID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// relaySenderKeyDistribution forwards a sender key to one recipient device.
+// The key travels inside a regular pairwise DOUBLE_RATCHET_V1 payload wrapped
+// for that device only; the server records who received which key ID.
+func (c *Client) relaySenderKeyDistribution(incoming WSIncoming) error {
+	toDeviceID := normalizeDeviceID(incoming.ToDeviceID)
+	if incoming.ToUserID <= 0 || toDeviceID == "" || !validSenderKeyID(incoming.SenderKeyID) {
+		return errSenderKeyInvalid
+	}
+	if incoming.ToUserID == c.userID && toDeviceID == c.deviceID {
+		return errSenderKeyInvalid
+	}
+	if incoming.Signature == "" || len(incoming.SenderSigningPubJWK) == 0 || !json.Valid(incoming.SenderSigningPubJWK) {
+		return errSenderKeyInvalid
+	}
+	announcedSigning := c.getSigningPublicKey()
+	if len(announcedSigning) == 0 || !jsonEqualCanonical(announcedSigning, incoming.SenderSigningPubJWK) {
+		return errSenderKeyInvalid
+	}
+	senderPub := incoming.SenderPublicJWK
+	if len(senderPub) == 0 {
+		senderPub = c.getPublicKey()
+	}
+	if len(senderPub) == 0 || !json.Valid(senderPub) {
+		return errSenderKeyInvalid
+	}
+
+	payload := CipherPayload{
+		Version:                   incoming.Version,
+		Ciphertext:                incoming.Ciphertext,
+		MessageIV:                 incoming.MessageIV,
+		WrappedKeys:               incoming.WrappedKeys,
+		SenderPublicJWK:           senderPub,
+		SenderSigningPubJWK:       incoming.SenderSigningPubJWK,
+		Signature:                 incoming.Signature,
+		ContentType:               incoming.ContentType,
+		SenderDeviceID:            c.deviceID,
+		EncryptionScheme:          incoming.EncryptionScheme,
+		SignatureCanonicalization: incoming.SignatureCanonicalization,
+		Nonce:                     incoming.Nonce,
+		SignedAt:                  incoming.SignedAt,
+		KeyEpoch:                  incoming.KeyEpoch,
+	}
+	if payload.EncryptionScheme != encryptionSchemeDoubleRatchet || len(payload.WrappedKeys) != 1 {
+		return errSenderKeyInvalid
+	}
+	if _, ok := payload.WrappedKeys[strconv.FormatInt(incoming.ToUserID, 10)+":"+toDeviceID]; !ok {
+		return errSenderKeyInvalid
+	}
+	if err := validateV3CipherPayload(payload); err != nil {
+		return err
+	}
+	if err := verifyCipherSignature(payload); err != nil {
+		return err
+	}
+	if c.app.hub.blocks.Between(c.userID, incoming.ToUserID) {
+		return errSenderKeyNotAllowed
+	}
+	// Only record distributions that are actually delivered; an offline
+	// device shows up as missing in the room's sender key state instead.
+	if !c.app.hub.DeviceConnected(c.roomID, incoming.ToUserID, toDeviceID) {
+		return errSenderKeyOffline
+	}
+
+	ctx, cancel := context.WithTimeout(c.context(), 5*time.Second)
+	defer cancel()
+	if err := c.app.ensureMembership(ctx, c.userID, c.roomID); err != nil {
+		return errSenderKeyNotAllowed
+	}
+	if err := c.app.ensureMembership(ctx, incoming.ToUserID, c.roomID); err != nil {
+		return errSenderKeyNotAllowed
+	}
+	if err := c.app.recordSenderKeyDistribution(ctx, c.roomID, c.userID, c.deviceID, incoming.SenderKeyID, incoming.ToUserID, toDeviceID); err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(map[string]any{
+		"type":         "sender_key_distribution",
+		"roomId":       c.roomID,
+		"fromUserId":   c.userID,
+		"fromUsername": c.username,
+		"fromDeviceId": c.deviceID,
+		"toUserId":     incoming.ToUserID,
+		"toDeviceId":   toDeviceID,
+		"senderKeyId":  incoming.SenderKeyID,
+		"payload":      payload,
+	})
+	if err != nil {
+		return err
+	}
+	c.app.hub.UnicastToDevice(c.roomID, incoming.ToUserID, toDeviceID, out)
+	return nil
+}
+
+// handleRoomSenderKeys reports the calling device's current sender key in the
+// room and which devices already hold it, so the client only distributes to
+// the devices that are missing it.
+func (a *App) handleRoomSenderKeys(w http.ResponseWriter, r *http.Request, auth AuthContext, roomID int64) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !a.features.Enabled(featureSenderKeys) {
+		respondFeatureDisabled(w, featureSenderKeys)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.ensureMembership(ctx, auth.UserID, roomID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "not a room member")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to validate room membership")
+		return
+	}
+
+	keyID, err := a.loadSenderKeyID(ctx, roomID, auth.UserID, auth.DeviceID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondJSON(w, http.StatusOK, map[string]any{
+				"roomId":      roomID,
+				"deviceId":    auth.DeviceID,
+				"senderKeyId": nil,
+				"recipients":  []any{},
+			})
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load sender key")
+		return
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT recipient_user_id, recipient_device_id, distributed_at
+FROM room_sender_key_recipients
+WHERE room_id = $1 AND sender_id = $2 AND sender_device_id = $3 AND key_id = $4
+ORDER BY recipient_user_id ASC, recipient_device_id ASC
+`, roomID, auth.UserID, auth.DeviceID, keyID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load sender key recipients")
+		return
+	}
+	defer rows.Close()
+
+	recipients := make([]map[string]any, 0, 16)
+	for rows.Next() {
+		var userID int64
+		var deviceID string
+		var distributedAt time.Time
+		if err := rows.Scan(&userID, &deviceID, &distributedAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode sender key recipients")
+			return
+		}
+		recipients = append(recipients, map[string]any{
+			"userId":        userID,
+			"deviceId":      deviceID,
+			"distributedAt": distributedAt.UTC().Format(time.RFC3339Nano),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to iterate sender key recipients")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"roomId":      roomID,
+		"deviceId":    auth.DeviceID,
+		"senderKeyId": keyID,
+		"recipients":  recipients,
+	})
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadSharedRoomID returns the lowest room ID both users belong to. Queued
+// frames hang off a room so they disappear with it.
+func (a *App) loadSharedRoomID(ctx context.Context, leftUserID, rightUserID int64) (int64, error) {
+	var roomID int64
+	err := a.db.QueryRowContext(ctx, `
+SELECT rm_left.room_id
+FROM room_members rm_left
+JOIN room_members rm_right ON rm_left.room_id = rm_right.room_id
+WHERE rm_left.user_id = $1 AND rm_right.user_id = $2
+ORDER BY rm_left.room_id ASC
+LIMIT 1
+`, leftUserID, rightUserID).Scan(&roomID)
+	return roomID, err
+}
+
+func (a *App) handleSignalSessionSubroutes(w http.ResponseWriter, r *http.Request, auth AuthContext) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 6 || parts[0] != "api" || parts[1] != "signal" || parts[2] != "sessions" || parts[5] != "reset" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	targetUserID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || targetUserID <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	targetDeviceID := normalizeDeviceID(parts[4])
+	if targetDeviceID == "" {
+		respondError(w, http.StatusBadRequest, "invalid device id")
+		return
+	}
+	a.handleSignalSessionReset(w, r, auth, targetUserID, targetDeviceID)
+}
+
+// handleSignalSessionReset tells a peer device that the caller lost its
+// ratchet state for their session. The peer's client drops its side and
+// re-initiates X3DH from a fresh prekey bundle. Offline devices get the
+// frame from the handshake queue when they next connect or sync.
+func (a *App) handleSignalSessionReset(w http.ResponseWriter, r *http.Request, auth AuthContext, targetUserID int64, targetDeviceID string) {
+	if targetUserID == auth.UserID && targetDeviceID == auth.DeviceID {
+		respondError(w, http.StatusBadRequest, "cannot reset a session with the current device")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if !a.rejectBotTarget(ctx, w, targetUserID) {
+		return
+	}
+	if a.hub.blocks.Between(auth.UserID, targetUserID) {
+		respondErrorCode(w, http.StatusForbidden, "blocked", "session reset is not allowed")
+		return
+	}
+	roomID, err := a.loadSharedRoomID(ctx, auth.UserID, targetUserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusForbidden, "target user is not in any shared room")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to validate room relationship")
+		return
+	}
+	if _, err := a.loadActiveDevice(ctx, targetUserID, targetDeviceID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondErrorCode(w, http.StatusNotFound, "device_not_found", "target device not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "failed to load target device")
+		return
+	}
+
+	requestedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	payload, err := json.Marshal(map[string]any{
+		"type":         "session_reset",
+		"roomId":       roomID,
+		"fromUserId":   auth.UserID,
+		"fromUsername": auth.Username,
+		"fromDeviceId": auth.DeviceID,
+		"toUserId":     targetUserID,
+		"toDeviceId":   targetDeviceID,
+		"requestedAt":  requestedAt,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to encode session reset")
+		return
+	}
+
+	delivered := a.hub.SendToDevice(targetUserID, targetDeviceID, payload) > 0
+	if !delivered {
+		if err := a.queueHandshake(ctx, roomID, auth.UserID, auth.DeviceID, targetUserID, targetDeviceID, payload); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to queue session reset")
+			return
+		}
+	}
+	requestLogger(r.Context()).Info("signal_session_reset_requested",
+		"target_user_id", targetUserID,
+		"target_device_id", targetDeviceID,
+		"delivered", delivered,
+	)
+	respondJSON(w, http.StatusAccepted, map[string]any{
+		"toUserId":    targetUserID,
+		"toDeviceId":  targetDeviceID,
+		"delivered":   delivered,
+		"requestedAt": requestedAt,
+	})
+}
@@ -16,6 +16,20 @@ import (
 	"strings"
 )
 
+// Signature canonicalizations a cipher payload may declare. Payloads that
+// declare none use the legacy form, which is encoding/json's map output and
+// only matches JavaScript for plain ASCII content.
+const (
+	signatureCanonicalizationLegacy = "legacy"
+	signatureCanonicalizationJCS    = "jcs"
+)
+
+// supportedSignatureCanonicalizations is advertised on GET /api/features,
+// preferred first, so clients can pick the newest form the server verifies.
+var supportedSignatureCanonicalizations = []string{signatureCanonicalizationJCS, signatureCanonicalizationLegacy}
+
+var errUnsupportedCanonicalization = errors.New("unsupported signature canonicalization")
+
 func verifyCipherSignature(payload CipherPayload) error {
 	canonical, err := canonicalSignaturePayload(payload)
 	if err != nil {
@@ -42,7 +56,7 @@ func verifyAckSignature(signingPublicJWK json.RawMessage, roomID, messageID, fro
 }
 
 func canonicalSignaturePayload(payload CipherPayload) ([]byte, error) {
-	if payload.Ciphertext == "" || payload.MessageIV == "" || len(payload.WrappedKeys) == 0 {
+	if payload.Ciphertext == "" || payload.MessageIV == "" || (len(payload.WrappedKeys) == 0 && payload.SenderKeyID == "") {
 		return nil, errors.New("incomplete ciphertext payload")
 	}
 	if len(payload.SenderPublicJWK) == 0 || !json.Valid(payload.SenderPublicJWK) {
@@ -136,7 +150,39 @@ func canonicalSignaturePayload(payload CipherPayload) ([]byte, error) {
 		"senderDeviceId":            payload.SenderDeviceID,
 		"encryptionScheme":          payload.EncryptionScheme,
 	}
-	return json.Marshal(doc)
+	// Poll option counts and sender key references are only signed when
+	// present, so every other payload keeps the canonical form existing
+	// clients produce.
+	if payload.PollOptionCount != 0 {
+		doc["pollOptionCount"] = payload.PollOptionCount
+	}
+	if payload.SenderKeyID != "" {
+		doc["senderKeyId"] = payload.SenderKeyID
+	}
+	if payload.Nonce != "" {
+		doc["nonce"] = payload.Nonce
+	}
+	if payload.SignedAt != 0 {
+		doc["signedAt"] = payload.SignedAt
+	}
+	if payload.KeyEpoch != 0 {
+		doc["keyEpoch"] = payload.KeyEpoch
+	}
+	switch payload.SignatureCanonicalization {
+	case "", signatureCanonicalizationLegacy:
+		return json.Marshal(doc)
+	case signatureCanonicalizationJCS:
+		// The choice is signed too, so a relay cannot downgrade a payload to
+		// the legacy form.
+		doc["signatureCanonicalization"] = payload.SignatureCanonicalization
+		encoded, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		return canonicalJSON(encoded)
+	default:
+		return nil, errUnsupportedCanonicalization
+	}
 }
 
 func canonicalAckPayload(roomID, messageID, fromUserID int64) ([]byte, error) {
@@ -152,6 +198,42 @@ func canonicalAckPayload(roomID, messageID, fromUserID int64) ([]byte, error) {
 	return json.Marshal(doc)
 }
 
+// canonicalKeyAnnouncePayload is what a device signs to prove it holds the
+// signing key it announces. Binding the account and device means a captured
+// announce cannot vouch for the keys under another identity.
+func canonicalKeyAnnouncePayload(userID int64, deviceID string, publicJWK, signingPublicJWK json.RawMessage) ([]byte, error) {
+	if userID <= 0 || deviceID == "" {
+		return nil, errors.New("invalid key announce identity")
+	}
+	publicKey, err := parseJWKMap(publicJWK)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	signingKey, err := parseJWKMap(signingPublicJWK)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing public key: %w", err)
+	}
+	encoded, err := json.Marshal(map[string]any{
+		"type":                "key_announce",
+		"userId":              userID,
+		"deviceId":            deviceID,
+		"publicKeyJwk":        publicKey,
+		"signingPublicKeyJwk": signingKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return canonicalJSON(encoded)
+}
+
+func verifyKeyAnnounceSignature(userID int64, deviceID string, publicJWK, signingPublicJWK json.RawMessage, signatureB64 string) error {
+	canonical, err := canonicalKeyAnnouncePayload(userID, deviceID, publicJWK, signingPublicJWK)
+	if err != nil {
+		return err
+	}
+	return verifyPayloadSignature(signingPublicJWK, canonical, signatureB64)
+}
+
 func parseJWKMap(raw json.RawMessage) (map[string]any, error) {
 	var parsed map[string]any
 	if err := json.Unmarshal(raw, &parsed); err != nil {
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+// The fuzz targets below run their seed corpus as part of `go test`; run
+// them for real with e.g.
+//
+//	go test -run '^$' -fuzz FuzzVerifyPayloadSignature ./internal/server/
+
+// fuzzSeedKeys returns deterministic signing keys so the seed corpus is
+// identical across runs.
+func fuzzSeedKeys(f *testing.F) (*ecdsa.PrivateKey, json.RawMessage, ed25519.PrivateKey, json.RawMessage) {
+	f.Helper()
+	curve := elliptic.P256()
+	scalar := sha256.Sum256([]byte("fuzz-seed-ecdsa"))
+	d := new(big.Int).SetBytes(scalar[:])
+	d.Mod(d, curve.Params().N)
+	ecdsaKey := &ecdsa.PrivateKey{D: d}
+	ecdsaKey.Curve = curve
+	ecdsaKey.X, ecdsaKey.Y = curve.ScalarBaseMult(d.Bytes())
+	ecdsaJWK, err := json.Marshal(map[string]any{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(ecdsaKey.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(ecdsaKey.Y.FillBytes(make([]byte, 32))),
+	})
+	if err != nil {
+		f.Fatalf("marshal ecdsa jwk: %v", err)
+	}
+
+	seed := sha256.Sum256([]byte("fuzz-seed-ed25519"))
+	edKey := ed25519.NewKeyFromSeed(seed[:])
+	edJWK, err := json.Marshal(map[string]any{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"x":   base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)),
+	})
+	if err != nil {
+		f.Fatalf("marshal ed25519 jwk: %v", err)
+	}
+	return ecdsaKey, ecdsaJWK, edKey, edJWK
+}
+
+func FuzzDecodeSignature(f *testing.F) {
+	for _, seed := range []string{"", " ", "AAAA", "AA", "AA==", "A", "====", "not base64!", " AQID\n", "-_-_"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		decoded, err := decodeSignature(input)
+		if err != nil {
+			return
+		}
+		// Whatever decodes must survive a round trip through the encoding
+		// clients are expected to use.
+		again, err := decodeSignature(base64.StdEncoding.EncodeToString(decoded))
+		if err != nil {
+			t.Fatalf("re-encoded signature did not decode: %v", err)
+		}
+		if !bytes.Equal(decoded, again) {
+			t.Fatalf("round trip changed signature bytes: %x != %x", decoded, again)
+		}
+	})
+}
+
+func FuzzECDSAPublicKeyFromJWK(f *testing.F) {
+	_, ecdsaJWK, _, edJWK := fuzzSeedKeys(f)
+	for _, seed := range []string{
+		string(ecdsaJWK),
+		string(edJWK),
+		`{}`,
+		`null`,
+		`[]`,
+		`{"kty":"EC","crv":"P-256","x":"","y":""}`,
+		`{"kty":"EC","crv":"P-256","x":"AA","y":"AA"}`,
+		`{"kty":"EC","crv":"P-384","x":"AA","y":"AA"}`,
+		`{"kty":"EC","crv":"P-256","x":"AAAA====","y":"%%%"}`,
+		`{"kty":1,"crv":true}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		key, err := ecdsaPublicKeyFromJWK(raw)
+		if err != nil {
+			return
+		}
+		if key.Curve != elliptic.P256() || !key.Curve.IsOnCurve(key.X, key.Y) {
+			t.Fatalf("accepted a point that is not on P-256: %s", raw)
+		}
+		reencoded, err := json.Marshal(map[string]any{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+		})
+		if err != nil {
+			t.Fatalf("marshal jwk: %v", err)
+		}
+		again, err := ecdsaPublicKeyFromJWK(reencoded)
+		if err != nil {
+			t.Fatalf("re-encoded jwk rejected: %v", err)
+		}
+		if again.X.Cmp(key.X) != 0 || again.Y.Cmp(key.Y) != 0 {
+			t.Fatalf("round trip changed the public key")
+		}
+	})
+}
+
+func FuzzVerifyPayloadSignature(f *testing.F) {
+	ecdsaKey, ecdsaJWK, edKey, edJWK := fuzzSeedKeys(f)
+	message := []byte(`{"ciphertext":"seed"}`)
+	hash := sha256.Sum256(message)
+	der, err := ecdsa.SignASN1(rand.Reader, ecdsaKey, hash[:])
+	if err != nil {
+		f.Fatalf("sign seed: %v", err)
+	}
+	f.Add([]byte(ecdsaJWK), message, base64.StdEncoding.EncodeToString(der))
+	f.Add([]byte(edJWK), message, base64.StdEncoding.EncodeToString(ed25519.Sign(edKey, message)))
+	f.Add([]byte(edJWK), message, base64.RawStdEncoding.EncodeToString(ed25519.Sign(edKey, message)))
+	f.Add([]byte(ecdsaJWK), message, base64.StdEncoding.EncodeToString(make([]byte, 64)))
+	f.Add([]byte(ecdsaJWK), message, "MAYCAQECAQE=")
+	f.Add([]byte(`{"kty":"OKP","crv":"Ed25519","x":""}`), message, "")
+	f.Add([]byte(`not json`), []byte{}, "AA==")
+
+	f.Fuzz(func(t *testing.T, jwk []byte, canonical []byte, signature string) {
+		if err := verifyPayloadSignature(jwk, canonical, signature); err != nil {
+			return
+		}
+		_, ecdsaErr := ecdsaPublicKeyFromJWK(jwk)
+		_, edErr := ed25519PublicKeyFromJWK(jwk)
+		if ecdsaErr != nil && edErr != nil {
+			t.Fatalf("signature accepted for a key neither parser understands: %s", jwk)
+		}
+		// A verified signature must not also cover a different message.
+		tampered := append(append([]byte(nil), canonical...), 0)
+		if err := verifyPayloadSignature(jwk, tampered, signature); err == nil {
+			t.Fatalf("signature also verified for a tampered message")
+		}
+	})
+}
+
+func FuzzCanonicalSignaturePayload(f *testing.F) {
+	_, ecdsaJWK, _, edJWK := fuzzSeedKeys(f)
+	oneTime := int64(4)
+	for _, payload := range []CipherPayload{
+		{
+			Version:    3,
+			Ciphertext: "ciphertext",
+			MessageIV:  "iv",
+			WrappedKeys: map[string]WrappedKey{
+				"7:device-b": {IV: "wrap-iv", WrappedKey: "wrap-key", MessageNumber: 1, SessionVersion: 1, RatchetDHPublicJWK: ecdsaJWK},
+				"9:device-c": {IV: "wrap-iv", WrappedKey: "wrap-key", PreKeyMessage: &PreKeyMessage{
+					IdentityKeyJWK:        ecdsaJWK,
+					IdentitySigningPubJWK: edJWK,
+					EphemeralKeyJWK:       ecdsaJWK,
+					SignedPreKeyID:        2,
+					OneTimePreKeyID:       &oneTime,
+				}},
+			},
+			SenderPublicJWK:     ecdsaJWK,
+			SenderSigningPubJWK: edJWK,
+			ContentType:         "text/plain",
+			SenderDeviceID:      "device-a",
+			EncryptionScheme:    encryptionSchemeDoubleRatchet,
+		},
+		{
+			Version:             3,
+			Ciphertext:          "ciphertext",
+			MessageIV:           "iv",
+			SenderKeyID:         "sk-1",
+			SenderPublicJWK:     ecdsaJWK,
+			SenderSigningPubJWK: ecdsaJWK,
+			PollOptionCount:     3,
+		},
+	} {
+		seed, err := json.Marshal(payload)
+		if err != nil {
+			f.Fatalf("marshal seed payload: %v", err)
+		}
+		f.Add(seed)
+	}
+	f.Add([]byte(`{"ciphertext":"c","messageIv":"i","senderKeyId":"k","senderPublicKeyJwk":{},"senderSigningPublicKeyJwk":[]}`))
+	f.Add([]byte(`{"ciphertext":"c","messageIv":"i","wrappedKeys":{"1":{"ratchetDhPublicKeyJwk":"x"}},"senderPublicKeyJwk":{"a":1},"senderSigningPublicKeyJwk":{"a":1}}`))
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		var payload CipherPayload
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return
+		}
+		canonical, err := canonicalSignaturePayload(payload)
+		if err != nil {
+			return
+		}
+		if !json.Valid(canonical) {
+			t.Fatalf("canonical payload is not valid JSON: %s", canonical)
+		}
+		// Signers and verifiers must derive byte-identical input regardless
+		// of map iteration order.
+		again, err := canonicalSignaturePayload(payload)
+		if err != nil {
+			t.Fatalf("second canonicalisation failed: %v", err)
+		}
+		if !bytes.Equal(canonical, again) {
+			t.Fatalf("canonical payload is not deterministic:\n%s\n%s", canonical, again)
+		}
+	})
+}
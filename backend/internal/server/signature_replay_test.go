@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignatureReplayGuardRejectsReusedNonce(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	guard := newSignatureReplayGuard(time.Minute)
+	guard.now = func() time.Time { return now }
+	signedAt := now.UnixMilli()
+
+	if err := guard.Check(1, "device-a", "nonce-0123456789abcdef", signedAt); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := guard.Check(1, "device-a", "nonce-0123456789abcdef", signedAt); !errors.Is(err, errSignatureReplayed) {
+		t.Fatalf("expected replay to be rejected, got %v", err)
+	}
+	// Nonces are scoped to the sending device.
+	if err := guard.Check(1, "device-b", "nonce-0123456789abcdef", signedAt); err != nil {
+		t.Fatalf("other device: %v", err)
+	}
+	if err := guard.Check(2, "device-a", "nonce-0123456789abcdef", signedAt); err != nil {
+		t.Fatalf("other user: %v", err)
+	}
+}
+
+func TestSignatureReplayGuardEnforcesSkew(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	guard := newSignatureReplayGuard(time.Minute)
+	guard.now = func() time.Time { return now }
+
+	for _, signedAt := range []time.Time{now.Add(-2 * time.Minute), now.Add(2 * time.Minute)} {
+		if err := guard.Check(1, "d", "nonce-0123456789abcdef", signedAt.UnixMilli()); !errors.Is(err, errSignatureStale) {
+			t.Fatalf("expected stale error for %v, got %v", signedAt, err)
+		}
+	}
+	if err := guard.Check(1, "d", "nonce-0123456789abcdef", now.Add(-30*time.Second).UnixMilli()); err != nil {
+		t.Fatalf("inside skew: %v", err)
+	}
+
+	// Once the timestamp leaves the window the entry is pruned, and the
+	// replay is refused by the skew check instead.
+	now = now.Add(2 * time.Minute)
+	if err := guard.Check(1, "d", "nonce-fedcba9876543210", now.UnixMilli()); err != nil {
+		t.Fatalf("fresh nonce: %v", err)
+	}
+	guard.mu.Lock()
+	entries := len(guard.seen)
+	guard.mu.Unlock()
+	if entries != 1 {
+		t.Fatalf("expected expired nonces to be pruned, have %d", entries)
+	}
+}
+
+func TestSignatureReplayGuardValidatesNonce(t *testing.T) {
+	t.Parallel()
+
+	guard := newSignatureReplayGuard(time.Minute)
+	nowMS := time.Now().UnixMilli()
+	if err := guard.Check(1, "d", "", nowMS); !errors.Is(err, errSignatureNonceMissing) {
+		t.Fatalf("expected missing nonce error, got %v", err)
+	}
+	if err := guard.Check(1, "d", "nonce-0123456789abcdef", 0); !errors.Is(err, errSignatureNonceMissing) {
+		t.Fatalf("expected missing timestamp error, got %v", err)
+	}
+	for _, nonce := range []string{"short", "nonce with spaces in it", string(make([]byte, maxSignatureNonceLength+1))} {
+		if err := guard.Check(1, "d", nonce, nowMS); !errors.Is(err, errSignatureNonceInvalid) {
+			t.Fatalf("expected %q to be rejected, got %v", nonce, err)
+		}
+	}
+}
+
+func TestCanonicalSignaturePayloadSignsNonce(t *testing.T) {
+	privateKey, signingJWK := makeECDSAP256JWK(t)
+	payload := CipherPayload{
+		Version:    3,
+		Ciphertext: "ciphertext-value",
+		MessageIV:  "iv-value",
+		WrappedKeys: map[string]WrappedKey{
+			"7:device-b": {IV: "wrap-iv", WrappedKey: "wrap-key", MessageNumber: 1, SessionVersion: 1},
+		},
+		SenderPublicJWK:     signingJWK,
+		SenderSigningPubJWK: signingJWK,
+		EncryptionScheme:    encryptionSchemeDoubleRatchet,
+		Nonce:               "nonce-0123456789abcdef",
+		SignedAt:            1_700_000_000_000,
+	}
+	canonical, err := canonicalSignaturePayload(payload)
+	if err != nil {
+		t.Fatalf("canonical signature payload: %v", err)
+	}
+	payload.Signature = signWithECDSA(t, privateKey, canonical)
+
+	renonced := payload
+	renonced.Nonce = "nonce-fedcba9876543210"
+	if err := verifyCipherSignature(renonced); err == nil {
+		t.Fatal("expected a swapped nonce to break the signature")
+	}
+	retimed := payload
+	retimed.SignedAt++
+	if err := verifyCipherSignature(retimed); err == nil {
+		t.Fatal("expected a changed timestamp to break the signature")
+	}
+}
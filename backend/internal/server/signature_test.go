@@ -273,3 +273,29 @@ func TestVerifyCipherSignatureWithDeviceAddressedRecipients(t *testing.T) {
 		t.Fatalf("expected verifyCipherSignature to fail when wrapped key is tampered")
 	}
 }
+
+func TestVerifyKeyAnnounceSignature(t *testing.T) {
+	privateKey, signingJWK := makeEd25519JWK(t)
+	publicJWK := json.RawMessage(`{"kty":"EC","crv":"P-256","x":"pub-x","y":"pub-y"}`)
+	canonical, err := canonicalKeyAnnouncePayload(4, "device-1", publicJWK, signingJWK)
+	if err != nil {
+		t.Fatalf("canonical key announce: %v", err)
+	}
+	signature := signWithEd25519(privateKey, canonical)
+
+	// Member order and whitespace in the announced JWKs do not matter.
+	reordered := json.RawMessage(`{ "y":"pub-y", "x":"pub-x", "crv":"P-256", "kty":"EC" }`)
+	if err := verifyKeyAnnounceSignature(4, "device-1", reordered, signingJWK, signature); err != nil {
+		t.Fatalf("verify key announce: %v", err)
+	}
+	if err := verifyKeyAnnounceSignature(5, "device-1", publicJWK, signingJWK, signature); err == nil {
+		t.Fatal("expected announce signed for another user to fail")
+	}
+	swapped := json.RawMessage(`{"kty":"EC","crv":"P-256","x":"other-x","y":"pub-y"}`)
+	if err := verifyKeyAnnounceSignature(4, "device-1", swapped, signingJWK, signature); err == nil {
+		t.Fatal("expected announce with a substituted public key to fail")
+	}
+	if err := verifyKeyAnnounceSignature(4, "device-1", publicJWK, signingJWK, ""); err == nil {
+		t.Fatal("expected unsigned announce to fail")
+	}
+}
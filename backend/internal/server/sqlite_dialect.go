@@ -0,0 +1,672 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The store's queries are written for PostgreSQL. translateSQLiteQuery
+// rewrites the constructs they rely on into SQLite equivalents so the same
+// statements run against either backend:
+//
+//   - NOW() and NOW() ± n * INTERVAL become UTC text timestamps, the form
+//     every TIMESTAMPTZ column holds on SQLite;
+//   - arrays are JSON text, so = ANY(x) reads json_each(x), unnest zips
+//     json_each tables and array_to_json is a no-op;
+//   - json_build_object, jsonb || and json_agg use the JSON1 functions;
+//   - casts, row locks and ILIKE are dropped or mapped, since SQLite is
+//     dynamically typed, serializes writers and matches LIKE without case.
+//
+// Anything it does not recognize is passed through untouched and fails at
+// prepare time with SQLite's own error, or, with sqliteStrictDialect set,
+// with one naming the construct postgresOnlySQL found.
+
+type sqlTokenKind int
+
+const (
+	sqlWord sqlTokenKind = iota
+	sqlString
+	sqlQuotedIdent
+	sqlParam
+	sqlNumber
+	sqlSymbol
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+var sqliteQueryCache sync.Map
+
+func translateSQLiteQuery(query string) string {
+	if cached, ok := sqliteQueryCache.Load(query); ok {
+		return cached.(string)
+	}
+	tokens := tokenizeSQL(query)
+	for _, pass := range sqlitePasses {
+		tokens = pass(tokens)
+	}
+	translated := joinSQLTokens(tokens)
+	sqliteQueryCache.Store(query, translated)
+	return translated
+}
+
+var sqlitePasses = []func([]sqlToken) []sqlToken{
+	rewriteSQLiteUTCDate,
+	rewriteSQLiteJSONBConcat,
+	stripSQLiteCasts,
+	rewriteSQLiteIntervals,
+	rewriteSQLiteNow,
+	rewriteSQLiteAny,
+	rewriteSQLiteFunctions,
+	rewriteSQLiteOperators,
+	stripSQLiteRowLocks,
+	rewriteSQLiteUnnest,
+	rewriteSQLiteInsertSelect,
+	rewriteSQLiteMessageSeq,
+}
+
+func tokenizeSQL(query string) []sqlToken {
+	var tokens []sqlToken
+	for i := 0; i < len(query); {
+		ch := query[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+		case ch == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case ch == '\'' || ch == '"':
+			j := i + 1
+			for j < len(query) {
+				if query[j] == ch {
+					if j+1 < len(query) && query[j+1] == ch {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			kind := sqlString
+			if ch == '"' {
+				kind = sqlQuotedIdent
+			}
+			end := min(j+1, len(query))
+			tokens = append(tokens, sqlToken{kind, query[i:end]})
+			i = end
+		case (ch == '$' || ch == '?') && i+1 < len(query) && isSQLDigit(query[i+1]):
+			j := i + 1
+			for j < len(query) && isSQLDigit(query[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{sqlParam, query[i+1 : j]})
+			i = j
+		case isSQLDigit(ch):
+			j := i
+			for j < len(query) && (isSQLDigit(query[j]) || query[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{sqlNumber, query[i:j]})
+			i = j
+		case isSQLWordStart(ch):
+			j := i
+			for j < len(query) && (isSQLWordStart(query[j]) || isSQLDigit(query[j]) || query[j] == '$') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{sqlWord, query[i:j]})
+			i = j
+		default:
+			width := 1
+			for _, op := range []string{"->>", "::", "||", "->", "<=", ">=", "<>", "!="} {
+				if strings.HasPrefix(query[i:], op) {
+					width = len(op)
+					break
+				}
+			}
+			tokens = append(tokens, sqlToken{sqlSymbol, query[i : i+width]})
+			i += width
+		}
+	}
+	return tokens
+}
+
+func isSQLDigit(ch byte) bool { return ch >= '0' && ch <= '9' }
+
+func isSQLWordStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch >= 0x80
+}
+
+// joinSQLTokens renders parameters as ?N, which SQLite binds by position just
+// as PostgreSQL binds $N.
+func joinSQLTokens(tokens []sqlToken) string {
+	var b strings.Builder
+	for i, token := range tokens {
+		if i > 0 && token.text != "." && tokens[i-1].text != "." {
+			b.WriteByte(' ')
+		}
+		if token.kind == sqlParam {
+			b.WriteByte('?')
+		}
+		b.WriteString(token.text)
+	}
+	return b.String()
+}
+
+func sqlWordTokens(words ...string) []sqlToken {
+	tokens := make([]sqlToken, 0, len(words))
+	for _, word := range words {
+		tokens = append(tokens, tokenizeSQL(word)...)
+	}
+	return tokens
+}
+
+func isSQLKeyword(tokens []sqlToken, i int, word string) bool {
+	return i >= 0 && i < len(tokens) && tokens[i].kind == sqlWord && strings.EqualFold(tokens[i].text, word)
+}
+
+func isSQLSymbol(tokens []sqlToken, i int, symbol string) bool {
+	return i >= 0 && i < len(tokens) && tokens[i].kind == sqlSymbol && tokens[i].text == symbol
+}
+
+// matchSQLParen returns the index of the parenthesis closing the one at open,
+// or -1 when the statement is unbalanced.
+func matchSQLParen(tokens []sqlToken, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch {
+		case isSQLSymbol(tokens, i, "("):
+			depth++
+		case isSQLSymbol(tokens, i, ")"):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isSQLNowCall(tokens []sqlToken, i int) bool {
+	return isSQLKeyword(tokens, i, "now") && isSQLSymbol(tokens, i+1, "(") && isSQLSymbol(tokens, i+2, ")")
+}
+
+func spliceSQL(tokens []sqlToken, start, end int, replacement []sqlToken) []sqlToken {
+	out := make([]sqlToken, 0, len(tokens)-(end-start)+len(replacement))
+	out = append(out, tokens[:start]...)
+	out = append(out, replacement...)
+	return append(out, tokens[end:]...)
+}
+
+// rewriteSQLiteUTCDate maps NOW() AT TIME ZONE 'UTC', used for calendar days.
+func rewriteSQLiteUTCDate(tokens []sqlToken) []sqlToken {
+	for i := 0; i < len(tokens); i++ {
+		if isSQLNowCall(tokens, i) && isSQLKeyword(tokens, i+3, "at") && isSQLKeyword(tokens, i+4, "time") &&
+			isSQLKeyword(tokens, i+5, "zone") && i+6 < len(tokens) && strings.EqualFold(tokens[i+6].text, "'utc'") {
+			tokens = spliceSQL(tokens, i, i+7, sqlWordTokens("date('now')"))
+		}
+	}
+	return tokens
+}
+
+// rewriteSQLiteJSONBConcat maps x::jsonb || jsonb_build_object(...), which
+// merges keys into a document, onto json_patch.
+func rewriteSQLiteJSONBConcat(tokens []sqlToken) []sqlToken {
+	for i := 1; i < len(tokens); i++ {
+		if !isSQLSymbol(tokens, i, "||") || !isSQLKeyword(tokens, i+1, "jsonb_build_object") {
+			continue
+		}
+		end := matchSQLParen(tokens, i+2)
+		if end < 0 {
+			continue
+		}
+		start := i - 1
+		if isSQLSymbol(tokens, i-2, "::") {
+			start = i - 3
+		}
+		if start < 0 {
+			continue
+		}
+		replacement := append(sqlWordTokens("json_patch("), tokens[start])
+		replacement = append(replacement, sqlToken{sqlSymbol, ","})
+		replacement = append(replacement, tokens[i+1:end+1]...)
+		replacement = append(replacement, sqlToken{sqlSymbol, ")"})
+		tokens = spliceSQL(tokens, start, end+1, replacement)
+		i = start
+	}
+	return tokens
+}
+
+func stripSQLiteCasts(tokens []sqlToken) []sqlToken {
+	out := make([]sqlToken, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		if !isSQLSymbol(tokens, i, "::") {
+			out = append(out, tokens[i])
+			continue
+		}
+		i++
+		if isSQLSymbol(tokens, i+1, "[") && isSQLSymbol(tokens, i+2, "]") {
+			i += 2
+		}
+	}
+	return out
+}
+
+var sqlIntervalUnits = map[string]float64{
+	"millisecond": 0.001, "milliseconds": 0.001,
+	"second": 1, "seconds": 1,
+	"minute": 60, "minutes": 60,
+	"hour": 3600, "hours": 3600,
+	"day": 86400, "days": 86400,
+}
+
+// rewriteSQLiteIntervals maps NOW() ± [factor *] INTERVAL 'n unit' onto a
+// strftime modifier.
+func rewriteSQLiteIntervals(tokens []sqlToken) []sqlToken {
+	for i := 0; i < len(tokens); i++ {
+		if !isSQLNowCall(tokens, i) || !(isSQLSymbol(tokens, i+3, "+") || isSQLSymbol(tokens, i+3, "-")) {
+			continue
+		}
+		sign := tokens[i+3].text
+		var factor []sqlToken
+		at := i + 4
+		if isSQLSymbol(tokens, at+1, "*") && isSQLKeyword(tokens, at+2, "interval") {
+			factor = tokens[at : at+1]
+			at += 2
+		}
+		if !isSQLKeyword(tokens, at, "interval") || at+1 >= len(tokens) || tokens[at+1].kind != sqlString {
+			continue
+		}
+		fields := strings.Fields(strings.Trim(tokens[at+1].text, "'"))
+		if len(fields) != 2 {
+			continue
+		}
+		amount, err := strconv.ParseFloat(fields[0], 64)
+		unit, ok := sqlIntervalUnits[strings.ToLower(fields[1])]
+		if err != nil || !ok {
+			continue
+		}
+		seconds := strconv.FormatFloat(amount*unit, 'f', -1, 64)
+		offset := sqlWordTokens("(", sign+seconds)
+		if factor != nil {
+			offset = append(offset, sqlToken{sqlSymbol, "*"})
+			offset = append(offset, factor...)
+		}
+		replacement := sqlWordTokens("strftime('%Y-%m-%dT%H:%M:%fZ',", "'now',")
+		replacement = append(replacement, offset...)
+		replacement = append(replacement, sqlWordTokens(") || ' seconds')")...)
+		tokens = spliceSQL(tokens, i, at+2, replacement)
+	}
+	return tokens
+}
+
+func rewriteSQLiteNow(tokens []sqlToken) []sqlToken {
+	for i := 0; i < len(tokens); i++ {
+		if isSQLNowCall(tokens, i) {
+			tokens = spliceSQL(tokens, i, i+3, sqlWordTokens("strftime('%Y-%m-%dT%H:%M:%fZ', 'now')"))
+		}
+	}
+	return tokens
+}
+
+// rewriteSQLiteAny maps x = ANY(array) onto membership in the JSON array.
+func rewriteSQLiteAny(tokens []sqlToken) []sqlToken {
+	for i := 0; i < len(tokens); i++ {
+		if !isSQLSymbol(tokens, i, "=") || !isSQLKeyword(tokens, i+1, "any") || !isSQLSymbol(tokens, i+2, "(") {
+			continue
+		}
+		end := matchSQLParen(tokens, i+2)
+		if end < 0 {
+			continue
+		}
+		replacement := sqlWordTokens("IN (SELECT value FROM json_each(")
+		replacement = append(replacement, tokens[i+3:end]...)
+		replacement = append(replacement, sqlWordTokens("))")...)
+		tokens = spliceSQL(tokens, i, end+1, replacement)
+	}
+	return tokens
+}
+
+// sqliteJSONColumns hold JSON documents as text; json_object would otherwise
+// embed them as strings.
+var sqliteJSONColumns = map[string]bool{
+	"payload": true, "details": true, "frame": true,
+	"identity_key_jwk": true, "identity_signing_public_key_jwk": true,
+	"public_key_jwk": true, "signing_public_key_jwk": true,
+}
+
+var sqliteBoolColumns = map[string]bool{
+	"hide_last_seen": true, "is_system": true, "announcement_only": true,
+	"knock_enabled": true, "enabled": true,
+}
+
+func rewriteSQLiteFunctions(tokens []sqlToken) []sqlToken {
+	for i := 0; i < len(tokens); i++ {
+		switch {
+		case isSQLKeyword(tokens, i, "array_to_json") && isSQLSymbol(tokens, i+1, "("):
+			tokens = spliceSQL(tokens, i, i+1, nil)
+		case isSQLKeyword(tokens, i, "bool_or"):
+			tokens[i] = sqlToken{sqlWord, "max"}
+		case isSQLKeyword(tokens, i, "json_agg"):
+			tokens[i] = sqlToken{sqlWord, "json_group_array"}
+		case isSQLKeyword(tokens, i, "ilike"):
+			tokens[i] = sqlToken{sqlWord, "LIKE"}
+		case (isSQLKeyword(tokens, i, "json_build_object") || isSQLKeyword(tokens, i, "jsonb_build_object")) && isSQLSymbol(tokens, i+1, "("):
+			end := matchSQLParen(tokens, i+1)
+			if end < 0 {
+				continue
+			}
+			args := wrapSQLiteJSONObjectValues(tokens[i+2 : end])
+			replacement := sqlWordTokens("json_object(")
+			replacement = append(replacement, args...)
+			replacement = append(replacement, sqlToken{sqlSymbol, ")"})
+			tokens = spliceSQL(tokens, i, end+1, replacement)
+		}
+	}
+	return tokens
+}
+
+func wrapSQLiteJSONObjectValues(args []sqlToken) []sqlToken {
+	var out []sqlToken
+	start, depth, index := 0, 0, 0
+	flush := func(end int) {
+		arg := args[start:end]
+		column := ""
+		if len(arg) == 1 && arg[0].kind == sqlWord {
+			column = arg[0].text
+		} else if len(arg) == 3 && arg[1].text == "." && arg[2].kind == sqlWord {
+			column = arg[2].text
+		}
+		column = strings.ToLower(column)
+		switch {
+		case index%2 == 1 && sqliteJSONColumns[column]:
+			out = append(out, sqlWordTokens("json(")...)
+			out = append(out, arg...)
+			out = append(out, sqlToken{sqlSymbol, ")"})
+		case index%2 == 1 && sqliteBoolColumns[column]:
+			out = append(out, sqlWordTokens("json(CASE")...)
+			out = append(out, arg...)
+			out = append(out, sqlWordTokens("WHEN 1 THEN 'true' WHEN 0 THEN 'false' END)")...)
+		default:
+			out = append(out, arg...)
+		}
+	}
+	for i, token := range args {
+		switch {
+		case token.kind == sqlSymbol && token.text == "(":
+			depth++
+		case token.kind == sqlSymbol && token.text == ")":
+			depth--
+		case token.kind == sqlSymbol && token.text == "," && depth == 0:
+			flush(i)
+			out = append(out, token)
+			start = i + 1
+			index++
+		}
+	}
+	if start < len(args) {
+		flush(len(args))
+	}
+	return out
+}
+
+// rewriteSQLiteOperators maps IS [NOT] DISTINCT FROM onto SQLite's
+// null-safe IS NOT and IS.
+func rewriteSQLiteOperators(tokens []sqlToken) []sqlToken {
+	for i := 0; i < len(tokens); i++ {
+		if !isSQLKeyword(tokens, i, "is") {
+			continue
+		}
+		if isSQLKeyword(tokens, i+1, "distinct") && isSQLKeyword(tokens, i+2, "from") {
+			tokens = spliceSQL(tokens, i, i+3, sqlWordTokens("IS NOT"))
+		} else if isSQLKeyword(tokens, i+1, "not") && isSQLKeyword(tokens, i+2, "distinct") && isSQLKeyword(tokens, i+3, "from") {
+			tokens = spliceSQL(tokens, i, i+4, sqlWordTokens("IS"))
+		}
+	}
+	return tokens
+}
+
+// stripSQLiteRowLocks drops FOR UPDATE clauses: every write transaction
+// starts with BEGIN IMMEDIATE, which already excludes other writers.
+func stripSQLiteRowLocks(tokens []sqlToken) []sqlToken {
+	for i := 0; i < len(tokens); i++ {
+		if !isSQLKeyword(tokens, i, "for") || !(isSQLKeyword(tokens, i+1, "update") || isSQLKeyword(tokens, i+1, "share")) {
+			continue
+		}
+		end := i + 2
+		if isSQLKeyword(tokens, end, "of") {
+			end += 2
+			for isSQLSymbol(tokens, end, ",") {
+				end += 2
+			}
+		}
+		if isSQLKeyword(tokens, end, "skip") && isSQLKeyword(tokens, end+1, "locked") {
+			end += 2
+		} else if isSQLKeyword(tokens, end, "nowait") {
+			end++
+		}
+		tokens = spliceSQL(tokens, i, min(end, len(tokens)), nil)
+		i--
+	}
+	return tokens
+}
+
+// rewriteSQLiteUnnest maps unnest(a, b, ...) [WITH ORDINALITY] [AS] alias
+// [(columns)] onto json_each tables joined on their array index.
+func rewriteSQLiteUnnest(tokens []sqlToken) []sqlToken {
+	for i := 0; i < len(tokens); i++ {
+		if !isSQLKeyword(tokens, i, "unnest") || !isSQLSymbol(tokens, i+1, "(") {
+			continue
+		}
+		end := matchSQLParen(tokens, i+1)
+		if end < 0 {
+			continue
+		}
+		var arrays [][]sqlToken
+		start, depth := i+2, 0
+		for j := i + 2; j <= end; j++ {
+			switch {
+			case isSQLSymbol(tokens, j, "("):
+				depth++
+			case isSQLSymbol(tokens, j, ")") && depth > 0:
+				depth--
+			case (isSQLSymbol(tokens, j, ",") && depth == 0) || j == end:
+				arrays = append(arrays, tokens[start:j])
+				start = j + 1
+			}
+		}
+
+		next := end + 1
+		ordinality := false
+		if isSQLKeyword(tokens, next, "with") && isSQLKeyword(tokens, next+1, "ordinality") {
+			ordinality = true
+			next += 2
+		}
+		if isSQLKeyword(tokens, next, "as") {
+			next++
+		}
+		alias := "unnest"
+		var columns []string
+		if next < len(tokens) && tokens[next].kind == sqlWord && !isSQLReservedAfterFrom(tokens[next].text) {
+			alias = tokens[next].text
+			next++
+			if isSQLSymbol(tokens, next, "(") {
+				closeAt := matchSQLParen(tokens, next)
+				for j := next + 1; j < closeAt; j++ {
+					if tokens[j].kind == sqlWord {
+						columns = append(columns, tokens[j].text)
+					}
+				}
+				next = closeAt + 1
+			}
+		}
+		if len(columns) == 0 {
+			if len(arrays) == 1 {
+				columns = []string{alias}
+			} else {
+				for n := range arrays {
+					columns = append(columns, "unnest"+strconv.Itoa(n+1))
+				}
+			}
+		}
+
+		replacement := sqlWordTokens("(SELECT")
+		for n := range arrays {
+			if n > 0 {
+				replacement = append(replacement, sqlToken{sqlSymbol, ","})
+			}
+			name := "unnest"
+			if n < len(columns) {
+				name = columns[n]
+			}
+			replacement = append(replacement, sqlWordTokens("j"+strconv.Itoa(n+1)+".value AS", name)...)
+		}
+		if ordinality {
+			name := "ordinality"
+			if len(columns) > len(arrays) {
+				name = columns[len(arrays)]
+			}
+			replacement = append(replacement, sqlWordTokens(", j1.key + 1 AS", name)...)
+		}
+		for n, array := range arrays {
+			table := "j" + strconv.Itoa(n+1)
+			if n == 0 {
+				replacement = append(replacement, sqlWordTokens("FROM json_each(")...)
+			} else {
+				replacement = append(replacement, sqlWordTokens("JOIN json_each(")...)
+			}
+			replacement = append(replacement, array...)
+			replacement = append(replacement, sqlWordTokens(") AS", table)...)
+			if n > 0 {
+				replacement = append(replacement, sqlWordTokens("ON", table+".key = j1.key")...)
+			}
+		}
+		replacement = append(replacement, sqlWordTokens(") AS", alias)...)
+		tokens = spliceSQL(tokens, i, next, replacement)
+	}
+	return tokens
+}
+
+func isSQLReservedAfterFrom(word string) bool {
+	switch strings.ToLower(word) {
+	case "on", "where", "order", "group", "limit", "join", "left", "inner", "returning":
+		return true
+	}
+	return false
+}
+
+// rewriteSQLiteInsertSelect adds WHERE true to INSERT ... SELECT ... ON
+// CONFLICT statements whose SELECT has none, which SQLite otherwise cannot
+// tell apart from a join constraint.
+func rewriteSQLiteInsertSelect(tokens []sqlToken) []sqlToken {
+	if !isSQLKeyword(tokens, 0, "insert") || !isSQLKeyword(tokens, 1, "into") {
+		return tokens
+	}
+	at := 3
+	if isSQLSymbol(tokens, at, "(") {
+		at = matchSQLParen(tokens, at) + 1
+	}
+	if at <= 0 || !isSQLKeyword(tokens, at, "select") {
+		return tokens
+	}
+	depth := 0
+	insertAt := -1
+	for i := at; i < len(tokens); i++ {
+		switch {
+		case isSQLSymbol(tokens, i, "("):
+			depth++
+		case isSQLSymbol(tokens, i, ")"):
+			depth--
+		case depth != 0:
+		case isSQLKeyword(tokens, i, "where"):
+			return tokens
+		case insertAt < 0 && (isSQLKeyword(tokens, i, "group") || isSQLKeyword(tokens, i, "order") || isSQLKeyword(tokens, i, "limit")):
+			insertAt = i
+		case isSQLKeyword(tokens, i, "on") && isSQLKeyword(tokens, i+1, "conflict"):
+			if insertAt < 0 {
+				insertAt = i
+			}
+			return spliceSQL(tokens, insertAt, insertAt, sqlWordTokens("WHERE true"))
+		}
+	}
+	return tokens
+}
+
+// rewriteSQLiteMessageSeq reads a new message's room_seq from the room
+// counter: trg_messages_stamp assigns it after RETURNING has been evaluated.
+func rewriteSQLiteMessageSeq(tokens []sqlToken) []sqlToken {
+	if !isSQLKeyword(tokens, 0, "insert") || !isSQLKeyword(tokens, 1, "into") || !isSQLKeyword(tokens, 2, "messages") {
+		return tokens
+	}
+	returning := -1
+	for i := range tokens {
+		if isSQLKeyword(tokens, i, "returning") {
+			returning = i
+		}
+	}
+	if returning < 0 {
+		return tokens
+	}
+	for i := returning + 1; i < len(tokens); i++ {
+		if isSQLKeyword(tokens, i, "room_seq") {
+			tokens = spliceSQL(tokens, i, i+1, sqlWordTokens("(SELECT last_message_seq FROM rooms WHERE rooms.id = messages.room_id)"))
+		}
+	}
+	return tokens
+}
+
+// sqliteStrictDialect makes the driver refuse statements that still hold
+// PostgreSQL-only syntax after translation. The tests turn it on, so the
+// unit and integration suites fail on any construct the translator misses
+// even where SQLite would accept it or the error would be obscure.
+var sqliteStrictDialect bool
+
+// sqlitePostgresOnlyFunctions have no SQLite counterpart under the same name;
+// the translator rewrites or drops every call it supports.
+var sqlitePostgresOnlyFunctions = map[string]bool{
+	"any": true, "now": true, "unnest": true, "array_agg": true, "array_to_json": true,
+	"json_build_object": true, "jsonb_build_object": true, "jsonb_agg": true,
+	"to_json": true, "to_jsonb": true, "jsonb_set": true, "jsonb_array_elements": true,
+	"jsonb_array_length": true, "jsonb_typeof": true, "greatest": true, "least": true,
+	"date_trunc": true, "extract": true, "to_char": true, "age": true, "bool_and": true,
+	"gen_random_uuid": true, "regexp_replace": true, "make_interval": true,
+}
+
+// postgresOnlySQL returns the first PostgreSQL-only construct left in a
+// translated statement, or "" when there is none. pg_* calls are allowed
+// only for the functions the driver registers.
+func postgresOnlySQL(tokens []sqlToken) string {
+	for i, token := range tokens {
+		word := strings.ToLower(token.text)
+		switch {
+		case token.kind == sqlSymbol && token.text == "::":
+			return "::"
+		case token.kind != sqlWord:
+		case word == "interval" || word == "ilike" || word == "similar":
+			return token.text
+		case isSQLSymbol(tokens, i+1, "(") && (sqlitePostgresOnlyFunctions[word] ||
+			(strings.HasPrefix(word, "pg_") && word != "pg_advisory_xact_lock" && word != "pg_try_advisory_xact_lock")):
+			return token.text + "()"
+		case word == "at" && isSQLKeyword(tokens, i+1, "time") && isSQLKeyword(tokens, i+2, "zone"):
+			return "AT TIME ZONE"
+		case word == "for" && (isSQLKeyword(tokens, i+1, "update") || isSQLKeyword(tokens, i+1, "share")):
+			return "FOR " + tokens[i+1].text
+		case word == "skip" && isSQLKeyword(tokens, i+1, "locked"):
+			return "SKIP LOCKED"
+		case word == "distinct" && isSQLKeyword(tokens, i+1, "on"):
+			return "DISTINCT ON"
+		}
+	}
+	return ""
+}
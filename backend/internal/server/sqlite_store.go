@@ -0,0 +1,329 @@
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// sqliteDriverName is a database/sql driver wrapping modernc.org/sqlite for
+// single-node deployments (DATABASE_URL=sqlite:///data/chat.db). It
+// translates each statement with translateSQLiteQuery and converts values
+// at the boundary: array parameters become JSON text, times are stored as
+// UTC text that sorts and compares like the timestamps NOW() produces, and
+// TIMESTAMPTZ columns scan back into time.Time.
+const sqliteDriverName = "chat-sqlite"
+
+// sqliteTimeLayout matches strftime('%Y-%m-%dT%H:%M:%fZ').
+const sqliteTimeLayout = "2006-01-02T15:04:05.000Z"
+
+func init() {
+	// The advisory locks that serialize PostgreSQL writers are no-ops:
+	// SQLite admits one writer at a time.
+	functions := map[string]func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error){
+		"md5": func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			if args[0] == nil {
+				return nil, nil
+			}
+			sum := md5.Sum([]byte(fmt.Sprint(args[0])))
+			return hex.EncodeToString(sum[:]), nil
+		},
+		"pg_advisory_xact_lock": func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+			return nil, nil
+		},
+		"pg_try_advisory_xact_lock": func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+			return true, nil
+		},
+	}
+	for name, fn := range functions {
+		if err := sqlite.RegisterDeterministicScalarFunction(name, -1, fn); err != nil {
+			panic(err)
+		}
+	}
+
+	// modernc keeps registered functions on the driver it registers as
+	// "sqlite", so connections are opened through that instance.
+	db, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(err)
+	}
+	base := db.Driver()
+	_ = db.Close()
+	sql.Register(sqliteDriverName, sqliteDriver{base: base})
+}
+
+func isSQLiteURL(dbURL string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(dbURL)), "sqlite:")
+}
+
+// sqliteDSN maps sqlite:///abs/path or sqlite://rel/path onto a modernc
+// connection string. Foreign keys are off by default in SQLite and the
+// schema relies on their cascades; BEGIN IMMEDIATE takes the write lock up
+// front so a transaction never fails to upgrade halfway through.
+func sqliteDSN(dbURL string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(dbURL))
+	if err != nil {
+		return "", err
+	}
+	path := parsed.Host + parsed.Path
+	if path == "" || path == "/" {
+		return "", fmt.Errorf("sqlite DATABASE_URL must include a database file path")
+	}
+	query := url.Values{}
+	query.Add("_pragma", "foreign_keys(1)")
+	query.Add("_pragma", "busy_timeout(10000)")
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Set("_txlock", "immediate")
+	return "file:" + path + "?" + query.Encode(), nil
+}
+
+// openDatabase opens DATABASE_URL with the driver its scheme selects.
+func openDatabase(dbURL string) (*sql.DB, error) {
+	if !isSQLiteURL(dbURL) {
+		return sql.Open("pgx", dbURL)
+	}
+	dsn, err := sqliteDSN(dbURL)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open(sqliteDriverName, dsn)
+}
+
+func isSQLiteDB(db *sql.DB) bool {
+	_, ok := db.Driver().(sqliteDriver)
+	return ok
+}
+
+type sqliteDriver struct {
+	base driver.Driver
+}
+
+func (d sqliteDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	base, ok := conn.(sqliteBaseConn)
+	if !ok {
+		_ = conn.Close()
+		return nil, fmt.Errorf("sqlite driver connection %T lacks context support", conn)
+	}
+	return &sqliteConn{base: base}, nil
+}
+
+type sqliteBaseConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+type sqliteConn struct {
+	base sqliteBaseConn
+}
+
+var (
+	_ driver.ConnBeginTx        = (*sqliteConn)(nil)
+	_ driver.ConnPrepareContext = (*sqliteConn)(nil)
+	_ driver.ExecerContext      = (*sqliteConn)(nil)
+	_ driver.QueryerContext     = (*sqliteConn)(nil)
+	_ driver.NamedValueChecker  = (*sqliteConn)(nil)
+)
+
+func (c *sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// sqliteQuery translates query and, in strict mode, rejects what the
+// translation left PostgreSQL-only.
+func sqliteQuery(query string) (string, error) {
+	translated := translateSQLiteQuery(query)
+	if sqliteStrictDialect {
+		if construct := postgresOnlySQL(tokenizeSQL(translated)); construct != "" {
+			return "", fmt.Errorf("sqlite: no translation for %s in %q", construct, query)
+		}
+	}
+	return translated, nil
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	translated, err := sqliteQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := c.base.PrepareContext(ctx, translated)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteStmt{Stmt: stmt}, nil
+}
+
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	translated, err := sqliteQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return c.base.ExecContext(ctx, translated, args)
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	translated, err := sqliteQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.base.QueryContext(ctx, translated, args)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLiteRows(rows), nil
+}
+
+// BeginTx drops the isolation level: SQLite transactions are always
+// serializable.
+func (c *sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.base.BeginTx(ctx, driver.TxOptions{ReadOnly: opts.ReadOnly})
+}
+
+func (c *sqliteConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *sqliteConn) Close() error                           { return c.base.Close() }
+func (c *sqliteConn) Ping(ctx context.Context) error         { return c.base.Ping(ctx) }
+func (c *sqliteConn) ResetSession(ctx context.Context) error { return c.base.ResetSession(ctx) }
+func (c *sqliteConn) IsValid() bool                          { return c.base.IsValid() }
+
+func (c *sqliteConn) CheckNamedValue(nv *driver.NamedValue) error {
+	value, err := sqliteValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	nv.Value = value
+	return nil
+}
+
+// sqliteValue converts a query argument into a value SQLite stores the way
+// the schema expects. Byte slices are bound as text, never as BLOBs, since
+// the JSON functions read BLOBs as binary JSONB.
+func sqliteValue(value any) (driver.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case driver.Valuer:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return nil, nil
+		}
+		converted, err := v.Value()
+		if err != nil {
+			return nil, err
+		}
+		return sqliteValue(converted)
+	case time.Time:
+		return v.UTC().Format(sqliteTimeLayout), nil
+	case string, int64, float64, bool:
+		return v, nil
+	case []byte:
+		if v == nil {
+			return nil, nil
+		}
+		return string(v), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(rv.Bytes()), nil
+		}
+		fallthrough
+	case reflect.Array:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(encoded), nil
+	}
+	converted, err := driver.DefaultParameterConverter.ConvertValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteValue(converted)
+}
+
+type sqliteStmt struct {
+	driver.Stmt
+}
+
+func (s *sqliteStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func (s *sqliteStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLiteRows(rows), nil
+}
+
+// sqliteRows hands text back as bytes, which scans into strings and
+// json.RawMessage alike, and parses timestamps from TIMESTAMPTZ columns
+// and from untyped expressions such as MAX(created_at).
+type sqliteRows struct {
+	driver.Rows
+	timeColumns []bool
+}
+
+func newSQLiteRows(rows driver.Rows) *sqliteRows {
+	columns := rows.Columns()
+	timeColumns := make([]bool, len(columns))
+	typed, ok := rows.(driver.RowsColumnTypeDatabaseTypeName)
+	for i := range columns {
+		if !ok {
+			timeColumns[i] = true
+			continue
+		}
+		switch typed.ColumnTypeDatabaseTypeName(i) {
+		case "", "TIMESTAMPTZ":
+			timeColumns[i] = true
+		}
+	}
+	return &sqliteRows{Rows: rows, timeColumns: timeColumns}
+}
+
+func (r *sqliteRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	for i, value := range dest {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if r.timeColumns[i] && len(text) == len(sqliteTimeLayout) && text[10] == 'T' && text[len(text)-1] == 'Z' {
+			if parsed, err := time.Parse(sqliteTimeLayout, text); err == nil {
+				dest[i] = parsed
+				continue
+			}
+		}
+		dest[i] = []byte(text)
+	}
+	return nil
+}
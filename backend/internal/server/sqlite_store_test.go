@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Every test, integration ones included, fails on a statement the SQLite
+// translator leaves PostgreSQL-only.
+func init() {
+	sqliteStrictDialect = true
+}
+
+func TestTranslateSQLiteQuery(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "params and casts",
+			query: `SELECT id FROM users WHERE username = ANY($1::TEXT[]) AND id = $2::BIGINT`,
+			want:  `SELECT id FROM users WHERE username IN ( SELECT value FROM json_each ( ?1 ) ) AND id = ?2`,
+		},
+		{
+			name:  "interval arithmetic",
+			query: `UPDATE t SET next_at = NOW() + $2 * INTERVAL '1 millisecond'`,
+			want:  `UPDATE t SET next_at = strftime ( '%Y-%m-%dT%H:%M:%fZ' , 'now' , ( + 0.001 * ?2 ) || ' seconds' )`,
+		},
+		{
+			name:  "utc day",
+			query: `SELECT (NOW() AT TIME ZONE 'UTC')::date`,
+			want:  `SELECT ( date ( 'now' ) )`,
+		},
+		{
+			name:  "row locks",
+			query: `SELECT id FROM jobs WHERE id = $1 FOR UPDATE OF j SKIP LOCKED`,
+			want:  `SELECT id FROM jobs WHERE id = ?1`,
+		},
+		{
+			name:  "json object values",
+			query: `SELECT json_build_object('payload', m.payload, 'hidden', hide_last_seen)`,
+			want:  `SELECT json_object ( 'payload' , json ( m.payload ) , 'hidden' , json ( CASE hide_last_seen WHEN 1 THEN 'true' WHEN 0 THEN 'false' END ) )`,
+		},
+		{
+			name:  "unnest with ordinality",
+			query: `SELECT b.room_id FROM unnest($1::BIGINT[], $2::TEXT[]) WITH ORDINALITY AS b(room_id, payload, ord) ORDER BY b.ord`,
+			want: `SELECT b.room_id FROM ( SELECT j1.value AS room_id , j2.value AS payload , j1.key + 1 AS ord ` +
+				`FROM json_each ( ?1 ) AS j1 JOIN json_each ( ?2 ) AS j2 ON j2.key = j1.key ) AS b ORDER BY b.ord`,
+		},
+		{
+			name:  "insert select before on conflict",
+			query: `INSERT INTO t(a) SELECT x FROM y ON CONFLICT DO NOTHING`,
+			want:  `INSERT INTO t ( a ) SELECT x FROM y WHERE true ON CONFLICT DO NOTHING`,
+		},
+		{
+			name:  "strings and comments pass through",
+			query: "SELECT 'NOW() $1::x' -- trailing\nFROM t",
+			want:  `SELECT 'NOW() $1::x' FROM t`,
+		},
+	}
+	for _, tc := range cases {
+		if got := translateSQLiteQuery(tc.query); got != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, tc.want)
+		}
+		if construct := postgresOnlySQL(tokenizeSQL(tc.want)); construct != "" {
+			t.Errorf("%s: translation still holds %s", tc.name, construct)
+		}
+	}
+}
+
+func TestSQLiteStrictDialectRejectsUntranslatedSyntax(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		`SELECT DISTINCT ON (room_id) id FROM messages`:                  "DISTINCT ON",
+		`SELECT GREATEST(a, b) FROM t`:                                   "GREATEST()",
+		`SELECT created_at AT TIME ZONE 'Asia/Shanghai' FROM t`:          "AT TIME ZONE",
+		`SELECT pg_snapshot_xmin(pg_current_snapshot())`:                 "pg_snapshot_xmin()",
+		`SELECT id FROM t WHERE created_at > NOW() - INTERVAL '1 month'`: "INTERVAL",
+		`SELECT pg_try_advisory_xact_lock(1)`:                            "",
+		`SELECT 'interval' AS word, interval_ms FROM t`:                  "",
+	}
+	for query, want := range cases {
+		if got := postgresOnlySQL(tokenizeSQL(translateSQLiteQuery(query))); got != want {
+			t.Errorf("%s: got %q, want %q", query, got, want)
+		}
+	}
+
+	db := openSQLiteTestDB(t)
+	if _, err := db.Exec(`SELECT GREATEST(1, 2)`); err == nil || !strings.Contains(err.Error(), "no translation for GREATEST()") {
+		t.Fatalf("expected the strict driver to name the construct, got %v", err)
+	}
+}
+
+// openSQLiteTestDB returns a migrated SQLite database that lives as long as
+// the test.
+func openSQLiteTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := openDatabase("sqlite://" + filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := runMigrations(db); err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+	return db
+}
+
+// seedSQLiteTestRoom bootstraps the admin account and its room.
+func seedSQLiteTestRoom(t *testing.T, db *sql.DB) (adminID, roomID int64) {
+	t.Helper()
+	if err := bootstrapAdminSecurity(db, "admin", []string{"ops"}, "hash", "admins"); err != nil {
+		t.Fatalf("bootstrap admin: %v", err)
+	}
+	if err := db.QueryRowContext(context.Background(),
+		`SELECT u.id, r.id FROM users u JOIN rooms r ON r.created_by = u.id WHERE u.username = $1`, "admin",
+	).Scan(&adminID, &roomID); err != nil {
+		t.Fatalf("load bootstrap rows: %v", err)
+	}
+	return adminID, roomID
+}
+
+// insertSQLiteTestMember creates an account with role and adds it to room.
+func insertSQLiteTestMember(t *testing.T, db *sql.DB, roomID int64, username, role string) int64 {
+	t.Helper()
+	ctx := context.Background()
+	var userID int64
+	if err := db.QueryRowContext(ctx,
+		`INSERT INTO users(username, password_hash, role) VALUES ($1, $2, $3) RETURNING id`, username, "hash", role,
+	).Scan(&userID); err != nil {
+		t.Fatalf("insert %s: %v", username, err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO room_members(room_id, user_id) VALUES ($1, $2)`, roomID, userID); err != nil {
+		t.Fatalf("add %s to room: %v", username, err)
+	}
+	return userID
+}
+
+func TestSQLiteStoreMessages(t *testing.T) {
+	t.Parallel()
+
+	db, err := openDatabase("sqlite://" + filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+	if err := runMigrations(db); err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+	if err := runMigrations(db); err != nil {
+		t.Fatalf("rerun migrations: %v", err)
+	}
+	if err := bootstrapAdminSecurity(db, "admin", []string{"ops"}, "hash", "admins"); err != nil {
+		t.Fatalf("bootstrap admin: %v", err)
+	}
+
+	ctx := context.Background()
+	var adminID, roomID int64
+	var epoch int64
+	if err := db.QueryRowContext(ctx, `
+SELECT u.id, r.id, r.key_epoch
+FROM users u JOIN rooms r ON r.created_by = u.id
+WHERE u.username = $1 AND r.name = $2
+`, "admin", "admins").Scan(&adminID, &roomID, &epoch); err != nil {
+		t.Fatalf("load bootstrap rows: %v", err)
+	}
+	if epoch != 2 {
+		t.Fatalf("key epoch = %d, want 2 after the admin joined", epoch)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	first, err := insertMessageTx(ctx, tx, roomID, adminID, []byte(`{"ciphertext":"YQ==","keyEpoch":7}`), []int64{adminID})
+	if err != nil {
+		t.Fatalf("insert first message: %v", err)
+	}
+	second, err := insertMessageTx(ctx, tx, roomID, adminID, []byte(`{"ciphertext":"Yg=="}`), nil)
+	if err != nil {
+		t.Fatalf("insert second message: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if first.Seq != 1 || second.Seq != 2 {
+		t.Fatalf("seqs = %d, %d; want 1, 2", first.Seq, second.Seq)
+	}
+	if time.Since(first.CreatedAt) > time.Minute || first.CreatedAt.Location() != time.UTC {
+		t.Fatalf("created_at = %v, want a recent UTC time", first.CreatedAt)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT room_seq, key_epoch, payload FROM messages WHERE room_id = $1 ORDER BY id`, roomID)
+	if err != nil {
+		t.Fatalf("load messages: %v", err)
+	}
+	defer rows.Close()
+	var epochs []int64
+	for rows.Next() {
+		var seq, keyEpoch int64
+		var payload []byte
+		if err := rows.Scan(&seq, &keyEpoch, &payload); err != nil {
+			t.Fatalf("scan message: %v", err)
+		}
+		epochs = append(epochs, keyEpoch)
+	}
+	if len(epochs) != 2 || epochs[0] != 7 || epochs[1] != epoch {
+		t.Fatalf("key epochs = %v, want [7 %d]", epochs, epoch)
+	}
+
+	var count, mentioned int64
+	var lastAt sql.NullTime
+	if err := db.QueryRowContext(ctx, `
+SELECT s.message_count, s.last_message_at, (SELECT COUNT(*) FROM message_mentions WHERE user_id = $2)
+FROM room_message_stats s WHERE s.room_id = $1
+`, roomID, adminID).Scan(&count, &lastAt, &mentioned); err != nil {
+		t.Fatalf("load stats: %v", err)
+	}
+	if count != 2 || !lastAt.Valid || mentioned != 1 {
+		t.Fatalf("stats = %d, %v, mentions %d", count, lastAt, mentioned)
+	}
+
+	var expired int64
+	if err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM messages WHERE created_at < NOW() - INTERVAL '1 hour' OR created_at > $1`,
+		time.Now().Add(time.Hour),
+	).Scan(&expired); err != nil {
+		t.Fatalf("compare timestamps: %v", err)
+	}
+	if expired != 0 {
+		t.Fatalf("%d messages fall outside the last hour", expired)
+	}
+}
@@ -2,28 +2,348 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 )
 
-func (a *App) storeMessage(ctx context.Context, roomID, senderID int64, payload CipherPayload) (int64, time.Time, error) {
+// messageForward records where a forwarded message was copied from.
+type messageForward struct {
+	RoomID    int64
+	MessageID int64
+}
+
+// messageStamp is what persisting assigns a message: its global id, its
+// position in the room's sequence and its creation time.
+type messageStamp struct {
+	ID        int64
+	Seq       int64
+	CreatedAt time.Time
+}
+
+func (a *App) storeMessage(ctx context.Context, roomID, senderID int64, payload CipherPayload, mentions []int64) (messageStamp, error) {
+	return a.storeMessageFrom(ctx, roomID, senderID, payload, mentions, nil)
+}
+
+// storeMessageFrom is storeMessage with optional forwarding provenance.
+func (a *App) storeMessageFrom(ctx context.Context, roomID, senderID int64, payload CipherPayload, mentions []int64, forward *messageForward) (stamp messageStamp, err error) {
+	ctx, s := a.tracer.StartSpan(ctx, "db.store_message", spanKindClient,
+		attrString("db.system", "postgresql"),
+		attrInt("room_id", roomID),
+	)
+	defer func() { s.End(err) }()
+
 	payloadJSON, err := json.Marshal(payload)
 	if err != nil {
-		return 0, time.Time{}, err
+		return messageStamp{}, err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return messageStamp{}, err
+	}
+	defer tx.Rollback()
+
+	if err := a.chargeMessageQuotaTx(ctx, tx, senderID, 1, len(payloadJSON)); err != nil {
+		return messageStamp{}, err
+	}
+	stamp, err = insertMessageTx(ctx, tx, roomID, senderID, payloadJSON, mentions)
+	if err != nil {
+		return messageStamp{}, err
+	}
+	if forward != nil {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE messages SET forwarded_from_room_id = $2, forwarded_from_message_id = $3 WHERE id = $1`,
+			stamp.ID, forward.RoomID, forward.MessageID,
+		); err != nil {
+			return messageStamp{}, err
+		}
+	}
+	if err := a.federation.enqueueMessage(ctx, tx, roomID, senderID, localFederationEnvelope(stamp.ID, stamp.CreatedAt, payloadJSON)); err != nil {
+		return messageStamp{}, err
 	}
 
-	var messageID int64
-	var createdAt time.Time
-	err = a.db.QueryRowContext(ctx, `
+	if err := tx.Commit(); err != nil {
+		return messageStamp{}, err
+	}
+	a.webhooks.Notify()
+	a.federation.Notify()
+	return stamp, nil
+}
+
+// insertMessageTx writes a message with its mentions, event log entry and
+// webhook deliveries inside the caller's transaction. The room sequence
+// number is assigned by a trigger that locks the room row, so sequence order
+// matches commit order within a room.
+func insertMessageTx(ctx context.Context, tx *sql.Tx, roomID, senderID int64, payloadJSON []byte, mentions []int64) (messageStamp, error) {
+	var stamp messageStamp
+	err := tx.QueryRowContext(ctx, `
 INSERT INTO messages(room_id, sender_id, payload)
 VALUES ($1, $2, $3)
-RETURNING id, created_at
-`, roomID, senderID, payloadJSON).Scan(&messageID, &createdAt)
+RETURNING id, room_seq, created_at
+`, roomID, senderID, payloadJSON).Scan(&stamp.ID, &stamp.Seq, &stamp.CreatedAt)
+	if err != nil {
+		return messageStamp{}, err
+	}
+	for _, userID := range mentions {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO message_mentions(message_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			stamp.ID, userID,
+		); err != nil {
+			return messageStamp{}, err
+		}
+	}
+	if _, err := recordEvent(ctx, tx, roomID, stamp.ID, senderID, eventMessageCreated, payloadJSON); err != nil {
+		return messageStamp{}, err
+	}
+	if err := enqueueWebhookEvent(ctx, tx, roomID, webhookEventMessageCreated, messageWebhookData(stamp.ID, senderID, stamp.CreatedAt)); err != nil {
+		return messageStamp{}, err
+	}
+	return stamp, nil
+}
+
+// messageWebhookData is the metadata exposed to webhooks; payloads stay
+// ciphertext-only and are never forwarded.
+func messageWebhookData(messageID, senderID int64, createdAt time.Time) map[string]any {
+	return map[string]any{
+		"messageId": messageID,
+		"senderId":  senderID,
+		"createdAt": createdAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// editConflictError rejects an edit made against a revision that is no
+// longer current, typically because another device of the sender edited or
+// revoked the message first.
+type editConflictError struct {
+	CurrentRevision int
+}
+
+func (e *editConflictError) Error() string {
+	return fmt.Sprintf("message is at revision %d", e.CurrentRevision)
+}
+
+// errMessageRevoked refuses edits to a revoked message, so an edit can never
+// bring back what the sender or a moderator took down.
+var errMessageRevoked = errors.New("message was revoked")
+
+// editMessage replaces the sender's own message payload; it returns
+// sql.ErrNoRows when the message does not exist or belongs to someone else
+// and errMessageRevoked once it was revoked. With expectedRevision set the
+// edit only applies if nobody changed the message since that revision,
+// otherwise it fails with *editConflictError.
+func (a *App) editMessage(ctx context.Context, roomID, messageID, senderID int64, payload CipherPayload, expectedRevision *int) (time.Time, int, int64, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return time.Time{}, 0, 0, err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var currentRevision int
+	var revoked bool
+	err = tx.QueryRowContext(ctx, `
+SELECT revision, revoked_at IS NOT NULL
+FROM messages
+WHERE id = $1 AND room_id = $2 AND sender_id = $3
+FOR UPDATE
+`, messageID, roomID, senderID).Scan(&currentRevision, &revoked)
+	if err != nil {
+		return time.Time{}, 0, 0, err
+	}
+	if revoked {
+		return time.Time{}, 0, 0, errMessageRevoked
+	}
+	if expectedRevision != nil && *expectedRevision != currentRevision {
+		return time.Time{}, 0, 0, &editConflictError{CurrentRevision: currentRevision}
+	}
+	// The replaced payload stays as a revision, so the new one is charged on
+	// top of it.
+	if err := a.chargeMessageQuotaTx(ctx, tx, senderID, 0, len(payloadJSON)); err != nil {
+		return time.Time{}, 0, 0, err
+	}
+
+	// Keep the version being replaced so clients can render edit history.
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO message_revisions(message_id, payload, authored_at)
+SELECT id, payload, COALESCE(edited_at, created_at)
+FROM messages
+WHERE id = $1
+`, messageID); err != nil {
+		return time.Time{}, 0, 0, err
+	}
+
+	var editedAt time.Time
+	var revision int
+	err = tx.QueryRowContext(ctx,
+		`UPDATE messages
+			 SET payload = $1::jsonb, edited_at = NOW(), revision = revision + 1
+			 WHERE id = $2
+			 RETURNING edited_at, revision`,
+		payloadJSON, messageID,
+	).Scan(&editedAt, &revision)
 	if err != nil {
-		return 0, time.Time{}, err
+		return time.Time{}, 0, 0, err
+	}
+	eventID, err := recordEvent(ctx, tx, roomID, messageID, senderID, eventMessageEdited, payloadJSON)
+	if err != nil {
+		return time.Time{}, 0, 0, err
 	}
 
-	return messageID, createdAt, nil
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, 0, 0, err
+	}
+	return editedAt, revision, eventID, nil
+}
+
+// revokeMessage revokes the sender's own message; it returns sql.ErrNoRows
+// when the message does not exist, belongs to someone else or is already revoked.
+func (a *App) revokeMessage(ctx context.Context, roomID, messageID, senderID int64) (time.Time, int64, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer tx.Rollback()
+
+	if err := releaseMessageQuotaTx(ctx, tx, `m.id = $1 AND m.room_id = $2 AND m.sender_id = $3`, messageID, roomID, senderID); err != nil {
+		return time.Time{}, 0, err
+	}
+	var revokedAt time.Time
+	err = tx.QueryRowContext(ctx,
+		`UPDATE messages
+			 SET revoked_at = NOW(), revoked_by = $3, edited_at = NULL, revision = revision + 1
+			 WHERE id = $1 AND room_id = $2 AND sender_id = $3 AND revoked_at IS NULL
+			 RETURNING revoked_at`,
+		messageID, roomID, senderID,
+	).Scan(&revokedAt)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	eventID, err := recordEvent(ctx, tx, roomID, messageID, senderID, eventMessageRevoked, nil)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, 0, err
+	}
+	return revokedAt, eventID, nil
+}
+
+// moderatorRevokeMessage revokes any member's message on behalf of a room
+// moderator; permission checks are the caller's responsibility.
+func (a *App) moderatorRevokeMessage(ctx context.Context, roomID, messageID, moderatorID int64) (int64, time.Time, int64, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	defer tx.Rollback()
+
+	if err := releaseMessageQuotaTx(ctx, tx, `m.id = $1 AND m.room_id = $2`, messageID, roomID); err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	var senderID int64
+	var revokedAt time.Time
+	err = tx.QueryRowContext(ctx,
+		`UPDATE messages
+			 SET revoked_at = NOW(), revoked_by = $3, edited_at = NULL, revision = revision + 1
+			 WHERE id = $1 AND room_id = $2 AND revoked_at IS NULL
+			 RETURNING sender_id, revoked_at`,
+		messageID, roomID, moderatorID,
+	).Scan(&senderID, &revokedAt)
+	if err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	eventID, err := recordEvent(ctx, tx, roomID, messageID, moderatorID, eventMessageRevoked, nil)
+	if err != nil {
+		return 0, time.Time{}, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	return senderID, revokedAt, eventID, nil
+}
+
+// applyReadReceipt advances the member's read cursor and logs a read_receipt
+// event when it moved; the event ID is 0 when the cursor was already past
+// upToMessageID. It returns sql.ErrNoRows when the message does not belong to
+// the room.
+func (a *App) applyReadReceipt(ctx context.Context, userID, roomID, upToMessageID int64) (int64, error) {
+	var found int64
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT id FROM messages WHERE id = $1 AND room_id = $2`,
+		upToMessageID, roomID,
+	).Scan(&found); err != nil {
+		return 0, err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE room_members SET last_read_message_id = $1 WHERE user_id = $2 AND room_id = $3 AND last_read_message_id < $1`,
+		upToMessageID, userID, roomID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if advanced, err := result.RowsAffected(); err != nil || advanced == 0 {
+		return 0, err
+	}
+	payload, err := json.Marshal(map[string]any{"upToMessageId": upToMessageID})
+	if err != nil {
+		return 0, err
+	}
+	eventID, err := recordEvent(ctx, tx, roomID, upToMessageID, userID, eventReadReceipt, payload)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return eventID, nil
+}
+
+func (a *App) listMessageRevisions(ctx context.Context, roomID, messageID int64) ([]MessageRevision, error) {
+	rows, err := a.readQuery(ctx, `
+SELECT mr.id, mr.payload, mr.authored_at, mr.replaced_at
+FROM message_revisions mr
+JOIN messages m ON m.id = mr.message_id
+WHERE mr.message_id = $1 AND m.room_id = $2
+ORDER BY mr.id ASC
+`, messageID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]MessageRevision, 0, 4)
+	for rows.Next() {
+		var item MessageRevision
+		var payloadRaw []byte
+		var authoredAt time.Time
+		var replacedAt time.Time
+		if err := rows.Scan(&item.ID, &payloadRaw, &authoredAt, &replacedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payloadRaw, &item.Payload); err != nil {
+			continue
+		}
+		item.AuthoredAt = authoredAt.UTC().Format(time.RFC3339Nano)
+		item.ReplacedAt = replacedAt.UTC().Format(time.RFC3339Nano)
+		revisions = append(revisions, item)
+	}
+	return revisions, rows.Err()
 }
 
 func (a *App) ensureRoomExists(ctx context.Context, roomID int64) error {
@@ -32,28 +352,144 @@ func (a *App) ensureRoomExists(ctx context.Context, roomID int64) error {
 }
 
 func (a *App) ensureMembership(ctx context.Context, userID, roomID int64) error {
+	if member, found := a.membership.Lookup(userID, roomID); found {
+		if !member {
+			return sql.ErrNoRows
+		}
+		return nil
+	}
 	var found int
-	return a.db.QueryRowContext(ctx,
+	err := a.db.QueryRowContext(ctx,
 		`SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2`,
 		roomID, userID,
 	).Scan(&found)
+	switch {
+	case err == nil:
+		a.membership.Store(userID, roomID, true)
+	case errors.Is(err, sql.ErrNoRows):
+		a.membership.Store(userID, roomID, false)
+	}
+	return err
 }
 
-func (a *App) ensureUserIdentity(ctx context.Context, userID int64, username string) (string, error) {
+type userIdentity struct {
+	Role        string
+	DisplayName string
+	Grant       roleGrant
+	// AcceptedPolicyVersion is the last policy version the user accepted;
+	// PublishedPolicyVersion is the newest one an admin published.
+	AcceptedPolicyVersion  int
+	PublishedPolicyVersion int
+}
+
+// ensureUserIdentity checks the token's user is still active under the same
+// username and returns its current role and display name.
+func (a *App) ensureUserIdentity(ctx context.Context, userID int64, username string) (userIdentity, error) {
+	var identity userIdentity
 	var storedUsername string
-	var role string
-	err := a.db.QueryRowContext(ctx,
-		`SELECT username, role FROM users WHERE id = $1`,
-		userID,
-	).Scan(&storedUsername, &role)
+	var suspended bool
+	var permissions []byte
+	err := a.db.QueryRowContext(ctx, `
+SELECT u.username, u.role, COALESCE(u.display_name, ''), u.suspended_at IS NOT NULL OR u.deleted_at IS NOT NULL,
+       COALESCE(u.custom_role, ''), COALESCE(r.version, 0), COALESCE(array_to_json(r.permissions), '[]'::json),
+       u.accepted_policy_version, COALESCE((SELECT MAX(version) FROM policy_versions), 0)
+FROM users u
+LEFT JOIN roles r ON r.name = u.custom_role
+WHERE u.id = $1
+`, userID).Scan(
+		&storedUsername, &identity.Role, &identity.DisplayName, &suspended, &identity.Grant.Name, &identity.Grant.Version, &permissions,
+		&identity.AcceptedPolicyVersion, &identity.PublishedPolicyVersion,
+	)
 	if err != nil {
-		return "", err
+		return userIdentity{}, err
+	}
+	if storedUsername != username || suspended {
+		return userIdentity{}, errInvalidIdentity
 	}
-	if storedUsername != username {
-		return "", errInvalidIdentity
+	if identity.Role != "admin" && identity.Role != "user" {
+		return userIdentity{}, errInvalidIdentity
 	}
-	if role != "admin" && role != "user" {
-		return "", errInvalidIdentity
+	if err := json.Unmarshal(permissions, &identity.Grant.Permissions); err != nil {
+		return userIdentity{}, err
+	}
+	return identity, nil
+}
+
+// listRoomMessages loads history rows for a room; clause continues the WHERE
+// after "m.room_id = $1" and must carry its own ORDER BY and LIMIT.
+func (a *App) listRoomMessages(ctx context.Context, clause string, args ...any) ([]StoredMessage, error) {
+	rows, err := a.readQuery(ctx, `
+SELECT m.id, m.room_id, m.room_seq, m.revision, m.sender_id, COALESCE(u.former_username, u.username), COALESCE(u.display_name, ''), u.deleted_at IS NOT NULL, m.payload, m.created_at, m.edited_at, m.revoked_at, m.revoked_by,
+       m.forwarded_from_room_id, m.forwarded_from_message_id,
+       (SELECT COALESCE(json_agg(mm.user_id ORDER BY mm.user_id), '[]'::json) FROM message_mentions mm WHERE mm.message_id = m.id)
+FROM messages m
+JOIN users u ON u.id = m.sender_id
+WHERE m.room_id = $1
+`+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]StoredMessage, 0, 64)
+	for rows.Next() {
+		message := StoredMessage{Kind: historyKindMessage}
+		var payloadRaw []byte
+		var createdAt time.Time
+		var editedAt sql.NullTime
+		var revokedAt sql.NullTime
+		var revokedBy sql.NullInt64
+		var forwardedFromRoomID sql.NullInt64
+		var forwardedFromMessageID sql.NullInt64
+		var mentionsRaw []byte
+		if err := rows.Scan(
+			&message.ID,
+			&message.RoomID,
+			&message.Seq,
+			&message.Revision,
+			&message.SenderID,
+			&message.SenderUsername,
+			&message.SenderDisplayName,
+			&message.SenderDeleted,
+			&payloadRaw,
+			&createdAt,
+			&editedAt,
+			&revokedAt,
+			&revokedBy,
+			&forwardedFromRoomID,
+			&forwardedFromMessageID,
+			&mentionsRaw,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payloadRaw, &message.Payload); err != nil {
+			continue
+		}
+		message.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		if editedAt.Valid {
+			value := editedAt.Time.UTC().Format(time.RFC3339Nano)
+			message.EditedAt = &value
+		}
+		if revokedAt.Valid {
+			value := revokedAt.Time.UTC().Format(time.RFC3339Nano)
+			message.RevokedAt = &value
+		}
+		if revokedBy.Valid {
+			value := revokedBy.Int64
+			message.RevokedBy = &value
+		}
+		if forwardedFromRoomID.Valid {
+			value := forwardedFromRoomID.Int64
+			message.ForwardedFromRoomID = &value
+		}
+		if forwardedFromMessageID.Valid {
+			value := forwardedFromMessageID.Int64
+			message.ForwardedFromMessageID = &value
+		}
+		if err := json.Unmarshal(mentionsRaw, &message.Mentions); err != nil {
+			message.Mentions = nil
+		}
+		messages = append(messages, message)
 	}
-	return role, nil
+	return messages, rows.Err()
 }
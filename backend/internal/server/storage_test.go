@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEditMessageRefusesRevokedMessage(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	adminID, roomID := seedSQLiteTestRoom(t, db)
+	senderID := insertSQLiteTestMember(t, db, roomID, "alice", "user")
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	stamp, err := insertMessageTx(ctx, tx, roomID, senderID, []byte(`{"ciphertext":"YQ=="}`), nil)
+	if err != nil {
+		t.Fatalf("insert message: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	app := &App{db: db}
+	if _, _, _, err := app.moderatorRevokeMessage(ctx, roomID, stamp.ID, adminID); err != nil {
+		t.Fatalf("moderator revoke: %v", err)
+	}
+	_, _, _, err = app.editMessage(ctx, roomID, stamp.ID, senderID, CipherPayload{Ciphertext: "Yg=="}, nil)
+	if !errors.Is(err, errMessageRevoked) {
+		t.Fatalf("expected errMessageRevoked, got %v", err)
+	}
+
+	var revokedBy, revisions int64
+	if err := db.QueryRowContext(ctx, `
+SELECT m.revoked_by, (SELECT COUNT(*) FROM message_revisions WHERE message_id = m.id)
+FROM messages m WHERE m.id = $1
+`, stamp.ID).Scan(&revokedBy, &revisions); err != nil {
+		t.Fatalf("load message: %v", err)
+	}
+	if revokedBy != adminID || revisions != 0 {
+		t.Fatalf("revoked_by = %d with %d revisions, want the admin's revoke to stand", revokedBy, revisions)
+	}
+}
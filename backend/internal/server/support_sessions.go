@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	supportTokenScope      = "support_readonly"
+	defaultSupportTokenTTL = 15 * time.Minute
+	maxSupportTokenTTL     = time.Hour
+	maxSupportReasonLength = 500
+)
+
+// SupportClaims let an admin look at another user's rooms and device records
+// on their behalf. The claim names differ from Claims, so a support token is
+// never accepted as a session token.
+type SupportClaims struct {
+	AdminID int64  `json:"sadm"`
+	UserID  int64  `json:"suid"`
+	Scope   string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+type supportContext struct {
+	AdminID int64
+	UserID  int64
+	TokenID string
+}
+
+func (a *App) issueSupportToken(adminID, userID int64, ttl time.Duration) (string, string, time.Time, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", time.Time{}, err
+	}
+	tokenID := hex.EncodeToString(raw)
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+	claims := SupportClaims{
+		AdminID: adminID,
+		UserID:  userID,
+		Scope:   supportTokenScope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "e2ee-chat-backend",
+			Subject:   strconv.FormatInt(userID, 10),
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.jwtSecret)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return signed, tokenID, expiresAt, nil
+}
+
+func (a *App) parseSupportToken(tokenString string) (*SupportClaims, error) {
+	claims := &SupportClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return a.jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid support token")
+	}
+	if claims.Scope != supportTokenScope || claims.AdminID <= 0 || claims.UserID <= 0 || claims.ID == "" {
+		return nil, errors.New("invalid support token claims")
+	}
+	return claims, nil
+}
+
+// handleAdminSupportToken issues a short-lived read-only token for one user.
+// A reason is required and recorded in the audit log with the token ID.
+func (a *App) handleAdminSupportToken(w http.ResponseWriter, r *http.Request, auth AuthContext, userID int64) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Reason     string `json:"reason"`
+		TTLMinutes int    `json:"ttlMinutes"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > maxSupportReasonLength {
+		respondError(w, http.StatusBadRequest, "reason is required and must be at most 500 characters")
+		return
+	}
+	ttl := defaultSupportTokenTTL
+	if req.TTLMinutes != 0 {
+		ttl = time.Duration(req.TTLMinutes) * time.Minute
+		if req.TTLMinutes < 0 || ttl > maxSupportTokenTTL {
+			respondError(w, http.StatusBadRequest, "ttlMinutes must be between 1 and 60")
+			return
+		}
+	}
+	if userID == auth.UserID {
+		respondError(w, http.StatusBadRequest, "support tokens are for other users")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var deleted bool
+	if err := a.db.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&deleted); err != nil || deleted {
+		respondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	token, tokenID, expiresAt, err := a.issueSupportToken(auth.UserID, userID, ttl)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to issue support token")
+		return
+	}
+	if err := recordAdminAudit(ctx, a.db, auth.UserID, auditSupportTokenIssued, userID, map[string]any{
+		"tokenId":   tokenID,
+		"reason":    req.Reason,
+		"expiresAt": expiresAt.Format(time.RFC3339Nano),
+	}); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to record audit entry")
+		return
+	}
+	requestLogger(r.Context()).Info("support_token_issued",
+		"admin_id", auth.UserID,
+		"user_id", userID,
+		"token_id", tokenID,
+	)
+	respondJSON(w, http.StatusOK, map[string]any{
+		"supportToken": token,
+		"tokenId":      tokenID,
+		"userId":       userID,
+		"expiresAt":    expiresAt.Format(time.RFC3339Nano),
+	})
+}
+
+// withSupportAuth accepts a support token as a bearer token only, re-checks
+// that its issuer is still active and still holds manage_users under their
+// current role, and audits every request made with it before the handler
+// runs. Support routes live under /api/admin/ so the admin IP filter applies
+// to them too.
+func (a *App) withSupportAuth(next func(http.ResponseWriter, *http.Request, supportContext)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString, source := authTokenFromRequest(r)
+		if tokenString == "" || source != "bearer" {
+			respondError(w, http.StatusUnauthorized, "support token required")
+			return
+		}
+		claims, err := a.parseSupportToken(tokenString)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, "invalid support token")
+			return
+		}
+		if r.Method != http.MethodGet {
+			respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+
+		issuer := AuthContext{UserID: claims.AdminID}
+		var inactive bool
+		var permissions []byte
+		err = a.db.QueryRowContext(ctx, `
+SELECT u.role, u.suspended_at IS NOT NULL OR u.deleted_at IS NOT NULL, COALESCE(array_to_json(r.permissions), '[]'::json)
+FROM users u
+LEFT JOIN roles r ON r.name = u.custom_role
+WHERE u.id = $1
+`, claims.AdminID).Scan(&issuer.Role, &inactive, &permissions)
+		if err == nil {
+			err = json.Unmarshal(permissions, &issuer.Permissions)
+		}
+		if err != nil || inactive || !issuer.Can(permManageUsers) {
+			respondError(w, http.StatusUnauthorized, "invalid support token")
+			return
+		}
+		if err := recordAdminAudit(ctx, a.db, claims.AdminID, auditSupportAccess, claims.UserID, map[string]any{
+			"tokenId": claims.ID,
+			"path":    r.URL.Path,
+		}); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to record audit entry")
+			return
+		}
+		requestLogger(r.Context()).Info("support_access",
+			"admin_id", claims.AdminID,
+			"user_id", claims.UserID,
+			"token_id", claims.ID,
+			"path", r.URL.Path,
+		)
+		next(w, r, supportContext{AdminID: claims.AdminID, UserID: claims.UserID, TokenID: claims.ID})
+	}
+}
+
+// handleSupportRooms lists the rooms the supported user belongs to. Only room
+// metadata is returned; messages stay out of reach of support tokens.
+func (a *App) handleSupportRooms(w http.ResponseWriter, r *http.Request, support supportContext) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT r.id, r.name, r.created_at, r.announcement_only, rm.joined_at
+FROM rooms r
+JOIN room_members rm ON rm.room_id = r.id
+WHERE rm.user_id = $1
+ORDER BY r.id ASC
+`, support.UserID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to fetch rooms")
+		return
+	}
+	defer rows.Close()
+
+	type supportRoom struct {
+		ID               int64  `json:"id"`
+		Name             string `json:"name"`
+		CreatedAt        string `json:"createdAt"`
+		AnnouncementOnly bool   `json:"announcementOnly"`
+		JoinedAt         string `json:"joinedAt"`
+	}
+	rooms := []supportRoom{}
+	for rows.Next() {
+		var room supportRoom
+		var createdAt, joinedAt time.Time
+		if err := rows.Scan(&room.ID, &room.Name, &createdAt, &room.AnnouncementOnly, &joinedAt); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to decode rooms")
+			return
+		}
+		room.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		room.JoinedAt = joinedAt.UTC().Format(time.RFC3339Nano)
+		rooms = append(rooms, room)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"userId": support.UserID, "rooms": rooms})
+}
+
+func (a *App) handleSupportDevices(w http.ResponseWriter, r *http.Request, support supportContext) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	devices, err := a.listUserDevices(ctx, support.UserID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load devices")
+		return
+	}
+	response := make([]DeviceSnapshot, 0, len(devices))
+	for _, item := range devices {
+		response = append(response, toDeviceSnapshot(item, ""))
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"userId": support.UserID, "devices": response})
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupportTokensAreNotSessionTokens(t *testing.T) {
+	t.Parallel()
+
+	app := &App{jwtSecret: []byte("0123456789abcdef0123456789abcdef")}
+	token, tokenID, _, err := app.issueSupportToken(1, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("issue support token: %v", err)
+	}
+	claims, err := app.parseSupportToken(token)
+	if err != nil || claims.AdminID != 1 || claims.UserID != 2 || claims.ID != tokenID {
+		t.Fatalf("expected support token to parse, got %+v %v", claims, err)
+	}
+	if _, err := app.parseToken(token); err == nil {
+		t.Fatal("expected support token to be rejected as a session token")
+	}
+
+	session, err := app.issueToken(1, "admin", "admin", roleGrant{}, "device-1", 1)
+	if err != nil {
+		t.Fatalf("issue session token: %v", err)
+	}
+	if _, err := app.parseSupportToken(session); err == nil {
+		t.Fatal("expected session token to be rejected as a support token")
+	}
+}
+
+func TestWithSupportAuthRejectsMissingOrCookieTokens(t *testing.T) {
+	t.Parallel()
+
+	app := &App{jwtSecret: []byte("0123456789abcdef0123456789abcdef")}
+	handler := app.withSupportAuth(func(w http.ResponseWriter, r *http.Request, support supportContext) {
+		t.Fatal("handler must not run")
+	})
+	token, _, _, err := app.issueSupportToken(1, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("issue support token: %v", err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/admin/support/rooms", nil)
+	request.AddCookie(&http.Cookie{Name: authCookieName, Value: token})
+	response := httptest.NewRecorder()
+	handler(response, request)
+	if response.Code != http.StatusUnauthorized {
+		t.Fatalf("expected cookie token to be rejected, got %d", response.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/api/admin/support/rooms", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	response = httptest.NewRecorder()
+	handler(response, request)
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected writes to be refused, got %d", response.Code)
+	}
+}
+
+func TestWithSupportAuthRechecksIssuerPermission(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLiteTestDB(t)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `INSERT INTO roles(name, permissions) VALUES ($1, $2)`, "helpdesk", []string{permManageUsers}); err != nil {
+		t.Fatalf("insert role: %v", err)
+	}
+	var issuerID, userID int64
+	if err := db.QueryRowContext(ctx,
+		`INSERT INTO users(username, password_hash, custom_role) VALUES ($1, $2, $3) RETURNING id`, "helper", "hash", "helpdesk",
+	).Scan(&issuerID); err != nil {
+		t.Fatalf("insert issuer: %v", err)
+	}
+	if err := db.QueryRowContext(ctx,
+		`INSERT INTO users(username, password_hash) VALUES ($1, $2) RETURNING id`, "alice", "hash",
+	).Scan(&userID); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+
+	app := &App{db: db, jwtSecret: []byte("0123456789abcdef0123456789abcdef")}
+	handler := app.withSupportAuth(func(w http.ResponseWriter, r *http.Request, support supportContext) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	token, _, _, err := app.issueSupportToken(issuerID, userID, time.Minute)
+	if err != nil {
+		t.Fatalf("issue support token: %v", err)
+	}
+	use := func() int {
+		request := httptest.NewRequest(http.MethodGet, "/api/admin/support/rooms", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+		handler(response, request)
+		return response.Code
+	}
+
+	if code := use(); code != http.StatusNoContent {
+		t.Fatalf("expected a manage_users grant to use the token, got %d", code)
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE roles SET permissions = $2 WHERE name = $1`, "helpdesk", []string{permViewAudit}); err != nil {
+		t.Fatalf("update role: %v", err)
+	}
+	if code := use(); code != http.StatusUnauthorized {
+		t.Fatalf("expected the token to stop working once manage_users was revoked, got %d", code)
+	}
+}
+
+func TestHandleAdminSupportTokenValidation(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	for _, tc := range []struct {
+		body   string
+		userID int64
+	}{
+		{`{}`, 2},
+		{`{"reason":"   "}`, 2},
+		{`{"reason":"` + strings.Repeat("a", maxSupportReasonLength+1) + `"}`, 2},
+		{`{"reason":"ticket 42","ttlMinutes":61}`, 2},
+		{`{"reason":"ticket 42"}`, 1},
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/api/admin/users/2/support-token", strings.NewReader(tc.body))
+		response := httptest.NewRecorder()
+		app.handleAdminSupportToken(response, request, AuthContext{UserID: 1, Role: "admin"}, tc.userID)
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected %d, got %d", tc.body, http.StatusBadRequest, response.Code)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The TCP gateway serves embedded clients that cannot perform an HTTP
+// upgrade. Every line in either direction is one JSON frame, the same frames
+// /ws carries, and connections join the same hub. The first line a client
+// sends replaces the upgrade request:
+//
+//	{"type":"hello","token":"<access token>","roomId":1,"snapshot":50}
+//
+// The server answers with a welcome frame, or with an error frame before
+// closing. Keepalives are {"type":"ping","data":"..."} lines, answered with
+// a pong line echoing data; close frames carry the WebSocket close code.
+const (
+	tcpGatewayHelloTimeout  = 10 * time.Second
+	tcpGatewayHelloMaxBytes = 8 << 10
+	// Lines up to this size are checked for pong frames before dispatch.
+	lineConnControlMaxBytes = 256
+)
+
+var errLineTooLong = errors.New("line exceeds read limit")
+
+type tcpGatewayConfig struct {
+	Addr     string
+	CertFile string
+	KeyFile  string
+}
+
+func (cfg tcpGatewayConfig) enabled() bool {
+	return cfg.Addr != ""
+}
+
+func validateTCPGatewayConfig(cfg tcpGatewayConfig) error {
+	if !cfg.enabled() {
+		if cfg.CertFile != "" || cfg.KeyFile != "" {
+			return errors.New("TCP_GATEWAY_ADDR must be set when TCP_GATEWAY_TLS_* settings are")
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		return fmt.Errorf("TCP_GATEWAY_ADDR: %w", err)
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return errors.New("TCP_GATEWAY_TLS_CERT_FILE and TCP_GATEWAY_TLS_KEY_FILE are required when TCP_GATEWAY_ADDR is set")
+	}
+	return nil
+}
+
+type tcpGatewayHello struct {
+	Type     string `json:"type"`
+	Token    string `json:"token"`
+	RoomID   int64  `json:"roomId"`
+	Snapshot int    `json:"snapshot"`
+}
+
+type tcpGateway struct {
+	app      *App
+	listener net.Listener
+	closing  atomic.Bool
+	done     chan struct{}
+}
+
+func newTCPGateway(app *App, cfg tcpGatewayConfig) (*tcpGateway, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tcp gateway certificate: %w", err)
+	}
+	listener, err := tls.Listen("tcp", cfg.Addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newTCPGatewayWithListener(app, listener), nil
+}
+
+func newTCPGatewayWithListener(app *App, listener net.Listener) *tcpGateway {
+	return &tcpGateway{app: app, listener: listener, done: make(chan struct{})}
+}
+
+func (g *tcpGateway) Start() {
+	go g.acceptLoop()
+}
+
+// Stop closes the listener. Connections already in the hub are closed with
+// it on shutdown.
+func (g *tcpGateway) Stop() {
+	if g.closing.Swap(true) {
+		return
+	}
+	_ = g.listener.Close()
+	<-g.done
+}
+
+func (g *tcpGateway) Addr() net.Addr {
+	return g.listener.Addr()
+}
+
+func (g *tcpGateway) acceptLoop() {
+	defer close(g.done)
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			if g.closing.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn("tcp_gateway_accept_failed", "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go g.serve(conn)
+	}
+}
+
+// serve admits one connection with the same checks handleWS runs, then hands
+// it to the shared read and write pumps.
+func (g *tcpGateway) serve(netConn net.Conn) {
+	a := g.app
+	conn := newLineConn(netConn)
+	remoteIP := normalizeClientIPCandidate(netConn.RemoteAddr().String())
+	if remoteIP == "" {
+		remoteIP = "unknown"
+	}
+	reject := func(code string, message string) {
+		_ = conn.writeFrame(map[string]any{"type": "error", "code": code, "message": message})
+		_ = conn.Close()
+	}
+
+	if a.draining.Load() {
+		reject("server_draining", "server is restarting")
+		return
+	}
+	if a.maintenance.Enabled() {
+		_ = conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(wsCloseMaintenance, a.maintenance.State().Reason),
+			time.Now().Add(time.Second),
+		)
+		_ = conn.Close()
+		return
+	}
+	if allowed, _ := a.wsConnectLimiter.Check(remoteIP); !allowed {
+		reject(errCodeRateLimited, "too many connection attempts")
+		return
+	}
+
+	_ = netConn.SetDeadline(time.Now().Add(tcpGatewayHelloTimeout))
+	conn.SetReadLimit(tcpGatewayHelloMaxBytes)
+	_, raw, err := conn.ReadMessage()
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	var hello tcpGatewayHello
+	if err := json.Unmarshal(raw, &hello); err != nil || hello.Type != "hello" {
+		reject(errCodeBadRequest, "expected hello frame")
+		return
+	}
+	if hello.Token == "" {
+		reject(errCodeUnauthorized, "authorization required")
+		return
+	}
+	claims, err := a.parseToken(hello.Token)
+	if err != nil {
+		reject(errCodeUnauthorized, "invalid token")
+		return
+	}
+	if hello.RoomID <= 0 {
+		reject(errCodeBadRequest, "invalid roomId")
+		return
+	}
+	if hello.Snapshot < 0 || hello.Snapshot > maxWSSnapshotMessages {
+		reject(errCodeBadRequest, errInvalidSnapshot.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	session, err := a.admitRoomSession(ctx, claims, hello.RoomID)
+	if err != nil {
+		var admissionErr *roomAdmissionError
+		if errors.As(err, &admissionErr) {
+			code := admissionErr.Code
+			if code == "" {
+				code = errorCodeForStatus(admissionErr.Status)
+			}
+			reject(code, admissionErr.Message)
+			return
+		}
+		logger.Error("tcp_gateway_admission_failed", "room_id", hello.RoomID, "error", err)
+		reject(errCodeInternal, "failed to validate room session")
+		return
+	}
+	_ = netConn.SetDeadline(time.Time{})
+	if err := conn.writeFrame(map[string]any{
+		"type":     "welcome",
+		"roomId":   session.roomID,
+		"userId":   session.claims.UserID,
+		"deviceId": session.device.DeviceID,
+	}); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	client := session.newClient(a, conn, newRequestID(), remoteIP)
+	a.joinRoom(ctx, client, hello.Snapshot)
+	go client.writePump()
+	client.readPump()
+}
+
+// lineConn adapts a stream connection to wsConn so gateway clients run
+// through the same pumps and frame handlers as WebSocket clients.
+type lineConn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	readLimit   int64
+	pongHandler func(appData string) error
+
+	writeMu sync.Mutex
+}
+
+var _ wsConn = (*lineConn)(nil)
+
+func newLineConn(conn net.Conn) *lineConn {
+	return &lineConn{
+		conn:      conn,
+		reader:    bufio.NewReader(conn),
+		readLimit: tcpGatewayHelloMaxBytes,
+	}
+}
+
+// ReadMessage returns the next frame line. Pong lines are consumed here, as
+// gorilla consumes pong control frames, and a clean EOF reads as a normal
+// close.
+func (l *lineConn) ReadMessage() (int, []byte, error) {
+	for {
+		line, err := l.readLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure, Text: "eof"}
+			}
+			return 0, nil, err
+		}
+		line = trimLine(line)
+		if len(line) == 0 {
+			continue
+		}
+		if len(line) <= lineConnControlMaxBytes {
+			var control struct {
+				Type string `json:"type"`
+				Data string `json:"data"`
+			}
+			if json.Unmarshal(line, &control) == nil && control.Type == "pong" {
+				if l.pongHandler != nil {
+					if err := l.pongHandler(control.Data); err != nil {
+						return 0, nil, err
+					}
+				}
+				continue
+			}
+		}
+		return websocket.TextMessage, line, nil
+	}
+}
+
+func (l *lineConn) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := l.reader.ReadSlice('\n')
+		if int64(len(line)+len(chunk)) > l.readLimit+1 {
+			return nil, errLineTooLong
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			return line, nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+}
+
+func trimLine(line []byte) []byte {
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+func (l *lineConn) WriteMessage(messageType int, data []byte) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	return l.writeLocked(messageType, data)
+}
+
+func (l *lineConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	_ = l.conn.SetWriteDeadline(deadline)
+	return l.writeLocked(messageType, data)
+}
+
+func (l *lineConn) writeLocked(messageType int, data []byte) error {
+	switch messageType {
+	case websocket.TextMessage, websocket.BinaryMessage:
+		buffers := net.Buffers{data, []byte{'\n'}}
+		_, err := buffers.WriteTo(l.conn)
+		return err
+	case websocket.PingMessage:
+		return l.writeJSONLocked(map[string]any{"type": "ping", "data": string(data)})
+	case websocket.CloseMessage:
+		frame := map[string]any{"type": "close"}
+		if len(data) >= 2 {
+			frame["code"] = binary.BigEndian.Uint16(data[:2])
+			frame["reason"] = string(data[2:])
+		}
+		return l.writeJSONLocked(frame)
+	default:
+		return nil
+	}
+}
+
+func (l *lineConn) writeJSONLocked(frame any) error {
+	encoded, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	_, err = l.conn.Write(append(encoded, '\n'))
+	return err
+}
+
+// writeFrame writes a frame outside the pumps, during the hello exchange.
+func (l *lineConn) writeFrame(frame any) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	return l.writeJSONLocked(frame)
+}
+
+func (l *lineConn) SetReadLimit(limit int64) {
+	l.readLimit = limit
+}
+
+func (l *lineConn) SetReadDeadline(t time.Time) error {
+	return l.conn.SetReadDeadline(t)
+}
+
+func (l *lineConn) SetWriteDeadline(t time.Time) error {
+	return l.conn.SetWriteDeadline(t)
+}
+
+func (l *lineConn) SetPongHandler(h func(appData string) error) {
+	l.pongHandler = h
+}
+
+// EnableWriteCompression is a no-op: compression is a WebSocket extension
+// and lines go out as written.
+func (l *lineConn) EnableWriteCompression(bool) {}
+
+func (l *lineConn) RemoteAddr() net.Addr {
+	return l.conn.RemoteAddr()
+}
+
+func (l *lineConn) Close() error {
+	return l.conn.Close()
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLineConnFrames(t *testing.T) {
+	t.Parallel()
+
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	conn := newLineConn(serverSide)
+	defer conn.Close()
+	peer := bufio.NewReader(clientSide)
+
+	go func() {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"room_peers"}`))
+		_ = conn.WriteMessage(websocket.PingMessage, pingPayload(time.Unix(0, 42)))
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(wsCloseMaintenance, "upgrade"), time.Now().Add(time.Second))
+	}()
+	for _, want := range []string{
+		`{"type":"room_peers"}`,
+		`{"data":"42","type":"ping"}`,
+		`{"code":4503,"reason":"upgrade","type":"close"}`,
+	} {
+		line, err := peer.ReadString('\n')
+		if err != nil || strings.TrimSpace(line) != want {
+			t.Fatalf("expected %s, got %q err=%v", want, line, err)
+		}
+	}
+
+	var pong string
+	conn.SetPongHandler(func(appData string) error {
+		pong = appData
+		return nil
+	})
+	go func() {
+		_, _ = clientSide.Write([]byte("{\"type\":\"pong\",\"data\":\"42\"}\r\n\n{\"type\":\"typing_status\"}\n"))
+	}()
+	messageType, raw, err := conn.ReadMessage()
+	if err != nil || messageType != websocket.TextMessage || string(raw) != `{"type":"typing_status"}` {
+		t.Fatalf("unexpected frame type=%d raw=%s err=%v", messageType, raw, err)
+	}
+	if pong != "42" {
+		t.Fatalf("expected pong handler to see 42, got %q", pong)
+	}
+
+	conn.SetReadLimit(16)
+	go func() {
+		_, _ = clientSide.Write([]byte(`{"type":"ciphertext","ciphertext":"too long"}` + "\n"))
+	}()
+	if _, _, err := conn.ReadMessage(); !errors.Is(err, errLineTooLong) {
+		t.Fatalf("expected errLineTooLong, got %v", err)
+	}
+
+	_ = clientSide.Close()
+	var closeErr *websocket.CloseError
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) {
+		t.Fatalf("expected a close error at eof, got %v", err)
+	}
+}
+
+func TestTCPGatewayRejectsBeforeAdmission(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	app := &App{
+		hub:              NewHub(),
+		jwtSecret:        []byte("test-secret-test-secret-test-secret"),
+		wsConnectLimiter: newKeyedRateLimiter(perMinuteLimit(600), 100, time.Minute),
+	}
+	gateway := newTCPGatewayWithListener(app, listener)
+	gateway.Start()
+	defer gateway.Stop()
+
+	cases := []struct {
+		hello string
+		code  string
+	}{
+		{hello: `GET /ws HTTP/1.1`, code: errCodeBadRequest},
+		{hello: `{"type":"hello","roomId":1}`, code: errCodeUnauthorized},
+		{hello: `{"type":"hello","token":"not-a-jwt","roomId":1}`, code: errCodeUnauthorized},
+	}
+	for _, tc := range cases {
+		conn, err := net.Dial("tcp", gateway.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write([]byte(tc.hello + "\n")); err != nil {
+			t.Fatalf("write hello: %v", err)
+		}
+		line, err := bufio.NewReader(conn).ReadBytes('\n')
+		_ = conn.Close()
+		if err != nil {
+			t.Fatalf("read reply to %s: %v", tc.hello, err)
+		}
+		var frame map[string]any
+		if err := json.Unmarshal(line, &frame); err != nil {
+			t.Fatalf("decode reply %s: %v", line, err)
+		}
+		if frame["type"] != "error" || frame["code"] != tc.code {
+			t.Fatalf("hello %s: expected %s error, got %s", tc.hello, tc.code, line)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultTracingServiceName = "e2ee-chat-backend"
+	tracingScopeName          = "e2ee-chat/backend"
+	tracingQueueSize          = 4096
+	tracingBatchSize          = 512
+	tracingFlushInterval      = 5 * time.Second
+	tracingExportTimeout      = 10 * time.Second
+	traceparentHeader         = "traceparent"
+)
+
+type spanKind int
+
+// OTLP span kinds.
+const (
+	spanKindServer spanKind = 2
+	spanKindClient spanKind = 3
+)
+
+// tracingConfig follows the standard OTEL_* variables so collectors can be
+// wired without product-specific settings. Endpoint is the full OTLP/HTTP
+// traces URL.
+type tracingConfig struct {
+	Endpoint    string
+	ServiceName string
+	Headers     map[string]string
+}
+
+func (cfg tracingConfig) enabled() bool {
+	return cfg.Endpoint != ""
+}
+
+// tracingEndpoint prefers the traces-specific URL and otherwise appends the
+// OTLP traces path to the base endpoint, as the OTel spec describes.
+func tracingEndpoint(baseEndpoint, tracesEndpoint string) string {
+	if tracesEndpoint != "" {
+		return tracesEndpoint
+	}
+	if baseEndpoint == "" {
+		return ""
+	}
+	return strings.TrimRight(baseEndpoint, "/") + "/v1/traces"
+}
+
+// parseOTLPHeaders reads the comma-separated key=value list used by
+// OTEL_EXPORTER_OTLP_HEADERS.
+func parseOTLPHeaders(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, found := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("header %q must be key=value", pair)
+		}
+		decoded, err := url.QueryUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w", key, err)
+		}
+		headers[key] = decoded
+	}
+	return headers, nil
+}
+
+func validateTracingConfig(cfg tracingConfig) error {
+	if !cfg.enabled() {
+		return nil
+	}
+	parsed, err := url.Parse(cfg.Endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("OTEL_EXPORTER_OTLP_ENDPOINT must be an http(s) URL")
+	}
+	return nil
+}
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type spanContextKey struct{}
+
+func spanContextFromContext(ctx context.Context) (spanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(spanContext)
+	return sc, ok
+}
+
+// parseTraceparent reads a W3C traceparent header. Unknown versions are
+// rejected rather than guessed at.
+func parseTraceparent(value string) (spanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return spanContext{}, false
+	}
+	var sc spanContext
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil || sc.traceID == [16]byte{} {
+		return spanContext{}, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil || sc.spanID == [8]byte{} {
+		return spanContext{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return spanContext{}, false
+	}
+	sc.sampled = flags&0x01 == 1
+	return sc, true
+}
+
+type spanAttr struct {
+	Key   string
+	Value any
+}
+
+func attrString(key, value string) spanAttr    { return spanAttr{Key: key, Value: value} }
+func attrInt(key string, value int64) spanAttr { return spanAttr{Key: key, Value: value} }
+func attrBool(key string, value bool) spanAttr { return spanAttr{Key: key, Value: value} }
+
+// span is a single in-flight operation. A nil span is valid and ignores every
+// call, which is what callers get when tracing is off or the trace is not
+// sampled.
+type span struct {
+	tracer   *tracer
+	sc       spanContext
+	parentID [8]byte
+	name     string
+	kind     spanKind
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs []spanAttr
+	ended bool
+}
+
+func (s *span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+func (s *span) SetAttributes(attrs ...spanAttr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// End records the span, marking it failed when err is non-nil. Only the
+// first call has an effect.
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	finished := finishedSpan{
+		sc:       s.sc,
+		parentID: s.parentID,
+		name:     s.name,
+		kind:     s.kind,
+		start:    s.start,
+		end:      end,
+		attrs:    s.attrs,
+	}
+	s.mu.Unlock()
+	if err != nil {
+		finished.errMessage = err.Error()
+		finished.failed = true
+	}
+	s.tracer.record(finished)
+}
+
+type finishedSpan struct {
+	sc         spanContext
+	parentID   [8]byte
+	name       string
+	kind       spanKind
+	start      time.Time
+	end        time.Time
+	attrs      []spanAttr
+	failed     bool
+	errMessage string
+}
+
+// tracer exports finished spans to an OTLP/HTTP collector in batches. Spans
+// are dropped rather than blocking request paths when the queue is full.
+type tracer struct {
+	cfg    tracingConfig
+	client *http.Client
+	queue  chan finishedSpan
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newTracer(cfg tracingConfig) *tracer {
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaultTracingServiceName
+	}
+	return &tracer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: tracingExportTimeout},
+		queue:  make(chan finishedSpan, tracingQueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// StartSpan begins a child of the span in ctx, or a new root when there is
+// none. It returns ctx unchanged and a nil span when t is nil.
+func (t *tracer) StartSpan(ctx context.Context, name string, kind spanKind, attrs ...spanAttr) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent, hasParent := spanContextFromContext(ctx)
+	sc := spanContext{sampled: true}
+	if hasParent {
+		sc.traceID = parent.traceID
+		sc.sampled = parent.sampled
+	} else {
+		randomBytes(sc.traceID[:])
+	}
+	randomBytes(sc.spanID[:])
+	ctx = context.WithValue(ctx, spanContextKey{}, sc)
+	if !sc.sampled {
+		return ctx, nil
+	}
+	s := &span{
+		tracer: t,
+		sc:     sc,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	if hasParent {
+		s.parentID = parent.spanID
+	}
+	return ctx, s
+}
+
+func randomBytes(buf []byte) {
+	if _, err := rand.Read(buf); err != nil {
+		buf[0] = 1
+	}
+}
+
+func (t *tracer) record(finished finishedSpan) {
+	select {
+	case t.queue <- finished:
+	default:
+	}
+}
+
+func (t *tracer) Start() {
+	go t.run()
+}
+
+// Stop flushes queued spans before returning.
+func (t *tracer) Stop() {
+	if t == nil {
+		return
+	}
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+	<-t.done
+}
+
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(tracingFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]finishedSpan, 0, tracingBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			logger.Warn("otlp_export_failed", "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case finished := <-t.queue:
+			batch = append(batch, finished)
+			if len(batch) >= tracingBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			for {
+				select {
+				case finished := <-t.queue:
+					batch = append(batch, finished)
+					if len(batch) >= tracingBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (t *tracer) export(batch []finishedSpan) error {
+	body, err := json.Marshal(encodeOTLPTraces(t.cfg.ServiceName, batch))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingExportTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range t.cfg.Headers {
+		request.Header.Set(key, value)
+	}
+	response, err := t.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// OTLP/JSON wire types. IDs are hex and 64-bit integers are strings, per the
+// OTLP JSON mapping.
+type otlpTracesRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+const otlpStatusError = 2
+
+func encodeOTLPTraces(serviceName string, batch []finishedSpan) otlpTracesRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, finished := range batch {
+		item := otlpSpan{
+			TraceID:           hex.EncodeToString(finished.sc.traceID[:]),
+			SpanID:            hex.EncodeToString(finished.sc.spanID[:]),
+			Name:              finished.name,
+			Kind:              int(finished.kind),
+			StartTimeUnixNano: strconv.FormatInt(finished.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(finished.end.UnixNano(), 10),
+			Attributes:        encodeOTLPAttributes(finished.attrs),
+		}
+		if finished.parentID != [8]byte{} {
+			item.ParentSpanID = hex.EncodeToString(finished.parentID[:])
+		}
+		if finished.failed {
+			item.Status = otlpStatus{Code: otlpStatusError, Message: finished.errMessage}
+		}
+		spans = append(spans, item)
+	}
+	return otlpTracesRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: encodeOTLPAttributes([]spanAttr{attrString("service.name", serviceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: tracingScopeName}, Spans: spans}},
+	}}}
+}
+
+func encodeOTLPAttributes(attrs []spanAttr) []otlpKeyValue {
+	encoded := make([]otlpKeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		var value otlpAnyValue
+		switch typed := attr.Value.(type) {
+		case string:
+			value.StringValue = &typed
+		case int64:
+			formatted := strconv.FormatInt(typed, 10)
+			value.IntValue = &formatted
+		case bool:
+			value.BoolValue = &typed
+		default:
+			formatted := fmt.Sprint(typed)
+			value.StringValue = &formatted
+		}
+		encoded = append(encoded, otlpKeyValue{Key: attr.Key, Value: value})
+	}
+	return encoded
+}
+
+// withTracing opens a server span per request, continuing the caller's trace
+// when a valid traceparent is supplied.
+func (a *App) withTracing(next http.Handler) http.Handler {
+	if a.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A socket can stay open for hours; its frames get their own spans.
+		if websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if remote, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+			ctx = context.WithValue(ctx, spanContextKey{}, remote)
+		}
+		ctx, s := a.tracer.StartSpan(ctx, r.Method, spanKindServer,
+			attrString("http.request.method", r.Method),
+			attrString("url.path", r.URL.Path),
+			attrString("request_id", requestIDFromContext(ctx)),
+		)
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		request := r.WithContext(ctx)
+		next.ServeHTTP(recorder, request)
+
+		// ServeMux records the matched pattern on the request it was handed,
+		// which keeps span names low-cardinality.
+		if request.Pattern != "" {
+			s.SetName(r.Method + " " + request.Pattern)
+		}
+		s.SetAttributes(attrInt("http.response.status_code", int64(recorder.statusCode)))
+		var err error
+		if recorder.statusCode >= http.StatusInternalServerError {
+			err = errors.New(http.StatusText(recorder.statusCode))
+		}
+		s.End(err)
+	})
+}
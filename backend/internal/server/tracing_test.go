@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+
+	sc, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || !sc.sampled {
+		t.Fatalf("expected sampled traceparent to parse")
+	}
+	if hex.EncodeToString(sc.traceID[:]) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("unexpected trace id %x", sc.traceID)
+	}
+
+	for _, value := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	} {
+		if _, ok := parseTraceparent(value); ok {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestTracingConfigFromEnvValues(t *testing.T) {
+	t.Parallel()
+
+	if got := tracingEndpoint("http://collector:4318/", ""); got != "http://collector:4318/v1/traces" {
+		t.Fatalf("unexpected endpoint %q", got)
+	}
+	if got := tracingEndpoint("http://collector:4318", "http://traces:4318/custom"); got != "http://traces:4318/custom" {
+		t.Fatalf("expected traces endpoint to win, got %q", got)
+	}
+	headers, err := parseOTLPHeaders("api-key=abc%3D, x-tenant = ops")
+	if err != nil {
+		t.Fatalf("parseOTLPHeaders: %v", err)
+	}
+	if headers["api-key"] != "abc=" || headers["x-tenant"] != "ops" {
+		t.Fatalf("unexpected headers %v", headers)
+	}
+	if _, err := parseOTLPHeaders("missing-value"); err == nil {
+		t.Fatalf("expected malformed header to be rejected")
+	}
+	if err := validateTracingConfig(tracingConfig{Endpoint: "collector:4318"}); err == nil {
+		t.Fatalf("expected endpoint without scheme to be rejected")
+	}
+}
+
+func TestTracerParentsSpansAndHonorsSampling(t *testing.T) {
+	t.Parallel()
+
+	var disabled *tracer
+	ctx, s := disabled.StartSpan(context.Background(), "noop", spanKindServer)
+	if s != nil || ctx != context.Background() {
+		t.Fatalf("expected nil tracer to be a no-op")
+	}
+	s.End(nil)
+
+	tr := newTracer(tracingConfig{Endpoint: "http://collector/v1/traces"})
+	ctx, root := tr.StartSpan(context.Background(), "root", spanKindServer)
+	_, child := tr.StartSpan(ctx, "child", spanKindClient, attrInt("room_id", 7))
+	child.End(nil)
+	root.End(context.DeadlineExceeded)
+	root.End(nil)
+
+	if len(tr.queue) != 2 {
+		t.Fatalf("expected 2 finished spans, got %d", len(tr.queue))
+	}
+	first, second := <-tr.queue, <-tr.queue
+	if first.sc.traceID != second.sc.traceID || first.parentID != second.sc.spanID {
+		t.Fatalf("expected child to share the trace and point at the root")
+	}
+	if !second.failed {
+		t.Fatalf("expected root span to record the error")
+	}
+
+	unsampled := context.WithValue(context.Background(), spanContextKey{}, spanContext{traceID: [16]byte{1}, spanID: [8]byte{1}})
+	if _, s := tr.StartSpan(unsampled, "skip", spanKindServer); s != nil {
+		t.Fatalf("expected unsampled parent to suppress the span")
+	}
+}
+
+func TestEncodeOTLPTraces(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1700000000, 0)
+	request := encodeOTLPTraces("svc", []finishedSpan{{
+		sc:     spanContext{traceID: [16]byte{0xab}, spanID: [8]byte{0xcd}},
+		name:   "db.read",
+		kind:   spanKindClient,
+		start:  start,
+		end:    start.Add(time.Millisecond),
+		attrs:  []spanAttr{attrInt("room_id", 3), attrBool("db.replica", true)},
+		failed: true,
+	}})
+	encoded := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if encoded.ParentSpanID != "" || encoded.StartTimeUnixNano != "1700000000000000000" {
+		t.Fatalf("unexpected span encoding %+v", encoded)
+	}
+	if *encoded.Attributes[0].Value.IntValue != "3" || !*encoded.Attributes[1].Value.BoolValue {
+		t.Fatalf("unexpected attributes %+v", encoded.Attributes)
+	}
+	if encoded.Status.Code != otlpStatusError {
+		t.Fatalf("expected error status")
+	}
+	if *request.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "svc" {
+		t.Fatalf("expected service name resource attribute")
+	}
+}
@@ -1,14 +1,18 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -34,24 +38,78 @@ const (
 )
 
 type App struct {
-	db                *sql.DB
-	hub               *Hub
-	jwtSecret         []byte
+	db         *sql.DB
+	hub        *Hub
+	membership *membershipCache
+	messages   *messagePipeline
+	webhooks   *webhookDispatcher
+	// mail is nil unless SMTP is configured.
+	mail       *emailDispatcher
+	appBaseURL string
+	federation *federationRelay
+	backups    *backupService
+	replica    *replicaRouter
+	tracer     *tracer
+	jwtSecret  []byte
+	// settingsMu guards the settings a config reload may replace.
+	settingsMu        sync.RWMutex
 	corsOrigin        string
 	adminUsername     string
+	adminUsernames    []string
 	loginIPLimiter    *keyedRateLimiter
 	loginUserLimiter  *keyedRateLimiter
 	wsConnectLimiter  *keyedRateLimiter
+	routeLimiters     map[string]*keyedRateLimiter
+	adminAllowlist    []netip.Prefix
+	ipDenylist        *ipDenylist
+	features          *featureFlags
 	trustProxyHeaders bool
 	accessTokenTTL    time.Duration
 	refreshTokenTTL   time.Duration
 	upgrader          websocket.Upgrader
+	wsCompression     wsCompressionConfig
+	wsLimits          wsLimitsConfig
+	roomLimits        roomLimitsConfig
+	signatureReplay   *signatureReplayGuard
+	keyEpochGrace     time.Duration
+	wsDrainWindow     time.Duration
+	// preKeyFetchesPerTargetHour caps how many bundles of one user a
+	// requester may fetch per hour.
+	preKeyFetchesPerTargetHour int
+	// drHandshakeTTL bounds how long an undelivered dr_handshake is kept.
+	drHandshakeTTL time.Duration
+	preKeyHygiene  *preKeyHygieneJob
+	// dailyMessageLimit and storageQuotaBytes are the per-user defaults;
+	// user_quotas rows override them.
+	dailyMessageLimit int
+	storageQuotaBytes int64
+	// usernameHoldPeriod keeps a deleted account's username reserved.
+	usernameHoldPeriod time.Duration
+	// policyVersion is POLICY_VERSION, the floor for the policy version
+	// users must accept; zero leaves the gate to published versions.
+	policyVersion int
+	// accountErasureGracePeriod is how long a self-service erasure request
+	// waits before the account is erased.
+	accountErasureGracePeriod time.Duration
+	// loginChallenge is nil when LOGIN_CHALLENGE_PROVIDER is unset.
+	loginChallenge *loginChallenge
+
+	// draining is set once a deploy drain starts; new WebSocket upgrades are
+	// refused from then on.
+	draining atomic.Bool
+	// maintenance turns away REST and WebSocket traffic other than health
+	// checks and the admin API.
+	maintenance maintenanceMode
+	// inflightMessages counts ciphertext frames read but not yet persisted.
+	inflightMessages sync.WaitGroup
 }
 
 type Claims struct {
 	UserID               int64  `json:"uid"`
 	Username             string `json:"uname"`
 	Role                 string `json:"role"`
+	CustomRole           string `json:"crole,omitempty"`
+	RoleVersion          int    `json:"rv,omitempty"`
 	DeviceID             string `json:"did"`
 	DeviceSessionVersion int    `json:"dsv"`
 	jwt.RegisteredClaims
@@ -60,40 +118,87 @@ type Claims struct {
 type AuthContext struct {
 	UserID               int64
 	Username             string
+	DisplayName          string
 	Role                 string
+	CustomRole           string
+	RoleVersion          int
+	Permissions          []string
 	DeviceID             string
 	DeviceName           string
 	DeviceSessionVersion int
 	DeviceLastSeenAt     time.Time
+	// PolicyVersion is the policy version in force when the request was
+	// authenticated; AcceptedPolicyVersion is the user's latest acceptance.
+	PolicyVersion         int
+	AcceptedPolicyVersion int
 }
 
 type Hub struct {
-	mu    sync.RWMutex
-	rooms map[int64]map[*Client]struct{}
+	mu       sync.RWMutex
+	rooms    map[int64]map[*Client]struct{}
+	typing   *typingTracker
+	blocks   *blockList
+	calls    *callRegistry
+	presence *presenceTracker
+	fanout   *fanoutPool
+	// fanoutThreshold is the recipient count above which broadcasts go
+	// through the fan-out pool.
+	fanoutThreshold int
+	// lastConnID numbers connections as they join, for admin inspection.
+	lastConnID int64
+	// ctx is the parent of every connection context; Shutdown cancels it.
+	ctx  context.Context
+	stop context.CancelFunc
 }
 
 type Client struct {
 	app        *App
-	conn       *websocket.Conn
+	conn       wsConn
 	send       chan []byte
 	userID     int64
 	username   string
+	role       string
 	deviceID   string
 	deviceName string
 	roomID     int64
+	// guest marks a read-only share-link viewer with no user behind it.
+	guest bool
+	codec wsCodec
+	// requestID is the upgrade request's X-Request-ID; every log line for the
+	// connection's frames carries it.
+	requestID string
+	// id is assigned by the hub; remoteIP and connectedAt describe the
+	// upgrade request for the admin connections listing.
+	id          int64
+	remoteIP    string
+	connectedAt time.Time
+	// ctx scopes the database work of the connection's frame handlers. It
+	// is cancelled when the client leaves the hub or the hub shuts down.
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	mu               sync.RWMutex
 	publicKey        json.RawMessage
 	signingPublicKey json.RawMessage
+	// announceSignature is the key_announce self-signature over both keys,
+	// forwarded so peers can check it themselves.
+	announceSignature string
+	displayName       string
+	rtt               rttWindow
+	frameLimiter      *rate.Limiter
 }
 
 type PeerSnapshot struct {
 	UserID              int64           `json:"userId"`
 	Username            string          `json:"username"`
+	DisplayName         string          `json:"displayName,omitempty"`
+	Presence            string          `json:"presence,omitempty"`
 	DeviceID            string          `json:"deviceId"`
 	DeviceName          string          `json:"deviceName,omitempty"`
 	PublicKeyJWK        json.RawMessage `json:"publicKeyJwk"`
 	SigningPublicKeyJWK json.RawMessage `json:"signingPublicKeyJwk,omitempty"`
+	Signature           string          `json:"signature,omitempty"`
+	Online              bool            `json:"online"`
 }
 
 type WrappedKey struct {
@@ -116,52 +221,77 @@ type PreKeyMessage struct {
 }
 
 type CipherPayload struct {
-	Version             int                   `json:"version"`
-	Ciphertext          string                `json:"ciphertext"`
-	MessageIV           string                `json:"messageIv"`
-	WrappedKeys         map[string]WrappedKey `json:"wrappedKeys"`
-	SenderPublicJWK     json.RawMessage       `json:"senderPublicKeyJwk"`
-	SenderSigningPubJWK json.RawMessage       `json:"senderSigningPublicKeyJwk,omitempty"`
-	Signature           string                `json:"signature,omitempty"`
-	ContentType         string                `json:"contentType,omitempty"`
-	SenderDeviceID      string                `json:"senderDeviceId,omitempty"`
-	EncryptionScheme    string                `json:"encryptionScheme,omitempty"`
+	Version                   int                   `json:"version"`
+	Ciphertext                string                `json:"ciphertext"`
+	MessageIV                 string                `json:"messageIv"`
+	WrappedKeys               map[string]WrappedKey `json:"wrappedKeys"`
+	SenderPublicJWK           json.RawMessage       `json:"senderPublicKeyJwk"`
+	SenderSigningPubJWK       json.RawMessage       `json:"senderSigningPublicKeyJwk,omitempty"`
+	Signature                 string                `json:"signature,omitempty"`
+	ContentType               string                `json:"contentType,omitempty"`
+	SenderDeviceID            string                `json:"senderDeviceId,omitempty"`
+	EncryptionScheme          string                `json:"encryptionScheme,omitempty"`
+	PollOptionCount           int                   `json:"pollOptionCount,omitempty"`
+	SenderKeyID               string                `json:"senderKeyId,omitempty"`
+	SignatureCanonicalization string                `json:"signatureCanonicalization,omitempty"`
+	Nonce                     string                `json:"nonce,omitempty"`
+	SignedAt                  int64                 `json:"signedAt,omitempty"`
+	KeyEpoch                  int64                 `json:"keyEpoch,omitempty"`
 }
 
 type WSIncoming struct {
-	Type                  string                `json:"type"`
-	Version               int                   `json:"version,omitempty"`
-	Ciphertext            string                `json:"ciphertext,omitempty"`
-	MessageIV             string                `json:"messageIv,omitempty"`
-	WrappedKeys           map[string]WrappedKey `json:"wrappedKeys,omitempty"`
-	SenderPublicJWK       json.RawMessage       `json:"senderPublicKeyJwk,omitempty"`
-	SenderSigningPubJWK   json.RawMessage       `json:"senderSigningPublicKeyJwk,omitempty"`
-	Signature             string                `json:"signature,omitempty"`
-	AckSignature          string                `json:"ackSignature,omitempty"`
-	MessageID             int64                 `json:"messageId,omitempty"`
-	PublicKeyJWK          json.RawMessage       `json:"publicKeyJwk,omitempty"`
-	SigningPublicKeyJWK   json.RawMessage       `json:"signingPublicKeyJwk,omitempty"`
-	ContentType           string                `json:"contentType,omitempty"`
-	SenderDeviceID        string                `json:"senderDeviceId,omitempty"`
-	EncryptionScheme      string                `json:"encryptionScheme,omitempty"`
-	ToUserID              int64                 `json:"toUserId,omitempty"`
-	ToDeviceID            string                `json:"toDeviceId,omitempty"`
-	Step                  string                `json:"step,omitempty"`
-	Action                string                `json:"action,omitempty"`
-	Mode                  string                `json:"mode,omitempty"`
-	IsTyping              bool                  `json:"isTyping,omitempty"`
-	UpToMessageID         int64                 `json:"upToMessageId,omitempty"`
-	SessionVersion        int                   `json:"sessionVersion,omitempty"`
-	RatchetDHPublic       json.RawMessage       `json:"ratchetDhPublicKeyJwk,omitempty"`
-	IdentityPublicJWK     json.RawMessage       `json:"identityPublicKeyJwk,omitempty"`
-	IdentitySigningPubJWK json.RawMessage       `json:"identitySigningPublicKeyJwk,omitempty"`
+	Type                      string                `json:"type"`
+	Version                   int                   `json:"version,omitempty"`
+	Ciphertext                string                `json:"ciphertext,omitempty"`
+	MessageIV                 string                `json:"messageIv,omitempty"`
+	WrappedKeys               map[string]WrappedKey `json:"wrappedKeys,omitempty"`
+	SenderPublicJWK           json.RawMessage       `json:"senderPublicKeyJwk,omitempty"`
+	SenderSigningPubJWK       json.RawMessage       `json:"senderSigningPublicKeyJwk,omitempty"`
+	Signature                 string                `json:"signature,omitempty"`
+	AckSignature              string                `json:"ackSignature,omitempty"`
+	MessageID                 int64                 `json:"messageId,omitempty"`
+	PublicKeyJWK              json.RawMessage       `json:"publicKeyJwk,omitempty"`
+	SigningPublicKeyJWK       json.RawMessage       `json:"signingPublicKeyJwk,omitempty"`
+	ContentType               string                `json:"contentType,omitempty"`
+	SenderDeviceID            string                `json:"senderDeviceId,omitempty"`
+	EncryptionScheme          string                `json:"encryptionScheme,omitempty"`
+	ToUserID                  int64                 `json:"toUserId,omitempty"`
+	ToDeviceID                string                `json:"toDeviceId,omitempty"`
+	Step                      string                `json:"step,omitempty"`
+	Action                    string                `json:"action,omitempty"`
+	Mode                      string                `json:"mode,omitempty"`
+	IsTyping                  bool                  `json:"isTyping,omitempty"`
+	UpToMessageID             int64                 `json:"upToMessageId,omitempty"`
+	SessionVersion            int                   `json:"sessionVersion,omitempty"`
+	RatchetDHPublic           json.RawMessage       `json:"ratchetDhPublicKeyJwk,omitempty"`
+	IdentityPublicJWK         json.RawMessage       `json:"identityPublicKeyJwk,omitempty"`
+	IdentitySigningPubJWK     json.RawMessage       `json:"identitySigningPublicKeyJwk,omitempty"`
+	Mentions                  []int64               `json:"mentions,omitempty"`
+	PollOptionCount           int                   `json:"pollOptionCount,omitempty"`
+	OptionIndex               *int                  `json:"optionIndex,omitempty"`
+	ExpectedRevision          *int                  `json:"expectedRevision,omitempty"`
+	CallID                    string                `json:"callId,omitempty"`
+	SDP                       json.RawMessage       `json:"sdp,omitempty"`
+	Candidate                 json.RawMessage       `json:"candidate,omitempty"`
+	Media                     string                `json:"media,omitempty"`
+	Reason                    string                `json:"reason,omitempty"`
+	SenderKeyID               string                `json:"senderKeyId,omitempty"`
+	Status                    string                `json:"status,omitempty"`
+	SignatureCanonicalization string                `json:"signatureCanonicalization,omitempty"`
+	Nonce                     string                `json:"nonce,omitempty"`
+	SignedAt                  int64                 `json:"signedAt,omitempty"`
+	KeyEpoch                  int64                 `json:"keyEpoch,omitempty"`
+	BackfillID                int64                 `json:"backfillId,omitempty"`
+	MessageIDs                []int64               `json:"messageIds,omitempty"`
+	Limit                     int                   `json:"limit,omitempty"`
 }
 
 type ProtocolErrorFrame struct {
-	Type    string `json:"type"`
-	RoomID  int64  `json:"roomId"`
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Type    string         `json:"type"`
+	RoomID  int64          `json:"roomId"`
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
 }
 
 type SignalSignedPreKey struct {
@@ -211,14 +341,59 @@ type DeviceSnapshot struct {
 }
 
 type StoredMessage struct {
-	ID             int64         `json:"id"`
-	RoomID         int64         `json:"roomId"`
-	SenderID       int64         `json:"senderId"`
-	SenderUsername string        `json:"senderUsername"`
-	CreatedAt      string        `json:"createdAt"`
-	EditedAt       *string       `json:"editedAt,omitempty"`
-	RevokedAt      *string       `json:"revokedAt,omitempty"`
-	Payload        CipherPayload `json:"payload"`
+	Kind   string `json:"kind"`
+	ID     int64  `json:"id"`
+	RoomID int64  `json:"roomId"`
+	// Seq goes up by one for every message stored in the room, in commit
+	// order, so a client can spot a missed frame.
+	Seq               int64         `json:"seq"`
+	SenderID          int64         `json:"senderId"`
+	SenderUsername    string        `json:"senderUsername"`
+	SenderDisplayName string        `json:"senderDisplayName,omitempty"`
+	SenderDeleted     bool          `json:"senderDeleted,omitempty"`
+	CreatedAt         string        `json:"createdAt"`
+	Revision          int           `json:"revision"`
+	EditedAt          *string       `json:"editedAt,omitempty"`
+	RevokedAt         *string       `json:"revokedAt,omitempty"`
+	RevokedBy         *int64        `json:"revokedBy,omitempty"`
+	Mentions          []int64       `json:"mentions,omitempty"`
+	Payload           CipherPayload `json:"payload"`
+
+	ForwardedFromRoomID    *int64 `json:"forwardedFromRoomId,omitempty"`
+	ForwardedFromMessageID *int64 `json:"forwardedFromMessageId,omitempty"`
+}
+
+// SystemEvent is a room-level entry from the event log, interleaved with
+// messages in history when a client asks for it. It is never encrypted.
+type SystemEvent struct {
+	Kind          string          `json:"kind"`
+	ID            int64           `json:"id"`
+	Type          string          `json:"type"`
+	RoomID        int64           `json:"roomId"`
+	ActorID       *int64          `json:"actorId,omitempty"`
+	ActorUsername string          `json:"actorUsername,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	CreatedAt     string          `json:"createdAt"`
+}
+
+type MessageRevision struct {
+	ID         int64         `json:"id"`
+	AuthoredAt string        `json:"authoredAt"`
+	ReplacedAt string        `json:"replacedAt"`
+	Payload    CipherPayload `json:"payload"`
+}
+
+type SyncEvent struct {
+	Cursor        int64           `json:"cursor"`
+	Type          string          `json:"type"`
+	RoomID        int64           `json:"roomId"`
+	MessageID     *int64          `json:"messageId,omitempty"`
+	ActorID       *int64          `json:"actorId,omitempty"`
+	ActorUsername string          `json:"actorUsername,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	CreatedAt     string          `json:"createdAt"`
+	// UndeliveredReason is set on dead letters: queue_full or offline.
+	UndeliveredReason string `json:"undeliveredReason,omitempty"`
 }
 
 var (
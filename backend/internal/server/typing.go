@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	typingBroadcastDebounce = 3 * time.Second
+	typingIdleExpiry        = 10 * time.Second
+)
+
+type typingKey struct {
+	roomID int64
+	userID int64
+}
+
+type typingEntry struct {
+	username      string
+	lastBroadcast time.Time
+	timer         *time.Timer
+}
+
+// typingTracker collapses typing_status frames per (room, user): repeated
+// isTyping=true updates are broadcast at most once per debounce window, and
+// an isTyping=false broadcast is synthesized when no refresh arrives before
+// the idle expiry.
+type typingTracker struct {
+	mu       sync.Mutex
+	entries  map[typingKey]*typingEntry
+	debounce time.Duration
+	expiry   time.Duration
+	now      func() time.Time
+	emit     func(roomID, userID int64, username string, isTyping bool)
+}
+
+func newTypingTracker(
+	debounce time.Duration,
+	expiry time.Duration,
+	emit func(roomID, userID int64, username string, isTyping bool),
+) *typingTracker {
+	return &typingTracker{
+		entries:  make(map[typingKey]*typingEntry),
+		debounce: debounce,
+		expiry:   expiry,
+		now:      time.Now,
+		emit:     emit,
+	}
+}
+
+func (t *typingTracker) Update(roomID, userID int64, username string, isTyping bool) {
+	if t == nil {
+		return
+	}
+	key := typingKey{roomID: roomID, userID: userID}
+
+	t.mu.Lock()
+	entry, found := t.entries[key]
+	if !isTyping {
+		if found {
+			entry.timer.Stop()
+			delete(t.entries, key)
+		}
+		t.mu.Unlock()
+		if found {
+			t.emit(roomID, userID, username, false)
+		}
+		return
+	}
+
+	now := t.now()
+	shouldBroadcast := !found || now.Sub(entry.lastBroadcast) >= t.debounce
+	if !found {
+		entry = &typingEntry{}
+		t.entries[key] = entry
+	} else {
+		entry.timer.Stop()
+	}
+	entry.username = username
+	if shouldBroadcast {
+		entry.lastBroadcast = now
+	}
+	current := entry
+	entry.timer = time.AfterFunc(t.expiry, func() {
+		t.expire(key, current)
+	})
+	t.mu.Unlock()
+
+	if shouldBroadcast {
+		t.emit(roomID, userID, username, true)
+	}
+}
+
+// Clear drops any pending typing state for the user, broadcasting
+// isTyping=false if they were still marked as typing.
+func (t *typingTracker) Clear(roomID, userID int64) {
+	if t == nil {
+		return
+	}
+	key := typingKey{roomID: roomID, userID: userID}
+
+	t.mu.Lock()
+	entry, found := t.entries[key]
+	if found {
+		entry.timer.Stop()
+		delete(t.entries, key)
+	}
+	t.mu.Unlock()
+
+	if found {
+		t.emit(roomID, userID, entry.username, false)
+	}
+}
+
+func (t *typingTracker) Stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, entry := range t.entries {
+		entry.timer.Stop()
+		delete(t.entries, key)
+	}
+}
+
+func (t *typingTracker) expire(key typingKey, entry *typingEntry) {
+	t.mu.Lock()
+	if t.entries[key] != entry {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.entries, key)
+	t.mu.Unlock()
+
+	t.emit(key.roomID, key.userID, entry.username, false)
+}
+
+// ClearTyping ends the user's typing state in the room once their last
+// connection to it is gone. Typing is tracked per user, so a device that
+// disconnects must not cancel the indicator another device is driving.
+func (h *Hub) ClearTyping(roomID, userID int64) {
+	if h.DeviceConnected(roomID, userID, "") {
+		return
+	}
+	h.typing.Clear(roomID, userID)
+}
+
+func (h *Hub) broadcastTypingStatus(roomID, userID int64, username string, isTyping bool) {
+	payload, err := json.Marshal(map[string]any{
+		"type":         "typing_status",
+		"roomId":       roomID,
+		"fromUserId":   userID,
+		"fromUsername": username,
+		"isTyping":     isTyping,
+	})
+	if err != nil {
+		return
+	}
+	h.BroadcastFrom(roomID, userID, payload)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type typingEmission struct {
+	roomID   int64
+	userID   int64
+	isTyping bool
+}
+
+type typingRecorder struct {
+	mu     sync.Mutex
+	events []typingEmission
+	notify chan struct{}
+}
+
+func newTypingRecorder() *typingRecorder {
+	return &typingRecorder{notify: make(chan struct{}, 16)}
+}
+
+func (r *typingRecorder) emit(roomID, userID int64, _ string, isTyping bool) {
+	r.mu.Lock()
+	r.events = append(r.events, typingEmission{roomID: roomID, userID: userID, isTyping: isTyping})
+	r.mu.Unlock()
+	r.notify <- struct{}{}
+}
+
+func (r *typingRecorder) snapshot() []typingEmission {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]typingEmission(nil), r.events...)
+}
+
+func TestTypingTrackerDebounce(t *testing.T) {
+	t.Parallel()
+
+	recorder := newTypingRecorder()
+	tracker := newTypingTracker(3*time.Second, time.Minute, recorder.emit)
+	defer tracker.Stop()
+	now := time.Unix(1_700_000_000, 0)
+	tracker.now = func() time.Time { return now }
+
+	tracker.Update(1, 7, "alice", true)
+	now = now.Add(time.Second)
+	tracker.Update(1, 7, "alice", true)
+	now = now.Add(time.Second)
+	tracker.Update(1, 7, "alice", true)
+	if got := len(recorder.snapshot()); got != 1 {
+		t.Fatalf("expected 1 broadcast within debounce window, got %d", got)
+	}
+
+	now = now.Add(2 * time.Second)
+	tracker.Update(1, 7, "alice", true)
+	if got := len(recorder.snapshot()); got != 2 {
+		t.Fatalf("expected refresh broadcast after debounce window, got %d", got)
+	}
+
+	tracker.Update(1, 7, "alice", false)
+	events := recorder.snapshot()
+	if len(events) != 3 || events[2].isTyping {
+		t.Fatalf("expected explicit stop broadcast, got %+v", events)
+	}
+
+	tracker.Update(1, 7, "alice", false)
+	if got := len(recorder.snapshot()); got != 3 {
+		t.Fatalf("expected redundant stop to be dropped, got %d events", got)
+	}
+}
+
+func TestTypingTrackerExpiry(t *testing.T) {
+	t.Parallel()
+
+	recorder := newTypingRecorder()
+	tracker := newTypingTracker(time.Second, 20*time.Millisecond, recorder.emit)
+	defer tracker.Stop()
+
+	tracker.Update(3, 9, "bob", true)
+	<-recorder.notify
+	select {
+	case <-recorder.notify:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected idle expiry broadcast")
+	}
+
+	events := recorder.snapshot()
+	if len(events) != 2 || events[1].isTyping || events[1].roomID != 3 || events[1].userID != 9 {
+		t.Fatalf("unexpected expiry events: %+v", events)
+	}
+}
+
+func TestHubClearTypingWaitsForLastConnection(t *testing.T) {
+	t.Parallel()
+
+	recorder := newTypingRecorder()
+	hub := NewHub()
+	hub.typing = newTypingTracker(time.Second, time.Minute, recorder.emit)
+	t.Cleanup(hub.typing.Stop)
+
+	phone := &Client{roomID: 1, userID: 1, deviceID: "phone", username: "alice", send: make(chan []byte, 1)}
+	laptop := &Client{roomID: 1, userID: 1, deviceID: "laptop", username: "alice", send: make(chan []byte, 1)}
+	hub.AddClient(phone)
+	hub.AddClient(laptop)
+
+	hub.typing.Update(1, 1, "alice", true)
+	hub.RemoveClient(laptop)
+	hub.ClearTyping(1, 1)
+	if events := recorder.snapshot(); len(events) != 1 || !events[0].isTyping {
+		t.Fatalf("expected the phone to keep typing, got %+v", events)
+	}
+
+	hub.RemoveClient(phone)
+	hub.ClearTyping(1, 1)
+	events := recorder.snapshot()
+	if len(events) != 2 || events[1].isTyping {
+		t.Fatalf("expected typing cleared after the last connection, got %+v", events)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+type blockPair struct {
+	blockerID int64
+	blockedID int64
+}
+
+// blockList mirrors user_blocks in memory so the hub can filter typing and
+// receipt fan-out without a query per recipient. Handlers write the table
+// first and then update the mirror.
+type blockList struct {
+	mu    sync.RWMutex
+	pairs map[blockPair]struct{}
+}
+
+func newBlockList() *blockList {
+	return &blockList{pairs: make(map[blockPair]struct{})}
+}
+
+func (b *blockList) Load(ctx context.Context, db *sql.DB) error {
+	if b == nil {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, `SELECT blocker_id, blocked_id FROM user_blocks`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	pairs := make(map[blockPair]struct{})
+	for rows.Next() {
+		var pair blockPair
+		if err := rows.Scan(&pair.blockerID, &pair.blockedID); err != nil {
+			return err
+		}
+		pairs[pair] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.pairs = pairs
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *blockList) Add(blockerID, blockedID int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.pairs[blockPair{blockerID: blockerID, blockedID: blockedID}] = struct{}{}
+	b.mu.Unlock()
+}
+
+func (b *blockList) Remove(blockerID, blockedID int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	delete(b.pairs, blockPair{blockerID: blockerID, blockedID: blockedID})
+	b.mu.Unlock()
+}
+
+// Blocks reports whether blockerID has blocked blockedID.
+func (b *blockList) Blocks(blockerID, blockedID int64) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	_, found := b.pairs[blockPair{blockerID: blockerID, blockedID: blockedID}]
+	b.mu.RUnlock()
+	return found
+}
+
+// Between reports whether either user has blocked the other.
+func (b *blockList) Between(userA, userB int64) bool {
+	return b.Blocks(userA, userB) || b.Blocks(userB, userA)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const defaultUsernameHoldDays = 30
+
+func (a *App) usernameHold() time.Duration {
+	if a.usernameHoldPeriod <= 0 {
+		return defaultUsernameHoldDays * 24 * time.Hour
+	}
+	return a.usernameHoldPeriod
+}
+
+// tombstoneUserTx deactivates an account without deleting its row, so
+// messages keep a sender to join against. The original username is kept in
+// former_username for rendering history; sessions, devices and memberships
+// are dropped. It returns the rooms the user was removed from.
+func tombstoneUserTx(ctx context.Context, tx *sql.Tx, userID int64) ([]int64, error) {
+	if _, err := tx.ExecContext(ctx, `
+UPDATE users
+SET deleted_at = NOW(),
+    former_username = username,
+    password_hash = '',
+    display_name = NULL,
+    bio = NULL
+WHERE id = $1 AND deleted_at IS NULL
+`, userID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE auth_refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE user_devices SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM room_members WHERE user_id = $1 RETURNING room_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	roomIDs := make([]int64, 0, 8)
+	for rows.Next() {
+		var roomID int64
+		if err := rows.Scan(&roomID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(map[string]any{"userId": userID, "reason": "account_deleted"})
+	if err != nil {
+		return nil, err
+	}
+	for _, roomID := range roomIDs {
+		if _, err := recordEvent(ctx, tx, roomID, 0, userID, eventMemberLeft, payload); err != nil {
+			return nil, err
+		}
+	}
+	return roomIDs, nil
+}
+
+// releaseHeldUsernames frees the usernames of accounts deleted longer than
+// hold ago. The placeholder is longer than any valid username, so it can
+// never collide with a real account.
+func releaseHeldUsernames(ctx context.Context, exec sqlExecer, hold time.Duration) (int64, error) {
+	result, err := exec.ExecContext(ctx, `
+UPDATE users
+SET username = 'deleted:' || id || ':' || md5(username)
+WHERE deleted_at IS NOT NULL
+  AND deleted_at < $1
+  AND username = former_username
+`, time.Now().Add(-hold))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUsernameHold(t *testing.T) {
+	t.Parallel()
+
+	if got := (&App{}).usernameHold(); got != defaultUsernameHoldDays*24*time.Hour {
+		t.Fatalf("unexpected default hold: %s", got)
+	}
+	if got := (&App{usernameHoldPeriod: time.Hour}).usernameHold(); got != time.Hour {
+		t.Fatalf("unexpected configured hold: %s", got)
+	}
+}
+
+func TestAdminDeleteUserRejectsSelf(t *testing.T) {
+	t.Parallel()
+
+	app := &App{}
+	rec := httptest.NewRecorder()
+	app.handleAdminDeleteUser(rec, httptest.NewRequest(http.MethodDelete, "/api/admin/users/1", nil), AuthContext{UserID: 1, Role: "admin"}, 1)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected %d, got %d", http.StatusForbidden, rec.Code)
+	}
+}
@@ -0,0 +1,403 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookEventMessageCreated = "message_created"
+	webhookEventMemberJoined   = "member_joined"
+	webhookEventRoomDeleted    = "room_deleted"
+
+	webhookSecretBytes       = 32
+	webhookMaxAttempts       = 8
+	webhookBaseBackoff       = 10 * time.Second
+	webhookMaxBackoff        = time.Hour
+	webhookPollInterval      = 5 * time.Second
+	webhookDeliveryBatchSize = 20
+	webhookRequestTimeout    = 10 * time.Second
+	webhookMaxErrorLength    = 500
+	maxWebhooksPerRoom       = 10
+
+	// webhookClaimLease is how long a claimed delivery stays invisible to
+	// other dispatchers. It covers a full batch of timed-out requests, so a
+	// delivery is only claimed again when its dispatcher died mid-batch.
+	webhookClaimLease = webhookRequestTimeout * (webhookDeliveryBatchSize + 1)
+)
+
+var errWebhookDestination = errors.New("webhook url must resolve to a public address")
+
+// webhookBlockedPrefixes are non-public ranges the netip predicates in
+// webhookAddrAllowed do not cover.
+var webhookBlockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+var webhookEventTypes = []string{
+	webhookEventMessageCreated,
+	webhookEventMemberJoined,
+	webhookEventRoomDeleted,
+}
+
+func validWebhookEventType(eventType string) bool {
+	for _, candidate := range webhookEventTypes {
+		if candidate == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func validateWebhookURL(raw string) error {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.New("webhook url must use http or https")
+	}
+	if parsed.Host == "" {
+		return errors.New("webhook url must include host")
+	}
+	if parsed.User != nil {
+		return errors.New("webhook url must not include credentials")
+	}
+	return nil
+}
+
+// webhookAddrAllowed reports whether webhooks may reach addr. Loopback,
+// private, link-local (cloud metadata included) and other non-routable
+// addresses are refused so room managers cannot probe the server's network.
+func webhookAddrAllowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	return !prefixesContain(webhookBlockedPrefixes, addr)
+}
+
+// checkWebhookDestination resolves the webhook host and refuses it unless
+// every address is public. The dispatcher checks again at dial time, so a
+// host that later resolves elsewhere is still refused.
+func checkWebhookDestination(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	host := parsed.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !webhookAddrAllowed(addr) {
+			return errWebhookDestination
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil || len(addrs) == 0 {
+		return errors.New("webhook host does not resolve")
+	}
+	for _, addr := range addrs {
+		if !webhookAddrAllowed(addr) {
+			return errWebhookDestination
+		}
+	}
+	return nil
+}
+
+// webhookDialControl refuses connections to non-public addresses after DNS
+// resolution, closing the rebinding gap left by the check at creation.
+func webhookDialControl(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !webhookAddrAllowed(addrPort.Addr()) {
+		return errWebhookDestination
+	}
+	return nil
+}
+
+// newWebhookHTTPClient builds the delivery client. Redirects are never
+// followed; control, when set, vets every dialed address.
+func newWebhookHTTPClient(control func(network, address string, c syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookRequestTimeout, Control: control}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   webhookRequestTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func generateWebhookSecret() (string, error) {
+	raw := make([]byte, webhookSecretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// signWebhookPayload binds the timestamp into the MAC so receivers can reject
+// replays outside their tolerance window.
+func signWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := webhookBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return delay
+}
+
+// enqueueWebhookEvent fans an event out to the room's subscribed webhooks.
+// Callers pass the mutation's transaction so deliveries only exist for
+// committed state.
+func enqueueWebhookEvent(ctx context.Context, exec sqlExecer, roomID int64, eventType string, data map[string]any) error {
+	body, err := json.Marshal(map[string]any{
+		"type":       eventType,
+		"roomId":     roomID,
+		"occurredAt": time.Now().UTC().Format(time.RFC3339Nano),
+		"data":       data,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = exec.ExecContext(ctx, `
+INSERT INTO webhook_deliveries(webhook_id, event_type, payload)
+SELECT id, $2, $3::jsonb
+FROM room_webhooks
+WHERE room_id = $1 AND disabled_at IS NULL AND $2 = ANY(event_types)
+`, roomID, eventType, body)
+	return err
+}
+
+type webhookDelivery struct {
+	id        int64
+	webhookID int64
+	eventType string
+	payload   []byte
+	attempts  int
+	url       string
+	secret    string
+}
+
+// webhookDispatcher drains webhook_deliveries in the background. Rows are
+// claimed with SKIP LOCKED under a short lease so several replicas can share
+// the queue, and delivered with no transaction or row lock held.
+type webhookDispatcher struct {
+	db     *sql.DB
+	client *http.Client
+	now    func() time.Time
+	wake   chan struct{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newWebhookDispatcher(db *sql.DB) *webhookDispatcher {
+	return &webhookDispatcher{
+		db:     db,
+		client: newWebhookHTTPClient(webhookDialControl),
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (d *webhookDispatcher) Start() {
+	go d.run()
+}
+
+// Notify nudges the dispatcher after new deliveries were committed so they
+// go out without waiting for the next poll.
+func (d *webhookDispatcher) Notify() {
+	if d == nil {
+		return
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *webhookDispatcher) Stop() {
+	if d == nil {
+		return
+	}
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+	<-d.done
+}
+
+func (d *webhookDispatcher) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			processed, err := d.processBatch()
+			if err != nil {
+				logger.Warn("webhook_dispatch_failed", "error", err)
+				break
+			}
+			if processed < webhookDeliveryBatchSize {
+				break
+			}
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// processBatch claims due deliveries by pushing next_attempt_at past the
+// lease, then delivers each and records its outcome on its own. An outcome
+// is only written if the row was not reclaimed meanwhile, and a crash leaves
+// claimed rows to be retried once the lease runs out.
+func (d *webhookDispatcher) processBatch() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookClaimLease)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `
+UPDATE webhook_deliveries
+SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+WHERE id IN (
+    SELECT id
+    FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at ASC, id ASC
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, webhook_id, event_type, payload, attempts,
+          (SELECT url FROM room_webhooks WHERE room_webhooks.id = webhook_deliveries.webhook_id),
+          (SELECT secret FROM room_webhooks WHERE room_webhooks.id = webhook_deliveries.webhook_id)
+`, webhookDeliveryBatchSize, webhookClaimLease.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	deliveries := make([]webhookDelivery, 0, webhookDeliveryBatchSize)
+	for rows.Next() {
+		var item webhookDelivery
+		if err := rows.Scan(&item.id, &item.webhookID, &item.eventType, &item.payload, &item.attempts, &item.url, &item.secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		deliveries = append(deliveries, item)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range deliveries {
+		statusCode, deliverErr := d.deliver(ctx, delivery)
+		if err := d.recordOutcome(ctx, delivery, statusCode, deliverErr); err != nil {
+			return 0, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+func (d *webhookDispatcher) recordOutcome(ctx context.Context, delivery webhookDelivery, statusCode int, deliverErr error) error {
+	attempts := delivery.attempts + 1
+	if deliverErr == nil {
+		_, err := d.db.ExecContext(ctx, `
+UPDATE webhook_deliveries
+SET status = 'delivered', attempts = $2, last_status_code = $3, last_error = NULL, delivered_at = NOW()
+WHERE id = $1 AND attempts = $4
+`, delivery.id, attempts, statusCode, delivery.attempts)
+		return err
+	}
+
+	status := "pending"
+	if attempts >= webhookMaxAttempts {
+		status = "failed"
+	}
+	var statusRef sql.NullInt64
+	if statusCode > 0 {
+		statusRef = sql.NullInt64{Int64: int64(statusCode), Valid: true}
+	}
+	message := deliverErr.Error()
+	if len(message) > webhookMaxErrorLength {
+		message = message[:webhookMaxErrorLength]
+	}
+	_, err := d.db.ExecContext(ctx, `
+UPDATE webhook_deliveries
+SET status = $2, attempts = $3, last_status_code = $4, last_error = $5, next_attempt_at = $6
+WHERE id = $1 AND attempts = $7
+`, delivery.id, status, attempts, statusRef, message, d.now().Add(webhookBackoff(attempts)), delivery.attempts)
+	return err
+}
+
+func (d *webhookDispatcher) deliver(ctx context.Context, delivery webhookDelivery) (int, error) {
+	timestamp := d.now().Unix()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.url, bytes.NewReader(delivery.payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "e2ee-chat-webhooks/1")
+	request.Header.Set("X-Webhook-Event", delivery.eventType)
+	request.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.id, 10))
+	request.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	request.Header.Set("X-Webhook-Signature", signWebhookPayload(delivery.secret, timestamp, delivery.payload))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
@@ -0,0 +1,423 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Clients opt into an encoding with Sec-WebSocket-Protocol. Connections that
+// negotiate no subprotocol keep the original JSON text frames.
+const (
+	wsSubprotocolJSON = "e2ee-chat.v1.json"
+	wsSubprotocolCBOR = "e2ee-chat.v1.cbor"
+	// wsSubprotocolAuthPrefix carries the access token for clients that can
+	// set neither cookies nor headers on the upgrade. It is never selected,
+	// so the token is not echoed back.
+	wsSubprotocolAuthPrefix = "e2ee-chat.auth."
+
+	maxCBORNestingDepth = 64
+)
+
+var errInvalidCBOR = errors.New("invalid cbor frame")
+
+type wsCodec int
+
+const (
+	wsCodecJSON wsCodec = iota
+	wsCodecCBOR
+)
+
+var errWSAuthSubprotocolAlone = errors.New("token subprotocol must be offered with " + wsSubprotocolJSON + " or " + wsSubprotocolCBOR)
+
+// wsSubprotocolToken returns the access token offered as a subprotocol. A
+// client that offers one must also offer an encoding, otherwise the server
+// would have no subprotocol to answer with and the client would fail the
+// handshake.
+func wsSubprotocolToken(r *http.Request) (string, error) {
+	token := ""
+	hasEncoding := false
+	for _, offered := range websocket.Subprotocols(r) {
+		switch {
+		case offered == wsSubprotocolJSON || offered == wsSubprotocolCBOR:
+			hasEncoding = true
+		case strings.HasPrefix(offered, wsSubprotocolAuthPrefix) && token == "":
+			token = strings.TrimSpace(strings.TrimPrefix(offered, wsSubprotocolAuthPrefix))
+		}
+	}
+	if token != "" && !hasEncoding {
+		return "", errWSAuthSubprotocolAlone
+	}
+	return token, nil
+}
+
+func wsCodecForSubprotocol(subprotocol string) wsCodec {
+	if subprotocol == wsSubprotocolCBOR {
+		return wsCodecCBOR
+	}
+	return wsCodecJSON
+}
+
+// decodeFrame converts an incoming frame into the JSON the dispatcher
+// understands. CBOR frames are transcoded value-for-value, so handlers stay
+// encoding-agnostic.
+func (codec wsCodec) decodeFrame(messageType int, raw []byte) ([]byte, error) {
+	if codec != wsCodecCBOR {
+		return raw, nil
+	}
+	if messageType != websocket.BinaryMessage {
+		return nil, fmt.Errorf("%w: expected binary frame", errInvalidCBOR)
+	}
+	return cborToJSON(raw)
+}
+
+func (codec wsCodec) encodeFrame(payload []byte) (int, []byte, error) {
+	if codec != wsCodecCBOR {
+		return websocket.TextMessage, payload, nil
+	}
+	encoded, err := jsonToCBOR(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	return websocket.BinaryMessage, encoded, nil
+}
+
+// jsonToCBOR streams JSON tokens into CBOR, using indefinite-length arrays
+// and maps so object key order is preserved without buffering.
+func jsonToCBOR(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch value := token.(type) {
+		case json.Delim:
+			switch value {
+			case '[':
+				out.WriteByte(0x9f)
+			case '{':
+				out.WriteByte(0xbf)
+			default:
+				out.WriteByte(0xff)
+			}
+		case bool:
+			if value {
+				out.WriteByte(0xf5)
+			} else {
+				out.WriteByte(0xf4)
+			}
+		case nil:
+			out.WriteByte(0xf6)
+		case string:
+			writeCBORHead(&out, 3, uint64(len(value)))
+			out.WriteString(value)
+		case json.Number:
+			if err := writeCBORNumber(&out, value); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported json token %T", token)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func writeCBORHead(out *bytes.Buffer, major byte, length uint64) {
+	prefix := major << 5
+	switch {
+	case length < 24:
+		out.WriteByte(prefix | byte(length))
+	case length <= math.MaxUint8:
+		out.WriteByte(prefix | 24)
+		out.WriteByte(byte(length))
+	case length <= math.MaxUint16:
+		out.WriteByte(prefix | 25)
+		_ = binary.Write(out, binary.BigEndian, uint16(length))
+	case length <= math.MaxUint32:
+		out.WriteByte(prefix | 26)
+		_ = binary.Write(out, binary.BigEndian, uint32(length))
+	default:
+		out.WriteByte(prefix | 27)
+		_ = binary.Write(out, binary.BigEndian, length)
+	}
+}
+
+func writeCBORNumber(out *bytes.Buffer, number json.Number) error {
+	text := number.String()
+	if !strings.ContainsAny(text, ".eE") {
+		if parsed, err := strconv.ParseInt(text, 10, 64); err == nil {
+			if parsed >= 0 {
+				writeCBORHead(out, 0, uint64(parsed))
+			} else {
+				writeCBORHead(out, 1, uint64(-1-parsed))
+			}
+			return nil
+		}
+	}
+	parsed, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return err
+	}
+	out.WriteByte(0xfb)
+	return binary.Write(out, binary.BigEndian, math.Float64bits(parsed))
+}
+
+// cborToJSON accepts the JSON-compatible subset of CBOR: integers, floats,
+// text strings, arrays, maps with text keys, booleans and null.
+func cborToJSON(data []byte) ([]byte, error) {
+	reader := &cborReader{data: data}
+	var out bytes.Buffer
+	out.Grow(len(data) * 2)
+	if err := reader.readItem(&out, 0); err != nil {
+		return nil, err
+	}
+	if reader.pos != len(reader.data) {
+		return nil, fmt.Errorf("%w: trailing bytes", errInvalidCBOR)
+	}
+	return out.Bytes(), nil
+}
+
+type cborReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *cborReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, fmt.Errorf("%w: unexpected end of data", errInvalidCBOR)
+	}
+	chunk := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return chunk, nil
+}
+
+func (r *cborReader) peekBreak() bool {
+	return r.pos < len(r.data) && r.data[r.pos] == 0xff
+}
+
+// readHead returns the major type and argument; indefinite is set for
+// additional info 31, which is only legal on arrays, maps and strings.
+func (r *cborReader) readHead() (major byte, argument uint64, indefinite bool, err error) {
+	first, err := r.next(1)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	major = first[0] >> 5
+	info := first[0] & 0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), false, nil
+	case info == 24:
+		chunk, err := r.next(1)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return major, uint64(chunk[0]), false, nil
+	case info == 25:
+		chunk, err := r.next(2)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return major, uint64(binary.BigEndian.Uint16(chunk)), false, nil
+	case info == 26:
+		chunk, err := r.next(4)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return major, uint64(binary.BigEndian.Uint32(chunk)), false, nil
+	case info == 27:
+		chunk, err := r.next(8)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return major, binary.BigEndian.Uint64(chunk), false, nil
+	case info == 31:
+		return major, 0, true, nil
+	default:
+		return 0, 0, false, fmt.Errorf("%w: reserved additional info", errInvalidCBOR)
+	}
+}
+
+func (r *cborReader) readItem(out *bytes.Buffer, depth int) error {
+	if depth > maxCBORNestingDepth {
+		return fmt.Errorf("%w: nesting too deep", errInvalidCBOR)
+	}
+	start := r.pos
+	major, argument, indefinite, err := r.readHead()
+	if err != nil {
+		return err
+	}
+	if indefinite && major != 3 && major != 4 && major != 5 {
+		return fmt.Errorf("%w: unsupported indefinite item", errInvalidCBOR)
+	}
+
+	switch major {
+	case 0:
+		out.WriteString(strconv.FormatUint(argument, 10))
+	case 1:
+		if argument > math.MaxInt64 {
+			return fmt.Errorf("%w: negative integer out of range", errInvalidCBOR)
+		}
+		out.WriteString(strconv.FormatInt(-1-int64(argument), 10))
+	case 3:
+		text, err := r.readText(argument, indefinite)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(text)
+		if err != nil {
+			return err
+		}
+		out.Write(encoded)
+	case 4:
+		out.WriteByte('[')
+		for i := uint64(0); indefinite || i < argument; i++ {
+			if indefinite && r.peekBreak() {
+				r.pos++
+				break
+			}
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := r.readItem(out, depth+1); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	case 5:
+		out.WriteByte('{')
+		for i := uint64(0); indefinite || i < argument; i++ {
+			if indefinite && r.peekBreak() {
+				r.pos++
+				break
+			}
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			keyMajor, keyLength, keyIndefinite, err := r.readHead()
+			if err != nil {
+				return err
+			}
+			if keyMajor != 3 {
+				return fmt.Errorf("%w: map keys must be text strings", errInvalidCBOR)
+			}
+			key, err := r.readText(keyLength, keyIndefinite)
+			if err != nil {
+				return err
+			}
+			encodedKey, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			out.Write(encodedKey)
+			out.WriteByte(':')
+			if err := r.readItem(out, depth+1); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	case 7:
+		return r.writeSimple(out, r.data[start]&0x1f, argument)
+	default:
+		return fmt.Errorf("%w: unsupported major type %d", errInvalidCBOR, major)
+	}
+	return nil
+}
+
+func (r *cborReader) readText(length uint64, indefinite bool) (string, error) {
+	if !indefinite {
+		if length > uint64(len(r.data)-r.pos) {
+			return "", fmt.Errorf("%w: unexpected end of data", errInvalidCBOR)
+		}
+		chunk, err := r.next(int(length))
+		if err != nil {
+			return "", err
+		}
+		return string(chunk), nil
+	}
+
+	var builder strings.Builder
+	for {
+		if r.peekBreak() {
+			r.pos++
+			return builder.String(), nil
+		}
+		major, chunkLength, chunkIndefinite, err := r.readHead()
+		if err != nil {
+			return "", err
+		}
+		if major != 3 || chunkIndefinite {
+			return "", fmt.Errorf("%w: invalid text chunk", errInvalidCBOR)
+		}
+		chunk, err := r.readText(chunkLength, false)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(chunk)
+	}
+}
+
+func (r *cborReader) writeSimple(out *bytes.Buffer, info byte, argument uint64) error {
+	var value float64
+	switch info {
+	case 20:
+		out.WriteString("false")
+		return nil
+	case 21:
+		out.WriteString("true")
+		return nil
+	case 22, 23:
+		out.WriteString("null")
+		return nil
+	case 25:
+		value = float16ToFloat64(uint16(argument))
+	case 26:
+		value = float64(math.Float32frombits(uint32(argument)))
+	case 27:
+		value = math.Float64frombits(argument)
+	default:
+		return fmt.Errorf("%w: unsupported simple value", errInvalidCBOR)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("%w: non-finite number", errInvalidCBOR)
+	}
+	out.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	return nil
+}
+
+func float16ToFloat64(bits uint16) float64 {
+	sign := 1.0
+	if bits&0x8000 != 0 {
+		sign = -1.0
+	}
+	exponent := int((bits >> 10) & 0x1f)
+	fraction := float64(bits & 0x3ff)
+	switch exponent {
+	case 0:
+		return sign * math.Ldexp(fraction, -24)
+	case 0x1f:
+		if fraction == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	default:
+		return sign * math.Ldexp(fraction+1024, exponent-25)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestJSONToCBORRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	input := []byte(`{"type":"ciphertext","id":42,"roomId":-7,"ratio":1.5,"ok":true,"none":null,` +
+		`"wrappedKeys":{"1:dev":"abc","2:dev":"déf"},"mentions":[1,2,300,70000,5000000000]}`)
+	encoded, err := jsonToCBOR(input)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if len(encoded) >= len(input) {
+		t.Fatalf("expected cbor frame to be smaller than json (%d >= %d)", len(encoded), len(input))
+	}
+	decoded, err := cborToJSON(encoded)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+
+	var want, got any
+	if err := json.Unmarshal(input, &want); err != nil {
+		t.Fatalf("unmarshal input: %v", err)
+	}
+	if err := json.Unmarshal(decoded, &got); err != nil {
+		t.Fatalf("unmarshal decoded %s: %v", decoded, err)
+	}
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if !bytes.Equal(wantJSON, gotJSON) {
+		t.Fatalf("round trip mismatch:\nwant %s\ngot  %s", wantJSON, gotJSON)
+	}
+}
+
+func TestCBORToJSONDefiniteLengthItems(t *testing.T) {
+	t.Parallel()
+
+	// {"a": [1, -2, 1.5 (float16)], "b": "xy"} encoded with definite lengths.
+	raw, err := hex.DecodeString(stripSpaces("a2 6161 83 01 21 f93e00 6162 627879"))
+	if err != nil {
+		t.Fatalf("bad fixture: %v", err)
+	}
+	decoded, err := cborToJSON(raw)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if string(decoded) != `{"a":[1,-2,1.5],"b":"xy"}` {
+		t.Fatalf("unexpected json: %s", decoded)
+	}
+}
+
+func TestCBORToJSONRejectsUnsupportedInput(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"byte string":    "4101",
+		"non-text key":   "a10101",
+		"truncated":      "62 61",
+		"trailing bytes": "0101",
+		"tagged value":   "c101",
+		"non-finite":     "f97c00",
+	}
+	for name, value := range cases {
+		raw, err := hex.DecodeString(stripSpaces(value))
+		if err != nil {
+			t.Fatalf("%s: bad fixture: %v", name, err)
+		}
+		if _, err := cborToJSON(raw); !errors.Is(err, errInvalidCBOR) {
+			t.Fatalf("%s: expected errInvalidCBOR, got %v", name, err)
+		}
+	}
+}
+
+func TestWSCodecFrames(t *testing.T) {
+	t.Parallel()
+
+	if codec := wsCodecForSubprotocol(""); codec != wsCodecJSON {
+		t.Fatalf("expected json codec by default")
+	}
+	codec := wsCodecForSubprotocol(wsSubprotocolCBOR)
+	if codec != wsCodecCBOR {
+		t.Fatalf("expected cbor codec for negotiated subprotocol")
+	}
+
+	messageType, frame, err := codec.encodeFrame([]byte(`{"type":"room_peers"}`))
+	if err != nil || messageType != websocket.BinaryMessage {
+		t.Fatalf("expected binary frame, got type=%d err=%v", messageType, err)
+	}
+	decoded, err := codec.decodeFrame(websocket.BinaryMessage, frame)
+	if err != nil || string(decoded) != `{"type":"room_peers"}` {
+		t.Fatalf("unexpected decode result %s err=%v", decoded, err)
+	}
+	if _, err := codec.decodeFrame(websocket.TextMessage, []byte(`{}`)); !errors.Is(err, errInvalidCBOR) {
+		t.Fatalf("expected text frames to be rejected on cbor connections")
+	}
+}
+
+func TestWSAuthTokenFromRequest(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		protocol string
+		bearer   string
+		cookie   string
+		want     string
+		wantErr  bool
+	}{
+		{name: "subprotocol", protocol: wsSubprotocolCBOR + ", " + wsSubprotocolAuthPrefix + "sub-token", want: "sub-token"},
+		{name: "bearer wins", protocol: wsSubprotocolJSON + ", " + wsSubprotocolAuthPrefix + "sub-token", bearer: "header-token", want: "header-token"},
+		{name: "subprotocol over cookie", protocol: wsSubprotocolJSON + ", " + wsSubprotocolAuthPrefix + "sub-token", cookie: "cookie-token", want: "sub-token"},
+		{name: "cookie", protocol: wsSubprotocolJSON, cookie: "cookie-token", want: "cookie-token"},
+		{name: "token without encoding", protocol: wsSubprotocolAuthPrefix + "sub-token", wantErr: true},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/ws?room_id=1", nil)
+		if tc.protocol != "" {
+			req.Header.Set("Sec-WebSocket-Protocol", tc.protocol)
+		}
+		if tc.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.bearer)
+		}
+		if tc.cookie != "" {
+			req.Header.Set("Cookie", authCookieName+"="+tc.cookie)
+		}
+		got, err := wsAuthTokenFromRequest(req)
+		if tc.wantErr {
+			if !errors.Is(err, errWSAuthSubprotocolAlone) {
+				t.Fatalf("%s: expected errWSAuthSubprotocolAlone, got %q err=%v", tc.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Fatalf("%s: expected %q, got %q err=%v", tc.name, tc.want, got, err)
+		}
+	}
+}
+
+func stripSpaces(value string) string {
+	return string(bytes.ReplaceAll([]byte(value), []byte(" "), nil))
+}
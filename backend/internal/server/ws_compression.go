@@ -0,0 +1,31 @@
+package server
+
+import "github.com/gorilla/websocket"
+
+const (
+	defaultWSCompressionEnabled   = true
+	defaultWSCompressionLevel     = 1
+	defaultWSCompressionThreshold = 1024
+)
+
+// wsCompressionConfig controls permessage-deflate. Compression is negotiated
+// per connection; frames below Threshold bytes are sent uncompressed because
+// deflate overhead outweighs the savings on small control frames.
+type wsCompressionConfig struct {
+	Enabled   bool
+	Level     int
+	Threshold int
+}
+
+func (cfg wsCompressionConfig) configureConn(conn *websocket.Conn) {
+	if !cfg.Enabled {
+		return
+	}
+	if err := conn.SetCompressionLevel(cfg.Level); err != nil {
+		logger.Warn("websocket_compression_level_invalid", "level", cfg.Level, "error", err)
+	}
+}
+
+func (cfg wsCompressionConfig) shouldCompress(size int) bool {
+	return cfg.Enabled && size >= cfg.Threshold
+}
@@ -0,0 +1,29 @@
+package server
+
+import "testing"
+
+func TestWSCompressionShouldCompress(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		cfg      wsCompressionConfig
+		size     int
+		expected bool
+	}{
+		{name: "disabled", cfg: wsCompressionConfig{Enabled: false, Threshold: 10}, size: 4096, expected: false},
+		{name: "below threshold", cfg: wsCompressionConfig{Enabled: true, Threshold: 1024}, size: 512, expected: false},
+		{name: "at threshold", cfg: wsCompressionConfig{Enabled: true, Threshold: 1024}, size: 1024, expected: true},
+		{name: "large frame", cfg: wsCompressionConfig{Enabled: true, Threshold: 1024}, size: 64 * 1024, expected: true},
+	}
+
+	for _, item := range cases {
+		item := item
+		t.Run(item.name, func(t *testing.T) {
+			t.Parallel()
+			if got := item.cfg.shouldCompress(item.size); got != item.expected {
+				t.Fatalf("expected %v, got %v", item.expected, got)
+			}
+		})
+	}
+}
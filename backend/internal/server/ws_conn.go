@@ -0,0 +1,25 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConn is the part of *websocket.Conn the read and write pumps use. Tests
+// drive the pumps through an in-memory implementation instead of a socket.
+type wsConn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	EnableWriteCompression(enable bool)
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+var _ wsConn = (*websocket.Conn)(nil)
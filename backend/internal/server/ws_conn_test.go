@@ -0,0 +1,246 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type fakeWSMessage struct {
+	messageType int
+	data        []byte
+}
+
+// fakeWSConn is an in-memory wsConn. Frames the test pushes into inbox are
+// read by readPump; everything the server writes lands in outbox.
+type fakeWSConn struct {
+	inbox     chan fakeWSMessage
+	outbox    chan fakeWSMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFakeWSConn() *fakeWSConn {
+	return &fakeWSConn{
+		inbox:  make(chan fakeWSMessage, 16),
+		outbox: make(chan fakeWSMessage, 64),
+		closed: make(chan struct{}),
+	}
+}
+
+func (f *fakeWSConn) ReadMessage() (int, []byte, error) {
+	select {
+	case message := <-f.inbox:
+		return message.messageType, message.data, nil
+	case <-f.closed:
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure}
+	}
+}
+
+func (f *fakeWSConn) WriteMessage(messageType int, data []byte) error {
+	select {
+	case <-f.closed:
+		return net.ErrClosed
+	default:
+	}
+	f.outbox <- fakeWSMessage{messageType: messageType, data: data}
+	return nil
+}
+
+func (f *fakeWSConn) WriteControl(messageType int, data []byte, _ time.Time) error {
+	return f.WriteMessage(messageType, data)
+}
+
+func (f *fakeWSConn) SetReadLimit(int64)                {}
+func (f *fakeWSConn) SetReadDeadline(time.Time) error   { return nil }
+func (f *fakeWSConn) SetWriteDeadline(time.Time) error  { return nil }
+func (f *fakeWSConn) SetPongHandler(func(string) error) {}
+func (f *fakeWSConn) EnableWriteCompression(bool)       {}
+func (f *fakeWSConn) RemoteAddr() net.Addr              { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+func (f *fakeWSConn) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *fakeWSConn) push(t *testing.T, frame WSIncoming) {
+	t.Helper()
+	raw, err := json.Marshal(frame)
+	if err != nil {
+		t.Fatalf("marshal frame: %v", err)
+	}
+	f.inbox <- fakeWSMessage{messageType: websocket.TextMessage, data: raw}
+}
+
+// expect returns the next text frame the server wrote, failing unless it has
+// the wanted type.
+func (f *fakeWSConn) expect(t *testing.T, frameType string) map[string]any {
+	t.Helper()
+	select {
+	case message := <-f.outbox:
+		var frame map[string]any
+		if err := json.Unmarshal(message.data, &frame); err != nil {
+			t.Fatalf("decode server frame %q: %v", message.data, err)
+		}
+		if frame["type"] != frameType {
+			t.Fatalf("expected %s frame, got %s", frameType, message.data)
+		}
+		return frame
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s frame", frameType)
+	}
+	return nil
+}
+
+func (f *fakeWSConn) expectQuiet(t *testing.T) {
+	t.Helper()
+	select {
+	case message := <-f.outbox:
+		t.Fatalf("expected no frame, got %s", message.data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// wsHarness runs real read and write pumps over fake connections, backed by
+// an App with only in-memory state.
+type wsHarness struct {
+	app *App
+}
+
+func newWSHarness() *wsHarness {
+	return &wsHarness{app: &App{hub: NewHub(), membership: newMembershipCache(time.Minute)}}
+}
+
+func (h *wsHarness) connect(t *testing.T, userID int64, deviceID string, roomID int64) (*Client, *fakeWSConn, <-chan struct{}) {
+	t.Helper()
+	conn := newFakeWSConn()
+	client := &Client{
+		app:      h.app,
+		conn:     conn,
+		send:     make(chan []byte, 16),
+		userID:   userID,
+		username: "user",
+		deviceID: deviceID,
+		roomID:   roomID,
+	}
+	h.app.membership.Store(userID, roomID, true)
+	h.app.hub.AddClient(client)
+	done := make(chan struct{})
+	go client.writePump()
+	go func() {
+		client.readPump()
+		close(done)
+	}()
+	t.Cleanup(func() { _ = conn.Close() })
+	return client, conn, done
+}
+
+// signedKeyAnnounce builds a key_announce frame signed the way a client
+// proves possession of its signing key.
+func signedKeyAnnounce(t *testing.T, userID int64, deviceID string, signingKey *ecdsa.PrivateKey, publicJWK, signingJWK json.RawMessage) WSIncoming {
+	t.Helper()
+	canonical, err := canonicalKeyAnnouncePayload(userID, deviceID, publicJWK, signingJWK)
+	if err != nil {
+		t.Fatalf("canonical key announce: %v", err)
+	}
+	return WSIncoming{
+		Type:                "key_announce",
+		PublicKeyJWK:        publicJWK,
+		SigningPublicKeyJWK: signingJWK,
+		Signature:           signWithECDSA(t, signingKey, canonical),
+	}
+}
+
+func TestWSHarnessAnnounceCiphertextEdit(t *testing.T) {
+	t.Parallel()
+
+	h := newWSHarness()
+	alice, aliceConn, aliceDone := h.connect(t, 1, "alice-phone", 9)
+	_, bobConn, _ := h.connect(t, 2, "bob-laptop", 9)
+
+	signingKey, harnessSigningKey := makeECDSAP256JWK(t)
+	publicJWK := json.RawMessage(`{"crv":"P-256","kty":"EC","x":"pub-x","y":"pub-y"}`)
+
+	// An announce signed for another device does not prove possession for
+	// this one and is refused.
+	aliceConn.push(t, signedKeyAnnounce(t, 1, "alice-tablet", signingKey, publicJWK, harnessSigningKey))
+	if frame := aliceConn.expect(t, "protocol_error"); frame["code"] != protocolErrorInvalidKeyAnnounce {
+		t.Fatalf("expected invalid key announce error, got %v", frame)
+	}
+	bobConn.expectQuiet(t)
+
+	aliceConn.push(t, signedKeyAnnounce(t, 1, "alice-phone", signingKey, publicJWK, harnessSigningKey))
+	for _, conn := range []*fakeWSConn{aliceConn, bobConn} {
+		if peer := conn.expect(t, "peer_key"); peer["deviceId"] != "alice-phone" {
+			t.Fatalf("unexpected peer_key frame: %v", peer)
+		}
+	}
+
+	cipher := WSIncoming{
+		Version:             2,
+		Ciphertext:          "c2VjcmV0",
+		MessageIV:           "aXY=",
+		WrappedKeys:         map[string]WrappedKey{"2:bob-laptop": {}},
+		SenderSigningPubJWK: harnessSigningKey,
+		Signature:           "c2ln",
+	}
+
+	// Signed with a key alice never announced: dropped without a reply.
+	forged := cipher
+	forged.Type = "ciphertext"
+	forged.SenderSigningPubJWK = json.RawMessage(`{"kty":"EC","x":"other"}`)
+	aliceConn.push(t, forged)
+	aliceConn.expectQuiet(t)
+
+	// Announced key but a legacy payload: rejected with a protocol error.
+	legacy := cipher
+	legacy.Type = "ciphertext"
+	aliceConn.push(t, legacy)
+	if frame := aliceConn.expect(t, "protocol_error"); frame["code"] != protocolErrorLegacyPayload {
+		t.Fatalf("expected legacy payload error, got %v", frame)
+	}
+
+	edit := cipher
+	edit.Type = "message_update"
+	edit.Mode = "edit"
+	edit.MessageID = 41
+	aliceConn.push(t, edit)
+	if frame := aliceConn.expect(t, "protocol_error"); frame["code"] != protocolErrorLegacyPayload {
+		t.Fatalf("expected legacy payload error for edit, got %v", frame)
+	}
+	bobConn.expectQuiet(t)
+
+	_ = aliceConn.Close()
+	select {
+	case <-aliceDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("readPump did not stop after the connection closed")
+	}
+	if left := bobConn.expect(t, "peer_left"); left["deviceId"] != "alice-phone" {
+		t.Fatalf("unexpected peer_left frame: %v", left)
+	}
+	if alice.context().Err() == nil {
+		t.Fatal("expected the closed connection's context to be cancelled")
+	}
+}
+
+func TestWSHarnessDropsFramesFromFormerMembers(t *testing.T) {
+	t.Parallel()
+
+	h := newWSHarness()
+	_, conn, _ := h.connect(t, 3, "carol-tablet", 4)
+	h.app.membership.Store(3, 4, false)
+
+	conn.push(t, WSIncoming{Type: "message_update", Mode: "revoke", MessageID: 7})
+	conn.push(t, WSIncoming{Type: "presence_update", Status: "sleeping"})
+	// Presence needs no membership, so its error proves the revoke before it
+	// was consumed and dropped.
+	if frame := conn.expect(t, "protocol_error"); frame["code"] != "invalid_presence" {
+		t.Fatalf("expected invalid_presence, got %v", frame)
+	}
+}
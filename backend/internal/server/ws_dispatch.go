@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// Persisting frames share one token bucket per connection. The burst
+	// absorbs an offline outbox flushing on reconnect.
+	wsFrameRateLimit = 20
+	wsFrameRateBurst = 60
+
+	protocolErrorRateLimited     = "rate_limited"
+	protocolErrorFeatureDisabled = "feature_disabled"
+)
+
+// wsFrame is one decoded client frame on its way through the middleware
+// chain. Middleware fills ctx and payload for the handlers that asked for them.
+type wsFrame struct {
+	incoming WSIncoming
+	// ctx is set by requireMembership and lives until the handler returns.
+	ctx context.Context
+	// payload is set by requireSignedCipher.
+	payload CipherPayload
+}
+
+type frameHandler func(c *Client, f *wsFrame)
+
+type frameMiddleware func(next frameHandler) frameHandler
+
+// withFrameMiddleware wraps handler so that middleware runs in the order
+// listed, the first one outermost.
+func withFrameMiddleware(handler frameHandler, middleware ...frameMiddleware) frameHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// wsFrameHandlers routes frames by type. Frames of any other type are ignored,
+// so clients can send frames a newer server understands.
+var wsFrameHandlers = map[string]frameHandler{
+	"key_announce":    (*Client).handleKeyAnnounceFrame,
+	"typing_status":   withFrameMiddleware((*Client).handleTypingFrame, requireMembership),
+	"presence_update": (*Client).handlePresenceFrame,
+	"ciphertext": withFrameMiddleware((*Client).handleCiphertextFrame,
+		limitFrameRate, requireSignedCipher, requireMembership),
+	"read_receipt":            withFrameMiddleware((*Client).handleReadReceiptFrame, limitFrameRate, requireMembership),
+	"message_update":          withFrameMiddleware((*Client).handleMessageUpdateFrame, limitFrameRate, requireMembership),
+	"decrypt_ack":             withFrameMiddleware((*Client).handleDecryptAckFrame, requireMembership),
+	"poll_vote":               withFrameMiddleware((*Client).handlePollVoteFrame, limitFrameRate, requireMembership),
+	"dr_handshake":            (*Client).handleHandshakeFrame,
+	"sender_key_distribution": (*Client).handleSenderKeyFrame,
+	"call_offer":              withFrameMiddleware((*Client).handleCallSignalFrame, limitCallSignalRate),
+	"call_answer":             (*Client).handleCallSignalFrame,
+	"ice_candidate":           withFrameMiddleware((*Client).handleCallSignalFrame, limitCallSignalRate),
+	"call_end":                (*Client).handleCallSignalFrame,
+	"decrypt_recovery_request": withFrameMiddleware((*Client).handleDecryptRecoveryRequestFrame,
+		limitFrameRate, requireMembership),
+	"decrypt_recovery_payload": withFrameMiddleware((*Client).handleDecryptRecoveryPayloadFrame,
+		limitFrameRate, requireSignedCipher, requireMembership),
+	"history_key_request": withFrameMiddleware((*Client).handleHistoryKeyRequestFrame,
+		limitFrameRate, requireMembership),
+	"history_key_response": withFrameMiddleware((*Client).handleHistoryKeyResponseFrame,
+		limitFrameRate, requireSignedCipher, requireMembership),
+}
+
+// dispatchFrame decodes one raw frame and hands it to its handler. Frames
+// over the size limit are refused before anything else is done with them.
+func (c *Client) dispatchFrame(raw []byte) {
+	if limit := c.app.roomLimits.maxFrameBytes(); len(raw) > limit {
+		c.rejectOversizedFrame(raw, limit)
+		return
+	}
+	var incoming WSIncoming
+	if err := json.Unmarshal(raw, &incoming); err != nil {
+		return
+	}
+	handler, ok := wsFrameHandlers[incoming.Type]
+	if !ok {
+		return
+	}
+	handler(c, &wsFrame{incoming: incoming})
+}
+
+// rejectOversizedFrame answers a frame over limit with a payload_too_large
+// protocol error naming the limit. Only the frame type is decoded.
+func (c *Client) rejectOversizedFrame(raw []byte, limit int) {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(raw, &envelope)
+	c.log().Warn("websocket_frame_too_large",
+		"user_id", c.userID,
+		"room_id", c.roomID,
+		"frame_type", envelope.Type,
+		"size", len(raw),
+		"limit", limit,
+	)
+	c.sendProtocolErrorDetails(protocolErrorPayloadTooLarge,
+		fmt.Sprintf("消息体积为 %d KB，超过服务器上限 %d KB，消息未发送。", (len(raw)+1023)/1024, limit/1024),
+		map[string]any{"frameType": envelope.Type, "size": len(raw), "limit": limit},
+	)
+}
+
+// requireMembership drops frames from senders no longer in the room. The
+// check goes through the membership cache, so a burst of frames costs at most
+// one query.
+func requireMembership(next frameHandler) frameHandler {
+	return func(c *Client, f *wsFrame) {
+		ctx, cancel := context.WithTimeout(c.context(), 5*time.Second)
+		defer cancel()
+		if err := c.app.ensureMembership(ctx, c.userID, c.roomID); err != nil {
+			return
+		}
+		f.ctx = ctx
+		next(c, f)
+	}
+}
+
+// requireSignedCipher builds the frame's cipher payload and drops it unless
+// it is well-formed and signed by the key the device announced.
+func requireSignedCipher(next frameHandler) frameHandler {
+	return func(c *Client, f *wsFrame) {
+		payload, ok := c.signedCipherPayload(f.incoming)
+		if !ok {
+			return
+		}
+		f.payload = payload
+		next(c, f)
+	}
+}
+
+// limitFrameRate throttles frames that write to the database.
+func limitFrameRate(next frameHandler) frameHandler {
+	return func(c *Client, f *wsFrame) {
+		if !c.allowFrame() {
+			c.log().Warn("websocket_frame_rate_limited", "user_id", c.userID, "room_id", c.roomID, "frame_type", f.incoming.Type)
+			c.sendProtocolError(protocolErrorRateLimited, "发送过于频繁，请稍后再试。")
+			return
+		}
+		next(c, f)
+	}
+}
+
+// limitCallSignalRate caps call offers and ICE candidates per user across
+// all of their connections. Neither writes to the database, but each one
+// reaches another member's devices.
+func limitCallSignalRate(next frameHandler) frameHandler {
+	return func(c *Client, f *wsFrame) {
+		if !c.app.hub.calls.AllowSignal(f.incoming.Type, c.userID) {
+			c.log().Warn("websocket_call_signal_rate_limited", "user_id", c.userID, "room_id", c.roomID, "frame_type", f.incoming.Type)
+			c.sendProtocolError(protocolErrorRateLimited, "通话信令过于频繁，请稍后再试。")
+			return
+		}
+		next(c, f)
+	}
+}
+
+func (c *Client) allowFrame() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frameLimiter == nil {
+		c.frameLimiter = rate.NewLimiter(wsFrameRateLimit, wsFrameRateBurst)
+	}
+	return c.frameLimiter.Allow()
+}
+
+// signedCipherPayload runs the checks every signed cipher frame shares: the
+// sender device and keys must match what the connection announced, the
+// payload must be a valid v3 payload within room limits, and its signature
+// must verify.
+func (c *Client) signedCipherPayload(incoming WSIncoming) (CipherPayload, bool) {
+	if !incoming.hasCipherBody() || incoming.Signature == "" {
+		return CipherPayload{}, false
+	}
+	senderDeviceID := normalizeDeviceID(incoming.SenderDeviceID)
+	if senderDeviceID == "" {
+		senderDeviceID = c.deviceID
+	}
+	if senderDeviceID != c.deviceID {
+		return CipherPayload{}, false
+	}
+	if len(incoming.SenderSigningPubJWK) == 0 || !json.Valid(incoming.SenderSigningPubJWK) {
+		return CipherPayload{}, false
+	}
+	announcedSigning := c.getSigningPublicKey()
+	if len(announcedSigning) == 0 || !jsonEqualCanonical(announcedSigning, incoming.SenderSigningPubJWK) {
+		return CipherPayload{}, false
+	}
+
+	senderPub := incoming.SenderPublicJWK
+	if len(senderPub) == 0 {
+		senderPub = c.getPublicKey()
+	}
+	if len(senderPub) == 0 || !json.Valid(senderPub) {
+		return CipherPayload{}, false
+	}
+	announcedPub := c.getPublicKey()
+	if len(announcedPub) > 0 && !jsonEqualCanonical(announcedPub, senderPub) {
+		return CipherPayload{}, false
+	}
+
+	payload := CipherPayload{
+		Version:                   incoming.Version,
+		Ciphertext:                incoming.Ciphertext,
+		MessageIV:                 incoming.MessageIV,
+		WrappedKeys:               incoming.WrappedKeys,
+		SenderPublicJWK:           senderPub,
+		SenderSigningPubJWK:       incoming.SenderSigningPubJWK,
+		Signature:                 incoming.Signature,
+		ContentType:               incoming.ContentType,
+		SenderDeviceID:            senderDeviceID,
+		EncryptionScheme:          incoming.EncryptionScheme,
+		PollOptionCount:           incoming.PollOptionCount,
+		SenderKeyID:               incoming.SenderKeyID,
+		SignatureCanonicalization: incoming.SignatureCanonicalization,
+		Nonce:                     incoming.Nonce,
+		SignedAt:                  incoming.SignedAt,
+		KeyEpoch:                  incoming.KeyEpoch,
+	}
+	if err := validateV3CipherPayload(payload); err != nil {
+		c.rejectInvalidPayload(incoming.Type, err)
+		return CipherPayload{}, false
+	}
+	if err := c.app.roomLimits.validateCipherPayload(payload); err != nil {
+		c.rejectInvalidPayload(incoming.Type, err)
+		return CipherPayload{}, false
+	}
+	if err := verifyCipherSignature(payload); err != nil {
+		c.log().Warn(
+			"drop_invalid_cipher_signature",
+			"user_id",
+			c.userID,
+			"room_id",
+			c.roomID,
+			"frame_type",
+			incoming.Type,
+			"message_id",
+			incoming.MessageID,
+			"error",
+			err,
+		)
+		return CipherPayload{}, false
+	}
+	// Only a verified signature may consume a nonce, otherwise anyone could
+	// burn nonces the real sender is about to use.
+	if err := c.app.signatureReplay.Check(c.userID, c.deviceID, payload.Nonce, payload.SignedAt); err != nil {
+		c.rejectInvalidPayload(incoming.Type, err)
+		return CipherPayload{}, false
+	}
+	return payload, true
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrameHandlersCoverProtocol(t *testing.T) {
+	t.Parallel()
+
+	for _, frameType := range []string{
+		"key_announce", "ciphertext", "typing_status", "presence_update", "read_receipt",
+		"message_update", "decrypt_ack", "poll_vote", "dr_handshake", "sender_key_distribution",
+		"call_offer", "call_answer", "ice_candidate", "call_end",
+		"decrypt_recovery_request", "decrypt_recovery_payload",
+		"history_key_request", "history_key_response",
+	} {
+		if wsFrameHandlers[frameType] == nil {
+			t.Fatalf("expected a handler for %q", frameType)
+		}
+	}
+}
+
+func TestWithFrameMiddlewareRunsInListedOrder(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+	record := func(name string) frameMiddleware {
+		return func(next frameHandler) frameHandler {
+			return func(c *Client, f *wsFrame) {
+				calls = append(calls, name)
+				next(c, f)
+			}
+		}
+	}
+	handler := withFrameMiddleware(func(*Client, *wsFrame) { calls = append(calls, "handler") }, record("outer"), record("inner"))
+	handler(&Client{}, &wsFrame{})
+	if strings.Join(calls, ",") != "outer,inner,handler" {
+		t.Fatalf("unexpected call order: %v", calls)
+	}
+}
+
+func TestRequireMembershipUsesCache(t *testing.T) {
+	t.Parallel()
+
+	app := &App{membership: newMembershipCache(time.Minute)}
+	app.membership.Store(1, 5, true)
+	app.membership.Store(2, 5, false)
+
+	var reached []int64
+	handler := requireMembership(func(c *Client, f *wsFrame) {
+		if f.ctx == nil {
+			t.Fatal("expected membership middleware to provide a context")
+		}
+		reached = append(reached, c.userID)
+	})
+	handler(&Client{app: app, userID: 1, roomID: 5}, &wsFrame{})
+	handler(&Client{app: app, userID: 2, roomID: 5}, &wsFrame{})
+	if len(reached) != 1 || reached[0] != 1 {
+		t.Fatalf("expected only the member to reach the handler, got %v", reached)
+	}
+}
+
+func TestRequireSignedCipherDropsUnsignedFrames(t *testing.T) {
+	t.Parallel()
+
+	called := false
+	handler := requireSignedCipher(func(*Client, *wsFrame) { called = true })
+	client := &Client{app: &App{}, deviceID: "d1", send: make(chan []byte, 1)}
+	handler(client, &wsFrame{incoming: WSIncoming{Type: "ciphertext", Ciphertext: "abc", MessageIV: "iv", SenderKeyID: "k1"}})
+	if called {
+		t.Fatal("expected frame without signature to be dropped")
+	}
+}
+
+func TestLimitFrameRateRejectsBurst(t *testing.T) {
+	t.Parallel()
+
+	handled := 0
+	handler := limitFrameRate(func(*Client, *wsFrame) { handled++ })
+	client := &Client{send: make(chan []byte, 1)}
+	for i := 0; i < wsFrameRateBurst+1; i++ {
+		handler(client, &wsFrame{incoming: WSIncoming{Type: "ciphertext"}})
+	}
+	if handled != wsFrameRateBurst {
+		t.Fatalf("expected %d frames through, got %d", wsFrameRateBurst, handled)
+	}
+	var frame ProtocolErrorFrame
+	if err := json.Unmarshal(<-client.send, &frame); err != nil || frame.Code != protocolErrorRateLimited {
+		t.Fatalf("expected rate_limited protocol error, got %+v", frame)
+	}
+}
+
+func TestSendEditConflictReportsCurrentRevision(t *testing.T) {
+	t.Parallel()
+
+	client := &Client{roomID: 5, send: make(chan []byte, 1)}
+	client.sendEditConflict(42, 3)
+
+	var frame struct {
+		Type            string `json:"type"`
+		RoomID          int64  `json:"roomId"`
+		MessageID       int64  `json:"messageId"`
+		CurrentRevision int    `json:"currentRevision"`
+	}
+	if err := json.Unmarshal(<-client.send, &frame); err != nil {
+		t.Fatalf("decode conflict frame: %v", err)
+	}
+	if frame.Type != "message_edit_conflict" || frame.RoomID != 5 || frame.MessageID != 42 || frame.CurrentRevision != 3 {
+		t.Fatalf("unexpected conflict frame: %+v", frame)
+	}
+}
+
+func TestDispatchFrameRejectsOversizedFrame(t *testing.T) {
+	t.Parallel()
+
+	app := &App{roomLimits: roomLimitsConfig{MaxPayloadBytes: 1024}}
+	client := &Client{app: app, send: make(chan []byte, 1), userID: 1, roomID: 5}
+	limit := app.roomLimits.maxFrameBytes()
+	raw := []byte(`{"type":"ciphertext","ciphertext":"` + strings.Repeat("A", limit) + `"}`)
+	client.dispatchFrame(raw)
+
+	select {
+	case payload := <-client.send:
+		var frame ProtocolErrorFrame
+		if err := json.Unmarshal(payload, &frame); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if frame.Code != protocolErrorPayloadTooLarge || frame.RoomID != 5 {
+			t.Fatalf("unexpected frame %+v", frame)
+		}
+		if frame.Details["limit"] != float64(limit) || frame.Details["size"] != float64(len(raw)) || frame.Details["frameType"] != "ciphertext" {
+			t.Fatalf("unexpected details %v", frame.Details)
+		}
+	default:
+		t.Fatal("expected a protocol error for the oversized frame")
+	}
+}
@@ -0,0 +1,502 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+func (c *Client) handleKeyAnnounceFrame(f *wsFrame) {
+	incoming := f.incoming
+	if len(incoming.PublicKeyJWK) == 0 || !json.Valid(incoming.PublicKeyJWK) {
+		return
+	}
+	if len(incoming.SigningPublicKeyJWK) == 0 || !json.Valid(incoming.SigningPublicKeyJWK) {
+		return
+	}
+	// Announced keys are what ciphertext signatures are checked against, so
+	// the device has to prove it holds the signing key first.
+	if err := verifyKeyAnnounceSignature(c.userID, c.deviceID, incoming.PublicKeyJWK, incoming.SigningPublicKeyJWK, incoming.Signature); err != nil {
+		c.log().Warn("drop_invalid_key_announce", "user_id", c.userID, "room_id", c.roomID, "device_id", c.deviceID, "error", err)
+		c.sendProtocolError(protocolErrorInvalidKeyAnnounce, "设备密钥声明签名无效，请刷新页面后重试。")
+		return
+	}
+	c.setAnnouncedKeys(incoming.PublicKeyJWK, incoming.SigningPublicKeyJWK, incoming.Signature)
+
+	if c.app.db != nil {
+		ctx, cancel := context.WithTimeout(c.context(), 5*time.Second)
+		err := c.app.storeAnnouncedKeys(ctx, c.roomID, c.userID, c.deviceID, incoming.PublicKeyJWK, incoming.SigningPublicKeyJWK, incoming.Signature)
+		cancel()
+		if err != nil {
+			c.log().Warn("store_announced_keys_failed", "user_id", c.userID, "room_id", c.roomID, "device_id", c.deviceID, "error", err)
+		}
+	}
+	if payload, err := peerKeyFrame(c, incoming.PublicKeyJWK, incoming.SigningPublicKeyJWK, incoming.Signature); err == nil {
+		c.app.hub.Broadcast(c.roomID, payload)
+	}
+}
+
+func (c *Client) handleCiphertextFrame(f *wsFrame) {
+	payload := f.payload
+	mentions, err := normalizeMentions(f.incoming.Mentions, c.userID)
+	if err != nil {
+		c.rejectInvalidPayload("ciphertext", errInvalidPayloadFormat)
+		return
+	}
+
+	decision, err := c.app.loadRoomSendDecision(f.ctx, c.userID, c.role, c.roomID, payload)
+	if err != nil {
+		return
+	}
+	if !decision.Allowed {
+		c.sendProtocolError(decision.Code, decision.Error)
+		return
+	}
+	if payload.EncryptionScheme == encryptionSchemeSenderKey {
+		if !c.app.features.Enabled(featureSenderKeys) {
+			c.sendProtocolError(protocolErrorFeatureDisabled, "sender keys are disabled on this server")
+			return
+		}
+		keyID, err := c.app.loadSenderKeyID(f.ctx, c.roomID, c.userID, c.deviceID)
+		if err != nil || keyID != payload.SenderKeyID {
+			c.sendProtocolError("unknown_sender_key", errSenderKeyUnknown.Error())
+			return
+		}
+	}
+	mentions, err = c.app.filterRoomMembers(f.ctx, c.roomID, mentions)
+	if err != nil {
+		return
+	}
+	// Persisting must outlive the connection: drain waits for messages
+	// already read instead of abandoning them.
+	c.app.persistCiphertext(context.WithoutCancel(c.context()), c, payload, mentions)
+}
+
+func (c *Client) handleTypingFrame(f *wsFrame) {
+	c.app.hub.typing.Update(c.roomID, c.userID, c.username, f.incoming.IsTyping)
+}
+
+func (c *Client) handlePresenceFrame(f *wsFrame) {
+	if !validPresenceStatus(f.incoming.Status) {
+		c.sendProtocolError("invalid_presence", "status must be online, away or dnd")
+		return
+	}
+	if status, changed := c.app.hub.presence.Set(c.userID, c.deviceID, f.incoming.Status); changed {
+		c.app.hub.BroadcastPresence(c.userID, c.username, status, 0)
+	}
+}
+
+func (c *Client) handleReadReceiptFrame(f *wsFrame) {
+	upToMessageID := f.incoming.UpToMessageID
+	if upToMessageID <= 0 {
+		return
+	}
+	eventID, err := c.app.applyReadReceipt(f.ctx, c.userID, c.roomID, upToMessageID)
+	if err != nil {
+		return
+	}
+	c.app.broadcastReadReceipt(c.roomID, c.userID, c.username, upToMessageID, eventID)
+}
+
+func (c *Client) handleMessageUpdateFrame(f *wsFrame) {
+	incoming := f.incoming
+	if incoming.MessageID <= 0 {
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(incoming.Mode)) {
+	case "revoke":
+		c.revokeOwnMessage(f.ctx, incoming.MessageID)
+	case "moderator_revoke":
+		c.moderatorRevoke(f.ctx, incoming.MessageID)
+	case "edit":
+		// Edits replace the ciphertext only; a message cannot turn into a poll.
+		incoming.PollOptionCount = 0
+		payload, ok := c.signedCipherPayload(incoming)
+		if !ok {
+			return
+		}
+		c.editOwnMessage(f.ctx, incoming.MessageID, payload, incoming.ExpectedRevision)
+	}
+}
+
+func (c *Client) revokeOwnMessage(ctx context.Context, messageID int64) {
+	revokedAt, eventID, err := c.app.revokeMessage(ctx, c.roomID, messageID, c.userID)
+	if err != nil {
+		return
+	}
+	if payload, err := json.Marshal(map[string]any{
+		"type":         "message_update",
+		"roomId":       c.roomID,
+		"messageId":    messageID,
+		"mode":         "revoke",
+		"fromUserId":   c.userID,
+		"fromUsername": c.username,
+		"revokedAt":    revokedAt.UTC().Format(time.RFC3339Nano),
+		"revokedBy":    c.userID,
+	}); err == nil {
+		c.app.broadcastEvent(c.roomID, eventID, c.userID, 0, payload)
+	}
+}
+
+func (c *Client) moderatorRevoke(ctx context.Context, messageID int64) {
+	decision, err := c.app.loadMessageModerationDecision(ctx, c.userID, c.role, c.roomID)
+	if err != nil {
+		return
+	}
+	if !decision.Allowed {
+		c.sendProtocolError(decision.Code, decision.Error)
+		return
+	}
+	senderID, revokedAt, eventID, err := c.app.moderatorRevokeMessage(ctx, c.roomID, messageID, c.userID)
+	if err != nil {
+		return
+	}
+	c.log().Info(
+		"message_moderator_revoked",
+		"moderator_id",
+		c.userID,
+		"sender_id",
+		senderID,
+		"room_id",
+		c.roomID,
+		"message_id",
+		messageID,
+	)
+	// Broadcast as a regular revoke so existing clients render it unchanged.
+	if payload, err := json.Marshal(map[string]any{
+		"type":         "message_update",
+		"roomId":       c.roomID,
+		"messageId":    messageID,
+		"mode":         "revoke",
+		"fromUserId":   c.userID,
+		"fromUsername": c.username,
+		"senderId":     senderID,
+		"revokedAt":    revokedAt.UTC().Format(time.RFC3339Nano),
+		"revokedBy":    c.userID,
+		"moderated":    true,
+	}); err == nil {
+		c.app.broadcastEvent(c.roomID, eventID, c.userID, 0, payload)
+	}
+}
+
+func (c *Client) editOwnMessage(ctx context.Context, messageID int64, payload CipherPayload, expectedRevision *int) {
+	decision, err := c.app.loadRoomPayloadDecision(ctx, c.roomID, payload)
+	if err != nil {
+		return
+	}
+	if !decision.Allowed {
+		c.sendProtocolError(decision.Code, decision.Error)
+		return
+	}
+
+	editedAt, revision, eventID, err := c.app.editMessage(ctx, c.roomID, messageID, c.userID, payload, expectedRevision)
+	var conflict *editConflictError
+	if errors.As(err, &conflict) {
+		c.sendEditConflict(messageID, conflict.CurrentRevision)
+		return
+	}
+	if errors.Is(err, errMessageRevoked) {
+		c.sendProtocolErrorDetails(protocolErrorMessageRevoked, "消息已被撤回，无法编辑。", map[string]any{"messageId": messageID})
+		return
+	}
+	var quotaErr *messageQuotaError
+	if errors.As(err, &quotaErr) {
+		c.sendProtocolError(quotaErr.Code, quotaErr.Error())
+		return
+	}
+	if err != nil {
+		return
+	}
+	if out, err := json.Marshal(map[string]any{
+		"type":         "message_update",
+		"roomId":       c.roomID,
+		"messageId":    messageID,
+		"mode":         "edit",
+		"fromUserId":   c.userID,
+		"fromUsername": c.username,
+		"editedAt":     editedAt.UTC().Format(time.RFC3339Nano),
+		"revision":     revision,
+		"payload":      payload,
+	}); err == nil {
+		c.app.broadcastEvent(c.roomID, eventID, c.userID, 0, out)
+	}
+}
+
+// sendEditConflict tells the editing device its edit was not applied. The
+// device should fetch the message, merge and retry with currentRevision.
+func (c *Client) sendEditConflict(messageID int64, currentRevision int) {
+	payload, err := json.Marshal(map[string]any{
+		"type":            "message_edit_conflict",
+		"roomId":          c.roomID,
+		"messageId":       messageID,
+		"currentRevision": currentRevision,
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- payload:
+	default:
+	}
+}
+
+func (c *Client) handleDecryptAckFrame(f *wsFrame) {
+	incoming := f.incoming
+	if incoming.MessageID <= 0 || strings.TrimSpace(incoming.AckSignature) == "" {
+		return
+	}
+	if len(incoming.SenderSigningPubJWK) == 0 || !json.Valid(incoming.SenderSigningPubJWK) {
+		return
+	}
+	announcedSigning := c.getSigningPublicKey()
+	if len(announcedSigning) == 0 || !jsonEqualCanonical(announcedSigning, incoming.SenderSigningPubJWK) {
+		return
+	}
+	if err := verifyAckSignature(incoming.SenderSigningPubJWK, c.roomID, incoming.MessageID, c.userID, incoming.AckSignature); err != nil {
+		c.log().Warn(
+			"drop_invalid_decrypt_ack",
+			"user_id",
+			c.userID,
+			"room_id",
+			c.roomID,
+			"message_id",
+			incoming.MessageID,
+			"error",
+			err,
+		)
+		return
+	}
+
+	senderID, err := c.app.loadMessageSender(f.ctx, c.roomID, incoming.MessageID)
+	if err != nil || senderID == c.userID {
+		return
+	}
+	if payload, err := json.Marshal(map[string]any{
+		"type":         "decrypt_ack",
+		"roomId":       c.roomID,
+		"messageId":    incoming.MessageID,
+		"fromUserId":   c.userID,
+		"fromUsername": c.username,
+	}); err == nil {
+		c.app.hub.Broadcast(c.roomID, payload)
+	}
+}
+
+func (c *Client) handlePollVoteFrame(f *wsFrame) {
+	incoming := f.incoming
+	if incoming.MessageID <= 0 || incoming.OptionIndex == nil {
+		return
+	}
+	if err := c.app.recordPollVote(f.ctx, c.roomID, incoming.MessageID, c.userID, *incoming.OptionIndex); err != nil {
+		if errors.Is(err, errPollOptionOutOfRange) || errors.Is(err, sql.ErrNoRows) {
+			c.sendProtocolError("invalid_poll_vote", "poll not found or option out of range")
+		}
+		return
+	}
+	tally, err := c.app.loadPollTally(f.ctx, c.roomID, incoming.MessageID, 0)
+	if err != nil {
+		return
+	}
+	// The acknowledgment carries only aggregate counts; who picked which
+	// option is never fanned out.
+	if payload, err := json.Marshal(map[string]any{
+		"type":         "poll_vote",
+		"roomId":       c.roomID,
+		"messageId":    incoming.MessageID,
+		"fromUserId":   c.userID,
+		"fromUsername": c.username,
+		"counts":       tally.Counts,
+		"totalVotes":   tally.TotalVotes,
+	}); err == nil {
+		c.app.hub.Broadcast(c.roomID, payload)
+	}
+}
+
+func (c *Client) handleHandshakeFrame(f *wsFrame) {
+	if err := c.relayHandshake(f.incoming); err != nil {
+		c.log().Debug(
+			"drop_dr_handshake",
+			"user_id",
+			c.userID,
+			"room_id",
+			c.roomID,
+			"error",
+			err,
+		)
+	}
+}
+
+func (c *Client) handleSenderKeyFrame(f *wsFrame) {
+	if !c.app.features.Enabled(featureSenderKeys) {
+		c.sendProtocolError(protocolErrorFeatureDisabled, "sender keys are disabled on this server")
+		return
+	}
+	if err := c.relaySenderKeyDistribution(f.incoming); err != nil {
+		c.log().Debug(
+			"drop_sender_key_distribution",
+			"user_id",
+			c.userID,
+			"room_id",
+			c.roomID,
+			"error",
+			err,
+		)
+		c.sendProtocolError("invalid_sender_key_distribution", err.Error())
+	}
+}
+
+func (c *Client) handleCallSignalFrame(f *wsFrame) {
+	if err := c.relayCallSignal(f.incoming); err != nil {
+		c.log().Debug(
+			"drop_call_signal",
+			"user_id",
+			c.userID,
+			"room_id",
+			c.roomID,
+			"frame_type",
+			f.incoming.Type,
+			"error",
+			err,
+		)
+		c.sendProtocolError("invalid_call_signal", err.Error())
+	}
+}
+
+func (c *Client) handleDecryptRecoveryRequestFrame(f *wsFrame) {
+	incoming := f.incoming
+	if incoming.MessageID <= 0 {
+		return
+	}
+	action := strings.ToLower(strings.TrimSpace(incoming.Action))
+	if action == "" {
+		action = "resync"
+	}
+	if action != "resync" {
+		return
+	}
+
+	senderID, err := c.app.loadMessageSender(f.ctx, c.roomID, incoming.MessageID)
+	if err != nil || senderID <= 0 || senderID == c.userID {
+		return
+	}
+	if c.app.hub.blocks.Between(c.userID, senderID) {
+		return
+	}
+
+	// The request is queued first so a sender that is offline, or drops it,
+	// is asked again when it next connects to the room.
+	req := decryptRecoveryRequest{
+		RoomID:            c.roomID,
+		MessageID:         incoming.MessageID,
+		RequesterID:       c.userID,
+		RequesterUsername: c.username,
+		RequesterDeviceID: c.deviceID,
+		SenderID:          senderID,
+		SenderDeviceID:    normalizeDeviceID(incoming.ToDeviceID),
+		Action:            action,
+	}
+	if req.ID, err = c.app.queueDecryptRecovery(f.ctx, req); err != nil {
+		c.log().Warn("queue_decrypt_recovery_failed", "user_id", c.userID, "room_id", c.roomID, "message_id", incoming.MessageID, "error", err)
+	}
+	if !c.app.hub.DeviceConnected(c.roomID, senderID, req.SenderDeviceID) {
+		return
+	}
+	payload, err := req.frame()
+	if err != nil {
+		return
+	}
+	if req.SenderDeviceID != "" {
+		c.app.hub.UnicastToDevice(c.roomID, senderID, req.SenderDeviceID, payload)
+	} else {
+		c.app.hub.Unicast(c.roomID, senderID, payload)
+	}
+	if req.ID > 0 {
+		if err := c.app.markDecryptRecoveryDelivered(f.ctx, req.ID); err != nil {
+			c.log().Warn("mark_decrypt_recovery_failed", "recovery_id", req.ID, "error", err)
+		}
+	}
+}
+
+func (c *Client) handleDecryptRecoveryPayloadFrame(f *wsFrame) {
+	incoming := f.incoming
+	if incoming.MessageID <= 0 || incoming.ToUserID <= 0 {
+		return
+	}
+	if err := c.app.ensureMembership(f.ctx, incoming.ToUserID, c.roomID); err != nil {
+		return
+	}
+	originalSenderID, err := c.app.loadMessageSender(f.ctx, c.roomID, incoming.MessageID)
+	if err != nil || originalSenderID != c.userID {
+		return
+	}
+	if c.app.hub.blocks.Between(c.userID, incoming.ToUserID) {
+		return
+	}
+
+	targetDeviceID := normalizeDeviceID(incoming.ToDeviceID)
+	frame := map[string]any{
+		"type":         "decrypt_recovery_payload",
+		"roomId":       c.roomID,
+		"messageId":    incoming.MessageID,
+		"fromUserId":   c.userID,
+		"fromUsername": c.username,
+		"fromDeviceId": c.deviceID,
+		"toUserId":     incoming.ToUserID,
+		"toDeviceId":   targetDeviceID,
+		"payload":      f.payload,
+	}
+	// A payload only settles the request if the requester can receive it now;
+	// otherwise the request stays open and is retried on a later connection.
+	if c.app.hub.DeviceConnected(c.roomID, incoming.ToUserID, targetDeviceID) {
+		ids, err := c.app.fulfillDecryptRecovery(f.ctx, c.roomID, incoming.MessageID, c.userID, incoming.ToUserID, targetDeviceID)
+		if err != nil {
+			c.log().Warn("fulfill_decrypt_recovery_failed", "user_id", c.userID, "room_id", c.roomID, "message_id", incoming.MessageID, "error", err)
+		} else if len(ids) > 0 {
+			frame["recoveryId"] = ids[0]
+		}
+	}
+	if out, err := json.Marshal(frame); err == nil {
+		if targetDeviceID != "" {
+			c.app.hub.UnicastToDevice(c.roomID, incoming.ToUserID, targetDeviceID, out)
+		} else {
+			c.app.hub.Unicast(c.roomID, incoming.ToUserID, out)
+		}
+	}
+}
+
+func (c *Client) handleHistoryKeyRequestFrame(f *wsFrame) {
+	if err := c.requestHistoryKeys(f.ctx, f.incoming); err != nil {
+		c.log().Warn("history_key_request_failed", "user_id", c.userID, "room_id", c.roomID, "device_id", c.deviceID, "error", err)
+		c.sendProtocolError("history_backfill_unavailable", "历史消息密钥补发请求失败，请稍后重试。")
+	}
+}
+
+func (c *Client) handleHistoryKeyResponseFrame(f *wsFrame) {
+	if err := c.relayHistoryKeys(f.ctx, f.incoming, f.payload); err != nil {
+		c.log().Debug(
+			"drop_history_key_response",
+			"user_id",
+			c.userID,
+			"room_id",
+			c.roomID,
+			"backfill_id",
+			f.incoming.BackfillID,
+			"error",
+			err,
+		)
+		c.sendProtocolError("invalid_history_key_response", err.Error())
+	}
+}
+
+// loadMessageSender returns who sent messageID, which must belong to roomID.
+func (a *App) loadMessageSender(ctx context.Context, roomID, messageID int64) (int64, error) {
+	var senderID int64
+	err := a.db.QueryRowContext(ctx,
+		`SELECT sender_id FROM messages WHERE id = $1 AND room_id = $2`,
+		messageID, roomID,
+	).Scan(&senderID)
+	return senderID, err
+}
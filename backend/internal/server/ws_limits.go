@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultWSReadLimitBytes  = 1 << 20
+	defaultWSPongTimeoutSecs = 90
+	defaultWSPingIntervalSec = 30
+	defaultWSSendBufferSize  = 256
+
+	minWSReadLimitBytes = 16 << 10
+	maxWSReadLimitBytes = 16 << 20
+	maxWSSendBufferSize = 4096
+)
+
+// wsLimitsConfig holds the per-connection WebSocket tuning knobs. Zero values
+// fall back to the defaults so partially built Apps (tests) behave as before.
+type wsLimitsConfig struct {
+	ReadLimitBytes int64
+	PongTimeout    time.Duration
+	PingInterval   time.Duration
+	SendBufferSize int
+}
+
+func (cfg wsLimitsConfig) withDefaults() wsLimitsConfig {
+	if cfg.ReadLimitBytes <= 0 {
+		cfg.ReadLimitBytes = defaultWSReadLimitBytes
+	}
+	if cfg.PongTimeout <= 0 {
+		cfg.PongTimeout = time.Duration(defaultWSPongTimeoutSecs) * time.Second
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = time.Duration(defaultWSPingIntervalSec) * time.Second
+	}
+	if cfg.SendBufferSize <= 0 {
+		cfg.SendBufferSize = defaultWSSendBufferSize
+	}
+	return cfg
+}
+
+func validateWSLimits(cfg wsLimitsConfig) error {
+	if cfg.ReadLimitBytes < minWSReadLimitBytes || cfg.ReadLimitBytes > maxWSReadLimitBytes {
+		return fmt.Errorf("WS_READ_LIMIT_BYTES must be between %d and %d", minWSReadLimitBytes, maxWSReadLimitBytes)
+	}
+	if cfg.SendBufferSize > maxWSSendBufferSize {
+		return fmt.Errorf("WS_SEND_BUFFER_SIZE must be <= %d", maxWSSendBufferSize)
+	}
+	// The server pings on PingInterval and the client's pong extends the read
+	// deadline, so the interval must leave room for at least one round trip.
+	if cfg.PingInterval >= cfg.PongTimeout {
+		return fmt.Errorf("WS_PING_INTERVAL_SECONDS must be less than WS_PONG_TIMEOUT_SECONDS")
+	}
+	return nil
+}
@@ -47,3 +47,72 @@ func TestValidateV3CipherPayload(t *testing.T) {
 		t.Fatalf("expected errInvalidPayloadFormat for wrapped key format, got: %v", err)
 	}
 }
+
+func TestRoomLimitsValidateCipherPayload(t *testing.T) {
+	payload := CipherPayload{
+		Version:          3,
+		EncryptionScheme: "DOUBLE_RATCHET_V1",
+		Ciphertext:       "ciphertext",
+		WrappedKeys: map[string]WrappedKey{
+			"12:device_1234": {IV: "iv", WrappedKey: "wrapped"},
+			"13:device_5678": {IV: "iv", WrappedKey: "wrapped"},
+		},
+	}
+
+	if err := (roomLimitsConfig{}).validateCipherPayload(payload); err != nil {
+		t.Fatalf("expected default limits to accept payload, got: %v", err)
+	}
+
+	err := roomLimitsConfig{MaxWrappedKeys: 1}.validateCipherPayload(payload)
+	if !errors.Is(err, errTooManyWrappedKeys) {
+		t.Fatalf("expected errTooManyWrappedKeys, got: %v", err)
+	}
+	if code, _ := protocolErrorFromValidation(err); code != protocolErrorTooManyRecipients {
+		t.Fatalf("expected %q protocol code, got %q", protocolErrorTooManyRecipients, code)
+	}
+
+	err = roomLimitsConfig{MaxPayloadBytes: 64}.validateCipherPayload(payload)
+	if !errors.Is(err, errPayloadTooLarge) {
+		t.Fatalf("expected errPayloadTooLarge, got: %v", err)
+	}
+	if code, _ := protocolErrorFromValidation(err); code != protocolErrorPayloadTooLarge {
+		t.Fatalf("expected %q protocol code, got %q", protocolErrorPayloadTooLarge, code)
+	}
+}
+
+func TestValidateV3CipherPayloadSenderKey(t *testing.T) {
+	valid := CipherPayload{
+		Version:          3,
+		EncryptionScheme: encryptionSchemeSenderKey,
+		SenderKeyID:      "sk_0123456789",
+	}
+	if err := validateV3CipherPayload(valid); err != nil {
+		t.Fatalf("expected valid sender key payload, got error: %v", err)
+	}
+
+	withWrappedKeys := valid
+	withWrappedKeys.WrappedKeys = map[string]WrappedKey{
+		"12:device_1234": {IV: "iv", WrappedKey: "wrapped"},
+	}
+	if err := validateV3CipherPayload(withWrappedKeys); !errors.Is(err, errInvalidPayloadFormat) {
+		t.Fatalf("expected errInvalidPayloadFormat for wrapped keys on sender key payload, got: %v", err)
+	}
+
+	missingKeyID := valid
+	missingKeyID.SenderKeyID = ""
+	if err := validateV3CipherPayload(missingKeyID); !errors.Is(err, errInvalidPayloadFormat) {
+		t.Fatalf("expected errInvalidPayloadFormat for missing sender key id, got: %v", err)
+	}
+
+	ratchetWithKeyID := CipherPayload{
+		Version:          3,
+		EncryptionScheme: encryptionSchemeDoubleRatchet,
+		SenderKeyID:      "sk_0123456789",
+		WrappedKeys: map[string]WrappedKey{
+			"12:device_1234": {IV: "iv", WrappedKey: "wrapped"},
+		},
+	}
+	if err := validateV3CipherPayload(ratchetWithKeyID); !errors.Is(err, errInvalidPayloadFormat) {
+		t.Fatalf("expected errInvalidPayloadFormat for sender key id on ratchet payload, got: %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -20,8 +21,14 @@ var (
 )
 
 const (
-	protocolErrorLegacyPayload = "legacy_payload_not_supported"
-	protocolErrorInvalidFormat = "invalid_payload_format"
+	protocolErrorLegacyPayload      = "legacy_payload_not_supported"
+	protocolErrorInvalidFormat      = "invalid_payload_format"
+	protocolErrorTooManyRecipients  = "too_many_recipients"
+	protocolErrorPayloadTooLarge    = "payload_too_large"
+	protocolErrorReplayedPayload    = "replayed_payload"
+	protocolErrorStalePayload       = "stale_payload"
+	protocolErrorInvalidKeyAnnounce = "invalid_key_announce"
+	protocolErrorMessageRevoked     = "message_revoked"
 )
 
 func validWrappedRecipientAddress(recipientID string) bool {
@@ -40,33 +47,80 @@ func validateV3CipherPayload(payload CipherPayload) error {
 	if payload.Version < 3 {
 		return errLegacyPayloadVersion
 	}
-	if strings.TrimSpace(payload.EncryptionScheme) != "DOUBLE_RATCHET_V1" {
-		return fmt.Errorf("%w: unsupported encryption scheme", errInvalidPayloadFormat)
+	switch payload.SignatureCanonicalization {
+	case "", signatureCanonicalizationLegacy, signatureCanonicalizationJCS:
+	default:
+		return fmt.Errorf("%w: %s", errInvalidPayloadFormat, errUnsupportedCanonicalization)
 	}
-	if len(payload.WrappedKeys) == 0 {
-		return fmt.Errorf("%w: wrapped keys are required", errInvalidPayloadFormat)
+	if payload.KeyEpoch < 0 {
+		return fmt.Errorf("%w: negative key epoch", errInvalidPayloadFormat)
 	}
-	for recipientID := range payload.WrappedKeys {
-		if !validWrappedRecipientAddress(recipientID) {
-			return fmt.Errorf("%w: invalid recipient address %q", errInvalidPayloadFormat, recipientID)
+	switch strings.TrimSpace(payload.EncryptionScheme) {
+	case encryptionSchemeDoubleRatchet:
+		if payload.SenderKeyID != "" {
+			return fmt.Errorf("%w: senderKeyId is only valid on sender key payloads", errInvalidPayloadFormat)
+		}
+		if len(payload.WrappedKeys) == 0 {
+			return fmt.Errorf("%w: wrapped keys are required", errInvalidPayloadFormat)
+		}
+		for recipientID := range payload.WrappedKeys {
+			if !validWrappedRecipientAddress(recipientID) {
+				return fmt.Errorf("%w: invalid recipient address %q", errInvalidPayloadFormat, recipientID)
+			}
 		}
+	case encryptionSchemeSenderKey:
+		if err := validateSenderKeyPayload(payload); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unsupported encryption scheme", errInvalidPayloadFormat)
+	}
+	return validatePollPayload(payload)
+}
+
+// hasCipherBody reports whether a frame carries ciphertext plus key material:
+// per-device wrapped keys, or a sender key reference.
+func (m WSIncoming) hasCipherBody() bool {
+	if m.Ciphertext == "" || m.MessageIV == "" {
+		return false
 	}
-	return nil
+	return len(m.WrappedKeys) > 0 || m.SenderKeyID != ""
 }
 
 func protocolErrorFromValidation(err error) (code string, message string) {
 	if errors.Is(err, errLegacyPayloadVersion) {
 		return protocolErrorLegacyPayload, "检测到旧版密文协议，当前仅支持 V3。请刷新页面升级客户端后重试。"
 	}
+	if errors.Is(err, errTooManyWrappedKeys) {
+		return protocolErrorTooManyRecipients, "接收设备数量超过服务器上限，消息未发送。"
+	}
+	if errors.Is(err, errPayloadTooLarge) {
+		return protocolErrorPayloadTooLarge, "消息体积超过服务器上限，消息未发送。"
+	}
+	if errors.Is(err, errSignatureReplayed) {
+		return protocolErrorReplayedPayload, "该消息已发送过，重复提交已被拒绝。"
+	}
+	if errors.Is(err, errSignatureStale) {
+		return protocolErrorStalePayload, "消息签名时间与服务器时间相差过大，请校准设备时间后重试。"
+	}
 	return protocolErrorInvalidFormat, "密文格式非法或不完整，请刷新页面后重试。"
 }
 
+func (c *Client) log() *slog.Logger {
+	return loggerWithRequestID(c.requestID)
+}
+
 func (c *Client) sendProtocolError(code string, message string) {
+	c.sendProtocolErrorDetails(code, message, nil)
+}
+
+func (c *Client) sendProtocolErrorDetails(code string, message string, details map[string]any) {
 	frame := ProtocolErrorFrame{
 		Type:    "protocol_error",
 		RoomID:  c.roomID,
 		Code:    code,
 		Message: message,
+		Details: details,
 	}
 	payload, err := json.Marshal(frame)
 	if err != nil {
@@ -75,7 +129,7 @@ func (c *Client) sendProtocolError(code string, message string) {
 	select {
 	case c.send <- payload:
 	default:
-		logger.Warn(
+		c.log().Warn(
 			"websocket_protocol_error_drop",
 			"user_id",
 			c.userID,
@@ -89,7 +143,7 @@ func (c *Client) sendProtocolError(code string, message string) {
 
 func (c *Client) rejectInvalidPayload(frameType string, validationErr error) {
 	code, message := protocolErrorFromValidation(validationErr)
-	logger.Warn(
+	c.log().Warn(
 		"drop_legacy_or_invalid_payload",
 		"user_id",
 		c.userID,
@@ -107,107 +161,331 @@ func (c *Client) rejectInvalidPayload(frameType string, validationErr error) {
 
 func (a *App) handleWS(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		respondJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	if a.wsConnectLimiter != nil && !a.wsConnectLimiter.Allow(clientKeyFromRequest(r, a.trustProxyHeaders)) {
-		respondRateLimited(w, "too many websocket connection attempts")
+	if a.draining.Load() {
+		respondDraining(w, a.effectiveWSDrainWindow())
+		return
+	}
+	if a.rejectMaintenanceUpgrade(w, r) {
+		return
+	}
+	if allowed, retryAfter := a.wsConnectLimiter.Check(clientKeyFromRequest(r, a.trustProxyHeaders)); !allowed {
+		respondRateLimitedAfter(w, "too many websocket connection attempts", retryAfter)
 		return
 	}
 
-	tokenString, _ := authTokenFromRequest(r)
+	tokenString, err := wsAuthTokenFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if tokenString == "" {
-		respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "authorization required"})
+		respondError(w, http.StatusUnauthorized, "authorization required")
 		return
 	}
 	claims, err := a.parseToken(tokenString)
 	if err != nil {
-		respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid token"})
+		respondError(w, http.StatusUnauthorized, "invalid token")
 		return
 	}
 
 	roomID, err := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("room_id")), 10, 64)
 	if err != nil || roomID <= 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid room_id"})
+		respondError(w, http.StatusBadRequest, "invalid room_id")
+		return
+	}
+	snapshotLimit, err := parseSnapshotLimit(r.URL.Query().Get("snapshot"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	role, err := a.ensureUserIdentity(ctx, claims.UserID, claims.Username)
+	session, err := a.admitRoomSession(ctx, claims, roomID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidIdentity) {
-			respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "authorization required"})
+		var admissionErr *roomAdmissionError
+		if errors.As(err, &admissionErr) {
+			respondErrorCode(w, admissionErr.Status, admissionErr.Code, admissionErr.Message)
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to validate identity"})
+		requestLogger(r.Context()).Error("ws_admission_failed", "room_id", roomID, "error", err)
+		respondError(w, http.StatusInternalServerError, "failed to validate room session")
 		return
 	}
-	if role != claims.Role {
-		respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "token role mismatch"})
+
+	conn, err := a.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		requestLogger(r.Context()).Error("websocket_upgrade_failed", "error", err)
 		return
 	}
+	a.wsCompression.configureConn(conn)
+
+	client := session.newClient(a, conn, requestIDFromContext(r.Context()), clientKeyFromRequest(r, a.trustProxyHeaders))
+	client.codec = wsCodecForSubprotocol(conn.Subprotocol())
+	a.joinRoom(ctx, client, snapshotLimit)
+
+	go client.writePump()
+	client.readPump()
+}
+
+// roomAdmissionError is why a device may not join a room. Status is the
+// HTTP status an upgrade request is refused with; Code, when set, replaces
+// the generic code for that status.
+type roomAdmissionError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *roomAdmissionError) Error() string {
+	return e.Message
+}
+
+// roomSession is a device that passed admission into one room.
+type roomSession struct {
+	claims   *Claims
+	identity userIdentity
+	device   deviceRecord
+	roomID   int64
+}
+
+// admitRoomSession runs the checks every transport shares before a device
+// joins a room: the account and device session must still match the token,
+// and the user must be a member of an existing room.
+func (a *App) admitRoomSession(ctx context.Context, claims *Claims, roomID int64) (roomSession, error) {
+	identity, err := a.ensureUserIdentity(ctx, claims.UserID, claims.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidIdentity) {
+			return roomSession{}, &roomAdmissionError{Status: http.StatusUnauthorized, Message: "authorization required"}
+		}
+		return roomSession{}, fmt.Errorf("validate identity: %w", err)
+	}
+	if !identity.matchesClaims(claims) {
+		return roomSession{}, &roomAdmissionError{Status: http.StatusUnauthorized, Message: "token role mismatch"}
+	}
 	device, err := a.validateDeviceClaim(ctx, claims.UserID, claims.DeviceID, claims.DeviceSessionVersion)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidIdentity) {
-			respondJSON(w, http.StatusUnauthorized, map[string]any{"error": "device session expired"})
-			return
+			return roomSession{}, &roomAdmissionError{Status: http.StatusUnauthorized, Message: "device session expired"}
+		}
+		return roomSession{}, fmt.Errorf("validate device session: %w", err)
+	}
+	if a.policyPending(identity) > 0 {
+		return roomSession{}, &roomAdmissionError{
+			Status:  http.StatusPreconditionRequired,
+			Code:    errCodePolicyAcceptanceRequired,
+			Message: "policy acceptance required",
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to validate device session"})
-		return
 	}
-
 	if err := a.ensureRoomExists(ctx, roomID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusNotFound, map[string]any{"error": "room not found"})
-			return
+			return roomSession{}, &roomAdmissionError{Status: http.StatusNotFound, Message: "room not found"}
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to verify room"})
-		return
+		return roomSession{}, fmt.Errorf("verify room: %w", err)
 	}
-
 	if err := a.ensureMembership(ctx, claims.UserID, roomID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondJSON(w, http.StatusForbidden, map[string]any{"error": "not a room member"})
-			return
+			return roomSession{}, &roomAdmissionError{Status: http.StatusForbidden, Message: "not a room member"}
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]any{"error": "failed to validate room membership"})
-		return
-	}
-
-	conn, err := a.upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		logger.Error("websocket_upgrade_failed", "error", err)
-		return
+		return roomSession{}, fmt.Errorf("validate room membership: %w", err)
 	}
+	return roomSession{claims: claims, identity: identity, device: device, roomID: roomID}, nil
+}
 
+func (s roomSession) newClient(a *App, conn wsConn, requestID string, remoteIP string) *Client {
 	client := &Client{
-		app:        a,
-		conn:       conn,
-		send:       make(chan []byte, 256),
-		userID:     claims.UserID,
-		username:   claims.Username,
-		deviceID:   device.DeviceID,
-		deviceName: device.DeviceName,
-		roomID:     roomID,
+		app:         a,
+		conn:        conn,
+		send:        make(chan []byte, a.wsLimits.withDefaults().SendBufferSize),
+		userID:      s.claims.UserID,
+		username:    s.claims.Username,
+		role:        s.identity.Role,
+		deviceID:    s.device.DeviceID,
+		deviceName:  s.device.DeviceName,
+		roomID:      s.roomID,
+		requestID:   requestID,
+		remoteIP:    remoteIP,
+		connectedAt: time.Now(),
 	}
+	client.setDisplayName(s.identity.DisplayName)
+	return client
+}
 
+// joinRoom registers client with the hub and queues everything a fresh
+// connection needs before its pumps start: peers, pending handshakes,
+// recovery and backfill requests, then the message snapshot.
+func (a *App) joinRoom(ctx context.Context, client *Client, snapshotLimit int) {
+	roomID := client.roomID
 	peers := a.hub.AddClient(client)
-	if payload, err := json.Marshal(map[string]any{
+	if stored, err := a.loadAnnouncedKeys(ctx, roomID); err != nil {
+		client.log().Warn("load_announced_keys_failed", "room_id", roomID, "error", err)
+	} else {
+		peers = mergeStoredPeers(peers, stored, client, a.hub.presence.Status)
+		a.replayStoredAnnounce(client, stored)
+	}
+	if status, changed := a.hub.presence.Connect(client.userID, client.deviceID); changed {
+		a.hub.BroadcastPresence(client.userID, client.username, status, 0)
+	}
+	roomPeers := map[string]any{
 		"type":   "room_peers",
 		"roomId": roomID,
 		"peers":  peers,
-	}); err == nil {
+	}
+	if epoch, err := a.loadRoomKeyEpoch(ctx, roomID); err != nil {
+		client.log().Warn("load_room_key_epoch_failed", "room_id", roomID, "error", err)
+	} else {
+		roomPeers["keyEpoch"] = epoch.Epoch
+	}
+	if payload, err := json.Marshal(roomPeers); err == nil {
 		client.send <- payload
 	}
+	// The send queue is not drained until writePump starts, so the number
+	// of queued handshakes pushed here stays well under its capacity.
+	if handshakes, err := a.claimPendingHandshakes(ctx, client.userID, client.deviceID); err != nil {
+		client.log().Warn("claim_pending_handshakes_failed", "user_id", client.userID, "error", err)
+	} else {
+		for _, frame := range handshakes {
+			client.send <- frame
+		}
+		a.acknowledgeHandshakes(handshakes, client.deviceID, true)
+	}
+	// Recovery requests left over for lack of queue space stay pending for
+	// the next connection.
+	if limit := min(maxDecryptRecoveriesPerDelivery, cap(client.send)-len(client.send)-1); limit > 0 {
+		if recoveries, err := a.claimDecryptRecoveries(ctx, roomID, client.userID, client.deviceID, limit); err != nil {
+			client.log().Warn("claim_decrypt_recoveries_failed", "user_id", client.userID, "room_id", roomID, "error", err)
+		} else {
+			for _, req := range recoveries {
+				if frame, err := req.frame(); err == nil {
+					client.send <- frame
+				}
+			}
+		}
+	}
+	if limit := min(maxHistoryBackfillsPerDelivery, cap(client.send)-len(client.send)-1); limit > 0 {
+		if backfills, err := a.openHistoryBackfills(ctx, roomID, client.userID, limit); err != nil {
+			client.log().Warn("load_history_backfills_failed", "room_id", roomID, "error", err)
+		} else {
+			for _, backfill := range backfills {
+				if frame, err := backfill.requestFrame(); err == nil {
+					client.send <- frame
+				}
+			}
+		}
+	}
+	// The snapshot goes last and is trimmed to the queue space left, so it
+	// never blocks before writePump starts.
+	if limit := min(snapshotLimit, cap(client.send)-len(client.send)-1); limit > 0 {
+		if messages, hasMore, err := a.loadJoinSnapshot(ctx, roomID, limit); err != nil {
+			client.log().Warn("ws_snapshot_failed", "room_id", roomID, "error", err)
+		} else {
+			for _, frame := range snapshotFrames(roomID, messages, hasMore) {
+				client.send <- frame
+			}
+		}
+	}
+}
 
-	go client.writePump()
-	client.readPump()
+// deliverStoredCiphertext fans a committed message out to the room, to any
+// mentioned members and to members connected elsewhere who want notifying.
+func (a *App) deliverStoredCiphertext(
+	roomID int64,
+	senderID int64,
+	senderUsername string,
+	senderDisplayName string,
+	stamp messageStamp,
+	payload CipherPayload,
+	mentions []int64,
+	forward *messageForward,
+) {
+	frame := map[string]any{
+		"type":           "ciphertext",
+		"id":             stamp.ID,
+		"roomId":         roomID,
+		"seq":            stamp.Seq,
+		"senderId":       senderID,
+		"senderUsername": senderUsername,
+		"createdAt":      stamp.CreatedAt.UTC().Format(time.RFC3339Nano),
+		"mentions":       mentions,
+		"payload":        payload,
+	}
+	if senderDisplayName != "" {
+		frame["senderDisplayName"] = senderDisplayName
+	}
+	if forward != nil {
+		frame["forwardedFromRoomId"] = forward.RoomID
+		frame["forwardedFromMessageId"] = forward.MessageID
+	}
+	if out, err := json.Marshal(frame); err == nil {
+		a.hub.Broadcast(roomID, out)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a.notifyMentions(roomID, stamp.ID, senderID, senderUsername, stamp.CreatedAt, mentions)
+	a.notifyRoomMessage(ctx, roomID, stamp.ID, senderID, senderUsername, stamp.CreatedAt, mentions)
+}
+
+// persistCiphertext stores a validated ciphertext frame and broadcasts it once
+// committed. With a write pipeline configured the insert is batched off the
+// read loop; otherwise it runs inline.
+func (a *App) persistCiphertext(ctx context.Context, c *Client, payload CipherPayload, mentions []int64) {
+	a.inflightMessages.Add(1)
+	ctx, s := a.tracer.StartSpan(ctx, "ws.ciphertext", spanKindServer,
+		attrInt("room_id", c.roomID),
+		attrString("request_id", c.requestID),
+	)
+	deliver := func(stamp messageStamp, err error) {
+		defer a.inflightMessages.Done()
+		s.End(err)
+		var quotaErr *messageQuotaError
+		if errors.As(err, &quotaErr) {
+			c.sendProtocolError(quotaErr.Code, quotaErr.Error())
+			return
+		}
+		if err != nil {
+			c.log().Error(
+				"store_message_failed",
+				"user_id",
+				c.userID,
+				"room_id",
+				c.roomID,
+				"error",
+				err,
+			)
+			return
+		}
+		a.deliverStoredCiphertext(c.roomID, c.userID, c.username, c.getDisplayName(), stamp, payload, mentions, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if a.messages == nil {
+		deliver(a.storeMessage(ctx, c.roomID, c.userID, payload, mentions))
+		return
+	}
+	if err := a.messages.Enqueue(ctx, pendingMessage{
+		roomID:   c.roomID,
+		senderID: c.userID,
+		payload:  payload,
+		mentions: mentions,
+		done:     deliver,
+	}); err != nil {
+		deliver(messageStamp{}, err)
+	}
 }
 
 func (c *Client) readPump() {
 	defer func() {
 		c.app.hub.RemoveClient(c)
+		if status, changed := c.app.hub.presence.Disconnect(c.userID, c.deviceID); changed {
+			c.app.hub.BroadcastPresence(c.userID, c.username, status, c.roomID)
+		}
+		c.app.markUserLastSeen(c.userID)
+		c.app.hub.ClearTyping(c.roomID, c.userID)
+		c.endCallsForDisconnect()
 		if payload, err := json.Marshal(map[string]any{
 			"type":     "peer_left",
 			"roomId":   c.roomID,
@@ -219,17 +497,18 @@ func (c *Client) readPump() {
 		_ = c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(1 << 20)
-	_ = c.conn.SetReadDeadline(time.Now().Add(90 * time.Second))
-	c.conn.SetPongHandler(func(string) error {
-		return c.conn.SetReadDeadline(time.Now().Add(90 * time.Second))
+	limits := c.app.wsLimits.withDefaults()
+	c.conn.SetReadLimit(limits.ReadLimitBytes)
+	_ = c.conn.SetReadDeadline(time.Now().Add(limits.PongTimeout))
+	c.conn.SetPongHandler(func(appData string) error {
+		return c.handlePong(appData, limits.PongTimeout)
 	})
 
 	for {
-		_, raw, err := c.conn.ReadMessage()
+		messageType, raw, err := c.conn.ReadMessage()
 		if err != nil {
 			if closeErr, ok := err.(*websocket.CloseError); ok {
-				logger.Info(
+				c.log().Info(
 					"websocket_closed",
 					"user_id",
 					c.userID,
@@ -243,7 +522,7 @@ func (c *Client) readPump() {
 					c.conn.RemoteAddr().String(),
 				)
 			} else {
-				logger.Warn(
+				c.log().Warn(
 					"websocket_read_failed",
 					"user_id",
 					c.userID,
@@ -258,528 +537,17 @@ func (c *Client) readPump() {
 			return
 		}
 
-		var incoming WSIncoming
-		if err := json.Unmarshal(raw, &incoming); err != nil {
+		raw, err = c.codec.decodeFrame(messageType, raw)
+		if err != nil {
 			continue
 		}
 
-		switch incoming.Type {
-		case "key_announce":
-			if len(incoming.PublicKeyJWK) == 0 || !json.Valid(incoming.PublicKeyJWK) {
-				continue
-			}
-			if len(incoming.SigningPublicKeyJWK) == 0 || !json.Valid(incoming.SigningPublicKeyJWK) {
-				continue
-			}
-			c.setPublicKey(incoming.PublicKeyJWK)
-			c.setSigningPublicKey(incoming.SigningPublicKeyJWK)
-			if payload, err := json.Marshal(map[string]any{
-				"type":                "peer_key",
-				"roomId":              c.roomID,
-				"userId":              c.userID,
-				"username":            c.username,
-				"deviceId":            c.deviceID,
-				"deviceName":          c.deviceName,
-				"publicKeyJwk":        json.RawMessage(incoming.PublicKeyJWK),
-				"signingPublicKeyJwk": json.RawMessage(incoming.SigningPublicKeyJWK),
-			}); err == nil {
-				c.app.hub.Broadcast(c.roomID, payload)
-			}
-
-		case "ciphertext":
-			if incoming.Ciphertext == "" || incoming.MessageIV == "" || len(incoming.WrappedKeys) == 0 {
-				continue
-			}
-			if incoming.Signature == "" {
-				continue
-			}
-			senderDeviceID := normalizeDeviceID(incoming.SenderDeviceID)
-			if senderDeviceID == "" {
-				senderDeviceID = c.deviceID
-			}
-			if senderDeviceID != c.deviceID {
-				continue
-			}
-			if len(incoming.SenderSigningPubJWK) == 0 || !json.Valid(incoming.SenderSigningPubJWK) {
-				continue
-			}
-			announcedSigning := c.getSigningPublicKey()
-			if len(announcedSigning) == 0 || !jsonEqualCanonical(announcedSigning, incoming.SenderSigningPubJWK) {
-				continue
-			}
-
-			senderPub := incoming.SenderPublicJWK
-			if len(senderPub) == 0 {
-				senderPub = c.getPublicKey()
-			}
-			if len(senderPub) == 0 || !json.Valid(senderPub) {
-				continue
-			}
-			announcedPub := c.getPublicKey()
-			if len(announcedPub) > 0 && !jsonEqualCanonical(announcedPub, senderPub) {
-				continue
-			}
-
-			payload := CipherPayload{
-				Version:             incoming.Version,
-				Ciphertext:          incoming.Ciphertext,
-				MessageIV:           incoming.MessageIV,
-				WrappedKeys:         incoming.WrappedKeys,
-				SenderPublicJWK:     senderPub,
-				SenderSigningPubJWK: incoming.SenderSigningPubJWK,
-				Signature:           incoming.Signature,
-				ContentType:         incoming.ContentType,
-				SenderDeviceID:      senderDeviceID,
-				EncryptionScheme:    incoming.EncryptionScheme,
-			}
-			if err := validateV3CipherPayload(payload); err != nil {
-				c.rejectInvalidPayload("ciphertext", err)
-				continue
-			}
-			if err := verifyCipherSignature(payload); err != nil {
-				logger.Warn(
-					"drop_invalid_cipher_signature",
-					"user_id",
-					c.userID,
-					"room_id",
-					c.roomID,
-					"error",
-					err,
-				)
-				continue
-			}
-
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := c.app.ensureMembership(ctx, c.userID, c.roomID); err != nil {
-				cancel()
-				continue
-			}
-			messageID, createdAt, err := c.app.storeMessage(ctx, c.roomID, c.userID, payload)
-			cancel()
-			if err != nil {
-				logger.Error(
-					"store_message_failed",
-					"user_id",
-					c.userID,
-					"room_id",
-					c.roomID,
-					"error",
-					err,
-				)
-				continue
-			}
-
-			if out, err := json.Marshal(map[string]any{
-				"type":           "ciphertext",
-				"id":             messageID,
-				"roomId":         c.roomID,
-				"senderId":       c.userID,
-				"senderUsername": c.username,
-				"createdAt":      createdAt.UTC().Format(time.RFC3339Nano),
-				"payload":        payload,
-			}); err == nil {
-				c.app.hub.Broadcast(c.roomID, out)
-			}
-
-		case "typing_status":
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := c.app.ensureMembership(ctx, c.userID, c.roomID); err != nil {
-				cancel()
-				continue
-			}
-			cancel()
-			if payload, err := json.Marshal(map[string]any{
-				"type":         "typing_status",
-				"roomId":       c.roomID,
-				"fromUserId":   c.userID,
-				"fromUsername": c.username,
-				"isTyping":     incoming.IsTyping,
-			}); err == nil {
-				c.app.hub.Broadcast(c.roomID, payload)
-			}
-
-		case "read_receipt":
-			if incoming.UpToMessageID <= 0 {
-				continue
-			}
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := c.app.ensureMembership(ctx, c.userID, c.roomID); err != nil {
-				cancel()
-				continue
-			}
-			var found int64
-			err := c.app.db.QueryRowContext(ctx,
-				`SELECT id FROM messages WHERE id = $1 AND room_id = $2`,
-				incoming.UpToMessageID, c.roomID,
-			).Scan(&found)
-
-			if err == nil {
-				_, _ = c.app.db.ExecContext(ctx,
-					`UPDATE room_members SET last_read_message_id = GREATEST(last_read_message_id, $1) WHERE user_id = $2 AND room_id = $3`,
-					incoming.UpToMessageID, c.userID, c.roomID,
-				)
-			}
-			cancel()
-			if err != nil {
-				continue
-			}
-			if payload, err := json.Marshal(map[string]any{
-				"type":          "read_receipt",
-				"roomId":        c.roomID,
-				"fromUserId":    c.userID,
-				"fromUsername":  c.username,
-				"upToMessageId": incoming.UpToMessageID,
-			}); err == nil {
-				c.app.hub.Broadcast(c.roomID, payload)
-			}
-
-		case "message_update":
-			if incoming.MessageID <= 0 {
-				continue
-			}
-			mode := strings.ToLower(strings.TrimSpace(incoming.Mode))
-			if mode != "edit" && mode != "revoke" {
-				continue
-			}
-
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := c.app.ensureMembership(ctx, c.userID, c.roomID); err != nil {
-				cancel()
-				continue
-			}
-
-			if mode == "revoke" {
-				var revokedAt time.Time
-				err := c.app.db.QueryRowContext(ctx,
-					`UPDATE messages
-						 SET revoked_at = NOW(), edited_at = NULL
-						 WHERE id = $1 AND room_id = $2 AND sender_id = $3 AND revoked_at IS NULL
-						 RETURNING revoked_at`,
-					incoming.MessageID, c.roomID, c.userID,
-				).Scan(&revokedAt)
-				cancel()
-				if err != nil {
-					continue
-				}
-				if payload, err := json.Marshal(map[string]any{
-					"type":         "message_update",
-					"roomId":       c.roomID,
-					"messageId":    incoming.MessageID,
-					"mode":         "revoke",
-					"fromUserId":   c.userID,
-					"fromUsername": c.username,
-					"revokedAt":    revokedAt.UTC().Format(time.RFC3339Nano),
-				}); err == nil {
-					c.app.hub.Broadcast(c.roomID, payload)
-				}
-				continue
-			}
-
-			if incoming.Ciphertext == "" || incoming.MessageIV == "" || len(incoming.WrappedKeys) == 0 {
-				cancel()
-				continue
-			}
-			if incoming.Signature == "" {
-				cancel()
-				continue
-			}
-			if len(incoming.SenderSigningPubJWK) == 0 || !json.Valid(incoming.SenderSigningPubJWK) {
-				cancel()
-				continue
-			}
-			announcedSigning := c.getSigningPublicKey()
-			if len(announcedSigning) == 0 || !jsonEqualCanonical(announcedSigning, incoming.SenderSigningPubJWK) {
-				cancel()
-				continue
-			}
-
-			senderPub := incoming.SenderPublicJWK
-			if len(senderPub) == 0 {
-				senderPub = c.getPublicKey()
-			}
-			if len(senderPub) == 0 || !json.Valid(senderPub) {
-				cancel()
-				continue
-			}
-			announcedPub := c.getPublicKey()
-			if len(announcedPub) > 0 && !jsonEqualCanonical(announcedPub, senderPub) {
-				cancel()
-				continue
-			}
-			senderDeviceID := normalizeDeviceID(incoming.SenderDeviceID)
-			if senderDeviceID == "" {
-				senderDeviceID = c.deviceID
-			}
-			if senderDeviceID != c.deviceID {
-				cancel()
-				continue
-			}
-
-			payload := CipherPayload{
-				Version:             incoming.Version,
-				Ciphertext:          incoming.Ciphertext,
-				MessageIV:           incoming.MessageIV,
-				WrappedKeys:         incoming.WrappedKeys,
-				SenderPublicJWK:     senderPub,
-				SenderSigningPubJWK: incoming.SenderSigningPubJWK,
-				Signature:           incoming.Signature,
-				ContentType:         incoming.ContentType,
-				SenderDeviceID:      senderDeviceID,
-				EncryptionScheme:    incoming.EncryptionScheme,
-			}
-			if err := validateV3CipherPayload(payload); err != nil {
-				cancel()
-				c.rejectInvalidPayload("message_update", err)
-				continue
-			}
-			if err := verifyCipherSignature(payload); err != nil {
-				cancel()
-				continue
-			}
-
-			payloadJSON, err := json.Marshal(payload)
-			if err != nil {
-				cancel()
-				continue
-			}
-
-			var editedAt time.Time
-			err = c.app.db.QueryRowContext(ctx,
-				`UPDATE messages
-					 SET payload = $1::jsonb, edited_at = NOW(), revoked_at = NULL
-					 WHERE id = $2 AND room_id = $3 AND sender_id = $4
-					 RETURNING edited_at`,
-				payloadJSON, incoming.MessageID, c.roomID, c.userID,
-			).Scan(&editedAt)
-			cancel()
-			if err != nil {
-				continue
-			}
-
-			if out, err := json.Marshal(map[string]any{
-				"type":         "message_update",
-				"roomId":       c.roomID,
-				"messageId":    incoming.MessageID,
-				"mode":         "edit",
-				"fromUserId":   c.userID,
-				"fromUsername": c.username,
-				"editedAt":     editedAt.UTC().Format(time.RFC3339Nano),
-				"payload":      payload,
-			}); err == nil {
-				c.app.hub.Broadcast(c.roomID, out)
-			}
-
-		case "decrypt_ack":
-			if incoming.MessageID <= 0 || strings.TrimSpace(incoming.AckSignature) == "" {
-				continue
-			}
-			if len(incoming.SenderSigningPubJWK) == 0 || !json.Valid(incoming.SenderSigningPubJWK) {
-				continue
-			}
-			announcedSigning := c.getSigningPublicKey()
-			if len(announcedSigning) == 0 || !jsonEqualCanonical(announcedSigning, incoming.SenderSigningPubJWK) {
-				continue
-			}
-			if err := verifyAckSignature(incoming.SenderSigningPubJWK, c.roomID, incoming.MessageID, c.userID, incoming.AckSignature); err != nil {
-				logger.Warn(
-					"drop_invalid_decrypt_ack",
-					"user_id",
-					c.userID,
-					"room_id",
-					c.roomID,
-					"message_id",
-					incoming.MessageID,
-					"error",
-					err,
-				)
-				continue
-			}
-
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := c.app.ensureMembership(ctx, c.userID, c.roomID); err != nil {
-				cancel()
-				continue
-			}
-			var senderID int64
-			err := c.app.db.QueryRowContext(ctx,
-				`SELECT sender_id FROM messages WHERE id = $1 AND room_id = $2`,
-				incoming.MessageID, c.roomID,
-			).Scan(&senderID)
-			cancel()
-			if err != nil {
-				continue
-			}
-			if senderID == c.userID {
-				continue
-			}
-
-			if payload, err := json.Marshal(map[string]any{
-				"type":         "decrypt_ack",
-				"roomId":       c.roomID,
-				"messageId":    incoming.MessageID,
-				"fromUserId":   c.userID,
-				"fromUsername": c.username,
-			}); err == nil {
-				c.app.hub.Broadcast(c.roomID, payload)
-			}
-
-		case "decrypt_recovery_request":
-			if incoming.MessageID <= 0 {
-				continue
-			}
-			action := strings.ToLower(strings.TrimSpace(incoming.Action))
-			if action == "" {
-				action = "resync"
-			}
-			if action != "resync" {
-				continue
-			}
-
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := c.app.ensureMembership(ctx, c.userID, c.roomID); err != nil {
-				cancel()
-				continue
-			}
-
-			var senderID int64
-			err := c.app.db.QueryRowContext(ctx,
-				`SELECT sender_id FROM messages WHERE id = $1 AND room_id = $2`,
-				incoming.MessageID, c.roomID,
-			).Scan(&senderID)
-			cancel()
-			if err != nil || senderID <= 0 || senderID == c.userID {
-				continue
-			}
-
-			if payload, err := json.Marshal(map[string]any{
-				"type":         "decrypt_recovery_request",
-				"roomId":       c.roomID,
-				"messageId":    incoming.MessageID,
-				"fromUserId":   c.userID,
-				"fromUsername": c.username,
-				"fromDeviceId": c.deviceID,
-				"toUserId":     senderID,
-				"toDeviceId":   normalizeDeviceID(incoming.ToDeviceID),
-				"action":       action,
-			}); err == nil {
-				targetDeviceID := normalizeDeviceID(incoming.ToDeviceID)
-				if targetDeviceID != "" {
-					c.app.hub.UnicastToDevice(c.roomID, senderID, targetDeviceID, payload)
-				} else {
-					c.app.hub.Unicast(c.roomID, senderID, payload)
-				}
-			}
-
-		case "decrypt_recovery_payload":
-			if incoming.MessageID <= 0 || incoming.ToUserID <= 0 {
-				continue
-			}
-			if incoming.Ciphertext == "" || incoming.MessageIV == "" || len(incoming.WrappedKeys) == 0 {
-				continue
-			}
-			if incoming.Signature == "" {
-				continue
-			}
-			senderDeviceID := normalizeDeviceID(incoming.SenderDeviceID)
-			if senderDeviceID == "" {
-				senderDeviceID = c.deviceID
-			}
-			if senderDeviceID != c.deviceID {
-				continue
-			}
-			if len(incoming.SenderSigningPubJWK) == 0 || !json.Valid(incoming.SenderSigningPubJWK) {
-				continue
-			}
-			announcedSigning := c.getSigningPublicKey()
-			if len(announcedSigning) == 0 || !jsonEqualCanonical(announcedSigning, incoming.SenderSigningPubJWK) {
-				continue
-			}
-			senderPub := incoming.SenderPublicJWK
-			if len(senderPub) == 0 {
-				senderPub = c.getPublicKey()
-			}
-			if len(senderPub) == 0 || !json.Valid(senderPub) {
-				continue
-			}
-			announcedPub := c.getPublicKey()
-			if len(announcedPub) > 0 && !jsonEqualCanonical(announcedPub, senderPub) {
-				continue
-			}
-
-			payload := CipherPayload{
-				Version:             incoming.Version,
-				Ciphertext:          incoming.Ciphertext,
-				MessageIV:           incoming.MessageIV,
-				WrappedKeys:         incoming.WrappedKeys,
-				SenderPublicJWK:     senderPub,
-				SenderSigningPubJWK: incoming.SenderSigningPubJWK,
-				Signature:           incoming.Signature,
-				ContentType:         incoming.ContentType,
-				SenderDeviceID:      senderDeviceID,
-				EncryptionScheme:    incoming.EncryptionScheme,
-			}
-			if err := validateV3CipherPayload(payload); err != nil {
-				c.rejectInvalidPayload("decrypt_recovery_payload", err)
-				continue
-			}
-			if err := verifyCipherSignature(payload); err != nil {
-				logger.Warn(
-					"drop_invalid_decrypt_recovery_payload",
-					"user_id",
-					c.userID,
-					"room_id",
-					c.roomID,
-					"message_id",
-					incoming.MessageID,
-					"error",
-					err,
-				)
-				continue
-			}
-
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := c.app.ensureMembership(ctx, c.userID, c.roomID); err != nil {
-				cancel()
-				continue
-			}
-			if err := c.app.ensureMembership(ctx, incoming.ToUserID, c.roomID); err != nil {
-				cancel()
-				continue
-			}
-			var originalSenderID int64
-			err := c.app.db.QueryRowContext(ctx,
-				`SELECT sender_id FROM messages WHERE id = $1 AND room_id = $2`,
-				incoming.MessageID, c.roomID,
-			).Scan(&originalSenderID)
-			cancel()
-			if err != nil || originalSenderID != c.userID {
-				continue
-			}
-
-			if out, err := json.Marshal(map[string]any{
-				"type":         "decrypt_recovery_payload",
-				"roomId":       c.roomID,
-				"messageId":    incoming.MessageID,
-				"fromUserId":   c.userID,
-				"fromUsername": c.username,
-				"fromDeviceId": c.deviceID,
-				"toUserId":     incoming.ToUserID,
-				"toDeviceId":   normalizeDeviceID(incoming.ToDeviceID),
-				"payload":      payload,
-			}); err == nil {
-				targetDeviceID := normalizeDeviceID(incoming.ToDeviceID)
-				if targetDeviceID != "" {
-					c.app.hub.UnicastToDevice(c.roomID, incoming.ToUserID, targetDeviceID, out)
-				} else {
-					c.app.hub.Unicast(c.roomID, incoming.ToUserID, out)
-				}
-			}
-		}
+		c.dispatchFrame(raw)
 	}
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(c.app.wsLimits.withDefaults().PingInterval)
 	defer ticker.Stop()
 
 	for {
@@ -790,8 +558,21 @@ func (c *Client) writePump() {
 				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
-				logger.Warn(
+			if c.guest {
+				filtered, allowed := guestFrame(payload)
+				if !allowed {
+					continue
+				}
+				payload = filtered
+			}
+			messageType, frame, err := c.codec.encodeFrame(payload)
+			if err != nil {
+				c.log().Warn("websocket_encode_failed", "user_id", c.userID, "room_id", c.roomID, "error", err)
+				continue
+			}
+			c.conn.EnableWriteCompression(c.app.wsCompression.shouldCompress(len(frame)))
+			if err := c.conn.WriteMessage(messageType, frame); err != nil {
+				c.log().Warn(
 					"websocket_write_failed",
 					"user_id",
 					c.userID,
@@ -806,8 +587,8 @@ func (c *Client) writePump() {
 			}
 		case <-ticker.C:
 			_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				logger.Warn(
+			if err := c.conn.WriteMessage(websocket.PingMessage, pingPayload(time.Now())); err != nil {
+				c.log().Warn(
 					"websocket_ping_failed",
 					"user_id",
 					c.userID,
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxWSSnapshotMessages caps the snapshot a client may ask for on /ws; older
+// history still comes from the REST endpoint.
+const maxWSSnapshotMessages = 50
+
+var errInvalidSnapshot = fmt.Errorf("snapshot must be between 0 and %d", maxWSSnapshotMessages)
+
+// parseSnapshotLimit reads the /ws snapshot parameter. Absent means no
+// snapshot, matching the behaviour of clients that predate it.
+func parseSnapshotLimit(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 || limit > maxWSSnapshotMessages {
+		return 0, errInvalidSnapshot
+	}
+	return limit, nil
+}
+
+// loadJoinSnapshot returns the room's newest messages, oldest first.
+func (a *App) loadJoinSnapshot(ctx context.Context, roomID int64, limit int) ([]StoredMessage, bool, error) {
+	messages, err := a.listRoomMessages(ctx, `ORDER BY m.id DESC LIMIT $2`, roomID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	reverseStoredMessages(messages)
+	return messages, hasMore, nil
+}
+
+// snapshotFrames streams messages as one room_snapshot_message frame each,
+// closed by room_snapshot_end. The client joined the hub before the snapshot
+// was read, so a live ciphertext frame may repeat a snapshot message; clients
+// dedupe by message id.
+func snapshotFrames(roomID int64, messages []StoredMessage, hasMore bool) [][]byte {
+	frames := make([][]byte, 0, len(messages)+1)
+	for _, message := range messages {
+		frame, err := json.Marshal(map[string]any{
+			"type":    "room_snapshot_message",
+			"roomId":  roomID,
+			"message": message,
+		})
+		if err != nil {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	end, err := json.Marshal(map[string]any{
+		"type":    "room_snapshot_end",
+		"roomId":  roomID,
+		"count":   len(frames),
+		"hasMore": hasMore,
+	})
+	if err == nil {
+		frames = append(frames, end)
+	}
+	return frames
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseSnapshotLimit(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		value  string
+		limit  int
+		hasErr bool
+	}{
+		{"", 0, false},
+		{" 20 ", 20, false},
+		{"0", 0, false},
+		{"50", 50, false},
+		{"51", 0, true},
+		{"-1", 0, true},
+		{"all", 0, true},
+	}
+	for _, tc := range cases {
+		limit, err := parseSnapshotLimit(tc.value)
+		if (err != nil) != tc.hasErr || limit != tc.limit {
+			t.Fatalf("parseSnapshotLimit(%q) = %d, %v", tc.value, limit, err)
+		}
+	}
+}
+
+func TestSnapshotFramesEndWithSummary(t *testing.T) {
+	t.Parallel()
+
+	messages := []StoredMessage{{ID: 4, RoomID: 2, Kind: historyKindMessage}, {ID: 5, RoomID: 2, Kind: historyKindMessage}}
+	frames := snapshotFrames(2, messages, true)
+	if len(frames) != 3 {
+		t.Fatalf("expected 2 messages and an end frame, got %d", len(frames))
+	}
+	var first struct {
+		Type    string        `json:"type"`
+		Message StoredMessage `json:"message"`
+	}
+	if err := json.Unmarshal(frames[0], &first); err != nil || first.Type != "room_snapshot_message" || first.Message.ID != 4 {
+		t.Fatalf("unexpected first frame: %s", frames[0])
+	}
+	var end struct {
+		Type    string `json:"type"`
+		Count   int    `json:"count"`
+		HasMore bool   `json:"hasMore"`
+	}
+	if err := json.Unmarshal(frames[2], &end); err != nil || end.Type != "room_snapshot_end" || end.Count != 2 || !end.HasMore {
+		t.Fatalf("unexpected end frame: %s", frames[2])
+	}
+}
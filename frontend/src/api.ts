@@ -182,10 +182,10 @@ async function parseJSON<T>(response: Response): Promise<T> {
   const body = await response.json().catch(() => ({}));
   if (!response.ok) {
     const message =
-      typeof body?.error === 'string'
-        ? body.error
-        : typeof body?.message === 'string'
-          ? body.message
+      typeof body?.message === 'string'
+        ? body.message
+        : typeof body?.error === 'string'
+          ? body.error
           : `request failed: ${response.status}`;
     const serverCode = typeof body?.code === 'string' && body.code.trim()
       ? body.code.trim()
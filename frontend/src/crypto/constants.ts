@@ -14,6 +14,7 @@ export const DR_MAX_SKIPPED_CACHE = 600;
 export const SIGNAL_X3DH_INFO = 'signal-x3dh-v1';
 export const SIGNAL_INITIATOR_CHAIN_INFO = 'signal-chain-initiator-v1';
 export const SIGNAL_RESPONDER_CHAIN_INFO = 'signal-chain-responder-v1';
+export const SIGNATURE_CANONICALIZATION_JCS = 'jcs';
 
 export const DEFAULT_KEY_MAX_AGE_MS = 4 * 60 * 60 * 1000;
 export const DEFAULT_KEY_HISTORY_LIMIT = 6;
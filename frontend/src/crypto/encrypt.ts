@@ -5,12 +5,14 @@ import {
   ensureSelfSession,
   prepareSendWrappedKey,
 } from './ratchet';
+import { SIGNATURE_CANONICALIZATION_JCS } from './constants';
 import { readSession, writeSession } from './store';
 import type { Identity } from './types';
 import {
   canonicalAckPayloadForSignature,
   buildRecipientAddress,
   canonicalCipherPayloadForSignature,
+  canonicalKeyAnnouncePayload,
   fromBase64,
   requireCryptoSupport,
   signingKeyFingerprint,
@@ -49,6 +51,43 @@ export async function signDecryptAck(
   return toBase64(normalized);
 }
 
+// signKeyAnnounce proves to the server and to peers that this device holds
+// the signing key it announces for userID/deviceID.
+export async function signKeyAnnounce(userID: number, deviceID: string, identity: Identity): Promise<string> {
+  const canonical = canonicalKeyAnnouncePayload(userID, deviceID, identity.publicKeyJwk, identity.signingPublicKeyJwk);
+  const signature = await crypto.subtle.sign(
+    { name: 'ECDSA', hash: 'SHA-256' },
+    identity.signingPrivateKey,
+    new TextEncoder().encode(canonical),
+  );
+  return toBase64(normalizeECDSASignatureForTransport(signature));
+}
+
+export async function verifyKeyAnnounce(
+  userID: number,
+  deviceID: string,
+  publicKeyJwk: JsonWebKey,
+  signingPublicKeyJwk: JsonWebKey,
+  signature: string,
+): Promise<boolean> {
+  if (!signature) {
+    return false;
+  }
+  try {
+    const publicKey = await crypto.subtle.importKey(
+      'jwk',
+      signingPublicKeyJwk,
+      { name: 'ECDSA', namedCurve: 'P-256' },
+      true,
+      ['verify'],
+    );
+    const canonical = canonicalKeyAnnouncePayload(userID, deviceID, publicKeyJwk, signingPublicKeyJwk);
+    return await verifyECDSASignatureWithFallback(publicKey, new TextEncoder().encode(canonical), fromBase64(signature));
+  } catch {
+    return false;
+  }
+}
+
 async function verifyCipherPayloadSignature(payload: CipherPayload): Promise<boolean> {
   if (!payload.signature || !payload.senderSigningPublicKeyJwk) {
     return false;
@@ -89,6 +128,7 @@ export async function encryptForRecipients(
   senderDeviceID: string,
   identity: Identity,
   recipients: RecipientAddress[],
+  keyEpoch?: number,
 ): Promise<CipherPayload> {
   requireCryptoSupport();
   if (!plaintext.trim()) {
@@ -149,7 +189,17 @@ export async function encryptForRecipients(
     contentType: 'text/plain',
     senderDeviceId: identity.activeKeyID,
     encryptionScheme: 'DOUBLE_RATCHET_V1',
+    signatureCanonicalization: SIGNATURE_CANONICALIZATION_JCS,
+    // The server rejects a nonce it has already seen from this device, so a
+    // captured frame cannot be resubmitted.
+    nonce: toBase64(crypto.getRandomValues(new Uint8Array(18))),
+    signedAt: Date.now(),
   };
+  // The epoch ties the payload to the member set it was keyed for; the
+  // server refuses it once the room has moved on and the grace period ends.
+  if (keyEpoch && keyEpoch > 0) {
+    unsignedPayload.keyEpoch = keyEpoch;
+  }
   const signature = await signCipherPayload(unsignedPayload, identity.signingPrivateKey);
   return {
     ...unsignedPayload,
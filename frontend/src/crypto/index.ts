@@ -22,6 +22,8 @@ export {
 
 export {
   signDecryptAck,
+  signKeyAnnounce,
+  verifyKeyAnnounce,
   encryptForRecipients,
   decryptPayload,
 } from './encrypt';
@@ -1,5 +1,5 @@
 import type { CipherPayload } from '../types';
-import { DR_MAX_SKIPPED_CACHE } from './constants';
+import { DR_MAX_SKIPPED_CACHE, SIGNATURE_CANONICALIZATION_JCS } from './constants';
 
 export function requireCryptoSupport(): void {
   if (!window.isSecureContext || !crypto?.subtle) {
@@ -98,6 +98,27 @@ export function stableJSONStringify(value: unknown): string {
   return JSON.stringify(sortJSON(value));
 }
 
+// RFC 8785 (JCS): JSON.stringify already escapes strings and formats numbers
+// as the scheme requires, so only member order needs fixing. Keys sort by
+// UTF-16 code units, which is what the default comparator does; localeCompare
+// would vary between runtimes.
+function sortJSONCodeUnits(value: unknown): unknown {
+  if (Array.isArray(value)) {
+    return value.map((item) => sortJSONCodeUnits(item));
+  }
+  if (value && typeof value === 'object') {
+    const entries = Object.entries(value as Record<string, unknown>)
+      .sort(([left], [right]) => (left < right ? -1 : left > right ? 1 : 0))
+      .map(([key, current]) => [key, sortJSONCodeUnits(current)]);
+    return Object.fromEntries(entries);
+  }
+  return value;
+}
+
+export function jcsStringify(value: unknown): string {
+  return JSON.stringify(sortJSONCodeUnits(value));
+}
+
 export function normalizeCounter(value: unknown): number {
   const parsed = Number(value);
   if (!Number.isFinite(parsed) || parsed < 0) {
@@ -177,7 +198,7 @@ export function canonicalCipherPayloadForSignature(payload: CipherPayload): stri
       };
     });
 
-  return stableJSONStringify({
+  const doc: Record<string, unknown> = {
     version: normalizeCounter(payload.version),
     ciphertext: payload.ciphertext,
     messageIv: payload.messageIv,
@@ -187,6 +208,34 @@ export function canonicalCipherPayloadForSignature(payload: CipherPayload): stri
     contentType: payload.contentType ?? '',
     senderDeviceId: payload.senderDeviceId ?? '',
     encryptionScheme: payload.encryptionScheme ?? '',
+  };
+  if (payload.nonce) {
+    doc.nonce = payload.nonce;
+  }
+  if (payload.signedAt) {
+    doc.signedAt = normalizeCounter(payload.signedAt);
+  }
+  if (payload.keyEpoch) {
+    doc.keyEpoch = normalizeCounter(payload.keyEpoch);
+  }
+  if (payload.signatureCanonicalization === SIGNATURE_CANONICALIZATION_JCS) {
+    return jcsStringify({ ...doc, signatureCanonicalization: SIGNATURE_CANONICALIZATION_JCS });
+  }
+  return stableJSONStringify(doc);
+}
+
+export function canonicalKeyAnnouncePayload(
+  userID: number,
+  deviceID: string,
+  publicKeyJwk: JsonWebKey,
+  signingPublicKeyJwk: JsonWebKey,
+): string {
+  return jcsStringify({
+    type: 'key_announce',
+    userId: normalizeCounter(userID),
+    deviceId: deviceID,
+    publicKeyJwk,
+    signingPublicKeyJwk,
   });
 }
 
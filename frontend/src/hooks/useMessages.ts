@@ -14,6 +14,8 @@ import {
   encryptForRecipients,
   resetRatchetSession,
   signDecryptAck,
+  signKeyAnnounce,
+  verifyKeyAnnounce,
   type Identity,
 } from '../crypto';
 import { buildRecipientAddress } from '../crypto/utils';
@@ -41,9 +43,13 @@ import type {
   DecryptAckFrame,
   DecryptRecoveryPayloadFrame,
   DecryptRecoveryRequestFrame,
+  HistoryBackfillProgressFrame,
+  HistoryKeyRequestFrame,
+  HistoryKeyResponseFrame,
   MessageUpdateFrame,
   Peer,
   ProtocolErrorFrame,
+  RoomKeyEpochFrame,
   ReadReceiptFrame,
   Room,
   TypingStatusFrame,
@@ -58,6 +64,7 @@ const SIGNAL_BUNDLE_RETRY_MAX_MS = 30000;
 const RESYNC_SWEEP_INTERVAL_MS = 5000;
 const RESYNC_SWEEP_BATCH_SIZE = 50;
 const RESYNC_REQUEST_TIMEOUT_MS = 20000;
+const HISTORY_KEYS_PER_RESPONSE = 100;
 
 type UseMessagesArgs = {
   api: ApiClient;
@@ -168,6 +175,7 @@ export function useMessages({
   const setMessageReadReceipts = useChatStore((state) => state.setMessageReadReceipts);
   const peers = useChatStore((state) => state.peers);
   const setPeers = useChatStore((state) => state.setPeers);
+  const setRoomKeyEpoch = useChatStore((state) => state.setRoomKeyEpoch);
 
   const [hasMoreHistory, setHasMoreHistory] = useState(false);
   const [historyLoading, setHistoryLoading] = useState(false);
@@ -190,6 +198,7 @@ export function useMessages({
   const ackedMessageIDsRef = useRef<Set<number>>(new Set());
   const pendingAckMessageIDsRef = useRef<Set<number>>(new Set());
   const pendingResyncRecoveryRef = useRef<Map<string, DecryptRecoveryRequestFrame>>(new Map());
+  const historyBackfillRoomsRef = useRef<Set<number>>(new Set());
   const pendingResyncTimeoutRef = useRef<Map<string, number>>(new Map());
   const resyncSweepCursorRef = useRef(0);
   const historyBeforeIDRef = useRef<number | null>(null);
@@ -479,9 +488,9 @@ export function useMessages({
     }
 
     const normalizedSenderDeviceID = senderDeviceID.trim();
-    if (!hasOnlineSenderPeer(senderUserID, normalizedSenderDeviceID)) {
-      return 'offline';
-    }
+    // The server queues requests for offline senders and redelivers them
+    // when the sender next connects, so they are still sent.
+    const senderOnline = hasOnlineSenderPeer(senderUserID, normalizedSenderDeviceID);
 
     const requestKey = buildRecoveryRequestKey({
       roomId: selectedRoomID,
@@ -529,17 +538,33 @@ export function useMessages({
       clearResyncRequest(auth.user.id, selectedRoomID, senderUserID, messageID);
     }, RESYNC_REQUEST_TIMEOUT_MS);
     pendingResyncTimeoutRef.current.set(requestKey, timeoutID);
-    return 'sent';
+    return senderOnline ? 'sent' : 'offline';
   }, [auth, selectedRoomID, sendJSON, hasOnlineSenderPeer]);
 
+  // Messages sent before this device joined carry no key for it; those are
+  // backfilled by the room's members instead of per-message recovery.
+  const requestHistoryBackfill = useCallback(() => {
+    if (!selectedRoomID || historyBackfillRoomsRef.current.has(selectedRoomID)) {
+      return;
+    }
+    if (sendJSON({ type: 'history_key_request' })) {
+      historyBackfillRoomsRef.current.add(selectedRoomID);
+    }
+  }, [selectedRoomID, sendJSON]);
+
   const requestDecryptRecoveryIfNeeded = useCallback((message: Pick<ChatMessage, 'id' | 'senderId' | 'payload'>) => {
+    if (auth && message.payload?.wrappedKeys
+      && !(buildRecipientAddress(auth.user.id, auth.device.deviceId) in message.payload.wrappedKeys)) {
+      requestHistoryBackfill();
+      return;
+    }
     const messageID = Number(message.id);
     const senderUserID = Number(message.senderId);
     const senderDeviceID = typeof message.payload?.senderDeviceId === 'string'
       ? message.payload.senderDeviceId.trim()
       : '';
     void queueDecryptRecoveryRequest(messageID, senderUserID, senderDeviceID);
-  }, [queueDecryptRecoveryRequest]);
+  }, [auth, queueDecryptRecoveryRequest, requestHistoryBackfill]);
 
   const emitTypingStatus = useCallback((isTyping: boolean) => {
     if (!auth || !selectedRoomID || !wsConnected) {
@@ -1028,6 +1053,129 @@ export function useMessages({
     [api, auth, identity, identityBound, resolveSignalBundle, selectedRoomID, sendJSON, setInfo],
   );
 
+  const sendHistoryKeys = useCallback(
+    async (request: HistoryKeyRequestFrame): Promise<number> => {
+      if (!auth || !identity || !identityBound) {
+        return 0;
+      }
+      const available = messagesRef.current.filter((item) =>
+        item.id >= request.fromMessageId
+        && item.id <= request.upToMessageId
+        && !item.revokedAt
+        && item.decryptState === 'ok'
+        && typeof item.plaintext === 'string'
+        && item.plaintext,
+      );
+      if (available.length === 0) {
+        return 0;
+      }
+
+      const sessionStatus = await ensureRatchetSessionsForRecipients(
+        auth.user.id,
+        auth.device.deviceId,
+        identity,
+        [request.fromUserId],
+        resolveSignalBundle,
+      );
+      const recipient = sessionStatus.readyRecipients.find((item) =>
+        item.userID === request.fromUserId && item.deviceID === request.fromDeviceId,
+      );
+      if (!recipient) {
+        throw new Error('无法补发历史消息密钥，目标设备会话未就绪');
+      }
+
+      let sentCount = 0;
+      for (let offset = 0; offset < available.length; offset += HISTORY_KEYS_PER_RESPONSE) {
+        const chunk = available.slice(offset, offset + HISTORY_KEYS_PER_RESPONSE);
+        const payload = await encryptForRecipients(
+          JSON.stringify({
+            messages: chunk.map((item) => ({
+              id: item.id,
+              senderId: item.senderId,
+              createdAt: item.createdAt,
+              plaintext: item.plaintext,
+            })),
+          }),
+          auth.user.id,
+          auth.device.deviceId,
+          identity,
+          [recipient],
+        );
+        const sent = sendJSON({
+          type: 'history_key_response',
+          backfillId: request.backfillId,
+          toUserId: request.fromUserId,
+          toDeviceId: request.fromDeviceId,
+          messageIds: chunk.map((item) => item.id),
+          ...payload,
+        });
+        if (!sent) {
+          throw new Error('WebSocket 未连接');
+        }
+        sentCount += chunk.length;
+      }
+      return sentCount;
+    },
+    [auth, identity, identityBound, resolveSignalBundle, sendJSON],
+  );
+
+  const applyHistoryKeys = useCallback(
+    async (response: HistoryKeyResponseFrame): Promise<void> => {
+      if (!auth || !identity || !identityBound) {
+        return;
+      }
+      const wrappedForMe = response.payload.wrappedKeys?.[
+        buildRecipientAddress(auth.user.id, auth.device.deviceId)
+      ];
+      if (!wrappedForMe) {
+        return;
+      }
+      if (wrappedForMe.preKeyMessage) {
+        await resetRatchetSession(auth.user.id, auth.device.deviceId, response.fromUserId, response.fromDeviceId);
+      }
+      const plaintext = await decryptPayload(
+        response.payload,
+        auth.user.id,
+        auth.device.deviceId,
+        response.fromUserId,
+        response.fromDeviceId,
+        identity,
+      );
+      const bundle = JSON.parse(plaintext) as {
+        messages?: Array<{ id: number; senderId: number; createdAt: string; plaintext: string }>;
+      };
+      // Only the messages the server checked against the backfill range are
+      // accepted. The local cache is keyed on the message's own metadata, so a
+      // forged senderId or createdAt simply never matches.
+      const covered = new Set(response.messageIds);
+      const repaired: ChatMessage[] = [];
+      for (const entry of bundle.messages ?? []) {
+        if (!covered.has(entry.id) || typeof entry.plaintext !== 'string') {
+          continue;
+        }
+        try {
+          await persistDecryptedPlaintext(
+            auth.user.id,
+            { id: entry.id, roomId: response.roomId, senderId: entry.senderId, createdAt: entry.createdAt },
+            entry.plaintext,
+          );
+        } catch {
+          continue;
+        }
+        const existing = messagesRef.current.find((item) => item.id === entry.id);
+        if (existing && existing.decryptState !== 'ok') {
+          repaired.push(existing);
+        }
+      }
+      for (const message of repaired) {
+        void enqueueDecryptTask(async () => {
+          await decryptAndUpdate(message);
+        });
+      }
+    },
+    [auth, identity, identityBound, decryptAndUpdate, enqueueDecryptTask],
+  );
+
   useEffect(() => {
     if (onRoomSwitch) {
       onRoomSwitch();
@@ -1158,13 +1306,25 @@ export function useMessages({
       return;
     }
     setError('');
-    sendJSON({
-      type: 'key_announce',
-      publicKeyJwk: identity.publicKeyJwk,
-      signingPublicKeyJwk: identity.signingPublicKeyJwk,
+    let cancelled = false;
+    void signKeyAnnounce(auth.user.id, auth.device.deviceId, identity).then((signature) => {
+      if (cancelled) {
+        return;
+      }
+      sendJSON({
+        type: 'key_announce',
+        publicKeyJwk: identity.publicKeyJwk,
+        signingPublicKeyJwk: identity.signingPublicKeyJwk,
+        signature,
+      });
+      bumpHandshakeTick();
+      void flushDecryptAckQueue();
+    }).catch((reason: unknown) => {
+      reportError(reason, '签名设备密钥声明失败');
     });
-    bumpHandshakeTick();
-    void flushDecryptAckQueue();
+    return () => {
+      cancelled = true;
+    };
   }, [
     wsConnected,
     auth,
@@ -1174,6 +1334,7 @@ export function useMessages({
     sendJSON,
     bumpHandshakeTick,
     flushDecryptAckQueue,
+    reportError,
     setError,
   ]);
 
@@ -1184,19 +1345,36 @@ export function useMessages({
 
     const unsubscribe = subscribeMessage((frame) => {
       if (frame.type === 'room_peers') {
-        const nextPeers: Record<string, Peer> = {};
         const values = Array.isArray(frame.peers) ? frame.peers : [];
-        for (const candidate of values) {
+        const keyEpoch = Number(frame.keyEpoch);
+        if (Number.isFinite(keyEpoch) && keyEpoch > 0) {
+          setRoomKeyEpoch(selectedRoomID, keyEpoch);
+        }
+        // Offline devices are included from their last announce, so every
+        // entry is checked against its own announce signature.
+        void Promise.all(values.map(async (candidate) => {
           const peer = candidate as Peer;
           const peerDeviceID = typeof peer?.deviceId === 'string' ? peer.deviceId.trim() : '';
-          if (peer?.userId && peerDeviceID && peer?.publicKeyJwk && peer?.signingPublicKeyJwk) {
-            nextPeers[buildRecipientAddress(peer.userId, peerDeviceID)] = {
-              ...peer,
-              deviceId: peerDeviceID,
-            };
+          if (!peer?.userId || !peerDeviceID || !peer?.publicKeyJwk || !peer?.signingPublicKeyJwk) {
+            return null;
           }
-        }
-        setPeers(nextPeers);
+          const valid = await verifyKeyAnnounce(
+            peer.userId,
+            peerDeviceID,
+            peer.publicKeyJwk,
+            peer.signingPublicKeyJwk,
+            typeof peer.signature === 'string' ? peer.signature : '',
+          );
+          return valid ? { ...peer, deviceId: peerDeviceID } : null;
+        })).then((verified) => {
+          const nextPeers: Record<string, Peer> = {};
+          for (const peer of verified) {
+            if (peer) {
+              nextPeers[buildRecipientAddress(peer.userId, peer.deviceId)] = peer;
+            }
+          }
+          setPeers(nextPeers);
+        });
         return;
       }
 
@@ -1213,21 +1391,29 @@ export function useMessages({
         if (!peer.userId || !peer.deviceId || !peer.publicKeyJwk || !peer.signingPublicKeyJwk) {
           return;
         }
-        setPeers((previous) => ({ ...previous, [buildRecipientAddress(peer.userId, peer.deviceId)]: peer }));
-        // Proactively establish ratchet session with new peer
-        if (peer.userId !== auth.user.id) {
-          void ensureRatchetSessionsForRecipients(
-            auth.user.id,
-            auth.device.deviceId,
-            identity,
-            [auth.user.id, peer.userId],
-            resolveSignalBundle,
-          ).then(() => {
-            bumpHandshakeTick();
-          }).catch(() => {
-            // Session establishment may fail for new peers; not critical
-          });
-        }
+        const signature = typeof frame.signature === 'string' ? frame.signature : '';
+        // The server checks the announce signature too; verifying here keeps
+        // a compromised server from injecting keys for a device.
+        void verifyKeyAnnounce(peer.userId, peer.deviceId, peer.publicKeyJwk, peer.signingPublicKeyJwk, signature).then((valid) => {
+          if (!valid) {
+            return;
+          }
+          setPeers((previous) => ({ ...previous, [buildRecipientAddress(peer.userId, peer.deviceId)]: peer }));
+          // Proactively establish ratchet session with new peer
+          if (peer.userId !== auth.user.id) {
+            void ensureRatchetSessionsForRecipients(
+              auth.user.id,
+              auth.device.deviceId,
+              identity,
+              [auth.user.id, peer.userId],
+              resolveSignalBundle,
+            ).then(() => {
+              bumpHandshakeTick();
+            }).catch(() => {
+              // Session establishment may fail for new peers; not critical
+            });
+          }
+        });
         return;
       }
 
@@ -1323,6 +1509,15 @@ export function useMessages({
         return;
       }
 
+      if (frame.type === 'room_key_epoch') {
+        const epochFrame = frame as unknown as RoomKeyEpochFrame;
+        const keyEpoch = Number(epochFrame.keyEpoch);
+        if (epochFrame.roomId === selectedRoomID && Number.isFinite(keyEpoch) && keyEpoch > 0) {
+          setRoomKeyEpoch(selectedRoomID, keyEpoch);
+        }
+        return;
+      }
+
       if (frame.type === 'protocol_error') {
         const protocolError = frame as unknown as ProtocolErrorFrame;
         if (protocolError.roomId !== selectedRoomID) {
@@ -1332,6 +1527,10 @@ export function useMessages({
           setError('检测到旧协议密文，已停止自动恢复。请刷新页面并让发送方重新发送该消息。');
           return;
         }
+        if (protocolError.code === 'stale_key_epoch') {
+          setError('房间成员已变更，这条消息未发送。请稍候片刻后重新发送。');
+          return;
+        }
         if (protocolError.code === 'invalid_payload_format') {
           setError('消息密文格式异常。可先请求重同步；若仍失败，请重新登录后重试。');
           return;
@@ -1379,6 +1578,55 @@ export function useMessages({
         return;
       }
 
+      if (frame.type === 'history_key_request') {
+        const request = frame as unknown as HistoryKeyRequestFrame;
+        if (request.roomId !== selectedRoomID || request.fromUserId === auth.user.id || request.backfillId <= 0) {
+          return;
+        }
+        void sendHistoryKeys(request)
+          .then((count) => {
+            if (count > 0) {
+              setInfo(`已向 ${request.fromUsername} 补发 ${count} 条历史消息的密钥`);
+            }
+          })
+          .catch((reason: unknown) => {
+            reportError(reason, '补发历史消息密钥失败');
+          });
+        return;
+      }
+
+      if (frame.type === 'history_key_response') {
+        const response = frame as unknown as HistoryKeyResponseFrame;
+        if (
+          response.roomId !== selectedRoomID
+          || response.toUserId !== auth.user.id
+          || response.toDeviceId !== auth.device.deviceId
+          || !response.payload
+        ) {
+          return;
+        }
+        void applyHistoryKeys(response)
+          .then(() => {
+            const { keysDelivered, messageCount } = response.progress;
+            setInfo(`历史消息密钥已补发 ${Math.min(keysDelivered, messageCount)}/${messageCount}`);
+          })
+          .catch((reason: unknown) => {
+            reportError(reason, '解密历史消息密钥失败');
+          });
+        return;
+      }
+
+      if (frame.type === 'history_backfill_progress') {
+        const progress = frame as unknown as HistoryBackfillProgressFrame;
+        if (progress.roomId !== selectedRoomID) {
+          return;
+        }
+        if (progress.status === 'open' && progress.keysDelivered === 0) {
+          setInfo(`正在向房间成员请求 ${progress.messageCount} 条历史消息的密钥`);
+        }
+        return;
+      }
+
       if (frame.type === 'decrypt_recovery_payload') {
         const recovery = frame as unknown as DecryptRecoveryPayloadFrame;
         if (recovery.roomId !== selectedRoomID || recovery.toUserId !== auth.user.id) {
@@ -1485,11 +1733,14 @@ export function useMessages({
     upsertIncomingMessage,
     resolveSignalBundle,
     sendDecryptRecoveryPayload,
+    sendHistoryKeys,
+    applyHistoryKeys,
     registerDeliveryAck,
     registerReadReceiptUpTo,
     reportError,
     bumpHandshakeTick,
     setPeers,
+    setRoomKeyEpoch,
     sendJSON,
     setError,
     setInfo,
@@ -1891,6 +2142,7 @@ export function useMessages({
         auth.device.deviceId,
         identity,
         sessionStatus.readyRecipients,
+        useChatStore.getState().roomKeyEpochs[selectedRoomID],
       );
       const missingRecipients = sessionStatus.readyRecipients
         .map((recipient) => buildRecipientAddress(recipient.userID, recipient.deviceID))
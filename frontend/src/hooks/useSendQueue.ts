@@ -197,6 +197,7 @@ export function useSendQueue({
           authDeviceID,
           identity,
           sessionStatus.readyRecipients,
+          useChatStore.getState().roomKeyEpochs[selectedRoomID],
         );
         if (payload.signature) {
           await rememberOutgoingPlaintext(authUserID, selectedRoomID, payload.signature, target.text);
@@ -13,6 +13,9 @@ type ChatStore = {
   peers: Record<string, Peer>;
   sendQueue: SendQueueItem[];
   managedUsers: User[];
+  // roomKeyEpochs holds each room's membership epoch as last announced by
+  // the server; outgoing ciphertext is tagged with it.
+  roomKeyEpochs: Record<number, number>;
   setRooms: (next: ValueOrUpdater<Room[]>) => void;
   setRoomMembers: (next: ValueOrUpdater<User[]>) => void;
   setSelectedRoomID: (next: ValueOrUpdater<number | null>) => void;
@@ -21,6 +24,7 @@ type ChatStore = {
   setPeers: (next: ValueOrUpdater<Record<string, Peer>>) => void;
   setSendQueue: (next: ValueOrUpdater<SendQueueItem[]>) => void;
   setManagedUsers: (next: ValueOrUpdater<User[]>) => void;
+  setRoomKeyEpoch: (roomID: number, keyEpoch: number) => void;
   resetSessionScopedState: () => void;
 };
 
@@ -40,6 +44,7 @@ export const useChatStore = create<ChatStore>((set) => ({
   peers: {},
   sendQueue: [],
   managedUsers: [],
+  roomKeyEpochs: {},
   setRooms: (next) => {
     set((state) => ({ rooms: resolveValue(next, state.rooms) }));
   },
@@ -64,6 +69,9 @@ export const useChatStore = create<ChatStore>((set) => ({
   setManagedUsers: (next) => {
     set((state) => ({ managedUsers: resolveValue(next, state.managedUsers) }));
   },
+  setRoomKeyEpoch: (roomID, keyEpoch) => {
+    set((state) => ({ roomKeyEpochs: { ...state.roomKeyEpochs, [roomID]: keyEpoch } }));
+  },
   resetSessionScopedState: () => {
     set({
       rooms: [],
@@ -74,6 +82,7 @@ export const useChatStore = create<ChatStore>((set) => ({
       peers: {},
       sendQueue: [],
       managedUsers: [],
+      roomKeyEpochs: {},
     });
   },
 }));
@@ -8,6 +8,8 @@ export interface Room {
   id: number;
   name: string;
   createdAt: string;
+  keyEpoch?: number;
+  keyEpochChangedAt?: string;
 }
 
 export interface DeviceSnapshot {
@@ -48,6 +50,10 @@ export interface CipherPayload {
   contentType?: string;
   senderDeviceId?: string;
   encryptionScheme?: string;
+  signatureCanonicalization?: string;
+  nonce?: string;
+  signedAt?: number;
+  keyEpoch?: number;
 }
 
 export interface ChatMessage {
@@ -68,6 +74,7 @@ export interface Peer {
   deviceName?: string;
   publicKeyJwk: JsonWebKey;
   signingPublicKeyJwk?: JsonWebKey;
+  signature?: string;
 }
 
 export interface SignalSignedPreKey {
@@ -130,6 +137,7 @@ export interface DecryptAckFrame {
 
 export interface DecryptRecoveryRequestFrame {
   type: 'decrypt_recovery_request';
+  recoveryId?: number;
   roomId: number;
   messageId: number;
   fromUserId: number;
@@ -142,6 +150,7 @@ export interface DecryptRecoveryRequestFrame {
 
 export interface DecryptRecoveryPayloadFrame {
   type: 'decrypt_recovery_payload';
+  recoveryId?: number;
   roomId: number;
   messageId: number;
   fromUserId: number;
@@ -152,6 +161,48 @@ export interface DecryptRecoveryPayloadFrame {
   payload: CipherPayload;
 }
 
+export interface HistoryBackfillProgress {
+  backfillId: number;
+  roomId: number;
+  status: 'open' | 'completed';
+  fromMessageId: number;
+  upToMessageId: number;
+  messageCount: number;
+  keysDelivered: number;
+  responses: number;
+  expiresAt: string;
+}
+
+export interface HistoryBackfillProgressFrame extends HistoryBackfillProgress {
+  type: 'history_backfill_progress';
+}
+
+export interface HistoryKeyRequestFrame {
+  type: 'history_key_request';
+  backfillId: number;
+  roomId: number;
+  fromUserId: number;
+  fromUsername: string;
+  fromDeviceId: string;
+  fromMessageId: number;
+  upToMessageId: number;
+  messageCount: number;
+}
+
+export interface HistoryKeyResponseFrame {
+  type: 'history_key_response';
+  backfillId: number;
+  roomId: number;
+  fromUserId: number;
+  fromUsername: string;
+  fromDeviceId: string;
+  toUserId: number;
+  toDeviceId: string;
+  messageIds: number[];
+  payload: CipherPayload;
+  progress: HistoryBackfillProgress;
+}
+
 export interface TypingStatusFrame {
   type: 'typing_status';
   roomId: number;
@@ -180,9 +231,18 @@ export interface MessageUpdateFrame {
   payload?: CipherPayload;
 }
 
+export interface RoomKeyEpochFrame {
+  type: 'room_key_epoch';
+  roomId: number;
+  keyEpoch: number;
+  keyEpochChangedAt: string;
+  graceSeconds: number;
+}
+
 export interface ProtocolErrorFrame {
   type: 'protocol_error';
   roomId: number;
-  code: 'legacy_payload_not_supported' | 'invalid_payload_format';
+  code: 'legacy_payload_not_supported' | 'invalid_payload_format' | 'stale_key_epoch' | 'payload_too_large';
   message: string;
+  details?: Record<string, unknown>;
 }